@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"gohomeworklesson01/logger"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 使用示例
+func main() {
+	fmt.Println("=== 并发安全日志系统demo ===")
+
+	// 创建日志系统（同时输出到文件和控制台）
+	appLog, err := logger.NewLogger("app.log", true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer appLog.Close()
+
+	// 内存输出，便于在测试中断言日志内容
+	memory := logger.NewMemorySink()
+	appLog.AddSink(memory)
+
+	var wg sync.WaitGroup
+
+	// 启动多个goroutine并发写日志
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				appLog.Info("Goroutine %d - 日志 %d", id, j)
+				time.Sleep(time.Millisecond * 10)
+			}
+		}(i)
+	}
+
+	// 模拟错误日志
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			appLog.Error("发生错误: 连接超时 %d", i)
+			time.Sleep(time.Millisecond * 50)
+		}
+	}()
+
+	// 模拟警告日志
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			appLog.Warn("警告: 服务器负载过高 %d", i)
+			time.Sleep(time.Millisecond * 30)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			appLog.Debug("调试信息: %d", i)
+			time.Sleep(time.Millisecond * 20)
+		}
+	}()
+
+	wg.Wait()
+
+	// 通过标准库 *log.Logger 接入第三方代码的日志
+	stdLog := appLog.StdLogger(logger.INFO)
+	stdLog.Println("来自标准库 log 的消息")
+	stdLog.Println("[WARN] http: 请求处理缓慢")
+	stdLog.Printf("ERROR: 第三方组件异常: %s", "connection reset")
+
+	// GORM 的 SQL 日志也写入同一个日志系统
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.NewGormLogger(appLog, 20*time.Millisecond),
+	})
+	if err != nil {
+		appLog.Error("打开数据库失败: %v", err)
+	} else {
+		type Note struct {
+			ID      uint
+			Content string
+		}
+		db.AutoMigrate(&Note{})
+		db.Create(&Note{Content: "hello gorm"})
+		var count int64
+		db.Raw("WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t WHERE n < 300000) SELECT count(*) FROM t").Scan(&count) // 模拟慢查询
+	}
+
+	appLog.Info("所有日志写入完成")
+
+	// 关闭后所有日志都已写入，再检查内存中的记录（defer 中的重复关闭是安全的）
+	if err := appLog.CloseWithTimeout(time.Second); err != nil {
+		fmt.Printf("关闭日志系统失败: %v\n", err)
+	}
+	slow, _ := memory.EntriesMatching("SLOW SQL")
+	fmt.Printf("内存中已记录 %d 条日志，包含 ERROR: %v，慢查询: %d 条\n",
+		memory.Len(), memory.ContainsLevel(logger.ERROR), len(slow))
+
+	// 固定时钟：日志时间戳可以在测试中断言
+	clocked, _ := logger.NewLogger("", false)
+	clockedMemory := logger.NewMemorySink()
+	clocked.AddSink(clockedMemory)
+	clocked.SetClock(logger.ClockFunc(func() time.Time { return time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local) }))
+	clocked.Info("定时任务开始")
+	clocked.Close()
+	fmt.Printf("固定时钟的日志时间: %s\n", clockedMemory.Entries()[0].Time.Format(time.DateTime))
+
+	// 敏感日志加密存储：密钥通常来自环境变量或 KMS，这里随机生成一个演示
+	key := make([]byte, 32)
+	rand.Read(key)
+	keys := logger.KeyProviderFunc(func() ([]byte, error) { return key, nil })
+
+	os.Remove("app.secure.log") // 每次演示使用新密钥，清理上次的文件
+	secure, err := logger.NewEncryptedLogger("app.secure.log", false, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	secure.Info("用户 %s 登录，手机号 %s", "zhangsan", "13800000000")
+	secure.Close()
+
+	plain, err := logger.ReadEncryptedLog("app.secure.log", keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print("解密后的日志:\n" + plain)
+}
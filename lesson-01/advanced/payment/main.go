@@ -0,0 +1,4671 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gohomeworklesson01/bank"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 自定义错误
+var (
+	ErrorInvalidAmount       = errors.New("金额必须大于0")     // 无效金额错误
+	ErrorTransactionNotFound = errors.New("交易不存在")       // 交易不存在错误
+	ErrorRefundExceeded      = errors.New("退款金额超过可退金额")  // 超额退款错误
+	ErrorNotRefundable       = errors.New("交易当前状态不允许退款") // 交易未成功时不能退款
+	ErrorPaymentPending      = errors.New("等待支付结果超时")    // 轮询期间交易一直处于处理中
+	ErrorInvalidSignature    = errors.New("签名校验失败")      // 请求或回调签名不正确
+	ErrorUnsupportedCurrency = errors.New("不支持的币种")      // 汇率表中没有该币种
+	ErrorPaymentNotFound     = errors.New("支付方式不存在")     // 未注册或未启用的支付方式
+	ErrorInvalidConfig       = errors.New("支付方式配置无效")    // 创建支付方式时缺少必要配置
+	ErrorPaymentFailed       = errors.New("交易未成功")       // 异步支付最终失败
+	ErrPaymentTimeout        = errors.New("支付超时")        // 支付方式未在超时时间内返回结果
+	ErrorSigningKeyNotFound  = errors.New("未配置签名密钥")     // 支付方式没有配置签名或验签密钥
+	ErrorCouponNotFound      = errors.New("优惠券不存在")
+	ErrorCouponExpired       = errors.New("优惠券已过期")
+	ErrorCouponUsed          = errors.New("优惠券已使用")     // 一次性优惠券不能重复使用
+	ErrorCouponNotApplicable = errors.New("优惠券不适用于该订单") // 未达到使用门槛或币种不符
+	ErrorQRSessionNotFound   = errors.New("二维码不存在")
+	ErrorQRSessionExpired    = errors.New("二维码已过期")
+)
+
+// PaymentErrorCode 支付失败原因分类
+type PaymentErrorCode int
+
+const (
+	ErrCodeInsufficientFunds   PaymentErrorCode = iota + 1 // 余额不足
+	ErrCodeDeclined                                        // 被渠道或发卡行拒绝
+	ErrCodeTimeout                                         // 支付方式未在超时时间内返回结果
+	ErrCodeProviderUnavailable                             // 渠道暂时不可用，如网关繁忙、系统异常
+	ErrCodeInvalidInstrument                               // 支付工具无效，如卡号错误、卡已过期、账户不存在
+)
+
+func (c PaymentErrorCode) String() string {
+	switch c {
+	case ErrCodeInsufficientFunds:
+		return "InsufficientFunds"
+	case ErrCodeDeclined:
+		return "Declined"
+	case ErrCodeTimeout:
+		return "Timeout"
+	case ErrCodeProviderUnavailable:
+		return "ProviderUnavailable"
+	case ErrCodeInvalidInstrument:
+		return "InvalidInstrument"
+	default:
+		return "Unknown"
+	}
+}
+
+// Retryable 该类错误是否值得稍后重试：超时和渠道不可用是暂时的，其余重试也不会成功
+func (c PaymentErrorCode) Retryable() bool {
+	return c == ErrCodeTimeout || c == ErrCodeProviderUnavailable
+}
+
+// PaymentError 支付渠道返回的失败，调用方可以用 errors.As 取出错误码决定是否重试
+type PaymentError struct {
+	Code       PaymentErrorCode
+	Provider   string // 支付方式名称
+	Retryable  bool   // 是否可以重试
+	RawMessage string // 渠道返回的原始错误码和信息，可以为空
+	Err        error  // 底层错误，可以为 nil
+}
+
+// newPaymentError 创建支付错误，是否可重试由错误码决定
+func newPaymentError(provider string, code PaymentErrorCode, raw string, err error) *PaymentError {
+	return &PaymentError{Code: code, Provider: provider, Retryable: code.Retryable(), RawMessage: raw, Err: err}
+}
+
+func (e *PaymentError) Error() string {
+	msg := e.Code.String()
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.RawMessage != "" {
+		msg += " (" + e.RawMessage + ")"
+	}
+	return msg
+}
+
+func (e *PaymentError) Unwrap() error {
+	return e.Err
+}
+
+// gatewayErrorCodes Stripe 风格的网关错误码与错误分类的对应关系，模拟支付也使用这套错误码
+var gatewayErrorCodes = map[string]PaymentErrorCode{
+	"insufficient_funds":   ErrCodeInsufficientFunds,
+	"card_declined":        ErrCodeDeclined,
+	"amount_too_small":     ErrCodeDeclined,
+	"expired_card":         ErrCodeInvalidInstrument,
+	"incorrect_number":     ErrCodeInvalidInstrument,
+	"invalid_number":       ErrCodeInvalidInstrument,
+	"rate_limit":           ErrCodeProviderUnavailable,
+	"api_connection_error": ErrCodeProviderUnavailable,
+	"mock_failure":         ErrCodeProviderUnavailable,
+}
+
+// classifyGatewayCode 按网关错误码分类，未知的错误码视为拒绝
+func classifyGatewayCode(code string) PaymentErrorCode {
+	if c, ok := gatewayErrorCodes[code]; ok {
+		return c
+	}
+	return ErrCodeDeclined
+}
+
+// PaymentStatus 支付状态
+type PaymentStatus int
+
+// 定义支付状态常量
+const (
+	PaymentPending   PaymentStatus = iota // 处理中，需要稍后查询结果
+	PaymentSucceeded                      // 支付成功
+	PaymentFailed                         // 支付失败
+	PaymentRefunded                       // 已全额退款
+)
+
+func (s PaymentStatus) String() string {
+	return []string{"Pending", "Succeeded", "Failed", "Refunded"}[s]
+}
+
+// Payment 支付接口
+type Payment interface {
+	// Pay 执行支付操作，返回支付结果和错误信息
+	// ctx 取消或超时后应尽快返回 ctx.Err()
+	Pay(ctx context.Context, amount float64) (*PaymentResult, error)
+	// Refund 对指定交易退款，支持多次部分退款，累计退款不能超过原支付金额
+	Refund(transactionID string, amount float64) (*RefundResult, error)
+	// QueryStatus 查询交易状态，异步支付返回 PaymentPending 后通过它获取最终结果
+	QueryStatus(transactionID string) (PaymentStatus, error)
+	// GetName 获取支付方式名称
+	GetName() string
+}
+
+// Currency 币种代码（ISO 4217）
+type Currency string
+
+// 常用币种
+const (
+	CNY Currency = "CNY"
+	USD Currency = "USD"
+	EUR Currency = "EUR"
+	HKD Currency = "HKD"
+	JPY Currency = "JPY"
+)
+
+// PaymentRequest 支付请求
+type PaymentRequest struct {
+	Amount   float64       // 支付金额
+	Currency Currency      // 支付币种，为空时视为人民币
+	Account  string        // 付款用户账户，用于查询支付历史
+	OrderID  string        // 订单号，可选，用于生成收据
+	Items    []ReceiptItem // 订单明细，可选，用于生成收据
+
+	CouponCode string  // 优惠券码，可选
+	Discount   float64 // 优惠金额，由 ProcessPayment 使用优惠券后填入，此时 Amount 为优惠后的金额
+}
+
+// Environment 运行环境：沙箱环境使用测试网关和测试凭证，不产生真实扣款
+type Environment string
+
+const (
+	EnvSandbox    Environment = "sandbox"
+	EnvProduction Environment = "production"
+)
+
+// ParseEnvironment 解析环境配置，只有明确配置为 production 时才是生产环境
+func ParseEnvironment(s string) Environment {
+	if strings.EqualFold(strings.TrimSpace(s), string(EnvProduction)) {
+		return EnvProduction
+	}
+	return EnvSandbox
+}
+
+// EnvironmentProvider 可选接口：声明支付方式连接的环境
+// 未实现该接口的支付方式跟随支付处理器的环境
+type EnvironmentProvider interface {
+	Environment() Environment
+}
+
+// sandboxEnv 将沙箱开关转换为环境
+func sandboxEnv(sandbox bool) Environment {
+	if sandbox {
+		return EnvSandbox
+	}
+	return EnvProduction
+}
+
+// InstrumentProvider 可选接口：返回脱敏后的支付工具（账户、卡号等），用于收据展示
+type InstrumentProvider interface {
+	MaskedInstrument() string
+}
+
+// SettlementCurrencyProvider 可选接口：声明支付方式的结算币种
+// 未实现该接口的支付方式按人民币结算
+type SettlementCurrencyProvider interface {
+	SettlementCurrency() Currency
+}
+
+// Converter 汇率换算接口
+type Converter interface {
+	// Convert 把 amount 从 from 币种换算为 to 币种
+	Convert(amount float64, from, to Currency) (float64, error)
+}
+
+// StaticRateConverter 使用固定汇率表的换算器，汇率为1单位外币可兑换的人民币
+type StaticRateConverter struct {
+	rates map[Currency]float64
+}
+
+// NewStaticRateConverter 创建固定汇率换算器，人民币汇率固定为1
+func NewStaticRateConverter(rates map[Currency]float64) *StaticRateConverter {
+	c := &StaticRateConverter{rates: map[Currency]float64{CNY: 1}}
+	for currency, rate := range rates {
+		c.rates[currency] = rate
+	}
+	return c
+}
+
+// Convert 先换算为人民币，再换算为目标币种，结果保留两位小数
+func (c *StaticRateConverter) Convert(amount float64, from, to Currency) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := c.rates[from]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrorUnsupportedCurrency, from)
+	}
+	toRate, ok := c.rates[to]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrorUnsupportedCurrency, to)
+	}
+	return math.Round(amount*fromRate/toRate*100) / 100, nil
+}
+
+// PaymentResult 支付结果
+type PaymentResult struct {
+	TransactionID    string        // 交易号，退款时使用
+	Provider         string        // 支付方式名称
+	Amount           float64       // 实际扣款金额（结算币种）
+	Currency         Currency      // 结算币种
+	OriginalAmount   float64       // 请求的原始金额
+	OriginalCurrency Currency      // 请求的原始币种
+	Status           PaymentStatus // 支付状态，PaymentPending 表示需要等待结果
+	Message          string        // 结果描述
+	Fee              float64       // 渠道手续费（结算币种）
+	NetAmount        float64       // 扣除手续费后的到账金额
+	Receipt          *Receipt      // 支付成功后生成的收据
+	Duplicate        bool          // 为 true 表示这是被拦截的重复支付，返回的是首笔交易的结果
+	Environment      Environment   // 交易发生的环境
+}
+
+// RefundResult 退款结果
+type RefundResult struct {
+	TransactionID string  // 原交易号
+	Amount        float64 // 本次退款金额
+	TotalRefunded float64 // 累计已退款金额
+	Remaining     float64 // 剩余可退金额
+	Message       string  // 结果描述
+}
+
+// Transaction 交易记录，用于退款时校验原支付
+type Transaction struct {
+	ID        string
+	Amount    float64       // 原支付金额
+	Refunded  float64       // 累计已退款金额
+	Status    PaymentStatus // 交易状态
+	CreatedAt time.Time
+}
+
+// transactionStore 交易记录存储，各支付方式内嵌使用
+type transactionStore struct {
+	mu      sync.Mutex
+	records map[string]*Transaction
+	seq     int
+}
+
+// record 记录一笔支付，返回生成的交易
+func (s *transactionStore) record(prefix string, amount float64, status PaymentStatus) Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == nil {
+		s.records = make(map[string]*Transaction)
+	}
+	s.seq++
+	now := time.Now()
+	tx := &Transaction{
+		ID:        fmt.Sprintf("%s%s%04d", prefix, now.Format("20060102150405"), s.seq),
+		Amount:    amount,
+		Status:    status,
+		CreatedAt: now,
+	}
+	s.records[tx.ID] = tx
+	return *tx
+}
+
+// resolve 更新处理中交易的最终状态
+func (s *transactionStore) resolve(transactionID string, status PaymentStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tx, exists := s.records[transactionID]; exists && tx.Status == PaymentPending {
+		tx.Status = status
+	}
+}
+
+// status 查询交易状态
+func (s *transactionStore) status(transactionID string) (PaymentStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, exists := s.records[transactionID]
+	if !exists {
+		return PaymentFailed, ErrorTransactionNotFound
+	}
+	return tx.Status, nil
+}
+
+// checkRefund 校验退款但不记录
+func (s *transactionStore) checkRefund(transactionID string, amount float64) error {
+	if amount <= 0 {
+		return ErrorInvalidAmount
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.refundable(transactionID, amount)
+	return err
+}
+
+// refundable 校验交易能否退款指定金额，调用方需持有锁
+func (s *transactionStore) refundable(transactionID string, amount float64) (*Transaction, error) {
+	tx, exists := s.records[transactionID]
+	if !exists {
+		return nil, ErrorTransactionNotFound
+	}
+	if tx.Status != PaymentSucceeded && tx.Status != PaymentRefunded {
+		return nil, fmt.Errorf("%w: 交易 %s 状态为 %s", ErrorNotRefundable, transactionID, tx.Status)
+	}
+
+	// 按分比较，避免浮点数误差
+	remaining := toCents(tx.Amount) - toCents(tx.Refunded)
+	if toCents(amount) > remaining {
+		return nil, fmt.Errorf("%w: 交易 %s 剩余可退 %.2f 元", ErrorRefundExceeded, transactionID, float64(remaining)/100)
+	}
+	return tx, nil
+}
+
+// refund 校验并记录一笔退款，返回更新后的交易
+func (s *transactionStore) refund(transactionID string, amount float64) (Transaction, error) {
+	if amount <= 0 {
+		return Transaction{}, ErrorInvalidAmount
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.refundable(transactionID, amount)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	tx.Refunded = float64(toCents(tx.Refunded)+toCents(amount)) / 100
+	if toCents(tx.Refunded) == toCents(tx.Amount) {
+		tx.Status = PaymentRefunded
+	}
+	return *tx, nil
+}
+
+// toCents 把元转换为分
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// newRefundResult 根据退款后的交易生成退款结果
+func newRefundResult(tx Transaction, amount float64, message string) *RefundResult {
+	return &RefundResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		TotalRefunded: tx.Refunded,
+		Remaining:     float64(toCents(tx.Amount)-toCents(tx.Refunded)) / 100,
+		Message:       message,
+	}
+}
+
+// Alipay 支付宝支付
+type Alipay struct {
+	account string
+	txs     transactionStore
+}
+
+// NewAlipay 创建支付宝支付实例
+func NewAlipay(account string) *Alipay {
+	return &Alipay{account: account}
+}
+
+// Pay 执行支付宝支付操作
+func (ali *Alipay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if err := sleepContext(ctx, 100*time.Millisecond); err != nil { // sleep 100毫秒 模拟支付处理
+		return nil, err
+	}
+	tx := ali.txs.record("ALI", amount, PaymentSucceeded)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message:       fmt.Sprintf("支付宝支付成功: 账户:%s, 金额:%.2f元, 交易号:%s", ali.account, amount, tx.ID),
+	}, nil
+}
+
+// Refund 执行支付宝退款操作
+func (ali *Alipay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	tx, err := ali.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount,
+		fmt.Sprintf("支付宝退款成功: 账户:%s, 交易号:%s, 退款:%.2f元", ali.account, tx.ID, amount)), nil
+}
+
+// QueryStatus 查询支付宝交易状态
+func (ali *Alipay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return ali.txs.status(transactionID)
+}
+
+// QRPayload 生成支付宝收款码内容
+func (ali *Alipay) QRPayload(sessionID string) string {
+	return "https://qr.alipay.com/" + sessionID
+}
+
+// MaskedInstrument 返回脱敏后的支付宝账户
+func (ali *Alipay) MaskedInstrument() string {
+	return maskMiddle(ali.account, 3, 12)
+}
+
+// GetName 获取支付方式名称
+func (ali *Alipay) GetName() string {
+	return "支付宝"
+}
+
+// WechatPay 微信支付
+type WechatPay struct {
+	openID string
+	txs    transactionStore
+}
+
+// NewWechatPay 创建微信支付实例
+func NewWechatPay(openID string) *WechatPay {
+	return &WechatPay{openID: openID}
+}
+
+// Pay 执行微信支付操作
+func (wechat *WechatPay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if err := sleepContext(ctx, 100*time.Millisecond); err != nil { // sleep 100毫秒 模拟支付处理
+		return nil, err
+	}
+	tx := wechat.txs.record("WX", amount, PaymentSucceeded)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message:       fmt.Sprintf("微信支付成功: OpenID:%s, 金额:%.2f元, 交易号:%s", wechat.openID, amount, tx.ID),
+	}, nil
+}
+
+// Refund 执行微信退款操作
+func (wechat *WechatPay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	tx, err := wechat.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount,
+		fmt.Sprintf("微信退款成功: OpenID:%s, 交易号:%s, 退款:%.2f元", wechat.openID, tx.ID, amount)), nil
+}
+
+// QueryStatus 查询微信交易状态
+func (wechat *WechatPay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return wechat.txs.status(transactionID)
+}
+
+// QRPayload 生成微信 Native 支付二维码内容
+func (wechat *WechatPay) QRPayload(sessionID string) string {
+	return "weixin://wxpay/bizpayurl?pr=" + sessionID
+}
+
+// MaskedInstrument 返回脱敏后的 OpenID
+func (wechat *WechatPay) MaskedInstrument() string {
+	return maskMiddle(wechat.openID, 4, 4)
+}
+
+// GetName 获取支付方式名称
+func (w *WechatPay) GetName() string {
+	return "微信支付"
+}
+
+// BankCard 银行卡支付
+// 银行卡支付需要等待银行清算，Pay 返回 PaymentPending，结果通过 QueryStatus 查询
+// 设置了回调分发器时，清算和退款结果还会以回调的形式主动通知
+type BankCardPay struct {
+	cardNumber  string
+	bankName    string
+	settleDelay time.Duration // 模拟银行清算耗时
+	txs         transactionStore
+	callbacks   *CallbackDispatcher // 异步结果通知，可以为 nil
+	callbackSeq map[string]int      // 每笔交易已发送的回调序号
+	callbackMu  sync.Mutex
+}
+
+// NewBankCard 创建银行卡支付实例
+func NewBankCard(cardNumber, bankName string) *BankCardPay {
+	return &BankCardPay{cardNumber: cardNumber, bankName: bankName, settleDelay: 300 * time.Millisecond}
+}
+
+// Pay 执行银行卡支付操作，提交后交易处于处理中，清算完成后变为成功
+func (bc *BankCardPay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if err := sleepContext(ctx, 100*time.Millisecond); err != nil {
+		return nil, err
+	}
+	tx := bc.txs.record("BANK", amount, PaymentPending)
+
+	// 模拟银行异步清算
+	time.AfterFunc(bc.settleDelay, func() {
+		bc.txs.resolve(tx.ID, PaymentSucceeded)
+		bc.notify(tx.ID, PaymentSucceeded, amount)
+	})
+
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message: fmt.Sprintf("银行卡支付成功: %s卡号:%s, 金额:%.2f元, 交易号:%s",
+			bc.bankName, bc.cardNumber, amount, tx.ID),
+	}, nil
+}
+
+// Refund 执行银行卡退款操作，退款原路退回到银行卡
+func (bc *BankCardPay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	tx, err := bc.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	bc.notify(tx.ID, tx.Status, amount)
+	return newRefundResult(tx, amount, fmt.Sprintf("银行卡退款成功: %s卡号:%s, 交易号:%s, 退款:%.2f元",
+		bc.bankName, bc.cardNumber, tx.ID, amount)), nil
+}
+
+// QueryStatus 查询银行卡交易状态
+func (bc *BankCardPay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return bc.txs.status(transactionID)
+}
+
+// SetCallbackDispatcher 设置异步结果的回调分发器
+func (bc *BankCardPay) SetCallbackDispatcher(d *CallbackDispatcher) {
+	bc.callbackMu.Lock()
+	defer bc.callbackMu.Unlock()
+	bc.callbacks = d
+}
+
+// notify 按交易顺序生成带签名的回调并投递
+func (bc *BankCardPay) notify(transactionID string, status PaymentStatus, amount float64) {
+	bc.callbackMu.Lock()
+	d := bc.callbacks
+	if d == nil {
+		bc.callbackMu.Unlock()
+		return
+	}
+	if bc.callbackSeq == nil {
+		bc.callbackSeq = make(map[string]int)
+	}
+	bc.callbackSeq[transactionID]++
+	seq := bc.callbackSeq[transactionID]
+	bc.callbackMu.Unlock()
+
+	cb := PaymentCallback{
+		EventID:       fmt.Sprintf("%s-%d", transactionID, seq),
+		TransactionID: transactionID,
+		Provider:      bc.GetName(),
+		Status:        status,
+		Amount:        amount,
+		Sequence:      seq,
+		Timestamp:     time.Now(),
+	}
+	if err := d.Sign(&cb); err != nil {
+		fmt.Printf("回调 %s 签名失败: %v\n", cb.EventID, err)
+		return
+	}
+	if err := d.Deliver(cb); err != nil {
+		fmt.Printf("回调 %s 投递失败: %v\n", cb.EventID, err)
+	}
+}
+
+// MaskedInstrument 返回脱敏后的银行卡号，只保留后四位
+func (bc *BankCardPay) MaskedInstrument() string {
+	return maskMiddle(bc.cardNumber, 0, 4)
+}
+
+// GetName 获取支付方式名称
+func (bc *BankCardPay) GetName() string {
+	return bc.bankName + "银行卡"
+}
+
+// BankLedger 银行账本，*bank.Bank 实现了该接口
+type BankLedger interface {
+	Deposit(accountNumber string, amount float64) error
+	Withdraw(accountNumber string, amount float64) error
+	GetBalance(accountNumber string) (float64, error)
+}
+
+// BalancePay 账户余额支付：支付从银行账户扣款，退款原路存回
+type BalancePay struct {
+	ledger        BankLedger
+	accountNumber string
+	mu            sync.Mutex // 保证扣款/存回与交易记录的更新作为一个整体执行
+	txs           transactionStore
+}
+
+// NewBalancePay 创建余额支付实例
+func NewBalancePay(ledger BankLedger, accountNumber string) *BalancePay {
+	return &BalancePay{ledger: ledger, accountNumber: accountNumber}
+}
+
+// Pay 从银行账户扣款，余额不足或账户冻结时失败
+func (b *BalancePay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.ledger.Withdraw(b.accountNumber, amount); err != nil {
+		code := ErrCodeDeclined
+		switch {
+		case errors.Is(err, bank.ErrorInsufficientBalance):
+			code = ErrCodeInsufficientFunds
+		case errors.Is(err, bank.ErrorAccountNotFound):
+			code = ErrCodeInvalidInstrument
+		}
+		return nil, newPaymentError(b.GetName(), code, "", fmt.Errorf("账户 %s 扣款失败: %w", b.accountNumber, err))
+	}
+	tx := b.txs.record("BAL", amount, PaymentSucceeded)
+	balance, _ := b.ledger.GetBalance(b.accountNumber)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message: fmt.Sprintf("余额支付成功: 账户:%s, 金额:%.2f元, 余额:%.2f元, 交易号:%s",
+			b.accountNumber, amount, balance, tx.ID),
+	}, nil
+}
+
+// Refund 退款存回银行账户，存款失败时不记录退款
+func (b *BalancePay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.txs.checkRefund(transactionID, amount); err != nil {
+		return nil, err
+	}
+	if err := b.ledger.Deposit(b.accountNumber, amount); err != nil {
+		return nil, fmt.Errorf("账户 %s 存回失败: %w", b.accountNumber, err)
+	}
+	tx, err := b.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	balance, _ := b.ledger.GetBalance(b.accountNumber)
+	return newRefundResult(tx, amount, fmt.Sprintf("余额退款成功: 账户:%s, 交易号:%s, 退款:%.2f元, 余额:%.2f元",
+		b.accountNumber, tx.ID, amount, balance)), nil
+}
+
+// QueryStatus 查询余额支付交易状态
+func (b *BalancePay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return b.txs.status(transactionID)
+}
+
+// MaskedInstrument 返回脱敏后的账户号码
+func (b *BalancePay) MaskedInstrument() string {
+	return maskMiddle(b.accountNumber, 0, 4)
+}
+
+// GetName 获取支付方式名称
+func (b *BalancePay) GetName() string {
+	return "账户余额"
+}
+
+// MockError 模拟支付返回的错误，带有网关错误码
+// Pay 返回的错误为 *PaymentError，按 Code 分类后包装 MockError
+type MockError struct {
+	Code string
+	Call int // 第几次调用
+}
+
+func (e *MockError) Error() string {
+	return fmt.Sprintf("模拟支付返回错误 %s (第%d次调用)", e.Code, e.Call)
+}
+
+// MockPayConfig 模拟支付的故障注入配置，零值表示总是立即成功
+type MockPayConfig struct {
+	FailEvery    int            // 每 N 次调用失败一次，0 表示不按次数失败
+	FailCode     string         // 按次数失败时的错误码，默认 mock_failure
+	ErrorCodes   map[int]string // 指定第几次调用（从1开始）返回的错误码
+	MinLatency   time.Duration  // 每次调用的最小延迟
+	MaxLatency   time.Duration  // 每次调用的最大延迟，大于 MinLatency 时在两者之间随机
+	Seed         int64          // 随机延迟的种子，相同种子得到相同的延迟序列
+	PendingPolls int            // 大于0时 Pay 返回处理中，QueryStatus 查询该次数后变为成功
+}
+
+// MockPay 可配置故障的模拟支付，用于确定性地验证超时、重试、轮询等处理逻辑
+type MockPay struct {
+	config MockPayConfig
+	txs    transactionStore
+
+	mu    sync.Mutex
+	calls int
+	rng   *rand.Rand
+	polls map[string]int // 处理中交易已被查询的次数
+}
+
+// NewMockPay 创建模拟支付实例
+func NewMockPay(config MockPayConfig) *MockPay {
+	if config.FailCode == "" {
+		config.FailCode = "mock_failure"
+	}
+	return &MockPay{
+		config: config,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+		polls:  make(map[string]int),
+	}
+}
+
+// Calls 获取 Pay 被调用的次数
+func (m *MockPay) Calls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+// next 记录一次调用，返回本次调用序号、延迟和应返回的错误
+func (m *MockPay) next() (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	call := m.calls
+
+	latency := m.config.MinLatency
+	if span := m.config.MaxLatency - m.config.MinLatency; span > 0 {
+		latency += time.Duration(m.rng.Int63n(int64(span)))
+	}
+
+	if code, ok := m.config.ErrorCodes[call]; ok {
+		return call, latency, &MockError{Code: code, Call: call}
+	}
+	if m.config.FailEvery > 0 && call%m.config.FailEvery == 0 {
+		return call, latency, &MockError{Code: m.config.FailCode, Call: call}
+	}
+	return call, latency, nil
+}
+
+// Pay 按配置模拟延迟、失败或异步支付
+func (m *MockPay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	call, latency, err := m.next()
+	if err := sleepContext(ctx, latency); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, newPaymentError(m.GetName(), classifyGatewayCode(err.(*MockError).Code), "", err)
+	}
+
+	status := PaymentSucceeded
+	if m.config.PendingPolls > 0 {
+		status = PaymentPending
+	}
+	tx := m.txs.record("MOCK", amount, status)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message:       fmt.Sprintf("模拟支付成功: 第%d次调用, 金额:%.2f元, 交易号:%s", call, amount, tx.ID),
+	}, nil
+}
+
+// Refund 执行模拟退款
+func (m *MockPay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	tx, err := m.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount, fmt.Sprintf("模拟退款成功: 交易号:%s, 退款:%.2f元", tx.ID, amount)), nil
+}
+
+// QueryStatus 查询模拟交易状态，处理中的交易被查询 PendingPolls 次后变为成功
+func (m *MockPay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	status, err := m.txs.status(transactionID)
+	if err != nil || status != PaymentPending {
+		return status, err
+	}
+
+	m.mu.Lock()
+	m.polls[transactionID]++
+	polls := m.polls[transactionID]
+	m.mu.Unlock()
+
+	if polls >= m.config.PendingPolls {
+		m.txs.resolve(transactionID, PaymentSucceeded)
+		return m.txs.status(transactionID)
+	}
+	return status, nil
+}
+
+// Environment 模拟支付只能用于沙箱环境
+func (m *MockPay) Environment() Environment {
+	return EnvSandbox
+}
+
+// GetName 获取支付方式名称
+func (m *MockPay) GetName() string {
+	return "模拟支付"
+}
+
+// maskMiddle 保留开头 keepStart 个和结尾 keepEnd 个字符，中间用 * 替换
+func maskMiddle(s string, keepStart, keepEnd int) string {
+	runes := []rune(s)
+	if len(runes) <= keepStart+keepEnd {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:keepStart]) + strings.Repeat("*", len(runes)-keepStart-keepEnd) + string(runes[len(runes)-keepEnd:])
+}
+
+// sleepContext 模拟耗时操作，ctx 被取消时提前返回 ctx.Err()
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// SignatureError 签名或验签失败，可以用 errors.Is(err, ErrorInvalidSignature) 判断
+type SignatureError struct {
+	Provider  string // 支付方式名称
+	Algorithm string // 签名算法，如 HMAC-SHA256
+	Reason    string // 失败原因
+	Err       error  // 底层错误，可以为 nil
+}
+
+func (e *SignatureError) Error() string {
+	msg := fmt.Sprintf("%v: %s [%s] %s", ErrorInvalidSignature, e.Provider, e.Algorithm, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap 使 errors.Is(err, ErrorInvalidSignature) 成立
+func (e *SignatureError) Unwrap() error {
+	return ErrorInvalidSignature
+}
+
+// Signer 报文签名器，对请求报文签名，或校验对方报文的签名
+type Signer interface {
+	Algorithm() string
+	Sign(payload []byte) (string, error)
+	Verify(payload []byte, signature string) error
+}
+
+// HMACSigner 使用双方共享密钥的 HMAC-SHA256 签名，签名为十六进制字符串
+type HMACSigner struct {
+	secret []byte
+}
+
+// NewHMACSigner 创建 HMAC-SHA256 签名器
+func NewHMACSigner(secret string) *HMACSigner {
+	return &HMACSigner{secret: []byte(secret)}
+}
+
+func (s *HMACSigner) Algorithm() string { return "HMAC-SHA256" }
+
+// Sign 计算签名
+func (s *HMACSigner) Sign(payload []byte) (string, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Verify 校验签名
+func (s *HMACSigner) Verify(payload []byte, signature string) error {
+	expected, _ := s.Sign(payload)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("签名不匹配")
+	}
+	return nil
+}
+
+// RSASigner 使用 RSA PKCS#1 v1.5 + SHA256 签名，签名为 base64 字符串
+// 只有公钥时只能验签，用于校验支付渠道的回调
+type RSASigner struct {
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+// NewRSASigner 使用私钥创建签名器，同时可以用对应的公钥验签
+func NewRSASigner(key *rsa.PrivateKey) *RSASigner {
+	return &RSASigner{private: key, public: &key.PublicKey}
+}
+
+// NewRSAVerifier 使用公钥创建只能验签的签名器
+func NewRSAVerifier(key *rsa.PublicKey) *RSASigner {
+	return &RSASigner{public: key}
+}
+
+func (s *RSASigner) Algorithm() string { return "RSA-SHA256" }
+
+// Sign 计算签名，没有私钥时返回 ErrorSigningKeyNotFound
+func (s *RSASigner) Sign(payload []byte) (string, error) {
+	if s.private == nil {
+		return "", fmt.Errorf("%w: 缺少 RSA 私钥", ErrorSigningKeyNotFound)
+	}
+	digest := sha256.Sum256(payload)
+	sig, err := rsa.SignPKCS1v15(crand.Reader, s.private, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// Verify 校验签名
+func (s *RSASigner) Verify(payload []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("签名格式错误: %w", err)
+	}
+	digest := sha256.Sum256(payload)
+	return rsa.VerifyPKCS1v15(s.public, crypto.SHA256, digest[:], sig)
+}
+
+// signPayload 签名报文，失败时返回 SignatureError
+func signPayload(provider string, signer Signer, payload []byte) (string, error) {
+	sig, err := signer.Sign(payload)
+	if err != nil {
+		return "", &SignatureError{Provider: provider, Algorithm: signer.Algorithm(), Reason: "签名失败", Err: err}
+	}
+	return sig, nil
+}
+
+// verifyPayload 校验报文签名，失败时返回 SignatureError
+func verifyPayload(provider string, signer Signer, payload []byte, signature string) error {
+	if signature == "" {
+		return &SignatureError{Provider: provider, Algorithm: signer.Algorithm(), Reason: "缺少签名"}
+	}
+	if err := signer.Verify(payload, signature); err != nil {
+		return &SignatureError{Provider: provider, Algorithm: signer.Algorithm(), Reason: "签名校验未通过", Err: err}
+	}
+	return nil
+}
+
+// KeyRing 按支付方式名称管理签名密钥
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys map[string]Signer
+}
+
+// NewKeyRing 创建密钥管理器
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]Signer)}
+}
+
+// Set 设置支付方式的签名器，signer 为 nil 时删除
+func (k *KeyRing) Set(provider string, signer Signer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if signer == nil {
+		delete(k.keys, provider)
+		return
+	}
+	k.keys[provider] = signer
+}
+
+// Get 获取支付方式的签名器
+func (k *KeyRing) Get(provider string) (Signer, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	signer, ok := k.keys[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorSigningKeyNotFound, provider)
+	}
+	return signer, nil
+}
+
+// Sign 使用支付方式的密钥签名
+func (k *KeyRing) Sign(provider string, payload []byte) (string, error) {
+	signer, err := k.Get(provider)
+	if err != nil {
+		return "", err
+	}
+	return signPayload(provider, signer, payload)
+}
+
+// Verify 使用支付方式的密钥验签
+func (k *KeyRing) Verify(provider string, payload []byte, signature string) error {
+	signer, err := k.Get(provider)
+	if err != nil {
+		return &SignatureError{Provider: provider, Reason: "未配置验签密钥", Err: err}
+	}
+	return verifyPayload(provider, signer, payload, signature)
+}
+
+// gatewayRoundTrip 模拟与支付网关的一次 HTTP 往返
+// 请求和响应都经过 JSON 编解码，server 模拟网关对请求报文的处理
+// signer 不为 nil 时对请求报文签名，网关先验签再处理
+func gatewayRoundTrip(ctx context.Context, provider string, signer Signer, req interface{}, resp interface{}, server func(body []byte) ([]byte, error)) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		signature, err := signPayload(provider, signer, body)
+		if err != nil {
+			return err
+		}
+		if err := verifyPayload(provider, signer, body, signature); err != nil { // 网关验签
+			return err
+		}
+	}
+	if err := sleepContext(ctx, 100*time.Millisecond); err != nil { // 模拟网络耗时
+		return err
+	}
+	respBody, err := server(body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respBody, resp)
+}
+
+// gatewayHost 根据是否沙箱环境选择网关地址
+func gatewayHost(sandbox bool, production, sandboxHost string) string {
+	if sandbox {
+		return sandboxHost
+	}
+	return production
+}
+
+// UnionPayConfig 银联支付配置
+type UnionPayConfig struct {
+	MerchantID string // 商户号
+	TerminalID string // 终端号
+	Sandbox    bool   // 是否使用沙箱环境
+}
+
+// UnionPay 银联支付
+type UnionPay struct {
+	config   UnionPayConfig
+	signer   Signer     // 请求报文签名，为 nil 时不签名
+	refundMu sync.Mutex // 保证退款的校验、网关调用与记录作为一个整体执行
+	txs      transactionStore
+}
+
+// unionPayRequest 银联交易请求报文
+type unionPayRequest struct {
+	MerID   string `json:"merId"`
+	TermID  string `json:"termId"`
+	TxnType string `json:"txnType"` // 01 消费，04 退货
+	TxnAmt  int64  `json:"txnAmt"`  // 金额，单位分
+	OrigQry string `json:"origQryId,omitempty"`
+}
+
+// unionPayResponse 银联交易应答报文
+type unionPayResponse struct {
+	RespCode string `json:"respCode"` // 00 表示成功
+	RespMsg  string `json:"respMsg"`
+}
+
+// NewUnionPay 创建银联支付实例
+func NewUnionPay(config UnionPayConfig) (*UnionPay, error) {
+	if config.MerchantID == "" {
+		return nil, fmt.Errorf("%w: 银联商户号不能为空", ErrorInvalidConfig)
+	}
+	return &UnionPay{config: config}, nil
+}
+
+// call 发送银联交易报文
+func (u *UnionPay) call(ctx context.Context, req unionPayRequest) error {
+	var resp unionPayResponse
+	err := gatewayRoundTrip(ctx, u.GetName(), u.signer, req, &resp, func(body []byte) ([]byte, error) {
+		var in unionPayRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		if in.TxnAmt <= 0 {
+			return json.Marshal(unionPayResponse{RespCode: "30", RespMsg: "交易金额无效"})
+		}
+		return json.Marshal(unionPayResponse{RespCode: "00", RespMsg: "成功[0000000]"})
+	})
+	if err != nil {
+		return err
+	}
+	if resp.RespCode != "00" {
+		return newPaymentError(u.GetName(), classifyUnionPayCode(resp.RespCode), resp.RespCode+" "+resp.RespMsg, nil)
+	}
+	return nil
+}
+
+// classifyUnionPayCode 按银联应答码分类
+func classifyUnionPayCode(code string) PaymentErrorCode {
+	switch code {
+	case "51": // 余额不足
+		return ErrCodeInsufficientFunds
+	case "14", "33", "54": // 无效卡号、过期卡
+		return ErrCodeInvalidInstrument
+	case "91", "96", "98": // 发卡行或银联系统繁忙、超时
+		return ErrCodeProviderUnavailable
+	default:
+		return ErrCodeDeclined
+	}
+}
+
+// Pay 执行银联支付操作
+func (u *UnionPay) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	req := unionPayRequest{MerID: u.config.MerchantID, TermID: u.config.TerminalID, TxnType: "01", TxnAmt: toCents(amount)}
+	if err := u.call(ctx, req); err != nil {
+		return nil, err
+	}
+	tx := u.txs.record("UP", amount, PaymentSucceeded)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message: fmt.Sprintf("银联支付成功: 商户号:%s, 金额:%.2f元, 交易号:%s, 网关:%s",
+			u.config.MerchantID, amount, tx.ID, gatewayHost(u.config.Sandbox, "gateway.95516.com", "gateway.test.95516.com")),
+	}, nil
+}
+
+// Refund 执行银联退货操作
+func (u *UnionPay) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	u.refundMu.Lock()
+	defer u.refundMu.Unlock()
+
+	// 先在本地校验，超额退款或未成功的交易不发送到网关
+	if err := u.txs.checkRefund(transactionID, amount); err != nil {
+		return nil, err
+	}
+	req := unionPayRequest{MerID: u.config.MerchantID, TermID: u.config.TerminalID, TxnType: "04", TxnAmt: toCents(amount), OrigQry: transactionID}
+	if err := u.call(context.Background(), req); err != nil {
+		return nil, err
+	}
+	tx, err := u.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount,
+		fmt.Sprintf("银联退款成功: 商户号:%s, 交易号:%s, 退款:%.2f元", u.config.MerchantID, tx.ID, amount)), nil
+}
+
+// QueryStatus 查询银联交易状态
+func (u *UnionPay) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return u.txs.status(transactionID)
+}
+
+// Environment 银联连接的环境
+func (u *UnionPay) Environment() Environment {
+	return sandboxEnv(u.config.Sandbox)
+}
+
+// SetRequestSigner 设置请求报文签名器
+func (u *UnionPay) SetRequestSigner(signer Signer) {
+	u.signer = signer
+}
+
+// GetName 获取支付方式名称
+func (u *UnionPay) GetName() string {
+	return "银联"
+}
+
+// PayPalConfig PayPal 支付配置
+type PayPalConfig struct {
+	ClientID     string // REST API 应用的 Client ID
+	ClientSecret string // REST API 应用的 Secret
+	Sandbox      bool   // 是否使用沙箱环境
+}
+
+// PayPal PayPal 支付，以美元结算
+type PayPal struct {
+	config   PayPalConfig
+	signer   Signer     // 请求报文签名，为 nil 时不签名
+	refundMu sync.Mutex // 保证退款的校验、网关调用与记录作为一个整体执行
+	txs      transactionStore
+}
+
+// payPalAmount PayPal 金额对象，金额使用字符串表示
+type payPalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// payPalRequest PayPal 订单/退款请求
+type payPalRequest struct {
+	Intent string       `json:"intent,omitempty"` // CAPTURE
+	Amount payPalAmount `json:"amount"`
+}
+
+// payPalResponse PayPal 响应
+type payPalResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // COMPLETED 表示成功
+}
+
+// NewPayPal 创建 PayPal 支付实例
+func NewPayPal(config PayPalConfig) (*PayPal, error) {
+	if config.ClientID == "" || config.ClientSecret == "" {
+		return nil, fmt.Errorf("%w: PayPal 需要 ClientID 和 ClientSecret", ErrorInvalidConfig)
+	}
+	return &PayPal{config: config}, nil
+}
+
+// call 调用 PayPal REST API
+func (pp *PayPal) call(ctx context.Context, req payPalRequest) error {
+	var resp payPalResponse
+	err := gatewayRoundTrip(ctx, pp.GetName(), pp.signer, req, &resp, func(body []byte) ([]byte, error) {
+		var in payPalRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		if in.Amount.CurrencyCode != string(USD) {
+			return json.Marshal(payPalResponse{Status: "CURRENCY_NOT_SUPPORTED"})
+		}
+		return json.Marshal(payPalResponse{ID: fmt.Sprintf("PAYID-%d", time.Now().UnixNano()), Status: "COMPLETED"})
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != "COMPLETED" {
+		code := ErrCodeDeclined
+		if resp.Status == "SERVICE_UNAVAILABLE" {
+			code = ErrCodeProviderUnavailable
+		}
+		return newPaymentError(pp.GetName(), code, "status "+resp.Status, nil)
+	}
+	return nil
+}
+
+// Pay 执行 PayPal 支付操作，amount 为美元
+func (pp *PayPal) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	req := payPalRequest{Intent: "CAPTURE", Amount: payPalAmount{CurrencyCode: string(USD), Value: fmt.Sprintf("%.2f", amount)}}
+	if err := pp.call(ctx, req); err != nil {
+		return nil, err
+	}
+	tx := pp.txs.record("PP", amount, PaymentSucceeded)
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message: fmt.Sprintf("PayPal支付成功: 金额:%.2f USD, 交易号:%s, 网关:%s",
+			amount, tx.ID, gatewayHost(pp.config.Sandbox, "api-m.paypal.com", "api-m.sandbox.paypal.com")),
+	}, nil
+}
+
+// Refund 执行 PayPal 退款操作
+func (pp *PayPal) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	pp.refundMu.Lock()
+	defer pp.refundMu.Unlock()
+
+	// 先在本地校验，超额退款或未成功的交易不发送到网关
+	if err := pp.txs.checkRefund(transactionID, amount); err != nil {
+		return nil, err
+	}
+	req := payPalRequest{Amount: payPalAmount{CurrencyCode: string(USD), Value: fmt.Sprintf("%.2f", amount)}}
+	if err := pp.call(context.Background(), req); err != nil {
+		return nil, err
+	}
+	tx, err := pp.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount, fmt.Sprintf("PayPal退款成功: 交易号:%s, 退款:%.2f USD", tx.ID, amount)), nil
+}
+
+// QueryStatus 查询 PayPal 交易状态
+func (pp *PayPal) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return pp.txs.status(transactionID)
+}
+
+// SettlementCurrency PayPal 以美元结算
+func (pp *PayPal) SettlementCurrency() Currency {
+	return USD
+}
+
+// Environment PayPal 连接的环境
+func (pp *PayPal) Environment() Environment {
+	return sandboxEnv(pp.config.Sandbox)
+}
+
+// SetRequestSigner 设置请求报文签名器
+func (pp *PayPal) SetRequestSigner(signer Signer) {
+	pp.signer = signer
+}
+
+// GetName 获取支付方式名称
+func (pp *PayPal) GetName() string {
+	return "PayPal"
+}
+
+// StripeConfig Stripe 支付配置
+type StripeConfig struct {
+	APIKey  string // 密钥，sk_live_ 或 sk_test_ 开头
+	Sandbox bool   // 是否使用测试模式，sk_test_ 开头的密钥自动视为测试模式
+}
+
+// Stripe Stripe 支付，以美元结算
+type Stripe struct {
+	config   StripeConfig
+	signer   Signer     // 请求报文签名，为 nil 时不签名
+	refundMu sync.Mutex // 保证退款的校验、网关调用与记录作为一个整体执行
+	txs      transactionStore
+}
+
+// stripeRequest Stripe PaymentIntent/Refund 请求，金额以最小货币单位表示
+type stripeRequest struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Confirm  bool   `json:"confirm,omitempty"`
+}
+
+// stripeResponse Stripe 响应
+type stripeResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // succeeded 表示成功
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// NewStripe 创建 Stripe 支付实例
+func NewStripe(config StripeConfig) (*Stripe, error) {
+	if !strings.HasPrefix(config.APIKey, "sk_") {
+		return nil, fmt.Errorf("%w: Stripe 密钥必须以 sk_ 开头", ErrorInvalidConfig)
+	}
+	if strings.HasPrefix(config.APIKey, "sk_test_") {
+		config.Sandbox = true
+	}
+	return &Stripe{config: config}, nil
+}
+
+// call 调用 Stripe API
+func (st *Stripe) call(ctx context.Context, req stripeRequest) error {
+	var resp stripeResponse
+	err := gatewayRoundTrip(ctx, st.GetName(), st.signer, req, &resp, func(body []byte) ([]byte, error) {
+		var in stripeRequest
+		if err := json.Unmarshal(body, &in); err != nil {
+			return nil, err
+		}
+		if in.Amount < 50 { // Stripe 要求最低 0.50 美元
+			return []byte(`{"error":{"code":"amount_too_small","message":"Amount must be at least $0.50 usd"}}`), nil
+		}
+		return json.Marshal(stripeResponse{ID: fmt.Sprintf("pi_%d", time.Now().UnixNano()), Status: "succeeded"})
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return newPaymentError(st.GetName(), classifyGatewayCode(resp.Error.Code), resp.Error.Code+": "+resp.Error.Message, nil)
+	}
+	return nil
+}
+
+// Pay 执行 Stripe 支付操作，amount 为美元
+func (st *Stripe) Pay(ctx context.Context, amount float64) (*PaymentResult, error) {
+	if amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if err := st.call(ctx, stripeRequest{Amount: toCents(amount), Currency: "usd", Confirm: true}); err != nil {
+		return nil, err
+	}
+	tx := st.txs.record("ST", amount, PaymentSucceeded)
+	mode := "live"
+	if st.config.Sandbox {
+		mode = "test"
+	}
+	return &PaymentResult{
+		TransactionID: tx.ID,
+		Amount:        amount,
+		Status:        tx.Status,
+		Message:       fmt.Sprintf("Stripe支付成功: 金额:%.2f USD, 交易号:%s, 模式:%s", amount, tx.ID, mode),
+	}, nil
+}
+
+// Refund 执行 Stripe 退款操作
+func (st *Stripe) Refund(transactionID string, amount float64) (*RefundResult, error) {
+	st.refundMu.Lock()
+	defer st.refundMu.Unlock()
+
+	// 先在本地校验，超额退款或未成功的交易不发送到网关
+	if err := st.txs.checkRefund(transactionID, amount); err != nil {
+		return nil, err
+	}
+	if err := st.call(context.Background(), stripeRequest{Amount: toCents(amount), Currency: "usd"}); err != nil {
+		return nil, err
+	}
+	tx, err := st.txs.refund(transactionID, amount)
+	if err != nil {
+		return nil, err
+	}
+	return newRefundResult(tx, amount, fmt.Sprintf("Stripe退款成功: 交易号:%s, 退款:%.2f USD", tx.ID, amount)), nil
+}
+
+// QueryStatus 查询 Stripe 交易状态
+func (st *Stripe) QueryStatus(transactionID string) (PaymentStatus, error) {
+	return st.txs.status(transactionID)
+}
+
+// SettlementCurrency Stripe 以美元结算
+func (st *Stripe) SettlementCurrency() Currency {
+	return USD
+}
+
+// Environment Stripe 连接的环境，测试密钥总是沙箱环境
+func (st *Stripe) Environment() Environment {
+	return sandboxEnv(st.config.Sandbox)
+}
+
+// SetRequestSigner 设置请求报文签名器
+func (st *Stripe) SetRequestSigner(signer Signer) {
+	st.signer = signer
+}
+
+// GetName 获取支付方式名称
+func (st *Stripe) GetName() string {
+	return "Stripe"
+}
+
+// PaymentCallback 支付结果回调（Webhook）
+type PaymentCallback struct {
+	EventID       string        `json:"event_id"`       // 回调唯一标识，用于去重
+	TransactionID string        `json:"transaction_id"` // 交易号
+	Provider      string        `json:"provider"`       // 支付方式名称
+	Status        PaymentStatus `json:"status"`         // 交易状态
+	Amount        float64       `json:"amount"`         // 本次回调涉及的金额
+	Sequence      int           `json:"sequence"`       // 同一交易内的回调序号，从1开始，0 表示不需要排序
+	Timestamp     time.Time     `json:"timestamp"`      // 回调产生时间
+	Signature     string        `json:"signature"`      // 签名，算法由支付方式的密钥决定，默认 HMAC-SHA256
+}
+
+// signingPayload 参与签名的内容
+func (cb PaymentCallback) signingPayload() string {
+	return fmt.Sprintf("%s|%s|%s|%d|%.2f|%d",
+		cb.EventID, cb.TransactionID, cb.Provider, cb.Status, cb.Amount, cb.Sequence)
+}
+
+// CallbackHandler 回调处理器
+type CallbackHandler interface {
+	HandleCallback(cb PaymentCallback) error
+}
+
+// CallbackHandlerFunc 把普通函数适配为进程内的回调处理器
+type CallbackHandlerFunc func(cb PaymentCallback) error
+
+// HandleCallback 实现 CallbackHandler 接口
+func (f CallbackHandlerFunc) HandleCallback(cb PaymentCallback) error {
+	return f(cb)
+}
+
+// HTTPCallbackHandler 把回调以 JSON 的形式 POST 到商户的 HTTP 地址
+type HTTPCallbackHandler struct {
+	URL    string
+	Client *http.Client
+}
+
+// HandleCallback 实现 CallbackHandler 接口
+func (h *HTTPCallbackHandler) HandleCallback(cb PaymentCallback) error {
+	body, err := json.Marshal(cb)
+	if err != nil {
+		return err
+	}
+
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("回调地址 %s 返回状态码 %d", h.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// 回调去重与排序的限制
+const (
+	callbackGapTimeout = 30 * time.Second // 缺少前序回调时最多等待的时间，超时后跳过缺失的序号
+	callbackSeenTTL    = 24 * time.Hour   // 已投递的 EventID 保留的时间，期间重复的回调被忽略
+	callbackSeenMax    = 100000           // 最多保留的已投递 EventID 数，超出时淘汰最早的
+	callbackPendingMax = 100              // 每笔交易最多缓存的乱序回调数
+)
+
+// CallbackDispatcher 回调分发器
+// 负责校验签名、按 EventID 去重，并保证同一交易的回调按 Sequence 顺序交给处理器
+// 处理失败的回调会在渠道重试时再次投递，处理器需要能处理重复的回调
+type CallbackDispatcher struct {
+	secret   *HMACSigner // 没有为支付方式单独配置密钥时使用的默认密钥
+	keys     *KeyRing    // 按支付方式配置的验签密钥，可以为 nil
+	handlers []CallbackHandler
+	mu       sync.Mutex
+	seen     map[string]bool            // 已成功投递的 EventID
+	seenLog  []seenEvent                // seen 中的 EventID 按投递时间排列，用于淘汰
+	inflight map[string]bool            // 正在投递的无序号回调
+	streams  map[string]*callbackStream // 每笔交易的投递状态
+	swept    time.Time                  // 上次清理空闲交易的时间
+}
+
+// seenEvent 已投递的回调及投递时间
+type seenEvent struct {
+	id string
+	at time.Time
+}
+
+// callbackStream 一笔交易的回调投递状态
+type callbackStream struct {
+	next       int                     // 下一个期望的序号
+	pending    map[int]PaymentCallback // 已到达、尚未投递成功的回调
+	delivering bool                    // 是否有 goroutine 正在投递该交易的回调
+	gapTimer   *time.Timer             // 缓存中有回调时启动，超时后跳过缺失的序号并重新投递
+	updated    time.Time               // 最后一次收到或投递回调的时间
+}
+
+// NewCallbackDispatcher 创建回调分发器，secret 为与支付渠道约定的签名密钥
+func NewCallbackDispatcher(secret string) *CallbackDispatcher {
+	return &CallbackDispatcher{
+		secret:   NewHMACSigner(secret),
+		seen:     make(map[string]bool),
+		inflight: make(map[string]bool),
+		streams:  make(map[string]*callbackStream),
+	}
+}
+
+// Register 注册回调处理器
+func (d *CallbackDispatcher) Register(handler CallbackHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers = append(d.handlers, handler)
+}
+
+// SetKeyRing 设置按支付方式管理的密钥，回调的 Provider 在其中有密钥时使用该密钥签名和验签
+func (d *CallbackDispatcher) SetKeyRing(keys *KeyRing) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys = keys
+}
+
+// signerFor 获取回调所用的签名器
+func (d *CallbackDispatcher) signerFor(provider string) Signer {
+	d.mu.Lock()
+	keys := d.keys
+	d.mu.Unlock()
+	if keys != nil {
+		if signer, err := keys.Get(provider); err == nil {
+			return signer
+		}
+	}
+	return d.secret
+}
+
+// Sign 计算并设置回调签名
+func (d *CallbackDispatcher) Sign(cb *PaymentCallback) error {
+	signature, err := signPayload(cb.Provider, d.signerFor(cb.Provider), []byte(cb.signingPayload()))
+	if err != nil {
+		return err
+	}
+	cb.Signature = signature
+	return nil
+}
+
+// Verify 校验回调签名，失败时返回 *SignatureError
+func (d *CallbackDispatcher) Verify(cb PaymentCallback) error {
+	if err := verifyPayload(cb.Provider, d.signerFor(cb.Provider), []byte(cb.signingPayload()), cb.Signature); err != nil {
+		err.(*SignatureError).Reason += fmt.Sprintf(" (回调 %s)", cb.EventID)
+		return err
+	}
+	return nil
+}
+
+// Deliver 接收一条回调：校验签名、去重，并按序投递给所有处理器
+// 所有处理器都成功后回调才记为已投递，之后重复的回调直接忽略；处理失败时返回错误，渠道重试时再次投递
+// Sequence 为 0 的回调不参与排序，立即投递；序号超前的回调先缓存，等前序回调到达后再一起投递，
+// 超过 callbackGapTimeout 仍未到达时跳过缺失的序号
+// 处理器在不持有锁的情况下调用，一个处理器很慢不会阻塞其他交易的回调
+func (d *CallbackDispatcher) Deliver(cb PaymentCallback) error {
+	if err := d.Verify(cb); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	d.evictLocked(now)
+	if d.seen[cb.EventID] || d.inflight[cb.EventID] {
+		d.mu.Unlock()
+		return nil
+	}
+
+	if cb.Sequence <= 0 {
+		d.inflight[cb.EventID] = true
+		handlers := append([]CallbackHandler(nil), d.handlers...)
+		d.mu.Unlock()
+
+		err := callHandlers(handlers, cb)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.inflight, cb.EventID)
+		if err == nil {
+			d.markSeenLocked(cb.EventID, time.Now())
+		}
+		return err
+	}
+
+	stream := d.streams[cb.TransactionID]
+	if stream == nil {
+		stream = &callbackStream{next: 1, pending: make(map[int]PaymentCallback)}
+		d.streams[cb.TransactionID] = stream
+	}
+	if cb.Sequence < stream.next {
+		// 该序号已经投递过，EventID 已超过保留时间
+		d.mu.Unlock()
+		return nil
+	}
+	if _, ok := stream.pending[cb.Sequence]; !ok && len(stream.pending) >= callbackPendingMax {
+		d.mu.Unlock()
+		return fmt.Errorf("交易 %s 等待投递的回调超过 %d 条", cb.TransactionID, callbackPendingMax)
+	}
+	stream.pending[cb.Sequence] = cb
+	stream.updated = now
+	d.mu.Unlock()
+
+	return d.drain(cb.TransactionID, false)
+}
+
+// drain 按序投递交易已到达的回调，直到遇到缺失的序号或处理失败，失败的回调留在缓存中等待重试
+// skipGap 为 true 时跳过缺失的序号，从已到达的最小序号继续
+// 同一交易同时只有一个 goroutine 在投递，其他 goroutine 把回调放入缓存后直接返回，由它继续投递
+func (d *CallbackDispatcher) drain(transactionID string, skipGap bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stream := d.streams[transactionID]
+	if stream == nil || stream.delivering {
+		return nil
+	}
+	stream.delivering = true
+	defer func() {
+		stream.delivering = false
+		d.scheduleGapLocked(transactionID, stream)
+	}()
+
+	if _, ok := stream.pending[stream.next]; !ok && skipGap {
+		if seq, ok := lowestSequence(stream.pending); ok {
+			stream.next = seq
+		}
+	}
+
+	for {
+		ready, ok := stream.pending[stream.next]
+		if !ok {
+			return nil
+		}
+		handlers := append([]CallbackHandler(nil), d.handlers...)
+		d.mu.Unlock()
+		err := callHandlers(handlers, ready)
+		d.mu.Lock()
+		if err != nil {
+			return err
+		}
+
+		delete(stream.pending, stream.next)
+		stream.next++
+		stream.updated = time.Now()
+		d.markSeenLocked(ready.EventID, stream.updated)
+	}
+}
+
+// scheduleGapLocked 缓存中还有回调时启动计时器，超时后跳过缺失的序号并重新投递，缓存为空时停止计时器
+func (d *CallbackDispatcher) scheduleGapLocked(transactionID string, stream *callbackStream) {
+	if len(stream.pending) == 0 {
+		if stream.gapTimer != nil {
+			stream.gapTimer.Stop()
+			stream.gapTimer = nil
+		}
+		return
+	}
+	if stream.gapTimer != nil {
+		return
+	}
+	stream.gapTimer = time.AfterFunc(callbackGapTimeout, func() {
+		d.mu.Lock()
+		stream.gapTimer = nil
+		d.mu.Unlock()
+		if err := d.drain(transactionID, true); err != nil {
+			log.Printf("重新投递交易 %s 的回调失败: %v", transactionID, err)
+		}
+	})
+}
+
+// markSeenLocked 记录已成功投递的 EventID
+func (d *CallbackDispatcher) markSeenLocked(eventID string, at time.Time) {
+	d.seen[eventID] = true
+	d.seenLog = append(d.seenLog, seenEvent{id: eventID, at: at})
+}
+
+// evictLocked 淘汰超过保留时间或超出数量上限的 EventID，每分钟最多清理一次已空闲的交易
+func (d *CallbackDispatcher) evictLocked(now time.Time) {
+	n := 0
+	for n < len(d.seenLog) && (len(d.seenLog)-n > callbackSeenMax || now.Sub(d.seenLog[n].at) > callbackSeenTTL) {
+		delete(d.seen, d.seenLog[n].id)
+		n++
+	}
+	d.seenLog = d.seenLog[n:]
+
+	if now.Sub(d.swept) < time.Minute {
+		return
+	}
+	d.swept = now
+	for id, stream := range d.streams {
+		if !stream.delivering && len(stream.pending) == 0 && now.Sub(stream.updated) > callbackSeenTTL {
+			delete(d.streams, id)
+		}
+	}
+}
+
+// lowestSequence 返回缓存中最小的序号，ok 为 false 表示缓存为空
+func lowestSequence(pending map[int]PaymentCallback) (lowest int, ok bool) {
+	for seq := range pending {
+		if !ok || seq < lowest {
+			lowest, ok = seq, true
+		}
+	}
+	return lowest, ok
+}
+
+// callHandlers 把回调交给所有处理器，返回所有处理器的错误
+func callHandlers(handlers []CallbackHandler, cb PaymentCallback) error {
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler.HandleCallback(cb); err != nil {
+			errs = append(errs, fmt.Errorf("处理回调 %s 失败: %w", cb.EventID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ServeHTTP 作为 Webhook 接收端，接收支付渠道 POST 过来的 JSON 回调
+func (d *CallbackDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cb PaymentCallback
+	if err := json.NewDecoder(r.Body).Decode(&cb); err != nil {
+		http.Error(w, "invalid callback body", http.StatusBadRequest)
+		return
+	}
+	if err := d.Deliver(cb); err != nil {
+		if errors.Is(err, ErrorInvalidSignature) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PaymentConfig 创建支付方式所需的配置，如账户、商户号等
+type PaymentConfig map[string]string
+
+// require 读取必填配置项
+func (c PaymentConfig) require(keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		value, ok := c[key]
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%w: 缺少 %s", ErrorInvalidConfig, key)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// PaymentFactory 支付方式工厂，根据配置创建支付方式实例
+type PaymentFactory func(config PaymentConfig) (Payment, error)
+
+// PaymentRegistry 支付方式注册表，按名称保存支付方式工厂
+// 新的支付方式只需要注册工厂，无需修改支付处理器
+type PaymentRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]PaymentFactory
+}
+
+// NewPaymentRegistry 创建空的支付方式注册表
+func NewPaymentRegistry() *PaymentRegistry {
+	return &PaymentRegistry{factories: make(map[string]PaymentFactory)}
+}
+
+// DefaultPaymentRegistry 默认注册表，内置支付方式在 init 中注册，插件也可以注册到这里
+var DefaultPaymentRegistry = NewPaymentRegistry()
+
+// Register 注册支付方式工厂，名称重复时返回错误
+func (r *PaymentRegistry) Register(name string, factory PaymentFactory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.factories[name]; exists {
+		return fmt.Errorf("支付方式 %s 已注册", name)
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// Create 使用已注册的工厂创建支付方式
+func (r *PaymentRegistry) Create(name string, config PaymentConfig) (Payment, error) {
+	r.mu.RLock()
+	factory, exists := r.factories[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrorPaymentNotFound, name)
+	}
+	return factory(config)
+}
+
+// Names 返回已注册的支付方式名称（按字母排序）
+func (r *PaymentRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 注册内置支付方式
+func init() {
+	DefaultPaymentRegistry.Register("alipay", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("account")
+		if err != nil {
+			return nil, err
+		}
+		return NewAlipay(values[0]), nil
+	})
+	DefaultPaymentRegistry.Register("wechat", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("open_id")
+		if err != nil {
+			return nil, err
+		}
+		return NewWechatPay(values[0]), nil
+	})
+	DefaultPaymentRegistry.Register("bankcard", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("card_number", "bank_name")
+		if err != nil {
+			return nil, err
+		}
+		return NewBankCard(values[0], values[1]), nil
+	})
+	DefaultPaymentRegistry.Register("unionpay", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("merchant_id")
+		if err != nil {
+			return nil, err
+		}
+		return NewUnionPay(UnionPayConfig{MerchantID: values[0], TerminalID: config["terminal_id"], Sandbox: config["sandbox"] == "true"})
+	})
+	DefaultPaymentRegistry.Register("paypal", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("client_id", "client_secret")
+		if err != nil {
+			return nil, err
+		}
+		return NewPayPal(PayPalConfig{ClientID: values[0], ClientSecret: values[1], Sandbox: config["sandbox"] == "true"})
+	})
+	DefaultPaymentRegistry.Register("stripe", func(config PaymentConfig) (Payment, error) {
+		values, err := config.require("api_key")
+		if err != nil {
+			return nil, err
+		}
+		return NewStripe(StripeConfig{APIKey: values[0], Sandbox: config["sandbox"] == "true"})
+	})
+}
+
+// ReceiptItem 收据中的一项商品
+type ReceiptItem struct {
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"` // 单价（请求币种）
+}
+
+// Receipt 支付收据
+type Receipt struct {
+	ReceiptNo        string        `json:"receipt_no"`
+	OrderID          string        `json:"order_id,omitempty"`
+	Items            []ReceiptItem `json:"items,omitempty"`
+	Method           string        `json:"method"`
+	Provider         string        `json:"provider"`
+	Instrument       string        `json:"instrument,omitempty"` // 脱敏后的支付工具
+	TransactionID    string        `json:"transaction_id"`
+	OriginalAmount   float64       `json:"original_amount"`
+	OriginalCurrency Currency      `json:"original_currency"`
+	Amount           float64       `json:"amount"` // 实际扣款金额（结算币种）
+	Currency         Currency      `json:"currency"`
+	CouponCode       string        `json:"coupon_code,omitempty"`
+	Discount         float64       `json:"discount,omitempty"` // 优惠金额（请求币种）
+	Fee              float64       `json:"fee"`
+	NetAmount        float64       `json:"net_amount"`
+	PaidAt           time.Time     `json:"paid_at"`
+	Environment      Environment   `json:"environment"`
+}
+
+// NewReceipt 根据支付请求和成功的支付结果生成收据
+func NewReceipt(method string, payment Payment, req PaymentRequest, result *PaymentResult) *Receipt {
+	receipt := &Receipt{
+		ReceiptNo:        "RCPT-" + result.TransactionID,
+		OrderID:          req.OrderID,
+		Items:            req.Items,
+		Method:           method,
+		Provider:         result.Provider,
+		TransactionID:    result.TransactionID,
+		OriginalAmount:   result.OriginalAmount,
+		OriginalCurrency: result.OriginalCurrency,
+		Amount:           result.Amount,
+		Currency:         result.Currency,
+		Fee:              result.Fee,
+		NetAmount:        result.NetAmount,
+		CouponCode:       req.CouponCode,
+		Discount:         req.Discount,
+		PaidAt:           time.Now(),
+		Environment:      result.Environment,
+	}
+	if ip, ok := payment.(InstrumentProvider); ok {
+		receipt.Instrument = ip.MaskedInstrument()
+	}
+	return receipt
+}
+
+// JSON 将收据序列化为缩进格式的 JSON
+func (r *Receipt) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Render 输出可打印的文本收据
+func (r *Receipt) Render(w io.Writer) {
+	line := strings.Repeat("-", 40)
+	fmt.Fprintln(w, line)
+	if r.Environment != EnvProduction {
+		fmt.Fprintln(w, "*** TEST 沙箱交易，未产生真实扣款 ***")
+	}
+	fmt.Fprintf(w, "收据编号: %s\n", r.ReceiptNo)
+	if r.OrderID != "" {
+		fmt.Fprintf(w, "订单号:   %s\n", r.OrderID)
+	}
+	fmt.Fprintf(w, "支付时间: %s\n", r.PaidAt.Format("2006-01-02 15:04:05"))
+	if len(r.Items) > 0 {
+		fmt.Fprintln(w, line)
+		for _, item := range r.Items {
+			fmt.Fprintf(w, "%-16s x%-3d %10.2f %s\n", item.Name, item.Quantity,
+				item.UnitPrice*float64(item.Quantity), r.OriginalCurrency)
+		}
+	}
+	fmt.Fprintln(w, line)
+	if r.Discount > 0 {
+		fmt.Fprintf(w, "订单金额: %.2f %s\n", r.OriginalAmount+r.Discount, r.OriginalCurrency)
+		fmt.Fprintf(w, "优惠:     -%.2f %s (%s)\n", r.Discount, r.OriginalCurrency, r.CouponCode)
+		fmt.Fprintf(w, "实付金额: %.2f %s\n", r.OriginalAmount, r.OriginalCurrency)
+	} else {
+		fmt.Fprintf(w, "订单金额: %.2f %s\n", r.OriginalAmount, r.OriginalCurrency)
+	}
+	if r.Currency != r.OriginalCurrency {
+		fmt.Fprintf(w, "扣款金额: %.2f %s\n", r.Amount, r.Currency)
+	}
+	fmt.Fprintf(w, "支付方式: %s %s\n", r.Provider, r.Instrument)
+	fmt.Fprintf(w, "交易号:   %s\n", r.TransactionID)
+	fmt.Fprintf(w, "手续费:   %.2f %s\n", r.Fee, r.Currency)
+	fmt.Fprintf(w, "到账金额: %.2f %s\n", r.NetAmount, r.Currency)
+	fmt.Fprintln(w, line)
+}
+
+// CouponType 优惠券类型
+type CouponType int
+
+const (
+	CouponPercentage CouponType = iota // 按比例折扣
+	CouponFixed                        // 立减固定金额
+	CouponThreshold                    // 满减：订单金额达到门槛后立减
+)
+
+// Coupon 优惠券
+type Coupon struct {
+	Code        string
+	Type        CouponType
+	Value       float64   // 折扣百分比（如 10 表示九折）或立减金额
+	Threshold   float64   // 满减门槛，仅 CouponThreshold 使用
+	MaxDiscount float64   // 按比例折扣的最高优惠金额，0 表示不限
+	Currency    Currency  // 金额类参数的币种，为空时视为人民币
+	ExpiresAt   time.Time // 过期时间，零值表示永不过期
+	SingleUse   bool      // 是否只能使用一次
+}
+
+// discount 计算优惠金额（分）
+func (c Coupon) discount(amount float64) (int64, error) {
+	cents := toCents(amount)
+	var off int64
+	switch c.Type {
+	case CouponPercentage:
+		off = int64(math.Round(float64(cents) * c.Value / 100))
+		if c.MaxDiscount > 0 && off > toCents(c.MaxDiscount) {
+			off = toCents(c.MaxDiscount)
+		}
+	case CouponFixed:
+		off = toCents(c.Value)
+	case CouponThreshold:
+		if cents < toCents(c.Threshold) {
+			return 0, fmt.Errorf("%w: %s 需满 %.2f 元", ErrorCouponNotApplicable, c.Code, c.Threshold)
+		}
+		off = toCents(c.Value)
+	}
+	// 至少支付1分钱
+	if off > cents-1 {
+		off = cents - 1
+	}
+	return off, nil
+}
+
+// DiscountEngine 优惠券管理与折扣计算
+// 一次性优惠券在支付期间被占用，支付成功后核销，失败后释放
+type DiscountEngine struct {
+	mu       sync.Mutex
+	coupons  map[string]Coupon
+	reserved map[string]bool // 支付中占用的一次性优惠券
+	redeemed map[string]bool // 已核销的一次性优惠券
+	now      func() time.Time
+}
+
+// NewDiscountEngine 创建折扣引擎
+func NewDiscountEngine(coupons ...Coupon) *DiscountEngine {
+	e := &DiscountEngine{
+		coupons:  make(map[string]Coupon),
+		reserved: make(map[string]bool),
+		redeemed: make(map[string]bool),
+		now:      time.Now,
+	}
+	for _, c := range coupons {
+		e.AddCoupon(c)
+	}
+	return e
+}
+
+// AddCoupon 添加优惠券，同码覆盖
+func (e *DiscountEngine) AddCoupon(coupon Coupon) {
+	if coupon.Currency == "" {
+		coupon.Currency = CNY
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.coupons[coupon.Code] = coupon
+}
+
+// Quote 计算优惠金额，不占用优惠券
+func (e *DiscountEngine) Quote(code string, amount float64, currency Currency) (float64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	off, err := e.check(code, amount, currency)
+	return float64(off) / 100, err
+}
+
+// check 校验优惠券并计算优惠金额（分），调用方需持有锁
+func (e *DiscountEngine) check(code string, amount float64, currency Currency) (int64, error) {
+	coupon, ok := e.coupons[code]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrorCouponNotFound, code)
+	}
+	if !coupon.ExpiresAt.IsZero() && !e.now().Before(coupon.ExpiresAt) {
+		return 0, fmt.Errorf("%w: %s", ErrorCouponExpired, code)
+	}
+	if coupon.SingleUse && (e.reserved[code] || e.redeemed[code]) {
+		return 0, fmt.Errorf("%w: %s", ErrorCouponUsed, code)
+	}
+	if currency != coupon.Currency {
+		return 0, fmt.Errorf("%w: %s 仅限 %s", ErrorCouponNotApplicable, code, coupon.Currency)
+	}
+	return coupon.discount(amount)
+}
+
+// reserve 校验并占用优惠券，返回优惠金额
+func (e *DiscountEngine) reserve(code string, amount float64, currency Currency) (float64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	off, err := e.check(code, amount, currency)
+	if err != nil {
+		return 0, err
+	}
+	if e.coupons[code].SingleUse {
+		e.reserved[code] = true
+	}
+	return float64(off) / 100, nil
+}
+
+// settle 支付结束后核销或释放优惠券
+func (e *DiscountEngine) settle(code string, paid bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.reserved[code] {
+		return
+	}
+	delete(e.reserved, code)
+	if paid {
+		e.redeemed[code] = true
+	}
+}
+
+// QRPayloadProvider 可选接口：生成扫码支付的二维码内容，未实现时使用通用格式
+type QRPayloadProvider interface {
+	QRPayload(sessionID string) string
+}
+
+// QRStatus 扫码支付会话状态
+type QRStatus int
+
+const (
+	QRWaiting QRStatus = iota // 等待用户扫码付款
+	QRPaying                  // 已扫码，正在扣款或等待付款结果，不会过期
+	QRPaid                    // 已付款
+	QRExpired                 // 超时未付款，二维码失效
+)
+
+func (s QRStatus) String() string {
+	switch s {
+	case QRWaiting:
+		return "Waiting"
+	case QRPaying:
+		return "Paying"
+	case QRPaid:
+		return "Paid"
+	case QRExpired:
+		return "Expired"
+	default:
+		return "Unknown"
+	}
+}
+
+// QRSession 扫码支付会话，商户展示二维码，用户扫码后付款
+type QRSession struct {
+	ID            string
+	Method        string
+	Request       PaymentRequest
+	Payload       string    // 二维码内容
+	ExpiresAt     time.Time // 过期时间
+	Status        QRStatus
+	TransactionID string // 用户付款后的交易号
+}
+
+// QRPaymentService 扫码支付：生成二维码，通过回调或轮询确认付款，超时未付款的二维码自动失效
+type QRPaymentService struct {
+	process    *PaymentProcess
+	ttl        time.Duration
+	dispatcher *CallbackDispatcher // 付款结果回调，为 nil 时只能轮询
+
+	mu       sync.Mutex
+	sessions map[string]*QRSession
+	byTxID   map[string]*QRSession
+}
+
+// NewQRPaymentService 创建扫码支付服务，ttl 为二维码有效期
+func NewQRPaymentService(process *PaymentProcess, ttl time.Duration) *QRPaymentService {
+	return &QRPaymentService{
+		process:  process,
+		ttl:      ttl,
+		sessions: make(map[string]*QRSession),
+		byTxID:   make(map[string]*QRSession),
+	}
+}
+
+// SetCallbackDispatcher 设置回调分发器，并把服务注册为回调处理器
+func (s *QRPaymentService) SetCallbackDispatcher(d *CallbackDispatcher) {
+	s.mu.Lock()
+	s.dispatcher = d
+	s.mu.Unlock()
+	d.Register(s)
+}
+
+// CreateQRPayment 创建扫码支付会话，返回二维码内容
+func (s *QRPaymentService) CreateQRPayment(method string, req PaymentRequest) (*QRSession, error) {
+	if req.Amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	payment, err := s.process.GetPayment(method)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newQRSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	session := &QRSession{ID: id, Method: method, Request: req, ExpiresAt: time.Now().Add(s.ttl), Status: QRWaiting}
+	if qp, ok := payment.(QRPayloadProvider); ok {
+		session.Payload = qp.QRPayload(id)
+	} else {
+		session.Payload = fmt.Sprintf("pay://%s/%s", method, id)
+	}
+	s.sessions[id] = session
+	s.mu.Unlock()
+
+	time.AfterFunc(s.ttl, func() { s.expire(id) })
+	copied := *session
+	return &copied, nil
+}
+
+// newQRSessionID 生成会话ID，随机部分保证多个 QRPaymentService 共用回调分发器时ID和回调的 EventID 不会重复
+func newQRSessionID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := crand.Read(b); err != nil {
+		return "", fmt.Errorf("生成二维码ID失败: %w", err)
+	}
+	return "QR" + time.Now().Format("20060102150405") + hex.EncodeToString(b), nil
+}
+
+// expire 二维码到期后，未扫码的会话失效；正在扣款的会话等待付款结果
+func (s *QRPaymentService) expire(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok && session.Status == QRWaiting {
+		session.Status = QRExpired
+	}
+}
+
+// Scan 模拟用户扫码付款：渠道完成扣款后通过回调通知商户
+// 扣款前会话进入 QRPaying 状态，同时扫码或重复扫码只扣款一次；扣款失败时恢复为等待扫码
+// 未设置回调分发器时，商户需要通过 Status/WaitPaid 轮询付款结果
+func (s *QRPaymentService) Scan(ctx context.Context, id string) error {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrorQRSessionNotFound, id)
+	}
+	if session.Status == QRPaying || session.Status == QRPaid {
+		s.mu.Unlock()
+		return nil // 正在付款或已付款，重复扫码
+	}
+	if session.Status == QRExpired || time.Now().After(session.ExpiresAt) {
+		session.Status = QRExpired
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrorQRSessionExpired, id)
+	}
+	session.Status = QRPaying
+	method, req := session.Method, session.Request
+	s.mu.Unlock()
+
+	result, err := s.process.Charge(ctx, method, req)
+	if err != nil {
+		s.mu.Lock()
+		session.Status = QRWaiting
+		if time.Now().After(session.ExpiresAt) {
+			session.Status = QRExpired
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	session.TransactionID = result.TransactionID
+	s.byTxID[result.TransactionID] = session
+	d := s.dispatcher
+	s.mu.Unlock()
+
+	if d != nil {
+		cb := PaymentCallback{
+			EventID:       id + "-1",
+			TransactionID: result.TransactionID,
+			Provider:      result.Provider,
+			Status:        result.Status,
+			Amount:        result.Amount,
+			Sequence:      1,
+			Timestamp:     time.Now(),
+		}
+		if err := d.Sign(&cb); err != nil {
+			return err
+		}
+		return d.Deliver(cb)
+	}
+	return nil
+}
+
+// HandleCallback 实现 CallbackHandler 接口，收到付款成功回调后将会话标记为已付款
+func (s *QRPaymentService) HandleCallback(cb PaymentCallback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.byTxID[cb.TransactionID]
+	if !ok {
+		return nil // 不是扫码支付的交易
+	}
+	if cb.Status == PaymentSucceeded && session.Status == QRPaying {
+		session.Status = QRPaid
+	}
+	return nil
+}
+
+// Status 查询会话状态；已付款但尚未收到回调时向支付渠道查询
+func (s *QRPaymentService) Status(id string) (QRStatus, error) {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	if !ok {
+		s.mu.Unlock()
+		return QRExpired, fmt.Errorf("%w: %s", ErrorQRSessionNotFound, id)
+	}
+	status, method, txID := session.Status, session.Method, session.TransactionID
+	s.mu.Unlock()
+
+	if status != QRPaying || txID == "" {
+		return status, nil
+	}
+	payment, err := s.process.GetPayment(method)
+	if err != nil {
+		return status, err
+	}
+	paymentStatus, err := payment.QueryStatus(txID)
+	if err != nil {
+		return status, err
+	}
+	if paymentStatus == PaymentSucceeded {
+		s.mu.Lock()
+		session.Status = QRPaid
+		s.mu.Unlock()
+		return QRPaid, nil
+	}
+	return status, nil
+}
+
+// WaitPaid 轮询会话状态，直到已付款、过期或 ctx 被取消
+func (s *QRPaymentService) WaitPaid(ctx context.Context, id string) (QRStatus, error) {
+	for {
+		status, err := s.Status(id)
+		if err != nil || (status != QRWaiting && status != QRPaying) {
+			return status, err
+		}
+		if err := sleepContext(ctx, s.process.pollInterval); err != nil {
+			return status, err
+		}
+	}
+}
+
+// PaymentEventType 支付事件类型
+type PaymentEventType int
+
+const (
+	EventPaymentCreated   PaymentEventType = iota // 发起支付
+	EventPaymentSucceeded                         // 支付成功
+	EventPaymentFailed                            // 支付失败
+	EventPaymentRefunded                          // 退款成功（含部分退款）
+)
+
+func (t PaymentEventType) String() string {
+	switch t {
+	case EventPaymentCreated:
+		return "PaymentCreated"
+	case EventPaymentSucceeded:
+		return "PaymentSucceeded"
+	case EventPaymentFailed:
+		return "PaymentFailed"
+	case EventPaymentRefunded:
+		return "PaymentRefunded"
+	default:
+		return "Unknown"
+	}
+}
+
+// PaymentEvent 支付处理器发布的事件
+type PaymentEvent struct {
+	Type          PaymentEventType
+	Method        string   // 支付方式注册名
+	Provider      string   // 支付方式显示名称，发起支付时可能为空
+	Account       string   // 付款用户账户
+	TransactionID string   // 交易号，发起支付和支付失败时可能为空
+	Amount        float64  // 支付金额或本次退款金额
+	Currency      Currency // 金额对应的币种
+	Err           error    // 失败原因，仅 EventPaymentFailed 有值
+	Time          time.Time
+}
+
+// PaymentEventHandler 支付事件处理函数
+type PaymentEventHandler func(event PaymentEvent)
+
+// eventSubscription 事件订阅
+type eventSubscription struct {
+	id      int
+	handler PaymentEventHandler
+	types   map[PaymentEventType]bool // 为空表示订阅全部事件
+}
+
+// EventBus 支付事件总线，下游模块（订单、账本、通知等）通过订阅事件与支付处理解耦
+// 事件在发布者的 goroutine 中按订阅顺序同步投递，处理函数 panic 不会影响支付流程
+type EventBus struct {
+	mu   sync.RWMutex
+	seq  int
+	subs []eventSubscription
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe 订阅指定类型的事件，不指定类型时订阅全部事件，返回取消订阅的函数
+func (b *EventBus) Subscribe(handler PaymentEventHandler, types ...PaymentEventType) (unsubscribe func()) {
+	sub := eventSubscription{handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[PaymentEventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.seq++
+	sub.id = b.seq
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s.id == sub.id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish 发布事件
+func (b *EventBus) Publish(event PaymentEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.RLock()
+	subs := append([]eventSubscription(nil), b.subs...)
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.types == nil || sub.types[event.Type] {
+			b.dispatch(sub.handler, event)
+		}
+	}
+}
+
+// dispatch 调用处理函数并恢复其中的 panic
+func (b *EventBus) dispatch(handler PaymentEventHandler, event PaymentEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("处理事件 %s 时发生 panic: %v\n", event.Type, r)
+		}
+	}()
+	handler(event)
+}
+
+// FeeSchedule 渠道手续费规则：按比例收取再加固定费用
+type FeeSchedule struct {
+	Percent float64 // 费率百分比，如 0.6 表示 0.6%
+	Fixed   float64 // 每笔固定费用（结算币种）
+}
+
+// Fee 计算指定金额的手续费，精确到分，且不超过交易金额
+func (f FeeSchedule) Fee(amount float64) float64 {
+	cents := int64(math.Round(amount*f.Percent)) + toCents(f.Fixed)
+	if cents > toCents(amount) {
+		cents = toCents(amount)
+	}
+	return float64(cents) / 100
+}
+
+// PaymentProcess 支付处理器
+type PaymentProcess struct {
+	registry     *PaymentRegistry
+	payments     map[string]Payment // 已启用的支付方式，按名称索引
+	pollInterval time.Duration      // 查询处理中交易的间隔
+	pollTimeout  time.Duration      // 等待处理中交易的最长时间
+	converter    Converter          // 请求币种与结算币种之间的换算
+	store        *PaymentStore      // 支付记录存储，为 nil 时不记录
+
+	defaultTimeout time.Duration            // 单次 Pay 调用的默认超时时间
+	timeouts       map[string]time.Duration // 按支付方式单独配置的超时时间
+	fees           map[string]FeeSchedule   // 按支付方式配置的手续费规则，未配置的不收费
+	events         *EventBus                // 支付事件
+	metrics        *PaymentMetrics          // 各支付方式的调用次数、成功率和耗时
+	env            Environment              // 运行环境，只允许使用同一环境的支付方式
+	discounts      *DiscountEngine          // 优惠券，为 nil 时不支持优惠券
+
+	duplicateWindow time.Duration             // 重复支付检测窗口，为0时不检测
+	recentMu        sync.Mutex                // 保护 recent
+	recent          map[string]*recentPayment // 窗口内的支付，按支付方式、支付工具、账户、金额和订单号索引
+}
+
+// recentPayment 重复支付检测窗口内的一笔支付
+type recentPayment struct {
+	at     time.Time
+	done   chan struct{} // 支付完成后关闭
+	result *PaymentResult
+	err    error
+}
+
+// NewPaymentProcess 创建支付处理器实例
+func NewPaymentProcess() *PaymentProcess {
+	return &PaymentProcess{
+		pollInterval: 100 * time.Millisecond,
+		pollTimeout:  5 * time.Second,
+		converter: NewStaticRateConverter(map[Currency]float64{
+			USD: 7.10, EUR: 7.75, HKD: 0.91, JPY: 0.048,
+		}),
+		registry:       DefaultPaymentRegistry,
+		payments:       make(map[string]Payment),
+		defaultTimeout: 3 * time.Second,
+		timeouts:       make(map[string]time.Duration),
+		fees:           make(map[string]FeeSchedule),
+		events:         NewEventBus(),
+		metrics:        NewPaymentMetrics(),
+		env:            EnvSandbox,
+		recent:         make(map[string]*recentPayment),
+	}
+}
+
+// SetDuplicateWindow 设置重复支付检测窗口
+// 窗口内相同支付方式、支付工具、账户、金额和订单号的支付只扣款一次，之后的请求直接返回首笔交易的结果
+// 首笔支付失败时不拦截，允许重新支付
+func (p *PaymentProcess) SetDuplicateWindow(window time.Duration) {
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+	p.duplicateWindow = window
+}
+
+// duplicateKey 生成重复支付检测的键
+func (p *PaymentProcess) duplicateKey(method string, req PaymentRequest) string {
+	instrument := ""
+	if payment, err := p.GetPayment(method); err == nil {
+		if ip, ok := payment.(InstrumentProvider); ok {
+			instrument = ip.MaskedInstrument()
+		}
+	}
+	return fmt.Sprintf("%s|%s|%s|%d|%s|%s", method, instrument, req.Account, toCents(req.Amount), req.Currency, req.OrderID)
+}
+
+// claimPayment 在检测窗口内登记一笔支付
+// 窗口内已有相同的支付时返回该支付，调用方应等待其完成；否则返回 nil 和新登记的支付
+func (p *PaymentProcess) claimPayment(key string) (existing, claimed *recentPayment) {
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+	if p.duplicateWindow <= 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	for k, r := range p.recent {
+		if now.Sub(r.at) > p.duplicateWindow {
+			delete(p.recent, k)
+		}
+	}
+	if r, ok := p.recent[key]; ok {
+		return r, nil
+	}
+	claimed = &recentPayment{at: now, done: make(chan struct{})}
+	p.recent[key] = claimed
+	return nil, claimed
+}
+
+// completePayment 记录支付结果；失败的支付从窗口中移除，以便重新支付
+func (p *PaymentProcess) completePayment(key string, r *recentPayment, result *PaymentResult, err error) {
+	p.recentMu.Lock()
+	r.result, r.err = result, err
+	if err != nil && p.recent[key] == r {
+		delete(p.recent, key)
+	}
+	p.recentMu.Unlock()
+	close(r.done)
+}
+
+// SetDiscountEngine 设置优惠券折扣引擎
+func (p *PaymentProcess) SetDiscountEngine(engine *DiscountEngine) {
+	p.discounts = engine
+}
+
+// SetEnvironment 设置运行环境，默认为沙箱环境
+func (p *PaymentProcess) SetEnvironment(env Environment) {
+	p.env = env
+}
+
+// Environment 获取运行环境
+func (p *PaymentProcess) Environment() Environment {
+	return p.env
+}
+
+// paymentEnvironment 获取支付方式的环境，未声明时跟随支付处理器
+func (p *PaymentProcess) paymentEnvironment(payment Payment) Environment {
+	if e, ok := payment.(EnvironmentProvider); ok {
+		return e.Environment()
+	}
+	return p.env
+}
+
+// Metrics 获取各支付方式的调用统计
+func (p *PaymentProcess) Metrics() *PaymentMetrics {
+	return p.metrics
+}
+
+// Events 获取支付事件总线
+func (p *PaymentProcess) Events() *EventBus {
+	return p.events
+}
+
+// Subscribe 订阅支付事件，不指定类型时订阅全部事件，返回取消订阅的函数
+func (p *PaymentProcess) Subscribe(handler PaymentEventHandler, types ...PaymentEventType) func() {
+	return p.events.Subscribe(handler, types...)
+}
+
+// SetFeeSchedule 设置指定支付方式的手续费规则
+func (p *PaymentProcess) SetFeeSchedule(method string, schedule FeeSchedule) {
+	p.fees[method] = schedule
+}
+
+// SetTimeout 设置指定支付方式单次 Pay 调用的超时时间
+func (p *PaymentProcess) SetTimeout(method string, timeout time.Duration) {
+	p.timeouts[method] = timeout
+}
+
+// timeoutFor 获取支付方式的超时时间
+func (p *PaymentProcess) timeoutFor(method string) time.Duration {
+	if timeout, ok := p.timeouts[method]; ok {
+		return timeout
+	}
+	return p.defaultTimeout
+}
+
+// UsePayment 通过注册表创建并启用支付方式
+func (p *PaymentProcess) UsePayment(method string, config PaymentConfig) error {
+	payment, err := p.registry.Create(method, config)
+	if err != nil {
+		return err
+	}
+	p.AddPayment(method, payment)
+	return nil
+}
+
+// AddPayment 以指定名称启用一个已创建好的支付方式
+func (p *PaymentProcess) AddPayment(method string, payment Payment) {
+	p.payments[method] = payment
+}
+
+// GetPayment 获取已启用的支付方式
+func (p *PaymentProcess) GetPayment(method string) (Payment, error) {
+	payment, exists := p.payments[method]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrorPaymentNotFound, method)
+	}
+	return payment, nil
+}
+
+// SetConverter 设置汇率换算器
+func (p *PaymentProcess) SetConverter(converter Converter) {
+	p.converter = converter
+}
+
+// settlementCurrency 获取支付方式的结算币种
+func settlementCurrency(payment Payment) Currency {
+	if s, ok := payment.(SettlementCurrencyProvider); ok {
+		return s.SettlementCurrency()
+	}
+	return CNY
+}
+
+// SetStore 设置支付记录存储，之后每次支付和退款都会写入数据库
+func (p *PaymentProcess) SetStore(store *PaymentStore) {
+	p.store = store
+}
+
+// ProcessPayment 使用指定名称的支付方式处理支付，失败时返回 nil
+// 请求带有优惠券时先计算折扣，按优惠后的金额扣款
+// 请求币种与支付方式的结算币种不同时，先换算再扣款，结果中同时记录原始金额和换算后的金额
+// 设置了支付记录存储时，每次尝试及其结果都会被记录
+// ctx 可用于取消支付；单次 Pay 调用超过该支付方式的超时时间时返回 ErrPaymentTimeout
+// 支付渠道的失败以 *PaymentError 返回，可以用 errors.As 取出错误码和是否可重试
+func (p *PaymentProcess) ProcessPayment(ctx context.Context, method string, req PaymentRequest) *PaymentResult {
+	result, err := p.Charge(ctx, method, req)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	if result.Duplicate {
+		fmt.Printf("重复支付已拦截，返回首笔交易 %s 的结果\n", result.TransactionID)
+		return result
+	}
+	fmt.Println(result.Message)
+	return result
+}
+
+// Charge 与 ProcessPayment 相同，但不打印结果，失败时返回错误
+func (p *PaymentProcess) Charge(ctx context.Context, method string, req PaymentRequest) (*PaymentResult, error) {
+	if req.Currency == "" {
+		req.Currency = CNY
+	}
+
+	// 重复支付检测：等待窗口内相同的支付完成，成功则直接返回其结果
+	key := p.duplicateKey(method, req)
+	for {
+		existing, claimed := p.claimPayment(key)
+		if existing == nil {
+			if claimed != nil {
+				result, err := p.charge(ctx, method, req)
+				p.completePayment(key, claimed, result, err)
+				return result, err
+			}
+			return p.charge(ctx, method, req)
+		}
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if existing.err == nil {
+			duplicate := *existing.result
+			duplicate.Duplicate = true
+			return &duplicate, nil
+		}
+		// 首笔支付失败，重新登记并支付
+	}
+}
+
+// charge 使用优惠券并执行支付
+func (p *PaymentProcess) charge(ctx context.Context, method string, req PaymentRequest) (*PaymentResult, error) {
+	// 使用优惠券：扣款金额为优惠后的金额，一次性优惠券在支付成功后核销
+	if req.CouponCode != "" {
+		if p.discounts == nil {
+			return nil, fmt.Errorf("%w: %s", ErrorCouponNotFound, req.CouponCode)
+		}
+		discount, err := p.discounts.reserve(req.CouponCode, req.Amount, req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		req.Discount = discount
+		req.Amount = float64(toCents(req.Amount)-toCents(discount)) / 100
+	}
+
+	result, err := p.execute(ctx, method, req)
+	if req.CouponCode != "" {
+		p.discounts.settle(req.CouponCode, err == nil)
+	}
+	return result, err
+}
+
+// execute 执行一次支付尝试：保存支付记录、发布事件并支付
+func (p *PaymentProcess) execute(ctx context.Context, method string, req PaymentRequest) (*PaymentResult, error) {
+	record := p.beginRecord(method, req)
+	event := PaymentEvent{Type: EventPaymentCreated, Method: method, Account: req.Account, Amount: req.Amount, Currency: req.Currency}
+	if payment, err := p.GetPayment(method); err == nil {
+		event.Provider = payment.GetName()
+	}
+	p.events.Publish(event)
+
+	start := time.Now()
+	result, err := p.pay(ctx, method, req)
+	if _, known := p.payments[method]; known {
+		p.metrics.Observe(method, time.Since(start), err)
+	}
+	p.finishRecord(record, result, err)
+	if err == nil {
+		payment, _ := p.GetPayment(method)
+		result.Receipt = NewReceipt(method, payment, req, result)
+	}
+
+	event.Time = time.Time{}
+	if result != nil {
+		event.TransactionID, event.Amount, event.Currency = result.TransactionID, result.Amount, result.Currency
+	}
+	if err != nil {
+		event.Type, event.Err = EventPaymentFailed, err
+	} else {
+		event.Type = EventPaymentSucceeded
+	}
+	p.events.Publish(event)
+	return result, err
+}
+
+// beginRecord 记录一次支付尝试，未设置存储时返回 nil
+func (p *PaymentProcess) beginRecord(method string, req PaymentRequest) *PaymentRecord {
+	if p.store == nil {
+		return nil
+	}
+	provider := method
+	if payment, err := p.GetPayment(method); err == nil {
+		provider = payment.GetName()
+	}
+	record, err := p.store.Begin(method, provider, req)
+	if err != nil {
+		fmt.Printf("保存支付记录失败: %v\n", err)
+		return nil
+	}
+	return record
+}
+
+// finishRecord 更新支付尝试的结果
+func (p *PaymentProcess) finishRecord(record *PaymentRecord, result *PaymentResult, payErr error) {
+	if p.store == nil || record == nil {
+		return
+	}
+	if err := p.store.Finish(record, result, payErr); err != nil {
+		fmt.Printf("更新支付记录失败: %v\n", err)
+	}
+}
+
+// pay 执行支付，返回的错误已包含支付方式名称
+func (p *PaymentProcess) pay(ctx context.Context, method string, req PaymentRequest) (*PaymentResult, error) {
+	payment, err := p.GetPayment(method) // 获取支付方式
+	if err != nil {
+		return nil, fmt.Errorf("无效的支付方式: %w", err)
+	}
+	// 沙箱环境不能使用生产凭证，生产环境也不能使用沙箱凭证
+	env := p.paymentEnvironment(payment)
+	if env != p.env {
+		return nil, fmt.Errorf("%s支付失败: %w: 支付方式为 %s 环境，支付处理器为 %s 环境", payment.GetName(), ErrorInvalidConfig, env, p.env)
+	}
+
+	currency := settlementCurrency(payment)
+	amount, err := p.converter.Convert(req.Amount, req.Currency, currency)
+	if err != nil {
+		return nil, fmt.Errorf("%s支付失败: %w", payment.GetName(), err)
+	}
+
+	// 为本次调用设置超时时间
+	timeout := p.timeoutFor(method)
+	payCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := payment.Pay(payCtx, amount) // 执行支付
+	if err != nil {
+		// 区分调用方主动取消和单次调用超时
+		if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s支付失败: %w", payment.GetName(),
+				newPaymentError(payment.GetName(), ErrCodeTimeout, "", fmt.Errorf("%w: 超过 %v 未返回", ErrPaymentTimeout, timeout)))
+		}
+		return nil, fmt.Errorf("%s支付失败: %w", payment.GetName(), err)
+	}
+	result.Provider = payment.GetName()
+	result.Environment = env
+	result.Currency = currency
+	result.OriginalAmount = req.Amount
+	result.OriginalCurrency = req.Currency
+	result.Fee = p.fees[method].Fee(result.Amount)
+	result.NetAmount = float64(toCents(result.Amount)-toCents(result.Fee)) / 100
+	if req.Currency != currency {
+		fmt.Printf("币种换算: %.2f %s -> %.2f %s\n", req.Amount, req.Currency, amount, currency)
+	}
+
+	// 异步支付：轮询直到得到最终结果
+	if result.Status == PaymentPending {
+		fmt.Printf("%s交易 %s 处理中，等待支付结果...\n", payment.GetName(), result.TransactionID)
+		status, err := p.AwaitPayment(ctx, payment, result.TransactionID)
+		if err != nil {
+			return result, fmt.Errorf("%s支付失败: %w", payment.GetName(), err)
+		}
+		result.Status = status
+		if status == PaymentFailed {
+			return result, fmt.Errorf("%s支付失败: %w: %s", payment.GetName(), ErrorPaymentFailed, result.TransactionID)
+		}
+	}
+
+	return result, nil
+}
+
+// AwaitPayment 轮询交易状态，直到交易不再处于处理中、超时或 ctx 被取消
+func (p *PaymentProcess) AwaitPayment(ctx context.Context, payment Payment, transactionID string) (PaymentStatus, error) {
+	deadline := time.Now().Add(p.pollTimeout)
+	for {
+		status, err := payment.QueryStatus(transactionID)
+		if err != nil {
+			return PaymentFailed, err
+		}
+		if status != PaymentPending {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return PaymentPending, fmt.Errorf("%w: 交易 %s", ErrorPaymentPending, transactionID)
+		}
+		if err := sleepContext(ctx, p.pollInterval); err != nil {
+			return PaymentPending, err
+		}
+	}
+}
+
+// ProcessRefund 使用指定名称的支付方式对交易退款，失败时返回 nil
+func (p *PaymentProcess) ProcessRefund(method string, transactionID string, amount float64) *RefundResult {
+	result, err := p.Refund(method, transactionID, amount)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	fmt.Printf("%s, 累计退款:%.2f元, 剩余可退:%.2f元\n", result.Message, result.TotalRefunded, result.Remaining)
+	return result
+}
+
+// Refund 与 ProcessRefund 相同，但不打印结果，失败时返回错误
+func (p *PaymentProcess) Refund(method string, transactionID string, amount float64) (*RefundResult, error) {
+	payment, err := p.GetPayment(method)
+	if err != nil {
+		return nil, fmt.Errorf("无效的支付方式: %w", err)
+	}
+
+	result, err := payment.Refund(transactionID, amount) // 执行退款
+	if err != nil {
+		return nil, fmt.Errorf("%s退款失败: %w", payment.GetName(), err)
+	}
+	if p.store != nil {
+		if err := p.store.RecordRefund(result); err != nil {
+			fmt.Printf("更新支付记录失败: %v\n", err)
+		}
+	}
+	p.events.Publish(PaymentEvent{
+		Type:          EventPaymentRefunded,
+		Method:        method,
+		Provider:      payment.GetName(),
+		TransactionID: result.TransactionID,
+		Amount:        result.Amount,
+		Currency:      settlementCurrency(payment),
+	})
+	return result, nil
+}
+
+// latencyBuckets 支付耗时直方图的桶上限（秒）
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// providerMetrics 单个支付方式的计数器和耗时直方图
+type providerMetrics struct {
+	successes uint64
+	failures  uint64
+	buckets   []uint64 // 与 latencyBuckets 对应，非累计
+	sum       float64  // 耗时总和（秒）
+}
+
+// PaymentStats 支付方式的调用统计快照
+type PaymentStats struct {
+	Calls       uint64
+	Successes   uint64
+	Failures    uint64
+	SuccessRate float64       // 没有调用时为0
+	AvgLatency  time.Duration // 平均耗时
+	P95Latency  time.Duration // 按直方图估算的95分位耗时，取所在桶的上限
+}
+
+// PaymentMetrics 按支付方式统计支付调用的次数、结果和耗时
+// 可以通过 Stats 读取，也可以以 Prometheus 文本格式暴露
+type PaymentMetrics struct {
+	mu        sync.Mutex
+	providers map[string]*providerMetrics
+}
+
+// NewPaymentMetrics 创建支付统计
+func NewPaymentMetrics() *PaymentMetrics {
+	return &PaymentMetrics{providers: make(map[string]*providerMetrics)}
+}
+
+// Observe 记录一次支付调用
+func (m *PaymentMetrics) Observe(method string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pm := m.providers[method]
+	if pm == nil {
+		pm = &providerMetrics{buckets: make([]uint64, len(latencyBuckets)+1)} // 最后一个桶为 +Inf
+		m.providers[method] = pm
+	}
+	if err != nil {
+		pm.failures++
+	} else {
+		pm.successes++
+	}
+	seconds := latency.Seconds()
+	pm.sum += seconds
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	pm.buckets[i]++
+}
+
+// Stats 获取所有支付方式的统计快照
+func (m *PaymentMetrics) Stats() map[string]PaymentStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]PaymentStats, len(m.providers))
+	for method, pm := range m.providers {
+		stats[method] = pm.snapshot()
+	}
+	return stats
+}
+
+// ProviderStats 以路由统计的形式返回支付方式的统计，供路由策略使用
+func (m *PaymentMetrics) ProviderStats(method string) ProviderStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pm := m.providers[method]
+	if pm == nil {
+		return ProviderStats{}
+	}
+	return ProviderStats{
+		Attempts:     int(pm.successes + pm.failures),
+		Successes:    int(pm.successes),
+		TotalLatency: time.Duration(pm.sum * float64(time.Second)),
+	}
+}
+
+// snapshot 生成统计快照，调用方需持有锁
+func (pm *providerMetrics) snapshot() PaymentStats {
+	calls := pm.successes + pm.failures
+	s := PaymentStats{Calls: calls, Successes: pm.successes, Failures: pm.failures}
+	if calls == 0 {
+		return s
+	}
+	s.SuccessRate = float64(pm.successes) / float64(calls)
+	s.AvgLatency = time.Duration(pm.sum / float64(calls) * float64(time.Second))
+
+	target := uint64(math.Ceil(float64(calls) * 0.95))
+	var cumulative uint64
+	for i, n := range pm.buckets {
+		cumulative += n
+		if cumulative >= target {
+			if i < len(latencyBuckets) {
+				s.P95Latency = time.Duration(latencyBuckets[i] * float64(time.Second))
+			} else {
+				s.P95Latency = time.Duration(latencyBuckets[len(latencyBuckets)-1] * float64(time.Second))
+			}
+			break
+		}
+	}
+	return s
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出统计
+func (m *PaymentMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	methods := make([]string, 0, len(m.providers))
+	for method := range m.providers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fmt.Fprintln(w, "# HELP payment_requests_total Total number of payment attempts by method and result.")
+	fmt.Fprintln(w, "# TYPE payment_requests_total counter")
+	for _, method := range methods {
+		pm := m.providers[method]
+		fmt.Fprintf(w, "payment_requests_total{method=%q,result=\"success\"} %d\n", method, pm.successes)
+		fmt.Fprintf(w, "payment_requests_total{method=%q,result=\"failure\"} %d\n", method, pm.failures)
+	}
+
+	fmt.Fprintln(w, "# HELP payment_duration_seconds Payment attempt latency by method.")
+	fmt.Fprintln(w, "# TYPE payment_duration_seconds histogram")
+	for _, method := range methods {
+		pm := m.providers[method]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += pm.buckets[i]
+			fmt.Fprintf(w, "payment_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", method, bound, cumulative)
+		}
+		cumulative += pm.buckets[len(latencyBuckets)]
+		fmt.Fprintf(w, "payment_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, cumulative)
+		fmt.Fprintf(w, "payment_duration_seconds_sum{method=%q} %g\n", method, pm.sum)
+		fmt.Fprintf(w, "payment_duration_seconds_count{method=%q} %d\n", method, cumulative)
+	}
+}
+
+// ServeHTTP 作为 Prometheus 抓取端点
+func (m *PaymentMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+// ProviderStats 路由到某个支付方式的统计数据
+type ProviderStats struct {
+	Attempts     int
+	Successes    int
+	TotalLatency time.Duration
+}
+
+// SuccessRate 平滑后的成功率，没有数据的支付方式为 0.5，以便新渠道也有机会被选中
+func (s ProviderStats) SuccessRate() float64 {
+	return float64(s.Successes+1) / float64(s.Attempts+2)
+}
+
+// RouterMetrics 路由统计，记录每个支付方式的支付结果，供路由策略参考
+type RouterMetrics struct {
+	mu        sync.Mutex
+	providers map[string]*ProviderStats
+	picks     map[string]map[string]int // 策略名 -> 支付方式 -> 选中次数
+}
+
+// NewRouterMetrics 创建路由统计
+func NewRouterMetrics() *RouterMetrics {
+	return &RouterMetrics{
+		providers: make(map[string]*ProviderStats),
+		picks:     make(map[string]map[string]int),
+	}
+}
+
+// Record 记录一次支付结果
+func (m *RouterMetrics) Record(method string, success bool, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.providers[method]
+	if stats == nil {
+		stats = &ProviderStats{}
+		m.providers[method] = stats
+	}
+	stats.Attempts++
+	stats.TotalLatency += latency
+	if success {
+		stats.Successes++
+	}
+}
+
+// recordPick 记录策略的一次选择
+func (m *RouterMetrics) recordPick(strategy, method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.picks[strategy] == nil {
+		m.picks[strategy] = make(map[string]int)
+	}
+	m.picks[strategy][method]++
+}
+
+// Stats 获取支付方式的统计数据
+func (m *RouterMetrics) Stats(method string) ProviderStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stats := m.providers[method]; stats != nil {
+		return *stats
+	}
+	return ProviderStats{}
+}
+
+// Picks 获取策略选中各支付方式的次数
+func (m *RouterMetrics) Picks(strategy string) map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	picks := make(map[string]int, len(m.picks[strategy]))
+	for method, n := range m.picks[strategy] {
+		picks[method] = n
+	}
+	return picks
+}
+
+// RoutingStrategy 路由策略，从候选支付方式中为请求选择一个
+type RoutingStrategy interface {
+	Name() string
+	Choose(p *PaymentProcess, candidates []string, req PaymentRequest, metrics *RouterMetrics) (string, error)
+}
+
+// routingStats 获取支付方式的路由统计，路由器尚未使用过该支付方式时参考支付处理器的全局统计
+func routingStats(p *PaymentProcess, metrics *RouterMetrics, method string) ProviderStats {
+	if stats := metrics.Stats(method); stats.Attempts > 0 {
+		return stats
+	}
+	return p.metrics.ProviderStats(method)
+}
+
+// LowestFeeStrategy 选择手续费最低的支付方式，手续费统一换算为人民币比较
+type LowestFeeStrategy struct {
+	MinSuccessRate float64 // 成功率低于该值的支付方式不参与选择，为0时不限制
+}
+
+func (LowestFeeStrategy) Name() string { return "lowest-fee" }
+
+func (s LowestFeeStrategy) Choose(p *PaymentProcess, candidates []string, req PaymentRequest, metrics *RouterMetrics) (string, error) {
+	best, bestFee := "", math.Inf(1)
+	for _, method := range candidates {
+		payment, err := p.GetPayment(method)
+		if err != nil {
+			continue
+		}
+		if routingStats(p, metrics, method).SuccessRate() < s.MinSuccessRate {
+			continue
+		}
+		currency := settlementCurrency(payment)
+		amount, err := p.converter.Convert(req.Amount, req.Currency, currency)
+		if err != nil {
+			continue
+		}
+		fee, err := p.converter.Convert(p.fees[method].Fee(amount), currency, CNY)
+		if err != nil {
+			continue
+		}
+		if fee < bestFee {
+			best, bestFee = method, fee
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: 没有可用的支付方式", ErrorPaymentNotFound)
+	}
+	return best, nil
+}
+
+// SuccessRateStrategy 选择历史成功率最高的支付方式
+type SuccessRateStrategy struct{}
+
+func (SuccessRateStrategy) Name() string { return "success-rate" }
+
+func (SuccessRateStrategy) Choose(p *PaymentProcess, candidates []string, _ PaymentRequest, metrics *RouterMetrics) (string, error) {
+	best, bestRate := "", -1.0
+	for _, method := range candidates {
+		if _, err := p.GetPayment(method); err != nil {
+			continue
+		}
+		if rate := routingStats(p, metrics, method).SuccessRate(); rate > bestRate {
+			best, bestRate = method, rate
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("%w: 没有可用的支付方式", ErrorPaymentNotFound)
+	}
+	return best, nil
+}
+
+// RoundRobinStrategy 依次轮流选择支付方式
+type RoundRobinStrategy struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (s *RoundRobinStrategy) Name() string { return "round-robin" }
+
+func (s *RoundRobinStrategy) Choose(p *PaymentProcess, candidates []string, _ PaymentRequest, _ *RouterMetrics) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range candidates {
+		method := candidates[s.next%len(candidates)]
+		s.next++
+		if _, err := p.GetPayment(method); err == nil {
+			return method, nil
+		}
+	}
+	return "", fmt.Errorf("%w: 没有可用的支付方式", ErrorPaymentNotFound)
+}
+
+// Router 按路由策略为每个请求选择支付方式，并把支付结果反馈到统计数据中
+type Router struct {
+	process    *PaymentProcess
+	candidates []string
+	strategy   RoutingStrategy
+	metrics    *RouterMetrics
+}
+
+// NewRouter 创建路由器，metrics 为 nil 时新建一份统计；多个路由器可共享同一份统计
+func NewRouter(process *PaymentProcess, strategy RoutingStrategy, metrics *RouterMetrics, candidates ...string) *Router {
+	if metrics == nil {
+		metrics = NewRouterMetrics()
+	}
+	return &Router{process: process, candidates: candidates, strategy: strategy, metrics: metrics}
+}
+
+// SetStrategy 切换路由策略
+func (r *Router) SetStrategy(strategy RoutingStrategy) {
+	r.strategy = strategy
+}
+
+// Metrics 获取路由统计
+func (r *Router) Metrics() *RouterMetrics {
+	return r.metrics
+}
+
+// Route 为请求选择支付方式
+func (r *Router) Route(req PaymentRequest) (string, error) {
+	if req.Currency == "" {
+		req.Currency = CNY
+	}
+	method, err := r.strategy.Choose(r.process, r.candidates, req, r.metrics)
+	if err != nil {
+		return "", err
+	}
+	r.metrics.recordPick(r.strategy.Name(), method)
+	return method, nil
+}
+
+// ProcessPayment 选择支付方式并处理支付，失败时返回 nil
+func (r *Router) ProcessPayment(ctx context.Context, req PaymentRequest) *PaymentResult {
+	method, err := r.Route(req)
+	if err != nil {
+		fmt.Printf("路由失败: %v\n", err)
+		return nil
+	}
+	start := time.Now()
+	result := r.process.ProcessPayment(ctx, method, req)
+	r.metrics.Record(method, result != nil, time.Since(start))
+	return result
+}
+
+// PaymentRecord 支付记录，每次支付尝试对应一条记录
+type PaymentRecord struct {
+	ID               uint          `gorm:"primaryKey"`
+	TransactionID    string        `gorm:"size:64;index"`  // 交易号，支付未提交成功时为空
+	Method           string        `gorm:"size:32;index"`  // 支付方式注册名，如 alipay
+	Provider         string        `gorm:"size:64"`        // 支付方式显示名称
+	Account          string        `gorm:"size:128;index"` // 付款用户账户
+	Amount           float64       // 实际扣款金额（结算币种）
+	Currency         Currency      `gorm:"size:3"`
+	OriginalAmount   float64       // 请求的原始金额
+	OriginalCurrency Currency      `gorm:"size:3"`
+	Fee              float64       // 渠道手续费
+	NetAmount        float64       // 扣除手续费后的到账金额
+	Environment      Environment   `gorm:"size:16"`
+	CouponCode       string        `gorm:"size:32"`
+	DiscountAmount   float64       // 优惠金额（请求币种），原价为 OriginalAmount + DiscountAmount
+	RefundedAmount   float64       // 累计退款金额
+	Status           PaymentStatus `gorm:"index"`
+	ErrorMessage     string        // 失败原因
+	CreatedAt        time.Time     `gorm:"index"`
+	UpdatedAt        time.Time
+}
+
+// PaymentHistoryFilter 支付历史查询条件，零值字段表示不限制
+type PaymentHistoryFilter struct {
+	Account string    // 付款用户账户
+	Method  string    // 支付方式注册名
+	Start   time.Time // 起始时间（含）
+	End     time.Time // 结束时间（不含）
+}
+
+// PaymentStore 基于 GORM 的支付记录存储
+type PaymentStore struct {
+	db *gorm.DB
+}
+
+// NewPaymentStore 创建支付记录存储，并自动迁移表结构
+func NewPaymentStore(db *gorm.DB) (*PaymentStore, error) {
+	if err := db.AutoMigrate(&PaymentRecord{}); err != nil {
+		return nil, err
+	}
+	return &PaymentStore{db: db}, nil
+}
+
+// Begin 记录一次支付尝试，状态为处理中
+func (s *PaymentStore) Begin(method, provider string, req PaymentRequest) (*PaymentRecord, error) {
+	record := &PaymentRecord{
+		Method:           method,
+		Provider:         provider,
+		Account:          req.Account,
+		OriginalAmount:   req.Amount,
+		OriginalCurrency: req.Currency,
+		CouponCode:       req.CouponCode,
+		DiscountAmount:   req.Discount,
+		Status:           PaymentPending,
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Finish 根据支付结果更新记录
+func (s *PaymentStore) Finish(record *PaymentRecord, result *PaymentResult, payErr error) error {
+	if result != nil {
+		record.TransactionID = result.TransactionID
+		record.Amount = result.Amount
+		record.Currency = result.Currency
+		record.Environment = result.Environment
+		record.Fee = result.Fee
+		record.NetAmount = result.NetAmount
+		record.Status = result.Status
+	}
+	if payErr != nil {
+		record.ErrorMessage = payErr.Error()
+		if record.Status != PaymentPending || result == nil {
+			record.Status = PaymentFailed
+		}
+	}
+	return s.db.Save(record).Error
+}
+
+// RecordRefund 更新交易的累计退款金额，全额退款后状态变为已退款
+func (s *PaymentStore) RecordRefund(refund *RefundResult) error {
+	updates := map[string]interface{}{"refunded_amount": refund.TotalRefunded}
+	if toCents(refund.Remaining) == 0 {
+		updates["status"] = PaymentRefunded
+	}
+	return s.db.Model(&PaymentRecord{}).
+		Where("transaction_id = ?", refund.TransactionID).
+		Updates(updates).Error
+}
+
+// History 按账户、支付方式、时间范围查询支付历史，按时间倒序排列
+func (s *PaymentStore) History(filter PaymentHistoryFilter) ([]PaymentRecord, error) {
+	query := s.db.Model(&PaymentRecord{})
+	if filter.Account != "" {
+		query = query.Where("account = ?", filter.Account)
+	}
+	if filter.Method != "" {
+		query = query.Where("method = ?", filter.Method)
+	}
+	if !filter.Start.IsZero() {
+		query = query.Where("created_at >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		query = query.Where("created_at < ?", filter.End)
+	}
+
+	var records []PaymentRecord
+	err := query.Order("created_at DESC").Order("id DESC").Find(&records).Error
+	return records, err
+}
+
+// Scheduler 延时执行任务的调度器
+// advanced/task 中的 TaskScheduler 只负责并发执行已有任务，不支持按时间调度，分期扣款需要延时执行
+type Scheduler interface {
+	Schedule(delay time.Duration, job func())
+}
+
+// timerScheduler 基于 time.AfterFunc 的调度器
+type timerScheduler struct{}
+
+func (timerScheduler) Schedule(delay time.Duration, job func()) {
+	time.AfterFunc(delay, job)
+}
+
+// InstallmentPlanStatus 分期计划状态
+type InstallmentPlanStatus int
+
+const (
+	PlanActive    InstallmentPlanStatus = iota // 正常扣款中
+	PlanOverdue                                // 有一期扣款失败，正在重试
+	PlanCompleted                              // 全部分期已支付
+	PlanDefaulted                              // 某一期重试耗尽，剩余分期不再扣款
+	PlanCancelled                              // 已取消
+)
+
+func (s InstallmentPlanStatus) String() string {
+	switch s {
+	case PlanActive:
+		return "Active"
+	case PlanOverdue:
+		return "Overdue"
+	case PlanCompleted:
+		return "Completed"
+	case PlanDefaulted:
+		return "Defaulted"
+	case PlanCancelled:
+		return "Cancelled"
+	default:
+		return "Unknown"
+	}
+}
+
+// Installment 分期计划中的一期
+type Installment struct {
+	Seq           int           // 期数，从1开始
+	Amount        float64       // 本期金额（请求币种）
+	DueAt         time.Time     // 计划扣款时间
+	Status        PaymentStatus // 本期支付状态
+	Attempts      int           // 已尝试扣款次数
+	TransactionID string        // 扣款成功后的交易号
+	LastError     string        // 最近一次扣款失败原因
+}
+
+// InstallmentPlan 分期付款计划
+type InstallmentPlan struct {
+	ID       string
+	Method   string
+	Request  PaymentRequest // 总金额、币种和付款账户
+	Interval time.Duration
+
+	mu           sync.Mutex
+	status       InstallmentPlanStatus
+	installments []Installment
+	done         chan struct{} // 计划结束（完成、违约或取消）时关闭
+}
+
+// Status 获取计划状态
+func (plan *InstallmentPlan) Status() InstallmentPlanStatus {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	return plan.status
+}
+
+// Installments 获取各期明细的副本
+func (plan *InstallmentPlan) Installments() []Installment {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	return append([]Installment(nil), plan.installments...)
+}
+
+// Paid 获取已支付的期数和金额
+func (plan *InstallmentPlan) Paid() (count int, amount float64) {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	var cents int64
+	for _, inst := range plan.installments {
+		if inst.Status == PaymentSucceeded {
+			count++
+			cents += toCents(inst.Amount)
+		}
+	}
+	return count, float64(cents) / 100
+}
+
+// Remaining 获取未支付的期数和金额
+func (plan *InstallmentPlan) Remaining() (count int, amount float64) {
+	paidCount, paidAmount := plan.Paid()
+	return len(plan.installments) - paidCount, float64(toCents(plan.Request.Amount)-toCents(paidAmount)) / 100
+}
+
+// Done 计划结束时关闭的通道
+func (plan *InstallmentPlan) Done() <-chan struct{} {
+	return plan.done
+}
+
+// Cancel 取消计划，尚未扣款的分期不再执行
+func (plan *InstallmentPlan) Cancel() {
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	if plan.status == PlanActive || plan.status == PlanOverdue {
+		plan.finish(PlanCancelled)
+	}
+}
+
+// finish 结束计划，调用方需持有锁
+func (plan *InstallmentPlan) finish(status InstallmentPlanStatus) {
+	plan.status = status
+	close(plan.done)
+}
+
+// InstallmentManager 分期计划管理器，按计划时间通过支付处理器扣款
+type InstallmentManager struct {
+	process    *PaymentProcess
+	scheduler  Scheduler
+	MaxRetries int           // 单期扣款失败后的最大重试次数
+	RetryDelay time.Duration // 重试间隔
+
+	mu    sync.Mutex
+	seq   int
+	plans map[string]*InstallmentPlan
+}
+
+// NewInstallmentManager 创建分期计划管理器，scheduler 为 nil 时使用 time.AfterFunc
+func NewInstallmentManager(process *PaymentProcess, scheduler Scheduler) *InstallmentManager {
+	if scheduler == nil {
+		scheduler = timerScheduler{}
+	}
+	return &InstallmentManager{
+		process:    process,
+		scheduler:  scheduler,
+		MaxRetries: 2,
+		RetryDelay: time.Second,
+		plans:      make(map[string]*InstallmentPlan),
+	}
+}
+
+// CreateInstallmentPlan 将 req.Amount 分为 n 期，每隔 interval 扣款一次，第一期在 interval 之后
+// 金额按分平均分配，除不尽的部分计入最后一期
+func (m *InstallmentManager) CreateInstallmentPlan(method string, req PaymentRequest, n int, interval time.Duration) (*InstallmentPlan, error) {
+	if req.Amount <= 0 {
+		return nil, ErrorInvalidAmount
+	}
+	if n <= 0 || interval <= 0 {
+		return nil, fmt.Errorf("分期数和间隔必须大于0")
+	}
+	if _, err := m.process.GetPayment(method); err != nil {
+		return nil, err
+	}
+	if req.Currency == "" {
+		req.Currency = CNY
+	}
+
+	total := toCents(req.Amount)
+	if total < int64(n) {
+		return nil, fmt.Errorf("%w: 金额不足以分为 %d 期", ErrorInvalidAmount, n)
+	}
+
+	m.mu.Lock()
+	m.seq++
+	plan := &InstallmentPlan{
+		ID:       fmt.Sprintf("PLAN%s%04d", time.Now().Format("20060102"), m.seq),
+		Method:   method,
+		Request:  req,
+		Interval: interval,
+		status:   PlanActive,
+		done:     make(chan struct{}),
+	}
+	m.plans[plan.ID] = plan
+	m.mu.Unlock()
+
+	now := time.Now()
+	per := total / int64(n)
+	for i := 0; i < n; i++ {
+		cents := per
+		if i == n-1 {
+			cents = total - per*int64(n-1)
+		}
+		plan.installments = append(plan.installments, Installment{
+			Seq:    i + 1,
+			Amount: float64(cents) / 100,
+			DueAt:  now.Add(time.Duration(i+1) * interval),
+			Status: PaymentPending,
+		})
+	}
+
+	m.scheduler.Schedule(interval, func() { m.charge(plan, 0) })
+	return plan, nil
+}
+
+// GetPlan 按编号获取分期计划
+func (m *InstallmentManager) GetPlan(id string) (*InstallmentPlan, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	plan, ok := m.plans[id]
+	return plan, ok
+}
+
+// charge 扣第 index 期，失败时按 RetryDelay 重试，重试耗尽或错误不可重试时计划违约
+func (m *InstallmentManager) charge(plan *InstallmentPlan, index int) {
+	plan.mu.Lock()
+	if plan.status != PlanActive && plan.status != PlanOverdue {
+		plan.mu.Unlock()
+		return
+	}
+	inst := plan.installments[index]
+	plan.mu.Unlock()
+
+	req := plan.Request
+	req.Amount = inst.Amount
+	result, err := m.process.execute(context.Background(), plan.Method, req)
+
+	plan.mu.Lock()
+	defer plan.mu.Unlock()
+	if plan.status != PlanActive && plan.status != PlanOverdue {
+		return // 扣款期间计划被取消
+	}
+
+	current := &plan.installments[index]
+	current.Attempts++
+	if err != nil {
+		current.Status = PaymentFailed
+		current.LastError = err.Error()
+		var payErr *PaymentError
+		if errors.As(err, &payErr) && !payErr.Retryable {
+			fmt.Printf("分期计划 %s 第%d期扣款失败，%s 错误无法重试: %v\n", plan.ID, current.Seq, payErr.Code, err)
+			plan.finish(PlanDefaulted)
+			return
+		}
+		if current.Attempts > m.MaxRetries {
+			fmt.Printf("分期计划 %s 第%d期扣款失败，重试次数已用完: %v\n", plan.ID, current.Seq, err)
+			plan.finish(PlanDefaulted)
+			return
+		}
+		fmt.Printf("分期计划 %s 第%d期扣款失败，%v 后重试: %v\n", plan.ID, current.Seq, m.RetryDelay, err)
+		plan.status = PlanOverdue
+		m.scheduler.Schedule(m.RetryDelay, func() { m.charge(plan, index) })
+		return
+	}
+
+	current.Status = PaymentSucceeded
+	current.TransactionID = result.TransactionID
+	current.LastError = ""
+	fmt.Printf("分期计划 %s 第%d/%d期扣款成功: %.2f %s, 交易号:%s\n", plan.ID, current.Seq,
+		len(plan.installments), current.Amount, req.Currency, result.TransactionID)
+
+	if index == len(plan.installments)-1 {
+		plan.finish(PlanCompleted)
+		return
+	}
+	plan.status = PlanActive
+	// 重试成功后下一期仍按原计划时间扣款，已过期则立即扣款
+	delay := time.Until(plan.installments[index+1].DueAt)
+	if delay < 0 {
+		delay = 0
+	}
+	m.scheduler.Schedule(delay, func() { m.charge(plan, index+1) })
+}
+
+// FeeSummary 某支付方式某一天的交易额与手续费汇总
+type FeeSummary struct {
+	Day      string // 日期，格式 2006-01-02
+	Method   string
+	Currency Currency
+	Count    int     // 成功交易笔数
+	Gross    float64 // 交易总额
+	Fee      float64 // 手续费合计
+	Net      float64 // 到账金额合计
+}
+
+// FeeReport 按支付方式和日期汇总 [start, end) 内成功交易的交易额、手续费和到账金额
+// 结果按日期、支付方式、币种排序
+func (s *PaymentStore) FeeReport(start, end time.Time) ([]FeeSummary, error) {
+	records, err := s.History(PaymentHistoryFilter{Start: start, End: end})
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		day, method string
+		currency    Currency
+	}
+	type sum struct {
+		count           int
+		gross, fee, net int64 // 以分为单位累加，避免浮点误差
+	}
+	sums := make(map[key]*sum)
+	for _, rec := range records {
+		if rec.Status != PaymentSucceeded && rec.Status != PaymentRefunded {
+			continue
+		}
+		k := key{rec.CreatedAt.Local().Format("2006-01-02"), rec.Method, rec.Currency}
+		if sums[k] == nil {
+			sums[k] = &sum{}
+		}
+		sums[k].count++
+		sums[k].gross += toCents(rec.Amount)
+		sums[k].fee += toCents(rec.Fee)
+		sums[k].net += toCents(rec.NetAmount)
+	}
+
+	report := make([]FeeSummary, 0, len(sums))
+	for k, v := range sums {
+		report = append(report, FeeSummary{
+			Day:      k.day,
+			Method:   k.method,
+			Currency: k.currency,
+			Count:    v.count,
+			Gross:    float64(v.gross) / 100,
+			Fee:      float64(v.fee) / 100,
+			Net:      float64(v.net) / 100,
+		})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Currency < b.Currency
+	})
+	return report, nil
+}
+
+// SettlementEntry 渠道对账单中的一条结算记录
+type SettlementEntry struct {
+	TransactionID string
+	Amount        float64
+	Currency      Currency
+	SettledAt     time.Time
+}
+
+// ParseSettlementCSV 解析渠道提供的 CSV 对账单
+// 第一行为表头，列依次为：transaction_id,amount,currency,settled_at（时间格式 2006-01-02 15:04:05）
+func ParseSettlementCSV(r io.Reader) ([]SettlementEntry, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("读取对账单失败: %w", err)
+	}
+
+	var entries []SettlementEntry
+	for i, row := range rows {
+		if i == 0 {
+			continue // 跳过表头
+		}
+		if len(row) < 4 {
+			return nil, fmt.Errorf("对账单第 %d 行列数不足", i+1)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("对账单第 %d 行金额无效: %w", i+1, err)
+		}
+		settledAt, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(row[3]), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("对账单第 %d 行时间无效: %w", i+1, err)
+		}
+		entries = append(entries, SettlementEntry{
+			TransactionID: strings.TrimSpace(row[0]),
+			Amount:        amount,
+			Currency:      Currency(strings.TrimSpace(row[2])),
+			SettledAt:     settledAt,
+		})
+	}
+	return entries, nil
+}
+
+// AmountMismatch 本地记录与对账单金额不一致的交易
+type AmountMismatch struct {
+	TransactionID string
+	LocalAmount   float64
+	SettledAmount float64
+}
+
+// ReconciliationReport 对账报告
+type ReconciliationReport struct {
+	Method                 string
+	Matched                int               // 一致的交易数
+	MissingLocally         []SettlementEntry // 对账单中有、本地没有成功记录的交易
+	MissingInSettlement    []PaymentRecord   // 本地成功、对账单中没有的交易
+	DuplicatedInSettlement []string          // 对账单中重复出现的交易号
+	DuplicatedLocally      []string          // 本地重复记录的交易号
+	AmountMismatches       []AmountMismatch  // 金额不一致的交易
+}
+
+// HasDiscrepancies 是否存在差异
+func (r *ReconciliationReport) HasDiscrepancies() bool {
+	return len(r.MissingLocally) > 0 || len(r.MissingInSettlement) > 0 ||
+		len(r.DuplicatedInSettlement) > 0 || len(r.DuplicatedLocally) > 0 || len(r.AmountMismatches) > 0
+}
+
+// Print 输出对账报告
+func (r *ReconciliationReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "对账报告 [%s]: 一致 %d 笔\n", r.Method, r.Matched)
+	for _, e := range r.MissingLocally {
+		fmt.Fprintf(w, "  本地缺失: %s %.2f %s\n", e.TransactionID, e.Amount, e.Currency)
+	}
+	for _, rec := range r.MissingInSettlement {
+		fmt.Fprintf(w, "  对账单缺失: %s %.2f %s\n", rec.TransactionID, rec.Amount, rec.Currency)
+	}
+	for _, id := range r.DuplicatedInSettlement {
+		fmt.Fprintf(w, "  对账单重复: %s\n", id)
+	}
+	for _, id := range r.DuplicatedLocally {
+		fmt.Fprintf(w, "  本地重复: %s\n", id)
+	}
+	for _, m := range r.AmountMismatches {
+		fmt.Fprintf(w, "  金额不一致: %s 本地 %.2f, 对账单 %.2f\n", m.TransactionID, m.LocalAmount, m.SettledAmount)
+	}
+	if !r.HasDiscrepancies() {
+		fmt.Fprintln(w, "  无差异")
+	}
+}
+
+// Reconcile 将指定支付方式在 [start, end) 内的本地成功交易与对账单比对
+func (s *PaymentStore) Reconcile(method string, start, end time.Time, entries []SettlementEntry) (*ReconciliationReport, error) {
+	records, err := s.History(PaymentHistoryFilter{Method: method, Start: start, End: end})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconciliationReport{Method: method}
+
+	// 本地已成功（含已退款）的交易，按交易号索引
+	local := make(map[string]PaymentRecord)
+	for _, rec := range records {
+		if rec.TransactionID == "" || (rec.Status != PaymentSucceeded && rec.Status != PaymentRefunded) {
+			continue
+		}
+		if _, exists := local[rec.TransactionID]; exists {
+			report.DuplicatedLocally = append(report.DuplicatedLocally, rec.TransactionID)
+			continue
+		}
+		local[rec.TransactionID] = rec
+	}
+
+	settled := make(map[string]bool)
+	for _, entry := range entries {
+		if settled[entry.TransactionID] {
+			report.DuplicatedInSettlement = append(report.DuplicatedInSettlement, entry.TransactionID)
+			continue
+		}
+		settled[entry.TransactionID] = true
+
+		rec, exists := local[entry.TransactionID]
+		switch {
+		case !exists:
+			report.MissingLocally = append(report.MissingLocally, entry)
+		case toCents(rec.Amount) != toCents(entry.Amount):
+			report.AmountMismatches = append(report.AmountMismatches, AmountMismatch{
+				TransactionID: entry.TransactionID,
+				LocalAmount:   rec.Amount,
+				SettledAmount: entry.Amount,
+			})
+		default:
+			report.Matched++
+		}
+	}
+
+	for _, rec := range records {
+		if _, ok := local[rec.TransactionID]; ok && !settled[rec.TransactionID] {
+			report.MissingInSettlement = append(report.MissingInSettlement, rec)
+			delete(local, rec.TransactionID) // 避免重复记录的交易被报告两次
+		}
+	}
+	return report, nil
+}
+
+// createPaymentRequest POST /payments 请求体
+//
+//	{"method":"alipay","amount":10.5,"currency":"CNY","account":"zhangsan","order_id":"...","coupon_code":"..."}
+type createPaymentRequest struct {
+	Method     string        `json:"method"`
+	Amount     float64       `json:"amount"`
+	Currency   Currency      `json:"currency,omitempty"`
+	Account    string        `json:"account,omitempty"`
+	OrderID    string        `json:"order_id,omitempty"`
+	Items      []ReceiptItem `json:"items,omitempty"`
+	CouponCode string        `json:"coupon_code,omitempty"`
+}
+
+// paymentResponse 支付结果响应体
+type paymentResponse struct {
+	TransactionID    string   `json:"transaction_id"`
+	Provider         string   `json:"provider"`
+	Status           string   `json:"status"`
+	Amount           float64  `json:"amount"`
+	Currency         Currency `json:"currency"`
+	OriginalAmount   float64  `json:"original_amount"`
+	OriginalCurrency Currency `json:"original_currency"`
+	Fee              float64  `json:"fee"`
+	Receipt          *Receipt `json:"receipt,omitempty"`
+}
+
+// paymentStatusResponse GET /payments/{method}/{id} 响应体
+type paymentStatusResponse struct {
+	TransactionID string `json:"transaction_id"`
+	Method        string `json:"method"`
+	Status        string `json:"status"`
+}
+
+// refundRequest POST /refunds 请求体
+//
+//	{"method":"wechat","transaction_id":"WX...","amount":40}
+type refundRequest struct {
+	Method        string  `json:"method"`
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+}
+
+// refundResponse 退款结果响应体
+type refundResponse struct {
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	TotalRefunded float64 `json:"total_refunded"`
+	Remaining     float64 `json:"remaining"`
+}
+
+// errorResponse 错误响应体
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// idempotencyTTL Idempotency-Key 的响应缓存时间，过期后相同的 key 作为新请求处理
+const idempotencyTTL = 24 * time.Hour
+
+// idempotentResponse 按 Idempotency-Key 缓存的响应
+type idempotentResponse struct {
+	done        chan struct{} // 第一次请求处理完成后关闭，处理过程中 panic 也会关闭
+	fingerprint string        // 请求体摘要，同一个 key 只能用于相同的请求
+	status      int
+	body        []byte
+	panicked    bool      // 第一次请求处理时 panic，没有可重放的响应
+	expiresAt   time.Time // 处理完成后设置，过期后删除
+}
+
+// PaymentGateway 以 HTTP 接口对外提供支付处理能力
+//
+//	POST /payments                 发起支付
+//	GET  /payments/{method}/{id}   查询交易状态
+//	POST /refunds                  退款
+//	POST /callbacks                接收支付渠道的回调
+//	GET  /metrics                  Prometheus 格式的支付统计
+//
+// POST /payments 和 POST /refunds 支持 Idempotency-Key 请求头：相同 key 的重复请求直接返回第一次的响应，
+// 同一个 key 携带不同的请求体时返回 422；响应保留 idempotencyTTL
+type PaymentGateway struct {
+	process *PaymentProcess
+	mux     *http.ServeMux
+
+	mu          sync.Mutex
+	idempotency map[string]*idempotentResponse
+	swept       time.Time // 上次清理过期响应的时间
+}
+
+// NewPaymentGateway 创建 HTTP 支付网关，dispatcher 为 nil 时不提供回调接口
+func NewPaymentGateway(process *PaymentProcess, dispatcher *CallbackDispatcher) *PaymentGateway {
+	g := &PaymentGateway{
+		process:     process,
+		mux:         http.NewServeMux(),
+		idempotency: make(map[string]*idempotentResponse),
+	}
+	g.mux.HandleFunc("POST /payments", g.idempotent(g.handleCreatePayment))
+	g.mux.HandleFunc("GET /payments/{method}/{id}", g.handleQueryStatus)
+	g.mux.HandleFunc("POST /refunds", g.idempotent(g.handleRefund))
+	g.mux.Handle("GET /metrics", process.Metrics())
+	if dispatcher != nil {
+		g.mux.Handle("POST /callbacks", dispatcher)
+	}
+	return g
+}
+
+// ServeHTTP 实现 http.Handler 接口
+func (g *PaymentGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// idempotent 为 POST 接口增加幂等处理，handler 返回状态码和响应体
+func (g *PaymentGateway) idempotent(handler func(r *http.Request, body []byte) (int, interface{})) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "读取请求体失败"})
+			return
+		}
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			status, resp := handler(r, body)
+			writeJSON(w, status, resp)
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+		key = r.URL.Path + "|" + key
+
+		now := time.Now()
+		g.mu.Lock()
+		g.sweepIdempotencyLocked(now)
+		entry, exists := g.idempotency[key]
+		if exists && !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+			exists = false
+		}
+		if !exists {
+			entry = &idempotentResponse{done: make(chan struct{}), fingerprint: fingerprint}
+			g.idempotency[key] = entry
+		}
+		g.mu.Unlock()
+
+		if exists {
+			if entry.fingerprint != fingerprint {
+				writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: "Idempotency-Key 已用于不同的请求"})
+				return
+			}
+			<-entry.done // 等待第一次请求处理完成
+			if entry.panicked {
+				writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "第一次请求处理失败，请重试"})
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeRaw(w, entry.status, entry.body)
+			return
+		}
+
+		// handler panic 时删除记录，让等待中的重放请求返回错误，之后使用相同 key 的重试重新处理
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			g.mu.Lock()
+			delete(g.idempotency, key)
+			g.mu.Unlock()
+			entry.panicked = true
+			close(entry.done)
+		}()
+
+		status, resp := handler(r, body)
+		entry.status = status
+		entry.body, _ = json.Marshal(resp)
+		g.mu.Lock()
+		entry.expiresAt = time.Now().Add(idempotencyTTL)
+		g.mu.Unlock()
+		completed = true
+		close(entry.done)
+		writeRaw(w, entry.status, entry.body)
+	}
+}
+
+// sweepIdempotencyLocked 删除过期的响应，每分钟最多清理一次，调用方需持有锁
+func (g *PaymentGateway) sweepIdempotencyLocked(now time.Time) {
+	if now.Sub(g.swept) < time.Minute {
+		return
+	}
+	g.swept = now
+	for key, entry := range g.idempotency {
+		if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+			delete(g.idempotency, key)
+		}
+	}
+}
+
+// handleCreatePayment 处理 POST /payments
+func (g *PaymentGateway) handleCreatePayment(r *http.Request, body []byte) (int, interface{}) {
+	var in createPaymentRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return http.StatusBadRequest, errorResponse{Error: "请求体不是合法的 JSON"}
+	}
+	result, err := g.process.Charge(r.Context(), in.Method, PaymentRequest{
+		Amount:     in.Amount,
+		Currency:   in.Currency,
+		Account:    in.Account,
+		OrderID:    in.OrderID,
+		Items:      in.Items,
+		CouponCode: in.CouponCode,
+	})
+	if err != nil {
+		return httpStatus(err), errorResponse{Error: err.Error()}
+	}
+	return http.StatusCreated, paymentResponse{
+		TransactionID:    result.TransactionID,
+		Provider:         result.Provider,
+		Status:           result.Status.String(),
+		Amount:           result.Amount,
+		Currency:         result.Currency,
+		OriginalAmount:   result.OriginalAmount,
+		OriginalCurrency: result.OriginalCurrency,
+		Fee:              result.Fee,
+		Receipt:          result.Receipt,
+	}
+}
+
+// handleQueryStatus 处理 GET /payments/{method}/{id}
+func (g *PaymentGateway) handleQueryStatus(w http.ResponseWriter, r *http.Request) {
+	method, id := r.PathValue("method"), r.PathValue("id")
+	payment, err := g.process.GetPayment(method)
+	if err != nil {
+		writeJSON(w, httpStatus(err), errorResponse{Error: err.Error()})
+		return
+	}
+	status, err := payment.QueryStatus(id)
+	if err != nil {
+		writeJSON(w, httpStatus(err), errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, paymentStatusResponse{TransactionID: id, Method: method, Status: status.String()})
+}
+
+// handleRefund 处理 POST /refunds
+func (g *PaymentGateway) handleRefund(r *http.Request, body []byte) (int, interface{}) {
+	var in refundRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return http.StatusBadRequest, errorResponse{Error: "请求体不是合法的 JSON"}
+	}
+	result, err := g.process.Refund(in.Method, in.TransactionID, in.Amount)
+	if err != nil {
+		return httpStatus(err), errorResponse{Error: err.Error()}
+	}
+	return http.StatusOK, refundResponse{
+		TransactionID: result.TransactionID,
+		Amount:        result.Amount,
+		TotalRefunded: result.TotalRefunded,
+		Remaining:     result.Remaining,
+	}
+}
+
+// httpStatus 将支付错误映射为 HTTP 状态码
+func httpStatus(err error) int {
+	var payErr *PaymentError
+	if errors.As(err, &payErr) {
+		switch payErr.Code {
+		case ErrCodeTimeout:
+			return http.StatusGatewayTimeout
+		case ErrCodeProviderUnavailable:
+			return http.StatusServiceUnavailable
+		case ErrCodeInvalidInstrument:
+			return http.StatusBadRequest
+		}
+	}
+	switch {
+	case errors.Is(err, ErrorPaymentNotFound), errors.Is(err, ErrorTransactionNotFound), errors.Is(err, ErrorCouponNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrorInvalidAmount), errors.Is(err, ErrorUnsupportedCurrency),
+		errors.Is(err, ErrorCouponExpired), errors.Is(err, ErrorCouponUsed), errors.Is(err, ErrorCouponNotApplicable):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrorRefundExceeded), errors.Is(err, ErrorNotRefundable):
+		return http.StatusConflict
+	case errors.Is(err, ErrPaymentTimeout), errors.Is(err, ErrorPaymentPending):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrorInvalidSignature):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusPaymentRequired
+	}
+}
+
+// writeJSON 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRaw(w, status, body)
+}
+
+// writeRaw 输出已编码的 JSON 响应
+func writeRaw(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func main() {
+	fmt.Println("=== 支付系统demo ===")
+
+	// 银行卡的清算和退款结果通过回调通知
+	dispatcher := NewCallbackDispatcher("callback-secret")
+	dispatcher.Register(CallbackHandlerFunc(func(cb PaymentCallback) error {
+		fmt.Printf("收到回调: 交易:%s 序号:%d 状态:%s 金额:%.2f元\n", cb.TransactionID, cb.Sequence, cb.Status, cb.Amount)
+		return nil
+	}))
+	bankCard := NewBankCard("62134456885454", "招商银行")
+	bankCard.SetCallbackDispatcher(dispatcher)
+
+	// 支付记录保存到 SQLite
+	db, err := gorm.Open(sqlite.Open("payment.db"), &gorm.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := NewPaymentStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 通过注册表按名称启用支付方式
+	var process = NewPaymentProcess()
+	process.SetStore(store)
+	process.SetEnvironment(ParseEnvironment(os.Getenv("PAYMENT_ENV"))) // 默认沙箱环境
+	fmt.Printf("运行环境: %s\n", process.Environment())
+	fmt.Printf("已注册的支付方式: %v\n", DefaultPaymentRegistry.Names())
+	if err := process.UsePayment("alipay", PaymentConfig{"account": "1111111@alipay.com"}); err != nil {
+		fmt.Printf("启用支付宝失败: %v\n", err)
+	}
+	if err := process.UsePayment("wechat", PaymentConfig{"open_id": "openid_123456"}); err != nil {
+		fmt.Printf("启用微信支付失败: %v\n", err)
+	}
+	process.AddPayment("bankcard", bankCard)
+	process.UsePayment("unionpay", PaymentConfig{"merchant_id": "777290058110048", "terminal_id": "00000001", "sandbox": "true"})
+	process.UsePayment("paypal", PaymentConfig{"client_id": "demo-client", "client_secret": "demo-secret", "sandbox": "true"})
+	process.UsePayment("stripe", PaymentConfig{"api_key": "sk_test_demo"})
+
+	// 请求报文签名：银联使用 HMAC 共享密钥，PayPal 使用商户 RSA 私钥
+	merchantKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	requestSigners := map[string]Signer{
+		"unionpay": NewHMACSigner("unionpay-request-secret"),
+		"paypal":   NewRSASigner(merchantKey),
+	}
+	for method, signer := range requestSigners {
+		if payment, err := process.GetPayment(method); err == nil {
+			if p, ok := payment.(interface{ SetRequestSigner(Signer) }); ok {
+				p.SetRequestSigner(signer)
+			}
+		}
+	}
+
+	// 各渠道手续费
+	process.SetFeeSchedule("alipay", FeeSchedule{Percent: 0.6})
+	process.SetFeeSchedule("wechat", FeeSchedule{Percent: 0.6})
+	process.SetFeeSchedule("bankcard", FeeSchedule{Percent: 0.5, Fixed: 0.10})
+	process.SetFeeSchedule("unionpay", FeeSchedule{Percent: 0.55})
+	process.SetFeeSchedule("paypal", FeeSchedule{Percent: 3.49, Fixed: 0.49})
+	process.SetFeeSchedule("stripe", FeeSchedule{Percent: 2.9, Fixed: 0.30})
+
+	// 订阅支付事件：统计各类事件数量，退款时发送通知
+	var eventMu sync.Mutex
+	eventCounts := make(map[PaymentEventType]int)
+	process.Subscribe(func(e PaymentEvent) {
+		eventMu.Lock()
+		defer eventMu.Unlock()
+		eventCounts[e.Type]++
+	})
+	process.Subscribe(func(e PaymentEvent) {
+		fmt.Printf("[通知] %s 交易 %s 已退款 %.2f %s\n", e.Provider, e.TransactionID, e.Amount, e.Currency)
+	}, EventPaymentRefunded)
+
+	// 使用不同的支付方式
+	ctx := context.Background()
+	runStart := time.Now()
+	methods := []string{"alipay", "wechat", "bankcard", "unionpay", "paypal", "stripe"}
+	requests := []PaymentRequest{
+		{Amount: 10.30, Currency: CNY, Account: "zhangsan"},
+		{Amount: 140.00, Account: "zhangsan"}, // 未指定币种，按人民币处理
+		{Amount: 20.00, Currency: USD, Account: "lisi"},
+		{Amount: 66.60, Account: "lisi"},
+		{Amount: 100.00, Currency: CNY, Account: "zhangsan"}, // PayPal 以美元结算，自动换算
+		{Amount: 0.30, Currency: USD, Account: "wangwu"},     // 低于 Stripe 最低金额，被网关拒绝
+	}
+	results := make(map[string]*PaymentResult)
+	for i, method := range methods {
+		results[method] = process.ProcessPayment(ctx, method, requests[i])
+	}
+	process.ProcessPayment(ctx, "applepay", PaymentRequest{Amount: 1.00}) // 未启用的支付方式
+
+	// 超时与取消：银联网关响应需要约100毫秒，超时时间设为50毫秒
+	process.SetTimeout("unionpay", 50*time.Millisecond)
+	process.ProcessPayment(ctx, "unionpay", PaymentRequest{Amount: 8.80, Account: "lisi"})
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	process.ProcessPayment(cancelled, "alipay", PaymentRequest{Amount: 8.80, Account: "zhangsan"})
+
+	// 模拟支付：每3次调用失败一次，第2次调用返回指定错误码，随机延迟0~150毫秒，超过100毫秒即超时
+	fmt.Println("\n=== 模拟支付demo ===")
+	process.AddPayment("mock", NewMockPay(MockPayConfig{
+		FailEvery:  3,
+		ErrorCodes: map[int]string{2: "card_declined"},
+		MaxLatency: 150 * time.Millisecond,
+		Seed:       42,
+	}))
+	process.SetTimeout("mock", 100*time.Millisecond)
+	for i := 0; i < 6; i++ {
+		result, err := process.Charge(ctx, "mock", PaymentRequest{Amount: 5.00, Account: "tester"})
+		var payErr *PaymentError
+		switch {
+		case err == nil:
+			fmt.Println(result.Message)
+		case errors.As(err, &payErr):
+			fmt.Printf("%v [分类:%s 可重试:%v]\n", err, payErr.Code, payErr.Retryable)
+		default:
+			fmt.Println(err)
+		}
+	}
+	// 先返回处理中，查询3次后成功
+	process.AddPayment("mock-pending", NewMockPay(MockPayConfig{PendingPolls: 3}))
+	process.ProcessPayment(ctx, "mock-pending", PaymentRequest{Amount: 5.00, Account: "tester"})
+
+	// 收据：带订单明细的银行卡支付
+	fmt.Println("\n=== 收据demo ===")
+	if r := process.ProcessPayment(ctx, "bankcard", PaymentRequest{
+		Amount:  128.00,
+		Account: "lisi",
+		OrderID: "ORD20250001",
+		Items: []ReceiptItem{
+			{Name: "Go语言编程", Quantity: 1, UnitPrice: 89.00},
+			{Name: "笔记本", Quantity: 3, UnitPrice: 13.00},
+		},
+	}); r != nil {
+		r.Receipt.Render(os.Stdout)
+		if data, err := r.Receipt.JSON(); err == nil {
+			fmt.Println(string(data))
+		}
+	}
+
+	// 优惠券：满减、折扣、过期和一次性使用
+	fmt.Println("\n=== 优惠券demo ===")
+	process.SetDiscountEngine(NewDiscountEngine(
+		Coupon{Code: "FULL100-20", Type: CouponThreshold, Threshold: 100, Value: 20},
+		Coupon{Code: "VIP10", Type: CouponPercentage, Value: 10, MaxDiscount: 15},
+		Coupon{Code: "NEWUSER5", Type: CouponFixed, Value: 5, SingleUse: true},
+		Coupon{Code: "EXPIRED", Type: CouponFixed, Value: 5, ExpiresAt: time.Now().Add(-time.Hour)},
+	))
+	for _, req := range []PaymentRequest{
+		{Amount: 120.00, Account: "zhangsan", CouponCode: "FULL100-20"},
+		{Amount: 80.00, Account: "zhangsan", CouponCode: "FULL100-20"}, // 未达到满减门槛
+		{Amount: 200.00, Account: "lisi", CouponCode: "VIP10"},         // 九折，最多优惠15元
+		{Amount: 30.00, Account: "wangwu", CouponCode: "NEWUSER5"},
+		{Amount: 30.00, Account: "wangwu", CouponCode: "NEWUSER5"}, // 已使用
+		{Amount: 30.00, Account: "wangwu", CouponCode: "EXPIRED"},
+	} {
+		if r := process.ProcessPayment(ctx, "alipay", req); r != nil {
+			fmt.Printf("  原价:%.2f 优惠:%.2f 实付:%.2f\n", req.Amount, r.Receipt.Discount, r.Amount)
+		}
+	}
+
+	// 账户余额支付：扣款和退款都作用在银行账户上
+	fmt.Println("\n=== 余额支付demo ===")
+	ledger := bank.NewBank()
+	ledger.OpenAccount("6222020200001234", "张三", 100.00)
+	process.AddPayment("balance", NewBalancePay(ledger, "6222020200001234"))
+	if r := process.ProcessPayment(ctx, "balance", PaymentRequest{Amount: 60.00, Account: "zhangsan"}); r != nil {
+		process.ProcessPayment(ctx, "balance", PaymentRequest{Amount: 60.00, Account: "zhangsan"}) // 余额不足
+		process.ProcessRefund("balance", r.TransactionID, 25.00)
+	}
+
+	// HTTP 网关：同一个 Idempotency-Key 重复提交只扣款一次
+	fmt.Println("\n=== HTTP网关demo ===")
+	server := httptest.NewServer(NewPaymentGateway(process, dispatcher))
+	post := func(path, key, body string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Printf("POST %s 失败: %v\n", path, err)
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		fmt.Printf("POST %s -> %d %s %s\n", path, resp.StatusCode, resp.Header.Get("Idempotent-Replayed"), data)
+	}
+	post("/payments", "order-1001", `{"method":"wechat","amount":18.8,"account":"lisi"}`)
+	post("/payments", "order-1001", `{"method":"wechat","amount":18.8,"account":"lisi"}`) // 重放，不会再次扣款
+	post("/payments", "order-1001", `{"method":"wechat","amount":99,"account":"lisi"}`)   // 同一个 key 不同请求
+	post("/payments", "", `{"method":"applepay","amount":1}`)
+	post("/refunds", "refund-1", `{"method":"alipay","transaction_id":"ALI-NOT-EXIST","amount":1}`)
+	if resp, err := http.Get(server.URL + "/payments/alipay/ALI-NOT-EXIST"); err == nil {
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Printf("GET /payments/alipay/ALI-NOT-EXIST -> %d %s\n", resp.StatusCode, data)
+	}
+	server.Close()
+
+	// 重复支付检测：2秒内相同订单的第二次支付直接返回第一次的结果，不同订单正常扣款
+	fmt.Println("\n=== 重复支付检测demo ===")
+	process.SetDuplicateWindow(2 * time.Second)
+	var dupWG sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		dupWG.Add(1)
+		go func() {
+			defer dupWG.Done()
+			process.ProcessPayment(ctx, "alipay", PaymentRequest{Amount: 66.00, Account: "zhangsan", OrderID: "ORD-DUP-1"})
+		}()
+	}
+	dupWG.Wait()
+	process.ProcessPayment(ctx, "alipay", PaymentRequest{Amount: 66.00, Account: "zhangsan", OrderID: "ORD-DUP-1"})
+	process.ProcessPayment(ctx, "alipay", PaymentRequest{Amount: 66.00, Account: "zhangsan", OrderID: "ORD-DUP-2"})
+	process.SetDuplicateWindow(0)
+
+	// 扫码支付：回调确认付款、轮询确认付款、超时未付款
+	fmt.Println("\n=== 扫码支付demo ===")
+	qrWithCallback := NewQRPaymentService(process, 500*time.Millisecond)
+	qrWithCallback.SetCallbackDispatcher(dispatcher)
+	qrPolling := NewQRPaymentService(process, 500*time.Millisecond)
+	sessionA, _ := qrWithCallback.CreateQRPayment("alipay", PaymentRequest{Amount: 15.00, Account: "zhangsan"})
+	sessionB, _ := qrPolling.CreateQRPayment("wechat", PaymentRequest{Amount: 25.00, Account: "lisi"})
+	sessionC, _ := qrPolling.CreateQRPayment("wechat", PaymentRequest{Amount: 35.00, Account: "wangwu"})
+	for _, s := range []*QRSession{sessionA, sessionB, sessionC} {
+		fmt.Printf("二维码 %s: %s, 金额:%.2f\n", s.ID, s.Payload, s.Request.Amount)
+	}
+	qrWithCallback.Scan(ctx, sessionA.ID)
+	statusA, _ := qrWithCallback.Status(sessionA.ID)
+	fmt.Printf("二维码 %s 状态(回调): %s\n", sessionA.ID, statusA)
+	go qrPolling.Scan(ctx, sessionB.ID)
+	statusB, _ := qrPolling.WaitPaid(ctx, sessionB.ID)
+	fmt.Printf("二维码 %s 状态(轮询): %s\n", sessionB.ID, statusB)
+	statusC, _ := qrPolling.WaitPaid(ctx, sessionC.ID) // 无人扫码，等待过期
+	fmt.Printf("二维码 %s 状态: %s\n", sessionC.ID, statusC)
+	if err := qrPolling.Scan(ctx, sessionC.ID); err != nil {
+		fmt.Printf("扫码失败: %v\n", err)
+	}
+
+	// 沙箱环境下不能使用生产密钥
+	if live, err := NewStripe(StripeConfig{APIKey: "sk_live_demo"}); err == nil {
+		process.AddPayment("stripe-live", live)
+		process.ProcessPayment(ctx, "stripe-live", PaymentRequest{Amount: 10.00, Currency: USD, Account: "wangwu"})
+	}
+
+	// 部分退款：微信支付的订单分两次退款，第三次超额退款会被拒绝
+	fmt.Println("\n=== 退款demo ===")
+	if wx := results["wechat"]; wx != nil {
+		process.ProcessRefund("wechat", wx.TransactionID, 40.00)
+		process.ProcessRefund("wechat", wx.TransactionID, 100.00)
+		process.ProcessRefund("wechat", wx.TransactionID, 0.01)
+		if payment, err := process.GetPayment("wechat"); err == nil {
+			if status, err := payment.QueryStatus(wx.TransactionID); err == nil {
+				fmt.Printf("交易 %s 当前状态: %s\n", wx.TransactionID, status)
+			}
+		}
+	}
+	process.ProcessRefund("alipay", "ALI-NOT-EXIST", 1.00)
+
+	// 与渠道对账单核对
+	fmt.Println("\n=== 对账demo ===")
+	var alipayTxs []*PaymentResult
+	for _, amount := range []float64{12.00, 30.50, 45.00} {
+		if r := process.ProcessPayment(ctx, "alipay", PaymentRequest{Amount: amount, Account: "zhaoliu"}); r != nil {
+			alipayTxs = append(alipayTxs, r)
+		}
+	}
+	if len(alipayTxs) == 3 {
+		// 模拟渠道对账单：第1笔重复出现，第2笔金额不一致，第3笔缺失，另有一笔本地不存在的交易
+		settledAt := time.Now().Format("2006-01-02 15:04:05")
+		csvData := "transaction_id,amount,currency,settled_at\n" +
+			fmt.Sprintf("%s,12.00,CNY,%s\n", alipayTxs[0].TransactionID, settledAt) +
+			fmt.Sprintf("%s,12.00,CNY,%s\n", alipayTxs[0].TransactionID, settledAt) +
+			fmt.Sprintf("%s,35.50,CNY,%s\n", alipayTxs[1].TransactionID, settledAt) +
+			fmt.Sprintf("ALI-UNKNOWN-0001,9.90,CNY,%s\n", settledAt)
+		entries, err := ParseSettlementCSV(strings.NewReader(csvData))
+		if err != nil {
+			fmt.Printf("解析对账单失败: %v\n", err)
+		} else if report, err := store.Reconcile("alipay", runStart, time.Now(), entries); err != nil {
+			fmt.Printf("对账失败: %v\n", err)
+		} else {
+			report.Print(os.Stdout)
+		}
+	}
+
+	// 分期付款：正常分3期，以及每期都低于 Stripe 最低金额导致违约
+	fmt.Println("\n=== 分期付款demo ===")
+	installments := NewInstallmentManager(process, nil)
+	installments.MaxRetries, installments.RetryDelay = 1, 50*time.Millisecond
+	var plans []*InstallmentPlan
+	for _, p := range []struct {
+		method string
+		req    PaymentRequest
+	}{
+		{"alipay", PaymentRequest{Amount: 100.00, Account: "zhangsan"}},
+		{"stripe", PaymentRequest{Amount: 0.90, Currency: USD, Account: "wangwu"}},
+	} {
+		plan, err := installments.CreateInstallmentPlan(p.method, p.req, 3, 100*time.Millisecond)
+		if err != nil {
+			fmt.Printf("创建分期计划失败: %v\n", err)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	for _, plan := range plans {
+		<-plan.Done()
+		paidCount, paidAmount := plan.Paid()
+		remainCount, remainAmount := plan.Remaining()
+		fmt.Printf("分期计划 %s [%s] 状态:%s 已付:%d期/%.2f 剩余:%d期/%.2f\n", plan.ID, plan.Method,
+			plan.Status(), paidCount, paidAmount, remainCount, remainAmount)
+	}
+
+	// 支付路由：银联仍是50毫秒超时，会一直失败
+	fmt.Println("\n=== 支付路由demo ===")
+	metrics := NewRouterMetrics()
+	for _, strategy := range []RoutingStrategy{LowestFeeStrategy{MinSuccessRate: 0.3}, SuccessRateStrategy{}, &RoundRobinStrategy{}} {
+		router := NewRouter(process, strategy, metrics, "alipay", "wechat", "unionpay")
+		for i := 0; i < 3; i++ {
+			router.ProcessPayment(ctx, PaymentRequest{Amount: 20.00, Account: "zhaoliu"})
+		}
+		fmt.Printf("策略 %s 选择: %v\n", strategy.Name(), metrics.Picks(strategy.Name()))
+	}
+	for _, method := range []string{"alipay", "wechat", "unionpay"} {
+		stats := metrics.Stats(method)
+		fmt.Printf("%-8s 尝试:%d 成功:%d 成功率:%.2f\n", method, stats.Attempts, stats.Successes, stats.SuccessRate())
+	}
+
+	eventMu.Lock()
+	fmt.Printf("\n支付事件统计: 发起:%d 成功:%d 失败:%d 退款:%d\n", eventCounts[EventPaymentCreated],
+		eventCounts[EventPaymentSucceeded], eventCounts[EventPaymentFailed], eventCounts[EventPaymentRefunded])
+	eventMu.Unlock()
+
+	// 各支付方式的调用统计
+	fmt.Println("\n=== 支付统计 ===")
+	paymentStats := process.Metrics().Stats()
+	statMethods := make([]string, 0, len(paymentStats))
+	for method := range paymentStats {
+		statMethods = append(statMethods, method)
+	}
+	sort.Strings(statMethods)
+	for _, method := range statMethods {
+		s := paymentStats[method]
+		fmt.Printf("%-12s 调用:%-3d 成功率:%5.1f%% 平均耗时:%-6v P95:%v\n", method, s.Calls,
+			s.SuccessRate*100, s.AvgLatency.Round(time.Millisecond), s.P95Latency)
+	}
+	var prom strings.Builder
+	process.Metrics().WritePrometheus(&prom)
+	for _, line := range strings.Split(prom.String(), "\n") {
+		if strings.Contains(line, `method="alipay"`) && !strings.Contains(line, "_bucket") {
+			fmt.Println(line)
+		}
+	}
+
+	// 查询支付历史
+	fmt.Println("\n=== 支付历史 ===")
+	today := time.Now().Truncate(24 * time.Hour)
+	history, err := store.History(PaymentHistoryFilter{Account: "zhangsan", Start: today})
+	if err != nil {
+		fmt.Printf("查询支付历史失败: %v\n", err)
+	}
+	for _, r := range history {
+		fmt.Printf("%s %-8s %-10s %8.2f %s 已退款:%.2f 状态:%s %s\n", r.CreatedAt.Format("15:04:05"),
+			r.Method, r.TransactionID, r.Amount, r.Currency, r.RefundedAmount, r.Status, r.ErrorMessage)
+	}
+
+	// 手续费与到账金额汇总
+	fmt.Println("\n=== 手续费报表 ===")
+	feeReport, err := store.FeeReport(runStart, time.Now().Add(time.Second))
+	if err != nil {
+		fmt.Printf("查询手续费报表失败: %v\n", err)
+	}
+	for _, s := range feeReport {
+		fmt.Printf("%s %-8s %s %d笔 交易额:%.2f 手续费:%.2f 到账:%.2f\n",
+			s.Day, s.Method, s.Currency, s.Count, s.Gross, s.Fee, s.Net)
+	}
+
+	// 回调乱序、重复到达以及伪造签名的处理
+	fmt.Println("\n=== 回调demo ===")
+	// 微信支付的回调使用渠道的 RSA 密钥签名，其余渠道使用分发器的默认 HMAC 密钥
+	wechatKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	callbackKeys := NewKeyRing()
+	callbackKeys.Set("微信支付", NewRSASigner(wechatKey))
+	dispatcher.SetKeyRing(callbackKeys)
+	callbacks := make([]PaymentCallback, 2)
+	for i := range callbacks {
+		callbacks[i] = PaymentCallback{
+			EventID:       fmt.Sprintf("WX-DEMO-%d", i+1),
+			TransactionID: "WX-DEMO",
+			Provider:      "微信支付",
+			Status:        []PaymentStatus{PaymentSucceeded, PaymentRefunded}[i],
+			Amount:        88.00,
+			Sequence:      i + 1,
+			Timestamp:     time.Now(),
+		}
+		dispatcher.Sign(&callbacks[i])
+	}
+	dispatcher.Deliver(callbacks[1]) // 序号2先到，等待序号1
+	dispatcher.Deliver(callbacks[0]) // 序号1到达后按顺序投递1、2
+	dispatcher.Deliver(callbacks[0]) // 重复回调被忽略
+
+	forged := callbacks[0]
+	forged.EventID, forged.Amount = "WX-DEMO-forged", 8800.00
+	unsigned := callbacks[1]
+	unsigned.EventID, unsigned.Signature = "WX-DEMO-unsigned", ""
+	for _, cb := range []PaymentCallback{forged, unsigned} {
+		var sigErr *SignatureError
+		if err := dispatcher.Deliver(cb); errors.As(err, &sigErr) {
+			fmt.Printf("拒绝回调: %s 算法:%s 原因:%s\n", sigErr.Provider, sigErr.Algorithm, sigErr.Reason)
+		}
+	}
+
+	// 只配置了公钥时只能验签，不能替渠道签名
+	callbackKeys.Set("微信支付", NewRSAVerifier(&wechatKey.PublicKey))
+	if err := dispatcher.Sign(&forged); err != nil {
+		fmt.Printf("签名失败: %v\n", err)
+	}
+}
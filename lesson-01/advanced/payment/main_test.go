@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMockProcess 创建只启用了模拟支付 "mock" 的支付处理器
+func newMockProcess(config MockPayConfig) (*PaymentProcess, *MockPay) {
+	process := NewPaymentProcess()
+	mock := NewMockPay(config)
+	process.AddPayment("mock", mock)
+	return process, mock
+}
+
+// TestMockPayTimeout 测试单次调用超时和调用方主动取消
+func TestMockPayTimeout(t *testing.T) {
+	process, _ := newMockProcess(MockPayConfig{MinLatency: 200 * time.Millisecond})
+	process.SetTimeout("mock", 20*time.Millisecond)
+	req := PaymentRequest{Amount: 5.00, Account: "tester"}
+
+	t.Run("超过超时时间", func(t *testing.T) {
+		start := time.Now()
+		_, err := process.Charge(context.Background(), "mock", req)
+		if !errors.Is(err, ErrPaymentTimeout) {
+			t.Fatalf("预期 ErrPaymentTimeout，实际 %v", err)
+		}
+		var payErr *PaymentError
+		if !errors.As(err, &payErr) || payErr.Code != ErrCodeTimeout || !payErr.Retryable {
+			t.Errorf("预期可重试的 ErrCodeTimeout，实际 %+v", payErr)
+		}
+		if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+			t.Errorf("超时后应立即返回，实际耗时 %v", elapsed)
+		}
+	})
+
+	t.Run("调用方取消不算超时", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := process.Charge(ctx, "mock", req)
+		if !errors.Is(err, context.Canceled) || errors.Is(err, ErrPaymentTimeout) {
+			t.Errorf("预期 context.Canceled，实际 %v", err)
+		}
+	})
+}
+
+// TestMockPayFailures 测试按次数失败和指定调用返回的错误码
+func TestMockPayFailures(t *testing.T) {
+	process, mock := newMockProcess(MockPayConfig{
+		FailEvery:  3,
+		ErrorCodes: map[int]string{2: "card_declined"},
+	})
+
+	// 第2次调用返回指定错误码，第3、6次调用按次数失败
+	want := []struct {
+		code      PaymentErrorCode // 0 表示成功
+		retryable bool
+	}{
+		{0, false},
+		{ErrCodeDeclined, false},
+		{ErrCodeProviderUnavailable, true},
+		{0, false},
+		{0, false},
+		{ErrCodeProviderUnavailable, true},
+	}
+	for i, w := range want {
+		result, err := process.Charge(context.Background(), "mock", PaymentRequest{Amount: 5.00, Account: "tester"})
+		if w.code == 0 {
+			if err != nil || result.Status != PaymentSucceeded {
+				t.Errorf("第%d次调用预期成功，实际 %v", i+1, err)
+			}
+			continue
+		}
+		var payErr *PaymentError
+		if !errors.As(err, &payErr) {
+			t.Errorf("第%d次调用预期 PaymentError，实际 %v", i+1, err)
+			continue
+		}
+		if payErr.Code != w.code || payErr.Retryable != w.retryable {
+			t.Errorf("第%d次调用错误码 %s 可重试 %v，预期 %s 可重试 %v", i+1, payErr.Code, payErr.Retryable, w.code, w.retryable)
+		}
+		var mockErr *MockError
+		if !errors.As(err, &mockErr) || mockErr.Call != i+1 {
+			t.Errorf("第%d次调用预期包装 MockError，实际 %v", i+1, err)
+		}
+	}
+	if mock.Calls() != len(want) {
+		t.Errorf("Calls = %d，预期 %d", mock.Calls(), len(want))
+	}
+}
+
+// TestMockPayPending 测试异步支付先返回处理中，轮询后得到结果
+func TestMockPayPending(t *testing.T) {
+	t.Run("轮询后成功", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{PendingPolls: 3})
+		process.pollInterval = time.Millisecond
+		result, err := process.Charge(context.Background(), "mock", PaymentRequest{Amount: 5.00, Account: "tester"})
+		if err != nil {
+			t.Fatalf("Charge: %v", err)
+		}
+		if result.Status != PaymentSucceeded {
+			t.Errorf("Status = %s，预期成功", result.Status)
+		}
+		// 交易已经成功，再次查询不会再变化
+		if status, err := mock.QueryStatus(result.TransactionID); err != nil || status != PaymentSucceeded {
+			t.Errorf("QueryStatus = %s, %v", status, err)
+		}
+	})
+
+	t.Run("轮询超时", func(t *testing.T) {
+		process, _ := newMockProcess(MockPayConfig{PendingPolls: 1000})
+		process.pollInterval, process.pollTimeout = time.Millisecond, 20*time.Millisecond
+		result, err := process.Charge(context.Background(), "mock", PaymentRequest{Amount: 5.00, Account: "tester"})
+		if !errors.Is(err, ErrorPaymentPending) {
+			t.Fatalf("预期 ErrorPaymentPending，实际 %v", err)
+		}
+		if result == nil || result.Status != PaymentPending {
+			t.Errorf("预期返回处理中的交易，实际 %+v", result)
+		}
+	})
+}
+
+// TestDuplicateWindow 测试重复支付检测窗口
+func TestDuplicateWindow(t *testing.T) {
+	req := PaymentRequest{Amount: 5.00, Account: "tester", OrderID: "ORD1"}
+
+	t.Run("窗口内重复支付只扣款一次", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{})
+		process.SetDuplicateWindow(time.Minute)
+		first, err := process.Charge(context.Background(), "mock", req)
+		if err != nil {
+			t.Fatalf("Charge: %v", err)
+		}
+		second, err := process.Charge(context.Background(), "mock", req)
+		if err != nil {
+			t.Fatalf("Charge: %v", err)
+		}
+		if !second.Duplicate || second.TransactionID != first.TransactionID {
+			t.Errorf("预期返回首笔交易 %s，实际 %s (Duplicate=%v)", first.TransactionID, second.TransactionID, second.Duplicate)
+		}
+		if first.Duplicate {
+			t.Errorf("首笔交易不应标记为重复")
+		}
+
+		// 订单号不同不算重复
+		other := req
+		other.OrderID = "ORD2"
+		if result, err := process.Charge(context.Background(), "mock", other); err != nil || result.Duplicate {
+			t.Errorf("不同订单预期正常扣款，实际 %+v, %v", result, err)
+		}
+		if mock.Calls() != 2 {
+			t.Errorf("Calls = %d，预期 2", mock.Calls())
+		}
+	})
+
+	t.Run("并发的重复支付", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{MinLatency: 20 * time.Millisecond})
+		process.SetDuplicateWindow(time.Minute)
+		var wg sync.WaitGroup
+		ids := make([]string, 5)
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if result, err := process.Charge(context.Background(), "mock", req); err == nil {
+					ids[i] = result.TransactionID
+				}
+			}(i)
+		}
+		wg.Wait()
+		if mock.Calls() != 1 {
+			t.Errorf("Calls = %d，预期 1", mock.Calls())
+		}
+		for _, id := range ids {
+			if id == "" || id != ids[0] {
+				t.Errorf("预期所有请求返回同一笔交易，实际 %v", ids)
+				break
+			}
+		}
+	})
+
+	t.Run("首笔失败后允许重新支付", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{ErrorCodes: map[int]string{1: "card_declined"}})
+		process.SetDuplicateWindow(time.Minute)
+		if _, err := process.Charge(context.Background(), "mock", req); err == nil {
+			t.Fatalf("首笔支付预期失败")
+		}
+		result, err := process.Charge(context.Background(), "mock", req)
+		if err != nil || result.Duplicate {
+			t.Errorf("重新支付预期正常扣款，实际 %+v, %v", result, err)
+		}
+		if mock.Calls() != 2 {
+			t.Errorf("Calls = %d，预期 2", mock.Calls())
+		}
+	})
+
+	t.Run("未设置窗口时不检测", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{})
+		for i := 0; i < 2; i++ {
+			if result, err := process.Charge(context.Background(), "mock", req); err != nil || result.Duplicate {
+				t.Errorf("第%d次支付预期正常扣款，实际 %+v, %v", i+1, result, err)
+			}
+		}
+		if mock.Calls() != 2 {
+			t.Errorf("Calls = %d，预期 2", mock.Calls())
+		}
+	})
+
+	t.Run("窗口过期后重新扣款", func(t *testing.T) {
+		process, mock := newMockProcess(MockPayConfig{})
+		process.SetDuplicateWindow(10 * time.Millisecond)
+		first, err := process.Charge(context.Background(), "mock", req)
+		if err != nil {
+			t.Fatalf("Charge: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		second, err := process.Charge(context.Background(), "mock", req)
+		if err != nil || second.Duplicate || second.TransactionID == first.TransactionID {
+			t.Errorf("窗口过期后预期新的交易，实际 %+v, %v", second, err)
+		}
+		if mock.Calls() != 2 {
+			t.Errorf("Calls = %d，预期 2", mock.Calls())
+		}
+	})
+}
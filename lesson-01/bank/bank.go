@@ -1,14 +1,16 @@
-package main
+// Package bank 银行账户系统：开户、存取款、转账和冻结，可以被多个 goroutine 同时使用
+package bank
 
 import (
 	"errors"
 	"fmt"
+	"sync"
 )
 
 // 自定义错误
 var (
-	ErrorAccountNotFound     = errors.New("账户不存在")    // 账户不存在错误
-	ErrorInsufficientBalance = errors.New("余额不足")      // 余额不足错误
+	ErrorAccountNotFound     = errors.New("账户不存在")   // 账户不存在错误
+	ErrorInsufficientBalance = errors.New("余额不足")    // 余额不足错误
 	ErrorInvalidAmount       = errors.New("金额必须大于0") // 无效金额错误
 )
 
@@ -22,6 +24,7 @@ type Account struct {
 
 // Bank 银行系统
 type Bank struct {
+	mu       sync.Mutex // 保护 accounts 及其中的账户
 	accounts map[string]*Account
 }
 
@@ -34,6 +37,9 @@ func NewBank() *Bank {
 
 // OpenAccount 开户方法，参数为账户号码、账户持有人姓名和初始存款金额
 func (b *Bank) OpenAccount(accountNumber string, accountHolder string, initialAccount float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if initialAccount < 0 {
 		return ErrorInvalidAmount
 	}
@@ -58,6 +64,9 @@ func (b *Bank) OpenAccount(accountNumber string, accountHolder string, initialAc
 ** amount 存款金额
  */
 func (b *Bank) Deposit(accountNumber string, amount float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if amount <= 0 {
 		return ErrorInvalidAmount
 	}
@@ -77,6 +86,9 @@ func (b *Bank) Deposit(accountNumber string, amount float64) error {
 ** amount 取款金额
  */
 func (b *Bank) Withdraw(accountNumber string, amount float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if amount <= 0 {
 		return ErrorInvalidAmount
 	}
@@ -100,6 +112,9 @@ func (b *Bank) Withdraw(accountNumber string, amount float64) error {
 ** @return 余额和错误信息
  */
 func (b *Bank) GetBalance(accountNumber string) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	account, exists := b.accounts[accountNumber]
 	if !exists || !account.IsActive {
 		return 0, ErrorAccountNotFound
@@ -114,6 +129,9 @@ func (b *Bank) GetBalance(accountNumber string) (float64, error) {
 ** amount 转入账户和转账金额
  */
 func (b *Bank) Transfer(fromAccount, toAccount string, amount float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if amount <= 0 {
 		return ErrorInvalidAmount
 	}
@@ -147,6 +165,9 @@ func (b *Bank) Transfer(fromAccount, toAccount string, amount float64) error {
 ** accountNumber 账户号码
  */
 func (b *Bank) FreezeAccount(accountNumber string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	account, exists := b.accounts[accountNumber]
 	if !exists {
 		return ErrorAccountNotFound
@@ -160,6 +181,9 @@ func (b *Bank) FreezeAccount(accountNumber string) error {
 ** accountNumber 账户号码
  */
 func (b *Bank) UnfreezeAccount(accountNumber string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	account, exists := b.accounts[accountNumber]
 	if !exists {
 		return ErrorAccountNotFound
@@ -172,6 +196,9 @@ func (b *Bank) UnfreezeAccount(accountNumber string) error {
 **显示所有账户信息
  */
 func (b *Bank) DisplayAllAccounts() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	fmt.Println("\n=== 账户列表 ===")
 	if len(b.accounts) == 0 {
 		fmt.Println("暂无账户")
@@ -190,60 +217,3 @@ func (b *Bank) DisplayAllAccounts() {
 	}
 	fmt.Printf("总余额: ¥%.2f\n", totalBalance)
 }
-
-func main() {
-	bank := NewBank()
-
-	// 开户信息列表，包含账户号码、持有人和初始存款
-	bank.OpenAccount("1", "张三", 1700.0)
-	bank.OpenAccount("2", "李四", 600.0)
-	bank.OpenAccount("3", "王五", 14000.0)
-
-	// 显示所有账户
-	bank.DisplayAllAccounts()
-
-	// 存款操作
-	if err := bank.Deposit("1", 500.0); err == nil {
-		fmt.Println("存款成功，账号1存款%f", 500.0)
-	}
-
-	// 转账操作
-	if err := bank.Transfer("1", "3", 300.0); err == nil {
-		fmt.Println("转账成功,账号1向账号3转账 %f", 300.0)
-	}
-
-	// 取款操作
-	if err := bank.Withdraw("2", 200.0); err == nil {
-		fmt.Println("取款成功，账号2取款%f", 200.0)
-	}
-
-	// 尝试超额取款（测试错误处理）
-	if err := bank.Withdraw("2", 1000.0); err != nil {
-		fmt.Printf("取款失败: %v\n", err)
-	}
-
-	// 查询余额
-	if balance, err := bank.GetBalance("1"); err == nil {
-		fmt.Printf("账户1余额: ¥%.2f\n", balance)
-	}
-
-	// 冻结账户
-	if err := bank.FreezeAccount("3"); err == nil {
-		fmt.Println("账户3已冻结")
-	}
-
-	// 尝试向冻结账户转账
-	if err := bank.Transfer("1", "3", 100.0); err != nil {
-		fmt.Printf("转账失败: %v\n", err)
-	}
-
-	bank.DisplayAllAccounts()
-
-	// 解冻账户
-	if err := bank.UnfreezeAccount("3"); err == nil {
-		fmt.Println("账户3已解冻")
-	}
-
-	bank.DisplayAllAccounts()
-
-}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"gohomeworklesson01/bank"
+)
+
+func main() {
+	b := bank.NewBank()
+
+	// 开户信息列表，包含账户号码、持有人和初始存款
+	b.OpenAccount("1", "张三", 1700.0)
+	b.OpenAccount("2", "李四", 600.0)
+	b.OpenAccount("3", "王五", 14000.0)
+
+	// 显示所有账户
+	b.DisplayAllAccounts()
+
+	// 存款操作
+	if err := b.Deposit("1", 500.0); err == nil {
+		fmt.Printf("存款成功，账号1存款%.2f\n", 500.0)
+	}
+
+	// 转账操作
+	if err := b.Transfer("1", "3", 300.0); err == nil {
+		fmt.Printf("转账成功,账号1向账号3转账 %.2f\n", 300.0)
+	}
+
+	// 取款操作
+	if err := b.Withdraw("2", 200.0); err == nil {
+		fmt.Printf("取款成功，账号2取款%.2f\n", 200.0)
+	}
+
+	// 尝试超额取款（测试错误处理）
+	if err := b.Withdraw("2", 1000.0); err != nil {
+		fmt.Printf("取款失败: %v\n", err)
+	}
+
+	// 查询余额
+	if balance, err := b.GetBalance("1"); err == nil {
+		fmt.Printf("账户1余额: ¥%.2f\n", balance)
+	}
+
+	// 冻结账户
+	if err := b.FreezeAccount("3"); err == nil {
+		fmt.Println("账户3已冻结")
+	}
+
+	// 尝试向冻结账户转账
+	if err := b.Transfer("1", "3", 100.0); err != nil {
+		fmt.Printf("转账失败: %v\n", err)
+	}
+
+	b.DisplayAllAccounts()
+
+	// 解冻账户
+	if err := b.UnfreezeAccount("3"); err == nil {
+		fmt.Println("账户3已解冻")
+	}
+
+	b.DisplayAllAccounts()
+
+}
@@ -123,7 +123,7 @@ func StudentManagementDemo() {
 
 	student1, _ := sm.GetStudent(2)
 	fmt.Println("更新后的学生信息")
-	fmt.Println("Id: %d, 姓名: %s, 年龄: %d, 分数: %d, 班级: %s\n", student1.Id, student1.Name, student1.Age, student1.Grade, student1.Class)
+	fmt.Printf("Id: %d, 姓名: %s, 年龄: %d, 分数: %d, 班级: %s\n", student1.Id, student1.Name, student1.Age, student1.Grade, student1.Class)
 
 	fmt.Println("根据条件查询学生")
 	seniorStudents := sm.FindStudents("", 70)
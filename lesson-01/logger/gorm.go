@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// GormLogger 基于 Logger 实现的 GORM 日志接口（gorm.io/gorm/logger.Interface）
+// 所有 SQL（含耗时与影响行数）都会进入同一个异步文件日志管道
+// 执行时间超过 SlowThreshold 的查询会以 WARN 级别标记为慢查询
+type GormLogger struct {
+	logger                    *Logger
+	level                     gormlogger.LogLevel
+	SlowThreshold             time.Duration // 慢查询阈值，0 表示不检测
+	IgnoreRecordNotFoundError bool          // 是否忽略 gorm.ErrRecordNotFound
+}
+
+// NewGormLogger 创建 GORM 日志适配器，默认记录全部 SQL
+func NewGormLogger(logger *Logger, slowThreshold time.Duration) *GormLogger {
+	return &GormLogger{
+		logger:                    logger,
+		level:                     gormlogger.Info,
+		SlowThreshold:             slowThreshold,
+		IgnoreRecordNotFoundError: true,
+	}
+}
+
+// LogMode 设置日志级别，返回新的实例，不影响原有配置
+func (g *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	newLogger := *g
+	newLogger.level = level
+	return &newLogger
+}
+
+// Info 记录 GORM 的普通信息
+func (g *GormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if g.level >= gormlogger.Info {
+		g.logger.Info("[gorm] "+msg, data...)
+	}
+}
+
+// Warn 记录 GORM 的警告信息
+func (g *GormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if g.level >= gormlogger.Warn {
+		g.logger.Warn("[gorm] "+msg, data...)
+	}
+}
+
+// Error 记录 GORM 的错误信息
+func (g *GormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if g.level >= gormlogger.Error {
+		g.logger.Error("[gorm] "+msg, data...)
+	}
+}
+
+// Trace 记录每条 SQL 的执行情况：出错记 ERROR，慢查询记 WARN，其余记 INFO
+func (g *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if g.level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	ms := float64(elapsed.Nanoseconds()) / 1e6
+
+	sql, rows := fc()
+	rowsText := "-" // rows 为 -1 表示未知
+	if rows != -1 {
+		rowsText = fmt.Sprintf("%d", rows)
+	}
+
+	switch {
+	case err != nil && g.level >= gormlogger.Error &&
+		(!g.IgnoreRecordNotFoundError || !errors.Is(err, gorm.ErrRecordNotFound)):
+		g.logger.Error("[gorm] [%.3fms] [rows:%s] %s | %v", ms, rowsText, sql, err)
+	case g.SlowThreshold != 0 && elapsed > g.SlowThreshold && g.level >= gormlogger.Warn:
+		g.logger.Warn("[gorm] SLOW SQL >= %v [%.3fms] [rows:%s] %s", g.SlowThreshold, ms, rowsText, sql)
+	case g.level >= gormlogger.Info:
+		g.logger.Info("[gorm] [%.3fms] [rows:%s] %s", ms, rowsText, sql)
+	}
+}
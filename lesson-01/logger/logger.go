@@ -0,0 +1,471 @@
+// Package logger 并发安全的异步日志系统：日志经由缓冲通道交给后台 goroutine 写入文件、控制台和其他 LogSink，
+// 支持标准库 *log.Logger 和 GORM 日志接入（见 StdLogger、GormLogger）以及日志文件加密存储
+package logger
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel 日志级别
+type LogLevel int
+
+// 定义日志级别常量，使用iota从0开始自动递增
+const (
+	DEBUG LogLevel = iota
+	INFO
+	WARN
+	ERROR
+)
+
+func (l LogLevel) String() string {
+	return []string{"DEBUG", "INFO", "WARN", "ERROR"}[l]
+}
+
+// ErrCloseTimeout 关闭日志系统时未能在期限内写完剩余日志
+var ErrCloseTimeout = errors.New("日志系统关闭超时")
+
+// LogEntry 日志属性
+type LogEntry struct {
+	Level   LogLevel
+	Message string
+	Time    time.Time
+}
+
+// Logger 并发安全的日志系统
+type Logger struct {
+	entries    chan LogEntry         // 日志条目通道，用于异步处理日志
+	wg         sync.WaitGroup        // 用于等待写入goroutine完成
+	file       io.WriteCloser        // 日志输出文件（可能被加密写入器包装）
+	consoleOut bool                  // 是否同时输出到控制台
+	mu         sync.RWMutex          // 保护文件写入的读写锁
+	running    atomic.Bool           // 记录日志系统是否正在运行
+	sinks      []LogSink             // 额外的日志输出目标
+	clock      atomic.Pointer[Clock] // 日志时间戳的来源，未设置时使用系统时间
+
+	sendMu    sync.RWMutex // Log 发送时持有读锁，关闭通道时持有写锁，避免向已关闭的通道发送
+	closeOnce sync.Once    // 保证重复关闭是安全的
+	closeErr  error        // 第一次关闭的结果
+	abandon   atomic.Bool  // 关闭超时后通知 writeLoop 放弃剩余日志
+	abandoned atomic.Int64 // 被放弃的日志条数
+}
+
+// Clock 日志时间戳的来源，测试中可以替换为固定或可以拨动的时间
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc 把普通函数适配为 Clock
+type ClockFunc func() time.Time
+
+// Now 实现 Clock 接口
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// LogSink 日志输出目标，writeLoop 会把每条日志同时交给所有 sink
+type LogSink interface {
+	// WriteEntry 接收原始日志条目和格式化后的文本
+	WriteEntry(entry LogEntry, formatted string)
+}
+
+// NewLogger 创建新的日志系统
+func NewLogger(filename string, consoleOutput bool) (*Logger, error) {
+	if filename == "" {
+		return newLogger(nil, consoleOutput), nil
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return newLogger(file, consoleOutput), nil
+}
+
+// NewEncryptedLogger 创建日志文件加密存储的日志系统
+// 每条日志使用 AES-GCM 单独加密后追加到文件，密钥由 keys 提供，可用 ReadEncryptedLog 解密读取
+func NewEncryptedLogger(filename string, consoleOutput bool, keys KeyProvider) (*Logger, error) {
+	key, err := keys.Key()
+	if err != nil {
+		return nil, fmt.Errorf("获取日志加密密钥失败: %w", err)
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewEncryptingWriter(file, key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newLogger(writer, consoleOutput), nil
+}
+
+// newLogger 使用给定的输出创建日志系统并启动写入goroutine，out 可以为 nil
+func newLogger(out io.WriteCloser, consoleOutput bool) *Logger {
+	logger := Logger{
+		entries:    make(chan LogEntry, 1000), // 缓冲通道
+		file:       out,
+		consoleOut: consoleOutput,
+	}
+	logger.running.Store(true)
+
+	// 启动日志写入goroutine
+	logger.wg.Add(1)
+	go logger.writeLoop()
+
+	return &logger
+}
+
+// writeLoop 日志写入循环
+func (l *Logger) writeLoop() {
+	defer l.wg.Done()
+
+	for entry := range l.entries {
+		// 关闭超时后只清空通道，不再写入
+		if l.abandon.Load() {
+			l.abandoned.Add(1)
+			continue
+		}
+
+		logMsg := formatEntry(entry)
+
+		// 写入文件
+		if l.file != nil {
+			l.mu.Lock()
+			io.WriteString(l.file, logMsg)
+			l.mu.Unlock()
+		}
+
+		// 控制台输出
+		if l.consoleOut {
+			fmt.Print(logMsg)
+		}
+
+		// 其他输出目标
+		l.mu.RLock()
+		for _, sink := range l.sinks {
+			sink.WriteEntry(entry, logMsg)
+		}
+		l.mu.RUnlock()
+	}
+}
+
+// formatEntry 把日志条目格式化为一行文本
+func formatEntry(entry LogEntry) string {
+	return fmt.Sprintf("[%s] %s: %s\n",
+		entry.Time.Format("2025-12-31 15:04:05"),
+		entry.Level,
+		entry.Message)
+}
+
+// AddSink 添加日志输出目标
+func (l *Logger) AddSink(sink LogSink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
+// SetClock 设置日志时间戳的来源，nil 表示使用系统时间
+func (l *Logger) SetClock(clock Clock) {
+	if clock == nil {
+		l.clock.Store(nil)
+		return
+	}
+	l.clock.Store(&clock)
+}
+
+// now 返回当前时间，取自 SetClock 设置的 Clock
+func (l *Logger) now() time.Time {
+	if clock := l.clock.Load(); clock != nil {
+		return (*clock).Now()
+	}
+	return time.Now()
+}
+
+// Log 记录日志
+func (l *Logger) Log(level LogLevel, format string, args ...interface{}) {
+	if !l.running.Load() {
+		return
+	}
+
+	// 持有读锁期间通道不会被关闭；拿到锁后需要再检查一次运行状态
+	l.sendMu.RLock()
+	defer l.sendMu.RUnlock()
+	if !l.running.Load() {
+		return
+	}
+
+	entry := LogEntry{
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+		Time:    l.now(),
+	}
+
+	select {
+	case l.entries <- entry:
+	default:
+		// 队列已满，丢弃日志
+		fmt.Printf("日志队列已满，丢弃日志: %s\n", entry.Message)
+	}
+}
+
+// 便捷方法
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.Log(DEBUG, format, args...)
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.Log(INFO, format, args...)
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.Log(WARN, format, args...)
+}
+
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.Log(ERROR, format, args...)
+}
+
+// stdLogWriter 标准库 *log.Logger 的输出适配器
+// 每次 Write 都会被解析成日志条目并投递到异步通道中
+type stdLogWriter struct {
+	logger *Logger
+	level  LogLevel // 未识别到级别前缀时使用的默认级别
+}
+
+// Write 解析标准库 log 写入的内容，按行拆分后转发给 Logger
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		level, msg := parseLevelPrefix(line, w.level)
+		w.logger.Log(level, "%s", msg)
+	}
+	return len(p), nil
+}
+
+// parseLevelPrefix 识别消息开头的级别标记，如 "[WARN] xxx"、"ERROR: xxx"
+// 识别成功时返回对应级别和去掉前缀后的消息，否则返回默认级别和原消息
+func parseLevelPrefix(line string, def LogLevel) (LogLevel, string) {
+	trimmed := strings.TrimSpace(line)
+	for _, level := range []LogLevel{DEBUG, INFO, WARN, ERROR} {
+		name := level.String()
+		for _, prefix := range []string{"[" + name + "]", name + ":"} {
+			if len(trimmed) >= len(prefix) && strings.EqualFold(trimmed[:len(prefix)], prefix) {
+				return level, strings.TrimSpace(trimmed[len(prefix):])
+			}
+		}
+	}
+	return def, trimmed
+}
+
+// StdLogger 返回一个写入本日志系统的标准库 *log.Logger
+// 第三方代码（如 net/http 的 ErrorLog、GORM 默认日志）无需修改即可接入异步日志管道
+// level 为默认级别，消息自带 "[WARN]"、"ERROR:" 等前缀时以前缀为准
+// 时间戳由本日志系统统一添加，因此返回的 *log.Logger 不带任何 flag
+func (l *Logger) StdLogger(level LogLevel) *log.Logger {
+	return log.New(&stdLogWriter{logger: l, level: level}, "", 0)
+}
+
+// KeyProvider 日志加密密钥的来源，可以是环境变量、KMS 等
+type KeyProvider interface {
+	// Key 返回 AES 密钥，长度必须是 16、24 或 32 字节
+	Key() ([]byte, error)
+}
+
+// KeyProviderFunc 把普通函数适配为 KeyProvider，便于接入 KMS 客户端
+type KeyProviderFunc func() ([]byte, error)
+
+// Key 实现 KeyProvider 接口
+func (f KeyProviderFunc) Key() ([]byte, error) {
+	return f()
+}
+
+// EnvKeyProvider 从环境变量读取 base64 编码的密钥
+type EnvKeyProvider struct {
+	Name string // 环境变量名，如 LOG_ENCRYPTION_KEY
+}
+
+// Key 实现 KeyProvider 接口
+func (p EnvKeyProvider) Key() ([]byte, error) {
+	value := os.Getenv(p.Name)
+	if value == "" {
+		return nil, fmt.Errorf("环境变量 %s 未设置", p.Name)
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("环境变量 %s 不是有效的 base64: %w", p.Name, err)
+	}
+	return key, nil
+}
+
+// encryptingWriter 加密写入器
+// 每次 Write 的内容作为一条记录加密，记录格式：4 字节长度（大端） + nonce + 密文
+type encryptingWriter struct {
+	w    io.WriteCloser
+	aead cipher.AEAD
+}
+
+// NewEncryptingWriter 使用 AES-GCM 包装一个写入器
+func NewEncryptingWriter(w io.WriteCloser, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead}, nil
+}
+
+// newAEAD 根据密钥创建 AES-GCM
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("无效的日志加密密钥: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Write 加密并写入一条记录
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	sealed := e.aead.Seal(nonce, nonce, p, nil)
+
+	record := make([]byte, 4+len(sealed))
+	binary.BigEndian.PutUint32(record, uint32(len(sealed)))
+	copy(record[4:], sealed)
+	if _, err := e.w.Write(record); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 关闭底层写入器
+func (e *encryptingWriter) Close() error {
+	return e.w.Close()
+}
+
+// DecryptLog 从 r 中逐条读取加密记录，解密后写入 w
+func DecryptLog(r io.Reader, w io.Writer, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(r)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("读取日志记录失败: %w", err)
+		}
+
+		sealed := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, sealed); err != nil {
+			return fmt.Errorf("读取日志记录失败: %w", err)
+		}
+		if len(sealed) < aead.NonceSize() {
+			return errors.New("日志记录已损坏")
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("解密日志记录失败: %w", err)
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadEncryptedLog 解密读取整个加密日志文件
+func ReadEncryptedLog(filename string, keys KeyProvider) (string, error) {
+	key, err := keys.Key()
+	if err != nil {
+		return "", fmt.Errorf("获取日志加密密钥失败: %w", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	if err := DecryptLog(file, &sb, key); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Close 关闭日志系统，等待所有日志写入完成，可以重复调用
+func (l *Logger) Close() {
+	l.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout 关闭日志系统，最多等待 d 时间写完剩余日志，d <= 0 表示一直等待
+// 超时后剩余日志会被放弃，返回包装了 ErrCloseTimeout 的错误
+// 可以安全地重复调用，之后的调用返回第一次关闭的结果
+func (l *Logger) CloseWithTimeout(d time.Duration) error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.shutdown(d)
+	})
+	return l.closeErr
+}
+
+// shutdown 停止接收新日志，关闭通道并等待写入goroutine退出
+func (l *Logger) shutdown(d time.Duration) error {
+	// 等待正在发送的 Log 结束后再关闭通道
+	l.sendMu.Lock()
+	l.running.Store(false)
+	close(l.entries)
+	l.sendMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait() // 等待写入goroutine完成
+		close(done)
+	}()
+
+	var err error
+	if d <= 0 {
+		<-done
+	} else {
+		select {
+		case <-done:
+		case <-time.After(d):
+			l.abandon.Store(true)
+			<-done
+			err = fmt.Errorf("%w: 放弃了 %d 条未写入的日志", ErrCloseTimeout, l.abandoned.Load())
+		}
+	}
+
+	if l.file != nil {
+		if cerr := l.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
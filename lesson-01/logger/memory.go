@@ -0,0 +1,102 @@
+package logger
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// MemorySink 内存日志输出，供测试使用
+// 记录格式化文本和原始 LogEntry，其他模块可以直接断言日志内容而无需读写文件
+type MemorySink struct {
+	mu        sync.Mutex
+	entries   []LogEntry
+	formatted []string
+}
+
+// NewMemorySink 创建内存日志输出
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// WriteEntry 实现 LogSink 接口
+func (m *MemorySink) WriteEntry(entry LogEntry, formatted string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	m.formatted = append(m.formatted, formatted)
+}
+
+// Entries 返回已记录的原始日志条目副本
+func (m *MemorySink) Entries() []LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]LogEntry(nil), m.entries...)
+}
+
+// Formatted 返回已记录的格式化日志文本副本
+func (m *MemorySink) Formatted() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.formatted...)
+}
+
+// Len 返回已记录的日志条数
+func (m *MemorySink) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// ContainsLevel 判断是否记录过指定级别的日志
+func (m *MemorySink) ContainsLevel(level LogLevel) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.entries {
+		if entry.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
+// EntriesMatching 返回消息匹配正则表达式 pattern 的日志条目
+func (m *MemorySink) EntriesMatching(pattern string) ([]LogEntry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matched []LogEntry
+	for _, entry := range m.entries {
+		if re.MatchString(entry.Message) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched, nil
+}
+
+// WaitFor 等待至少记录 n 条日志，超时返回 false
+// 日志是异步写入的，断言前可以用它代替 Close 等待写入完成
+// timeout 是等待写入 goroutine 的真实时间，与 Logger 的 Clock 无关，Clock 固定时也会按时超时
+func (m *MemorySink) WaitFor(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for m.Len() < n {
+		select {
+		case <-deadline:
+			return false
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return true
+}
+
+// Reset 清空已记录的日志
+func (m *MemorySink) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = nil
+	m.formatted = nil
+}
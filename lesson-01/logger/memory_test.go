@@ -0,0 +1,71 @@
+package logger_test
+
+import (
+	"gohomeworklesson01/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMemorySink 测试内存日志输出的断言辅助方法
+func TestMemorySink(t *testing.T) {
+	l, err := logger.NewLogger("", false)
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+	memory := logger.NewMemorySink()
+	l.AddSink(memory)
+
+	l.Info("用户 %d 登录", 1)
+	l.Warn("服务器负载过高")
+	l.Info("用户 %d 登录", 2)
+
+	t.Run("WaitFor", func(t *testing.T) {
+		if !memory.WaitFor(3, time.Second) {
+			t.Fatalf("预期 1 秒内记录 3 条日志，实际 %d 条", memory.Len())
+		}
+		start := time.Now()
+		if memory.WaitFor(4, 20*time.Millisecond) {
+			t.Errorf("只有 %d 条日志，WaitFor(4) 预期超时", memory.Len())
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("WaitFor 在 %v 后返回，早于超时时间", elapsed)
+		}
+	})
+
+	t.Run("ContainsLevel", func(t *testing.T) {
+		if !memory.ContainsLevel(logger.INFO) || !memory.ContainsLevel(logger.WARN) {
+			t.Errorf("预期包含 INFO 和 WARN 日志")
+		}
+		if memory.ContainsLevel(logger.ERROR) {
+			t.Errorf("没有记录 ERROR 日志")
+		}
+	})
+
+	t.Run("EntriesMatching", func(t *testing.T) {
+		matched, err := memory.EntriesMatching(`^用户 \d+ 登录$`)
+		if err != nil {
+			t.Fatalf("EntriesMatching: %v", err)
+		}
+		if len(matched) != 2 || matched[0].Message != "用户 1 登录" || matched[1].Message != "用户 2 登录" {
+			t.Errorf("匹配结果 %+v，预期两条登录日志", matched)
+		}
+		if matched, _ := memory.EntriesMatching("不存在"); len(matched) != 0 {
+			t.Errorf("预期没有匹配，实际 %d 条", len(matched))
+		}
+		if _, err := memory.EntriesMatching("("); err == nil {
+			t.Errorf("无效的正则表达式预期返回错误")
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		if formatted := memory.Formatted(); len(formatted) != 3 || !strings.Contains(formatted[1], "WARN: 服务器负载过高") {
+			t.Errorf("格式化日志 %q", formatted)
+		}
+		memory.Reset()
+		if memory.Len() != 0 || memory.ContainsLevel(logger.INFO) {
+			t.Errorf("Reset 后预期没有日志")
+		}
+	})
+}
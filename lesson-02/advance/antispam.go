@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 评论反垃圾规则的环境变量，见 LoadAntiSpamRules
+const (
+	CommentRateLimitEnv       = "BLOG_COMMENT_RATE_LIMIT"       // 每个用户每分钟最多发表的评论数
+	CommentDuplicateWindowEnv = "BLOG_COMMENT_DUPLICATE_WINDOW" // 重复评论的检测时长，如 10m
+)
+
+// commentRateWindow 评论频率限制的统计时长
+const commentRateWindow = time.Minute
+
+// RateLimitError 评论过于频繁，返回 429，RetryAfter 之后可以再次发表
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("评论过于频繁，请 %d 秒后再试", retryAfterSeconds(e.RetryAfter))
+}
+
+// SpamError 评论被反垃圾检查拒绝（重复内容或 SpamChecker 判定为垃圾评论），返回 422
+type SpamError struct {
+	Reason string
+}
+
+func (e *SpamError) Error() string {
+	return "评论被拒绝: " + e.Reason
+}
+
+// SpamChecker 可插拔的垃圾评论检查（如接入第三方反垃圾服务），发表和修改评论时调用
+// 返回 *SpamError 时拒绝评论；返回其他错误时视为检查失败，评论进入待审核而不是直接拒绝
+type SpamChecker interface {
+	CheckComment(ctx context.Context, comment *Comment) error
+}
+
+// SpamCheckerFunc 把函数作为 SpamChecker 使用
+type SpamCheckerFunc func(ctx context.Context, comment *Comment) error
+
+func (f SpamCheckerFunc) CheckComment(ctx context.Context, comment *Comment) error {
+	return f(ctx, comment)
+}
+
+// AntiSpamRules 发表评论时的频率限制和反垃圾检查，在审核规则（ModerationRules）之前执行
+type AntiSpamRules struct {
+	PerMinute       int           // 每个用户每分钟最多发表的评论数（包括之后删除的），0 表示不限制
+	DuplicateWindow time.Duration // 同一用户在这段时间内发表相同内容的评论时拒绝，0 表示不检查
+	Checker         SpamChecker   // 垃圾评论检查，可以为 nil
+}
+
+// CommentAntiSpam 当前使用的反垃圾规则，默认每分钟最多 5 条评论，10 分钟内不能重复发表相同的内容
+// 只应在启动时修改（见 LoadAntiSpamRules），请求处理过程中只读
+var CommentAntiSpam = AntiSpamRules{PerMinute: 5, DuplicateWindow: 10 * time.Minute}
+
+// LoadAntiSpamRules 从环境变量读取频率限制和重复检测的时长，没有设置的项使用 defaults 中的值
+// Checker 不能通过环境变量配置，保留 defaults 中的值
+func LoadAntiSpamRules(defaults AntiSpamRules) (AntiSpamRules, error) {
+	rules := defaults
+	if value := os.Getenv(CommentRateLimitEnv); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return rules, fmt.Errorf("%s 必须是非负整数: %q", CommentRateLimitEnv, value)
+		}
+		rules.PerMinute = n
+	}
+	if value := os.Getenv(CommentDuplicateWindowEnv); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return rules, fmt.Errorf("%s 必须是非负的时长（如 10m）: %q", CommentDuplicateWindowEnv, value)
+		}
+		rules.DuplicateWindow = d
+	}
+	return rules, nil
+}
+
+/*
+checkRate 检查用户的评论频率和重复内容，在创建评论的事务中调用
+同一用户并发发表评论时可能略微超过限制，限制只用于防止刷评论，不需要精确
+参数：
+  - tx: GORM 数据库连接
+  - comment: 待发表的评论，需要设置 UserID、Content 和 CreatedAt
+
+返回值：
+  - error: 超过频率限制时返回 *RateLimitError，重复内容时返回 *SpamError，查询失败时返回其他错误
+*/
+func (rules AntiSpamRules) checkRate(tx *gorm.DB, comment *Comment) error {
+	now := comment.CreatedAt
+	if rules.PerMinute > 0 {
+		// 已删除的评论也计入，删除评论不能绕过限制
+		var recent []time.Time
+		err := tx.Unscoped().Model(&Comment{}).
+			Where("user_id = ? AND created_at > ?", comment.UserID, now.Add(-commentRateWindow)).
+			Order("created_at DESC").Limit(rules.PerMinute).
+			Pluck("created_at", &recent).Error
+		if err != nil {
+			return err
+		}
+		if len(recent) >= rules.PerMinute {
+			// 最早的一条移出统计时长后可以再发表
+			return &RateLimitError{RetryAfter: recent[len(recent)-1].Add(commentRateWindow).Sub(now)}
+		}
+	}
+	if rules.DuplicateWindow > 0 {
+		var count int64
+		err := tx.Model(&Comment{}).
+			Where("user_id = ? AND content = ? AND created_at > ?", comment.UserID, comment.Content, now.Add(-rules.DuplicateWindow)).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return &SpamError{Reason: "不能重复发表相同的评论"}
+		}
+	}
+	return nil
+}
+
+// checkSpam 调用 Checker 检查评论，被判定为垃圾评论时返回 *SpamError
+// 检查失败时只记录日志，返回进入待审核的原因；通过时返回空字符串
+func (rules AntiSpamRules) checkSpam(ctx context.Context, comment *Comment) (string, error) {
+	if rules.Checker == nil {
+		return "", nil
+	}
+	err := rules.Checker.CheckComment(ctx, comment)
+	var spamErr *SpamError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &spamErr):
+		return "", err
+	default:
+		log.Printf("检查用户 %d 的评论是否为垃圾评论失败: %v", comment.UserID, err)
+		return "反垃圾检查失败", nil
+	}
+}
+
+// retryAfterSeconds 把等待时间向上取整为秒，至少 1 秒，用于 Retry-After 响应头
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// blogClient 博客接口的客户端，在 testutil.APIClient 上提供带类型的方法，请求失败时直接结束测试
+type blogClient struct {
+	*testutil.APIClient
+	User *User // 登录的用户，未登录时为 nil
+}
+
+// newBlogAPI 在本地端口启动博客接口，返回服务和数据库；服务记录所有请求，测试失败时输出
+func newBlogAPI(t *testing.T) (*testutil.APIServer, *gorm.DB) {
+	t.Helper()
+	srv, db := newTestServer(t)
+	return testutil.NewAPIServer(t, srv), db
+}
+
+// newBlogClient 注册用户 name（邮箱为 name@example.com，密码为 correct horse）并返回已登录的客户端
+func newBlogClient(t *testing.T, api *testutil.APIServer, name string) *blogClient {
+	t.Helper()
+	c := &blogClient{APIClient: api.Client()}
+	var resp tokenResponse
+	req := registerRequest{Name: name, Email: strings.ToLower(name) + "@example.com", Password: "correct horse"}
+	c.Expect(t, http.StatusCreated, "POST", "/auth/register", req, &resp)
+	c.Token, c.User = resp.Token, resp.User
+	return c
+}
+
+// Login 用邮箱和密码登录，成功后客户端使用新的 token
+func (c *blogClient) Login(t *testing.T, email, password string) {
+	t.Helper()
+	var resp tokenResponse
+	c.Expect(t, http.StatusOK, "POST", "/auth/login", loginRequest{Email: email, Password: password}, &resp)
+	c.Token, c.User = resp.Token, resp.User
+}
+
+// CreatePost 发布文章
+func (c *blogClient) CreatePost(t *testing.T, req postRequest) *Post {
+	t.Helper()
+	var post Post
+	c.Expect(t, http.StatusCreated, "POST", "/posts", req, &post)
+	return &post
+}
+
+// Comment 在文章下发表评论
+func (c *blogClient) Comment(t *testing.T, postID uint, content string) *Comment {
+	t.Helper()
+	var comment Comment
+	c.Expect(t, http.StatusCreated, "POST", "/posts/"+itoa(postID)+"/comments", commentRequest{Content: content}, &comment)
+	return &comment
+}
+
+// Comments 查询文章的第一页评论
+func (c *blogClient) Comments(t *testing.T, postID uint) dbutil.Page[Comment] {
+	t.Helper()
+	var page dbutil.Page[Comment]
+	c.Expect(t, http.StatusOK, "GET", "/posts/"+itoa(postID)+"/comments", nil, &page)
+	return page
+}
+
+// TestAPIEndToEnd 通过真实的 HTTP 连接测试注册、登录、发布文章和评论的完整流程
+func TestAPIEndToEnd(t *testing.T) {
+	api, _ := newBlogAPI(t)
+	alice := newBlogClient(t, api, "Alice")
+	bob := newBlogClient(t, api, "Bob")
+
+	// 重新登录后使用新的 token
+	alice.Login(t, "alice@example.com", "correct horse")
+	if alice.User == nil || alice.User.Name != "Alice" {
+		t.Fatalf("登录返回的用户不正确: %+v", alice.User)
+	}
+	if code := api.Client().Do(t, "POST", "/auth/login", loginRequest{Email: "alice@example.com", Password: "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("密码错误返回 %d，预期 401", code)
+	}
+
+	api.ResetExchanges()
+	post := alice.CreatePost(t, postRequest{Title: "GORM 入门", Content: "内容"})
+	bob.Comment(t, post.ID, "写得好")
+	alice.Comment(t, post.ID, "谢谢")
+
+	// 未登录的访客可以查看评论，不能发表评论
+	visitor := &blogClient{APIClient: api.Client()}
+	comments := visitor.Comments(t, post.ID)
+	if comments.Total != 2 || len(comments.Items) != 2 {
+		t.Fatalf("预期 2 条评论，实际 %+v", comments)
+	}
+	if code := visitor.Do(t, "POST", "/posts/"+itoa(post.ID)+"/comments", commentRequest{Content: "匿名"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("未登录发表评论返回 %d，预期 401", code)
+	}
+
+	// 服务端记录了每次请求和响应
+	exchanges := api.Exchanges()
+	want := []string{
+		"POST /posts 201",
+		"POST /posts/" + itoa(post.ID) + "/comments 201",
+		"POST /posts/" + itoa(post.ID) + "/comments 201",
+		"GET /posts/" + itoa(post.ID) + "/comments 200",
+		"POST /posts/" + itoa(post.ID) + "/comments 401",
+	}
+	if len(exchanges) != len(want) {
+		t.Fatalf("记录了 %d 次请求，预期 %d", len(exchanges), len(want))
+	}
+	for i, e := range exchanges {
+		if got := fmt.Sprintf("%s %s %d", e.Method, e.Path, e.Status); got != want[i] {
+			t.Errorf("第 %d 次请求 %s，预期 %s", i+1, got, want[i])
+		}
+	}
+	if auth := exchanges[1].RequestHeader.Get("Authorization"); auth != "Bearer "+bob.Token {
+		t.Errorf("Bob 的评论请求应携带他的 token，实际 %q", auth)
+	}
+	var created Comment
+	if err := exchanges[1].DecodeResponse(&created); err != nil || created.UserID != bob.User.ID || created.Content != "写得好" {
+		t.Errorf("记录的响应不正确: %+v %v", created, err)
+	}
+}
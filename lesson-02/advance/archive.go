@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ArchiveMonth 某个月发布的文章数量，用于博客侧边栏的按月归档
+type ArchiveMonth struct {
+	Year  int   `json:"year"`
+	Month int   `json:"month"`
+	Count int64 `json:"count"`
+}
+
+// 按发布月份统计文章数量（不含已删除的文章），最近的月份在前，没有文章的月份不返回
+// 月份按 UTC 划分，与 ListPostsByMonth 一致
+func GetArchive(db *gorm.DB) ([]ArchiveMonth, error) {
+	var rows []struct {
+		Month string // YYYY-MM
+		Count int64
+	}
+	month := dbutil.MonthExpr(db, "published_at")
+	err := db.Model(&Post{}).Scopes(publishedPosts).
+		Select(month + " AS month, COUNT(*) AS count").
+		Group(month).
+		Order("month DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	archive := make([]ArchiveMonth, 0, len(rows))
+	for _, row := range rows {
+		t, err := time.Parse("2006-01", row.Month)
+		if err != nil {
+			return nil, fmt.Errorf("解析归档月份 %q 失败: %w", row.Month, err)
+		}
+		archive = append(archive, ArchiveMonth{Year: t.Year(), Month: int(t.Month()), Count: row.Count})
+	}
+	return archive, nil
+}
+
+/*
+ListPostsByMonth 分页查询某个月发布的文章（含作者、分类和标签），按发布时间倒序
+按发布时间的范围查询，可以使用 published_at 的索引
+参数：
+  - db: GORM 数据库连接
+  - year, month: 年份和月份（1-12），按 UTC 划分
+  - page, size: 分页参数，规则见 dbutil.NormalizePage
+
+返回值：
+  - dbutil.Page[Post]: 分页结果
+  - error: 月份不合法时返回 ValidationError
+*/
+func ListPostsByMonth(db *gorm.DB, year, month, page, size int) (dbutil.Page[Post], error) {
+	if month < 1 || month > 12 {
+		return dbutil.Page[Post]{}, &ValidationError{Field: "month", Message: "必须在 1 到 12 之间"}
+	}
+	if year < 1 || year > 9999 {
+		return dbutil.Page[Post]{}, &ValidationError{Field: "year", Message: "必须在 1 到 9999 之间"}
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	var posts []Post
+	query := db.Model(&Post{}).Scopes(publishedPosts).
+		Where("published_at >= ? AND published_at < ?", start, end).
+		Preload("User").Preload("Category").Preload("Tags").
+		Order("published_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	return dbutil.NewPage(posts, total, page, size), nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 附件的限制
+const (
+	MaxAttachmentBytes         = 10 << 20       // 单个附件最大 10MB
+	DefaultOrphanAttachmentAge = 24 * time.Hour // 上传后超过 24 小时仍未关联文章的附件视为孤儿
+	maxFilenameLength          = 255            // 原始文件名最多 255 个字符
+)
+
+// ErrAttachmentNotFound 附件不存在，或当前用户无权查看
+var ErrAttachmentNotFound = errors.New("附件不存在")
+
+// attachmentTypes 允许上传的文件类型及保存时使用的扩展名
+// 类型根据文件内容识别（http.DetectContentType），不信任客户端声明的类型；
+// 不允许 HTML、SVG 等浏览器会执行脚本的类型
+var attachmentTypes = map[string]string{
+	"image/png":                 ".png",
+	"image/jpeg":                ".jpg",
+	"image/gif":                 ".gif",
+	"image/webp":                ".webp",
+	"application/pdf":           ".pdf",
+	"text/plain; charset=utf-8": ".txt",
+}
+
+// Attachment 文章的附件，文件内容保存在 Storage 中，数据库只保存元数据
+// 附件先上传再关联文章；PostID 不建外键，文章被物理删除后附件成为孤儿，由 CleanupOrphanAttachments 清理
+type Attachment struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"` // 上传人
+	PostID      *uint     `gorm:"index" json:"post_id"`          // 所属文章，未关联时为 nil
+	Filename    string    `gorm:"size:255;not null" json:"filename"`
+	ContentType string    `gorm:"size:100;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	StorageKey  string    `gorm:"size:255;not null;uniqueIndex" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	dbutil.AuditFields
+}
+
+// AttachmentCleanup CleanupOrphanAttachments 的清理结果
+type AttachmentCleanup struct {
+	Attachments []Attachment `json:"attachments"` // 删除的孤儿附件
+	Files       []string     `json:"files"`       // 删除的没有附件记录的文件
+}
+
+/*
+SaveAttachment 保存上传的文件并创建附件记录
+先写入存储再创建记录，创建失败时删除已写入的文件；进程在两步之间退出留下的文件由 CleanupOrphanAttachments 清理
+参数：
+  - db: GORM 数据库连接，存储使用其中的 context
+  - storage: 文件存储
+  - userID: 上传人
+  - postID: 关联的文章，为 nil 时稍后通过 AttachToPost 关联
+  - filename: 客户端提供的文件名，只保留最后一段并去掉控制字符
+  - r: 文件内容，最多 MaxAttachmentBytes
+
+返回值：
+  - *Attachment: 创建的附件
+  - error: 文件为空、类型不支持或超出大小限制时返回 ValidationError，文章不存在时返回 ErrPostNotFound
+*/
+func SaveAttachment(db *gorm.DB, storage Storage, userID uint, postID *uint, filename string, r io.Reader) (*Attachment, error) {
+	if postID != nil {
+		if err := ensureExists(db, &Post{}, *postID, ErrPostNotFound); err != nil {
+			return nil, err
+		}
+	}
+
+	// 读取开头的 512 字节识别类型
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return nil, &ValidationError{Field: "file", Message: "不能为空"}
+	}
+	contentType := http.DetectContentType(head)
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		return nil, &ValidationError{Field: "file", Message: "不支持的文件类型 " + contentType}
+	}
+
+	key, err := newStorageKey(ext)
+	if err != nil {
+		return nil, err
+	}
+	ctx := db.Statement.Context
+	// 多读 1 个字节用于判断是否超出限制
+	body := &countingReader{r: io.LimitReader(br, MaxAttachmentBytes+1)}
+	if err := storage.Put(ctx, key, body); err != nil {
+		return nil, err
+	}
+	if body.n > MaxAttachmentBytes {
+		if err := storage.Delete(ctx, key); err != nil {
+			log.Printf("删除超出大小的附件文件 %s 失败: %v", key, err)
+		}
+		return nil, &ValidationError{Field: "file", Message: fmt.Sprintf("最大 %dMB", MaxAttachmentBytes>>20)}
+	}
+
+	attachment := &Attachment{
+		UserID:      userID,
+		PostID:      postID,
+		Filename:    cleanFilename(filename, ext),
+		ContentType: contentType,
+		Size:        body.n,
+		StorageKey:  key,
+	}
+	if err := db.Create(attachment).Error; err != nil {
+		if err := storage.Delete(ctx, key); err != nil {
+			log.Printf("删除附件文件 %s 失败: %v", key, err)
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// 按 ID 查询附件，不存在时返回 ErrAttachmentNotFound
+func GetAttachment(db *gorm.DB, id uint) (*Attachment, error) {
+	var attachment Attachment
+	if err := db.First(&attachment, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// 查询文章的附件，按上传顺序排列；文章不存在、已删除或未发布时返回 ErrPostNotFound
+func ListPostAttachments(db *gorm.DB, postID uint) ([]Attachment, error) {
+	if err := ensureExists(db.Scopes(publishedPosts), &Post{}, postID, ErrPostNotFound); err != nil {
+		return nil, err
+	}
+	attachments := []Attachment{}
+	if err := db.Where("post_id = ?", postID).Order("id").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// 把附件关联到文章，postID 为 nil 时取消关联（之后按孤儿附件清理）
+func AttachToPost(db *gorm.DB, id uint, postID *uint) (*Attachment, error) {
+	if postID != nil {
+		if err := ensureExists(db, &Post{}, *postID, ErrPostNotFound); err != nil {
+			return nil, err
+		}
+	}
+	// MySQL 的 RowsAffected 不包括值未改变的行，不能据此判断附件是否存在
+	if err := db.Model(&Attachment{}).Where("id = ?", id).Update("post_id", postID).Error; err != nil {
+		return nil, err
+	}
+	return GetAttachment(db, id)
+}
+
+// 删除附件记录和文件；文件删除失败时只记录日志，留下的文件由 CleanupOrphanAttachments 清理
+func DeleteAttachment(db *gorm.DB, storage Storage, id uint) error {
+	attachment, err := GetAttachment(db, id)
+	if err != nil {
+		return err
+	}
+	if err := db.Delete(attachment).Error; err != nil {
+		return err
+	}
+	if err := storage.Delete(db.Statement.Context, attachment.StorageKey); err != nil {
+		log.Printf("删除附件文件 %s 失败: %v", attachment.StorageKey, err)
+	}
+	return nil
+}
+
+// AttachmentVisible 判断用户能否下载附件：上传人总是可以，其他人只能下载已关联已发布文章的附件
+func AttachmentVisible(db *gorm.DB, attachment *Attachment, viewerID uint) (bool, error) {
+	if viewerID != 0 && attachment.UserID == viewerID {
+		return true, nil
+	}
+	if attachment.PostID == nil {
+		return false, nil
+	}
+	// 未发布文章的附件只有上传人可以下载
+	err := ensureExists(db.Scopes(publishedPosts), &Post{}, *attachment.PostID, ErrPostNotFound)
+	if errors.Is(err, ErrPostNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+CleanupOrphanAttachments 清理孤儿附件和存储中没有记录的文件
+孤儿附件指上传超过 olderThan 仍未关联文章，或关联的文章已被物理删除的附件（软删除的文章可以恢复，附件保留）；
+存储中修改时间超过 olderThan 且没有附件记录的文件（上传中途失败留下的）也会被删除。
+olderThan 给上传和关联留出时间，避免删除正在上传或刚上传还未关联的文件
+参数：
+  - db: GORM 数据库连接，存储使用其中的 context
+  - storage: 文件存储
+  - olderThan: 只清理早于这个时长的附件和文件，<= 0 时使用 DefaultOrphanAttachmentAge
+
+返回值：
+  - AttachmentCleanup: 删除的附件和文件
+  - error: 查询或删除失败时返回，此前已删除的部分不会恢复
+*/
+func CleanupOrphanAttachments(db *gorm.DB, storage Storage, olderThan time.Duration) (AttachmentCleanup, error) {
+	if olderThan <= 0 {
+		olderThan = DefaultOrphanAttachmentAge
+	}
+	cutoff := time.Now().Add(-olderThan)
+	ctx := db.Statement.Context
+	result := AttachmentCleanup{Attachments: []Attachment{}, Files: []string{}}
+
+	// 包括已软删除的文章
+	allPosts := db.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&Post{}).Select("id")
+	orphan := db.Session(&gorm.Session{NewDB: true}).
+		Where("post_id IS NULL OR post_id NOT IN (?)", allPosts)
+
+	var orphans []Attachment
+	if err := db.Where("created_at < ?", cutoff).Where(orphan).Order("id").Find(&orphans).Error; err != nil {
+		return result, err
+	}
+	for _, attachment := range orphans {
+		// 查询之后可能刚被关联到文章，删除时再检查一次
+		deleted := db.Where("id = ?", attachment.ID).Where(orphan).Delete(&Attachment{})
+		if deleted.Error != nil {
+			return result, deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			continue
+		}
+		result.Attachments = append(result.Attachments, attachment)
+		if err := storage.Delete(ctx, attachment.StorageKey); err != nil {
+			return result, err
+		}
+	}
+
+	// 存储中没有记录的文件
+	var keys []string
+	err := storage.List(ctx, func(key string, modTime time.Time) error {
+		if modTime.Before(cutoff) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	const batchSize = 500
+	for start := 0; start < len(keys); start += batchSize {
+		batch := keys[start:min(start+batchSize, len(keys))]
+		var known []string
+		if err := db.Model(&Attachment{}).Where("storage_key IN ?", batch).Pluck("storage_key", &known).Error; err != nil {
+			return result, err
+		}
+		exists := make(map[string]bool, len(known))
+		for _, key := range known {
+			exists[key] = true
+		}
+		for _, key := range batch {
+			if exists[key] {
+				continue
+			}
+			if err := storage.Delete(ctx, key); err != nil {
+				return result, err
+			}
+			result.Files = append(result.Files, key)
+		}
+	}
+	return result, nil
+}
+
+// newStorageKey 生成随机的存储 key，按前两个字符分目录，避免单个目录下文件过多
+func newStorageKey(ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(b)
+	return name[:2] + "/" + name + ext, nil
+}
+
+// cleanFilename 只保留文件名的最后一段，去掉控制字符并限制长度；结果为空时使用 attachment + 扩展名
+func cleanFilename(name, ext string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return "attachment" + ext
+	}
+	if utf8.RuneCountInString(name) > maxFilenameLength {
+		name = string([]rune(name)[:maxFilenameLength])
+	}
+	return name
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// JWTSecretEnv 保存 JWT 签名密钥的环境变量，值为 base64 编码、至少 32 字节的随机数
+// 可以用 `openssl rand -base64 32` 生成
+const JWTSecretEnv = "BLOG_JWT_SECRET"
+
+// DefaultTokenTTL 登录后颁发的 token 的默认有效期
+const DefaultTokenTTL = 24 * time.Hour
+
+var (
+	// ErrEmailTaken 注册的邮箱已被使用
+	ErrEmailTaken = errors.New("邮箱已被注册")
+	// ErrInvalidCredentials 邮箱不存在或密码错误，两者不区分，避免泄露邮箱是否注册
+	ErrInvalidCredentials = errors.New("邮箱或密码错误")
+	// ErrInvalidToken token 格式错误、签名不匹配或已过期
+	ErrInvalidToken = errors.New("无效的登录凭证")
+	// ErrForbidden 已登录，但不是资源的所有者
+	ErrForbidden = errors.New("没有权限操作该资源")
+)
+
+// 用户角色，注册的用户都是 RoleUser，其他角色只能直接在数据库中修改
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // 可以审核评论
+	RoleAdmin     = "admin"     // 可以审核评论和管理标签
+)
+
+// CanModerate 用户是否可以审核评论
+func (u *User) CanModerate() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+// SetPassword 校验密码长度并保存 bcrypt 哈希，需要再保存 User 才会写入数据库
+func (u *User) SetPassword(password string) error {
+	hash, err := dbutil.HashPassword(password)
+	if errors.Is(err, dbutil.ErrPasswordLength) {
+		return &ValidationError{Field: "password", Message: fmt.Sprintf("长度必须在 %d 到 %d 个字节之间", dbutil.MinPasswordLen, dbutil.MaxPasswordLen)}
+	}
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	return nil
+}
+
+// CheckPassword 校验密码，不匹配或用户没有设置密码时返回 ErrInvalidCredentials
+func (u *User) CheckPassword(password string) error {
+	err := dbutil.CheckPassword(u.PasswordHash, password)
+	if errors.Is(err, dbutil.ErrPasswordMismatch) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// BeforeSave 在 Create/Save/Update 前检查要写入的 password_hash 是 bcrypt 哈希，见 dbutil.GuardPasswordHash
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	return dbutil.GuardPasswordHash(tx, u.PasswordHash)
+}
+
+// 注册用户，邮箱统一转为小写，已被使用时返回 ErrEmailTaken
+func RegisterUser(db *gorm.DB, name, email, password string) (*User, error) {
+	user := &User{Name: strings.TrimSpace(name), Email: normalizeEmail(email)}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Where("email = ?", user.Email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+		return tx.Create(user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 校验邮箱和密码，成功时返回用户
+func Authenticate(db *gorm.DB, email, password string) (*User, error) {
+	var user User
+	err := db.Where("email = ?", normalizeEmail(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 邮箱不存在时也计算一次哈希，响应时间不会暴露邮箱是否注册
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := user.CheckPassword(password); err != nil {
+		return nil, err
+	}
+	// 密码正确后才提示封禁，不向不知道密码的人暴露账号状态
+	if user.SuspendedAt != nil {
+		return nil, ErrUserSuspended
+	}
+	return &user, nil
+}
+
+// dummyPasswordHash 用于邮箱不存在时的比较，内容无关紧要，强度与真实密码相同
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), dbutil.PasswordCost)
+	return hash
+})
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ---------- JWT ----------
+
+/*
+TokenIssuer 颁发和校验 HS256 签名的 JWT，token 中只保存用户ID（sub）、签发时间（iat）和过期时间（exp）
+token 无状态，服务端不保存会话：退出登录由客户端丢弃 token，
+更换 Secret 会使所有已颁发的 token 失效
+*/
+type TokenIssuer struct {
+	Secret []byte        // 签名密钥，至少 32 字节
+	TTL    time.Duration // 有效期，<= 0 时使用 DefaultTokenTTL
+
+	now func() time.Time // 测试中替换当前时间
+}
+
+// jwtHeader 固定的 JWT 头部，只支持 HS256
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// tokenClaims token 的载荷
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewTokenIssuer 创建 TokenIssuer，secret 不足 32 字节时返回错误
+func NewTokenIssuer(secret []byte, ttl time.Duration) (*TokenIssuer, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("JWT 签名密钥至少 32 字节，实际 %d 字节", len(secret))
+	}
+	return &TokenIssuer{Secret: secret, TTL: ttl}, nil
+}
+
+// LoadTokenIssuer 从环境变量 JWTSecretEnv 读取签名密钥
+// 没有设置时生成随机密钥并返回 generated = true，此时服务重启后之前颁发的 token 全部失效
+func LoadTokenIssuer(ttl time.Duration) (issuer *TokenIssuer, generated bool, err error) {
+	value := os.Getenv(JWTSecretEnv)
+	if value == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, false, err
+		}
+		issuer, err := NewTokenIssuer(secret, ttl)
+		return issuer, true, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("解析 %s 失败: %w", JWTSecretEnv, err)
+	}
+	issuer, err = NewTokenIssuer(secret, ttl)
+	return issuer, false, err
+}
+
+func (ti *TokenIssuer) clock() time.Time {
+	if ti.now != nil {
+		return ti.now()
+	}
+	return time.Now()
+}
+
+// Issue 为用户颁发 token，返回 token 和过期时间
+func (ti *TokenIssuer) Issue(userID uint) (string, time.Time, error) {
+	ttl := ti.TTL
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	now := ti.clock()
+	expiresAt := now.Add(ttl)
+	payload, err := json.Marshal(tokenClaims{
+		Subject:   strconv.FormatUint(uint64(userID), 10),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(ti.sign(signingInput)), expiresAt, nil
+}
+
+// Parse 校验 token 的签名和有效期，返回其中的用户ID
+// 只接受本服务颁发的 HS256 token，头部不一致（如 alg: none）时直接拒绝
+func (ti *TokenIssuer) Parse(token string) (uint, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return 0, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, ti.sign(parts[0]+"."+parts[1])) {
+		return 0, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return 0, ErrInvalidToken
+	}
+	if ti.clock().Unix() >= claims.ExpiresAt {
+		return 0, fmt.Errorf("%w: 已过期", ErrInvalidToken)
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 0)
+	if err != nil || userID == 0 {
+		return 0, ErrInvalidToken
+	}
+	return uint(userID), nil
+}
+
+func (ti *TokenIssuer) sign(signingInput string) []byte {
+	mac := hmac.New(sha256.New, ti.Secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// deriveKey 从签名密钥派生出其他用途（如分页游标签名）的密钥，同一个密钥不直接用于不同用途
+func (ti *TokenIssuer) deriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, ti.Secret)
+	mac.Write([]byte("derive:" + purpose))
+	return mac.Sum(nil)
+}
+
+// ---------- 当前用户 ----------
+
+// currentUserKey context 中保存当前登录用户的键
+type currentUserKey struct{}
+
+// withCurrentUser 返回带有当前登录用户的 context
+func withCurrentUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, currentUserKey{}, user)
+}
+
+// CurrentUser 取出 requireUser 注入的当前登录用户
+func CurrentUser(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(currentUserKey{}).(*User)
+	return user, ok
+}
+
+// bearerToken 取出 Authorization: Bearer <token> 中的 token
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requireUser 要求请求携带有效 token 的中间件
+// 校验通过后把当前用户放入 context（见 CurrentUser），并作为 AuditPlugin 的操作人；
+// 用户已被删除时 token 同样无效
+func (s *Server) requireUser(h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		token, ok := bearerToken(r)
+		if !ok {
+			return ErrInvalidToken
+		}
+		return s.withUser(h, token)(w, r, db)
+	}
+}
+
+// requireModerator 要求当前用户可以审核评论（见 User.CanModerate）的中间件，
+// 未登录时返回 ErrInvalidToken，没有权限时返回 ErrForbidden
+func (s *Server) requireModerator(h handlerFunc) handlerFunc {
+	return s.requireUser(func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		if user, _ := CurrentUser(r.Context()); !user.CanModerate() {
+			return ErrForbidden
+		}
+		return h(w, r, db)
+	})
+}
+
+// requireAdmin 要求当前用户是管理员的中间件，错误同 requireModerator
+func (s *Server) requireAdmin(h handlerFunc) handlerFunc {
+	return s.requireUser(func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		if user, _ := CurrentUser(r.Context()); user.Role != RoleAdmin {
+			return ErrForbidden
+		}
+		return h(w, r, db)
+	})
+}
+
+// optionalUser 登录可选的中间件，用于公开接口根据当前用户返回不同内容（如是否点赞过）
+// 没有 Authorization 请求头时按未登录处理；携带了 token 但无效时与 requireUser 一样返回 401，
+// 避免客户端以为自己已登录
+func (s *Server) optionalUser(h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		if r.Header.Get("Authorization") == "" {
+			return h(w, r, db)
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			return ErrInvalidToken
+		}
+		return s.withUser(h, token)(w, r, db)
+	}
+}
+
+// withUser 校验 token 并加载用户，放入 context 后调用 h
+func (s *Server) withUser(h handlerFunc, token string) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		userID, err := s.tokens.Parse(token)
+		if err != nil {
+			return err
+		}
+		var user User
+		if err := db.First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+		// 封禁前签发的 token 同样失效
+		if user.SuspendedAt != nil {
+			return ErrUserSuspended
+		}
+
+		ctx := dbutil.WithActor(withCurrentUser(r.Context(), &user), user.ID)
+		return h(w, r.WithContext(ctx), db.WithContext(ctx))
+	}
+}
+
+// viewerID 当前登录用户的ID，未登录时为 0
+func viewerID(ctx context.Context) uint {
+	if user, ok := CurrentUser(ctx); ok {
+		return user.ID
+	}
+	return 0
+}
+
+// checkOwner 检查 model 对应表中主键为 id 的记录属于 userID
+// 记录不存在时返回 notFound，属于其他用户时返回 ErrForbidden
+func checkOwner(db *gorm.DB, model interface{}, id, userID uint, notFound error) error {
+	var owners []uint
+	if err := db.Model(model).Where("id = ?", id).Pluck("user_id", &owners).Error; err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return notFound
+	}
+	if owners[0] != userID {
+		return ErrForbidden
+	}
+	return nil
+}
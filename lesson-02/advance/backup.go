@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"time"
+)
+
+// BackupVersion 备份文件的格式版本，格式不兼容地修改时递增，ImportBlog 只接受相同版本的备份
+const BackupVersion = 1
+
+// ErrDatabaseNotEmpty 导入的目标数据库已有用户、分类、文章或评论
+var ErrDatabaseNotEmpty = errors.New("只能导入到空数据库")
+
+/*
+Backup ExportBlog 导出的备份文件，包含用户、标签、分类、文章和评论
+记录之间通过导出时的 ID 关联，导入时重新分配 ID。
+点赞、关注、通知、附件、浏览统计、举报和审核日志不在备份中，导入后点赞数和关注数为 0
+*/
+type Backup struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Users      []BackupUser     `json:"users"`
+	Tags       []BackupTag      `json:"tags"`
+	Categories []BackupCategory `json:"categories"`
+	Posts      []BackupPost     `json:"posts"`
+	Comments   []BackupComment  `json:"comments"`
+}
+
+// BackupUser 备份中的用户，包含密码哈希，导入后可以用原来的密码登录
+type BackupUser struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"password_hash"`
+	Role         string     `json:"role"`
+	SuspendedAt  *time.Time `json:"suspended_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type BackupTag struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BackupCategory struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	ParentID    *uint     `json:"parent_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BackupPost 备份中的文章，包括回收站中的文章（DeletedAt 不为 nil）
+type BackupPost struct {
+	ID          uint       `json:"id"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	UserID      uint       `json:"user_id"`
+	CategoryID  *uint      `json:"category_id"`
+	TagIDs      []uint     `json:"tag_ids"`
+	Status      string     `json:"status"`
+	PublishedAt time.Time  `json:"published_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// BackupComment 备份中的评论，包括回收站中的评论和审核状态
+type BackupComment struct {
+	ID               uint       `json:"id"`
+	Content          string     `json:"content"`
+	UserID           uint       `json:"user_id"`
+	PostID           uint       `json:"post_id"`
+	ParentID         *uint      `json:"parent_id"`
+	Status           string     `json:"status"`
+	ModerationReason string     `json:"moderation_reason,omitempty"`
+	ModeratedBy      *uint      `json:"moderated_by,omitempty"`
+	ModeratedAt      *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ImportStats ImportBlog 导入的记录数量，Tags 只统计新建的标签，与已有标签同名的直接使用已有的
+type ImportStats struct {
+	Users      int `json:"users"`
+	Tags       int `json:"tags"`
+	Categories int `json:"categories"`
+	Posts      int `json:"posts"`
+	Comments   int `json:"comments"`
+}
+
+// deletedAtPtr 把软删除时间转换为备份中的指针，未删除时返回 nil
+func deletedAtPtr(deletedAt gorm.DeletedAt) *time.Time {
+	if !deletedAt.Valid {
+		return nil
+	}
+	t := deletedAt.Time
+	return &t
+}
+
+/*
+ExportBlog 把用户、标签、分类、文章（含标签关联）和评论导出为 JSON，格式见 Backup
+包括回收站中的文章和评论；所有查询在同一事务中完成，导出的是一致的快照
+参数：
+  - db: GORM 数据库连接
+  - w: 写入 JSON 的目标
+
+返回值：
+  - error: 查询或写入失败时返回
+*/
+func ExportBlog(db *gorm.DB, w io.Writer) error {
+	backup := Backup{Version: BackupVersion, ExportedAt: time.Now().UTC()}
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var users []User
+		if err := tx.Order("id").Find(&users).Error; err != nil {
+			return err
+		}
+		backup.Users = make([]BackupUser, len(users))
+		for i, u := range users {
+			backup.Users[i] = BackupUser{
+				ID: u.ID, Name: u.Name, Email: u.Email, PasswordHash: u.PasswordHash, Role: u.Role,
+				SuspendedAt: u.SuspendedAt, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt,
+			}
+		}
+
+		var tags []Tag
+		if err := tx.Order("id").Find(&tags).Error; err != nil {
+			return err
+		}
+		backup.Tags = make([]BackupTag, len(tags))
+		for i, t := range tags {
+			backup.Tags[i] = BackupTag{ID: t.ID, Name: t.Name, CreatedAt: t.CreatedAt}
+		}
+
+		var categories []Category
+		if err := tx.Order("id").Find(&categories).Error; err != nil {
+			return err
+		}
+		backup.Categories = make([]BackupCategory, len(categories))
+		for i, c := range categories {
+			backup.Categories[i] = BackupCategory{
+				ID: c.ID, Name: c.Name, Slug: c.Slug, Description: c.Description, ParentID: c.ParentID,
+				CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt,
+			}
+		}
+
+		var postTags []struct{ PostID, TagID uint }
+		if err := tx.Table("post_tags").Order("post_id, tag_id").Find(&postTags).Error; err != nil {
+			return err
+		}
+		tagIDs := make(map[uint][]uint)
+		for _, pt := range postTags {
+			tagIDs[pt.PostID] = append(tagIDs[pt.PostID], pt.TagID)
+		}
+		var posts []Post
+		if err := tx.Unscoped().Order("id").Find(&posts).Error; err != nil {
+			return err
+		}
+		backup.Posts = make([]BackupPost, len(posts))
+		for i, p := range posts {
+			backup.Posts[i] = BackupPost{
+				ID: p.ID, Title: p.Title, Content: p.Content, UserID: p.UserID, CategoryID: p.CategoryID,
+				TagIDs: tagIDs[p.ID], Status: p.Status, PublishedAt: p.PublishedAt,
+				CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt, DeletedAt: deletedAtPtr(p.DeletedAt),
+			}
+		}
+
+		var comments []Comment
+		if err := tx.Unscoped().Order("id").Find(&comments).Error; err != nil {
+			return err
+		}
+		backup.Comments = make([]BackupComment, len(comments))
+		for i, c := range comments {
+			backup.Comments[i] = BackupComment{
+				ID: c.ID, Content: c.Content, UserID: c.UserID, PostID: c.PostID, ParentID: c.ParentID,
+				Status: c.Status, ModerationReason: c.ModerationReason, ModeratedBy: c.ModeratedBy, ModeratedAt: c.ModeratedAt,
+				CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, DeletedAt: deletedAtPtr(c.DeletedAt),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(backup)
+}
+
+/*
+ImportBlog 把 ExportBlog 导出的备份导入到空数据库，所有记录在同一事务中创建，任何一条失败都会整体回滚
+记录重新分配 ID，关联按备份中的 ID 映射到新记录；标签按名称匹配已有的标签（如种子数据），没有时新建。
+分类和评论按层级先创建上级再创建下级，与备份中的顺序无关；评论的提及记录根据内容重新解析，
+作者的文章数量导入后用 RecountPostCounts 重新统计
+参数：
+  - db: GORM 数据库连接
+  - r: 备份 JSON
+
+返回值：
+  - ImportStats: 导入的记录数量
+  - error: 数据库中已有用户、分类、文章或评论时返回 ErrDatabaseNotEmpty；
+    版本不匹配或关联的记录不在备份中时返回说明原因的错误；创建失败时返回数据库错误
+*/
+func ImportBlog(db *gorm.DB, r io.Reader) (ImportStats, error) {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return ImportStats{}, fmt.Errorf("解析备份失败: %w", err)
+	}
+	if backup.Version != BackupVersion {
+		return ImportStats{}, fmt.Errorf("不支持的备份版本 %d，当前版本为 %d", backup.Version, BackupVersion)
+	}
+
+	var stats ImportStats
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		stats = ImportStats{}
+		for _, model := range []interface{}{&User{}, &Category{}, &Post{}, &Comment{}} {
+			var count int64
+			if err := tx.Unscoped().Model(model).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrDatabaseNotEmpty
+			}
+		}
+
+		userIDs := make(map[uint]uint, len(backup.Users))
+		for _, u := range backup.Users {
+			user := User{
+				Name: u.Name, Email: u.Email, PasswordHash: u.PasswordHash, Role: u.Role,
+				SuspendedAt: u.SuspendedAt, CreatedAt: u.CreatedAt, UpdatedAt: u.UpdatedAt,
+			}
+			if user.Role == "" {
+				user.Role = RoleUser
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			userIDs[u.ID] = user.ID
+		}
+		stats.Users = len(userIDs)
+
+		tagIDs := make(map[uint]uint, len(backup.Tags))
+		for _, t := range backup.Tags {
+			var tag Tag
+			err := tx.Where("name = ?", t.Name).Take(&tag).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				tag = Tag{Name: t.Name, CreatedAt: t.CreatedAt}
+				err = tx.Create(&tag).Error
+				stats.Tags++
+			}
+			if err != nil {
+				return err
+			}
+			tagIDs[t.ID] = tag.ID
+		}
+
+		categoryIDs, err := importCategories(tx, backup.Categories)
+		if err != nil {
+			return err
+		}
+		stats.Categories = len(categoryIDs)
+
+		postIDs := make(map[uint]uint, len(backup.Posts))
+		for _, p := range backup.Posts {
+			userID, ok := userIDs[p.UserID]
+			if !ok {
+				return fmt.Errorf("文章 %d 的作者 %d 不在备份中", p.ID, p.UserID)
+			}
+			post := Post{
+				Title: p.Title, Content: p.Content, UserID: userID, Status: p.Status,
+				PublishedAt: p.PublishedAt, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt,
+			}
+			if p.CategoryID != nil {
+				categoryID, ok := categoryIDs[*p.CategoryID]
+				if !ok {
+					return fmt.Errorf("文章 %d 的分类 %d 不在备份中", p.ID, *p.CategoryID)
+				}
+				post.CategoryID = &categoryID
+			}
+			if p.DeletedAt != nil {
+				post.DeletedAt = gorm.DeletedAt{Time: *p.DeletedAt, Valid: true}
+			}
+			if err := tx.Create(&post).Error; err != nil {
+				return err
+			}
+			for _, oldTagID := range p.TagIDs {
+				tagID, ok := tagIDs[oldTagID]
+				if !ok {
+					return fmt.Errorf("文章 %d 的标签 %d 不在备份中", p.ID, oldTagID)
+				}
+				if err := tx.Exec("INSERT INTO post_tags (post_id, tag_id) VALUES (?, ?)", post.ID, tagID).Error; err != nil {
+					return err
+				}
+			}
+			postIDs[p.ID] = post.ID
+		}
+		stats.Posts = len(postIDs)
+
+		commentIDs, err := importComments(tx, backup.Comments, userIDs, postIDs)
+		if err != nil {
+			return err
+		}
+		stats.Comments = len(commentIDs)
+
+		// AfterCreate 把回收站中的文章也计入了文章数量，重新统计
+		_, err = RecountPostCounts(tx)
+		return err
+	})
+	if err != nil {
+		return ImportStats{}, err
+	}
+	return stats, nil
+}
+
+// runBackupCommand 执行 blog export/import 子命令，command 为 export 时把备份写入 path，否则从 path 导入
+func runBackupCommand(db *gorm.DB, command, path string) error {
+	if command == "export" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := ExportBlog(db, f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("已导出备份到 %s\n", path)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stats, err := ImportBlog(db, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("导入完成：%d 个用户，%d 个新标签，%d 个分类，%d 篇文章，%d 条评论\n",
+		stats.Users, stats.Tags, stats.Categories, stats.Posts, stats.Comments)
+	return nil
+}
+
+// importCategories 按层级创建分类，返回备份中的 ID 到新 ID 的映射
+func importCategories(tx *gorm.DB, categories []BackupCategory) (map[uint]uint, error) {
+	return createByLevel("分类", categories,
+		func(c BackupCategory) (uint, *uint) { return c.ID, c.ParentID },
+		func(c BackupCategory, parentID *uint) (uint, error) {
+			category := Category{
+				Name: c.Name, Slug: c.Slug, Description: c.Description, ParentID: parentID,
+				CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt,
+			}
+			err := tx.Create(&category).Error
+			return category.ID, err
+		})
+}
+
+// importComments 按层级创建评论，返回备份中的 ID 到新 ID 的映射
+// 审核员不在备份中时（如已被删除）清空 ModeratedBy，保留审核状态
+func importComments(tx *gorm.DB, comments []BackupComment, userIDs, postIDs map[uint]uint) (map[uint]uint, error) {
+	return createByLevel("评论", comments,
+		func(c BackupComment) (uint, *uint) { return c.ID, c.ParentID },
+		func(c BackupComment, parentID *uint) (uint, error) {
+			userID, ok := userIDs[c.UserID]
+			if !ok {
+				return 0, fmt.Errorf("评论 %d 的作者 %d 不在备份中", c.ID, c.UserID)
+			}
+			postID, ok := postIDs[c.PostID]
+			if !ok {
+				return 0, fmt.Errorf("评论 %d 的文章 %d 不在备份中", c.ID, c.PostID)
+			}
+			comment := Comment{
+				Content: c.Content, UserID: userID, PostID: postID, ParentID: parentID,
+				Status: c.Status, ModerationReason: c.ModerationReason, ModeratedAt: c.ModeratedAt,
+				CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt,
+			}
+			if c.ModeratedBy != nil {
+				if moderatorID, ok := userIDs[*c.ModeratedBy]; ok {
+					comment.ModeratedBy = &moderatorID
+				}
+			}
+			if c.DeletedAt != nil {
+				comment.DeletedAt = gorm.DeletedAt{Time: *c.DeletedAt, Valid: true}
+			}
+			if err := tx.Create(&comment).Error; err != nil {
+				return 0, err
+			}
+			// 提及记录不在备份中，根据内容重新解析
+			return comment.ID, saveMentions(tx, &comment)
+		})
+}
+
+/*
+createByLevel 按层级创建有上级的记录（分类、评论），每一轮创建上级已经创建或没有上级的记录，
+直到全部创建，这样不依赖备份中的顺序，也满足 parent_id 外键
+参数：
+  - kind: 记录的名称，用于错误信息
+  - items: 备份中的记录
+  - key: 返回记录在备份中的 ID 和上级 ID
+  - create: 创建记录，parentID 为上级的新 ID，返回新 ID
+
+返回值：
+  - map[uint]uint: 备份中的 ID 到新 ID 的映射
+  - error: 创建失败，或上级不在备份中（包括循环引用）时返回
+*/
+func createByLevel[T any](kind string, items []T, key func(T) (uint, *uint), create func(T, *uint) (uint, error)) (map[uint]uint, error) {
+	ids := make(map[uint]uint, len(items))
+	pending := items
+	for len(pending) > 0 {
+		var next []T
+		for _, item := range pending {
+			oldID, oldParentID := key(item)
+			var parentID *uint
+			if oldParentID != nil {
+				newParentID, ok := ids[*oldParentID]
+				if !ok {
+					next = append(next, item)
+					continue
+				}
+				parentID = &newParentID
+			}
+			newID, err := create(item, parentID)
+			if err != nil {
+				return nil, err
+			}
+			ids[oldID] = newID
+		}
+		if len(next) == len(pending) {
+			oldID, oldParentID := key(next[0])
+			return nil, fmt.Errorf("%s %d 的上级 %d 不在备份中", kind, oldID, *oldParentID)
+		}
+		pending = next
+	}
+	return ids, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"gohomeworklesson02/migrations"
+	"gohomeworklesson02/testutil"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func init() {
+	testutil.RegisterBenchSeed(seedBenchData)
+}
+
+// benchSeedStart 基准测试数据的起始时间，第 i 篇文章在此后第 i 分钟发布，保证每次生成的数据相同
+var benchSeedStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+/*
+seedBenchData 基准测试数据集，由 testutil.NewBenchDB 调用并缓存
+参数：
+  - db: 空的 SQLite 数据库
+  - rows: 文章数量；每 10 篇文章一个作者，第 i 篇文章有 1+i%5 条评论，其中第一条已删除
+
+返回值：
+  - error: 迁移或写入失败时返回
+*/
+func seedBenchData(db *gorm.DB, rows int) error {
+	runner, err := migrations.NewRunner(db, blogMigrations...)
+	if err != nil {
+		return err
+	}
+	if _, err := runner.Up(); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		users := make([]User, rows/10+1)
+		for i := range users {
+			users[i] = User{Name: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@bench.test", i)}
+		}
+		if err := tx.CreateInBatches(users, 500).Error; err != nil {
+			return err
+		}
+
+		posts := make([]Post, rows)
+		for i := range posts {
+			posts[i] = Post{
+				Title:     fmt.Sprintf("post %d", i),
+				Content:   fmt.Sprintf("content of post %d", i),
+				UserID:    users[i/10].ID,
+				CreatedAt: benchSeedStart.Add(time.Duration(i) * time.Minute),
+			}
+		}
+		if err := tx.CreateInBatches(posts, 500).Error; err != nil {
+			return err
+		}
+
+		var comments []Comment
+		for i, post := range posts {
+			for j := 0; j < 1+i%5; j++ {
+				comments = append(comments, Comment{
+					Content: fmt.Sprintf("comment %d on post %d", j, i),
+					PostID:  post.ID,
+					UserID:  users[(i+j)%len(users)].ID,
+				})
+			}
+		}
+		if err := tx.CreateInBatches(comments, 500).Error; err != nil {
+			return err
+		}
+		// 每篇文章的第一条评论已删除，计数时应排除
+		return tx.Where("content LIKE ?", "comment 0 on post %").Delete(&Comment{}).Error
+	})
+}
+
+// getPostsWithCommentCountN1 GetPostsWithCommentCount 原来的实现：预加载全部评论后逐篇 Count，
+// 查询次数随文章数量线性增长，只用于对比测试
+func getPostsWithCommentCountN1(db *gorm.DB) ([]PostWithCount, error) {
+	var posts []Post
+	var result []PostWithCount
+
+	err := db.
+		Model(&Post{}).
+		Preload("Comments").
+		Preload("User").
+		Preload("Tags").
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, post := range posts {
+		var count int64
+		if err := db.Model(&Comment{}).Where("post_id = ?", post.ID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		result = append(result, PostWithCount{Post: post, CommentCount: count})
+	}
+	return result, nil
+}
+
+// seedPostsWithComments 创建 posts 篇文章，第 i 篇有 i%5 条评论，每篇文章有一条已删除的评论
+func seedPostsWithComments(tb testing.TB, db *gorm.DB, posts int) {
+	tb.Helper()
+	user := newUser(tb, db, func(u *User) { u.Name = "Alice" })
+	for i := 0; i < posts; i++ {
+		post := newPost(tb, db, user)
+		comments := newComments(tb, db, post, user, 1+i%5)
+		if err := db.Delete(&comments[0]).Error; err != nil {
+			tb.Fatalf("delete comment: %v", err)
+		}
+	}
+}
+
+// TestGetPostsWithCommentCount 测试聚合查询与逐篇 Count 的结果一致
+func TestGetPostsWithCommentCount(t *testing.T) {
+	_, db := newTestServer(t)
+	seedPostsWithComments(t, db, 12)
+
+	want, err := getPostsWithCommentCountN1(db)
+	if err != nil {
+		t.Fatalf("N+1: %v", err)
+	}
+	counts := make(map[uint]int64, len(want))
+	for _, p := range want {
+		counts[p.ID] = p.CommentCount
+	}
+
+	var seen int
+	for page := 1; ; page++ {
+		result, err := GetPostsWithCommentCount(db, page, 5)
+		if err != nil {
+			t.Fatalf("GetPostsWithCommentCount: %v", err)
+		}
+		if result.Total != 12 {
+			t.Errorf("Total = %d，预期 12", result.Total)
+		}
+		for _, p := range result.Items {
+			if p.CommentCount != counts[p.ID] {
+				t.Errorf("文章 %d 的评论数 %d，预期 %d", p.ID, p.CommentCount, counts[p.ID])
+			}
+			if p.User == nil || p.User.Name != "Alice" {
+				t.Errorf("文章 %d 应预加载作者", p.ID)
+			}
+			seen++
+		}
+		if !result.HasNext {
+			break
+		}
+	}
+	if seen != 12 {
+		t.Errorf("分页共返回 %d 篇文章，预期 12", seen)
+	}
+
+	// 查询次数固定，不随文章数量增长；逐篇 Count 的实现每篇文章多一次查询
+	recorded, queries := testutil.RecordQueries(db)
+	if _, err := GetPostsWithCommentCount(recorded, 1, 12); err != nil {
+		t.Fatalf("GetPostsWithCommentCount: %v", err)
+	}
+	testutil.AssertQueryCount(t, queries, 4) // 总数、文章和评论数、预加载的作者和标签
+	queries.Reset()
+	if _, err := getPostsWithCommentCountN1(recorded); err != nil {
+		t.Fatalf("N+1: %v", err)
+	}
+	if n := len(queries.ExecutedQueries()); n < 12 {
+		t.Errorf("逐篇 Count 执行了 %d 次查询，预期至少 12 次", n)
+	}
+}
+
+// TestBenchData 测试基准测试数据集，以及 BenchmarkListPosts 两种分页查到的最后一页相同
+func TestBenchData(t *testing.T) {
+	const rows, size = 50, 20
+	db := testutil.NewBenchDB(t, rows)
+
+	testutil.AssertCount(t, db, &User{}, nil, rows/10+1)
+	testutil.AssertCount(t, db, &Post{}, nil, rows)
+	testutil.AssertCount(t, db, &Comment{}, nil, 2*rows) // 每 5 篇文章共 0+1+2+3+4 条未删除的评论
+	testutil.AssertNoOrphans(t, db, "comments", "post_id=posts", "user_id=users")
+
+	offset, err := ListPosts(db, 0, 3, size)
+	if err != nil {
+		t.Fatalf("ListPosts: %v", err)
+	}
+	cursor, err := ListPostsByCursor(db, 0, &PostCursor{PublishedAt: benchSeedStart.Add(10 * time.Minute), ID: 11}, size)
+	if err != nil {
+		t.Fatalf("ListPostsByCursor: %v", err)
+	}
+	if len(offset.Items) != 10 || len(cursor.Items) != 10 || cursor.Next != nil {
+		t.Fatalf("最后一页预期 10 篇文章，实际偏移分页 %d 篇、游标分页 %d 篇", len(offset.Items), len(cursor.Items))
+	}
+	for i := range offset.Items {
+		if offset.Items[i].ID != cursor.Items[i].ID {
+			t.Errorf("第 %d 篇文章不同：%d、%d", i, offset.Items[i].ID, cursor.Items[i].ID)
+		}
+	}
+}
+
+// BenchmarkGetPostsWithCommentCount 对比逐篇 Count 与子查询聚合的耗时
+//
+//	go test -run ^$ -bench BenchmarkGetPostsWithCommentCount ./advance
+//
+// 两种实现都查询全部 100 篇文章（聚合版本使用 MaxPageSize 一页取完）
+func BenchmarkGetPostsWithCommentCount(b *testing.B) {
+	db := testutil.NewBenchDB(b, 100)
+
+	b.Run("n+1", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := getPostsWithCommentCountN1(db); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("subquery", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GetPostsWithCommentCount(db, 1, 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkListPosts 对比偏移分页与游标分页翻到最后一页的耗时，数据量越大差距越明显
+//
+//	go test -run ^$ -bench BenchmarkListPosts ./advance
+func BenchmarkListPosts(b *testing.B) {
+	const size = 20
+	for _, rows := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("rows=%d", rows), func(b *testing.B) {
+			db := testutil.NewBenchDB(b, rows)
+			lastPage := (rows + size - 1) / size
+			// 最后一页的游标，即倒数第二页最后一篇文章（最早发布的文章 ID 最小）
+			cursor := &PostCursor{
+				PublishedAt: benchSeedStart.Add(time.Duration(rows-size*(lastPage-1)) * time.Minute),
+				ID:          uint(rows - size*(lastPage-1) + 1),
+			}
+
+			b.Run("offset", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := ListPosts(db, 0, lastPage, size); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("cursor", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := ListPostsByCursor(db, 0, cursor, size); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -1,53 +1,164 @@
 package main
 
 import (
+	"context"
+	"embed"
+	"errors"
 	"fmt"
-	"gorm.io/driver/sqlite"
+	applog "gohomeworklesson01/logger"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/migrations"
+	"gohomeworklesson02/seed"
 	"gorm.io/gorm"
 	"log"
+	"os"
 	"time"
 )
 
 type User struct {
-	ID        uint `gorm:"primaryKey"`
-	Name      string
-	Email     string `gorm:"uniqueIndex"`
-	Posts     []Post `gorm:"foreignKey:UserID"`
-	PostCount uint   `gorm:"default:0"` // 用于统计用户文章数量
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	Name               string     `json:"name"`
+	Email              string     `gorm:"uniqueIndex;size:128" json:"-"` // 不随作者、粉丝等公开信息返回，只有 GET /me 返回本人的邮箱
+	PasswordHash       string     `gorm:"size:60" json:"-"`              // bcrypt 哈希，通过 SetPassword 设置
+	Posts              []Post     `gorm:"foreignKey:UserID" json:"posts,omitempty"`
+	PostCount          uint       `gorm:"default:0" json:"post_count"`               // 未删除的文章数量，由 Post 的钩子维护
+	Role               string     `gorm:"size:16;not null;default:user" json:"role"` // RoleUser、RoleModerator 或 RoleAdmin
+	FollowerCount      uint       `gorm:"default:0" json:"follower_count"`           // 粉丝数，由 FollowUser/UnfollowUser 维护
+	FollowingCount     uint       `gorm:"default:0" json:"following_count"`          // 关注数，同上
+	SuspendedAt        *time.Time `json:"suspended_at,omitempty"`                    // 被封禁的时间，封禁后不能登录，见 ResolveReport
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	dbutil.AuditFields            // 创建人/修改人，由 AuditPlugin 根据 context 中的操作人填充
 }
 
 type Post struct {
-	ID        uint `gorm:"primaryKey"`
-	Title     string
-	Content   string
-	UserID    uint      // Belongs To User
-	User      User      `gorm:"foreignKey:UserID"`
-	Comments  []Comment `gorm:"foreignKey:PostID"`
-	Tags      []Tag     `gorm:"many2many:post_tags;"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"` // 软删除
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Title   string `json:"title"`
+	Content string `json:"content"` // Markdown 原文
+	// Content 渲染后的 HTML 缓存，修改内容时清空，见 RenderPostsHTML
+	ContentHTML string    `gorm:"type:text" json:"content_html,omitempty"`
+	UserID      uint      `json:"user_id"` // Belongs To User
+	User        *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Comments    []Comment `gorm:"foreignKey:PostID" json:"comments,omitempty"`
+	// 显式指定 joinForeignKey，嵌入 Post 的 PostWithCount 预加载标签时同样使用 post_id 列
+	Tags       []Tag     `gorm:"many2many:post_tags;joinForeignKey:PostID" json:"tags,omitempty"`
+	CategoryID *uint     `gorm:"index" json:"category_id"` // 所属分类，可以为空
+	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	LikeCount  uint      `gorm:"default:0" json:"like_count"` // 点赞数，由 LikeTarget/UnlikeTarget 维护
+	LikedByMe  bool      `gorm:"-" json:"liked_by_me"`        // 当前用户是否点赞，见 MarkLikedPosts
+	// 发布时间，用于按月归档；创建时没有指定则与创建时间相同，见 BeforeCreate
+	// 定时发布的文章为计划的发布时间，由 ScheduledPublisher 到期后发布
+	PublishedAt time.Time `gorm:"index" json:"published_at"`
+	// 状态：PostDraft、PostScheduled 或 PostPublished，列表等只查询已发布的文章，见 publishedPosts
+	Status string `gorm:"size:16;not null;default:published;index" json:"status"`
+	// 内容的字数统计，保存时由 BeforeSave 计算，见 MeasureContent
+	WordCount      uint           `gorm:"not null;default:0" json:"word_count"`
+	CharCount      uint           `gorm:"not null;default:0" json:"char_count"`
+	ReadingMinutes uint           `gorm:"not null;default:0" json:"reading_minutes"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"` // 软删除
+	dbutil.AuditFields
+}
+
+// BeforeCreate 没有指定状态时为已发布；没有指定发布时间时使用创建时间，创建时间也没有指定时使用当前时间
+func (p *Post) BeforeCreate(tx *gorm.DB) error {
+	if p.Status == "" {
+		p.Status = PostPublished
+	}
+	if p.PublishedAt.IsZero() {
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = tx.NowFunc()
+		}
+		p.PublishedAt = p.CreatedAt
+	}
+	return nil
+}
+
+// BeforeSave 根据内容计算字数、字符数和阅读时长
+// 按 map 修改（如 UpdatePost）时只有修改了 content 才重新计算；UpdateColumn 不调用钩子
+func (p *Post) BeforeSave(tx *gorm.DB) error {
+	content := p.Content
+	if updates, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		if content, ok = updates["content"].(string); !ok {
+			return nil
+		}
+	}
+	stats := MeasureContent(content)
+	tx.Statement.SetColumn("word_count", stats.Words)
+	tx.Statement.SetColumn("char_count", stats.Chars)
+	tx.Statement.SetColumn("reading_minutes", stats.ReadingMinutes)
+	return nil
+}
+
+// contentStats 返回保存在文章上的字数统计
+func (p *Post) contentStats() ContentStats {
+	return ContentStats{Words: p.WordCount, Chars: p.CharCount, ReadingMinutes: p.ReadingMinutes}
+}
+
+// AfterCreate 增加作者的文章数量，与创建文章在同一事务中
+// 按主键 upsert（如种子数据）已存在的文章时同样会计数，偏差可以用 RecountPostCounts 修复
+func (p *Post) AfterCreate(tx *gorm.DB) error {
+	return adjustPostCount(tx, p.UserID, 1)
+}
+
+// AfterDelete 减少作者的文章数量，软删除和物理删除都会调用
+// 需要先加载 UserID；UserID 为 0 时（如按主键批量删除）不修改计数，
+// 物理删除已软删除的文章（DeletedAt 有效）时也不修改，软删除时已经减过
+func (p *Post) AfterDelete(tx *gorm.DB) error {
+	if tx.Statement.Unscoped && p.DeletedAt.Valid {
+		return nil
+	}
+	return adjustPostCount(tx, p.UserID, -1)
+}
+
+// adjustPostCount 修改作者的文章数量，减少时不会小于 0
+// GORM 没有恢复软删除的钩子，RestorePost 直接调用它增加计数
+func adjustPostCount(tx *gorm.DB, userID uint, step int) error {
+	if userID == 0 {
+		return nil
+	}
+	update := tx.Model(&User{}).Where("id = ?", userID)
+	if step < 0 {
+		update = update.Where("post_count > 0")
+	}
+	return update.UpdateColumn("post_count", gorm.Expr("post_count + ?", step)).Error
 }
 
 type Comment struct {
-	ID        uint `gorm:"primaryKey"`
-	Content   string
-	UserID    uint
-	PostID    uint
-	Post      Post `gorm:"foreignKey:PostID"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"` // 软删除
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	Content  string    `json:"content"`
+	UserID   uint      `json:"user_id"`
+	User     *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	PostID   uint      `json:"post_id"`
+	Post     *Post     `gorm:"foreignKey:PostID" json:"post,omitempty"`
+	ParentID *uint     `gorm:"index" json:"parent_id"` // 回复的评论，顶层评论为 nil
+	Replies  []Comment `gorm:"foreignKey:ParentID" json:"replies,omitempty"`
+	// ReplyCount 直接回复的数量，由 GetPostComments/GetCommentReplies 填充，
+	// 超出加载深度的评论 Replies 为空，可以根据它判断是否还有回复
+	ReplyCount int64 `gorm:"-" json:"reply_count"`
+	LikeCount  uint  `gorm:"default:0" json:"like_count"` // 点赞数，由 LikeTarget/UnlikeTarget 维护
+	LikedByMe  bool  `gorm:"-" json:"liked_by_me"`        // 当前用户是否点赞，见 MarkLikedComments
+	// 转义后的内容，@用户名 渲染为链接，请求 format=html 时由 RenderCommentsHTML 填充
+	ContentHTML string `gorm:"-" json:"content_html,omitempty"`
+	// 审核状态，发表和修改时根据 CommentModeration 自动设置，审核员通过 ModerateComment 修改
+	Status           string         `gorm:"size:16;not null;default:approved;index" json:"status"`
+	ModerationReason string         `gorm:"size:500" json:"moderation_reason,omitempty"` // 进入待审核或被拒绝的原因
+	ModeratedBy      *uint          `json:"moderated_by,omitempty"`                      // 人工审核的审核员
+	ModeratedAt      *time.Time     `json:"moderated_at,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"` // 软删除
+	dbutil.AuditFields
 }
 
 type Tag struct {
-	ID        uint   `gorm:"primaryKey"`
-	Name      string `gorm:"uniqueIndex"`
-	Posts     []Post `gorm:"many2many:post_tags;"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;size:64" json:"name"`
+	Posts     []Post    `gorm:"many2many:post_tags;" json:"posts,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	dbutil.AuditFields
 }
 
 // PostWithCount 用于包含评论数量的文章
@@ -56,66 +167,585 @@ type PostWithCount struct {
 	CommentCount int64 `json:"comment_count"`
 }
 
-// 查询用户最新文章（含标签）
-func GetUserLatestPosts(db *gorm.DB, userID uint) ([]Post, error) {
-	var posts []Post
+// 评论树的加载深度：顶层评论为第 1 层
+const (
+	DefaultCommentDepth = 3
+	MaxCommentDepth     = 10
+)
 
-	err := db.
-		Model(&Post{}).
-		Where("user_id = ?", userID).
-		Preload("User").
-		Preload("Tags").
-		Preload("Comments").
-		Order("created_at DESC").
-		Limit(10).
-		Find(&posts).Error
+// 博客操作的业务错误，HTTP 接口据此返回 404
+var (
+	ErrUserNotFound    = errors.New("用户不存在")
+	ErrPostNotFound    = errors.New("文章不存在")
+	ErrCommentNotFound = errors.New("评论不存在")
+)
+
+// blogMigrations 博客的表结构迁移，新的结构变更追加新版本，不要修改已发布的迁移
+var blogMigrations = []migrations.Migration{
+	{
+		Version: 2024010101,
+		Name:    "create_blog_tables",
+		// 使用 AutoMigrate 作为初始结构，已经用 AutoMigrate 建过表的数据库也能直接接入
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&User{}, &Post{}, &Comment{}, &Tag{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("post_tags", &Comment{}, &Post{}, &Tag{}, &User{})
+		},
+	},
+	{
+		Version: 2024010102,
+		Name:    "add_audit_columns",
+		// 新库在上一步的 AutoMigrate 中已经建好这两列，这里只给旧库补上
+		Up: func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&User{}, &Post{}, &Comment{}, &Tag{}} {
+				for _, column := range []string{"CreatedBy", "UpdatedBy"} {
+					if tx.Migrator().HasColumn(model, column) {
+						continue
+					}
+					if err := tx.Migrator().AddColumn(model, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&User{}, &Post{}, &Comment{}, &Tag{}} {
+				for _, column := range []string{"CreatedBy", "UpdatedBy"} {
+					if err := tx.Migrator().DropColumn(model, column); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2024010103,
+		Name:    "add_user_password_hash",
+		// 注册登录使用的密码哈希，没有设置密码的旧用户无法登录
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&User{}, "PasswordHash") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&User{}, "PasswordHash")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&User{}, "PasswordHash")
+		},
+	},
+	{
+		Version: 2024010104,
+		Name:    "add_comment_parent_id",
+		// 楼中楼回复，已有的评论都是顶层评论
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&Comment{}, "ParentID") {
+				if err := tx.Migrator().AddColumn(&Comment{}, "ParentID"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&Comment{}, "ParentID") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&Comment{}, "ParentID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&Comment{}, "ParentID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Comment{}, "ParentID")
+		},
+	},
+	{
+		Version: 2024010105,
+		Name:    "create_likes",
+		// 点赞记录和文章、评论上冗余的点赞数
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&Like{}); err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&Post{}, &Comment{}} {
+				if tx.Migrator().HasColumn(model, "LikeCount") {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(model, "LikeCount"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&Post{}, &Comment{}} {
+				if err := tx.Migrator().DropColumn(model, "LikeCount"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&Like{})
+		},
+	},
+	{
+		Version: 2024010106,
+		Name:    "create_categories",
+		// 分类表和文章的 category_id，已有的文章没有分类
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&Category{}); err != nil {
+				return err
+			}
+			if !tx.Migrator().HasColumn(&Post{}, "CategoryID") {
+				if err := tx.Migrator().AddColumn(&Post{}, "CategoryID"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&Post{}, "CategoryID") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&Post{}, "CategoryID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&Post{}, "CategoryID"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&Post{}, "CategoryID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&Category{})
+		},
+	},
+	{
+		Version: 2024010107,
+		Name:    "add_comment_moderation",
+		// 用户角色和评论的审核状态，已有的评论按已通过处理（列的默认值）
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&User{}, "Role") {
+				if err := tx.Migrator().AddColumn(&User{}, "Role"); err != nil {
+					return err
+				}
+			}
+			for _, field := range []string{"Status", "ModerationReason", "ModeratedBy", "ModeratedAt"} {
+				if tx.Migrator().HasColumn(&Comment{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&Comment{}, field); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&Comment{}, "Status") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&Comment{}, "Status")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&Comment{}, "Status"); err != nil {
+				return err
+			}
+			for _, field := range []string{"Status", "ModerationReason", "ModeratedBy", "ModeratedAt"} {
+				if err := tx.Migrator().DropColumn(&Comment{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&User{}, "Role")
+		},
+	},
+	{
+		Version: 2024010108,
+		Name:    "create_post_views",
+		// 文章每天的浏览次数，用于计算热门文章
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&PostView{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&PostView{})
+		},
+	},
+	{
+		Version: 2024010109,
+		Name:    "add_post_published_at",
+		// 已有文章的发布时间取创建时间（包括已删除的文章）
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&Post{}, "PublishedAt") {
+				if err := tx.Migrator().AddColumn(&Post{}, "PublishedAt"); err != nil {
+					return err
+				}
+			}
+			err := tx.Unscoped().Model(&Post{}).Where("published_at IS NULL").
+				UpdateColumn("published_at", gorm.Expr("created_at")).Error
+			if err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(&Post{}, "PublishedAt") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&Post{}, "PublishedAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&Post{}, "PublishedAt"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Post{}, "PublishedAt")
+		},
+	},
+	{
+		Version: 2024010110,
+		Name:    "add_post_content_html",
+		// 渲染后的 HTML 缓存，已有的文章在第一次请求 HTML 时渲染
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&Post{}, "ContentHTML") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&Post{}, "ContentHTML")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&Post{}, "ContentHTML")
+		},
+	},
+	{
+		Version: 2024010111,
+		Name:    "create_attachments",
+		// 文章附件的元数据，文件保存在 Storage 中
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Attachment{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Attachment{})
+		},
+	},
+	{
+		Version: 2024010112,
+		Name:    "create_follows",
+		// 关注关系和用户的粉丝数、关注数，已有用户的计数为 0
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"FollowerCount", "FollowingCount"} {
+				if tx.Migrator().HasColumn(&User{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&User{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&Follow{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&Follow{}); err != nil {
+				return err
+			}
+			for _, field := range []string{"FollowerCount", "FollowingCount"} {
+				if err := tx.Migrator().DropColumn(&User{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2024010113,
+		Name:    "create_notifications",
+		// 站内通知，只通知迁移之后的评论、回复和点赞
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Notification{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Notification{})
+		},
+	},
+	{
+		Version: 2024010114,
+		Name:    "add_post_status",
+		// 草稿和定时发布，已有文章的默认状态为已发布
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&Post{}, "Status") {
+				if err := tx.Migrator().AddColumn(&Post{}, "Status"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&Post{}, "Status") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&Post{}, "Status")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&Post{}, "Status"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Post{}, "Status")
+		},
+	},
+	{
+		Version: 2024010115,
+		Name:    "create_mentions",
+		// 评论中提及的用户，只解析迁移之后发表或修改的评论
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Mention{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&Mention{})
+		},
+	},
+	{
+		Version: 2024010116,
+		Name:    "create_reports",
+		// 举报、审核日志和用户的封禁时间
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&User{}, "SuspendedAt") {
+				if err := tx.Migrator().AddColumn(&User{}, "SuspendedAt"); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&Report{}, &ModerationLog{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&ModerationLog{}, &Report{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&User{}, "SuspendedAt")
+		},
+	},
+	{
+		Version: 2024010117,
+		Name:    "add_post_content_stats",
+		// 字数、字符数和阅读时长，已有文章为 0，需要执行 blog backfill-stats 重新统计
+		Up: func(tx *gorm.DB) error {
+			for _, field := range postStatsFields {
+				if tx.Migrator().HasColumn(&Post{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&Post{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, field := range postStatsFields {
+				if err := tx.Migrator().DropColumn(&Post{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// postStatsFields 迁移 add_post_content_stats 添加的字段
+var postStatsFields = []string{"WordCount", "CharCount", "ReadingMinutes"}
 
-	return posts, err
+//go:embed fixtures
+var fixtures embed.FS
+
+// blogSeeder 博客的种子数据：所有环境都有基础标签，development 环境额外有演示用户和文章
+func blogSeeder(db *gorm.DB) *seed.Seeder {
+	return seed.New(db).Add(
+		seed.YAML[Tag]("tags", fixtures, "fixtures/tags.yaml"),
+		seed.Records("demo users", func() []User {
+			return []User{
+				// PostCount 由下面的演示文章通过 AfterCreate 计数，每次执行种子数据时先重置为 0
+				{ID: 1000, Name: "演示用户", Email: "demo@example.com"},
+			}
+		}).In("development"),
+		seed.Records("demo posts", func() []Post {
+			return []Post{
+				{ID: 1000, Title: "欢迎使用博客", Content: "这是一篇演示文章", UserID: 1000, Tags: []Tag{{ID: 1}, {ID: 2}}},
+			}
+		}).In("development"),
+	)
 }
 
-// 统计评论数量
-func GetPostsWithCommentCount(db *gorm.DB) ([]PostWithCount, error) {
-	var posts []Post
-	var result []PostWithCount
+// 分页查询文章及其评论数量（含作者、分类和标签），按发布时间倒序，分页规则见 dbutil.NormalizePage
+// 评论数量通过关联子查询在查询文章的同一条 SQL 中算出，不再逐篇 Count，
+// 也不再预加载全部评论，评论内容请使用 GetPostComments 分页获取
+func GetPostsWithCommentCount(db *gorm.DB, page, size int) (dbutil.Page[PostWithCount], error) {
+	var posts []PostWithCount
 
-	// 先查询文章并预加载评论
-	err := db.
-		Model(&Post{}).
-		Preload("Comments").
-		Preload("User").
-		Preload("Tags").
-		Find(&posts).Error
+	// 不计入已软删除和未通过审核的评论
+	commentCount := db.Session(&gorm.Session{NewDB: true}).
+		Model(&Comment{}).
+		Select("COUNT(*)").
+		Where("comments.post_id = posts.id").
+		Scopes(approvedComments)
+	query := db.Model(&Post{}).Scopes(publishedPosts).Select("posts.*, (?) AS comment_count", commentCount)
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size), postListScope)
+	if err != nil {
+		return dbutil.Page[PostWithCount]{}, err
+	}
 
+	return dbutil.NewPage(posts, total, page, size), nil
+}
+
+// 查询文章详情（含作者、分类和标签），文章不存在时返回 ErrPostNotFound
+func GetPost(db *gorm.DB, postID uint) (*Post, error) {
+	var post Post
+	err := db.Preload("User").Preload("Category").Preload("Tags").First(&post, postID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPostNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	return &post, nil
+}
 
-	// 转换结果，包含评论数量
-	for _, post := range posts {
-		var count int64
+// postListScope 文章列表的预加载和排序：含作者、分类和标签，按发布时间倒序
+// 草稿和定时文章发布时 published_at 改为发布时间，按 created_at 排序会把它们排在很靠后的位置
+func postListScope(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("Category").Preload("Tags").Order("posts.published_at DESC").Order("posts.id DESC")
+}
 
-		// 统计评论数量
-		err := db.Model(&Comment{}).
-			Where("post_id = ?", post.ID).
-			Count(&count).Error
+// 分页查询已发布的文章（含作者、分类和标签），按发布时间倒序，草稿见 ListDrafts
+// 参数 userID: 只查询该用户的文章，为 0 时查询所有文章
+func ListPosts(db *gorm.DB, userID uint, page, size int) (dbutil.Page[Post], error) {
+	var posts []Post
 
-		if err != nil {
-			return nil, err
-		}
+	query := db.Model(&Post{}).Scopes(publishedPosts)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size), postListScope)
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
 
-		result = append(result, PostWithCount{
-			Post:         post,
-			CommentCount: count,
-		})
+	return dbutil.NewPage(posts, total, page, size), nil
+}
+
+// PostCursor 文章游标分页的位置，记录上一页最后一篇文章的 (published_at, id)，与 postListScope 的排序一致
+type PostCursor struct {
+	PublishedAt time.Time `json:"published_at"`
+	ID          uint      `json:"id"`
+}
+
+// CursorPage 游标分页的结果
+type CursorPage[T any] struct {
+	Items []T         `json:"items"`
+	Total int64       `json:"total"` // 符合条件的记录总数，与游标位置无关
+	Next  *PostCursor `json:"-"`     // 下一页的游标，没有下一页时为 nil；接口中编码为 next_cursor 返回
+}
+
+/*
+ListPostsByCursor 游标分页查询文章（含作者、分类和标签），按发布时间倒序
+与 ListPosts 的偏移分页相比，翻到后面的页时不需要扫描前面的行，翻页期间有新文章发布也不会重复或遗漏
+参数：
+  - db: GORM 数据库连接
+  - userID: 只查询该用户的文章，为 0 时查询所有文章
+  - cursor: 上一页返回的 Next，为 nil 时查询第一页
+  - size: 每页大小，规则同 dbutil.NormalizePage
+
+返回值：
+  - CursorPage[Post]: 本页文章、总数和下一页的游标
+  - error: 查询失败时返回
+*/
+func ListPostsByCursor(db *gorm.DB, userID uint, cursor *PostCursor, size int) (CursorPage[Post], error) {
+	_, size = dbutil.NormalizePage(1, size)
+
+	query := db.Model(&Post{}).Scopes(publishedPosts)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return CursorPage[Post]{}, err
 	}
 
+	if cursor != nil {
+		// published_at 相同时用 id 区分，保证排序唯一
+		query = query.Where("posts.published_at < ? OR (posts.published_at = ? AND posts.id < ?)",
+			cursor.PublishedAt, cursor.PublishedAt, cursor.ID)
+	}
+	// 多查一条用来判断是否还有下一页
+	posts := make([]Post, 0, size+1)
+	if err := query.Scopes(postListScope).Limit(size + 1).Find(&posts).Error; err != nil {
+		return CursorPage[Post]{}, err
+	}
+
+	result := CursorPage[Post]{Items: posts, Total: total}
+	if len(posts) > size {
+		result.Items = posts[:size]
+		last := result.Items[size-1]
+		result.Next = &PostCursor{PublishedAt: last.PublishedAt, ID: last.ID}
+	}
 	return result, nil
 }
 
+// 修改文章标题、内容和分类，文章不存在时返回 ErrPostNotFound，分类不存在时返回 ErrCategoryNotFound
+// 参数 categoryID: 为 nil 时清除文章的分类
+func UpdatePost(db *gorm.DB, postID uint, title, content string, categoryID *uint) (*Post, error) {
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if err := ensureCategory(tx, categoryID); err != nil {
+			return err
+		}
+		result := tx.Model(&Post{ID: postID}).
+			Updates(map[string]interface{}{"title": title, "content": content, "content_html": "", "category_id": categoryID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPostNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPost(db, postID)
+}
+
+// ensureCategory 分类ID不为空时检查分类存在
+func ensureCategory(tx *gorm.DB, categoryID *uint) error {
+	if categoryID == nil {
+		return nil
+	}
+	return ensureExists(tx, &Category{}, *categoryID, ErrCategoryNotFound)
+}
+
+// 软删除文章并减少作者的文章数量，文章不存在或已删除时返回 ErrPostNotFound
+func DeletePost(db *gorm.DB, postID uint) error {
+	return dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var post Post
+		if err := tx.Select("id", "user_id").First(&post, postID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPostNotFound
+			}
+			return err
+		}
+		// 作者的文章数量由 AfterDelete 减少
+		return tx.Delete(&post).Error
+	})
+}
+
+// RecountPostCounts 按 posts 表重新统计所有用户的文章数量（不含已删除的文章），修复计数的偏差
+// 只修改计数不正确的用户，返回修改的用户数
+func RecountPostCounts(db *gorm.DB) (int64, error) {
+	postCount := db.Session(&gorm.Session{NewDB: true}).
+		Model(&Post{}).
+		Select("COUNT(*)").
+		Where("posts.user_id = users.id")
+	result := db.Model(&User{}).
+		Where("post_count <> (?)", postCount).
+		UpdateColumn("post_count", postCount)
+	return result.RowsAffected, result.Error
+}
+
+// ensureExists 检查 model 对应的表中是否存在主键为 id 的记录，不存在时返回 notFound
+func ensureExists(tx *gorm.DB, model interface{}, id uint, notFound error) error {
+	var count int64
+	if err := tx.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return notFound
+	}
+	return nil
+}
+
 // 发布文章并绑定标签
 func PublishPostWithTags(db *gorm.DB, post *Post, tagIDs []uint) error {
-	return db.Transaction(func(tx *gorm.DB) error {
+	return dbutil.WithTx(db, func(tx *gorm.DB) error {
 		// 1. 创建文章
+		if err := ensureExists(tx, &User{}, post.UserID, ErrUserNotFound); err != nil {
+			return err
+		}
+		if err := ensureCategory(tx, post.CategoryID); err != nil {
+			return err
+		}
 		if err := tx.Create(post).Error; err != nil {
 			return err
 		}
@@ -134,93 +764,251 @@ func PublishPostWithTags(db *gorm.DB, post *Post, tagIDs []uint) error {
 			}
 		}
 
-		// 3. 更新用户文章数量
-		if err := tx.Model(&User{}).
-			Where("id = ?", post.UserID).
-			UpdateColumn("post_count", gorm.Expr("post_count + ?", 1)).
-			Error; err != nil {
-			return err
-		}
-
+		// 用户文章数量由 Post 的 AfterCreate 钩子更新
 		return nil
 	})
 }
 
 // 发布评论函数
 func PublishComment(db *gorm.DB, userID, postID uint, content string) (*Comment, error) {
-	comment := &Comment{
-		Content:   content,
-		UserID:    userID,
-		PostID:    postID,
-		CreatedAt: time.Now(),
+	return publishComment(db, &Comment{Content: content, UserID: userID, PostID: postID})
+}
+
+// 回复评论，回复与被回复的评论属于同一篇文章
+// 被回复的评论不存在、已删除或未通过审核时返回 ErrCommentNotFound
+func ReplyComment(db *gorm.DB, userID, parentID uint, content string) (*Comment, error) {
+	var parent Comment
+	if err := db.Scopes(approvedComments).Select("id", "post_id").First(&parent, parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return publishComment(db, &Comment{Content: content, UserID: userID, PostID: parent.PostID, ParentID: &parent.ID})
+}
+
+// publishComment 校验用户和文章存在后创建评论，频率和垃圾评论由 CommentAntiSpam 检查，审核状态由 CommentModeration 决定
+func publishComment(db *gorm.DB, comment *Comment) (*Comment, error) {
+	comment.CreatedAt = time.Now()
+
+	// 反垃圾检查可能调用外部服务，在事务之外执行
+	spamReason, err := CommentAntiSpam.checkSpam(db.Statement.Context, comment)
+	if err != nil {
+		return nil, err
 	}
 
-	err := db.Transaction(func(tx *gorm.DB) error {
+	err = dbutil.WithTx(db, func(tx *gorm.DB) error {
 		// 验证用户和文章是否存在
-		var userCount, postCount int64
-		if err := tx.Model(&User{}).Where("id = ?", userID).Count(&userCount).Error; err != nil {
+		if err := ensureExists(tx, &User{}, comment.UserID, ErrUserNotFound); err != nil {
+			return err
+		}
+		// 草稿和定时发布的文章不能评论
+		if err := ensureExists(tx.Scopes(publishedPosts), &Post{}, comment.PostID, ErrPostNotFound); err != nil {
 			return err
 		}
-		if userCount == 0 {
-			return fmt.Errorf("用户不存在")
+
+		if err := CommentAntiSpam.checkRate(tx, comment); err != nil {
+			return err
 		}
 
-		if err := tx.Model(&Post{}).Where("id = ?", postID).Count(&postCount).Error; err != nil {
+		status, reason, err := CommentModeration.evaluate(tx, comment)
+		if err != nil {
 			return err
 		}
-		if postCount == 0 {
-			return fmt.Errorf("文章不存在")
+		if spamReason != "" && status == CommentApproved {
+			status, reason = CommentPending, spamReason
 		}
+		comment.Status, comment.ModerationReason = status, reason
 
-		// 创建评论
+		// 创建评论和提及记录
 		if err := tx.Create(comment).Error; err != nil {
 			return err
 		}
-
-		return nil
+		return saveMentions(tx, comment)
 	})
 
 	if err != nil {
 		return nil, err
 	}
+	notifyComment(db, comment)
 
 	// 预加载关联数据
 	db.Preload("User").Preload("Post").First(comment, comment.ID)
 	return comment, nil
 }
 
-// 获取文章的所有评论（包含用户信息）
-func GetPostComments(db *gorm.DB, postID uint) ([]Comment, error) {
+// 分页获取文章的评论树：按时间正序分页查询顶层评论，再逐层加载回复（包含用户信息）
+// 参数 depth: 加载的层数，1 表示只返回顶层评论，<= 0 时使用 DefaultCommentDepth，最多 MaxCommentDepth
+// 分页规则见 dbutil.NormalizePage，Total 为顶层评论的数量
+// 只返回已通过审核的评论，已删除或未通过审核的评论的回复也不会返回
+func GetPostComments(db *gorm.DB, postID uint, depth, page, size int) (dbutil.Page[Comment], error) {
+	return getCommentTree(db.Where("post_id = ? AND parent_id IS NULL", postID), depth, page, size)
+}
+
+// 分页获取评论的回复树，用于加载超出 GetPostComments 深度的回复，参数同 GetPostComments
+func GetCommentReplies(db *gorm.DB, commentID uint, depth, page, size int) (dbutil.Page[Comment], error) {
+	if err := ensureExists(db.Scopes(approvedComments), &Comment{}, commentID, ErrCommentNotFound); err != nil {
+		return dbutil.Page[Comment]{}, err
+	}
+	return getCommentTree(db.Where("parent_id = ?", commentID), depth, page, size)
+}
+
+// getCommentTree 分页查询 query 条件下的评论，并加载 depth 层回复
+func getCommentTree(query *gorm.DB, depth, page, size int) (dbutil.Page[Comment], error) {
 	var comments []Comment
 
-	err := db.
+	query = query.
 		Model(&Comment{}).
-		Where("post_id = ?", postID).
-		Preload("User").         // 预加载用户信息
-		Order("created_at ASC"). // 按时间正序排列
-		Find(&comments).Error
+		Scopes(approvedComments).
+		Preload("User").                        // 预加载用户信息
+		Order("created_at ASC").Order("id ASC") // 按时间正序排列
+	total, err := dbutil.FindWithCount(query, &comments, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Comment]{}, err
+	}
+	// 回复使用新的查询，不继承 query 的条件
+	if err := loadReplies(query.Session(&gorm.Session{NewDB: true}), comments, normalizeDepth(depth)); err != nil {
+		return dbutil.Page[Comment]{}, err
+	}
+
+	return dbutil.NewPage(comments, total, page, size), nil
+}
+
+// normalizeDepth 校验评论树的深度
+func normalizeDepth(depth int) int {
+	if depth <= 0 {
+		return DefaultCommentDepth
+	}
+	if depth > MaxCommentDepth {
+		return MaxCommentDepth
+	}
+	return depth
+}
 
-	return comments, err
+// loadReplies 填充 comments 的回复数量，并逐层加载回复，共 depth 层（包括 comments 本身）
+// 每一层只执行两条查询（回复数量和回复内容），与评论数量无关
+func loadReplies(db *gorm.DB, comments []Comment, depth int) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].ID
+	}
+
+	var counts []struct {
+		ParentID uint
+		Count    int64
+	}
+	if err := db.Model(&Comment{}).Select("parent_id, COUNT(*) AS count").Scopes(approvedComments).
+		Where("parent_id IN ?", ids).Group("parent_id").Scan(&counts).Error; err != nil {
+		return err
+	}
+	countByParent := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		countByParent[c.ParentID] = c.Count
+	}
+	for i := range comments {
+		comments[i].ReplyCount = countByParent[comments[i].ID]
+	}
+	if depth <= 1 || len(counts) == 0 {
+		return nil
+	}
+
+	var replies []Comment
+	if err := db.Scopes(approvedComments).Where("parent_id IN ?", ids).Preload("User").
+		Order("created_at ASC").Order("id ASC").Find(&replies).Error; err != nil {
+		return err
+	}
+	if err := loadReplies(db, replies, depth-1); err != nil {
+		return err
+	}
+	repliesByParent := make(map[uint][]Comment, len(counts))
+	for _, reply := range replies {
+		repliesByParent[*reply.ParentID] = append(repliesByParent[*reply.ParentID], reply)
+	}
+	for i := range comments {
+		comments[i].Replies = repliesByParent[comments[i].ID]
+	}
+	return nil
 }
 
-// 获取用户的评论历史
-func GetUserComments(db *gorm.DB, userID uint) ([]Comment, error) {
+// 分页获取用户的评论历史，包括未通过审核的评论（Status 标明审核状态）
+func GetUserComments(db *gorm.DB, userID uint, page, size int) (dbutil.Page[Comment], error) {
 	var comments []Comment
 
-	err := db.
+	query := db.
 		Model(&Comment{}).
 		Where("user_id = ?", userID).
-		Preload("Post").          // 预加载文章信息
-		Preload("User").          // 预加载用户信息
-		Order("created_at DESC"). // 按时间倒序排列
-		Find(&comments).Error
+		Preload("Post").         // 预加载文章信息
+		Preload("User").         // 预加载用户信息
+		Order("created_at DESC") // 按时间倒序排列
+	total, err := dbutil.FindWithCount(query, &comments, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Comment]{}, err
+	}
 
-	return comments, err
+	return dbutil.NewPage(comments, total, page, size), nil
 }
 
-// 软删除评论
+// 修改评论内容，评论不存在时返回 ErrCommentNotFound
+// 修改后的内容重新按 CommentModeration 审核，之前的人工审核结果不再保留
+func UpdateComment(db *gorm.DB, commentID uint, content string) (*Comment, error) {
+	var userIDs []uint
+	if err := db.Model(&Comment{}).Where("id = ?", commentID).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	if len(userIDs) == 0 {
+		return nil, ErrCommentNotFound
+	}
+	// 修改不受频率限制，但同样要经过垃圾评论检查
+	spamReason, err := CommentAntiSpam.checkSpam(db.Statement.Context, &Comment{ID: commentID, UserID: userIDs[0], Content: content})
+	if err != nil {
+		return nil, err
+	}
+
+	comment := &Comment{ID: commentID}
+	err = dbutil.WithTx(db, func(tx *gorm.DB) error {
+		status, reason, err := CommentModeration.evaluate(tx, &Comment{UserID: userIDs[0], Content: content})
+		if err != nil {
+			return err
+		}
+		if spamReason != "" && status == CommentApproved {
+			status, reason = CommentPending, spamReason
+		}
+		err = tx.Model(comment).Updates(map[string]interface{}{
+			"content":           content,
+			"status":            status,
+			"moderation_reason": reason,
+			"moderated_by":      nil,
+			"moderated_at":      nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return saveMentions(tx, &Comment{ID: commentID, UserID: userIDs[0], Content: content})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Preload("User").First(comment, commentID).Error; err != nil {
+		return nil, err
+	}
+	// 修改后通过审核的评论此前可能没有通知过，新提及的用户也需要通知，重复的通知会被跳过
+	notifyComment(db, comment)
+	return comment, nil
+}
+
+// 软删除评论，评论不存在或已删除时返回 ErrCommentNotFound
 func SoftDeleteComment(db *gorm.DB, commentID uint) error {
-	return db.Delete(&Comment{}, commentID).Error
+	result := db.Delete(&Comment{}, commentID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
 }
 
 // 彻底删除评论
@@ -229,26 +1017,112 @@ func HardDeleteComment(db *gorm.DB, commentID uint) error {
 }
 
 func main() {
-	// 连接数据库
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	// 连接数据库，BLOG_DSN 可指定 MySQL/PostgreSQL，格式见 dbutil.ParseDSN
+	dsn := os.Getenv("BLOG_DSN")
+	if dsn == "" {
+		dsn = "test.db"
+	}
+	// BLOG_SQL_LOG 指定 SQL 日志文件，通过 lesson-01 的异步日志系统记录全部 SQL 和慢查询
+	gormConfig := &gorm.Config{}
+	if path := os.Getenv("BLOG_SQL_LOG"); path != "" {
+		sqlLog, err := applog.NewLogger(path, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sqlLog.Close()
+		gormConfig.Logger = applog.NewGormLogger(sqlLog, 200*time.Millisecond)
+	}
+	db, err := dbutil.Open(dbutil.Config{
+		DSN:         dsn,
+		Pool:        dbutil.PoolConfig{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Hour},
+		PrepareStmt: true,
+		// 以下参数只对 SQLite 生效
+		SQLite: dbutil.SQLiteConfig{WAL: true, BusyTimeout: 5 * time.Second, ForeignKeys: true},
+	}, gormConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// 自动迁移
-	err = db.AutoMigrate(&User{}, &Post{}, &Comment{}, &Tag{})
+	// 执行数据库迁移
+	runner, err := migrations.NewRunner(db, blogMigrations...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if _, err := runner.Up(); err != nil {
+		log.Fatal(err)
+	}
+
+	// 加载种子数据，环境由 BLOG_ENV 指定，默认 development
+	env := os.Getenv("BLOG_ENV")
+	if env == "" {
+		env = "development"
+	}
+	if _, err := blogSeeder(db).Run(env); err != nil {
+		log.Fatal(err)
+	}
 
 	fmt.Println("数据库连接成功！")
 
-	// 示例：创建用户
+	// blog serve 启动 HTTP API（见 Server），监听地址由 BLOG_ADDR 指定，默认 :8080
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		addr := os.Getenv("BLOG_ADDR")
+		if addr == "" {
+			addr = ":8080"
+		}
+		tokens, generated, err := LoadTokenIssuer(DefaultTokenTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if generated {
+			log.Printf("未设置 %s，使用随机生成的 JWT 密钥，重启后需要重新登录", JWTSecretEnv)
+		}
+		if CommentModeration, err = LoadModerationRules(CommentModeration); err != nil {
+			log.Fatal(err)
+		}
+		if CommentAntiSpam, err = LoadAntiSpamRules(CommentAntiSpam); err != nil {
+			log.Fatal(err)
+		}
+		// 附件保存在 BLOG_UPLOAD_DIR 指定的目录，默认 uploads
+		uploadDir := os.Getenv(UploadDirEnv)
+		if uploadDir == "" {
+			uploadDir = "uploads"
+		}
+		if err := runServer(db, addr, tokens, &LocalStorage{Root: uploadDir}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// blog backfill-stats 重新统计已有文章的字数和阅读时长，见 BackfillContentStats
+	if len(os.Args) > 1 && os.Args[1] == "backfill-stats" {
+		n, err := BackfillContentStats(db, DefaultBackfillBatchSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("已更新 %d 篇文章的字数统计\n", n)
+		return
+	}
+
+	// blog export <文件> 导出备份，blog import <文件> 把备份导入到空数据库，见 ExportBlog/ImportBlog
+	// 导入时需要设置 BLOG_ENV=production，development 环境的演示用户会使数据库不为空
+	if len(os.Args) > 2 && (os.Args[1] == "export" || os.Args[1] == "import") {
+		if err := runBackupCommand(db, os.Args[1], os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 示例：创建用户，重复运行时复用已有用户（启用外键后 user_id 必须有效）
 	user := User{
 		Name:  "张三",
 		Email: "zhangsan@example.com",
 	}
-	db.Create(&user)
+	if err := db.Where(User{Email: user.Email}).FirstOrCreate(&user).Error; err != nil {
+		log.Fatal(err)
+	}
+
+	// 之后的写操作以张三的身份执行，CreatedBy/UpdatedBy 由 AuditPlugin 自动填充
+	db = db.WithContext(dbutil.WithActor(context.Background(), user.ID))
 
 	// 发布文章
 	post := &Post{
@@ -264,11 +1138,11 @@ func main() {
 	}
 
 	// 查询用户最新文章
-	latestPosts, err := GetUserLatestPosts(db, user.ID)
+	latestPosts, err := ListPostsFiltered(db, PostFilter{AuthorID: user.ID}, 1, 10)
 	if err != nil {
 		log.Printf("查询用户最新文章失败: %v", err)
 	} else {
-		fmt.Printf("用户 %s 的最新文章: %d 篇\n", user.Name, len(latestPosts))
+		fmt.Printf("用户 %s 的最新文章: %d 篇，共 %d 篇\n", user.Name, len(latestPosts.Items), latestPosts.Total)
 	}
 
 	comment1, err := PublishComment(db, user.ID, post.ID, "这篇博客写得真不错！")
@@ -278,6 +1152,14 @@ func main() {
 		fmt.Printf("用户 %s 评论: %s\n", user.Name, comment1.Content)
 	}
 
+	// 分页查看文章评论
+	comments, err := GetPostComments(db, post.ID, DefaultCommentDepth, 1, 20)
+	if err != nil {
+		log.Printf("查询文章评论失败: %v", err)
+	} else {
+		fmt.Printf("文章《%s》共 %d 条评论，第 %d/%d 页\n", post.Title, comments.Total, comments.Page, comments.TotalPages)
+	}
+
 	// 示例：软删除评论
 	var comment Comment
 	if err := db.First(&comment).Error; err == nil {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrCategoryNotFound 分类不存在
+	ErrCategoryNotFound = errors.New("分类不存在")
+	// ErrSlugTaken 分类的 slug 已被使用
+	ErrSlugTaken = errors.New("slug 已被使用")
+)
+
+// slugPattern slug 只能包含小写字母、数字和连字符，不能以连字符开头或结尾
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Category 文章分类，通过 ParentID 组成树；与标签不同，一篇文章只属于一个分类
+type Category struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"size:64;not null" json:"name"`
+	Slug        string     `gorm:"uniqueIndex;size:64;not null" json:"slug"` // URL 中使用的唯一标识
+	Description string     `gorm:"size:500" json:"description"`
+	ParentID    *uint      `gorm:"index" json:"parent_id"` // 上级分类，顶级分类为 nil
+	Children    []Category `gorm:"foreignKey:ParentID" json:"children,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	dbutil.AuditFields
+}
+
+// 创建分类，slug 已被使用时返回 ErrSlugTaken，上级分类不存在时返回 ErrCategoryNotFound
+func CreateCategory(db *gorm.DB, category *Category) error {
+	if !slugPattern.MatchString(category.Slug) {
+		return &ValidationError{Field: "slug", Message: "只能包含小写字母、数字和连字符"}
+	}
+	return dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if category.ParentID != nil {
+			if err := ensureExists(tx, &Category{}, *category.ParentID, ErrCategoryNotFound); err != nil {
+				return err
+			}
+		}
+		var count int64
+		if err := tx.Model(&Category{}).Where("slug = ?", category.Slug).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrSlugTaken
+		}
+		return tx.Create(category).Error
+	})
+}
+
+// 查询所有分类，按树形结构返回顶级分类，子分类在 Children 中
+// 分类数量通常不多，一次查出后在内存中组装
+func ListCategoryTree(db *gorm.DB) ([]Category, error) {
+	var categories []Category
+	if err := db.Order("name ASC").Order("id ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]int) // 上级分类ID -> 子分类在 categories 中的下标
+	var roots []int
+	for i, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, i)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], i)
+		}
+	}
+	var build func(i int) Category
+	build = func(i int) Category {
+		c := categories[i]
+		for _, child := range children[c.ID] {
+			c.Children = append(c.Children, build(child))
+		}
+		return c
+	}
+	tree := make([]Category, 0, len(roots))
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+	return tree, nil
+}
+
+// 根据 slug 查询分类，不存在时返回 ErrCategoryNotFound
+func GetCategoryBySlug(db *gorm.DB, slug string) (*Category, error) {
+	var category Category
+	err := db.Where("slug = ?", slug).First(&category).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// categoryDescendantIDs 返回分类及其所有下级分类的ID
+// 使用递归 CTE 在数据库中展开（SQLite 3.8.3+、MySQL 8.0+、PostgreSQL 均支持），
+// UNION 去重，即使数据中出现环也能结束
+func categoryDescendantIDs(db *gorm.DB, categoryID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Raw(`
+		WITH RECURSIVE tree(id) AS (
+			SELECT id FROM categories WHERE id = ?
+			UNION
+			SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT id FROM tree`, categoryID).Scan(&ids).Error
+	return ids, err
+}
+
+/*
+ListPostsByCategory 分页查询分类下的文章（含作者、分类和标签），按发布时间倒序
+参数：
+  - db: GORM 数据库连接
+  - slug: 分类的 slug
+  - includeDescendants: 是否包含下级分类的文章
+  - page, size: 分页参数，规则见 dbutil.NormalizePage
+
+返回值：
+  - dbutil.Page[Post]: 分页结果
+  - error: 分类不存在时返回 ErrCategoryNotFound
+*/
+func ListPostsByCategory(db *gorm.DB, slug string, includeDescendants bool, page, size int) (dbutil.Page[Post], error) {
+	category, err := GetCategoryBySlug(db, slug)
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	categoryIDs := []uint{category.ID}
+	if includeDescendants {
+		if categoryIDs, err = categoryDescendantIDs(db, category.ID); err != nil {
+			return dbutil.Page[Post]{}, err
+		}
+	}
+
+	var posts []Post
+	query := db.Model(&Post{}).Scopes(publishedPosts).Where("category_id IN ?", categoryIDs)
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size), postListScope)
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	return dbutil.NewPage(posts, total, page, size), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"gohomeworklesson02/markdown"
+	"gorm.io/gorm"
+	"unicode"
+)
+
+// 接口返回的文章内容格式，见 applyContentFormat
+const (
+	ContentMarkdown = "markdown" // 只返回 Markdown 原文 content
+	ContentHTML     = "html"     // 同时返回渲染后的 content_html
+)
+
+// PostRenderer 渲染文章内容使用的 Markdown 渲染器，可以在启动时设置 Highlight 钩子
+// 修改渲染规则后已缓存的 content_html 不会自动更新，需要清空 posts.content_html
+var PostRenderer = &markdown.Renderer{}
+
+/*
+RenderPostsHTML 填充文章的 ContentHTML
+文章的 content_html 列是渲染结果的缓存：为空时渲染 Markdown 并写回数据库，之后直接使用；
+UpdatePost 修改内容时会清空缓存
+参数：
+  - db: GORM 数据库连接
+  - posts: 需要渲染的文章，需要已加载 ID、Content 和 ContentHTML
+
+返回值：
+  - error: 写回缓存失败时返回
+*/
+func RenderPostsHTML(db *gorm.DB, posts ...*Post) error {
+	for _, post := range posts {
+		if post.ContentHTML != "" {
+			continue
+		}
+		post.ContentHTML = PostRenderer.Render(post.Content)
+		if post.ContentHTML == "" {
+			continue
+		}
+		// 内容在读取之后被修改时不写入，避免旧内容的渲染结果覆盖清空后的缓存
+		// UpdateColumn 不修改 updated_at 和修改人
+		err := db.Model(&Post{}).
+			Where("id = ? AND content = ?", post.ID, post.Content).
+			UpdateColumn("content_html", post.ContentHTML).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postPtrs 返回指向 posts 中各元素的指针，用于传给 RenderPostsHTML
+func postPtrs(posts []Post) []*Post {
+	ptrs := make([]*Post, len(posts))
+	for i := range posts {
+		ptrs[i] = &posts[i]
+	}
+	return ptrs
+}
+
+// 估算阅读时长使用的阅读速度
+const (
+	ReadingSpeedCJK   = 300 // 中日韩文字每分钟阅读的字数
+	ReadingSpeedWords = 200 // 其他语言每分钟阅读的单词数
+)
+
+// DefaultBackfillBatchSize BackfillContentStats 每批处理的文章数
+const DefaultBackfillBatchSize = 200
+
+// ContentStats 文章内容的字数统计，保存在 Post 上，由 BeforeSave 计算
+type ContentStats struct {
+	Words          uint // 字数：每个中日韩文字算一个字，其他语言按连续的字母和数字算一个单词
+	Chars          uint // 字符数，不含空白字符
+	ReadingMinutes uint // 估算的阅读时长（分钟），有内容时至少 1 分钟
+}
+
+// MeasureContent 统计内容的字数、字符数和阅读时长，Markdown 标记按普通字符统计
+func MeasureContent(content string) ContentStats {
+	var stats ContentStats
+	var cjk, words uint
+	inWord := false
+	for _, r := range content {
+		if !unicode.IsSpace(r) {
+			stats.Chars++
+		}
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	stats.Words = cjk + words
+	if stats.Words > 0 {
+		// cjk/ReadingSpeedCJK + words/ReadingSpeedWords 通分后向上取整，有内容时至少为 1
+		perMinute := uint(ReadingSpeedCJK * ReadingSpeedWords)
+		stats.ReadingMinutes = (cjk*ReadingSpeedWords + words*ReadingSpeedCJK + perMinute - 1) / perMinute
+	}
+	return stats
+}
+
+/*
+BackfillContentStats 重新统计已有文章（包括已删除的）的字数、字符数和阅读时长，
+用于添加这些字段之前创建的文章；按主键分批读取，只更新统计结果有变化的文章，不修改 updated_at
+参数：
+  - db: GORM 数据库连接
+  - batchSize: 每批处理的文章数，<= 0 时使用 DefaultBackfillBatchSize
+
+返回值：
+  - int64: 更新的文章数
+  - error: 查询或更新失败时返回，已经处理的批次不会回滚，重新执行即可继续
+*/
+func BackfillContentStats(db *gorm.DB, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBackfillBatchSize
+	}
+	var updated int64
+	var posts []Post
+	err := db.Unscoped().Model(&Post{}).
+		Select("id", "content", "word_count", "char_count", "reading_minutes").
+		FindInBatches(&posts, batchSize, func(tx *gorm.DB, _ int) error {
+			for _, post := range posts {
+				stats := MeasureContent(post.Content)
+				if stats == post.contentStats() {
+					continue
+				}
+				err := db.Unscoped().Model(&Post{ID: post.ID}).UpdateColumns(map[string]interface{}{
+					"word_count": stats.Words, "char_count": stats.Chars, "reading_minutes": stats.ReadingMinutes,
+				}).Error
+				if err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		}).Error
+	return updated, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 测试数据工厂，每条记录的用户名、邮箱、标题都带有递增序号，不会互相冲突
+var (
+	userFactory = testutil.NewFactory(func(n int) User {
+		return User{Name: fmt.Sprintf("user%d", n), Email: testutil.SeqEmail("user", n)}
+	})
+	postFactory = testutil.NewFactory(func(n int) Post {
+		return Post{Title: fmt.Sprintf("post %d", n), Content: fmt.Sprintf("content of post %d", n)}
+	})
+	commentFactory = testutil.NewFactory(func(n int) Comment {
+		return Comment{Content: fmt.Sprintf("comment %d", n)}
+	})
+)
+
+// newUser 创建用户，overrides 可以修改默认值，如 func(u *User) { u.Name = "Alice" }
+func newUser(tb testing.TB, db *gorm.DB, overrides ...func(*User)) *User {
+	tb.Helper()
+	return userFactory.Create(tb, db, overrides...)
+}
+
+// newPost 创建 author 的文章，author 为 nil 时同时创建一个作者
+func newPost(tb testing.TB, db *gorm.DB, author *User, overrides ...func(*Post)) *Post {
+	tb.Helper()
+	if author == nil {
+		author = newUser(tb, db)
+	}
+	setAuthor := func(p *Post) { p.UserID = author.ID }
+	return postFactory.Create(tb, db, append([]func(*Post){setAuthor}, overrides...)...)
+}
+
+// newComments 在文章下创建 n 条 author 的评论，author 为 nil 时同时创建一个评论者
+func newComments(tb testing.TB, db *gorm.DB, post *Post, author *User, n int, overrides ...func(*Comment)) []Comment {
+	tb.Helper()
+	if author == nil {
+		author = newUser(tb, db)
+	}
+	setTarget := func(c *Comment) { c.PostID, c.UserID = post.ID, author.ID }
+	return commentFactory.CreateN(tb, db, n, append([]func(*Comment){setTarget}, overrides...)...)
+}
+
+// newPostWithComments 创建一篇有 n 条评论的文章，作者和评论者都是新用户
+func newPostWithComments(tb testing.TB, db *gorm.DB, n int, overrides ...func(*Post)) (*Post, []Comment) {
+	tb.Helper()
+	post := newPost(tb, db, nil, overrides...)
+	return post, newComments(tb, db, post, nil, n)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// Follow 关注关系，同一用户对同一作者只能关注一次（唯一索引 idx_follow_pair）
+type Follow struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FollowerID uint      `gorm:"uniqueIndex:idx_follow_pair;not null" json:"follower_id"`       // 关注者
+	FolloweeID uint      `gorm:"uniqueIndex:idx_follow_pair;not null;index" json:"followee_id"` // 被关注的作者
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowCounts 用户的粉丝数和关注数
+type FollowCounts struct {
+	FollowerCount  uint `json:"follower_count"`
+	FollowingCount uint `json:"following_count"`
+}
+
+/*
+FollowUser 关注作者，重复关注不会报错也不会重复计数
+关注记录和双方的 follower_count/following_count 在同一事务中修改，规则同 LikeTarget：
+只有真正插入了记录时才修改计数，查询粉丝数时不需要 COUNT 关注表
+参数：
+  - db: GORM 数据库连接
+  - followerID: 关注者
+  - followeeID: 被关注的作者
+
+返回值：
+  - FollowCounts: 被关注作者的粉丝数和关注数
+  - error: 作者不存在时返回 ErrUserNotFound，关注自己时返回 ValidationError
+*/
+func FollowUser(db *gorm.DB, followerID, followeeID uint) (FollowCounts, error) {
+	return changeFollow(db, followerID, followeeID, true)
+}
+
+// UnfollowUser 取消关注，没有关注过时不报错，参数和返回值同 FollowUser
+func UnfollowUser(db *gorm.DB, followerID, followeeID uint) (FollowCounts, error) {
+	return changeFollow(db, followerID, followeeID, false)
+}
+
+func changeFollow(db *gorm.DB, followerID, followeeID uint, follow bool) (FollowCounts, error) {
+	if followerID == followeeID {
+		return FollowCounts{}, &ValidationError{Field: "id", Message: "不能关注自己"}
+	}
+
+	var counts FollowCounts
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if err := ensureExists(tx, &User{}, followeeID, ErrUserNotFound); err != nil {
+			return err
+		}
+
+		var result *gorm.DB
+		step := 1
+		if follow {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&Follow{FollowerID: followerID, FolloweeID: followeeID})
+		} else {
+			result = tx.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&Follow{})
+			step = -1
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// 只有关注状态真正改变时才修改计数，UpdateColumn 不修改 updated_at
+		if result.RowsAffected > 0 {
+			changes := []struct {
+				id     uint
+				column string
+			}{{followeeID, "follower_count"}, {followerID, "following_count"}}
+			for _, change := range changes {
+				id, column := change.id, change.column
+				update := tx.Model(&User{}).Where("id = ?", id)
+				if !follow {
+					update = update.Where(column + " > 0")
+				}
+				if err := update.UpdateColumn(column, gorm.Expr(column+" + ?", step)).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Model(&User{}).Select("follower_count, following_count").
+			Where("id = ?", followeeID).Scan(&counts).Error
+	})
+	if err != nil {
+		return FollowCounts{}, err
+	}
+	return counts, nil
+}
+
+// 分页查询关注 userID 的用户，最近关注的在前
+func ListFollowers(db *gorm.DB, userID uint, page, size int) (dbutil.Page[User], error) {
+	return listFollowUsers(db, userID, "followee_id", "follower_id", page, size)
+}
+
+// 分页查询 userID 关注的作者，最近关注的在前
+func ListFollowing(db *gorm.DB, userID uint, page, size int) (dbutil.Page[User], error) {
+	return listFollowUsers(db, userID, "follower_id", "followee_id", page, size)
+}
+
+// listFollowUsers 查询 follows 表中 by 列等于 userID 的记录对应的 pick 列的用户
+func listFollowUsers(db *gorm.DB, userID uint, by, pick string, page, size int) (dbutil.Page[User], error) {
+	if err := ensureExists(db, &User{}, userID, ErrUserNotFound); err != nil {
+		return dbutil.Page[User]{}, err
+	}
+	var users []User
+	query := db.Model(&User{}).
+		Joins("JOIN follows ON follows."+pick+" = users.id").
+		Where("follows."+by+" = ?", userID).
+		Order("follows.created_at DESC").Order("follows.id DESC")
+	total, err := dbutil.FindWithCount(query, &users, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[User]{}, err
+	}
+	return dbutil.NewPage(users, total, page, size), nil
+}
+
+/*
+GetFeed 分页查询 userID 关注的作者发布的文章（含作者、分类和标签），按发布时间倒序
+参数：
+  - db: GORM 数据库连接
+  - userID: 当前用户
+  - page, size: 分页参数，规则见 dbutil.NormalizePage
+
+返回值：
+  - dbutil.Page[Post]: 分页结果，没有关注任何作者时为空
+  - error: 查询失败时返回
+*/
+func GetFeed(db *gorm.DB, userID uint, page, size int) (dbutil.Page[Post], error) {
+	followees := db.Session(&gorm.Session{NewDB: true}).
+		Model(&Follow{}).Select("followee_id").Where("follower_id = ?", userID)
+
+	var posts []Post
+	query := db.Model(&Post{}).
+		Scopes(publishedPosts).
+		Where("user_id IN (?)", followees).
+		Preload("User").Preload("Category").Preload("Tags").
+		Order("published_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	return dbutil.NewPage(posts, total, page, size), nil
+}
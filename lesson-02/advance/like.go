@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// 可以点赞的对象类型
+const (
+	LikeTargetPost    = "post"
+	LikeTargetComment = "comment"
+)
+
+// ErrInvalidLikeTarget 不支持点赞的对象类型
+var ErrInvalidLikeTarget = errors.New("不支持点赞的对象类型")
+
+// Like 点赞记录，同一用户对同一对象只能点赞一次（唯一索引 idx_like_user_target）
+type Like struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"uniqueIndex:idx_like_user_target;not null" json:"user_id"`
+	TargetType string    `gorm:"uniqueIndex:idx_like_user_target;size:16;not null" json:"target_type"` // LikeTargetPost 或 LikeTargetComment
+	TargetID   uint      `gorm:"uniqueIndex:idx_like_user_target;not null" json:"target_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// likeTarget 点赞对象对应的模型和不存在时返回的错误
+func likeTarget(targetType string) (model interface{}, notFound error, err error) {
+	switch targetType {
+	case LikeTargetPost:
+		return &Post{}, ErrPostNotFound, nil
+	case LikeTargetComment:
+		return &Comment{}, ErrCommentNotFound, nil
+	}
+	return nil, nil, ErrInvalidLikeTarget
+}
+
+/*
+LikeTarget 点赞文章或评论，重复点赞不会报错也不会重复计数
+点赞记录和对象上的 like_count 在同一事务中修改：只有真正插入了记录时才加 1，
+并发的重复点赞由唯一索引拦截（ON CONFLICT DO NOTHING），计数不会偏差
+参数：
+  - db: GORM 数据库连接
+  - userID: 点赞的用户
+  - targetType: LikeTargetPost 或 LikeTargetComment
+  - targetID: 文章或评论ID
+
+返回值：
+  - uint: 点赞后的点赞数
+  - error: 对象不存在时返回 ErrPostNotFound/ErrCommentNotFound
+*/
+func LikeTarget(db *gorm.DB, userID uint, targetType string, targetID uint) (uint, error) {
+	return changeLike(db, userID, targetType, targetID, true)
+}
+
+// UnlikeTarget 取消点赞，没有点赞过时不报错，参数和返回值同 LikeTarget
+func UnlikeTarget(db *gorm.DB, userID uint, targetType string, targetID uint) (uint, error) {
+	return changeLike(db, userID, targetType, targetID, false)
+}
+
+func changeLike(db *gorm.DB, userID uint, targetType string, targetID uint, like bool) (uint, error) {
+	model, notFound, err := likeTarget(targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint
+	var liked bool // 本次新增了点赞记录
+	err = dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if err := ensureExists(tx, model, targetID, notFound); err != nil {
+			return err
+		}
+
+		var result *gorm.DB
+		delta := "like_count + ?"
+		if like {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&Like{UserID: userID, TargetType: targetType, TargetID: targetID})
+		} else {
+			result = tx.Where("user_id = ? AND target_type = ? AND target_id = ?", userID, targetType, targetID).
+				Delete(&Like{})
+			delta = "like_count - ?"
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// 只有点赞状态真正改变时才修改计数
+		if result.RowsAffected > 0 {
+			liked = like
+			update := tx.Model(model).Where("id = ?", targetID)
+			if !like {
+				update = update.Where("like_count > 0")
+			}
+			if err := update.UpdateColumn("like_count", gorm.Expr(delta, 1)).Error; err != nil {
+				return err
+			}
+		}
+
+		var counts []uint
+		if err := tx.Model(model).Where("id = ?", targetID).Pluck("like_count", &counts).Error; err != nil {
+			return err
+		}
+		if len(counts) > 0 {
+			count = counts[0]
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if liked {
+		notifyLike(db, userID, targetType, targetID)
+	}
+	return count, nil
+}
+
+// likedTargets 返回 targetIDs 中 userID 点赞过的对象
+func likedTargets(db *gorm.DB, userID uint, targetType string, targetIDs []uint) (map[uint]bool, error) {
+	liked := make(map[uint]bool)
+	if userID == 0 || len(targetIDs) == 0 {
+		return liked, nil
+	}
+	var ids []uint
+	err := db.Model(&Like{}).
+		Where("user_id = ? AND target_type = ? AND target_id IN ?", userID, targetType, targetIDs).
+		Pluck("target_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		liked[id] = true
+	}
+	return liked, nil
+}
+
+// MarkLikedPosts 根据 viewerID 的点赞记录填充 posts 的 LikedByMe，viewerID 为 0（未登录）时不做修改
+func MarkLikedPosts(db *gorm.DB, viewerID uint, posts []Post) error {
+	ids := make([]uint, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+	liked, err := likedTargets(db, viewerID, LikeTargetPost, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].LikedByMe = liked[posts[i].ID]
+	}
+	return nil
+}
+
+// MarkLikedComments 填充 comments 及其已加载的回复的 LikedByMe，规则同 MarkLikedPosts
+// 整棵评论树只执行一次查询
+func MarkLikedComments(db *gorm.DB, viewerID uint, comments []Comment) error {
+	var ids []uint
+	var collect func([]Comment)
+	collect = func(comments []Comment) {
+		for i := range comments {
+			ids = append(ids, comments[i].ID)
+			collect(comments[i].Replies)
+		}
+	}
+	collect(comments)
+
+	liked, err := likedTargets(db, viewerID, LikeTargetComment, ids)
+	if err != nil {
+		return err
+	}
+	var mark func([]Comment)
+	mark = func(comments []Comment) {
+		for i := range comments {
+			comments[i].LikedByMe = liked[comments[i].ID]
+			mark(comments[i].Replies)
+		}
+	}
+	mark(comments)
+	return nil
+}
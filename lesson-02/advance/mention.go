@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxMentions 一条评论最多提及的用户数，超出的部分忽略
+const MaxMentions = 10
+
+// mentionPattern 评论中的 @用户名：@ 前不能是字母、数字或下划线（排除邮箱地址），
+// 用户名由字母、数字和下划线组成，以空格或标点结束
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_])@([\p{L}\p{N}_]+)`)
+
+// mentionLink 渲染评论时提及的链接，指向被提及用户的文章列表
+const mentionLink = "/posts?user_id=%d"
+
+// Mention 评论中提及（@）的用户，发表和修改评论时由 saveMentions 根据内容重新生成
+type Mention struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CommentID uint      `gorm:"not null;uniqueIndex:idx_mention_pair" json:"comment_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_mention_pair;index" json:"user_id"` // 被提及的用户
+	User      *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ParseMentions 解析内容中提及的用户名，按出现顺序去重，最多 MaxMentions 个
+func ParseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := m[2]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == MaxMentions {
+			break
+		}
+	}
+	return names
+}
+
+/*
+saveMentions 根据评论内容重新生成提及记录，在创建或修改评论的事务中调用
+用户名按 User.Name 精确匹配；多个用户同名时无法确定提及的是谁，忽略该用户名；提及自己也会忽略
+参数：
+  - tx: GORM 数据库连接
+  - comment: 评论，需要已有 ID、UserID 和 Content
+
+返回值：
+  - error: 查询或保存失败时返回
+*/
+func saveMentions(tx *gorm.DB, comment *Comment) error {
+	if err := tx.Where("comment_id = ?", comment.ID).Delete(&Mention{}).Error; err != nil {
+		return err
+	}
+	names := ParseMentions(comment.Content)
+	if len(names) == 0 {
+		return nil
+	}
+
+	var users []User
+	if err := tx.Select("id", "name").Where("name IN ?", names).Find(&users).Error; err != nil {
+		return err
+	}
+	byName := make(map[string][]uint, len(users))
+	for _, u := range users {
+		byName[u.Name] = append(byName[u.Name], u.ID)
+	}
+	var mentions []Mention
+	for _, name := range names {
+		if ids := byName[name]; len(ids) == 1 && ids[0] != comment.UserID {
+			mentions = append(mentions, Mention{CommentID: comment.ID, UserID: ids[0]})
+		}
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	return tx.Create(&mentions).Error
+}
+
+// mentionedUsers 查询评论提及的用户ID
+func mentionedUsers(db *gorm.DB, commentID uint) ([]uint, error) {
+	var userIDs []uint
+	err := db.Model(&Mention{}).Where("comment_id = ?", commentID).Order("id").Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+/*
+RenderCommentsHTML 填充评论及其已加载的回复的 ContentHTML
+评论内容是纯文本，渲染时转义 HTML，并把有提及记录的 @用户名 渲染为指向该用户的链接，
+没有对应记录的（用户不存在或同名）保持原文
+参数：
+  - db: GORM 数据库连接
+  - comments: 需要渲染的评论，需要已加载 ID 和 Content
+
+返回值：
+  - error: 查询提及记录失败时返回
+*/
+func RenderCommentsHTML(db *gorm.DB, comments []Comment) error {
+	var all []*Comment
+	var collect func(comments []Comment)
+	collect = func(comments []Comment) {
+		for i := range comments {
+			all = append(all, &comments[i])
+			collect(comments[i].Replies)
+		}
+	}
+	collect(comments)
+	if len(all) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(all))
+	for i, c := range all {
+		ids[i] = c.ID
+	}
+	var mentions []Mention
+	if err := db.Preload("User").Where("comment_id IN ?", ids).Find(&mentions).Error; err != nil {
+		return err
+	}
+	users := make(map[uint]map[string]uint)
+	for _, m := range mentions {
+		if m.User == nil {
+			continue
+		}
+		if users[m.CommentID] == nil {
+			users[m.CommentID] = make(map[string]uint)
+		}
+		users[m.CommentID][m.User.Name] = m.UserID
+	}
+
+	for _, c := range all {
+		c.ContentHTML = renderMentions(c.Content, users[c.ID])
+	}
+	return nil
+}
+
+// renderMentions 转义内容并把 users 中的 @用户名 替换为链接，换行转换为 <br>
+func renderMentions(content string, users map[string]uint) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		// m[4]:m[5] 为用户名，@ 在它的前一个字节
+		name := content[m[4]:m[5]]
+		userID, ok := users[name]
+		if !ok {
+			continue
+		}
+		at := m[4] - 1
+		b.WriteString(html.EscapeString(content[last:at]))
+		fmt.Fprintf(&b, `<a href="`+mentionLink+`" class="mention">@%s</a>`, userID, html.EscapeString(name))
+		last = m[5]
+	}
+	b.WriteString(html.EscapeString(content[last:]))
+	return strings.ReplaceAll(b.String(), "\n", "<br>\n")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"gohomeworklesson02/testutil"
+	"testing"
+)
+
+// TestBlogMigrations 测试 blogMigrations 建出的表结构与模型一致：
+// 给模型新增字段或索引时忘记追加迁移，已有的数据库升级后就会缺少对应的列
+func TestBlogMigrations(t *testing.T) {
+	migrated := openTestDB(t)
+	runner := testutil.RunMigrations(t, migrated, 0, blogMigrations...)
+	if version, err := runner.Version(); err != nil || version != blogMigrations[len(blogMigrations)-1].Version {
+		t.Fatalf("迁移后版本 %d，预期 %d: %v", version, blogMigrations[len(blogMigrations)-1].Version, err)
+	}
+
+	models := openTestDB(t)
+	err := models.AutoMigrate(&User{}, &Post{}, &Comment{}, &Tag{}, &Like{}, &Category{}, &PostView{},
+		&Attachment{}, &Follow{}, &Notification{}, &Mention{}, &Report{}, &ModerationLog{})
+	if err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+	testutil.AssertSchema(t, migrated, testutil.CurrentSchema(t, models))
+
+	// 逐步执行时，第一个迁移之后还没有点赞表，文章表已包含模型中的全部列
+	fresh := openTestDB(t)
+	testutil.RunMigrations(t, fresh, 2024010101, blogMigrations...)
+	testutil.AssertSchema(t, fresh, testutil.Schema{
+		Tables: map[string]testutil.TableSchema{"posts": testutil.CurrentSchema(t, models).Tables["posts"]},
+		Absent: []string{"likes", "follows", "reports"},
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 评论的审核状态，只有 CommentApproved 的评论会出现在公开的查询中
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+)
+
+// 自动审核规则的环境变量，见 LoadModerationRules
+const (
+	ModerationRequireApprovalEnv = "BLOG_MODERATION_REQUIRE_APPROVAL"
+	ModerationTrustedAfterEnv    = "BLOG_MODERATION_TRUSTED_AFTER"
+	ModerationBlockedWordsEnv    = "BLOG_MODERATION_BLOCKED_WORDS"
+	ModerationMaxLinksEnv        = "BLOG_MODERATION_MAX_LINKS"
+)
+
+// ModerationRules 发表或修改评论时的自动审核规则，命中规则的评论进入待审核，否则直接通过
+// 审核员和管理员发表的评论总是直接通过
+type ModerationRules struct {
+	RequireApproval bool     // 为 true 时所有评论都需要人工审核（受信任的用户除外）
+	TrustedAfter    int64    // 用户已有这么多条通过的评论后不再需要人工审核，0 表示不启用
+	BlockedWords    []string // 包含这些词（不区分大小写）的评论进入待审核，即使是受信任的用户
+	MaxLinks        int      // 链接数超过时进入待审核，0 表示不限制
+}
+
+// CommentModeration 当前使用的自动审核规则，默认不需要人工审核，链接超过 3 个的评论进入待审核
+// 只应在启动时修改（见 LoadModerationRules），请求处理过程中只读
+var CommentModeration = ModerationRules{MaxLinks: 3}
+
+// LoadModerationRules 从环境变量读取自动审核规则，没有设置的项使用 defaults 中的值
+// BLOG_MODERATION_BLOCKED_WORDS 为逗号分隔的屏蔽词
+func LoadModerationRules(defaults ModerationRules) (ModerationRules, error) {
+	rules := defaults
+	if value := os.Getenv(ModerationRequireApprovalEnv); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return rules, fmt.Errorf("解析 %s 失败: %w", ModerationRequireApprovalEnv, err)
+		}
+		rules.RequireApproval = b
+	}
+	if value := os.Getenv(ModerationTrustedAfterEnv); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return rules, fmt.Errorf("%s 必须是非负整数: %q", ModerationTrustedAfterEnv, value)
+		}
+		rules.TrustedAfter = n
+	}
+	if value := os.Getenv(ModerationBlockedWordsEnv); value != "" {
+		rules.BlockedWords = nil
+		for _, word := range strings.Split(value, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				rules.BlockedWords = append(rules.BlockedWords, word)
+			}
+		}
+	}
+	if value := os.Getenv(ModerationMaxLinksEnv); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return rules, fmt.Errorf("%s 必须是非负整数: %q", ModerationMaxLinksEnv, value)
+		}
+		rules.MaxLinks = n
+	}
+	return rules, nil
+}
+
+/*
+evaluate 根据规则决定评论的审核状态
+参数：
+  - tx: GORM 数据库连接，用于查询作者的角色和已通过的评论数
+  - comment: 待审核的评论，需要设置 UserID 和 Content
+
+返回值：
+  - status: CommentApproved 或 CommentPending
+  - reason: 进入待审核的原因，供审核员参考；直接通过时为空
+  - error: 查询失败时返回
+*/
+func (rules ModerationRules) evaluate(tx *gorm.DB, comment *Comment) (status, reason string, err error) {
+	var roles []string
+	if err := tx.Model(&User{}).Where("id = ?", comment.UserID).Pluck("role", &roles).Error; err != nil {
+		return "", "", err
+	}
+	if len(roles) > 0 && (&User{Role: roles[0]}).CanModerate() {
+		return CommentApproved, "", nil
+	}
+
+	content := strings.ToLower(comment.Content)
+	for _, word := range rules.BlockedWords {
+		if strings.Contains(content, strings.ToLower(word)) {
+			return CommentPending, "包含屏蔽词", nil
+		}
+	}
+	if rules.MaxLinks > 0 {
+		if links := strings.Count(content, "http://") + strings.Count(content, "https://"); links > rules.MaxLinks {
+			return CommentPending, fmt.Sprintf("包含 %d 个链接", links), nil
+		}
+	}
+	if !rules.RequireApproval {
+		return CommentApproved, "", nil
+	}
+
+	if rules.TrustedAfter > 0 {
+		var approved int64
+		err := tx.Model(&Comment{}).
+			Where("user_id = ? AND status = ?", comment.UserID, CommentApproved).
+			Count(&approved).Error
+		if err != nil {
+			return "", "", err
+		}
+		if approved >= rules.TrustedAfter {
+			return CommentApproved, "", nil
+		}
+	}
+	return CommentPending, "需要人工审核", nil
+}
+
+// approvedComments 只查询已通过审核的评论，公开的评论查询都应使用
+func approvedComments(db *gorm.DB) *gorm.DB {
+	return db.Where("comments.status = ?", CommentApproved)
+}
+
+// 分页查询指定审核状态的评论（含作者和文章），按发表时间正序，先发表的先审核
+// 参数 status: CommentPending、CommentApproved 或 CommentRejected，为空时查询待审核的评论
+func ListModerationQueue(db *gorm.DB, status string, page, size int) (dbutil.Page[Comment], error) {
+	if status == "" {
+		status = CommentPending
+	}
+	if status != CommentPending && status != CommentApproved && status != CommentRejected {
+		return dbutil.Page[Comment]{}, &ValidationError{Field: "status", Message: "只能是 pending、approved 或 rejected"}
+	}
+
+	var comments []Comment
+	query := db.Model(&Comment{}).
+		Where("status = ?", status).
+		Preload("User").
+		Preload("Post").
+		Order("created_at ASC").Order("id ASC")
+	total, err := dbutil.FindWithCount(query, &comments, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Comment]{}, err
+	}
+	return dbutil.NewPage(comments, total, page, size), nil
+}
+
+/*
+ModerateComment 审核评论，已经审核过的评论也可以重新审核（如下架已通过的评论）
+评论被拒绝后它的回复也不再公开显示，与删除评论相同
+参数：
+  - db: GORM 数据库连接
+  - moderatorID: 审核员
+  - commentID: 评论ID
+  - status: CommentApproved 或 CommentRejected
+  - reason: 审核理由，拒绝时必填
+
+返回值：
+  - *Comment: 审核后的评论（含作者）
+  - error: 参数错误时返回 ValidationError，评论不存在时返回 ErrCommentNotFound
+*/
+func ModerateComment(db *gorm.DB, moderatorID, commentID uint, status, reason string) (*Comment, error) {
+	reason = strings.TrimSpace(reason)
+	if status != CommentApproved && status != CommentRejected {
+		return nil, &ValidationError{Field: "status", Message: "只能是 approved 或 rejected"}
+	}
+	if status == CommentRejected && reason == "" {
+		return nil, &ValidationError{Field: "reason", Message: "拒绝评论时必须填写理由"}
+	}
+
+	result := db.Model(&Comment{ID: commentID}).Updates(map[string]interface{}{
+		"status":            status,
+		"moderation_reason": reason,
+		"moderated_by":      moderatorID,
+		"moderated_at":      time.Now(),
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrCommentNotFound
+	}
+
+	var comment Comment
+	if err := db.Preload("User").First(&comment, commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	// 待审核的评论通过后才通知文章和被回复的评论的作者
+	notifyComment(db, &comment)
+	return &comment, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"sync"
+	"time"
+)
+
+// 通知类型
+const (
+	NotifyComment = "comment" // 有人评论了你的文章
+	NotifyReply   = "reply"   // 有人回复了你的评论
+	NotifyLike    = "like"    // 有人点赞了你的文章或评论
+	NotifyMention = "mention" // 有人在评论中提及了你
+)
+
+// ErrNotificationNotFound 通知不存在或不属于当前用户
+var ErrNotificationNotFound = errors.New("通知不存在")
+
+// Notification 站内通知
+// 同一事件只通知一次（唯一索引 idx_notification_event），例如取消点赞后再次点赞、
+// 审核员重复通过同一条评论都不会产生新的通知
+type Notification struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	UserID  uint   `gorm:"not null;uniqueIndex:idx_notification_event;index:idx_notification_user_read" json:"user_id"` // 接收人
+	Type    string `gorm:"size:16;not null;uniqueIndex:idx_notification_event" json:"type"`                             // NotifyComment、NotifyReply、NotifyLike 或 NotifyMention
+	ActorID uint   `gorm:"not null;uniqueIndex:idx_notification_event" json:"actor_id"`                                 // 触发通知的用户
+	Actor   *User  `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	PostID  uint   `gorm:"not null;uniqueIndex:idx_notification_event" json:"post_id"` // 相关的文章
+	// 相关的评论：新评论、回复或提及所在的评论本身，点赞评论时为被点赞的评论；点赞文章时为 0
+	CommentID uint       `gorm:"not null;default:0;uniqueIndex:idx_notification_event" json:"comment_id,omitempty"`
+	ReadAt    *time.Time `gorm:"index:idx_notification_user_read" json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NotificationChannel 通知的推送渠道（如邮件、WebSocket），通知保存到数据库后依次调用
+// Deliver 在发起通知的请求中同步执行，耗时的渠道应自行异步发送
+type NotificationChannel interface {
+	Deliver(ctx context.Context, n *Notification) error
+}
+
+// NotificationChannelFunc 把函数作为 NotificationChannel 使用
+type NotificationChannelFunc func(ctx context.Context, n *Notification) error
+
+func (f NotificationChannelFunc) Deliver(ctx context.Context, n *Notification) error {
+	return f(ctx, n)
+}
+
+// NotificationDispatcher 保存通知并推送到注册的渠道
+type NotificationDispatcher struct {
+	mu       sync.RWMutex
+	channels []NotificationChannel
+}
+
+// Notifications 评论、回复和点赞使用的通知分发器，可以在启动时注册推送渠道
+var Notifications = &NotificationDispatcher{}
+
+// Register 注册推送渠道
+func (d *NotificationDispatcher) Register(ch NotificationChannel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels = append(d.channels, ch)
+}
+
+/*
+Dispatch 保存通知并推送到各渠道
+在触发通知的操作提交之后调用，通知失败不影响已完成的操作，因此只记录日志不返回错误；
+发给自己的通知和已经存在的通知会被跳过，也不会推送
+参数：
+  - db: GORM 数据库连接，推送使用其中的 context
+  - notifications: 要发送的通知，只需要填写 UserID、Type、ActorID、PostID 和 CommentID
+*/
+func (d *NotificationDispatcher) Dispatch(db *gorm.DB, notifications ...Notification) {
+	d.mu.RLock()
+	channels := d.channels
+	d.mu.RUnlock()
+
+	for i := range notifications {
+		n := &notifications[i]
+		if n.UserID == 0 || n.UserID == n.ActorID {
+			continue
+		}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(n)
+		if result.Error != nil {
+			log.Printf("保存 %s 通知失败（接收人 %d）: %v", n.Type, n.UserID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		for _, ch := range channels {
+			if err := ch.Deliver(db.Statement.Context, n); err != nil {
+				log.Printf("推送通知 %d 失败: %v", n.ID, err)
+			}
+		}
+	}
+}
+
+// notifyComment 通知已通过审核的评论：回复通知被回复的评论的作者，评论和回复都通知文章作者，
+// 提及的用户收到提及通知（已经收到回复或评论通知的不再重复通知）
+func notifyComment(db *gorm.DB, comment *Comment) {
+	if comment.Status != CommentApproved {
+		return
+	}
+	var notifications []Notification
+	var parentAuthor uint
+	if comment.ParentID != nil {
+		var authors []uint
+		if err := db.Model(&Comment{}).Where("id = ?", *comment.ParentID).Pluck("user_id", &authors).Error; err != nil {
+			log.Printf("查询评论 %d 的作者失败: %v", *comment.ParentID, err)
+		} else if len(authors) > 0 {
+			parentAuthor = authors[0]
+			notifications = append(notifications, Notification{
+				UserID: parentAuthor, Type: NotifyReply, ActorID: comment.UserID, PostID: comment.PostID, CommentID: comment.ID,
+			})
+		}
+	}
+
+	var authors []uint
+	if err := db.Model(&Post{}).Where("id = ?", comment.PostID).Pluck("user_id", &authors).Error; err != nil {
+		log.Printf("查询文章 %d 的作者失败: %v", comment.PostID, err)
+	} else if len(authors) > 0 && authors[0] != parentAuthor {
+		// 文章作者已经收到回复通知时不再重复通知
+		notifications = append(notifications, Notification{
+			UserID: authors[0], Type: NotifyComment, ActorID: comment.UserID, PostID: comment.PostID, CommentID: comment.ID,
+		})
+	}
+
+	mentioned, err := mentionedUsers(db, comment.ID)
+	if err != nil {
+		log.Printf("查询评论 %d 提及的用户失败: %v", comment.ID, err)
+	}
+	notified := make(map[uint]bool, len(notifications))
+	for _, n := range notifications {
+		notified[n.UserID] = true
+	}
+	for _, userID := range mentioned {
+		if !notified[userID] {
+			notifications = append(notifications, Notification{
+				UserID: userID, Type: NotifyMention, ActorID: comment.UserID, PostID: comment.PostID, CommentID: comment.ID,
+			})
+		}
+	}
+	Notifications.Dispatch(db, notifications...)
+}
+
+// notifyLike 通知被点赞的文章或评论的作者
+func notifyLike(db *gorm.DB, userID uint, targetType string, targetID uint) {
+	var target struct {
+		UserID uint
+		PostID uint
+	}
+	n := Notification{Type: NotifyLike, ActorID: userID}
+	var err error
+	switch targetType {
+	case LikeTargetPost:
+		err = db.Model(&Post{}).Select("user_id, id AS post_id").Where("id = ?", targetID).Scan(&target).Error
+	case LikeTargetComment:
+		err = db.Model(&Comment{}).Select("user_id, post_id").Where("id = ?", targetID).Scan(&target).Error
+		n.CommentID = targetID
+	}
+	if err != nil {
+		log.Printf("查询点赞对象 %s %d 失败: %v", targetType, targetID, err)
+		return
+	}
+	n.UserID, n.PostID = target.UserID, target.PostID
+	Notifications.Dispatch(db, n)
+}
+
+// 分页查询用户的通知（含触发人），最新的在前
+// 参数 unreadOnly: 只查询未读的通知
+func ListNotifications(db *gorm.DB, userID uint, unreadOnly bool, page, size int) (dbutil.Page[Notification], error) {
+	var notifications []Notification
+	query := db.Model(&Notification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	query = query.Preload("Actor").Order("created_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &notifications, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Notification]{}, err
+	}
+	return dbutil.NewPage(notifications, total, page, size), nil
+}
+
+// 查询用户的未读通知数量，使用 idx_notification_user_read 索引
+func CountUnreadNotifications(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// 把用户的一条通知标记为已读，已读的通知不会修改；通知不存在或不属于该用户时返回 ErrNotificationNotFound
+func MarkNotificationRead(db *gorm.DB, userID, id uint) error {
+	var count int64
+	if err := db.Model(&Notification{}).Where("id = ? AND user_id = ?", id, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotificationNotFound
+	}
+	return db.Model(&Notification{}).
+		Where("id = ? AND read_at IS NULL", id).
+		UpdateColumn("read_at", time.Now()).Error
+}
+
+// 把用户的所有未读通知标记为已读，返回标记的数量
+func MarkAllNotificationsRead(db *gorm.DB, userID uint) (int64, error) {
+	result := db.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		UpdateColumn("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
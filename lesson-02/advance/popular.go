@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 热门文章的参数限制
+const (
+	DefaultPopularWindow = 7 * 24 * time.Hour  // 默认统计最近 7 天
+	MaxPopularWindow     = 30 * 24 * time.Hour // 最多统计最近 30 天
+	DefaultPopularLimit  = 10
+	MaxPopularLimit      = 50
+)
+
+// DefaultPopularTTL 热门文章缓存的有效期，也是后台刷新的间隔
+const DefaultPopularTTL = 5 * time.Minute
+
+// viewDayLayout PostView.Day 的格式，按 UTC 日期统计
+const viewDayLayout = "2006-01-02"
+
+// PostView 文章每天的浏览次数，用于计算热门文章；只按天累计，不保存每次浏览的记录
+type PostView struct {
+	PostID uint   `gorm:"primaryKey;autoIncrement:false" json:"post_id"`
+	Day    string `gorm:"primaryKey;size:10" json:"day"` // UTC 日期，格式见 viewDayLayout
+	Views  uint   `gorm:"not null;default:0" json:"views"`
+}
+
+// PopularityWeights 热门程度的计算参数
+// 每条评论、点赞、浏览的得分按时间衰减，每经过 HalfLife 得分减半
+type PopularityWeights struct {
+	Comment  float64
+	Like     float64
+	View     float64
+	HalfLife time.Duration
+}
+
+// DefaultPopularityWeights 默认的计算参数：评论比点赞更能说明文章受欢迎，浏览的权重最低
+var DefaultPopularityWeights = PopularityWeights{Comment: 3, Like: 2, View: 0.1, HalfLife: 24 * time.Hour}
+
+// PopularPost 热门文章及其得分
+type PopularPost struct {
+	Post
+	Score float64 `json:"score"`
+}
+
+// 记录文章被浏览一次，累加到当天的浏览次数
+func RecordPostView(db *gorm.DB, postID uint) error {
+	view := PostView{PostID: postID, Day: time.Now().UTC().Format(viewDayLayout), Views: 1}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "post_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("post_views.views + ?", 1)}),
+	}).Create(&view).Error
+}
+
+/*
+GetPopularPosts 查询最近一段时间内最热门的文章（含作者、分类和标签），按得分从高到低排列
+得分根据窗口内已通过审核的评论、点赞和浏览计算，见 DefaultPopularityWeights；窗口内没有任何互动的文章不会返回
+每次调用都会查询数据库，HTTP 接口通过 PopularCache 缓存结果
+参数：
+  - db: GORM 数据库连接
+  - window: 统计最近多长时间，<= 0 时使用 DefaultPopularWindow，最多 MaxPopularWindow
+  - limit: 最多返回多少篇，<= 0 时使用 DefaultPopularLimit，最多 MaxPopularLimit
+
+返回值：
+  - []PopularPost: 热门文章
+  - error: 查询失败时返回
+*/
+func GetPopularPosts(db *gorm.DB, window time.Duration, limit int) ([]PopularPost, error) {
+	return popularPosts(db, DefaultPopularityWeights, time.Now(), window, limit)
+}
+
+// normalizePopular 校验热门文章的统计窗口和数量
+func normalizePopular(window time.Duration, limit int) (time.Duration, int) {
+	if window <= 0 {
+		window = DefaultPopularWindow
+	}
+	if window > MaxPopularWindow {
+		window = MaxPopularWindow
+	}
+	if limit <= 0 {
+		limit = DefaultPopularLimit
+	}
+	if limit > MaxPopularLimit {
+		limit = MaxPopularLimit
+	}
+	return window, limit
+}
+
+// dailyCount 某篇文章某一天的互动次数
+type dailyCount struct {
+	PostID uint
+	Day    string
+	Count  float64
+}
+
+func popularPosts(db *gorm.DB, weights PopularityWeights, now time.Time, window time.Duration, limit int) ([]PopularPost, error) {
+	window, limit = normalizePopular(window, limit)
+	since := now.Add(-window)
+	// 只统计未删除的已发布文章
+	livePosts := db.Session(&gorm.Session{NewDB: true}).Model(&Post{}).Scopes(publishedPosts).Select("id")
+
+	// 评论和点赞按天聚合后再计算衰减，查询的行数与互动次数无关
+	day := dbutil.DateExpr(db, "created_at")
+	var comments, likes, views []dailyCount
+	err := db.Model(&Comment{}).
+		Select("post_id, "+day+" AS day, COUNT(*) AS count").
+		Scopes(approvedComments).
+		Where("created_at >= ? AND post_id IN (?)", since, livePosts).
+		Group("post_id, " + day).
+		Scan(&comments).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&Like{}).
+		Select("target_id AS post_id, "+day+" AS day, COUNT(*) AS count").
+		Where("target_type = ? AND created_at >= ? AND target_id IN (?)", LikeTargetPost, since, livePosts).
+		Group("target_id, " + day).
+		Scan(&likes).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&PostView{}).
+		Select("post_id, day, views AS count").
+		Where("day >= ? AND post_id IN (?)", since.UTC().Format(viewDayLayout), livePosts).
+		Scan(&views).Error
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uint]float64)
+	add := func(counts []dailyCount, weight float64) {
+		for _, c := range counts {
+			scores[c.PostID] += weight * c.Count * weights.decay(now, c.Day)
+		}
+	}
+	add(comments, weights.Comment)
+	add(likes, weights.Like)
+	add(views, weights.View)
+
+	ids := make([]uint, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	// 得分相同时新文章在前
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	if len(ids) == 0 {
+		return []PopularPost{}, nil
+	}
+
+	var posts []Post
+	if err := db.Where("id IN ?", ids).Scopes(postListScope).Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	result := make([]PopularPost, 0, len(ids))
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			result = append(result, PopularPost{Post: post, Score: math.Round(scores[id]*100) / 100})
+		}
+	}
+	return result, nil
+}
+
+// decay 某一天的互动在 now 时的衰减系数，按当天中午计算经过的时间
+func (w PopularityWeights) decay(now time.Time, day string) float64 {
+	t, err := time.Parse(viewDayLayout, day)
+	if err != nil || w.HalfLife <= 0 {
+		return 1
+	}
+	age := now.Sub(t.Add(12 * time.Hour))
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(w.HalfLife))
+}
+
+// PopularCache 缓存各统计窗口的热门文章，过期后下一次请求时重新计算，
+// Run 在后台定期刷新已缓存的窗口，使请求通常不需要等待计算
+type PopularCache struct {
+	db  *gorm.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[time.Duration]popularEntry
+}
+
+// popularEntry 一个统计窗口的缓存，保存 MaxPopularLimit 篇，请求时按 limit 截取
+type popularEntry struct {
+	posts     []PopularPost
+	updatedAt time.Time
+}
+
+// NewPopularCache 创建热门文章缓存，ttl <= 0 时使用 DefaultPopularTTL
+func NewPopularCache(db *gorm.DB, ttl time.Duration) *PopularCache {
+	if ttl <= 0 {
+		ttl = DefaultPopularTTL
+	}
+	return &PopularCache{db: db, ttl: ttl, entries: make(map[time.Duration]popularEntry)}
+}
+
+// Get 返回缓存的热门文章，参数同 GetPopularPosts，缓存不存在或已过期时重新计算
+// 返回的切片与缓存共享，调用方不能修改其中的文章
+func (c *PopularCache) Get(ctx context.Context, window time.Duration, limit int) ([]PopularPost, error) {
+	window, limit = normalizePopular(window, limit)
+
+	c.mu.Lock()
+	entry, ok := c.entries[window]
+	c.mu.Unlock()
+	if !ok || time.Since(entry.updatedAt) >= c.ttl {
+		var err error
+		if entry, err = c.refresh(ctx, window); err != nil {
+			return nil, err
+		}
+	}
+	if len(entry.posts) > limit {
+		return entry.posts[:limit], nil
+	}
+	return entry.posts, nil
+}
+
+// refresh 重新计算 window 的热门文章并写入缓存
+func (c *PopularCache) refresh(ctx context.Context, window time.Duration) (popularEntry, error) {
+	posts, err := GetPopularPosts(c.db.WithContext(ctx), window, MaxPopularLimit)
+	if err != nil {
+		return popularEntry{}, err
+	}
+	entry := popularEntry{posts: posts, updatedAt: time.Now()}
+	c.mu.Lock()
+	c.entries[window] = entry
+	c.mu.Unlock()
+	return entry, nil
+}
+
+// Run 每隔 ttl 刷新一次已缓存的窗口，直到 ctx 结束；刷新失败时记录日志，保留旧的缓存
+func (c *PopularCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		windows := make([]time.Duration, 0, len(c.entries))
+		for window := range c.entries {
+			windows = append(windows, window)
+		}
+		c.mu.Unlock()
+		for _, window := range windows {
+			if _, err := c.refresh(ctx, window); err != nil && ctx.Err() == nil {
+				log.Printf("刷新热门文章失败（%s）: %v", window, err)
+			}
+		}
+	}
+}
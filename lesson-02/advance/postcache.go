@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// 文章缓存的默认参数
+const (
+	DefaultPostCacheTTL     = 30 * time.Second // 缓存的有效期，修改文章时会立即失效，TTL 只是兜底
+	DefaultPostCacheEntries = 1000             // 文章详情和列表页各自最多缓存的条数
+)
+
+// PostCache GetPost 和 ListPosts 的读穿缓存，减少 HTTP 接口对数据库的查询
+// 发布、修改、删除文章，新评论和点赞等写操作通过 InvalidatePost/InvalidateAll 让缓存失效；
+// 任何文章变化都可能影响列表的某一页，因此失效时总是清空所有列表页
+type PostCache struct {
+	db         *gorm.DB
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	posts map[uint]postCacheEntry[Post]
+	lists map[postListKey]postCacheEntry[dbutil.Page[Post]]
+	// generation 每次失效时加一，查询期间缓存失效过的结果不写入缓存，避免写回旧数据
+	generation    uint64
+	hits          uint64
+	misses        uint64
+	invalidations uint64
+}
+
+// postListKey 列表页的缓存键，page 和 size 是规范化之后的值
+type postListKey struct {
+	userID     uint
+	page, size int
+}
+
+type postCacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// PostCacheStats 缓存的命中统计
+type PostCacheStats struct {
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRate       float64 `json:"hit_rate"` // Hits / (Hits + Misses)，没有请求时为 0
+	Invalidations uint64  `json:"invalidations"`
+	Posts         int     `json:"posts"` // 当前缓存的文章详情数量（含已过期未清理的）
+	Lists         int     `json:"lists"` // 当前缓存的列表页数量
+}
+
+// NewPostCache 创建文章缓存，ttl <= 0 时使用 DefaultPostCacheTTL
+func NewPostCache(db *gorm.DB, ttl time.Duration) *PostCache {
+	if ttl <= 0 {
+		ttl = DefaultPostCacheTTL
+	}
+	return &PostCache{
+		db:         db,
+		ttl:        ttl,
+		maxEntries: DefaultPostCacheEntries,
+		posts:      make(map[uint]postCacheEntry[Post]),
+		lists:      make(map[postListKey]postCacheEntry[dbutil.Page[Post]]),
+	}
+}
+
+// GetPost 查询文章详情，参数和错误同 GetPost，文章不存在的结果不缓存
+// 返回的是缓存的副本，调用方可以修改 LikedByMe、ContentHTML 等字段，但不能修改 Tags 等切片的元素
+func (c *PostCache) GetPost(ctx context.Context, postID uint) (*Post, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.posts[postID]
+	hit := ok && now.Before(entry.expiresAt)
+	c.record(hit)
+	generation := c.generation
+	c.mu.Unlock()
+	if hit {
+		post := entry.value
+		return &post, nil
+	}
+
+	post, err := GetPost(c.db.WithContext(ctx), postID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.generation == generation {
+		storeEntry(c.posts, postID, *post, now.Add(c.ttl), c.maxEntries)
+	}
+	c.mu.Unlock()
+	return post, nil
+}
+
+// ListPosts 分页查询已发布的文章，参数同 ListPosts
+// 返回的 Items 是缓存的副本，规则同 GetPost
+func (c *PostCache) ListPosts(ctx context.Context, userID uint, page, size int) (dbutil.Page[Post], error) {
+	page, size = dbutil.NormalizePage(page, size)
+	key := postListKey{userID: userID, page: page, size: size}
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.lists[key]
+	hit := ok && now.Before(entry.expiresAt)
+	c.record(hit)
+	generation := c.generation
+	c.mu.Unlock()
+	if hit {
+		result := entry.value
+		result.Items = make([]Post, len(entry.value.Items))
+		copy(result.Items, entry.value.Items)
+		return result, nil
+	}
+
+	result, err := ListPosts(c.db.WithContext(ctx), userID, page, size)
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	cached := result
+	cached.Items = make([]Post, len(result.Items))
+	copy(cached.Items, result.Items)
+	c.mu.Lock()
+	if c.generation == generation {
+		storeEntry(c.lists, key, cached, now.Add(c.ttl), c.maxEntries)
+	}
+	c.mu.Unlock()
+	return result, nil
+}
+
+// InvalidatePost 清除文章详情的缓存和所有列表页，修改文章或与文章一起返回的数据（点赞数、评论等）后调用
+func (c *PostCache) InvalidatePost(postIDs ...uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range postIDs {
+		delete(c.posts, id)
+	}
+	clear(c.lists)
+	c.generation++
+	c.invalidations++
+}
+
+// InvalidateAll 清除所有缓存，用于影响多篇文章的操作（如发布新文章、定时发布、修改标签）
+func (c *PostCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.posts)
+	clear(c.lists)
+	c.generation++
+	c.invalidations++
+}
+
+// Stats 返回缓存的命中统计
+func (c *PostCache) Stats() PostCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := PostCacheStats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+		Posts:         len(c.posts),
+		Lists:         len(c.lists),
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// record 记录一次命中或未命中，调用方持有锁
+func (c *PostCache) record(hit bool) {
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// storeEntry 写入缓存；已满时先清理过期的条目，仍然满时不写入，调用方持有锁
+func storeEntry[K comparable, T any](entries map[K]postCacheEntry[T], key K, value T, expiresAt time.Time, maxEntries int) {
+	if _, ok := entries[key]; !ok && len(entries) >= maxEntries {
+		now := time.Now()
+		for k, entry := range entries {
+			if !now.Before(entry.expiresAt) {
+				delete(entries, k)
+			}
+		}
+		if len(entries) >= maxEntries {
+			return
+		}
+	}
+	entries[key] = postCacheEntry[T]{value: value, expiresAt: expiresAt}
+}
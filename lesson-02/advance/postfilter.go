@@ -0,0 +1,117 @@
+package main
+
+import (
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"time"
+)
+
+// MaxFilterTags 组合筛选一次最多指定的标签数
+const MaxFilterTags = 20
+
+// PostFilter 文章的组合筛选条件，零值的字段不参与筛选，各条件之间是 AND 的关系
+type PostFilter struct {
+	AuthorID uint   // 作者
+	TagIDs   []uint // 标签，重复的ID只算一次
+	// MatchAllTags 为 true 时文章必须包含 TagIDs 中的所有标签，否则包含任意一个即可
+	MatchAllTags bool
+	CategoryID   *uint // 分类
+	// IncludeDescendants 为 true 时 CategoryID 也匹配下级分类的文章
+	IncludeDescendants bool
+	// Status 文章状态，为空时只查询已发布的文章；查询草稿时调用方需要确认是作者本人
+	Status string
+	// 发布时间范围 [PublishedFrom, PublishedTo)，为 nil 时不限制
+	PublishedFrom *time.Time
+	PublishedTo   *time.Time
+}
+
+// validate 检查筛选条件，返回去重后的标签ID
+func (f PostFilter) validate() ([]uint, error) {
+	switch f.Status {
+	case "", PostDraft, PostScheduled, PostPublished:
+	default:
+		return nil, &ValidationError{Field: "status", Message: "只能是 draft、scheduled 或 published"}
+	}
+	if f.PublishedFrom != nil && f.PublishedTo != nil && !f.PublishedFrom.Before(*f.PublishedTo) {
+		return nil, &ValidationError{Field: "to", Message: "必须晚于开始时间"}
+	}
+
+	seen := make(map[uint]bool, len(f.TagIDs))
+	tagIDs := make([]uint, 0, len(f.TagIDs))
+	for _, id := range f.TagIDs {
+		if !seen[id] {
+			seen[id] = true
+			tagIDs = append(tagIDs, id)
+		}
+	}
+	if len(tagIDs) > MaxFilterTags {
+		return nil, &ValidationError{Field: "tag_ids", Message: "最多指定 20 个标签"}
+	}
+	return tagIDs, nil
+}
+
+/*
+ListPostsFiltered 按作者、标签、分类、状态和发布时间组合筛选文章（含作者、分类和标签），按发布时间倒序
+所有条件在同一条 SQL 中完成：标签条件先在 post_tags 中按文章分组，
+要求包含所有标签时用 HAVING 比较匹配的标签数，再与 posts 连接，一篇文章只会返回一次
+参数：
+  - db: GORM 数据库连接
+  - filter: 筛选条件，零值时等同于 ListPosts(db, 0, page, size)
+  - page, size: 分页参数，规则见 dbutil.NormalizePage
+
+返回值：
+  - dbutil.Page[Post]: 分页结果
+  - error: 条件不正确时返回 ValidationError，分类不存在时返回 ErrCategoryNotFound
+*/
+func ListPostsFiltered(db *gorm.DB, filter PostFilter, page, size int) (dbutil.Page[Post], error) {
+	tagIDs, err := filter.validate()
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+
+	query := db.Model(&Post{})
+	if filter.Status == "" {
+		query = query.Scopes(publishedPosts)
+	} else {
+		query = query.Where("posts.status = ?", filter.Status)
+	}
+	if filter.AuthorID != 0 {
+		query = query.Where("posts.user_id = ?", filter.AuthorID)
+	}
+	if filter.CategoryID != nil {
+		if err := ensureExists(db, &Category{}, *filter.CategoryID, ErrCategoryNotFound); err != nil {
+			return dbutil.Page[Post]{}, err
+		}
+		categoryIDs := []uint{*filter.CategoryID}
+		if filter.IncludeDescendants {
+			if categoryIDs, err = categoryDescendantIDs(db, *filter.CategoryID); err != nil {
+				return dbutil.Page[Post]{}, err
+			}
+		}
+		query = query.Where("posts.category_id IN ?", categoryIDs)
+	}
+	if filter.PublishedFrom != nil {
+		query = query.Where("posts.published_at >= ?", filter.PublishedFrom.UTC())
+	}
+	if filter.PublishedTo != nil {
+		query = query.Where("posts.published_at < ?", filter.PublishedTo.UTC())
+	}
+	if len(tagIDs) > 0 {
+		matched := db.Session(&gorm.Session{NewDB: true}).
+			Table("post_tags").
+			Select("post_id").
+			Where("tag_id IN ?", tagIDs).
+			Group("post_id")
+		if filter.MatchAllTags {
+			matched = matched.Having("COUNT(DISTINCT tag_id) = ?", len(tagIDs))
+		}
+		query = query.Joins("JOIN (?) AS matched_tags ON matched_tags.post_id = posts.id", matched)
+	}
+
+	var posts []Post
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size), postListScope)
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	return dbutil.NewPage(posts, total, page, size), nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// 举报的处理状态
+const (
+	ReportOpen      = "open"      // 待处理
+	ReportDismissed = "dismissed" // 驳回，内容没有问题
+	ReportResolved  = "resolved"  // 已处理（隐藏内容或封禁作者）
+)
+
+// 审核操作，记录在 ModerationLog.Action
+const (
+	ActionDismissReport = "dismiss_report" // 驳回举报
+	ActionHidePost      = "hide_post"      // 隐藏文章
+	ActionHideComment   = "hide_comment"   // 隐藏评论
+	ActionSuspendUser   = "suspend_user"   // 封禁用户
+	ActionUnsuspendUser = "unsuspend_user" // 解除封禁
+)
+
+var (
+	// ErrReportNotFound 举报不存在
+	ErrReportNotFound = errors.New("举报不存在")
+	// ErrAlreadyReported 同一用户对同一内容只能举报一次
+	ErrAlreadyReported = errors.New("已经举报过该内容")
+	// ErrUserSuspended 账号已被封禁，不能登录，已签发的 token 也不再有效
+	ErrUserSuspended = errors.New("账号已被封禁")
+)
+
+// Report 用户对文章或评论的举报，同一用户对同一内容只能举报一次（唯一索引 idx_report_reporter_target）
+type Report struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	ReporterID uint   `gorm:"not null;uniqueIndex:idx_report_reporter_target" json:"reporter_id"`
+	Reporter   *User  `gorm:"foreignKey:ReporterID" json:"reporter,omitempty"`
+	TargetType string `gorm:"size:16;not null;uniqueIndex:idx_report_reporter_target;index:idx_report_target" json:"target_type"` // LikeTargetPost 或 LikeTargetComment
+	TargetID   uint   `gorm:"not null;uniqueIndex:idx_report_reporter_target;index:idx_report_target" json:"target_id"`
+	AuthorID   uint   `gorm:"not null" json:"author_id"` // 被举报内容的作者
+	Reason     string `gorm:"size:500;not null" json:"reason"`
+	Status     string `gorm:"size:16;not null;default:open;index" json:"status"` // ReportOpen、ReportDismissed 或 ReportResolved
+	// 处理举报的审核员、时间和处理说明，待处理时为空
+	HandledBy  *uint      `json:"handled_by,omitempty"`
+	HandledAt  *time.Time `json:"handled_at,omitempty"`
+	Resolution string     `gorm:"size:500" json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ModerationLog 审核操作日志，处理举报和封禁用户时在同一事务中写入，只增不改
+type ModerationLog struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ModeratorID uint      `gorm:"not null;index" json:"moderator_id"`
+	Moderator   *User     `gorm:"foreignKey:ModeratorID" json:"moderator,omitempty"`
+	Action      string    `gorm:"size:32;not null" json:"action"`      // ActionDismissReport 等
+	TargetType  string    `gorm:"size:16;not null" json:"target_type"` // LikeTargetPost、LikeTargetComment 或 user
+	TargetID    uint      `gorm:"not null" json:"target_id"`
+	ReportID    *uint     `json:"report_id,omitempty"` // 由举报触发时为对应的举报
+	Note        string    `gorm:"size:500" json:"note,omitempty"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
+
+// moderationTargetUser 封禁和解除封禁时 ModerationLog.TargetType 的取值
+const moderationTargetUser = "user"
+
+// ReportAction 处理举报的动作，至少选择一项
+type ReportAction struct {
+	Hide    bool   // 隐藏被举报的内容：文章改为 PostHidden，评论改为 CommentRejected
+	Suspend bool   // 封禁被举报内容的作者，不能封禁审核员和管理员
+	Note    string // 处理说明，必填，隐藏评论时同时作为评论的审核理由
+}
+
+/*
+ReportContent 举报文章或评论
+只能举报公开的内容（已发布的文章、已通过审核的评论），不能举报自己的内容
+参数：
+  - db: GORM 数据库连接
+  - reporterID: 举报人
+  - targetType: LikeTargetPost 或 LikeTargetComment
+  - targetID: 文章或评论ID
+  - reason: 举报理由
+
+返回值：
+  - *Report: 创建的举报
+  - error: 内容不存在时返回 ErrPostNotFound/ErrCommentNotFound，已经举报过时返回 ErrAlreadyReported，
+    举报自己的内容时返回 ValidationError
+*/
+func ReportContent(db *gorm.DB, reporterID uint, targetType string, targetID uint, reason string) (*Report, error) {
+	var query *gorm.DB
+	var notFound error
+	switch targetType {
+	case LikeTargetPost:
+		query, notFound = db.Model(&Post{}).Scopes(publishedPosts), ErrPostNotFound
+	case LikeTargetComment:
+		query, notFound = db.Model(&Comment{}).Scopes(approvedComments), ErrCommentNotFound
+	default:
+		return nil, ErrInvalidLikeTarget
+	}
+	var authors []uint
+	if err := query.Where("id = ?", targetID).Pluck("user_id", &authors).Error; err != nil {
+		return nil, err
+	}
+	if len(authors) == 0 {
+		return nil, notFound
+	}
+	if authors[0] == reporterID {
+		return nil, &ValidationError{Field: "target", Message: "不能举报自己的内容"}
+	}
+
+	report := Report{
+		ReporterID: reporterID, TargetType: targetType, TargetID: targetID, AuthorID: authors[0],
+		Reason: strings.TrimSpace(reason), Status: ReportOpen,
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&report)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyReported
+	}
+	return &report, nil
+}
+
+// 分页查询指定状态的举报（含举报人），按举报时间正序，先举报的先处理
+// 参数 status: ReportOpen、ReportDismissed 或 ReportResolved，为空时查询待处理的举报
+func ListReports(db *gorm.DB, status string, page, size int) (dbutil.Page[Report], error) {
+	if status == "" {
+		status = ReportOpen
+	}
+	if status != ReportOpen && status != ReportDismissed && status != ReportResolved {
+		return dbutil.Page[Report]{}, &ValidationError{Field: "status", Message: "只能是 open、dismissed 或 resolved"}
+	}
+	var reports []Report
+	query := db.Model(&Report{}).
+		Where("status = ?", status).
+		Preload("Reporter").
+		Order("created_at ASC").Order("id ASC")
+	total, err := dbutil.FindWithCount(query, &reports, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Report]{}, err
+	}
+	return dbutil.NewPage(reports, total, page, size), nil
+}
+
+// findOpenReport 查询待处理的举报，举报不存在时返回 ErrReportNotFound，已处理时返回 ValidationError
+func findOpenReport(tx *gorm.DB, reportID uint) (*Report, error) {
+	var report Report
+	if err := tx.First(&report, reportID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+	if report.Status != ReportOpen {
+		return nil, &ValidationError{Field: "status", Message: "举报已处理"}
+	}
+	return &report, nil
+}
+
+// 驳回举报，只处理这一条，同一内容的其他举报仍然待处理；note 为处理说明，可以为空
+func DismissReport(db *gorm.DB, moderatorID, reportID uint, note string) (*Report, error) {
+	note = strings.TrimSpace(note)
+	var report *Report
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var err error
+		if report, err = findOpenReport(tx, reportID); err != nil {
+			return err
+		}
+		if err := closeReports(tx.Where("id = ?", report.ID), moderatorID, ReportDismissed, note); err != nil {
+			return err
+		}
+		return tx.Create(&ModerationLog{
+			ModeratorID: moderatorID, Action: ActionDismissReport,
+			TargetType: report.TargetType, TargetID: report.TargetID, ReportID: &report.ID, Note: note,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.First(report, reportID).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+/*
+ResolveReport 处理举报：隐藏被举报的内容和/或封禁作者，每个动作写一条审核日志
+同一内容的其他待处理举报一并标记为已处理，所有修改在同一事务中完成
+参数：
+  - db: GORM 数据库连接
+  - moderatorID: 审核员
+  - reportID: 举报ID
+  - action: 处理动作
+
+返回值：
+  - *Report: 处理后的举报
+  - error: 举报不存在时返回 ErrReportNotFound；已处理、没有选择动作、没有填写说明，
+    或作者是审核员/管理员时返回 ValidationError
+*/
+func ResolveReport(db *gorm.DB, moderatorID, reportID uint, action ReportAction) (*Report, error) {
+	action.Note = strings.TrimSpace(action.Note)
+	if !action.Hide && !action.Suspend {
+		return nil, &ValidationError{Field: "action", Message: "至少选择隐藏内容或封禁作者中的一项"}
+	}
+	if action.Note == "" {
+		return nil, &ValidationError{Field: "note", Message: "处理举报时必须填写说明"}
+	}
+
+	var report *Report
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var err error
+		if report, err = findOpenReport(tx, reportID); err != nil {
+			return err
+		}
+		if action.Hide {
+			if err := hideContent(tx, moderatorID, report, action.Note); err != nil {
+				return err
+			}
+		}
+		if action.Suspend {
+			if err := suspendUser(tx, moderatorID, report.AuthorID, &report.ID, action.Note); err != nil {
+				return err
+			}
+		}
+		sameTarget := tx.Where("target_type = ? AND target_id = ? AND status = ?", report.TargetType, report.TargetID, ReportOpen)
+		return closeReports(sameTarget, moderatorID, ReportResolved, action.Note)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.First(report, reportID).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// closeReports 把 query 匹配的举报标记为 status，记录处理人、时间和说明
+func closeReports(query *gorm.DB, moderatorID uint, status, note string) error {
+	return query.Model(&Report{}).Updates(map[string]interface{}{
+		"status":     status,
+		"handled_by": moderatorID,
+		"handled_at": time.Now(),
+		"resolution": note,
+	}).Error
+}
+
+// hideContent 隐藏被举报的文章或评论并写审核日志
+// 隐藏的文章不再公开，作者仍然可以查看和修改，但不能重新发布；隐藏的评论与审核拒绝相同
+func hideContent(tx *gorm.DB, moderatorID uint, report *Report, note string) error {
+	logEntry := ModerationLog{
+		ModeratorID: moderatorID, TargetType: report.TargetType, TargetID: report.TargetID, ReportID: &report.ID, Note: note,
+	}
+	switch report.TargetType {
+	case LikeTargetPost:
+		logEntry.Action = ActionHidePost
+		if err := tx.Model(&Post{ID: report.TargetID}).Update("status", PostHidden).Error; err != nil {
+			return err
+		}
+	case LikeTargetComment:
+		logEntry.Action = ActionHideComment
+		err := tx.Model(&Comment{ID: report.TargetID}).Updates(map[string]interface{}{
+			"status":            CommentRejected,
+			"moderation_reason": note,
+			"moderated_by":      moderatorID,
+			"moderated_at":      time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Create(&logEntry).Error
+}
+
+// suspendUser 封禁用户并写审核日志，已经封禁的用户保留最初的封禁时间
+// 不能封禁审核员和管理员，返回 ValidationError
+func suspendUser(tx *gorm.DB, moderatorID, userID uint, reportID *uint, note string) error {
+	var user User
+	if err := tx.Select("id", "role").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if user.CanModerate() {
+		return &ValidationError{Field: "suspend", Message: "不能封禁审核员或管理员"}
+	}
+	err := tx.Model(&User{}).
+		Where("id = ? AND suspended_at IS NULL", userID).
+		UpdateColumn("suspended_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&ModerationLog{
+		ModeratorID: moderatorID, Action: ActionSuspendUser,
+		TargetType: moderationTargetUser, TargetID: userID, ReportID: reportID, Note: note,
+	}).Error
+}
+
+// 解除用户的封禁并写审核日志，用户不存在时返回 ErrUserNotFound，没有被封禁时返回 ValidationError
+func UnsuspendUser(db *gorm.DB, moderatorID, userID uint, note string) error {
+	return dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if err := ensureExists(tx, &User{}, userID, ErrUserNotFound); err != nil {
+			return err
+		}
+		result := tx.Model(&User{}).
+			Where("id = ? AND suspended_at IS NOT NULL", userID).
+			UpdateColumn("suspended_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &ValidationError{Field: "user", Message: "用户没有被封禁"}
+		}
+		return tx.Create(&ModerationLog{
+			ModeratorID: moderatorID, Action: ActionUnsuspendUser,
+			TargetType: moderationTargetUser, TargetID: userID, Note: strings.TrimSpace(note),
+		}).Error
+	})
+}
+
+// 分页查询审核日志（含审核员），最新的在前
+func ListModerationLogs(db *gorm.DB, page, size int) (dbutil.Page[ModerationLog], error) {
+	var logs []ModerationLog
+	query := db.Model(&ModerationLog{}).Preload("Moderator").Order("created_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &logs, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[ModerationLog]{}, err
+	}
+	return dbutil.NewPage(logs, total, page, size), nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+	_ "time/tzdata" // 内置时区数据，没有安装 tzdata 的系统也能解析 timezone
+)
+
+// 文章状态，只有已发布的文章会出现在列表、归档、热门和关注动态中
+const (
+	PostDraft     = "draft"     // 草稿，只有作者可以看到
+	PostScheduled = "scheduled" // 定时发布，到 PublishedAt 时由 ScheduledPublisher 发布
+	PostPublished = "published" // 已发布
+	PostHidden    = "hidden"    // 被审核员隐藏（见 ResolveReport），作者可以查看和修改，但不能重新发布
+)
+
+// DefaultPublishInterval ScheduledPublisher 检查到期文章的间隔
+const DefaultPublishInterval = time.Minute
+
+// publishAtLayouts 指定 timezone 时 publish_at 可以使用的本地时间格式
+var publishAtLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// publishedPosts 只查询已发布的文章，列名带表名，可以用于 JOIN 查询
+func publishedPosts(db *gorm.DB) *gorm.DB {
+	return db.Where("posts.status = ?", PostPublished)
+}
+
+/*
+ParsePublishAt 解析定时发布的时间
+timezone 为空时 value 必须是带时区偏移的 RFC 3339 时间（如 2024-06-01T08:00:00+08:00）；
+指定 timezone（IANA 名称，如 Asia/Shanghai）时 value 也可以是不带偏移的本地时间，按该时区解释，夏令时切换由时区数据处理
+参数：
+  - value: 发布时间
+  - timezone: 时区名称，可以为空
+
+返回值：
+  - time.Time: UTC 时间
+  - error: 格式或时区不正确时返回 ValidationError
+*/
+func ParsePublishAt(value, timezone string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, &ValidationError{Field: "publish_at", Message: "不能为空"}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if timezone == "" {
+		return time.Time{}, &ValidationError{Field: "publish_at", Message: "必须是带时区的 RFC 3339 时间，或同时指定 timezone"}
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, &ValidationError{Field: "timezone", Message: "未知的时区 " + timezone}
+	}
+	for _, layout := range publishAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, &ValidationError{Field: "publish_at", Message: "格式应为 2006-01-02T15:04:05"}
+}
+
+// findUnpublished 查询未发布的文章，文章不存在时返回 ErrPostNotFound，已发布或被隐藏时返回 ValidationError
+func findUnpublished(tx *gorm.DB, postID uint) (*Post, error) {
+	var post Post
+	if err := tx.Select("id", "status").First(&post, postID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+	switch post.Status {
+	case PostPublished:
+		return nil, &ValidationError{Field: "status", Message: "文章已发布"}
+	case PostHidden:
+		return nil, &ValidationError{Field: "status", Message: "文章已被审核员隐藏"}
+	}
+	return &post, nil
+}
+
+// 把草稿或定时发布的文章设置为在 publishAt 定时发布，publishAt 必须晚于当前时间（dbutil.ClockFrom）
+// 文章不存在时返回 ErrPostNotFound，已发布时返回 ValidationError
+func SchedulePost(db *gorm.DB, postID uint, publishAt time.Time) (*Post, error) {
+	if !publishAt.After(dbutil.ClockFrom(db.Statement.Context).Now()) {
+		return nil, &ValidationError{Field: "publish_at", Message: "必须晚于当前时间"}
+	}
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if _, err := findUnpublished(tx, postID); err != nil {
+			return err
+		}
+		return tx.Model(&Post{ID: postID}).
+			Updates(map[string]interface{}{"status": PostScheduled, "published_at": publishAt.UTC()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPost(db, postID)
+}
+
+// 取消定时发布，文章恢复为草稿；文章不是定时发布状态时返回 ValidationError
+func CancelSchedule(db *gorm.DB, postID uint) (*Post, error) {
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		post, err := findUnpublished(tx, postID)
+		if err != nil {
+			return err
+		}
+		if post.Status != PostScheduled {
+			return &ValidationError{Field: "status", Message: "文章没有定时发布"}
+		}
+		return tx.Model(post).Update("status", PostDraft).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPost(db, postID)
+}
+
+// 立即发布草稿或定时发布的文章，发布时间为当前时间（dbutil.ClockFrom）
+func PublishPost(db *gorm.DB, postID uint) (*Post, error) {
+	now := dbutil.ClockFrom(db.Statement.Context).Now()
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if _, err := findUnpublished(tx, postID); err != nil {
+			return err
+		}
+		return tx.Model(&Post{ID: postID}).
+			Updates(map[string]interface{}{"status": PostPublished, "published_at": now.UTC()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPost(db, postID)
+}
+
+// 发布所有到期（PublishedAt 不晚于 now）的定时文章，返回发布的数量
+// 只修改状态，发布时间保持为计划的时间；条件中包含状态，与取消定时发布并发时不会发布已取消的文章
+func PublishDuePosts(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Model(&Post{}).
+		Where("status = ? AND published_at <= ?", PostScheduled, now.UTC()).
+		Update("status", PostPublished)
+	return result.RowsAffected, result.Error
+}
+
+// 分页查询用户的草稿和定时发布的文章（含分类和标签），最近修改的在前
+func ListDrafts(db *gorm.DB, userID uint, page, size int) (dbutil.Page[Post], error) {
+	var posts []Post
+	query := db.Model(&Post{}).
+		Where("user_id = ? AND status IN ?", userID, []string{PostDraft, PostScheduled}).
+		Preload("Category").Preload("Tags").
+		Order("updated_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[Post]{}, err
+	}
+	return dbutil.NewPage(posts, total, page, size), nil
+}
+
+// ScheduledPublisher 定时发布到期的文章，由 runServer 在后台运行
+type ScheduledPublisher struct {
+	db       *gorm.DB
+	interval time.Duration
+	// OnPublish 发布了文章之后调用，可以为 nil；runServer 用它清除文章缓存
+	OnPublish func()
+	// Clock 判断文章是否到期和计时使用的时钟，为 nil 时使用系统时间
+	Clock dbutil.Clock
+}
+
+// NewScheduledPublisher 创建定时发布的后台任务，interval <= 0 时使用 DefaultPublishInterval
+func NewScheduledPublisher(db *gorm.DB, interval time.Duration) *ScheduledPublisher {
+	if interval <= 0 {
+		interval = DefaultPublishInterval
+	}
+	return &ScheduledPublisher{db: db, interval: interval}
+}
+
+// Run 启动时和之后每隔 interval 发布一次到期的文章，直到 ctx 结束；失败时记录日志，下次继续
+// 文章最多比计划时间晚 interval 发布
+func (p *ScheduledPublisher) Run(ctx context.Context) {
+	clock := p.Clock
+	if clock == nil {
+		clock = dbutil.SystemClock{}
+	}
+	ticker := clock.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		n, err := PublishDuePosts(p.db.WithContext(ctx), clock.Now())
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("定时发布文章失败: %v", err)
+		case n > 0:
+			log.Printf("定时发布了 %d 篇文章", n)
+			if p.OnPublish != nil {
+				p.OnPublish()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,1818 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// 请求参数的限制
+const (
+	maxBodyBytes         = 1 << 20 // 请求体最大 1MB
+	maxTitleLength       = 200     // 标题最多 200 个字符
+	maxContentLength     = 20000   // 文章内容最多 20000 个字符
+	maxCommentLength     = 2000    // 评论最多 2000 个字符
+	maxNameLength        = 64      // 用户名、分类名和 slug 最多 64 个字符
+	maxEmailLength       = 128     // 邮箱最多 128 个字符，与 users.email 列的长度一致
+	maxDescriptionLength = 500     // 分类描述最多 500 个字符
+)
+
+// shutdownTimeout 收到退出信号后等待进行中的请求完成的最长时间
+const shutdownTimeout = 10 * time.Second
+
+// ValidationError 请求参数校验失败，返回 400
+type ValidationError struct {
+	Field   string // 出错的字段，请求体格式错误时为空
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// errorResponse 出错时返回的 JSON
+type errorResponse struct {
+	Error string `json:"error"`
+	Field string `json:"field,omitempty"`
+}
+
+// Server 博客的 HTTP API，所有读写都通过 blog.go 中的函数完成
+// 文章内容是 Markdown，查询文章的接口支持参数 format=html 同时返回渲染后的 content_html；
+// 评论内容是纯文本，查询、发表和修改评论的接口同样支持 format=html，返回转义后的内容，@用户名 渲染为链接
+// 标记 * 的接口需要登录，请求头携带 Authorization: Bearer <token>，只能修改、删除自己的文章和评论；
+// 标记 M 的接口只有审核员和管理员可以访问，标记 A 的接口只有管理员（RoleAdmin）可以访问；
+// 其余接口登录后返回的文章和评论会标记 liked_by_me，评论只返回已通过审核的
+//
+//	POST   /auth/register         注册 {"name", "email", "password"}
+//	POST   /auth/login            登录 {"email", "password"}，返回 token
+//	GET    /me                  * 当前登录用户，其他接口返回的用户信息都不含邮箱
+//	POST   /users/post-counts/recount A 重新统计所有用户的文章数量，修复计数偏差，返回修正的用户数
+//	GET    /posts                 分页查询文章，参数 page、size、user_id；
+//	                              组合筛选参数 tag_ids（逗号分隔）、tag_mode（any 或 all，默认 any）、category_id、
+//	                              include_descendants（默认 true）、from、to（发布时间，RFC 3339 或日期）、
+//	                              status（draft、scheduled 或 published，非 published 时 user_id 必须是自己）；
+//	                              带 cursor 参数时使用游标分页（第一页传空值，不支持筛选参数），返回 next_cursor
+//	POST   /posts               * 发布文章 {"title", "content", "category_id", "tag_ids", "draft"}，draft 为 true 时保存为草稿
+//	GET    /posts/drafts        * 分页查询自己的草稿和定时发布的文章，参数 page、size
+//	GET    /posts/popular         热门文章，参数 days（统计最近几天，默认 7，最多 30）、limit（默认 10，最多 50）
+//	GET    /posts/{id}            文章详情，同时记录一次浏览；未发布的文章只有作者可以查看
+//	GET    /posts/{id}/related    相同标签最多的其他文章，参数 limit（默认 5，最多 20）
+//	PUT    /posts/{id}          * 修改文章 {"title", "content", "category_id"}
+//	DELETE /posts/{id}          * 删除文章（软删除）
+//	PUT    /posts/{id}/schedule * 定时发布草稿 {"publish_at", "timezone"}，publish_at 为 RFC 3339 时间，
+//	                              或指定 timezone（如 Asia/Shanghai）时的本地时间 2006-01-02T15:04:05
+//	DELETE /posts/{id}/schedule * 取消定时发布，文章恢复为草稿
+//	POST   /posts/{id}/publish  * 立即发布草稿或定时发布的文章
+//	PUT    /posts/{id}/like     * 点赞文章，重复点赞不会重复计数
+//	DELETE /posts/{id}/like     * 取消点赞
+//	GET    /archive               按月归档：每个月发布的文章数量
+//	GET    /archive/{year}/{month} 分页查询某个月发布的文章，参数 page、size
+//	GET    /tags                  所有标签及文章数量
+//	PUT    /tags/{id}           A 重命名标签 {"name"}
+//	POST   /tags/{id}/merge     A 把标签合并到另一个标签 {"into"}，合并后删除该标签
+//	DELETE /tags/orphans        A 删除没有文章使用的标签
+//	POST   /attachments         * 上传附件，multipart/form-data：file（最大 10MB）、post_id（可选）
+//	GET    /attachments/{id}      下载附件；未关联文章的附件只有上传人可以下载
+//	PUT    /attachments/{id}    * 关联附件到文章 {"post_id"}，post_id 为 null 时取消关联
+//	DELETE /attachments/{id}    * 删除附件
+//	DELETE /attachments/orphans A 清理上传超过 24 小时仍未关联文章的附件和没有记录的文件
+//	GET    /posts/{id}/attachments 文章的附件
+//	GET    /feed                * 关注的作者发布的文章，参数 page、size
+//	PUT    /users/{id}/follow   * 关注作者，重复关注不会重复计数
+//	DELETE /users/{id}/follow   * 取消关注
+//	GET    /users/{id}/followers 分页查询粉丝，参数 page、size
+//	GET    /users/{id}/following 分页查询关注的作者，参数 page、size
+//	GET    /notifications       * 分页查询通知，参数 page、size、unread（为 true 时只返回未读）
+//	GET    /notifications/unread-count * 未读通知数量
+//	PUT    /notifications/{id}/read * 标记通知为已读
+//	PUT    /notifications/read  * 标记所有通知为已读
+//	GET    /trash/posts         * 分页查询自己已删除的文章，参数 page、size
+//	POST   /posts/{id}/restore  * 恢复已删除的文章
+//	POST   /comments/{id}/restore * 恢复已删除的评论，回复的评论已删除时需要先恢复上级评论
+//	DELETE /trash               A 彻底删除回收站中超过 days 天（默认 30）的文章和评论
+//	GET    /cache/posts         A 文章缓存的命中次数、命中率和缓存条数
+//	GET    /categories            分类树
+//	POST   /categories          * 创建分类 {"name", "slug", "description", "parent_id"}
+//	GET    /categories/{slug}/posts 分页查询分类下的文章，参数 page、size、include_descendants（默认 true）
+//	GET    /posts/{id}/comments   分页查询评论树，参数 page、size、depth（回复的层数）、format
+//	POST   /posts/{id}/comments * 发表评论 {"content"}，内容中的 @用户名 通知被提及的用户；发表过于频繁时返回 429
+//	                              （带 Retry-After），重复内容或被判定为垃圾评论时返回 422，规则见 CommentAntiSpam
+//	GET    /comments/{id}/replies 分页查询评论的回复树，参数同上
+//	POST   /comments/{id}/replies * 回复评论 {"content"}，限制同发表评论
+//	PUT    /comments/{id}       * 修改评论 {"content"}
+//	DELETE /comments/{id}       * 删除评论（软删除）
+//	PUT    /comments/{id}/like  * 点赞评论
+//	DELETE /comments/{id}/like  * 取消点赞
+//	GET    /moderation/comments M 分页查询待审核的评论，参数 page、size、status（默认 pending）
+//	PUT    /comments/{id}/moderation M 审核评论 {"status": "approved"|"rejected", "reason"}，拒绝时必须填写理由
+//	POST   /posts/{id}/reports  * 举报文章 {"reason"}，同一内容只能举报一次，重复举报返回 409
+//	POST   /comments/{id}/reports * 举报评论 {"reason"}，同上
+//	GET    /moderation/reports  M 分页查询举报，参数 page、size、status（默认 open）
+//	POST   /moderation/reports/{id}/dismiss M 驳回举报 {"note"}
+//	POST   /moderation/reports/{id}/resolve M 处理举报 {"hide", "suspend", "note"}：隐藏内容和/或封禁作者，
+//	                              同一内容的其他举报一并处理；封禁的用户不能登录，已有的 token 返回 403
+//	DELETE /users/{id}/suspension M 解除封禁 {"note"}
+//	GET    /moderation/logs     M 分页查询审核日志（处理举报、封禁和解除封禁），参数 page、size
+type Server struct {
+	db      *gorm.DB
+	tokens  *TokenIssuer
+	cursors *dbutil.CursorCodec // 分页游标的签名，密钥由 tokens 的密钥派生
+	popular *PopularCache       // 热门文章的缓存，由 runServer 定期刷新
+	posts   *PostCache          // 文章详情和列表的缓存，修改文章的接口负责让它失效
+	storage Storage             // 附件文件的存储
+	mux     *http.ServeMux
+}
+
+// NewServer 创建 API 服务并注册路由，tokens 用于颁发和校验登录 token，storage 用于保存附件
+func NewServer(db *gorm.DB, tokens *TokenIssuer, storage Storage) *Server {
+	// 派生的密钥固定 32 字节，不会返回错误
+	cursors, _ := dbutil.NewCursorCodec(tokens.deriveKey("cursor"))
+	s := &Server{
+		db:      db,
+		tokens:  tokens,
+		cursors: cursors,
+		popular: NewPopularCache(db, DefaultPopularTTL),
+		posts:   NewPostCache(db, DefaultPostCacheTTL),
+		storage: storage,
+		mux:     http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("POST /auth/register", s.handle(s.register))
+	s.mux.HandleFunc("POST /auth/login", s.handle(s.login))
+	s.mux.HandleFunc("GET /me", s.handle(s.requireUser(s.me)))
+	s.mux.HandleFunc("POST /users/post-counts/recount", s.handle(s.requireAdmin(s.recountPostCounts)))
+
+	s.mux.HandleFunc("GET /posts", s.handle(s.optionalUser(s.listPosts)))
+	s.mux.HandleFunc("POST /posts", s.handle(s.requireUser(s.createPost)))
+	s.mux.HandleFunc("GET /posts/popular", s.handle(s.optionalUser(s.listPopularPosts)))
+	s.mux.HandleFunc("GET /posts/drafts", s.handle(s.requireUser(s.listDrafts)))
+	s.mux.HandleFunc("GET /posts/{id}", s.handle(s.optionalUser(s.getPost)))
+	s.mux.HandleFunc("GET /posts/{id}/related", s.handle(s.optionalUser(s.listRelatedPosts)))
+	s.mux.HandleFunc("PUT /posts/{id}", s.handle(s.requireUser(s.updatePost)))
+	s.mux.HandleFunc("DELETE /posts/{id}", s.handle(s.requireUser(s.deletePost)))
+	s.mux.HandleFunc("PUT /posts/{id}/schedule", s.handle(s.requireUser(s.schedulePost)))
+	s.mux.HandleFunc("DELETE /posts/{id}/schedule", s.handle(s.requireUser(s.changePostStatus(CancelSchedule))))
+	s.mux.HandleFunc("POST /posts/{id}/publish", s.handle(s.requireUser(s.changePostStatus(PublishPost))))
+	s.mux.HandleFunc("PUT /posts/{id}/like", s.handle(s.requireUser(s.like(LikeTargetPost, true))))
+	s.mux.HandleFunc("DELETE /posts/{id}/like", s.handle(s.requireUser(s.like(LikeTargetPost, false))))
+	s.mux.HandleFunc("GET /archive", s.handle(s.getArchive))
+	s.mux.HandleFunc("GET /archive/{year}/{month}", s.handle(s.optionalUser(s.listPostsByMonth)))
+	s.mux.HandleFunc("GET /tags", s.handle(s.listTags))
+	s.mux.HandleFunc("PUT /tags/{id}", s.handle(s.requireAdmin(s.renameTag)))
+	s.mux.HandleFunc("POST /tags/{id}/merge", s.handle(s.requireAdmin(s.mergeTags)))
+	s.mux.HandleFunc("DELETE /tags/orphans", s.handle(s.requireAdmin(s.deleteOrphanTags)))
+	s.mux.HandleFunc("POST /attachments", s.handle(s.requireUser(s.uploadAttachment)))
+	s.mux.HandleFunc("GET /attachments/{id}", s.handle(s.optionalUser(s.downloadAttachment)))
+	s.mux.HandleFunc("PUT /attachments/{id}", s.handle(s.requireUser(s.attachToPost)))
+	s.mux.HandleFunc("DELETE /attachments/{id}", s.handle(s.requireUser(s.deleteAttachment)))
+	s.mux.HandleFunc("DELETE /attachments/orphans", s.handle(s.requireAdmin(s.cleanupAttachments)))
+	s.mux.HandleFunc("GET /posts/{id}/attachments", s.handle(s.listPostAttachments))
+	s.mux.HandleFunc("GET /feed", s.handle(s.requireUser(s.getFeed)))
+	s.mux.HandleFunc("PUT /users/{id}/follow", s.handle(s.requireUser(s.follow(true))))
+	s.mux.HandleFunc("DELETE /users/{id}/follow", s.handle(s.requireUser(s.follow(false))))
+	s.mux.HandleFunc("GET /users/{id}/followers", s.handle(s.listFollowUsers(ListFollowers)))
+	s.mux.HandleFunc("GET /users/{id}/following", s.handle(s.listFollowUsers(ListFollowing)))
+	s.mux.HandleFunc("GET /notifications", s.handle(s.requireUser(s.listNotifications)))
+	s.mux.HandleFunc("GET /notifications/unread-count", s.handle(s.requireUser(s.countUnreadNotifications)))
+	s.mux.HandleFunc("PUT /notifications/{id}/read", s.handle(s.requireUser(s.markNotificationRead)))
+	s.mux.HandleFunc("PUT /notifications/read", s.handle(s.requireUser(s.markAllNotificationsRead)))
+	s.mux.HandleFunc("GET /trash/posts", s.handle(s.requireUser(s.listDeletedPosts)))
+	s.mux.HandleFunc("POST /posts/{id}/restore", s.handle(s.requireUser(s.restorePost)))
+	s.mux.HandleFunc("POST /comments/{id}/restore", s.handle(s.requireUser(s.restoreComment)))
+	s.mux.HandleFunc("DELETE /trash", s.handle(s.requireAdmin(s.purgeTrash)))
+	s.mux.HandleFunc("GET /cache/posts", s.handle(s.requireAdmin(s.postCacheStats)))
+	s.mux.HandleFunc("GET /categories", s.handle(s.listCategories))
+	s.mux.HandleFunc("POST /categories", s.handle(s.requireUser(s.createCategory)))
+	s.mux.HandleFunc("GET /categories/{slug}/posts", s.handle(s.optionalUser(s.listCategoryPosts)))
+	s.mux.HandleFunc("GET /posts/{id}/comments", s.handle(s.optionalUser(s.listComments)))
+	s.mux.HandleFunc("POST /posts/{id}/comments", s.handle(s.requireUser(s.createComment)))
+	s.mux.HandleFunc("GET /comments/{id}/replies", s.handle(s.optionalUser(s.listReplies)))
+	s.mux.HandleFunc("POST /comments/{id}/replies", s.handle(s.requireUser(s.createReply)))
+	s.mux.HandleFunc("PUT /comments/{id}", s.handle(s.requireUser(s.updateComment)))
+	s.mux.HandleFunc("DELETE /comments/{id}", s.handle(s.requireUser(s.deleteComment)))
+	s.mux.HandleFunc("PUT /comments/{id}/like", s.handle(s.requireUser(s.like(LikeTargetComment, true))))
+	s.mux.HandleFunc("DELETE /comments/{id}/like", s.handle(s.requireUser(s.like(LikeTargetComment, false))))
+	s.mux.HandleFunc("GET /moderation/comments", s.handle(s.requireModerator(s.listModerationQueue)))
+	s.mux.HandleFunc("PUT /comments/{id}/moderation", s.handle(s.requireModerator(s.moderateComment)))
+	s.mux.HandleFunc("POST /posts/{id}/reports", s.handle(s.requireUser(s.report(LikeTargetPost))))
+	s.mux.HandleFunc("POST /comments/{id}/reports", s.handle(s.requireUser(s.report(LikeTargetComment))))
+	s.mux.HandleFunc("GET /moderation/reports", s.handle(s.requireModerator(s.listReports)))
+	s.mux.HandleFunc("POST /moderation/reports/{id}/dismiss", s.handle(s.requireModerator(s.dismissReport)))
+	s.mux.HandleFunc("POST /moderation/reports/{id}/resolve", s.handle(s.requireModerator(s.resolveReport)))
+	s.mux.HandleFunc("DELETE /users/{id}/suspension", s.handle(s.requireModerator(s.unsuspendUser)))
+	s.mux.HandleFunc("GET /moderation/logs", s.handle(s.requireModerator(s.listModerationLogs)))
+}
+
+// ServeHTTP 实现 http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handlerFunc 返回错误的处理函数，错误由 handle 统一转换为 HTTP 状态码
+type handlerFunc func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error
+
+// handle 把请求的 context 传给数据库（客户端断开时取消查询），并统一处理错误
+func (s *Server) handle(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r, s.db.WithContext(r.Context())); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// ---------- 用户 ----------
+
+type registerRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (req *registerRequest) validate() error {
+	if err := validateText("name", req.Name, maxNameLength); err != nil {
+		return err
+	}
+	if err := validateText("email", req.Email, maxEmailLength); err != nil {
+		return err
+	}
+	if !strings.Contains(req.Email, "@") {
+		return &ValidationError{Field: "email", Message: "格式不正确"}
+	}
+	return nil
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// tokenResponse 注册和登录成功时返回的 token
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      *User     `json:"user"`
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	var req registerRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	user, err := RegisterUser(db, req.Name, req.Email, req.Password)
+	if err != nil {
+		return err
+	}
+	return s.writeToken(w, http.StatusCreated, user)
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	var req loginRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	user, err := Authenticate(db, req.Email, req.Password)
+	if err != nil {
+		return err
+	}
+	return s.writeToken(w, http.StatusOK, user)
+}
+
+// meResponse 当前登录用户，User 的 JSON 不含邮箱，只有本人才能看到自己的邮箱
+type meResponse struct {
+	*User
+	Email string `json:"email"`
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	user, _ := CurrentUser(r.Context())
+	return writeJSON(w, http.StatusOK, meResponse{User: user, Email: user.Email})
+}
+
+// recountPostCounts 重新统计文章数量后清空文章缓存，返回修正的用户数
+func (s *Server) recountPostCounts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	fixed, err := RecountPostCounts(db)
+	if err != nil {
+		return err
+	}
+	// 文章缓存中的作者带有文章数量
+	s.posts.InvalidateAll()
+	return writeJSON(w, http.StatusOK, countResponse{Count: fixed})
+}
+
+// writeToken 为用户颁发 token 并返回
+func (s *Server) writeToken(w http.ResponseWriter, status int, user *User) error {
+	token, expiresAt, err := s.tokens.Issue(user.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, status, tokenResponse{Token: token, ExpiresAt: expiresAt, User: user})
+}
+
+// ---------- 文章 ----------
+
+type postRequest struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	CategoryID *uint  `json:"category_id"`
+	TagIDs     []uint `json:"tag_ids"`
+}
+
+// createPostRequest 发布文章的请求，修改文章时不能修改状态
+type createPostRequest struct {
+	postRequest
+	Draft bool `json:"draft"`
+}
+
+func (req *postRequest) validate() error {
+	req.Title = strings.TrimSpace(req.Title)
+	if err := validateText("title", req.Title, maxTitleLength); err != nil {
+		return err
+	}
+	return validateText("content", req.Content, maxContentLength)
+}
+
+// cursorPageResponse 游标分页的响应，next_cursor 为空时表示没有下一页
+type cursorPageResponse[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (s *Server) listPosts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	userID, err := queryUint(r, "user_id")
+	if err != nil {
+		return err
+	}
+	filter, filtered, err := postFilterParams(r)
+	if err != nil {
+		return err
+	}
+	if r.URL.Query().Has("cursor") {
+		if filtered {
+			return &ValidationError{Field: "cursor", Message: "游标分页不支持筛选参数"}
+		}
+		return s.listPostsByCursor(w, r, db, userID, size)
+	}
+
+	var posts dbutil.Page[Post]
+	if filtered {
+		// 草稿和定时发布的文章只能查询自己的
+		if filter.Status != "" && filter.Status != PostPublished && (userID == 0 || userID != viewerID(r.Context())) {
+			return ErrForbidden
+		}
+		filter.AuthorID = userID
+		posts, err = ListPostsFiltered(db, filter, page, size)
+	} else {
+		// 只缓存不带筛选参数的列表
+		posts, err = s.posts.ListPosts(r.Context(), userID, page, size)
+	}
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedPosts(db, viewerID(r.Context()), posts.Items); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+// postFilterParams 解析 GET /posts 的筛选参数（作者 user_id 除外），filtered 表示是否带有任何筛选参数
+// from、to 可以是 RFC 3339 时间或 2006-01-02 格式的日期（UTC），to 为日期时包含当天
+func postFilterParams(r *http.Request) (filter PostFilter, filtered bool, err error) {
+	q := r.URL.Query()
+	for _, name := range []string{"tag_ids", "tag_mode", "category_id", "include_descendants", "status", "from", "to"} {
+		if q.Has(name) {
+			filtered = true
+		}
+	}
+	if !filtered {
+		return PostFilter{}, false, nil
+	}
+
+	if value := q.Get("tag_ids"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 0)
+			if err != nil || id == 0 {
+				return PostFilter{}, true, &ValidationError{Field: "tag_ids", Message: "必须是逗号分隔的正整数"}
+			}
+			filter.TagIDs = append(filter.TagIDs, uint(id))
+		}
+	}
+	switch q.Get("tag_mode") {
+	case "", "any":
+	case "all":
+		filter.MatchAllTags = true
+	default:
+		return PostFilter{}, true, &ValidationError{Field: "tag_mode", Message: "只能是 any 或 all"}
+	}
+	if q.Has("category_id") {
+		id, err := queryUint(r, "category_id")
+		if err != nil {
+			return PostFilter{}, true, err
+		}
+		filter.CategoryID = &id
+	}
+	filter.IncludeDescendants = true
+	if value := q.Get("include_descendants"); value != "" {
+		if filter.IncludeDescendants, err = strconv.ParseBool(value); err != nil {
+			return PostFilter{}, true, &ValidationError{Field: "include_descendants", Message: "必须是 true 或 false"}
+		}
+	}
+	filter.Status = q.Get("status")
+	if filter.PublishedFrom, err = queryTime(r, "from", false); err != nil {
+		return PostFilter{}, true, err
+	}
+	if filter.PublishedTo, err = queryTime(r, "to", true); err != nil {
+		return PostFilter{}, true, err
+	}
+	return filter, true, nil
+}
+
+// queryTime 解析 RFC 3339 时间或 2006-01-02 格式的日期（UTC），参数为空时返回 nil
+// endOfDay 为 true 时日期解析为第二天零点，用作不包含的结束时间
+func queryTime(r *http.Request, name string, endOfDay bool) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, &ValidationError{Field: name, Message: "必须是 RFC 3339 时间或 2006-01-02 格式的日期"}
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// listPostsByCursor 游标分页查询文章，cursor 为空时返回第一页
+func (s *Server) listPostsByCursor(w http.ResponseWriter, r *http.Request, db *gorm.DB, userID uint, size int) error {
+	var cursor *PostCursor
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		cursor = &PostCursor{}
+		if err := s.cursors.Decode(token, cursor); err != nil {
+			return &ValidationError{Field: "cursor", Message: err.Error()}
+		}
+	}
+	posts, err := ListPostsByCursor(db, userID, cursor, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedPosts(db, viewerID(r.Context()), posts.Items); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+
+	resp := cursorPageResponse[Post]{Items: posts.Items, Total: posts.Total}
+	if posts.Next != nil {
+		if resp.NextCursor, err = s.cursors.Encode(posts.Next); err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getArchive(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	archive, err := GetArchive(db)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, archive)
+}
+
+func (s *Server) listPostsByMonth(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	year, err := pathUint(r, "year")
+	if err != nil {
+		return err
+	}
+	month, err := pathUint(r, "month")
+	if err != nil {
+		return err
+	}
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	posts, err := ListPostsByMonth(db, int(year), int(month), page, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedPosts(db, viewerID(r.Context()), posts.Items); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+func (s *Server) createPost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	var req createPostRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	user, _ := CurrentUser(r.Context())
+	post := &Post{Title: req.Title, Content: req.Content, UserID: user.ID, CategoryID: req.CategoryID}
+	if req.Draft {
+		post.Status = PostDraft
+	}
+	if err := PublishPostWithTags(db, post, req.TagIDs); err != nil {
+		return err
+	}
+	s.posts.InvalidateAll()
+	created, err := GetPost(db, post.ID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", fmt.Sprintf("/posts/%d", post.ID))
+	return writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) getPost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	post, err := s.posts.GetPost(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if post.Status != PostPublished {
+		// 草稿对其他人不可见，与文章不存在一样返回 404；作者查看草稿不计入浏览
+		if viewerID(r.Context()) != post.UserID {
+			return ErrPostNotFound
+		}
+	} else if err := RecordPostView(db, id); err != nil {
+		// 浏览次数只用于排序热门文章，记录失败不影响返回文章
+		log.Printf("记录文章 %d 的浏览失败: %v", id, err)
+	}
+	posts := []Post{*post}
+	if err := MarkLikedPosts(db, viewerID(r.Context()), posts); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, &posts[0]); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts[0])
+}
+
+func (s *Server) listRelatedPosts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	limit, err := queryUint(r, "limit")
+	if err != nil {
+		return err
+	}
+	related, err := GetRelatedPosts(db, id, int(limit))
+	if err != nil {
+		return err
+	}
+	ptrs := make([]*Post, len(related))
+	for i := range related {
+		ptrs[i] = &related[i].Post
+	}
+	if err := prepareEmbeddedPosts(r, db, ptrs); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, related)
+}
+
+func (s *Server) listPopularPosts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	days, err := queryUint(r, "days")
+	if err != nil {
+		return err
+	}
+	limit, err := queryUint(r, "limit")
+	if err != nil {
+		return err
+	}
+	cached, err := s.popular.Get(r.Context(), time.Duration(days)*24*time.Hour, int(limit))
+	if err != nil {
+		return err
+	}
+
+	// 缓存的结果被多个请求共享，复制后再标记当前用户的点赞状态
+	posts := make([]PopularPost, len(cached))
+	copy(posts, cached)
+	ptrs := make([]*Post, len(posts))
+	for i := range posts {
+		ptrs[i] = &posts[i].Post
+	}
+	if err := prepareEmbeddedPosts(r, db, ptrs); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+func (s *Server) updatePost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req postRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Post{}, id, user.ID, ErrPostNotFound); err != nil {
+		return err
+	}
+	post, err := UpdatePost(db, id, req.Title, req.Content, req.CategoryID)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(id)
+	return writeJSON(w, http.StatusOK, post)
+}
+
+func (s *Server) deletePost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Post{}, id, user.ID, ErrPostNotFound); err != nil {
+		return err
+	}
+	if err := DeletePost(db, id); err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type scheduleRequest struct {
+	PublishAt string `json:"publish_at"`
+	Timezone  string `json:"timezone"`
+}
+
+func (s *Server) schedulePost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req scheduleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	publishAt, err := ParsePublishAt(req.PublishAt, req.Timezone)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Post{}, id, user.ID, ErrPostNotFound); err != nil {
+		return err
+	}
+	post, err := SchedulePost(db, id, publishAt)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(id)
+	return writeJSON(w, http.StatusOK, post)
+}
+
+// changePostStatus 取消定时发布或立即发布自己的文章，返回修改后的文章
+func (s *Server) changePostStatus(change func(db *gorm.DB, postID uint) (*Post, error)) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		id, err := pathID(r)
+		if err != nil {
+			return err
+		}
+		user, _ := CurrentUser(r.Context())
+		if err := checkOwner(db, &Post{}, id, user.ID, ErrPostNotFound); err != nil {
+			return err
+		}
+		post, err := change(db, id)
+		if err != nil {
+			return err
+		}
+		s.posts.InvalidatePost(id)
+		return writeJSON(w, http.StatusOK, post)
+	}
+}
+
+func (s *Server) listDrafts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	posts, err := ListDrafts(db, user.ID, page, size)
+	if err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+func (s *Server) postCacheStats(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	return writeJSON(w, http.StatusOK, s.posts.Stats())
+}
+
+// ---------- 标签 ----------
+
+type renameTagRequest struct {
+	Name string `json:"name"`
+}
+
+type mergeTagsRequest struct {
+	Into uint `json:"into"` // 保留的标签
+}
+
+// deleteOrphanTagsResponse 删除的标签
+type deleteOrphanTagsResponse struct {
+	Deleted []Tag `json:"deleted"`
+}
+
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	tags, err := ListTags(db)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = []TagWithCount{}
+	}
+	return writeJSON(w, http.StatusOK, tags)
+}
+
+func (s *Server) renameTag(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req renameTagRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateText("name", req.Name, maxNameLength); err != nil {
+		return err
+	}
+	tag, err := RenameTag(db, id, req.Name)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidateAll()
+	return writeJSON(w, http.StatusOK, tag)
+}
+
+func (s *Server) mergeTags(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req mergeTagsRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if req.Into == 0 {
+		return &ValidationError{Field: "into", Message: "不能为空"}
+	}
+	tag, err := MergeTags(db, id, req.Into)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidateAll()
+	return writeJSON(w, http.StatusOK, tag)
+}
+
+func (s *Server) deleteOrphanTags(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	tags, err := DeleteOrphanTags(db)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, deleteOrphanTagsResponse{Deleted: tags})
+}
+
+// ---------- 附件 ----------
+
+type attachRequest struct {
+	PostID *uint `json:"post_id"`
+}
+
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	// 除文件外的表单字段很小，请求体最多比文件大 maxBodyBytes
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAttachmentBytes+maxBodyBytes)
+	if err := r.ParseMultipartForm(maxBodyBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &ValidationError{Field: "file", Message: fmt.Sprintf("最大 %dMB", MaxAttachmentBytes>>20)}
+		}
+		return &ValidationError{Message: "请求体格式错误: " + err.Error()}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	user, _ := CurrentUser(r.Context())
+	var postID *uint
+	if value := r.FormValue("post_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil || id == 0 {
+			return &ValidationError{Field: "post_id", Message: "必须是正整数"}
+		}
+		if err := checkOwner(db, &Post{}, uint(id), user.ID, ErrPostNotFound); err != nil {
+			return err
+		}
+		pid := uint(id)
+		postID = &pid
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return &ValidationError{Field: "file", Message: "不能为空"}
+	}
+	defer file.Close()
+
+	attachment, err := SaveAttachment(db, s.storage, user.ID, postID, header.Filename, file)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", fmt.Sprintf("/attachments/%d", attachment.ID))
+	return writeJSON(w, http.StatusCreated, attachment)
+}
+
+func (s *Server) downloadAttachment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	attachment, err := GetAttachment(db, id)
+	if err != nil {
+		return err
+	}
+	visible, err := AttachmentVisible(db, attachment, viewerID(r.Context()))
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return ErrAttachmentNotFound
+	}
+	file, err := s.storage.Open(r.Context(), attachment.StorageKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// 文件类型是上传时根据内容识别的，禁止浏览器再次猜测类型，并且不允许执行脚本
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	// 本地文件支持 Range 和 If-Modified-Since
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", attachment.CreatedAt, seeker)
+		return nil
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Printf("发送附件 %d 失败: %v", id, err)
+	}
+	return nil
+}
+
+func (s *Server) attachToPost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req attachRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Attachment{}, id, user.ID, ErrAttachmentNotFound); err != nil {
+		return err
+	}
+	if req.PostID != nil {
+		if err := checkOwner(db, &Post{}, *req.PostID, user.ID, ErrPostNotFound); err != nil {
+			return err
+		}
+	}
+	attachment, err := AttachToPost(db, id, req.PostID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, attachment)
+}
+
+func (s *Server) deleteAttachment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Attachment{}, id, user.ID, ErrAttachmentNotFound); err != nil {
+		return err
+	}
+	if err := DeleteAttachment(db, s.storage, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) cleanupAttachments(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	result, err := CleanupOrphanAttachments(db, s.storage, DefaultOrphanAttachmentAge)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) listPostAttachments(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	attachments, err := ListPostAttachments(db, id)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, attachments)
+}
+
+// ---------- 分类 ----------
+
+type categoryRequest struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	ParentID    *uint  `json:"parent_id"`
+}
+
+func (req *categoryRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateText("name", req.Name, maxNameLength); err != nil {
+		return err
+	}
+	if err := validateText("slug", req.Slug, maxNameLength); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(req.Description) > maxDescriptionLength {
+		return &ValidationError{Field: "description", Message: fmt.Sprintf("最多 %d 个字符", maxDescriptionLength)}
+	}
+	return nil
+}
+
+func (s *Server) listCategories(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	categories, err := ListCategoryTree(db)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, categories)
+}
+
+func (s *Server) createCategory(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	var req categoryRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	category := &Category{Name: req.Name, Slug: req.Slug, Description: req.Description, ParentID: req.ParentID}
+	if err := CreateCategory(db, category); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, category)
+}
+
+func (s *Server) listCategoryPosts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	includeDescendants := true
+	if value := r.URL.Query().Get("include_descendants"); value != "" {
+		if includeDescendants, err = strconv.ParseBool(value); err != nil {
+			return &ValidationError{Field: "include_descendants", Message: "必须是 true 或 false"}
+		}
+	}
+	posts, err := ListPostsByCategory(db, r.PathValue("slug"), includeDescendants, page, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedPosts(db, viewerID(r.Context()), posts.Items); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+// ---------- 评论 ----------
+
+type commentRequest struct {
+	Content string `json:"content"`
+}
+
+func (req *commentRequest) validate() error {
+	return validateText("content", req.Content, maxCommentLength)
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	postID, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	depth, err := queryUint(r, "depth")
+	if err != nil {
+		return err
+	}
+	// 文章不存在或未发布时返回 404，而不是空列表
+	if err := ensureExists(db.Scopes(publishedPosts), &Post{}, postID, ErrPostNotFound); err != nil {
+		return err
+	}
+	comments, err := GetPostComments(db, postID, int(depth), page, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedComments(db, viewerID(r.Context()), comments.Items); err != nil {
+		return err
+	}
+	if err := applyCommentFormat(r, db, comments.Items); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *Server) listReplies(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	commentID, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	depth, err := queryUint(r, "depth")
+	if err != nil {
+		return err
+	}
+	replies, err := GetCommentReplies(db, commentID, int(depth), page, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedComments(db, viewerID(r.Context()), replies.Items); err != nil {
+		return err
+	}
+	if err := applyCommentFormat(r, db, replies.Items); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, replies)
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	postID, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req commentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	user, _ := CurrentUser(r.Context())
+	comment, err := PublishComment(db, user.ID, postID, req.Content)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(comment.PostID)
+	created := []Comment{*comment}
+	if err := applyCommentFormat(r, db, created); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, created[0])
+}
+
+func (s *Server) createReply(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	parentID, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req commentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	user, _ := CurrentUser(r.Context())
+	reply, err := ReplyComment(db, user.ID, parentID, req.Content)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(reply.PostID)
+	created := []Comment{*reply}
+	if err := applyCommentFormat(r, db, created); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, created[0])
+}
+
+func (s *Server) updateComment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req commentRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Comment{}, id, user.ID, ErrCommentNotFound); err != nil {
+		return err
+	}
+	comment, err := UpdateComment(db, id, req.Content)
+	if err != nil {
+		return err
+	}
+	updated := []Comment{*comment}
+	if err := applyCommentFormat(r, db, updated); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, updated[0])
+}
+
+func (s *Server) deleteComment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db, &Comment{}, id, user.ID, ErrCommentNotFound); err != nil {
+		return err
+	}
+	if err := SoftDeleteComment(db, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// ---------- 点赞 ----------
+
+// likeResponse 点赞和取消点赞后的状态
+type likeResponse struct {
+	Liked     bool `json:"liked"`
+	LikeCount uint `json:"like_count"`
+}
+
+// like 点赞（like 为 true）或取消点赞 targetType 类型的对象，对象ID取自路径中的 {id}
+func (s *Server) like(targetType string, like bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		id, err := pathID(r)
+		if err != nil {
+			return err
+		}
+		user, _ := CurrentUser(r.Context())
+		change := UnlikeTarget
+		if like {
+			change = LikeTarget
+		}
+		count, err := change(db, user.ID, targetType, id)
+		if err != nil {
+			return err
+		}
+		if targetType == LikeTargetPost {
+			// 点赞数随文章一起返回
+			s.posts.InvalidatePost(id)
+		}
+		return writeJSON(w, http.StatusOK, likeResponse{Liked: like, LikeCount: count})
+	}
+}
+
+// ---------- 关注 ----------
+
+// followResponse 关注和取消关注的结果，计数是被关注作者的
+type followResponse struct {
+	Following bool `json:"following"`
+	FollowCounts
+}
+
+func (s *Server) getFeed(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	posts, err := GetFeed(db, user.ID, page, size)
+	if err != nil {
+		return err
+	}
+	if err := MarkLikedPosts(db, user.ID, posts.Items); err != nil {
+		return err
+	}
+	if err := applyContentFormat(r, db, postPtrs(posts.Items)...); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+func (s *Server) follow(follow bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		id, err := pathID(r)
+		if err != nil {
+			return err
+		}
+		user, _ := CurrentUser(r.Context())
+		change := UnfollowUser
+		if follow {
+			change = FollowUser
+		}
+		counts, err := change(db, user.ID, id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, followResponse{Following: follow, FollowCounts: counts})
+	}
+}
+
+// listFollowUsers 返回分页查询粉丝或关注的作者的处理函数，list 为 ListFollowers 或 ListFollowing
+func (s *Server) listFollowUsers(list func(db *gorm.DB, userID uint, page, size int) (dbutil.Page[User], error)) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		id, err := pathID(r)
+		if err != nil {
+			return err
+		}
+		page, size, err := pageParams(r)
+		if err != nil {
+			return err
+		}
+		users, err := list(db, id, page, size)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, users)
+	}
+}
+
+// ---------- 通知 ----------
+
+// countResponse 返回数量的接口的响应
+type countResponse struct {
+	Count int64 `json:"count"`
+}
+
+func (s *Server) listNotifications(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	var unreadOnly bool
+	if value := r.URL.Query().Get("unread"); value != "" {
+		if unreadOnly, err = strconv.ParseBool(value); err != nil {
+			return &ValidationError{Field: "unread", Message: "必须是 true 或 false"}
+		}
+	}
+	user, _ := CurrentUser(r.Context())
+	notifications, err := ListNotifications(db, user.ID, unreadOnly, page, size)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, notifications)
+}
+
+func (s *Server) countUnreadNotifications(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	user, _ := CurrentUser(r.Context())
+	count, err := CountUnreadNotifications(db, user.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (s *Server) markNotificationRead(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := MarkNotificationRead(db, user.ID, id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// markAllNotificationsRead 返回本次标记的数量
+func (s *Server) markAllNotificationsRead(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	user, _ := CurrentUser(r.Context())
+	count, err := MarkAllNotificationsRead(db, user.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+// ---------- 回收站 ----------
+
+func (s *Server) listDeletedPosts(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	posts, err := ListDeletedPosts(db, user.ID, page, size)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, posts)
+}
+
+func (s *Server) restorePost(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	// 已删除的文章需要 Unscoped 才能查到作者
+	if err := checkOwner(db.Unscoped(), &Post{}, id, user.ID, ErrPostNotFound); err != nil {
+		return err
+	}
+	post, err := RestorePost(db, id)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(id)
+	return writeJSON(w, http.StatusOK, post)
+}
+
+func (s *Server) restoreComment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	user, _ := CurrentUser(r.Context())
+	if err := checkOwner(db.Unscoped(), &Comment{}, id, user.ID, ErrCommentNotFound); err != nil {
+		return err
+	}
+	comment, err := RestoreComment(db, id)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, comment)
+}
+
+func (s *Server) purgeTrash(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	days, err := queryUint(r, "days")
+	if err != nil {
+		return err
+	}
+	purged, err := PurgeTrash(db, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, purged)
+}
+
+// ---------- 评论审核 ----------
+
+type moderationRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func (req *moderationRequest) validate() error {
+	if n := utf8.RuneCountInString(req.Reason); n > maxDescriptionLength {
+		return &ValidationError{Field: "reason", Message: fmt.Sprintf("最多 %d 个字符，实际 %d 个", maxDescriptionLength, n)}
+	}
+	return nil
+}
+
+func (s *Server) listModerationQueue(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	comments, err := ListModerationQueue(db, r.URL.Query().Get("status"), page, size)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *Server) moderateComment(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req moderationRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	moderator, _ := CurrentUser(r.Context())
+	comment, err := ModerateComment(db, moderator.ID, id, req.Status, req.Reason)
+	if err != nil {
+		return err
+	}
+	s.posts.InvalidatePost(comment.PostID)
+	return writeJSON(w, http.StatusOK, comment)
+}
+
+// ---------- 举报 ----------
+
+type reportRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (req *reportRequest) validate() error {
+	return validateText("reason", req.Reason, maxDescriptionLength)
+}
+
+// noteRequest 驳回举报和解除封禁的请求，note 为可选的处理说明
+type noteRequest struct {
+	Note string `json:"note"`
+}
+
+func (req *noteRequest) validate() error {
+	if n := utf8.RuneCountInString(req.Note); n > maxDescriptionLength {
+		return &ValidationError{Field: "note", Message: fmt.Sprintf("最多 %d 个字符，实际 %d 个", maxDescriptionLength, n)}
+	}
+	return nil
+}
+
+// resolveReportRequest 处理举报的请求，note 必填
+type resolveReportRequest struct {
+	noteRequest
+	Hide    bool `json:"hide"`
+	Suspend bool `json:"suspend"`
+}
+
+// report 返回举报文章或评论的处理函数
+func (s *Server) report(targetType string) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+		id, err := pathID(r)
+		if err != nil {
+			return err
+		}
+		var req reportRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			return err
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+		user, _ := CurrentUser(r.Context())
+		report, err := ReportContent(db, user.ID, targetType, id, req.Reason)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusCreated, report)
+	}
+}
+
+func (s *Server) listReports(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	reports, err := ListReports(db, r.URL.Query().Get("status"), page, size)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, reports)
+}
+
+func (s *Server) dismissReport(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req noteRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	moderator, _ := CurrentUser(r.Context())
+	report, err := DismissReport(db, moderator.ID, id, req.Note)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) resolveReport(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req resolveReportRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	moderator, _ := CurrentUser(r.Context())
+	report, err := ResolveReport(db, moderator.ID, id, ReportAction{Hide: req.Hide, Suspend: req.Suspend, Note: req.Note})
+	if err != nil {
+		return err
+	}
+	// 隐藏的内容和被封禁的作者都会影响缓存的文章和列表
+	s.posts.InvalidateAll()
+	return writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) unsuspendUser(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	id, err := pathID(r)
+	if err != nil {
+		return err
+	}
+	var req noteRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		return err
+	}
+	if err := req.validate(); err != nil {
+		return err
+	}
+	moderator, _ := CurrentUser(r.Context())
+	if err := UnsuspendUser(db, moderator.ID, id, req.Note); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) listModerationLogs(w http.ResponseWriter, r *http.Request, db *gorm.DB) error {
+	page, size, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	logs, err := ListModerationLogs(db, page, size)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, logs)
+}
+
+// ---------- 请求解析和响应 ----------
+
+// decodeJSON 解析 JSON 请求体，拒绝未知字段和超过 maxBodyBytes 的请求体
+func decodeJSON(w http.ResponseWriter, r *http.Request, dest interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dest); err != nil {
+		return &ValidationError{Message: "请求体格式错误: " + err.Error()}
+	}
+	if dec.More() {
+		return &ValidationError{Message: "请求体只能包含一个 JSON 对象"}
+	}
+	return nil
+}
+
+// validateText 检查必填的文本字段及其长度（按字符计算）
+func validateText(field, value string, maxLength int) error {
+	if strings.TrimSpace(value) == "" {
+		return &ValidationError{Field: field, Message: "不能为空"}
+	}
+	if n := utf8.RuneCountInString(value); n > maxLength {
+		return &ValidationError{Field: field, Message: fmt.Sprintf("最多 %d 个字符，实际 %d 个", maxLength, n)}
+	}
+	return nil
+}
+
+// pathID 解析路径中的 {id}
+func pathID(r *http.Request) (uint, error) {
+	return pathUint(r, "id")
+}
+
+// pathUint 解析路径中名为 name 的正整数
+func pathUint(r *http.Request, name string) (uint, error) {
+	n, err := strconv.ParseUint(r.PathValue(name), 10, 0)
+	if err != nil || n == 0 {
+		return 0, &ValidationError{Field: name, Message: "必须是正整数"}
+	}
+	return uint(n), nil
+}
+
+// queryUint 解析查询参数中的非负整数，参数不存在时返回 0
+func queryUint(r *http.Request, name string) (uint, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 0)
+	if err != nil {
+		return 0, &ValidationError{Field: name, Message: "必须是非负整数"}
+	}
+	return uint(n), nil
+}
+
+// pageParams 解析分页参数 page、size，超出范围的值由 dbutil.NormalizePage 修正
+func pageParams(r *http.Request) (page, size int, err error) {
+	p, err := queryUint(r, "page")
+	if err != nil {
+		return 0, 0, err
+	}
+	s, err := queryUint(r, "size")
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(p), int(s), nil
+}
+
+// prepareEmbeddedPosts 为嵌入了 Post 的结果（如 PopularPost）标记当前用户的点赞状态并处理内容格式，
+// posts 指向各结果中的 Post
+func prepareEmbeddedPosts(r *http.Request, db *gorm.DB, posts []*Post) error {
+	if viewer := viewerID(r.Context()); viewer != 0 {
+		items := make([]Post, len(posts))
+		for i, post := range posts {
+			items[i] = *post
+		}
+		if err := MarkLikedPosts(db, viewer, items); err != nil {
+			return err
+		}
+		for i, post := range posts {
+			post.LikedByMe = items[i].LikedByMe
+		}
+	}
+	return applyContentFormat(r, db, posts...)
+}
+
+// applyContentFormat 根据查询参数 format 决定返回的文章内容：
+// markdown（默认）只返回原文 content，html 同时返回渲染后的 content_html（见 RenderPostsHTML）
+func applyContentFormat(r *http.Request, db *gorm.DB, posts ...*Post) error {
+	switch r.URL.Query().Get("format") {
+	case "", ContentMarkdown:
+		for _, post := range posts {
+			post.ContentHTML = ""
+		}
+		return nil
+	case ContentHTML:
+		return RenderPostsHTML(db, posts...)
+	}
+	return &ValidationError{Field: "format", Message: "只能是 markdown 或 html"}
+}
+
+// applyCommentFormat 根据查询参数 format 决定是否返回评论渲染后的 content_html（见 RenderCommentsHTML），
+// 取值同 applyContentFormat；评论是纯文本，markdown 只返回原文 content
+func applyCommentFormat(r *http.Request, db *gorm.DB, comments []Comment) error {
+	switch r.URL.Query().Get("format") {
+	case "", ContentMarkdown:
+		return nil
+	case ContentHTML:
+		return RenderCommentsHTML(db, comments)
+	}
+	return &ValidationError{Field: "format", Message: "只能是 markdown 或 html"}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeError 把错误转换为状态码：校验失败 400，未登录或登录失败 401，没有权限 403，
+// 记录不存在 404，邮箱、slug 或标签名已被使用 409，请求已取消 499，其余 500
+// 500 只返回通用的提示，详细错误写入日志，避免把 SQL 等内部信息暴露给客户端
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *ValidationError
+	var rateLimitErr *RateLimitError
+	var spamErr *SpamError
+	switch {
+	case errors.As(err, &validationErr):
+		_ = writeJSON(w, http.StatusBadRequest, errorResponse{Error: validationErr.Message, Field: validationErr.Field})
+	case errors.As(err, &rateLimitErr):
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(rateLimitErr.RetryAfter)))
+		_ = writeJSON(w, http.StatusTooManyRequests, errorResponse{Error: err.Error()})
+	case errors.As(err, &spamErr):
+		_ = writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error(), Field: "content"})
+	case errors.Is(err, ErrInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="blog"`)
+		_ = writeJSON(w, http.StatusUnauthorized, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidCredentials):
+		_ = writeJSON(w, http.StatusUnauthorized, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrUserSuspended):
+		_ = writeJSON(w, http.StatusForbidden, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrEmailTaken):
+		_ = writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Field: "email"})
+	case errors.Is(err, ErrSlugTaken):
+		_ = writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Field: "slug"})
+	case errors.Is(err, ErrTagNameTaken):
+		_ = writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Field: "name"})
+	case errors.Is(err, ErrAlreadyReported):
+		_ = writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrPostNotFound), errors.Is(err, ErrCommentNotFound),
+		errors.Is(err, ErrCategoryNotFound), errors.Is(err, ErrTagNotFound), errors.Is(err, ErrAttachmentNotFound),
+		errors.Is(err, ErrNotificationNotFound), errors.Is(err, ErrReportNotFound):
+		_ = writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		_ = writeJSON(w, http.StatusNotFound, errorResponse{Error: "记录不存在"})
+	case errors.Is(err, context.Canceled):
+		// 客户端已经断开，响应不会被收到，nginx 的惯例用 499 表示
+		w.WriteHeader(499)
+	default:
+		log.Printf("%s %s 失败: %v", r.Method, r.URL.Path, err)
+		_ = writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "服务器内部错误"})
+	}
+}
+
+// runServer 在 addr 上启动 API 服务，收到 SIGINT/SIGTERM 后停止接收新连接，
+// 等待进行中的请求完成（最多 shutdownTimeout）后返回
+func runServer(db *gorm.DB, addr string, tokens *TokenIssuer, storage Storage) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := NewServer(db, tokens, storage)
+	go server.popular.Run(ctx)
+	publisher := NewScheduledPublisher(db, DefaultPublishInterval)
+	publisher.OnPublish = server.posts.InvalidateAll
+	go publisher.Run(ctx)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           server,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("博客 API 监听 %s", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("正在关闭服务，等待进行中的请求完成")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("关闭服务失败: %w", err)
+	}
+	return nil
+}
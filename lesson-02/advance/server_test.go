@@ -0,0 +1,2905 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/migrations"
+	"gohomeworklesson02/testutil"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB 创建独立的空 SQLite 数据库，测试结束后关闭
+func openTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := dbutil.Open(dbutil.Config{
+		DSN:    filepath.Join(t.TempDir(), "blog.db"),
+		SQLite: dbutil.SQLiteConfig{WAL: true, BusyTimeout: 5 * time.Second, ForeignKeys: true},
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+// newTestServer 创建使用独立 SQLite 数据库的 API 服务，表结构通过 blogMigrations 创建
+func newTestServer(t testing.TB) (*Server, *gorm.DB) {
+	t.Helper()
+	db := openTestDB(t)
+
+	runner, err := migrations.NewRunner(db, blogMigrations...)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	// 降低计算强度加快测试
+	cost := dbutil.PasswordCost
+	dbutil.PasswordCost = bcrypt.MinCost
+	t.Cleanup(func() { dbutil.PasswordCost = cost })
+	// 测试会连续发表很多相同的评论，不限制频率，需要的测试单独设置
+	antiSpam := CommentAntiSpam
+	CommentAntiSpam = AntiSpamRules{}
+	t.Cleanup(func() { CommentAntiSpam = antiSpam })
+
+	tokens, err := NewTokenIssuer([]byte("blog-api-test-jwt-secret-0123456"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewTokenIssuer: %v", err)
+	}
+	return NewServer(db, tokens, &LocalStorage{Root: t.TempDir()}), db
+}
+
+// register 注册用户并返回登录 token
+func register(t *testing.T, h http.Handler, name string) (string, *User) {
+	t.Helper()
+	var resp tokenResponse
+	req := registerRequest{Name: name, Email: strings.ToLower(name) + "@example.com", Password: "correct horse"}
+	if code := do(t, h, "POST", "/auth/register", "", req, &resp); code != http.StatusCreated {
+		t.Fatalf("注册 %s 返回 %d", name, code)
+	}
+	return resp.Token, resp.User
+}
+
+// do 发送请求，token 不为空时作为 Bearer token，body 不为 nil 时编码为 JSON，响应体解码到 dest（可以为 nil）
+func do(t *testing.T, h http.Handler, method, path, token string, body, dest interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if dest != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), dest); err != nil {
+			t.Fatalf("%s %s: 解析响应失败: %v\n%s", method, path, err, rec.Body.String())
+		}
+	}
+	return rec.Code
+}
+
+// TestServer 测试文章和评论的增删改查接口
+func TestServer(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, author := register(t, srv, "Alice")
+
+	tag := Tag{Name: "Go"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+
+	var post Post
+	t.Run("发布文章", func(t *testing.T) {
+		code := do(t, srv, "POST", "/posts", token, postRequest{
+			Title: " Hello ", Content: "world", TagIDs: []uint{tag.ID},
+		}, &post)
+		if code != http.StatusCreated {
+			t.Fatalf("状态码 %d，预期 201", code)
+		}
+		if post.ID == 0 || post.Title != "Hello" || post.User == nil || len(post.Tags) != 1 {
+			t.Errorf("返回的文章不正确: %+v", post)
+		}
+		if post.UserID != author.ID || post.CreatedBy != author.ID {
+			t.Errorf("作者 %d、CreatedBy %d，预期 %d", post.UserID, post.CreatedBy, author.ID)
+		}
+	})
+
+	t.Run("参数校验", func(t *testing.T) {
+		cases := []struct {
+			name, method, path string
+			body               interface{}
+			status             int
+			field              string
+		}{
+			{"标题为空", "POST", "/posts", postRequest{Title: " ", Content: "c"}, 400, "title"},
+			{"标题过长", "POST", "/posts", postRequest{Title: strings.Repeat("标", maxTitleLength+1), Content: "c"}, 400, "title"},
+			{"未知字段", "POST", "/posts", `{"title":"t","content":"c","user_id":1}`, 400, ""},
+			{"格式错误", "POST", "/posts", `{"title":`, 400, ""},
+			{"非法ID", "GET", "/posts/abc", nil, 400, "id"},
+			{"非法分页参数", "GET", "/posts?page=-1", nil, 400, "page"},
+			{"评论为空", "POST", "/posts/1/comments", commentRequest{}, 400, "content"},
+			{"文章不存在", "POST", "/posts/999/comments", commentRequest{Content: "c"}, 404, ""},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				var resp errorResponse
+				if code := do(t, srv, c.method, c.path, token, c.body, &resp); code != c.status {
+					t.Errorf("状态码 %d，预期 %d（%+v）", code, c.status, resp)
+				}
+				if resp.Error == "" || resp.Field != c.field {
+					t.Errorf("错误响应 %+v，预期字段 %q", resp, c.field)
+				}
+			})
+		}
+	})
+
+	t.Run("查询文章", func(t *testing.T) {
+		var got Post
+		if code := do(t, srv, "GET", "/posts/"+itoa(post.ID), "", nil, &got); code != 200 || got.Title != "Hello" {
+			t.Errorf("GET 返回 %d %+v", code, got)
+		}
+		var page dbutil.Page[Post]
+		if code := do(t, srv, "GET", "/posts?user_id="+itoa(author.ID), "", nil, &page); code != 200 || page.Total != 1 {
+			t.Errorf("列表返回 %d %+v", code, page)
+		}
+		if code := do(t, srv, "GET", "/posts?user_id=999", "", nil, &page); code != 200 || page.Total != 0 {
+			t.Errorf("其他作者的列表返回 %d %+v", code, page)
+		}
+	})
+
+	t.Run("评论", func(t *testing.T) {
+		path := "/posts/" + itoa(post.ID) + "/comments"
+		var comment Comment
+		code := do(t, srv, "POST", path, token, commentRequest{Content: "nice"}, &comment)
+		if code != http.StatusCreated || comment.ID == 0 || comment.UserID != author.ID {
+			t.Fatalf("发表评论返回 %d %+v", code, comment)
+		}
+		if code := do(t, srv, "PUT", "/comments/"+itoa(comment.ID), token, commentRequest{Content: "great"}, &comment); code != 200 || comment.Content != "great" {
+			t.Errorf("修改评论返回 %d %+v", code, comment)
+		}
+
+		var page dbutil.Page[Comment]
+		if code := do(t, srv, "GET", path, "", nil, &page); code != 200 || page.Total != 1 {
+			t.Errorf("评论列表返回 %d %+v", code, page)
+		}
+		if code := do(t, srv, "DELETE", "/comments/"+itoa(comment.ID), token, nil, nil); code != http.StatusNoContent {
+			t.Errorf("删除评论返回 %d", code)
+		}
+		if code := do(t, srv, "DELETE", "/comments/"+itoa(comment.ID), token, nil, nil); code != http.StatusNotFound {
+			t.Errorf("重复删除评论返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "GET", "/posts/999/comments", "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("不存在的文章的评论返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("评论分页", func(t *testing.T) {
+		other, comments := newPostWithComments(t, db, 12)
+		var page dbutil.Page[Comment]
+		path := "/posts/" + itoa(other.ID) + "/comments?page=2&size=5"
+		if code := do(t, srv, "GET", path, "", nil, &page); code != 200 || page.Total != 12 || len(page.Items) != 5 {
+			t.Fatalf("评论列表返回 %d，共 %d 条，本页 %d 条", code, page.Total, len(page.Items))
+		}
+		for _, c := range page.Items {
+			if c.PostID != other.ID || c.UserID != comments[0].UserID {
+				t.Errorf("评论 %+v 不属于文章 %d", c, other.ID)
+			}
+		}
+	})
+
+	t.Run("修改和删除文章", func(t *testing.T) {
+		path := "/posts/" + itoa(post.ID)
+		var got Post
+		if code := do(t, srv, "PUT", path, token, postRequest{Title: "Hi", Content: "updated"}, &got); code != 200 || got.Content != "updated" {
+			t.Errorf("修改文章返回 %d %+v", code, got)
+		}
+		if code := do(t, srv, "DELETE", path, token, nil, nil); code != http.StatusNoContent {
+			t.Errorf("删除文章返回 %d", code)
+		}
+		if code := do(t, srv, "GET", path, "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("删除后查询返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "PUT", path, token, postRequest{Title: "Hi", Content: "again"}, nil); code != http.StatusNotFound {
+			t.Errorf("修改已删除的文章返回 %d，预期 404", code)
+		}
+
+		var user User
+		if err := db.First(&user, author.ID).Error; err != nil || user.PostCount != 0 {
+			t.Errorf("删除后文章数量 %d，预期 0（%v）", user.PostCount, err)
+		}
+	})
+}
+
+// TestServerAuth 测试注册、登录和资源所有权检查
+func TestServerAuth(t *testing.T) {
+	srv, _ := newTestServer(t)
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+
+	t.Run("注册", func(t *testing.T) {
+		var resp errorResponse
+		dup := registerRequest{Name: "Alice2", Email: " ALICE@example.com", Password: "another horse"}
+		if code := do(t, srv, "POST", "/auth/register", "", dup, &resp); code != http.StatusConflict || resp.Field != "email" {
+			t.Errorf("重复邮箱返回 %d %+v，预期 409", code, resp)
+		}
+		short := registerRequest{Name: "Carol", Email: "carol@example.com", Password: "short"}
+		if code := do(t, srv, "POST", "/auth/register", "", short, &resp); code != http.StatusBadRequest || resp.Field != "password" {
+			t.Errorf("过短的密码返回 %d %+v，预期 400", code, resp)
+		}
+	})
+
+	t.Run("登录", func(t *testing.T) {
+		var resp tokenResponse
+		code := do(t, srv, "POST", "/auth/login", "", loginRequest{Email: "Alice@Example.com", Password: "correct horse"}, &resp)
+		if code != http.StatusOK || resp.Token == "" || resp.User.ID != alice.ID {
+			t.Fatalf("登录返回 %d %+v", code, resp)
+		}
+		var me meResponse
+		if code := do(t, srv, "GET", "/me", resp.Token, nil, &me); code != http.StatusOK || me.User == nil || me.ID != alice.ID || me.Email != "alice@example.com" {
+			t.Errorf("/me 返回 %d %+v", code, me)
+		}
+		// 只有 /me 返回邮箱，登录等其他接口中的用户信息都不包含
+		var raw json.RawMessage
+		do(t, srv, "POST", "/auth/login", "", loginRequest{Email: "alice@example.com", Password: "correct horse"}, &raw)
+		if strings.Contains(string(raw), "alice@example.com") {
+			t.Errorf("登录返回的用户信息不应包含邮箱: %s", raw)
+		}
+
+		for _, req := range []loginRequest{
+			{Email: "alice@example.com", Password: "wrong horse"},
+			{Email: "nobody@example.com", Password: "correct horse"},
+		} {
+			if code := do(t, srv, "POST", "/auth/login", "", req, nil); code != http.StatusUnauthorized {
+				t.Errorf("登录 %+v 返回 %d，预期 401", req, code)
+			}
+		}
+	})
+
+	t.Run("未登录", func(t *testing.T) {
+		for _, token := range []string{"", "not-a-token", aliceToken + "x"} {
+			if code := do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, nil); code != http.StatusUnauthorized {
+				t.Errorf("token %q 返回 %d，预期 401", token, code)
+			}
+		}
+	})
+
+	t.Run("只能修改自己的文章和评论", func(t *testing.T) {
+		var post Post
+		if code := do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "mine", Content: "c"}, &post); code != http.StatusCreated {
+			t.Fatalf("发布文章返回 %d", code)
+		}
+		var comment Comment
+		if code := do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments", aliceToken, commentRequest{Content: "c"}, &comment); code != http.StatusCreated {
+			t.Fatalf("发表评论返回 %d", code)
+		}
+
+		path := "/posts/" + itoa(post.ID)
+		if code := do(t, srv, "PUT", path, bobToken, postRequest{Title: "hacked", Content: "c"}, nil); code != http.StatusForbidden {
+			t.Errorf("修改他人文章返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "DELETE", path, bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("删除他人文章返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "PUT", "/comments/"+itoa(comment.ID), bobToken, commentRequest{Content: "hacked"}, nil); code != http.StatusForbidden {
+			t.Errorf("修改他人评论返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "DELETE", "/comments/"+itoa(comment.ID), bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("删除他人评论返回 %d，预期 403", code)
+		}
+
+		var got Post
+		if code := do(t, srv, "GET", path, "", nil, &got); code != http.StatusOK || got.Title != "mine" {
+			t.Errorf("文章被其他用户修改: %d %+v", code, got)
+		}
+	})
+}
+
+// TestServerCommentTree 测试回复评论和按深度加载评论树
+func TestServerCommentTree(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+
+	var post Post
+	if code := do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, &post); code != http.StatusCreated {
+		t.Fatalf("发布文章返回 %d", code)
+	}
+	comment := func(path, content string) Comment {
+		t.Helper()
+		var c Comment
+		if code := do(t, srv, "POST", path, token, commentRequest{Content: content}, &c); code != http.StatusCreated {
+			t.Fatalf("POST %s 返回 %d", path, code)
+		}
+		return c
+	}
+
+	// c1 <- r1 <- r2 <- r3，c1 <- r1b，c2 没有回复
+	c1 := comment("/posts/"+itoa(post.ID)+"/comments", "c1")
+	comment("/posts/"+itoa(post.ID)+"/comments", "c2")
+	r1 := comment("/comments/"+itoa(c1.ID)+"/replies", "r1")
+	comment("/comments/"+itoa(c1.ID)+"/replies", "r1b")
+	r2 := comment("/comments/"+itoa(r1.ID)+"/replies", "r2")
+	r3 := comment("/comments/"+itoa(r2.ID)+"/replies", "r3")
+	if r3.PostID != post.ID || r3.ParentID == nil || *r3.ParentID != r2.ID {
+		t.Errorf("回复应属于同一篇文章并指向被回复的评论: %+v", r3)
+	}
+
+	tree := func(path string) dbutil.Page[Comment] {
+		t.Helper()
+		var page dbutil.Page[Comment]
+		if code := do(t, srv, "GET", path, "", nil, &page); code != http.StatusOK {
+			t.Fatalf("GET %s 返回 %d", path, code)
+		}
+		return page
+	}
+
+	t.Run("默认深度", func(t *testing.T) {
+		page := tree("/posts/" + itoa(post.ID) + "/comments")
+		if page.Total != 2 || len(page.Items) != 2 {
+			t.Fatalf("预期 2 条顶层评论，实际 %d", page.Total)
+		}
+		top := page.Items[0]
+		if top.Content != "c1" || top.ReplyCount != 2 || len(top.Replies) != 2 {
+			t.Fatalf("c1 的回复不正确: %+v", top)
+		}
+		second := top.Replies[0]
+		if second.Content != "r1" || second.User == nil || len(second.Replies) != 1 {
+			t.Fatalf("r1 的回复不正确: %+v", second)
+		}
+		// 第 3 层只返回回复数量，不再加载下一层
+		third := second.Replies[0]
+		if third.Content != "r2" || third.ReplyCount != 1 || len(third.Replies) != 0 {
+			t.Errorf("r2 预期有 1 条未加载的回复: %+v", third)
+		}
+		if page.Items[1].ReplyCount != 0 {
+			t.Errorf("c2 没有回复，实际 %d", page.Items[1].ReplyCount)
+		}
+	})
+
+	t.Run("指定深度", func(t *testing.T) {
+		page := tree("/posts/" + itoa(post.ID) + "/comments?depth=1")
+		if top := page.Items[0]; top.ReplyCount != 2 || len(top.Replies) != 0 {
+			t.Errorf("depth=1 只返回顶层评论: %+v", top)
+		}
+		page = tree("/posts/" + itoa(post.ID) + "/comments?depth=10")
+		if got := page.Items[0].Replies[0].Replies[0].Replies; len(got) != 1 || got[0].ID != r3.ID {
+			t.Errorf("depth=10 预期加载到 r3，实际 %+v", got)
+		}
+	})
+
+	t.Run("加载更深的回复", func(t *testing.T) {
+		page := tree("/comments/" + itoa(r2.ID) + "/replies")
+		if page.Total != 1 || page.Items[0].ID != r3.ID {
+			t.Errorf("r2 的回复预期为 r3，实际 %+v", page)
+		}
+		if code := do(t, srv, "GET", "/comments/999/replies", "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("不存在的评论的回复返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "POST", "/comments/999/replies", token, commentRequest{Content: "c"}, nil); code != http.StatusNotFound {
+			t.Errorf("回复不存在的评论返回 %d，预期 404", code)
+		}
+	})
+}
+
+// TestServerLikes 测试点赞、取消点赞和 liked_by_me 标记
+func TestServerLikes(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+
+	var post Post
+	if code := do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c"}, &post); code != http.StatusCreated {
+		t.Fatalf("发布文章返回 %d", code)
+	}
+	var comment Comment
+	if code := do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments", aliceToken, commentRequest{Content: "c"}, &comment); code != http.StatusCreated {
+		t.Fatalf("发表评论返回 %d", code)
+	}
+	postLike := "/posts/" + itoa(post.ID) + "/like"
+	commentLike := "/comments/" + itoa(comment.ID) + "/like"
+
+	like := func(method, path, token string, want uint) {
+		t.Helper()
+		var resp likeResponse
+		if code := do(t, srv, method, path, token, nil, &resp); code != http.StatusOK || resp.LikeCount != want {
+			t.Errorf("%s %s 返回 %d %+v，预期点赞数 %d", method, path, code, resp, want)
+		}
+	}
+
+	t.Run("点赞", func(t *testing.T) {
+		like("PUT", postLike, bobToken, 1)
+		like("PUT", postLike, bobToken, 1) // 重复点赞不计数
+		like("PUT", postLike, aliceToken, 2)
+		like("PUT", commentLike, bobToken, 1)
+
+		var likes int64
+		db.Model(&Like{}).Where("target_type = ? AND target_id = ?", LikeTargetPost, post.ID).Count(&likes)
+		if likes != 2 {
+			t.Errorf("预期 2 条点赞记录，实际 %d", likes)
+		}
+		if code := do(t, srv, "PUT", "/posts/999/like", bobToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("点赞不存在的文章返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "PUT", postLike, "", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("未登录点赞返回 %d，预期 401", code)
+		}
+	})
+
+	t.Run("liked_by_me", func(t *testing.T) {
+		var got Post
+		do(t, srv, "GET", "/posts/"+itoa(post.ID), bobToken, nil, &got)
+		if !got.LikedByMe || got.LikeCount != 2 {
+			t.Errorf("Bob 查看文章: %+v", got)
+		}
+		do(t, srv, "GET", "/posts/"+itoa(post.ID), "", nil, &got)
+		if got.LikedByMe {
+			t.Errorf("未登录时 liked_by_me 应为 false")
+		}
+
+		var comments dbutil.Page[Comment]
+		do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments", bobToken, nil, &comments)
+		if len(comments.Items) != 1 || !comments.Items[0].LikedByMe || comments.Items[0].LikeCount != 1 {
+			t.Errorf("Bob 查看评论: %+v", comments.Items)
+		}
+		do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments", aliceToken, nil, &comments)
+		if comments.Items[0].LikedByMe {
+			t.Errorf("Alice 没有点赞评论，liked_by_me 应为 false")
+		}
+
+		if code := do(t, srv, "GET", "/posts", "bad-token", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("携带无效 token 返回 %d，预期 401", code)
+		}
+	})
+
+	t.Run("取消点赞", func(t *testing.T) {
+		like("DELETE", postLike, bobToken, 1)
+		like("DELETE", postLike, bobToken, 1) // 重复取消不计数
+		like("DELETE", commentLike, aliceToken, 1)
+
+		var posts dbutil.Page[Post]
+		do(t, srv, "GET", "/posts", bobToken, nil, &posts)
+		if len(posts.Items) != 1 || posts.Items[0].LikedByMe || posts.Items[0].LikeCount != 1 {
+			t.Errorf("取消点赞后: %+v", posts.Items)
+		}
+	})
+}
+
+// TestServerCategories 测试分类树和按分类（含下级分类）查询文章
+func TestServerCategories(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+
+	category := func(name, slug string, parentID *uint) Category {
+		t.Helper()
+		var c Category
+		req := categoryRequest{Name: name, Slug: slug, ParentID: parentID}
+		if code := do(t, srv, "POST", "/categories", token, req, &c); code != http.StatusCreated {
+			t.Fatalf("创建分类 %s 返回 %d", slug, code)
+		}
+		return c
+	}
+	// tech -> go -> gorm，life 与 tech 平级
+	tech := category("技术", "tech", nil)
+	golang := category("Go", "go", &tech.ID)
+	gormCategory := category("GORM", "gorm", &golang.ID)
+	life := category("生活", "life", nil)
+
+	publish := func(title string, categoryID *uint) Post {
+		t.Helper()
+		var p Post
+		if code := do(t, srv, "POST", "/posts", token, postRequest{Title: title, Content: "c", CategoryID: categoryID}, &p); code != http.StatusCreated {
+			t.Fatalf("发布文章 %s 返回 %d", title, code)
+		}
+		return p
+	}
+	first := publish("tech", &tech.ID)
+	publish("gorm", &gormCategory.ID)
+	publish("life", &life.ID)
+	publish("none", nil)
+	if first.Category == nil || first.Category.Slug != "tech" {
+		t.Errorf("文章应包含分类: %+v", first.Category)
+	}
+
+	t.Run("分类树", func(t *testing.T) {
+		var tree []Category
+		if code := do(t, srv, "GET", "/categories", "", nil, &tree); code != http.StatusOK {
+			t.Fatalf("分类树返回 %d", code)
+		}
+		// 顶级分类按名称排序：技术、生活
+		if len(tree) != 2 || tree[0].Slug != "tech" || tree[1].Slug != "life" {
+			t.Fatalf("顶级分类不正确: %+v", tree)
+		}
+		if children := tree[0].Children; len(children) != 1 || children[0].Slug != "go" ||
+			len(children[0].Children) != 1 || children[0].Children[0].Slug != "gorm" {
+			t.Errorf("tech 的下级分类不正确: %+v", children)
+		}
+	})
+
+	t.Run("按分类查询文章", func(t *testing.T) {
+		titles := func(path string) string {
+			t.Helper()
+			var page dbutil.Page[Post]
+			if code := do(t, srv, "GET", path, "", nil, &page); code != http.StatusOK {
+				t.Fatalf("GET %s 返回 %d", path, code)
+			}
+			result := make([]string, len(page.Items))
+			for i, p := range page.Items {
+				result[i] = p.Title
+			}
+			return strings.Join(result, ",")
+		}
+		if got := titles("/categories/tech/posts"); got != "gorm,tech" {
+			t.Errorf("tech 及下级分类的文章 = [%s]，预期 [gorm,tech]", got)
+		}
+		if got := titles("/categories/tech/posts?include_descendants=false"); got != "tech" {
+			t.Errorf("只查 tech 分类的文章 = [%s]，预期 [tech]", got)
+		}
+		if got := titles("/categories/go/posts"); got != "gorm" {
+			t.Errorf("go 及下级分类的文章 = [%s]，预期 [gorm]", got)
+		}
+		if code := do(t, srv, "GET", "/categories/missing/posts", "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("不存在的分类返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("校验", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			req    interface{}
+			status int
+		}{
+			{"slug 格式错误", categoryRequest{Name: "x", Slug: "Not Valid"}, 400},
+			{"slug 重复", categoryRequest{Name: "x", Slug: "tech"}, 409},
+			{"上级分类不存在", categoryRequest{Name: "x", Slug: "x", ParentID: new(uint)}, 404},
+		}
+		for _, c := range cases {
+			if code := do(t, srv, "POST", "/categories", token, c.req, nil); code != c.status {
+				t.Errorf("%s: 返回 %d，预期 %d", c.name, code, c.status)
+			}
+		}
+		missing := uint(999)
+		if code := do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c", CategoryID: &missing}, nil); code != http.StatusNotFound {
+			t.Errorf("分类不存在时发布文章返回 %d，预期 404", code)
+		}
+
+		// 修改文章时不传分类会清除分类
+		var updated Post
+		do(t, srv, "PUT", "/posts/"+itoa(first.ID), token, postRequest{Title: "tech", Content: "c"}, &updated)
+		if updated.CategoryID != nil || updated.Category != nil {
+			t.Errorf("预期清除分类，实际 %+v", updated.CategoryID)
+		}
+	})
+}
+
+// TestTokenIssuer 测试 token 的签名和过期校验
+func TestTokenIssuer(t *testing.T) {
+	if _, err := NewTokenIssuer([]byte("short"), 0); err == nil {
+		t.Errorf("预期过短的密钥被拒绝")
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := &TokenIssuer{Secret: []byte("token-issuer-test-secret-0123456"), TTL: time.Hour, now: func() time.Time { return now }}
+
+	token, expiresAt, err := issuer.Issue(42)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("过期时间 %v，预期 %v", expiresAt, now.Add(time.Hour))
+	}
+	if id, err := issuer.Parse(token); err != nil || id != 42 {
+		t.Errorf("Parse = %d, %v，预期 42", id, err)
+	}
+
+	other := &TokenIssuer{Secret: []byte("another-issuer-test-secret-01234")}
+	forged, _, _ := other.Issue(42)
+	parts := strings.Split(token, ".")
+	unsigned := `{"alg":"none","typ":"JWT"}`
+	for name, bad := range map[string]string{
+		"其他密钥签名":   forged,
+		"alg none": base64.RawURLEncoding.EncodeToString([]byte(unsigned)) + "." + parts[1] + ".",
+		"格式错误":     "a.b",
+	} {
+		if _, err := issuer.Parse(bad); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: 预期 ErrInvalidToken，实际 %v", name, err)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := issuer.Parse(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("过期的 token 预期 ErrInvalidToken，实际 %v", err)
+	}
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// TestServerPostCursor 测试文章的游标分页：逐页翻完不重复不遗漏，游标被篡改时返回 400
+func TestServerPostCursor(t *testing.T) {
+	srv, db := newTestServer(t)
+	_, alice := register(t, srv, "Alice")
+	_, bob := register(t, srv, "Bob")
+
+	// 部分文章的发布时间相同，翻页依赖 id 区分
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		post := Post{Title: "post " + itoa(uint(i)), Content: "c", UserID: alice.ID, CreatedAt: base.Add(time.Duration(i/2) * time.Minute)}
+		if i == 6 {
+			post.UserID = bob.ID
+		}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+
+	list := func(t *testing.T, query string) []Post {
+		t.Helper()
+		var posts []Post
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("翻页没有结束")
+			}
+			var resp cursorPageResponse[Post]
+			path := "/posts?size=3&cursor=" + cursor + query
+			if code := do(t, srv, "GET", path, "", nil, &resp); code != http.StatusOK {
+				t.Fatalf("GET %s 返回 %d", path, code)
+			}
+			posts = append(posts, resp.Items...)
+			if resp.NextCursor == "" {
+				return posts
+			}
+			cursor = resp.NextCursor
+		}
+	}
+
+	t.Run("全部文章", func(t *testing.T) {
+		posts := list(t, "")
+		if len(posts) != 7 {
+			t.Fatalf("共返回 %d 篇文章，预期 7", len(posts))
+		}
+		for i := 1; i < len(posts); i++ {
+			prev, cur := posts[i-1], posts[i]
+			if cur.PublishedAt.After(prev.PublishedAt) || (cur.PublishedAt.Equal(prev.PublishedAt) && cur.ID >= prev.ID) {
+				t.Errorf("第 %d 篇文章顺序错误: %d 在 %d 之后", i, cur.ID, prev.ID)
+			}
+		}
+	})
+
+	t.Run("按作者过滤", func(t *testing.T) {
+		var first cursorPageResponse[Post]
+		do(t, srv, "GET", "/posts?size=3&cursor=&user_id="+itoa(alice.ID), "", nil, &first)
+		if first.Total != 6 || len(first.Items) != 3 || first.NextCursor == "" {
+			t.Errorf("第一页 total=%d items=%d next=%q", first.Total, len(first.Items), first.NextCursor)
+		}
+		if posts := list(t, "&user_id="+itoa(alice.ID)); len(posts) != 6 {
+			t.Errorf("共返回 %d 篇文章，预期 6", len(posts))
+		}
+	})
+
+	t.Run("无效游标", func(t *testing.T) {
+		var first cursorPageResponse[Post]
+		do(t, srv, "GET", "/posts?size=3&cursor=", "", nil, &first)
+		payload, sig, _ := strings.Cut(first.NextCursor, ".")
+		data, _ := base64.RawURLEncoding.DecodeString(payload)
+		forged := base64.RawURLEncoding.EncodeToString(bytes.Replace(data, []byte(`"id":`), []byte(`"id":1`), 1)) + "." + sig
+
+		for _, cursor := range []string{"garbage", forged} {
+			var resp errorResponse
+			if code := do(t, srv, "GET", "/posts?cursor="+cursor, "", nil, &resp); code != http.StatusBadRequest || resp.Field != "cursor" {
+				t.Errorf("cursor=%s 返回 %d %+v，预期 400", cursor, code, resp)
+			}
+		}
+	})
+
+	t.Run("不带 cursor 时使用偏移分页", func(t *testing.T) {
+		var resp dbutil.Page[Post]
+		do(t, srv, "GET", "/posts?size=3&page=3", "", nil, &resp)
+		if resp.Total != 7 || resp.TotalPages != 3 || len(resp.Items) != 1 {
+			t.Errorf("偏移分页结果不正确: total=%d pages=%d items=%d", resp.Total, resp.TotalPages, len(resp.Items))
+		}
+	})
+
+	t.Run("按发布时间而不是创建时间排序", func(t *testing.T) {
+		// 很早之前创建的草稿，最近才发布
+		late := Post{Title: "late", Content: "c", UserID: bob.ID, Status: PostPublished,
+			CreatedAt: base.Add(-24 * time.Hour), PublishedAt: base.Add(time.Hour)}
+		if err := db.Create(&late).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+		srv.posts.InvalidateAll()
+
+		if posts := list(t, ""); len(posts) != 8 || posts[0].ID != late.ID {
+			t.Errorf("游标分页第一篇应为最近发布的文章 %d，实际 %+v", late.ID, posts)
+		}
+		var page dbutil.Page[Post]
+		do(t, srv, "GET", "/posts?size=3", "", nil, &page)
+		if len(page.Items) == 0 || page.Items[0].ID != late.ID {
+			t.Errorf("偏移分页第一篇应为最近发布的文章 %d，实际 %+v", late.ID, page.Items)
+		}
+	})
+}
+
+// TestServerModeration 测试评论审核：待审核的评论不公开，审核员通过或拒绝后生效
+func TestServerModeration(t *testing.T) {
+	srv, db := newTestServer(t)
+	rules := CommentModeration
+	CommentModeration = ModerationRules{RequireApproval: true, TrustedAfter: 1, BlockedWords: []string{"spam"}}
+	t.Cleanup(func() { CommentModeration = rules })
+
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+	modToken, moderator := register(t, srv, "Mod")
+	if err := db.Model(moderator).Update("role", RoleModerator).Error; err != nil {
+		t.Fatalf("设置审核员: %v", err)
+	}
+
+	var post Post
+	if code := do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c"}, &post); code != http.StatusCreated {
+		t.Fatalf("发布文章返回 %d", code)
+	}
+	commentsPath := "/posts/" + itoa(post.ID) + "/comments"
+	publicComments := func(t *testing.T) dbutil.Page[Comment] {
+		t.Helper()
+		var page dbutil.Page[Comment]
+		if code := do(t, srv, "GET", commentsPath, "", nil, &page); code != http.StatusOK {
+			t.Fatalf("GET %s 返回 %d", commentsPath, code)
+		}
+		return page
+	}
+	moderate := func(t *testing.T, token string, id uint, req moderationRequest, dest interface{}) int {
+		t.Helper()
+		return do(t, srv, "PUT", "/comments/"+itoa(id)+"/moderation", token, req, dest)
+	}
+
+	var pending Comment
+	t.Run("新评论进入待审核", func(t *testing.T) {
+		if code := do(t, srv, "POST", commentsPath, bobToken, commentRequest{Content: "first"}, &pending); code != http.StatusCreated {
+			t.Fatalf("发表评论返回 %d", code)
+		}
+		if pending.Status != CommentPending || pending.ModerationReason == "" {
+			t.Errorf("状态 %q 原因 %q，预期待审核", pending.Status, pending.ModerationReason)
+		}
+		if page := publicComments(t); page.Total != 0 {
+			t.Errorf("待审核的评论不应公开，返回 %d 条", page.Total)
+		}
+		if code := do(t, srv, "POST", "/comments/"+itoa(pending.ID)+"/replies", aliceToken, commentRequest{Content: "r"}, nil); code != http.StatusNotFound {
+			t.Errorf("回复待审核的评论返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("只有审核员可以审核", func(t *testing.T) {
+		if code := do(t, srv, "GET", "/moderation/comments", bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("普通用户查询审核队列返回 %d，预期 403", code)
+		}
+		if code := moderate(t, bobToken, pending.ID, moderationRequest{Status: CommentApproved}, nil); code != http.StatusForbidden {
+			t.Errorf("普通用户审核返回 %d，预期 403", code)
+		}
+		var queue dbutil.Page[Comment]
+		if code := do(t, srv, "GET", "/moderation/comments", modToken, nil, &queue); code != http.StatusOK || queue.Total != 1 {
+			t.Errorf("审核队列返回 %d，共 %d 条，预期 1 条", code, queue.Total)
+		}
+	})
+
+	t.Run("参数校验", func(t *testing.T) {
+		cases := []struct {
+			req   moderationRequest
+			field string
+		}{
+			{moderationRequest{Status: "deleted"}, "status"},
+			{moderationRequest{Status: CommentRejected, Reason: " "}, "reason"},
+		}
+		for _, c := range cases {
+			var resp errorResponse
+			if code := moderate(t, modToken, pending.ID, c.req, &resp); code != http.StatusBadRequest || resp.Field != c.field {
+				t.Errorf("%+v 返回 %d %+v，预期 400 %s", c.req, code, resp, c.field)
+			}
+		}
+		if code := moderate(t, modToken, 9999, moderationRequest{Status: CommentApproved}, nil); code != http.StatusNotFound {
+			t.Errorf("审核不存在的评论返回 %d，预期 404", code)
+		}
+	})
+
+	var reply Comment
+	t.Run("通过后公开", func(t *testing.T) {
+		var approved Comment
+		if code := moderate(t, modToken, pending.ID, moderationRequest{Status: CommentApproved}, &approved); code != http.StatusOK {
+			t.Fatalf("审核返回 %d", code)
+		}
+		if approved.Status != CommentApproved || approved.ModeratedBy == nil || *approved.ModeratedBy != moderator.ID {
+			t.Errorf("审核结果不正确: %+v", approved)
+		}
+		if page := publicComments(t); page.Total != 1 {
+			t.Errorf("通过后返回 %d 条评论，预期 1", page.Total)
+		}
+
+		// Bob 已有一条通过的评论，之后的评论不需要人工审核
+		if code := do(t, srv, "POST", "/comments/"+itoa(pending.ID)+"/replies", bobToken, commentRequest{Content: "reply"}, &reply); code != http.StatusCreated {
+			t.Fatalf("回复返回 %d", code)
+		}
+		if reply.Status != CommentApproved {
+			t.Errorf("受信任用户的回复状态 %q，预期 approved", reply.Status)
+		}
+	})
+
+	t.Run("屏蔽词", func(t *testing.T) {
+		var spam Comment
+		do(t, srv, "POST", commentsPath, bobToken, commentRequest{Content: "buy SPAM now"}, &spam)
+		if spam.Status != CommentPending {
+			t.Errorf("包含屏蔽词的评论状态 %q，预期 pending", spam.Status)
+		}
+		var edited Comment
+		do(t, srv, "PUT", "/comments/"+itoa(reply.ID), bobToken, commentRequest{Content: "spam"}, &edited)
+		if edited.Status != CommentPending {
+			t.Errorf("修改为包含屏蔽词后状态 %q，预期 pending", edited.Status)
+		}
+		do(t, srv, "PUT", "/comments/"+itoa(reply.ID), bobToken, commentRequest{Content: "reply"}, &edited)
+		if edited.Status != CommentApproved {
+			t.Errorf("改回后状态 %q，预期 approved", edited.Status)
+		}
+	})
+
+	t.Run("拒绝后不再公开", func(t *testing.T) {
+		var rejected Comment
+		req := moderationRequest{Status: CommentRejected, Reason: "离题"}
+		if code := moderate(t, modToken, pending.ID, req, &rejected); code != http.StatusOK {
+			t.Fatalf("审核返回 %d", code)
+		}
+		if rejected.ModerationReason != "离题" {
+			t.Errorf("拒绝理由 %q", rejected.ModerationReason)
+		}
+		if page := publicComments(t); page.Total != 0 {
+			t.Errorf("拒绝后返回 %d 条评论，预期 0", page.Total)
+		}
+		if code := do(t, srv, "GET", "/comments/"+itoa(pending.ID)+"/replies", "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("查询被拒绝评论的回复返回 %d，预期 404", code)
+		}
+
+		counted, err := GetPostsWithCommentCount(db, 1, 10)
+		if err != nil {
+			t.Fatalf("GetPostsWithCommentCount: %v", err)
+		}
+		// 只剩下已通过的回复
+		if len(counted.Items) != 1 || counted.Items[0].CommentCount != 1 {
+			t.Errorf("评论数量不正确: %+v", counted.Items)
+		}
+	})
+}
+
+// TestLoadModerationRules 测试从环境变量读取审核规则
+func TestLoadModerationRules(t *testing.T) {
+	t.Setenv(ModerationRequireApprovalEnv, "true")
+	t.Setenv(ModerationBlockedWordsEnv, " spam, ,ads ")
+	rules, err := LoadModerationRules(ModerationRules{MaxLinks: 3, TrustedAfter: 5})
+	if err != nil {
+		t.Fatalf("LoadModerationRules: %v", err)
+	}
+	if !rules.RequireApproval || rules.MaxLinks != 3 || rules.TrustedAfter != 5 ||
+		len(rules.BlockedWords) != 2 || rules.BlockedWords[1] != "ads" {
+		t.Errorf("规则不正确: %+v", rules)
+	}
+
+	t.Setenv(ModerationMaxLinksEnv, "-1")
+	if _, err := LoadModerationRules(ModerationRules{}); err == nil {
+		t.Error("MaxLinks 为负数时应返回错误")
+	}
+}
+
+// TestServerTags 测试标签的重命名、合并和清理
+func TestServerTags(t *testing.T) {
+	srv, db := newTestServer(t)
+	adminToken, admin := register(t, srv, "Admin")
+	if err := db.Model(admin).Update("role", RoleAdmin).Error; err != nil {
+		t.Fatalf("设置管理员: %v", err)
+	}
+	bobToken, bob := register(t, srv, "Bob")
+
+	tags := []Tag{{Name: "go"}, {Name: "golang"}, {Name: "db"}, {Name: "orphan"}}
+	if err := db.Create(&tags).Error; err != nil {
+		t.Fatalf("create tags: %v", err)
+	}
+	goTag, golang, dbTag, orphan := tags[0], tags[1], tags[2], tags[3]
+	publish := func(tagIDs ...uint) Post {
+		t.Helper()
+		post := Post{Title: "t", Content: "c", UserID: bob.ID}
+		if err := PublishPostWithTags(db, &post, tagIDs); err != nil {
+			t.Fatalf("PublishPostWithTags: %v", err)
+		}
+		return post
+	}
+	both := publish(goTag.ID, golang.ID)
+	publish(golang.ID)
+	if err := DeletePost(db, publish(dbTag.ID).ID); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+
+	postCounts := func(t *testing.T) map[string]int64 {
+		t.Helper()
+		var list []TagWithCount
+		if code := do(t, srv, "GET", "/tags", "", nil, &list); code != http.StatusOK {
+			t.Fatalf("GET /tags 返回 %d", code)
+		}
+		counts := make(map[string]int64, len(list))
+		for _, tag := range list {
+			counts[tag.Name] = tag.PostCount
+		}
+		return counts
+	}
+
+	t.Run("文章数量", func(t *testing.T) {
+		counts := postCounts(t)
+		if len(counts) != 4 || counts["go"] != 1 || counts["golang"] != 2 || counts["db"] != 0 {
+			t.Errorf("文章数量不正确: %v", counts)
+		}
+	})
+
+	t.Run("只有管理员可以管理标签", func(t *testing.T) {
+		if code := do(t, srv, "PUT", "/tags/"+itoa(goTag.ID), bobToken, renameTagRequest{Name: "x"}, nil); code != http.StatusForbidden {
+			t.Errorf("普通用户重命名返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "DELETE", "/tags/orphans", bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("普通用户清理标签返回 %d，预期 403", code)
+		}
+	})
+
+	t.Run("重命名", func(t *testing.T) {
+		var renamed Tag
+		if code := do(t, srv, "PUT", "/tags/"+itoa(goTag.ID), adminToken, renameTagRequest{Name: " Go "}, &renamed); code != http.StatusOK || renamed.Name != "Go" {
+			t.Errorf("重命名返回 %d %+v", code, renamed)
+		}
+		var resp errorResponse
+		if code := do(t, srv, "PUT", "/tags/"+itoa(goTag.ID), adminToken, renameTagRequest{Name: "golang"}, &resp); code != http.StatusConflict || resp.Field != "name" {
+			t.Errorf("重命名为已有的标签名返回 %d %+v，预期 409", code, resp)
+		}
+		if code := do(t, srv, "PUT", "/tags/9999", adminToken, renameTagRequest{Name: "x"}, nil); code != http.StatusNotFound {
+			t.Errorf("重命名不存在的标签返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("合并", func(t *testing.T) {
+		mergePath := "/tags/" + itoa(golang.ID) + "/merge"
+		for _, into := range []uint{golang.ID, 0} {
+			if code := do(t, srv, "POST", mergePath, adminToken, mergeTagsRequest{Into: into}, nil); code != http.StatusBadRequest {
+				t.Errorf("合并到 %d 返回 %d，预期 400", into, code)
+			}
+		}
+		if code := do(t, srv, "POST", mergePath, adminToken, mergeTagsRequest{Into: 9999}, nil); code != http.StatusNotFound {
+			t.Errorf("合并到不存在的标签返回 %d，预期 404", code)
+		}
+
+		var merged TagWithCount
+		if code := do(t, srv, "POST", mergePath, adminToken, mergeTagsRequest{Into: goTag.ID}, &merged); code != http.StatusOK {
+			t.Fatalf("合并返回 %d", code)
+		}
+		if merged.ID != goTag.ID || merged.PostCount != 2 {
+			t.Errorf("合并结果不正确: %+v", merged)
+		}
+		testutil.AssertCount(t, db.Table("post_tags"), nil, map[string]interface{}{"post_id": both.ID}, 1)
+		if counts := postCounts(t); len(counts) != 3 || counts["Go"] != 2 {
+			t.Errorf("合并后的标签不正确: %v", counts)
+		}
+	})
+
+	t.Run("清理没有文章的标签", func(t *testing.T) {
+		var resp deleteOrphanTagsResponse
+		if code := do(t, srv, "DELETE", "/tags/orphans", adminToken, nil, &resp); code != http.StatusOK {
+			t.Fatalf("清理返回 %d", code)
+		}
+		// db 只被已删除的文章使用，文章恢复后仍需要它，不会删除
+		if len(resp.Deleted) != 1 || resp.Deleted[0].ID != orphan.ID {
+			t.Errorf("删除的标签不正确: %+v", resp.Deleted)
+		}
+		if code := do(t, srv, "DELETE", "/tags/orphans", adminToken, nil, &resp); code != http.StatusOK || len(resp.Deleted) != 0 {
+			t.Errorf("再次清理返回 %d %+v", code, resp.Deleted)
+		}
+	})
+}
+
+// TestPopularPosts 测试热门文章的得分：按互动类型加权、随时间衰减，只统计窗口内的互动
+func TestPopularPosts(t *testing.T) {
+	_, db := newTestServer(t)
+	user := newUser(t, db)
+	posts := make([]Post, 5)
+	for i := range posts {
+		posts[i] = *newPost(t, db, user)
+	}
+	commented, liked, viewed, old, deleted := posts[0], posts[1], posts[2], posts[3], posts[4]
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC) // 当天中午，当天的互动不衰减
+	day := func(daysAgo int) time.Time { return now.AddDate(0, 0, -daysAgo) }
+	comments := []Comment{
+		{Content: "c", UserID: user.ID, PostID: commented.ID, CreatedAt: day(0)},
+		{Content: "c", UserID: user.ID, PostID: commented.ID, CreatedAt: day(0)},
+		{Content: "c", UserID: user.ID, PostID: commented.ID, CreatedAt: day(0), Status: CommentPending},
+		{Content: "c", UserID: user.ID, PostID: old.ID, CreatedAt: day(10)},
+		{Content: "c", UserID: user.ID, PostID: deleted.ID, CreatedAt: day(0)},
+	}
+	if err := db.Create(&comments).Error; err != nil {
+		t.Fatalf("create comments: %v", err)
+	}
+	for i := uint(0); i < 5; i++ {
+		like := Like{UserID: 100 + i, TargetType: LikeTargetPost, TargetID: liked.ID, CreatedAt: day(3)}
+		if err := db.Create(&like).Error; err != nil {
+			t.Fatalf("create like: %v", err)
+		}
+	}
+	view := PostView{PostID: viewed.ID, Day: now.Format(viewDayLayout), Views: 100}
+	if err := db.Create(&view).Error; err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	if err := db.Delete(&deleted).Error; err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+
+	result, err := popularPosts(db, DefaultPopularityWeights, now, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("popularPosts: %v", err)
+	}
+	// 浏览 100*0.1 = 10；评论 2*3 = 6（待审核的不算）；3 天前的点赞 5*2*0.5^3 = 1.25
+	want := []struct {
+		id    uint
+		score float64
+	}{{viewed.ID, 10}, {commented.ID, 6}, {liked.ID, 1.25}}
+	if len(result) != len(want) {
+		t.Fatalf("返回 %d 篇文章，预期 %d: %+v", len(result), len(want), result)
+	}
+	for i, w := range want {
+		if result[i].ID != w.id || result[i].Score != w.score {
+			t.Errorf("第 %d 名是文章 %d（%.2f 分），预期文章 %d（%.2f 分）", i+1, result[i].ID, result[i].Score, w.id, w.score)
+		}
+		if result[i].User == nil {
+			t.Errorf("文章 %d 应预加载作者", result[i].ID)
+		}
+	}
+
+	if result, _ := popularPosts(db, DefaultPopularityWeights, now, 0, 1); len(result) != 1 || result[0].ID != viewed.ID {
+		t.Errorf("limit=1 返回 %+v", result)
+	}
+	// 窗口扩大到 30 天后包含 10 天前的评论
+	if result, _ := popularPosts(db, DefaultPopularityWeights, now, 30*24*time.Hour, 10); len(result) != 4 {
+		t.Errorf("30 天内返回 %d 篇文章，预期 4", len(result))
+	}
+}
+
+// TestServerPopularPosts 测试热门文章接口：浏览文章会被统计，结果在缓存有效期内不变
+func TestServerPopularPosts(t *testing.T) {
+	srv, _ := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+	var post Post
+	if code := do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, &post); code != http.StatusCreated {
+		t.Fatalf("发布文章返回 %d", code)
+	}
+
+	var popular []PopularPost
+	if code := do(t, srv, "GET", "/posts/popular", "", nil, &popular); code != http.StatusOK || len(popular) != 0 {
+		t.Fatalf("没有互动时返回 %d %+v", code, popular)
+	}
+
+	do(t, srv, "GET", "/posts/"+itoa(post.ID), "", nil, nil)
+	do(t, srv, "PUT", "/posts/"+itoa(post.ID)+"/like", token, nil, nil)
+	// 7 天窗口的结果已缓存，换一个窗口才会重新计算
+	do(t, srv, "GET", "/posts/popular", "", nil, &popular)
+	if len(popular) != 0 {
+		t.Errorf("缓存有效期内结果应不变: %+v", popular)
+	}
+	if code := do(t, srv, "GET", "/posts/popular?days=1", token, nil, &popular); code != http.StatusOK || len(popular) != 1 {
+		t.Fatalf("返回 %d %+v，预期 1 篇文章", code, popular)
+	}
+	if popular[0].ID != post.ID || popular[0].Score <= 0 || !popular[0].LikedByMe {
+		t.Errorf("热门文章不正确: %+v", popular[0])
+	}
+
+	// 标记点赞状态不能修改缓存中的文章
+	do(t, srv, "GET", "/posts/popular?days=1", "", nil, &popular)
+	if len(popular) != 1 || popular[0].LikedByMe {
+		t.Errorf("未登录时不应标记点赞: %+v", popular)
+	}
+	if code := do(t, srv, "GET", "/posts/popular?days=x", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("days=x 返回 %d，预期 400", code)
+	}
+}
+
+// TestServerArchive 测试按月归档
+func TestServerArchive(t *testing.T) {
+	srv, db := newTestServer(t)
+	user := newUser(t, db)
+	month := func(m time.Month, day int) time.Time { return time.Date(2024, m, day, 8, 0, 0, 0, time.UTC) }
+	posts := []Post{
+		{Title: "jan 1", PublishedAt: month(time.January, 1)},
+		{Title: "jan 31", PublishedAt: month(time.January, 31)},
+		{Title: "mar", PublishedAt: month(time.March, 15)},
+		{Title: "deleted", PublishedAt: month(time.March, 16)},
+	}
+	for i := range posts {
+		posts[i].UserID = user.ID
+		if err := db.Create(&posts[i]).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+	if err := db.Delete(&posts[3]).Error; err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+
+	t.Run("默认发布时间", func(t *testing.T) {
+		post := Post{Title: "now", UserID: user.ID}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+		if !post.PublishedAt.Equal(post.CreatedAt) {
+			t.Errorf("PublishedAt %v，预期与 CreatedAt %v 相同", post.PublishedAt, post.CreatedAt)
+		}
+		if err := db.Delete(&post).Error; err != nil {
+			t.Fatalf("delete post: %v", err)
+		}
+	})
+
+	t.Run("按月统计", func(t *testing.T) {
+		var archive []ArchiveMonth
+		if code := do(t, srv, "GET", "/archive", "", nil, &archive); code != http.StatusOK {
+			t.Fatalf("GET /archive 返回 %d", code)
+		}
+		want := []ArchiveMonth{{Year: 2024, Month: 3, Count: 1}, {Year: 2024, Month: 1, Count: 2}}
+		if len(archive) != len(want) || archive[0] != want[0] || archive[1] != want[1] {
+			t.Errorf("归档 %+v，预期 %+v", archive, want)
+		}
+	})
+
+	t.Run("某个月的文章", func(t *testing.T) {
+		var page dbutil.Page[Post]
+		if code := do(t, srv, "GET", "/archive/2024/1?size=1", "", nil, &page); code != http.StatusOK {
+			t.Fatalf("返回 %d", code)
+		}
+		if page.Total != 2 || len(page.Items) != 1 || page.Items[0].Title != "jan 31" || page.Items[0].User == nil {
+			t.Errorf("2024 年 1 月的文章不正确: %+v", page)
+		}
+		do(t, srv, "GET", "/archive/2024/2", "", nil, &page)
+		if page.Total != 0 || len(page.Items) != 0 {
+			t.Errorf("2024 年 2 月应没有文章: %+v", page)
+		}
+		for _, path := range []string{"/archive/2024/13", "/archive/2024/0", "/archive/abc/1"} {
+			if code := do(t, srv, "GET", path, "", nil, nil); code != http.StatusBadRequest {
+				t.Errorf("GET %s 返回 %d，预期 400", path, code)
+			}
+		}
+	})
+}
+
+// TestServerMarkdown 测试文章内容的 HTML 渲染和缓存
+func TestServerMarkdown(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+	var post Post
+	req := postRequest{Title: "t", Content: "# Hi\n\n<script>alert(1)</script> **b**"}
+	if code := do(t, srv, "POST", "/posts", token, req, &post); code != http.StatusCreated {
+		t.Fatalf("发布文章返回 %d", code)
+	}
+	path := "/posts/" + itoa(post.ID)
+	cached := func(t *testing.T) string {
+		t.Helper()
+		var html []string
+		if err := db.Model(&Post{}).Where("id = ?", post.ID).Pluck("content_html", &html).Error; err != nil {
+			t.Fatalf("查询缓存: %v", err)
+		}
+		return html[0]
+	}
+
+	t.Run("默认只返回原文", func(t *testing.T) {
+		var got Post
+		do(t, srv, "GET", path, "", nil, &got)
+		if got.Content != req.Content || got.ContentHTML != "" {
+			t.Errorf("content=%q content_html=%q", got.Content, got.ContentHTML)
+		}
+	})
+
+	t.Run("渲染并缓存", func(t *testing.T) {
+		var got Post
+		if code := do(t, srv, "GET", path+"?format=html", "", nil, &got); code != http.StatusOK {
+			t.Fatalf("返回 %d", code)
+		}
+		want := "<h1>Hi</h1>\n<p>&lt;script&gt;alert(1)&lt;/script&gt; <strong>b</strong></p>\n"
+		if got.ContentHTML != want || got.Content != req.Content {
+			t.Errorf("content_html=%q，预期 %q", got.ContentHTML, want)
+		}
+		if html := cached(t); html != want {
+			t.Errorf("缓存 %q，预期 %q", html, want)
+		}
+
+		// 之后直接使用缓存
+		db.Model(&Post{}).Where("id = ?", post.ID).UpdateColumn("content_html", "<p>cached</p>")
+		srv.posts.InvalidatePost(post.ID) // 直接修改数据库不经过接口，需要手动清除文章缓存
+		do(t, srv, "GET", path+"?format=html", "", nil, &got)
+		if got.ContentHTML != "<p>cached</p>" {
+			t.Errorf("应使用缓存，得到 %q", got.ContentHTML)
+		}
+	})
+
+	t.Run("修改后缓存失效", func(t *testing.T) {
+		var updated Post
+		do(t, srv, "PUT", path, token, postRequest{Title: "t", Content: "*new*"}, &updated)
+		if html := cached(t); html != "" {
+			t.Errorf("修改后缓存应清空，得到 %q", html)
+		}
+		var page dbutil.Page[Post]
+		if code := do(t, srv, "GET", "/posts?format=html", "", nil, &page); code != http.StatusOK || len(page.Items) != 1 {
+			t.Fatalf("返回 %d %+v", code, page)
+		}
+		if page.Items[0].ContentHTML != "<p><em>new</em></p>\n" {
+			t.Errorf("列表中的 content_html=%q", page.Items[0].ContentHTML)
+		}
+	})
+
+	if code := do(t, srv, "GET", path+"?format=xml", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("format=xml 返回 %d，预期 400", code)
+	}
+}
+
+// upload 上传附件，postID 不为空时同时提交 post_id
+func upload(t *testing.T, h http.Handler, token, postID, filename string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if postID != "" {
+		mw.WriteField("post_id", postID)
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write(content)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/attachments", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestServerAttachments 测试附件的上传、下载、关联和孤儿清理
+func TestServerAttachments(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	if err := db.Model(bob).Update("role", RoleAdmin).Error; err != nil {
+		t.Fatalf("设置管理员: %v", err)
+	}
+	alicePost := Post{Title: "a", Content: "c", UserID: alice.ID}
+	bobPost := Post{Title: "b", Content: "c", UserID: bob.ID}
+	for _, post := range []*Post{&alicePost, &bobPost} {
+		if err := PublishPostWithTags(db, post, nil); err != nil {
+			t.Fatalf("PublishPostWithTags: %v", err)
+		}
+	}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+
+	var image Attachment
+	t.Run("上传", func(t *testing.T) {
+		rec := upload(t, srv, aliceToken, "", `..\..\logo.png`, png)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("返回 %d: %s", rec.Code, rec.Body.String())
+		}
+		json.Unmarshal(rec.Body.Bytes(), &image)
+		if image.ContentType != "image/png" || image.Size != int64(len(png)) || image.Filename != "logo.png" ||
+			image.UserID != alice.ID || image.PostID != nil {
+			t.Errorf("返回的附件不正确: %+v", image)
+		}
+		if rec.Header().Get("Location") != "/attachments/"+itoa(image.ID) {
+			t.Errorf("Location = %q", rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("上传校验", func(t *testing.T) {
+		cases := []struct {
+			name, postID string
+			content      []byte
+			status       int
+		}{
+			{"HTML", "", []byte("<html><script>alert(1)</script></html>"), 400},
+			{"空文件", "", nil, 400},
+			{"超出大小", "", bytes.Repeat([]byte("a"), MaxAttachmentBytes+1), 400},
+			{"非法文章ID", "x", png, 400},
+			{"文章不存在", "999", png, 404},
+			{"别人的文章", itoa(bobPost.ID), png, 403},
+		}
+		for _, c := range cases {
+			if rec := upload(t, srv, aliceToken, c.postID, "f", c.content); rec.Code != c.status {
+				t.Errorf("%s: 返回 %d，预期 %d: %s", c.name, rec.Code, c.status, rec.Body.String())
+			}
+		}
+		var count int64
+		db.Model(&Attachment{}).Count(&count)
+		if count != 1 {
+			t.Errorf("校验失败不应创建附件，共 %d 个", count)
+		}
+	})
+
+	path := "/attachments/" + itoa(image.ID)
+	download := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("未关联时只有上传人可以下载", func(t *testing.T) {
+		rec := download(aliceToken)
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), png) {
+			t.Fatalf("上传人下载返回 %d，%d 字节", rec.Code, rec.Body.Len())
+		}
+		if rec.Header().Get("Content-Type") != "image/png" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("响应头不正确: %v", rec.Header())
+		}
+		if code := download("").Code; code != http.StatusNotFound {
+			t.Errorf("匿名下载返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("关联文章", func(t *testing.T) {
+		if code := do(t, srv, "PUT", path, bobToken, attachRequest{PostID: &bobPost.ID}, nil); code != http.StatusForbidden {
+			t.Errorf("关联别人的附件返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "PUT", path, aliceToken, attachRequest{PostID: &bobPost.ID}, nil); code != http.StatusForbidden {
+			t.Errorf("关联到别人的文章返回 %d，预期 403", code)
+		}
+		var got Attachment
+		if code := do(t, srv, "PUT", path, aliceToken, attachRequest{PostID: &alicePost.ID}, &got); code != http.StatusOK {
+			t.Fatalf("关联返回 %d", code)
+		}
+		if got.PostID == nil || *got.PostID != alicePost.ID {
+			t.Errorf("post_id = %v", got.PostID)
+		}
+		if code := download("").Code; code != http.StatusOK {
+			t.Errorf("关联后匿名下载返回 %d", code)
+		}
+
+		// 上传时直接关联
+		if rec := upload(t, srv, aliceToken, itoa(alicePost.ID), "notes.txt", []byte("hello")); rec.Code != http.StatusCreated {
+			t.Fatalf("上传时关联返回 %d: %s", rec.Code, rec.Body.String())
+		}
+		var list []Attachment
+		if code := do(t, srv, "GET", "/posts/"+itoa(alicePost.ID)+"/attachments", "", nil, &list); code != http.StatusOK {
+			t.Fatalf("查询文章附件返回 %d", code)
+		}
+		if len(list) != 2 || list[0].ID != image.ID || list[1].ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("文章附件不正确: %+v", list)
+		}
+
+		// 文章删除后其他人不能下载
+		if err := DeletePost(db, alicePost.ID); err != nil {
+			t.Fatalf("DeletePost: %v", err)
+		}
+		if code := download("").Code; code != http.StatusNotFound {
+			t.Errorf("文章删除后匿名下载返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("删除", func(t *testing.T) {
+		stored, err := GetAttachment(db, image.ID)
+		if err != nil {
+			t.Fatalf("GetAttachment: %v", err)
+		}
+		if code := do(t, srv, "DELETE", path, bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("删除别人的附件返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "DELETE", path, aliceToken, nil, nil); code != http.StatusNoContent {
+			t.Fatalf("删除返回 %d", code)
+		}
+		if _, err := srv.storage.Open(context.Background(), stored.StorageKey); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("文件应已删除: %v", err)
+		}
+		if code := download(aliceToken).Code; code != http.StatusNotFound {
+			t.Errorf("删除后下载返回 %d，预期 404", code)
+		}
+	})
+
+	t.Run("清理孤儿", func(t *testing.T) {
+		old := time.Now().Add(-2 * DefaultOrphanAttachmentAge)
+		var stale, fresh Attachment
+		for _, a := range []*Attachment{&stale, &fresh} {
+			rec := upload(t, srv, aliceToken, "", "f.png", png)
+			json.Unmarshal(rec.Body.Bytes(), a)
+		}
+		db.Model(&stale).UpdateColumn("created_at", old)
+
+		// 没有记录的文件，只清理修改时间足够早的
+		root := srv.storage.(*LocalStorage).Root
+		for _, key := range []string{"zz/stray.png", "zz/recent.png"} {
+			if err := srv.storage.Put(context.Background(), key, bytes.NewReader(png)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+		os.Chtimes(filepath.Join(root, "zz", "stray.png"), old, old)
+		// 引用的文章被物理删除的附件（文件修改时间不影响有记录的附件）
+		var gone Attachment
+		json.Unmarshal(upload(t, srv, bobToken, itoa(bobPost.ID), "g.png", png).Body.Bytes(), &gone)
+		db.Model(&gone).UpdateColumn("created_at", old)
+		if err := db.Unscoped().Delete(&Post{}, bobPost.ID).Error; err != nil {
+			t.Fatalf("物理删除文章: %v", err)
+		}
+
+		if code := do(t, srv, "DELETE", "/attachments/orphans", aliceToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("非管理员清理返回 %d，预期 403", code)
+		}
+		var result AttachmentCleanup
+		if code := do(t, srv, "DELETE", "/attachments/orphans", bobToken, nil, &result); code != http.StatusOK {
+			t.Fatalf("清理返回 %d", code)
+		}
+		var deleted []uint
+		for _, a := range result.Attachments {
+			deleted = append(deleted, a.ID)
+		}
+		if len(deleted) != 2 || deleted[0] != stale.ID || deleted[1] != gone.ID {
+			t.Errorf("删除的附件 %v，预期 [%d %d]", deleted, stale.ID, gone.ID)
+		}
+		if len(result.Files) != 1 || result.Files[0] != "zz/stray.png" {
+			t.Errorf("删除的文件 %v，预期 [zz/stray.png]", result.Files)
+		}
+
+		var remaining []uint
+		db.Model(&Attachment{}).Order("id").Pluck("id", &remaining)
+		var keys []string
+		srv.storage.List(context.Background(), func(key string, _ time.Time) error {
+			keys = append(keys, key)
+			return nil
+		})
+		// 剩下：已删除文章上的 notes.txt、刚上传的 fresh 及其文件、最近的无记录文件
+		if len(remaining) != 2 || remaining[1] != fresh.ID || len(keys) != 3 {
+			t.Errorf("剩余附件 %v，文件 %v", remaining, keys)
+		}
+	})
+}
+
+// TestLocalStorageKey 测试 LocalStorage 拒绝跳出根目录的 key
+func TestLocalStorageKey(t *testing.T) {
+	storage := &LocalStorage{Root: t.TempDir()}
+	for _, key := range []string{"", "../x", "/etc/passwd", `a\..\..\x`, "a/../../x"} {
+		if err := storage.Put(context.Background(), key, strings.NewReader("x")); !errors.Is(err, ErrStorageKeyInvalid) {
+			t.Errorf("Put(%q) = %v，预期 ErrStorageKeyInvalid", key, err)
+		}
+	}
+}
+
+// TestServerFollow 测试关注、粉丝数和关注的作者的文章
+func TestServerFollow(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	_, carol := register(t, srv, "Carol")
+
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	publish := func(author uint, title string, offset time.Duration) Post {
+		t.Helper()
+		post := Post{Title: title, Content: "c", UserID: author, PublishedAt: base.Add(offset)}
+		if err := PublishPostWithTags(db, &post, nil); err != nil {
+			t.Fatalf("PublishPostWithTags: %v", err)
+		}
+		return post
+	}
+	publish(bob.ID, "bob-1", 0)
+	carolPost := publish(carol.ID, "carol-1", time.Hour)
+	publish(bob.ID, "bob-2", 2*time.Hour)
+	publish(alice.ID, "alice-1", 3*time.Hour)
+	if err := DeletePost(db, publish(bob.ID, "bob-deleted", 4*time.Hour).ID); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+
+	followPath := func(user *User) string { return "/users/" + itoa(user.ID) + "/follow" }
+	t.Run("关注", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			var resp followResponse
+			if code := do(t, srv, "PUT", followPath(bob), aliceToken, nil, &resp); code != http.StatusOK {
+				t.Fatalf("关注返回 %d", code)
+			}
+			if !resp.Following || resp.FollowerCount != 1 {
+				t.Errorf("第 %d 次关注: %+v，重复关注不应重复计数", i+1, resp)
+			}
+		}
+		do(t, srv, "PUT", followPath(carol), aliceToken, nil, nil)
+		do(t, srv, "PUT", followPath(carol), bobToken, nil, nil)
+
+		var me User
+		do(t, srv, "GET", "/me", aliceToken, nil, &me)
+		if me.FollowingCount != 2 || me.FollowerCount != 0 {
+			t.Errorf("Alice 的关注数 %d、粉丝数 %d，预期 2、0", me.FollowingCount, me.FollowerCount)
+		}
+
+		if code := do(t, srv, "PUT", followPath(alice), aliceToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("关注自己返回 %d，预期 400", code)
+		}
+		if code := do(t, srv, "PUT", "/users/999/follow", aliceToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("关注不存在的用户返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "PUT", followPath(bob), "", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("未登录关注返回 %d，预期 401", code)
+		}
+	})
+
+	t.Run("粉丝和关注列表", func(t *testing.T) {
+		var followers dbutil.Page[User]
+		if code := do(t, srv, "GET", "/users/"+itoa(carol.ID)+"/followers", "", nil, &followers); code != http.StatusOK {
+			t.Fatalf("查询粉丝返回 %d", code)
+		}
+		// 最近关注的在前
+		if followers.Total != 2 || len(followers.Items) != 2 || followers.Items[0].ID != bob.ID {
+			t.Errorf("Carol 的粉丝不正确: %+v", followers)
+		}
+		var following dbutil.Page[User]
+		do(t, srv, "GET", "/users/"+itoa(alice.ID)+"/following?size=1", "", nil, &following)
+		if following.Total != 2 || len(following.Items) != 1 || following.Items[0].ID != carol.ID {
+			t.Errorf("Alice 关注的作者不正确: %+v", following)
+		}
+		if code := do(t, srv, "GET", "/users/999/followers", "", nil, nil); code != http.StatusNotFound {
+			t.Errorf("不存在的用户返回 %d，预期 404", code)
+		}
+	})
+
+	titles := func(t *testing.T, token string) []string {
+		t.Helper()
+		var page dbutil.Page[Post]
+		if code := do(t, srv, "GET", "/feed", token, nil, &page); code != http.StatusOK {
+			t.Fatalf("GET /feed 返回 %d", code)
+		}
+		var got []string
+		for _, post := range page.Items {
+			got = append(got, post.Title)
+		}
+		return got
+	}
+
+	t.Run("关注的作者的文章", func(t *testing.T) {
+		// 按发布时间倒序，不含自己和未关注作者的文章，不含已删除的文章
+		if got := strings.Join(titles(t, aliceToken), ","); got != "bob-2,carol-1,bob-1" {
+			t.Errorf("Alice 的关注动态 %s", got)
+		}
+		if _, err := LikeTarget(db, alice.ID, LikeTargetPost, carolPost.ID); err != nil {
+			t.Fatalf("LikeTarget: %v", err)
+		}
+		var page dbutil.Page[Post]
+		do(t, srv, "GET", "/feed?size=2&page=2", aliceToken, nil, &page)
+		if len(page.Items) != 1 || page.Total != 3 || page.Items[0].Title != "bob-1" {
+			t.Errorf("第二页不正确: %+v", page)
+		}
+		do(t, srv, "GET", "/feed", aliceToken, nil, &page)
+		if !page.Items[1].LikedByMe || page.Items[0].LikedByMe {
+			t.Errorf("liked_by_me 不正确")
+		}
+		var body json.RawMessage
+		do(t, srv, "GET", "/feed", aliceToken, nil, &body)
+		testutil.AssertGoldenJSON(t, "follow_feed", body, testutil.NormalizeIDs(), testutil.NormalizeTimestamps())
+		if code := do(t, srv, "GET", "/feed", "", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("未登录返回 %d，预期 401", code)
+		}
+	})
+
+	t.Run("取消关注", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			var resp followResponse
+			if code := do(t, srv, "DELETE", followPath(bob), aliceToken, nil, &resp); code != http.StatusOK {
+				t.Fatalf("取消关注返回 %d", code)
+			}
+			if resp.Following || resp.FollowerCount != 0 {
+				t.Errorf("第 %d 次取消关注: %+v", i+1, resp)
+			}
+		}
+		if got := strings.Join(titles(t, aliceToken), ","); got != "carol-1" {
+			t.Errorf("取消关注后的动态 %s", got)
+		}
+		var me User
+		do(t, srv, "GET", "/me", aliceToken, nil, &me)
+		if me.FollowingCount != 1 {
+			t.Errorf("取消关注后关注数 %d，预期 1", me.FollowingCount)
+		}
+	})
+}
+
+// TestServerNotifications 测试评论、回复和点赞的通知及其查询、已读接口
+func TestServerNotifications(t *testing.T) {
+	srv, db := newTestServer(t)
+	dispatcher := Notifications
+	Notifications = &NotificationDispatcher{}
+	t.Cleanup(func() { Notifications = dispatcher })
+	var delivered []string
+	Notifications.Register(NotificationChannelFunc(func(ctx context.Context, n *Notification) error {
+		delivered = append(delivered, n.Type)
+		return nil
+	}))
+
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+	carolToken, _ := register(t, srv, "Carol")
+	modToken, moderator := register(t, srv, "Mod")
+	if err := db.Model(moderator).Update("role", RoleModerator).Error; err != nil {
+		t.Fatalf("设置审核员: %v", err)
+	}
+
+	var post Post
+	do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c"}, &post)
+	postPath := "/posts/" + itoa(post.ID)
+
+	// types 返回用户的通知类型，最新的在前
+	types := func(t *testing.T, token, query string) []string {
+		t.Helper()
+		var page dbutil.Page[Notification]
+		if code := do(t, srv, "GET", "/notifications"+query, token, nil, &page); code != http.StatusOK {
+			t.Fatalf("GET /notifications%s 返回 %d", query, code)
+		}
+		got := []string{}
+		for _, n := range page.Items {
+			got = append(got, n.Type)
+		}
+		return got
+	}
+	unread := func(t *testing.T, token string) int64 {
+		t.Helper()
+		var resp countResponse
+		if code := do(t, srv, "GET", "/notifications/unread-count", token, nil, &resp); code != http.StatusOK {
+			t.Fatalf("查询未读数量返回 %d", code)
+		}
+		return resp.Count
+	}
+
+	var bobComment Comment
+	t.Run("评论和回复", func(t *testing.T) {
+		do(t, srv, "POST", postPath+"/comments", bobToken, commentRequest{Content: "hi"}, &bobComment)
+		// 文章作者回复：通知 Bob，不通知自己
+		do(t, srv, "POST", "/comments/"+itoa(bobComment.ID)+"/replies", aliceToken, commentRequest{Content: "thanks"}, nil)
+		// Carol 回复 Bob：通知 Bob 和文章作者
+		do(t, srv, "POST", "/comments/"+itoa(bobComment.ID)+"/replies", carolToken, commentRequest{Content: "+1"}, nil)
+
+		if got := strings.Join(types(t, aliceToken, ""), ","); got != "comment,comment" {
+			t.Errorf("Alice 的通知 %s", got)
+		}
+		if got := strings.Join(types(t, bobToken, ""), ","); got != "reply,reply" {
+			t.Errorf("Bob 的通知 %s", got)
+		}
+		var page dbutil.Page[Notification]
+		do(t, srv, "GET", "/notifications", bobToken, nil, &page)
+		if n := page.Items[1]; n.Actor == nil || n.Actor.ID != alice.ID || n.PostID != post.ID || n.ReadAt != nil {
+			t.Errorf("通知内容不正确: %+v", n)
+		}
+	})
+
+	t.Run("点赞", func(t *testing.T) {
+		for _, path := range []string{postPath + "/like", "/comments/" + itoa(bobComment.ID) + "/like"} {
+			do(t, srv, "PUT", path, carolToken, nil, nil)
+			// 取消后再点赞不重复通知
+			do(t, srv, "DELETE", path, carolToken, nil, nil)
+			do(t, srv, "PUT", path, carolToken, nil, nil)
+		}
+		do(t, srv, "PUT", "/comments/"+itoa(bobComment.ID)+"/like", bobToken, nil, nil)
+
+		if got := strings.Join(types(t, aliceToken, ""), ","); got != "like,comment,comment" {
+			t.Errorf("Alice 的通知 %s", got)
+		}
+		var page dbutil.Page[Notification]
+		do(t, srv, "GET", "/notifications", bobToken, nil, &page)
+		if page.Total != 3 || page.Items[0].Type != NotifyLike || page.Items[0].CommentID != bobComment.ID {
+			t.Errorf("Bob 的通知不正确: %+v", page.Items)
+		}
+	})
+
+	t.Run("待审核的评论通过后才通知", func(t *testing.T) {
+		rules := CommentModeration
+		CommentModeration = ModerationRules{RequireApproval: true, TrustedAfter: 100}
+		t.Cleanup(func() { CommentModeration = rules })
+
+		before := unread(t, aliceToken)
+		var pending Comment
+		do(t, srv, "POST", postPath+"/comments", carolToken, commentRequest{Content: "later"}, &pending)
+		if got := unread(t, aliceToken); got != before {
+			t.Errorf("待审核的评论不应通知，未读 %d，之前 %d", got, before)
+		}
+		for i := 0; i < 2; i++ {
+			path := "/comments/" + itoa(pending.ID) + "/moderation"
+			if code := do(t, srv, "PUT", path, modToken, moderationRequest{Status: CommentApproved}, nil); code != http.StatusOK {
+				t.Fatalf("审核返回 %d", code)
+			}
+		}
+		if got := unread(t, aliceToken); got != before+1 {
+			t.Errorf("通过后未读 %d，预期 %d", got, before+1)
+		}
+	})
+
+	t.Run("已读", func(t *testing.T) {
+		var page dbutil.Page[Notification]
+		do(t, srv, "GET", "/notifications", aliceToken, nil, &page)
+		first := "/notifications/" + itoa(page.Items[0].ID) + "/read"
+		if code := do(t, srv, "PUT", first, bobToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("标记别人的通知返回 %d，预期 404", code)
+		}
+		for i := 0; i < 2; i++ {
+			if code := do(t, srv, "PUT", first, aliceToken, nil, nil); code != http.StatusNoContent {
+				t.Errorf("第 %d 次标记已读返回 %d", i+1, code)
+			}
+		}
+		if got := unread(t, aliceToken); got != page.Total-1 {
+			t.Errorf("未读 %d，预期 %d", got, page.Total-1)
+		}
+		if got := types(t, aliceToken, "?unread=true"); len(got) != int(page.Total-1) {
+			t.Errorf("未读的通知 %v", got)
+		}
+
+		var resp countResponse
+		if code := do(t, srv, "PUT", "/notifications/read", aliceToken, nil, &resp); code != http.StatusOK || resp.Count != page.Total-1 {
+			t.Errorf("全部已读返回 %d，标记 %d 条", code, resp.Count)
+		}
+		if got := unread(t, aliceToken); got != 0 {
+			t.Errorf("全部已读后未读 %d", got)
+		}
+		if got := unread(t, bobToken); got != 3 {
+			t.Errorf("不应影响其他用户，Bob 未读 %d", got)
+		}
+		if code := do(t, srv, "GET", "/notifications?unread=maybe", aliceToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("非法 unread 返回 %d，预期 400", code)
+		}
+	})
+
+	// 每条新通知推送一次：Alice 4 条（2 评论、1 点赞、1 审核通过），Bob 3 条
+	if len(delivered) != 7 {
+		t.Errorf("推送了 %d 条通知 %v，预期 7 条", len(delivered), delivered)
+	}
+}
+
+// TestServerTrash 测试回收站：查询、恢复已删除的文章和评论，彻底删除过期的内容
+func TestServerTrash(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	if err := db.Model(bob).Update("role", RoleAdmin).Error; err != nil {
+		t.Fatalf("设置管理员: %v", err)
+	}
+	tag := Tag{Name: "go"}
+	db.Create(&tag)
+
+	var post Post
+	do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c", TagIDs: []uint{tag.ID}}, &post)
+	postPath := "/posts/" + itoa(post.ID)
+	postCount := func() uint {
+		var user User
+		db.First(&user, alice.ID)
+		return user.PostCount
+	}
+
+	t.Run("恢复文章", func(t *testing.T) {
+		do(t, srv, "DELETE", postPath, aliceToken, nil, nil)
+		var trash dbutil.Page[DeletedPost]
+		if code := do(t, srv, "GET", "/trash/posts", aliceToken, nil, &trash); code != http.StatusOK {
+			t.Fatalf("查询回收站返回 %d", code)
+		}
+		if trash.Total != 1 || trash.Items[0].ID != post.ID || trash.Items[0].DeletedAt.IsZero() || len(trash.Items[0].Tags) != 1 {
+			t.Errorf("回收站不正确: %+v", trash.Items)
+		}
+		do(t, srv, "GET", "/trash/posts", bobToken, nil, &trash)
+		if trash.Total != 0 {
+			t.Errorf("不应看到别人的文章: %+v", trash.Items)
+		}
+		if postCount() != 0 {
+			t.Errorf("删除后文章数 %d", postCount())
+		}
+
+		if code := do(t, srv, "POST", postPath+"/restore", bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("恢复别人的文章返回 %d，预期 403", code)
+		}
+		var restored Post
+		if code := do(t, srv, "POST", postPath+"/restore", aliceToken, nil, &restored); code != http.StatusOK {
+			t.Fatalf("恢复返回 %d", code)
+		}
+		if restored.ID != post.ID || len(restored.Tags) != 1 || postCount() != 1 {
+			t.Errorf("恢复后 %+v，文章数 %d", restored, postCount())
+		}
+		if code := do(t, srv, "POST", postPath+"/restore", aliceToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("恢复未删除的文章返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "GET", postPath, "", nil, nil); code != http.StatusOK {
+			t.Errorf("恢复后查询文章返回 %d", code)
+		}
+	})
+
+	t.Run("恢复评论", func(t *testing.T) {
+		var comment, reply Comment
+		do(t, srv, "POST", postPath+"/comments", bobToken, commentRequest{Content: "c"}, &comment)
+		do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/replies", aliceToken, commentRequest{Content: "r"}, &reply)
+		do(t, srv, "DELETE", "/comments/"+itoa(comment.ID), bobToken, nil, nil)
+		do(t, srv, "DELETE", "/comments/"+itoa(reply.ID), aliceToken, nil, nil)
+
+		replyRestore := "/comments/" + itoa(reply.ID) + "/restore"
+		if code := do(t, srv, "POST", replyRestore, aliceToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("上级评论已删除时恢复回复返回 %d，预期 400", code)
+		}
+		if code := do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/restore", aliceToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("恢复别人的评论返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/restore", bobToken, nil, nil); code != http.StatusOK {
+			t.Errorf("恢复评论返回 %d", code)
+		}
+		var got Comment
+		if code := do(t, srv, "POST", replyRestore, aliceToken, nil, &got); code != http.StatusOK || got.ID != reply.ID {
+			t.Errorf("恢复回复返回 %d %+v", code, got)
+		}
+		var page dbutil.Page[Comment]
+		do(t, srv, "GET", postPath+"/comments", "", nil, &page)
+		if page.Total != 1 || len(page.Items[0].Replies) != 1 {
+			t.Errorf("恢复后的评论树不正确: %+v", page.Items)
+		}
+	})
+
+	t.Run("彻底删除", func(t *testing.T) {
+		old := time.Now().Add(-2 * DefaultTrashRetention)
+		// 过期的文章及其评论树、点赞和浏览
+		var expired Post
+		do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "old", Content: "c", TagIDs: []uint{tag.ID}}, &expired)
+		expiredPath := "/posts/" + itoa(expired.ID)
+		var c1 Comment
+		do(t, srv, "POST", expiredPath+"/comments", bobToken, commentRequest{Content: "c"}, &c1)
+		do(t, srv, "POST", "/comments/"+itoa(c1.ID)+"/replies", aliceToken, commentRequest{Content: "r"}, nil)
+		do(t, srv, "PUT", "/comments/"+itoa(c1.ID)+"/like", aliceToken, nil, nil)
+		do(t, srv, "PUT", expiredPath+"/like", bobToken, nil, nil)
+		do(t, srv, "GET", expiredPath, "", nil, nil)
+		do(t, srv, "DELETE", expiredPath, aliceToken, nil, nil)
+		// 最近删除的文章保留
+		var recent Post
+		do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "recent", Content: "c"}, &recent)
+		do(t, srv, "DELETE", "/posts/"+itoa(recent.ID), aliceToken, nil, nil)
+		// 未删除的文章上：过期的评论彻底删除，有回复的过期评论保留
+		var leaf, parent Comment
+		do(t, srv, "POST", postPath+"/comments", bobToken, commentRequest{Content: "leaf"}, &leaf)
+		do(t, srv, "POST", postPath+"/comments", bobToken, commentRequest{Content: "parent"}, &parent)
+		do(t, srv, "POST", "/comments/"+itoa(parent.ID)+"/replies", aliceToken, commentRequest{Content: "r"}, nil)
+		do(t, srv, "DELETE", "/comments/"+itoa(leaf.ID), bobToken, nil, nil)
+		do(t, srv, "DELETE", "/comments/"+itoa(parent.ID), bobToken, nil, nil)
+
+		db.Unscoped().Model(&Post{}).Where("id = ?", expired.ID).UpdateColumn("deleted_at", old)
+		db.Unscoped().Model(&Comment{}).Where("id IN ?", []uint{leaf.ID, parent.ID}).UpdateColumn("deleted_at", old)
+
+		if code := do(t, srv, "DELETE", "/trash", aliceToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("非管理员清理返回 %d，预期 403", code)
+		}
+		var purged TrashPurge
+		if code := do(t, srv, "DELETE", "/trash", bobToken, nil, &purged); code != http.StatusOK {
+			t.Fatalf("清理返回 %d", code)
+		}
+		if purged.Posts != 1 || purged.Comments != 3 {
+			t.Errorf("删除了 %d 篇文章、%d 条评论，预期 1、3", purged.Posts, purged.Comments)
+		}
+
+		count := func(model interface{}, query string, args ...interface{}) int64 {
+			var n int64
+			db.Unscoped().Model(model).Where(query, args...).Count(&n)
+			return n
+		}
+		checks := []struct {
+			name      string
+			got, want int64
+		}{
+			{"过期文章", count(&Post{}, "id = ?", expired.ID), 0},
+			{"最近删除的文章", count(&Post{}, "id = ?", recent.ID), 1},
+			{"过期文章的评论", count(&Comment{}, "post_id = ?", expired.ID), 0},
+			{"过期的叶子评论", count(&Comment{}, "id = ?", leaf.ID), 0},
+			{"有回复的过期评论", count(&Comment{}, "id = ?", parent.ID), 1},
+			{"文章点赞", count(&Like{}, "target_type = ? AND target_id = ?", LikeTargetPost, expired.ID), 0},
+			{"评论点赞", count(&Like{}, "target_type = ? AND target_id = ?", LikeTargetComment, c1.ID), 0},
+			{"浏览统计", count(&PostView{}, "post_id = ?", expired.ID), 0},
+			{"通知", count(&Notification{}, "post_id = ?", expired.ID), 0},
+			{"标签", count(&Tag{}, "id = ?", tag.ID), 1},
+		}
+		for _, c := range checks {
+			if c.got != c.want {
+				t.Errorf("%s: %d 条，预期 %d", c.name, c.got, c.want)
+			}
+		}
+		// 彻底删除后不能留下指向已删除文章或评论的标签关联和回复
+		testutil.AssertNoOrphans(t, db, "post_tags")
+		testutil.AssertNoOrphans(t, db, "comments", "post_id=posts", "parent_id=comments")
+	})
+}
+
+// TestServerRelatedPosts 测试按相同标签数量推荐相关文章
+func TestServerRelatedPosts(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, user := register(t, srv, "Alice")
+	tags := []Tag{{Name: "go"}, {Name: "gorm"}, {Name: "sql"}, {Name: "web"}}
+	db.Create(&tags)
+	goTag, gormTag, sqlTag, webTag := tags[0].ID, tags[1].ID, tags[2].ID, tags[3].ID
+
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	publish := func(title string, offset time.Duration, tagIDs ...uint) Post {
+		t.Helper()
+		post := Post{Title: title, Content: "c", UserID: user.ID, PublishedAt: base.Add(offset)}
+		if err := PublishPostWithTags(db, &post, tagIDs); err != nil {
+			t.Fatalf("PublishPostWithTags: %v", err)
+		}
+		return post
+	}
+	source := publish("source", 0, goTag, gormTag, sqlTag)
+	publish("two-old", time.Hour, goTag, gormTag)
+	publish("three", 2*time.Hour, goTag, gormTag, sqlTag, webTag)
+	twoNew := publish("two-new", 3*time.Hour, gormTag, sqlTag)
+	publish("one", 4*time.Hour, sqlTag, webTag)
+	publish("none", 5*time.Hour, webTag)
+	if err := DeletePost(db, publish("deleted", 6*time.Hour, goTag, gormTag, sqlTag).ID); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+	LikeTarget(db, user.ID, LikeTargetPost, twoNew.ID)
+
+	path := "/posts/" + itoa(source.ID) + "/related"
+	var related []RelatedPost
+	if code := do(t, srv, "GET", path, token, nil, &related); code != http.StatusOK {
+		t.Fatalf("返回 %d", code)
+	}
+	var got []string
+	for _, post := range related {
+		got = append(got, fmt.Sprintf("%s:%d", post.Title, post.SharedTags))
+	}
+	if want := "three:3,two-new:2,two-old:2,one:1"; strings.Join(got, ",") != want {
+		t.Errorf("相关文章 %v，预期 %s", got, want)
+	}
+	if len(related) > 1 && (!related[1].LikedByMe || related[0].LikedByMe || related[1].User == nil || len(related[1].Tags) != 2) {
+		t.Errorf("文章详情不正确: %+v", related[1])
+	}
+
+	do(t, srv, "GET", path+"?limit=2", "", nil, &related)
+	if len(related) != 2 {
+		t.Errorf("limit=2 返回 %d 篇", len(related))
+	}
+	if code := do(t, srv, "GET", "/posts/999/related", "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("文章不存在返回 %d，预期 404", code)
+	}
+}
+
+func TestServerScheduledPublishing(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+
+	var draft Post
+	req := createPostRequest{postRequest: postRequest{Title: "草稿", Content: "c"}, Draft: true}
+	if code := do(t, srv, "POST", "/posts", aliceToken, req, &draft); code != http.StatusCreated {
+		t.Fatalf("创建草稿返回 %d", code)
+	}
+	if draft.Status != PostDraft {
+		t.Fatalf("状态 %q，预期 draft", draft.Status)
+	}
+	postPath := "/posts/" + itoa(draft.ID)
+	listed := func() int64 {
+		var page dbutil.Page[Post]
+		do(t, srv, "GET", "/posts", "", nil, &page)
+		return page.Total
+	}
+
+	t.Run("草稿不公开", func(t *testing.T) {
+		if listed() != 0 {
+			t.Errorf("列表中不应有草稿")
+		}
+		if code := do(t, srv, "GET", postPath, bobToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("别人查看草稿返回 %d，预期 404", code)
+		}
+		if code := do(t, srv, "GET", postPath, aliceToken, nil, nil); code != http.StatusOK {
+			t.Errorf("作者查看草稿返回 %d", code)
+		}
+		if code := do(t, srv, "POST", postPath+"/comments", bobToken, commentRequest{Content: "c"}, nil); code != http.StatusNotFound {
+			t.Errorf("评论草稿返回 %d，预期 404", code)
+		}
+		var drafts dbutil.Page[Post]
+		do(t, srv, "GET", "/posts/drafts", aliceToken, nil, &drafts)
+		if drafts.Total != 1 || drafts.Items[0].ID != draft.ID {
+			t.Errorf("草稿列表不正确: %+v", drafts.Items)
+		}
+		do(t, srv, "GET", "/posts/drafts", bobToken, nil, &drafts)
+		if drafts.Total != 0 {
+			t.Errorf("不应看到别人的草稿: %+v", drafts.Items)
+		}
+	})
+
+	t.Run("定时发布", func(t *testing.T) {
+		publishAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		shanghai, _ := time.LoadLocation("Asia/Shanghai")
+		local := scheduleRequest{PublishAt: publishAt.In(shanghai).Format("2006-01-02T15:04:05"), Timezone: "Asia/Shanghai"}
+
+		if code := do(t, srv, "PUT", postPath+"/schedule", bobToken, local, nil); code != http.StatusForbidden {
+			t.Errorf("定时发布别人的文章返回 %d，预期 403", code)
+		}
+		past := scheduleRequest{PublishAt: time.Now().Add(-time.Hour).Format(time.RFC3339)}
+		if code := do(t, srv, "PUT", postPath+"/schedule", aliceToken, past, nil); code != http.StatusBadRequest {
+			t.Errorf("过去的时间返回 %d，预期 400", code)
+		}
+		for _, bad := range []scheduleRequest{
+			{PublishAt: "2030-01-01T08:00:00"},
+			{PublishAt: "2030-01-01T08:00:00", Timezone: "Mars/Olympus"},
+		} {
+			if code := do(t, srv, "PUT", postPath+"/schedule", aliceToken, bad, nil); code != http.StatusBadRequest {
+				t.Errorf("%+v 返回 %d，预期 400", bad, code)
+			}
+		}
+
+		var scheduled Post
+		if code := do(t, srv, "PUT", postPath+"/schedule", aliceToken, local, &scheduled); code != http.StatusOK {
+			t.Fatalf("定时发布返回 %d", code)
+		}
+		if scheduled.Status != PostScheduled || !scheduled.PublishedAt.Equal(publishAt) {
+			t.Errorf("定时发布后 %q %v，预期 %v", scheduled.Status, scheduled.PublishedAt, publishAt)
+		}
+
+		if n, err := PublishDuePosts(db, time.Now()); err != nil || n != 0 {
+			t.Errorf("未到期时发布了 %d 篇: %v", n, err)
+		}
+		if n, err := PublishDuePosts(db, publishAt); err != nil || n != 1 {
+			t.Errorf("到期时发布了 %d 篇: %v", n, err)
+		}
+		srv.posts.InvalidateAll() // 同 runServer 中 ScheduledPublisher 的 OnPublish
+		if listed() != 1 {
+			t.Errorf("发布后应出现在列表中")
+		}
+		var published Post
+		do(t, srv, "GET", postPath, "", nil, &published)
+		if published.Status != PostPublished || !published.PublishedAt.Equal(publishAt) {
+			t.Errorf("发布后 %q %v", published.Status, published.PublishedAt)
+		}
+		if code := do(t, srv, "PUT", postPath+"/schedule", aliceToken, local, nil); code != http.StatusBadRequest {
+			t.Errorf("已发布的文章定时发布返回 %d，预期 400", code)
+		}
+	})
+
+	t.Run("取消和立即发布", func(t *testing.T) {
+		var post Post
+		do(t, srv, "POST", "/posts", aliceToken, createPostRequest{postRequest: postRequest{Title: "t", Content: "c"}, Draft: true}, &post)
+		path := "/posts/" + itoa(post.ID)
+		if code := do(t, srv, "DELETE", path+"/schedule", aliceToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("取消未定时发布的草稿返回 %d，预期 400", code)
+		}
+		do(t, srv, "PUT", path+"/schedule", aliceToken, scheduleRequest{PublishAt: time.Now().Add(time.Hour).Format(time.RFC3339)}, nil)
+		var cancelled Post
+		if code := do(t, srv, "DELETE", path+"/schedule", aliceToken, nil, &cancelled); code != http.StatusOK || cancelled.Status != PostDraft {
+			t.Errorf("取消定时发布返回 %d，状态 %q", code, cancelled.Status)
+		}
+		if n, _ := PublishDuePosts(db, time.Now().Add(2*time.Hour)); n != 0 {
+			t.Errorf("取消后仍发布了 %d 篇", n)
+		}
+
+		var published Post
+		if code := do(t, srv, "POST", path+"/publish", aliceToken, nil, &published); code != http.StatusOK || published.Status != PostPublished {
+			t.Errorf("立即发布返回 %d，状态 %q", code, published.Status)
+		}
+		if code := do(t, srv, "POST", path+"/publish", aliceToken, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("重复发布返回 %d，预期 400", code)
+		}
+		if listed() != 2 {
+			t.Errorf("立即发布后列表中应有 2 篇文章")
+		}
+	})
+	t.Run("ScheduledPublisher", func(t *testing.T) {
+		var post Post
+		do(t, srv, "POST", "/posts", aliceToken, createPostRequest{postRequest: postRequest{Title: "t", Content: "c"}, Draft: true}, &post)
+
+		start := time.Date(2030, 1, 1, 8, 0, 0, 0, time.UTC)
+		clock := testutil.NewFakeClock(start)
+		ctx, cancel := context.WithCancel(clock.Context(context.Background()))
+		defer cancel()
+		if _, err := SchedulePost(db.WithContext(ctx), post.ID, start.Add(-time.Second)); err == nil {
+			t.Errorf("早于时钟当前时间的定时发布应返回错误")
+		}
+		if _, err := SchedulePost(db.WithContext(ctx), post.ID, start.Add(90*time.Second)); err != nil {
+			t.Fatalf("SchedulePost: %v", err)
+		}
+
+		published := make(chan struct{}, 1)
+		publisher := NewScheduledPublisher(db, time.Minute)
+		publisher.Clock = clock
+		publisher.OnPublish = func() { published <- struct{}{} }
+		done := make(chan struct{})
+		go func() {
+			publisher.Run(ctx)
+			close(done)
+		}()
+
+		clock.BlockUntil(1) // 等待 Run 创建 ticker
+		clock.Advance(time.Minute)
+		clock.Advance(time.Minute)
+		select {
+		case <-published:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("时钟前进到计划时间之后文章没有发布")
+		}
+		got, err := GetPost(db, post.ID)
+		if err != nil {
+			t.Fatalf("GetPost: %v", err)
+		}
+		if got.Status != PostPublished || !got.PublishedAt.Equal(start.Add(90*time.Second)) {
+			t.Errorf("发布后 %q %v", got.Status, got.PublishedAt)
+		}
+		cancel()
+		<-done
+	})
+}
+
+func TestServerPostCache(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+	var post Post
+	do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, &post)
+	path := "/posts/" + itoa(post.ID)
+
+	// 第二次查询命中缓存，直接修改数据库不会影响结果
+	do(t, srv, "GET", path, "", nil, nil)
+	db.Model(&Post{}).Where("id = ?", post.ID).UpdateColumn("title", "changed")
+	var got Post
+	do(t, srv, "GET", path, "", nil, &got)
+	if got.Title != "t" {
+		t.Errorf("应命中缓存，得到标题 %q", got.Title)
+	}
+	var page dbutil.Page[Post]
+	do(t, srv, "GET", "/posts", "", nil, nil)
+	do(t, srv, "GET", "/posts?page=1", "", nil, &page)
+	stats := srv.posts.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.HitRate != 0.5 || stats.Posts != 1 || stats.Lists != 1 {
+		t.Errorf("统计不正确: %+v", stats)
+	}
+
+	// 点赞数随文章一起返回，点赞后缓存失效
+	do(t, srv, "PUT", path+"/like", token, nil, nil)
+	do(t, srv, "GET", path, "", nil, &got)
+	if got.LikeCount != 1 || got.Title != "changed" {
+		t.Errorf("点赞后应重新查询: %+v", got)
+	}
+	do(t, srv, "GET", "/posts", "", nil, &page)
+	if len(page.Items) != 1 || page.Items[0].LikeCount != 1 {
+		t.Errorf("点赞后列表应重新查询: %+v", page.Items)
+	}
+
+	// 缓存的是副本，当前用户的点赞状态不会影响其他人
+	do(t, srv, "GET", path, token, nil, &got)
+	if !got.LikedByMe {
+		t.Errorf("应标记 liked_by_me")
+	}
+	do(t, srv, "GET", path, "", nil, &got)
+	if got.LikedByMe {
+		t.Errorf("未登录时不应标记 liked_by_me")
+	}
+
+	// 删除后缓存失效
+	do(t, srv, "DELETE", path, token, nil, nil)
+	if code := do(t, srv, "GET", path, "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("删除后查询返回 %d，预期 404", code)
+	}
+
+	adminToken, admin := register(t, srv, "Admin")
+	db.Model(admin).Update("role", RoleAdmin)
+	if code := do(t, srv, "GET", "/cache/posts", token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("普通用户查询缓存统计返回 %d，预期 403", code)
+	}
+	var resp PostCacheStats
+	if code := do(t, srv, "GET", "/cache/posts", adminToken, nil, &resp); code != http.StatusOK || resp.Invalidations < 2 {
+		t.Errorf("缓存统计返回 %d: %+v", code, resp)
+	}
+}
+
+func TestServerCommentAntiSpam(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, user := register(t, srv, "Alice")
+	var post Post
+	do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, &post)
+	path := "/posts/" + itoa(post.ID) + "/comments"
+
+	t.Run("频率限制", func(t *testing.T) {
+		CommentAntiSpam = AntiSpamRules{PerMinute: 2}
+		var first Comment
+		for i := 0; i < 2; i++ {
+			if code := do(t, srv, "POST", path, token, commentRequest{Content: fmt.Sprint("c", i)}, &first); code != http.StatusCreated {
+				t.Fatalf("第 %d 条评论返回 %d", i+1, code)
+			}
+		}
+		// 删除评论不能绕过限制
+		do(t, srv, "DELETE", "/comments/"+itoa(first.ID), token, nil, nil)
+
+		body, _ := json.Marshal(commentRequest{Content: "c3"})
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("超过频率限制返回 %d，预期 429", rec.Code)
+		}
+		if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+			t.Errorf("Retry-After %q", rec.Header().Get("Retry-After"))
+		}
+
+		// 一分钟之前的评论不计入
+		db.Unscoped().Model(&Comment{}).Where("user_id = ?", user.ID).UpdateColumn("created_at", time.Now().Add(-2*time.Minute))
+		if code := do(t, srv, "POST", path, token, commentRequest{Content: "c3"}, nil); code != http.StatusCreated {
+			t.Errorf("限制过期后返回 %d", code)
+		}
+	})
+
+	t.Run("重复内容", func(t *testing.T) {
+		CommentAntiSpam = AntiSpamRules{DuplicateWindow: time.Minute}
+		var resp errorResponse
+		if code := do(t, srv, "POST", path, token, commentRequest{Content: "c3"}, &resp); code != http.StatusUnprocessableEntity || resp.Field != "content" {
+			t.Errorf("重复评论返回 %d %+v，预期 422", code, resp)
+		}
+		var comment Comment
+		do(t, srv, "POST", path, token, commentRequest{Content: "dup"}, &comment)
+		if code := do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/replies", token, commentRequest{Content: "dup"}, nil); code != http.StatusUnprocessableEntity {
+			t.Errorf("重复回复返回 %d，预期 422", code)
+		}
+	})
+
+	t.Run("垃圾评论检查", func(t *testing.T) {
+		CommentAntiSpam = AntiSpamRules{Checker: SpamCheckerFunc(func(ctx context.Context, c *Comment) error {
+			switch {
+			case strings.Contains(c.Content, "casino"):
+				return &SpamError{Reason: "广告"}
+			case strings.Contains(c.Content, "timeout"):
+				return errors.New("反垃圾服务超时")
+			}
+			return nil
+		})}
+		if code := do(t, srv, "POST", path, token, commentRequest{Content: "casino"}, nil); code != http.StatusUnprocessableEntity {
+			t.Errorf("垃圾评论返回 %d，预期 422", code)
+		}
+		var comment Comment
+		if code := do(t, srv, "POST", path, token, commentRequest{Content: "timeout"}, &comment); code != http.StatusCreated {
+			t.Fatalf("检查失败时返回 %d", code)
+		}
+		if comment.Status != CommentPending || comment.ModerationReason != "反垃圾检查失败" {
+			t.Errorf("检查失败时应进入待审核: %q %q", comment.Status, comment.ModerationReason)
+		}
+		if code := do(t, srv, "PUT", "/comments/"+itoa(comment.ID), token, commentRequest{Content: "casino"}, nil); code != http.StatusUnprocessableEntity {
+			t.Errorf("修改为垃圾评论返回 %d，预期 422", code)
+		}
+	})
+}
+
+// TestLoadAntiSpamRules 测试从环境变量读取反垃圾规则
+func TestLoadAntiSpamRules(t *testing.T) {
+	t.Setenv(CommentRateLimitEnv, "3")
+	t.Setenv(CommentDuplicateWindowEnv, "90s")
+	rules, err := LoadAntiSpamRules(AntiSpamRules{PerMinute: 5})
+	if err != nil {
+		t.Fatalf("LoadAntiSpamRules: %v", err)
+	}
+	if rules.PerMinute != 3 || rules.DuplicateWindow != 90*time.Second {
+		t.Errorf("规则不正确: %+v", rules)
+	}
+	t.Setenv(CommentDuplicateWindowEnv, "abc")
+	if _, err := LoadAntiSpamRules(AntiSpamRules{}); err == nil {
+		t.Error("无效的时长应返回错误")
+	}
+}
+
+func TestPostCountHooks(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, alice := register(t, srv, "Alice")
+	postCount := func() uint {
+		var user User
+		db.First(&user, alice.ID)
+		return user.PostCount
+	}
+
+	var posts [3]Post
+	for i := range posts {
+		do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c"}, &posts[i])
+	}
+	if postCount() != 3 {
+		t.Fatalf("创建后文章数 %d，预期 3", postCount())
+	}
+
+	// 软删除减少，恢复增加
+	do(t, srv, "DELETE", "/posts/"+itoa(posts[0].ID), token, nil, nil)
+	if postCount() != 2 {
+		t.Errorf("软删除后文章数 %d，预期 2", postCount())
+	}
+	do(t, srv, "POST", "/posts/"+itoa(posts[0].ID)+"/restore", token, nil, nil)
+	if postCount() != 3 {
+		t.Errorf("恢复后文章数 %d，预期 3", postCount())
+	}
+
+	// 物理删除未删除的文章减少；物理删除已软删除的文章不再重复减少
+	live := Post{ID: posts[1].ID, UserID: alice.ID}
+	if err := db.Unscoped().Delete(&live).Error; err != nil {
+		t.Fatalf("物理删除: %v", err)
+	}
+	if postCount() != 2 {
+		t.Errorf("物理删除后文章数 %d，预期 2", postCount())
+	}
+	do(t, srv, "DELETE", "/posts/"+itoa(posts[2].ID), token, nil, nil)
+	var deleted Post
+	db.Unscoped().Select("id", "user_id", "deleted_at").First(&deleted, posts[2].ID)
+	if err := db.Unscoped().Delete(&deleted).Error; err != nil {
+		t.Fatalf("物理删除已删除的文章: %v", err)
+	}
+	if postCount() != 1 {
+		t.Errorf("物理删除已软删除的文章后文章数 %d，预期 1", postCount())
+	}
+
+	// 修复计数偏差
+	db.Model(&User{}).Where("id = ?", alice.ID).UpdateColumn("post_count", 99)
+	adminToken, admin := register(t, srv, "Admin")
+	db.Model(admin).Update("role", RoleAdmin)
+	if code := do(t, srv, "POST", "/users/post-counts/recount", token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("普通用户修复计数返回 %d，预期 403", code)
+	}
+	var resp countResponse
+	if code := do(t, srv, "POST", "/users/post-counts/recount", adminToken, nil, &resp); code != http.StatusOK || resp.Count != 1 {
+		t.Errorf("修复计数返回 %d %+v", code, resp)
+	}
+	if postCount() != 1 {
+		t.Errorf("修复后文章数 %d，预期 1", postCount())
+	}
+	if fixed, err := RecountPostCounts(db); err != nil || fixed != 0 {
+		t.Errorf("没有偏差时修复了 %d 个用户: %v", fixed, err)
+	}
+}
+
+func TestServerPostFilter(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, alice := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	goTag, dbTag := Tag{Name: "go"}, Tag{Name: "db"}
+	db.Create(&goTag)
+	db.Create(&dbTag)
+	parent := Category{Name: "后端", Slug: "backend"}
+	db.Create(&parent)
+	child := Category{Name: "Go", Slug: "go", ParentID: &parent.ID}
+	db.Create(&child)
+
+	create := func(token string, tags []uint, category *uint, publishedAt string) uint {
+		var post Post
+		do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "c", TagIDs: tags, CategoryID: category}, &post)
+		at, _ := time.Parse(time.DateOnly, publishedAt)
+		db.Model(&Post{}).Where("id = ?", post.ID).UpdateColumn("published_at", at)
+		return post.ID
+	}
+	p1 := create(aliceToken, []uint{goTag.ID, dbTag.ID}, &child.ID, "2024-01-10")
+	p2 := create(aliceToken, []uint{goTag.ID}, &parent.ID, "2024-02-10")
+	p3 := create(bobToken, []uint{dbTag.ID}, nil, "2024-03-10")
+	var draft Post
+	do(t, srv, "POST", "/posts", aliceToken, createPostRequest{postRequest: postRequest{Title: "d", Content: "c"}, Draft: true}, &draft)
+
+	ids := func(query, token string) []uint {
+		t.Helper()
+		var page dbutil.Page[Post]
+		if code := do(t, srv, "GET", "/posts?"+query, token, nil, &page); code != http.StatusOK {
+			t.Fatalf("%s 返回 %d", query, code)
+		}
+		result := make([]uint, len(page.Items))
+		for i, p := range page.Items {
+			result[i] = p.ID
+		}
+		return result
+	}
+	tags := itoa(goTag.ID) + "," + itoa(dbTag.ID)
+	cases := []struct {
+		query string
+		want  []uint
+	}{
+		{"tag_ids=" + tags, []uint{p3, p2, p1}},
+		{"tag_ids=" + tags + "&tag_mode=all", []uint{p1}},
+		{"tag_ids=" + tags + "," + itoa(goTag.ID) + "&tag_mode=all", []uint{p1}},
+		{"user_id=" + itoa(alice.ID) + "&tag_ids=" + itoa(goTag.ID), []uint{p2, p1}},
+		{"category_id=" + itoa(parent.ID), []uint{p2, p1}},
+		{"category_id=" + itoa(parent.ID) + "&include_descendants=false", []uint{p2}},
+		{"from=2024-02-01&to=2024-03-10", []uint{p3, p2}},
+		{"to=2024-02-10T00:00:00Z", []uint{p1}},
+		{"user_id=" + itoa(bob.ID) + "&tag_ids=" + itoa(dbTag.ID) + "&from=2024-01-01", []uint{p3}},
+	}
+	for _, c := range cases {
+		if got := ids(c.query, ""); fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s 得到 %v，预期 %v", c.query, got, c.want)
+		}
+	}
+
+	t.Run("草稿", func(t *testing.T) {
+		if got := ids("user_id="+itoa(alice.ID)+"&status=draft", aliceToken); len(got) != 1 || got[0] != draft.ID {
+			t.Errorf("自己的草稿 %v", got)
+		}
+		if code := do(t, srv, "GET", "/posts?user_id="+itoa(alice.ID)+"&status=draft", bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("查询别人的草稿返回 %d，预期 403", code)
+		}
+	})
+
+	t.Run("参数错误", func(t *testing.T) {
+		for query, code := range map[string]int{
+			"tag_ids=a":                     http.StatusBadRequest,
+			"tag_mode=none&tag_ids=1":       http.StatusBadRequest,
+			"from=2024-03-01&to=2024-02-01": http.StatusBadRequest,
+			"from=yesterday":                http.StatusBadRequest,
+			"status=unknown&user_id=1":      http.StatusBadRequest,
+			"cursor=&tag_ids=1":             http.StatusBadRequest,
+			"category_id=999":               http.StatusNotFound,
+		} {
+			if got := do(t, srv, "GET", "/posts?"+query, aliceToken, nil, nil); got != code {
+				t.Errorf("%s 返回 %d，预期 %d", query, got, code)
+			}
+		}
+	})
+}
+
+func TestBlogBackup(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, _ := register(t, srv, "Bob")
+
+	// 源数据库中先创建一个无关的标签，使标签、分类和文章的 ID 与新数据库不同
+	tags := []Tag{{Name: "unused"}, {Name: "go"}, {Name: "db"}}
+	if err := db.Create(&tags).Error; err != nil {
+		t.Fatalf("create tags: %v", err)
+	}
+	var tech, golang Category
+	do(t, srv, "POST", "/categories", aliceToken, categoryRequest{Name: "技术", Slug: "tech"}, &tech)
+	do(t, srv, "POST", "/categories", aliceToken, categoryRequest{Name: "Go", Slug: "go", ParentID: &tech.ID}, &golang)
+
+	var post, deleted, draft Post
+	req := createPostRequest{postRequest: postRequest{Title: "GORM", Content: "c", CategoryID: &golang.ID, TagIDs: []uint{tags[1].ID, tags[2].ID}}}
+	do(t, srv, "POST", "/posts", aliceToken, req, &post)
+	do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "deleted", Content: "c"}, &deleted)
+	do(t, srv, "DELETE", "/posts/"+itoa(deleted.ID), aliceToken, nil, nil)
+	do(t, srv, "POST", "/posts", aliceToken, createPostRequest{postRequest: postRequest{Title: "draft", Content: "c"}, Draft: true}, &draft)
+
+	var comment, reply Comment
+	do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments", bobToken, commentRequest{Content: "好文"}, &comment)
+	do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/replies", aliceToken, commentRequest{Content: "谢谢"}, &reply)
+
+	var buf bytes.Buffer
+	if err := ExportBlog(db, &buf); err != nil {
+		t.Fatalf("ExportBlog: %v", err)
+	}
+	exported := buf.String()
+	// 密码哈希每次都不同，ID 和时间与运行环境有关，都不放入对比文件
+	testutil.AssertGoldenJSON(t, "blog_backup", buf.Bytes(),
+		testutil.IgnoreFields("password_hash"), testutil.NormalizeIDs(), testutil.NormalizeTimestamps())
+
+	// 新数据库中已有同名标签 db，导入时直接使用
+	target, targetDB := newTestServer(t)
+	existing := Tag{Name: "db"}
+	if err := targetDB.Create(&existing).Error; err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+	stats, err := ImportBlog(targetDB, strings.NewReader(exported))
+	if err != nil {
+		t.Fatalf("ImportBlog: %v", err)
+	}
+	if want := (ImportStats{Users: 2, Tags: 2, Categories: 2, Posts: 3, Comments: 2}); stats != want {
+		t.Errorf("导入数量 %+v，预期 %+v", stats, want)
+	}
+
+	// 用原来的密码登录
+	var login tokenResponse
+	if code := do(t, target, "POST", "/auth/login", "", loginRequest{Email: "alice@example.com", Password: "correct horse"}, &login); code != http.StatusOK {
+		t.Fatalf("导入后登录返回 %d", code)
+	}
+	var user User
+	targetDB.First(&user, login.User.ID)
+	if user.PostCount != 2 {
+		t.Errorf("导入后文章数 %d，预期 2（不含回收站中的文章）", user.PostCount)
+	}
+
+	var posts []Post
+	targetDB.Unscoped().Preload("Category").Preload("Tags", func(db *gorm.DB) *gorm.DB { return db.Order("name") }).
+		Order("id").Find(&posts)
+	if len(posts) != 3 {
+		t.Fatalf("导入后文章 %d 篇，预期 3", len(posts))
+	}
+	imported := posts[0]
+	if imported.UserID != login.User.ID || !imported.CreatedAt.Equal(post.CreatedAt) {
+		t.Errorf("文章的作者或创建时间不正确: %+v", imported)
+	}
+	if imported.Category == nil || imported.Category.Slug != "go" {
+		t.Errorf("文章的分类不正确: %+v", imported.Category)
+	}
+	if len(imported.Tags) != 2 || imported.Tags[0].Name != "db" || imported.Tags[0].ID != existing.ID || imported.Tags[1].Name != "go" {
+		t.Errorf("文章的标签不正确: %+v", imported.Tags)
+	}
+	if !posts[1].DeletedAt.Valid || posts[2].Status != PostDraft {
+		t.Errorf("回收站中的文章和草稿应保持原状态: %+v %+v", posts[1].DeletedAt, posts[2].Status)
+	}
+	var category Category
+	targetDB.First(&category, *imported.CategoryID)
+	var parent Category
+	if category.ParentID == nil || targetDB.First(&parent, *category.ParentID).Error != nil || parent.Slug != "tech" {
+		t.Errorf("分类的上级不正确: %+v", category.ParentID)
+	}
+
+	var comments []Comment
+	targetDB.Order("id").Find(&comments)
+	if len(comments) != 2 || comments[0].PostID != imported.ID || comments[1].ParentID == nil ||
+		*comments[1].ParentID != comments[0].ID || comments[1].UserID != login.User.ID {
+		t.Errorf("评论的关联不正确: %+v", comments)
+	}
+
+	// 只能导入到空数据库，失败时不修改数据
+	if _, err := ImportBlog(targetDB, strings.NewReader(exported)); !errors.Is(err, ErrDatabaseNotEmpty) {
+		t.Errorf("重复导入应返回 ErrDatabaseNotEmpty: %v", err)
+	}
+	_, emptyDB := newTestServer(t)
+	versioned := strings.Replace(exported, `"version": 1`, `"version": 2`, 1)
+	if _, err := ImportBlog(emptyDB, strings.NewReader(versioned)); err == nil {
+		t.Error("不支持的版本应返回错误")
+	}
+	// 评论的文章不在备份中时整体回滚，已创建的用户和文章也不保留
+	var backup Backup
+	if err := json.Unmarshal([]byte(exported), &backup); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	backup.Comments[0].PostID = 9999
+	broken, _ := json.Marshal(backup)
+	if _, err := ImportBlog(emptyDB, bytes.NewReader(broken)); err == nil {
+		t.Error("关联的文章不在备份中应返回错误")
+	}
+	var count int64
+	emptyDB.Model(&User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("导入失败后不应有用户: %d", count)
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	got := ParseMentions("@张三 你好，@Bob_1: 请看 a@example.com 和 @张三、(@Carol)")
+	if want := []string{"张三", "Bob_1", "Carol"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ParseMentions = %v，预期 %v", got, want)
+	}
+	var many strings.Builder
+	for i := 0; i < MaxMentions+5; i++ {
+		fmt.Fprintf(&many, "@u%d ", i)
+	}
+	if got := ParseMentions(many.String()); len(got) != MaxMentions {
+		t.Errorf("最多解析 %d 个用户名，实际 %d 个", MaxMentions, len(got))
+	}
+}
+
+func TestServerMentions(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	carolToken, carol := register(t, srv, "Carol")
+	daveToken, _ := register(t, srv, "Dave")
+	erinToken, erin := register(t, srv, "Erin")
+	// 同名的用户无法确定提及的是谁，不会被提及
+	register(t, srv, "Twin")
+	if err := db.Create(&User{Name: "Twin", Email: "twin2@example.com"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	var post Post
+	do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c"}, &post)
+	mentions := func(t *testing.T, token string) int {
+		t.Helper()
+		var page dbutil.Page[Notification]
+		do(t, srv, "GET", "/notifications", token, nil, &page)
+		n := 0
+		for _, item := range page.Items {
+			if item.Type == NotifyMention {
+				n++
+			}
+		}
+		return n
+	}
+
+	var comment Comment
+	content := "@Carol @Dave <b>看看</b> @Alice @Bob @Twin @Nobody bob@example.com"
+	if code := do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments?format=html", bobToken, commentRequest{Content: content}, &comment); code != http.StatusCreated {
+		t.Fatalf("发表评论返回 %d", code)
+	}
+	if mentions(t, carolToken) != 1 || mentions(t, daveToken) != 1 {
+		t.Error("被提及的用户应收到提及通知")
+	}
+	// 文章作者已经收到评论通知，不再收到提及通知；提及自己和同名用户都会忽略
+	if mentions(t, aliceToken) != 0 || mentions(t, bobToken) != 0 {
+		t.Error("文章作者和评论作者不应收到提及通知")
+	}
+	var count int64
+	db.Model(&Mention{}).Where("comment_id = ?", comment.ID).Count(&count)
+	if count != 3 {
+		t.Errorf("提及记录 %d 条，预期 3（Carol、Dave、Alice）", count)
+	}
+
+	link := fmt.Sprintf(`<a href="/posts?user_id=%d" class="mention">@Carol</a>`, carol.ID)
+	if !strings.Contains(comment.ContentHTML, link) || !strings.Contains(comment.ContentHTML, "&lt;b&gt;") ||
+		!strings.Contains(comment.ContentHTML, " @Twin @Nobody bob@example.com") {
+		t.Errorf("渲染结果不正确: %s", comment.ContentHTML)
+	}
+
+	t.Run("查询评论", func(t *testing.T) {
+		var page dbutil.Page[Comment]
+		do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments", "", nil, &page)
+		if len(page.Items) != 1 || page.Items[0].ContentHTML != "" {
+			t.Fatalf("默认不返回 content_html: %+v", page.Items)
+		}
+		do(t, srv, "POST", "/comments/"+itoa(comment.ID)+"/replies", carolToken, commentRequest{Content: "@Bob 好的"}, nil)
+		do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments?format=html", "", nil, &page)
+		replies := page.Items[0].Replies
+		want := fmt.Sprintf(`<a href="/posts?user_id=%d" class="mention">@Bob</a> 好的`, bob.ID)
+		if len(replies) != 1 || replies[0].ContentHTML != want {
+			t.Errorf("回复的渲染结果不正确: %+v", replies)
+		}
+		if code := do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments?format=pdf", "", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("不支持的 format 返回 %d，预期 400", code)
+		}
+	})
+
+	t.Run("修改评论", func(t *testing.T) {
+		do(t, srv, "PUT", "/comments/"+itoa(comment.ID), bobToken, commentRequest{Content: "@Carol @Erin"}, nil)
+		// 新提及的用户收到通知，已经通知过的不重复通知
+		if mentions(t, erinToken) != 1 || mentions(t, carolToken) != 1 {
+			t.Error("修改评论后只通知新提及的用户")
+		}
+		var userIDs []uint
+		db.Model(&Mention{}).Where("comment_id = ?", comment.ID).Order("user_id").Pluck("user_id", &userIDs)
+		if len(userIDs) != 2 || userIDs[0] != carol.ID || userIDs[1] != erin.ID {
+			t.Errorf("修改后的提及记录 %v", userIDs)
+		}
+	})
+}
+
+func TestServerReports(t *testing.T) {
+	srv, db := newTestServer(t)
+	aliceToken, _ := register(t, srv, "Alice")
+	bobToken, bob := register(t, srv, "Bob")
+	carolToken, _ := register(t, srv, "Carol")
+	modToken, moderator := register(t, srv, "Mod")
+	if err := db.Model(moderator).Update("role", RoleModerator).Error; err != nil {
+		t.Fatalf("设置审核员: %v", err)
+	}
+
+	var post, spam Post
+	do(t, srv, "POST", "/posts", aliceToken, postRequest{Title: "t", Content: "c"}, &post)
+	do(t, srv, "POST", "/posts", bobToken, postRequest{Title: "spam", Content: "c"}, &spam)
+	var comment Comment
+	do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments", bobToken, commentRequest{Content: "广告"}, &comment)
+
+	report := func(t *testing.T, token, path, reason string) (Report, int) {
+		t.Helper()
+		var r Report
+		code := do(t, srv, "POST", path+"/reports", token, reportRequest{Reason: reason}, &r)
+		return r, code
+	}
+
+	var postReport, commentReport Report
+	t.Run("举报", func(t *testing.T) {
+		var code int
+		if postReport, code = report(t, aliceToken, "/posts/"+itoa(spam.ID), "垃圾内容"); code != http.StatusCreated {
+			t.Fatalf("举报文章返回 %d", code)
+		}
+		if postReport.AuthorID != bob.ID || postReport.Status != ReportOpen {
+			t.Errorf("举报不正确: %+v", postReport)
+		}
+		if _, code = report(t, aliceToken, "/posts/"+itoa(spam.ID), "再次举报"); code != http.StatusConflict {
+			t.Errorf("重复举报返回 %d，预期 409", code)
+		}
+		if _, code = report(t, carolToken, "/posts/"+itoa(spam.ID), "垃圾内容"); code != http.StatusCreated {
+			t.Errorf("其他用户举报返回 %d", code)
+		}
+		if _, code = report(t, aliceToken, "/posts/"+itoa(post.ID), "自己的"); code != http.StatusBadRequest {
+			t.Errorf("举报自己的内容返回 %d，预期 400", code)
+		}
+		if _, code = report(t, aliceToken, "/posts/"+itoa(spam.ID), " "); code != http.StatusBadRequest {
+			t.Errorf("理由为空返回 %d，预期 400", code)
+		}
+		if _, code = report(t, aliceToken, "/comments/9999", "x"); code != http.StatusNotFound {
+			t.Errorf("举报不存在的评论返回 %d，预期 404", code)
+		}
+		if commentReport, code = report(t, carolToken, "/comments/"+itoa(comment.ID), "广告"); code != http.StatusCreated {
+			t.Fatalf("举报评论返回 %d", code)
+		}
+	})
+
+	t.Run("查询举报", func(t *testing.T) {
+		if code := do(t, srv, "GET", "/moderation/reports", aliceToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("普通用户查询举报返回 %d，预期 403", code)
+		}
+		var page dbutil.Page[Report]
+		do(t, srv, "GET", "/moderation/reports", modToken, nil, &page)
+		if page.Total != 3 || page.Items[0].ID != postReport.ID || page.Items[0].Reporter == nil {
+			t.Errorf("待处理的举报不正确: %+v", page)
+		}
+	})
+
+	t.Run("驳回", func(t *testing.T) {
+		var dismissed Report
+		path := "/moderation/reports/" + itoa(commentReport.ID)
+		if code := do(t, srv, "POST", path+"/dismiss", modToken, noteRequest{Note: "正常评论"}, &dismissed); code != http.StatusOK {
+			t.Fatalf("驳回举报返回 %d", code)
+		}
+		if dismissed.Status != ReportDismissed || dismissed.HandledBy == nil || *dismissed.HandledBy != moderator.ID {
+			t.Errorf("驳回后的举报不正确: %+v", dismissed)
+		}
+		if code := do(t, srv, "POST", path+"/dismiss", modToken, noteRequest{}, nil); code != http.StatusBadRequest {
+			t.Errorf("重复处理返回 %d，预期 400", code)
+		}
+		var page dbutil.Page[Comment]
+		do(t, srv, "GET", "/posts/"+itoa(post.ID)+"/comments", "", nil, &page)
+		if page.Total != 1 {
+			t.Errorf("驳回举报后评论应保留: %d", page.Total)
+		}
+	})
+
+	t.Run("隐藏并封禁", func(t *testing.T) {
+		path := "/moderation/reports/" + itoa(postReport.ID) + "/resolve"
+		if code := do(t, srv, "POST", path, modToken, resolveReportRequest{noteRequest: noteRequest{Note: "x"}}, nil); code != http.StatusBadRequest {
+			t.Errorf("没有选择动作返回 %d，预期 400", code)
+		}
+		if code := do(t, srv, "POST", path, modToken, resolveReportRequest{Hide: true}, nil); code != http.StatusBadRequest {
+			t.Errorf("没有填写说明返回 %d，预期 400", code)
+		}
+		var resolved Report
+		req := resolveReportRequest{noteRequest: noteRequest{Note: "发布广告"}, Hide: true, Suspend: true}
+		if code := do(t, srv, "POST", path, modToken, req, &resolved); code != http.StatusOK {
+			t.Fatalf("处理举报返回 %d", code)
+		}
+		if resolved.Status != ReportResolved || resolved.Resolution != "发布广告" {
+			t.Errorf("处理后的举报不正确: %+v", resolved)
+		}
+		// 同一文章的其他举报一并处理
+		var open int64
+		db.Model(&Report{}).Where("status = ?", ReportOpen).Count(&open)
+		if open != 0 {
+			t.Errorf("还有 %d 条待处理的举报", open)
+		}
+		if code := do(t, srv, "GET", "/posts/"+itoa(spam.ID), aliceToken, nil, nil); code != http.StatusNotFound {
+			t.Errorf("隐藏的文章返回 %d，预期 404", code)
+		}
+		// 封禁后已有的 token 和登录都返回 403
+		if code := do(t, srv, "GET", "/me", bobToken, nil, nil); code != http.StatusForbidden {
+			t.Errorf("封禁后访问返回 %d，预期 403", code)
+		}
+		login := loginRequest{Email: "bob@example.com", Password: "correct horse"}
+		if code := do(t, srv, "POST", "/auth/login", "", login, nil); code != http.StatusForbidden {
+			t.Errorf("封禁后登录返回 %d，预期 403", code)
+		}
+		if code := do(t, srv, "POST", "/auth/login", "", loginRequest{Email: login.Email, Password: "wrong password"}, nil); code != http.StatusUnauthorized {
+			t.Errorf("密码错误时返回 %d，预期 401", code)
+		}
+
+		// 解除封禁后可以登录，但隐藏的文章不能重新发布
+		if code := do(t, srv, "DELETE", "/users/"+itoa(bob.ID)+"/suspension", modToken, noteRequest{Note: "申诉通过"}, nil); code != http.StatusNoContent {
+			t.Fatalf("解除封禁返回 %d", code)
+		}
+		var resp tokenResponse
+		if code := do(t, srv, "POST", "/auth/login", "", login, &resp); code != http.StatusOK {
+			t.Fatalf("解除封禁后登录返回 %d", code)
+		}
+		var hidden Post
+		if code := do(t, srv, "GET", "/posts/"+itoa(spam.ID), resp.Token, nil, &hidden); code != http.StatusOK || hidden.Status != PostHidden {
+			t.Errorf("作者查看隐藏的文章返回 %d，状态 %s", code, hidden.Status)
+		}
+		if code := do(t, srv, "POST", "/posts/"+itoa(spam.ID)+"/publish", resp.Token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("重新发布隐藏的文章返回 %d，预期 400", code)
+		}
+	})
+
+	t.Run("不能封禁审核员", func(t *testing.T) {
+		var modComment Comment
+		do(t, srv, "POST", "/posts/"+itoa(post.ID)+"/comments", modToken, commentRequest{Content: "审核员的评论"}, &modComment)
+		r, _ := report(t, carolToken, "/comments/"+itoa(modComment.ID), "x")
+		req := resolveReportRequest{noteRequest: noteRequest{Note: "x"}, Hide: true, Suspend: true}
+		if code := do(t, srv, "POST", "/moderation/reports/"+itoa(r.ID)+"/resolve", modToken, req, nil); code != http.StatusBadRequest {
+			t.Errorf("封禁审核员返回 %d，预期 400", code)
+		}
+		// 事务回滚，评论没有被隐藏
+		var status string
+		db.Model(&Comment{}).Where("id = ?", modComment.ID).Pluck("status", &status)
+		if status != CommentApproved {
+			t.Errorf("封禁失败后评论状态 %s", status)
+		}
+	})
+
+	t.Run("审核日志", func(t *testing.T) {
+		var page dbutil.Page[ModerationLog]
+		do(t, srv, "GET", "/moderation/logs", modToken, nil, &page)
+		var actions []string
+		for _, l := range page.Items {
+			actions = append(actions, l.Action)
+		}
+		want := []string{ActionUnsuspendUser, ActionSuspendUser, ActionHidePost, ActionDismissReport}
+		if strings.Join(actions, ",") != strings.Join(want, ",") {
+			t.Errorf("审核日志 %v，预期 %v", actions, want)
+		}
+		if l := page.Items[1]; l.TargetID != bob.ID || l.ReportID == nil || *l.ReportID != postReport.ID || l.Moderator == nil {
+			t.Errorf("封禁日志不正确: %+v", l)
+		}
+	})
+}
+
+func TestMeasureContent(t *testing.T) {
+	cases := []struct {
+		content string
+		want    ContentStats
+	}{
+		{"", ContentStats{}},
+		{"  \n", ContentStats{}},
+		{"Hello, GORM v2!", ContentStats{Words: 3, Chars: 13, ReadingMinutes: 1}},
+		{"学习 GORM 的关联", ContentStats{Words: 6, Chars: 9, ReadingMinutes: 1}},
+		{strings.Repeat("字", 301), ContentStats{Words: 301, Chars: 301, ReadingMinutes: 2}},
+		{strings.Repeat("word ", 400), ContentStats{Words: 400, Chars: 1600, ReadingMinutes: 2}},
+	}
+	for _, c := range cases {
+		if got := MeasureContent(c.content); got != c.want {
+			t.Errorf("MeasureContent(%.20q) = %+v，预期 %+v", c.content, got, c.want)
+		}
+	}
+}
+
+func TestServerPostContentStats(t *testing.T) {
+	srv, db := newTestServer(t)
+	token, _ := register(t, srv, "Alice")
+
+	var post Post
+	do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "学习 GORM"}, &post)
+	if post.WordCount != 3 || post.CharCount != 6 || post.ReadingMinutes != 1 {
+		t.Fatalf("创建后的字数统计不正确: %d %d %d", post.WordCount, post.CharCount, post.ReadingMinutes)
+	}
+
+	// 修改内容时重新计算，只修改状态等其他字段时保留
+	var updated Post
+	do(t, srv, "PUT", "/posts/"+itoa(post.ID), token, postRequest{Title: "t", Content: strings.Repeat("字", 600)}, &updated)
+	if updated.WordCount != 600 || updated.ReadingMinutes != 2 {
+		t.Errorf("修改后的字数统计不正确: %d %d", updated.WordCount, updated.ReadingMinutes)
+	}
+	do(t, srv, "PUT", "/posts/"+itoa(post.ID)+"/like", token, nil, nil)
+	if err := db.Model(&Post{ID: post.ID}).Update("status", PostPublished).Error; err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	srv.posts.InvalidatePost(post.ID)
+
+	var page dbutil.Page[Post]
+	do(t, srv, "GET", "/posts", "", nil, &page)
+	if len(page.Items) != 1 || page.Items[0].WordCount != 600 || page.Items[0].CharCount != 600 {
+		t.Errorf("列表中的字数统计不正确: %+v", page.Items)
+	}
+
+	// 回填：模拟添加字段之前创建的文章
+	var other Post
+	do(t, srv, "POST", "/posts", token, postRequest{Title: "t", Content: "one two"}, &other)
+	err := db.Model(&Post{}).Where("id IN ?", []uint{post.ID, other.ID}).
+		UpdateColumns(map[string]interface{}{"word_count": 0, "char_count": 0, "reading_minutes": 0}).Error
+	if err != nil {
+		t.Fatalf("reset stats: %v", err)
+	}
+	if n, err := BackfillContentStats(db, 1); err != nil || n != 2 {
+		t.Fatalf("BackfillContentStats = %d, %v，预期更新 2 篇", n, err)
+	}
+	var backfilled Post
+	db.First(&backfilled, other.ID)
+	if backfilled.WordCount != 2 || backfilled.CharCount != 6 || !backfilled.UpdatedAt.Equal(other.UpdatedAt) {
+		t.Errorf("回填结果不正确: %+v", backfilled)
+	}
+	if n, _ := BackfillContentStats(db, 0); n != 0 {
+		t.Errorf("再次回填更新了 %d 篇，预期 0", n)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UploadDirEnv 本地存储附件的目录，默认 uploads
+const UploadDirEnv = "BLOG_UPLOAD_DIR"
+
+// ErrStorageKeyInvalid 存储 key 不合法（绝对路径、包含 .. 等）
+var ErrStorageKeyInvalid = errors.New("存储 key 不合法")
+
+// Storage 附件文件的存储，key 是以 / 分隔的相对路径，由 newStorageKey 生成
+// 本地磁盘使用 LocalStorage，对象存储（如 S3）实现同样的接口即可替换
+type Storage interface {
+	// Put 写入 key 的内容，key 已存在时覆盖；写入失败时不能留下不完整的文件
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open 读取 key 的内容，不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除 key，不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 遍历所有 key 及其最后修改时间，fn 返回错误时停止遍历并返回该错误
+	List(ctx context.Context, fn func(key string, modTime time.Time) error) error
+}
+
+// LocalStorage 把文件保存在本地目录 Root 下，key 对应 Root 下的相对路径
+type LocalStorage struct {
+	Root string
+}
+
+// tempPrefix 写入过程中的临时文件前缀，List 会跳过这些文件
+const tempPrefix = ".tmp-"
+
+// path 把 key 转换为本地路径，拒绝会跳出 Root 的 key
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, `\`) || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("%w: %q", ErrStorageKeyInvalid, key)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put 先写入同目录下的临时文件，完成后重命名，读取方不会看到写了一半的文件
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // 重命名成功后删除会失败，忽略即可
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: r}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List 遍历 Root 下的所有文件，Root 不存在时视为空
+func (s *LocalStorage) List(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	err := filepath.WalkDir(s.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info.ModTime())
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// contextReader 在每次读取前检查 ctx，客户端断开后停止写入
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
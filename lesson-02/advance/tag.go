@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"strings"
+)
+
+var (
+	// ErrTagNotFound 标签不存在
+	ErrTagNotFound = errors.New("标签不存在")
+	// ErrTagNameTaken 标签名已被其他标签使用
+	ErrTagNameTaken = errors.New("标签名已被使用")
+)
+
+// TagWithCount 标签及使用它的文章数量
+type TagWithCount struct {
+	Tag
+	PostCount int64 `json:"post_count"`
+}
+
+// 查询所有标签及使用它们的文章数量（不含已删除和未发布的文章），按名称排序
+func ListTags(db *gorm.DB) ([]TagWithCount, error) {
+	postCount := db.Session(&gorm.Session{NewDB: true}).
+		Table("post_tags").
+		Select("COUNT(*)").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = ?", PostPublished).
+		Where("post_tags.tag_id = tags.id")
+
+	var tags []TagWithCount
+	err := db.Model(&Tag{}).
+		Select("tags.*, (?) AS post_count", postCount).
+		Order("name ASC").Order("id ASC").
+		Find(&tags).Error
+	return tags, err
+}
+
+// 重命名标签，标签不存在时返回 ErrTagNotFound，新名称被其他标签使用时返回 ErrTagNameTaken
+func RenameTag(db *gorm.DB, tagID uint, name string) (*Tag, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{Field: "name", Message: "不能为空"}
+	}
+
+	var tag Tag
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if err := tx.First(&tag, tagID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTagNotFound
+			}
+			return err
+		}
+		var count int64
+		if err := tx.Model(&Tag{}).Where("name = ? AND id <> ?", name, tagID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTagNameTaken
+		}
+		tag.Name = name
+		return tx.Model(&tag).Update("name", name).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+/*
+MergeTags 把标签 source 合并到 target：使用 source 的文章改为使用 target，然后删除 source
+同时使用两个标签的文章只保留一条关联，不会重复
+参数：
+  - db: GORM 数据库连接
+  - sourceID: 被合并的标签，合并后删除
+  - targetID: 保留的标签
+
+返回值：
+  - *TagWithCount: 合并后的 target 及使用它的文章数量
+  - error: 标签不存在时返回 ErrTagNotFound，两个标签相同时返回 ValidationError
+*/
+func MergeTags(db *gorm.DB, sourceID, targetID uint) (*TagWithCount, error) {
+	if sourceID == targetID {
+		return nil, &ValidationError{Field: "into", Message: "不能合并到标签自身"}
+	}
+
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		for _, id := range []uint{sourceID, targetID} {
+			if err := ensureExists(tx, &Tag{}, id, ErrTagNotFound); err != nil {
+				return err
+			}
+		}
+		// 已经有 target 的文章不再插入，避免违反 post_tags 的主键
+		err := tx.Exec(`
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, ? FROM post_tags
+			WHERE tag_id = ? AND post_id NOT IN (SELECT post_id FROM post_tags WHERE tag_id = ?)`,
+			targetID, sourceID, targetID).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM post_tags WHERE tag_id = ?", sourceID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Tag{}, sourceID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := ListTags(db.Where("tags.id = ?", targetID))
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, ErrTagNotFound
+	}
+	return &tags[0], nil
+}
+
+// 删除没有任何文章使用的标签，返回被删除的标签
+// 被已删除（可恢复）的文章使用的标签不会删除，post_tags 中的关联始终有效
+func DeleteOrphanTags(db *gorm.DB) ([]Tag, error) {
+	var tags []Tag
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		used := tx.Session(&gorm.Session{NewDB: true}).Table("post_tags").Select("tag_id")
+		if err := tx.Where("id NOT IN (?)", used).Order("id ASC").Find(&tags).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(tags))
+		for i := range tags {
+			ids[i] = tags[i].ID
+		}
+		return tx.Delete(&Tag{}, ids).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = []Tag{} // 序列化为 [] 而不是 null
+	}
+	return tags, nil
+}
+
+// 相关文章的数量限制
+const (
+	DefaultRelatedLimit = 5
+	MaxRelatedLimit     = 20
+)
+
+// RelatedPost 相关文章及与原文章相同的标签数量
+type RelatedPost struct {
+	Post
+	SharedTags int64 `json:"shared_tags"`
+}
+
+/*
+GetRelatedPosts 查询与文章有相同标签的其他文章（含作者、分类和标签）
+按相同标签的数量从多到少排列，数量相同时新发布的在前；没有相同标签的文章不返回
+通过 post_tags 自连接聚合出每篇文章的相同标签数，只查询一次，不需要加载所有文章
+参数：
+  - db: GORM 数据库连接
+  - postID: 原文章
+  - limit: 最多返回多少篇，<= 0 时使用 DefaultRelatedLimit，最多 MaxRelatedLimit
+
+返回值：
+  - []RelatedPost: 相关文章，不含原文章、已删除和未发布的文章
+  - error: 原文章不存在或已删除时返回 ErrPostNotFound
+*/
+func GetRelatedPosts(db *gorm.DB, postID uint, limit int) ([]RelatedPost, error) {
+	if limit <= 0 {
+		limit = DefaultRelatedLimit
+	}
+	if limit > MaxRelatedLimit {
+		limit = MaxRelatedLimit
+	}
+	if err := ensureExists(db, &Post{}, postID, ErrPostNotFound); err != nil {
+		return nil, err
+	}
+
+	shared := db.Session(&gorm.Session{NewDB: true}).
+		Table("post_tags AS source").
+		Select("other.post_id, COUNT(*) AS shared_tags").
+		Joins("JOIN post_tags AS other ON other.tag_id = source.tag_id AND other.post_id <> source.post_id").
+		Where("source.post_id = ?", postID).
+		Group("other.post_id")
+
+	posts := []RelatedPost{}
+	err := db.Model(&Post{}).Scopes(publishedPosts).
+		Select("posts.*, related.shared_tags").
+		Joins("JOIN (?) AS related ON related.post_id = posts.id", shared).
+		Preload("User").Preload("Category").Preload("Tags").
+		Order("related.shared_tags DESC").Order("posts.published_at DESC").Order("posts.id DESC").
+		Limit(limit).
+		Find(&posts).Error
+	return posts, err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DefaultTrashRetention 回收站中的文章和评论默认保留 30 天，之后由 PurgeTrash 彻底删除
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DeletedPost 回收站中的文章，Post.DeletedAt 不输出到 JSON，这里单独返回删除时间
+type DeletedPost struct {
+	Post
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// TrashPurge PurgeTrash 彻底删除的文章和评论数量
+type TrashPurge struct {
+	Posts    int64 `json:"posts"`
+	Comments int64 `json:"comments"`
+}
+
+// 分页查询已软删除的文章（含分类和标签），最近删除的在前
+// 参数 userID: 只查询该用户的文章，为 0 时查询所有用户的
+func ListDeletedPosts(db *gorm.DB, userID uint, page, size int) (dbutil.Page[DeletedPost], error) {
+	var posts []Post
+	query := db.Unscoped().Model(&Post{}).Where("deleted_at IS NOT NULL")
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	query = query.Preload("Category").Preload("Tags").Order("deleted_at DESC").Order("id DESC")
+	total, err := dbutil.FindWithCount(query, &posts, dbutil.Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[DeletedPost]{}, err
+	}
+	items := make([]DeletedPost, len(posts))
+	for i, post := range posts {
+		items[i] = DeletedPost{Post: post, DeletedAt: post.DeletedAt.Time}
+	}
+	return dbutil.NewPage(items, total, page, size), nil
+}
+
+// 恢复已软删除的文章并增加作者的文章数量，文章不存在或未删除时返回 ErrPostNotFound
+func RestorePost(db *gorm.DB, postID uint) (*Post, error) {
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var post Post
+		err := tx.Unscoped().Select("id", "user_id").Where("deleted_at IS NOT NULL").First(&post, postID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPostNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&post).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return adjustPostCount(tx, post.UserID, 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetPost(db, postID)
+}
+
+// 恢复已软删除的评论，评论不存在或未删除时返回 ErrCommentNotFound；
+// 所属文章已删除时返回 ErrPostNotFound，回复的评论已删除时返回 ValidationError（需要先恢复上级评论）
+func RestoreComment(db *gorm.DB, commentID uint) (*Comment, error) {
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		var comment Comment
+		err := tx.Unscoped().Select("id", "post_id", "parent_id").Where("deleted_at IS NOT NULL").First(&comment, commentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := ensureExists(tx, &Post{}, comment.PostID, ErrPostNotFound); err != nil {
+			return err
+		}
+		if comment.ParentID != nil {
+			err := ensureExists(tx, &Comment{}, *comment.ParentID, ErrCommentNotFound)
+			if errors.Is(err, ErrCommentNotFound) {
+				return &ValidationError{Field: "parent_id", Message: "回复的评论已删除，请先恢复上级评论"}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Model(&comment).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	var comment Comment
+	if err := db.Preload("User").First(&comment, commentID).Error; err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+/*
+PurgeTrash 彻底删除在回收站中超过 olderThan 的文章和评论，所有删除在同一事务中完成
+删除文章时同时删除它的全部评论（包括未删除的）、标签关联、点赞、浏览统计和通知；
+附件不在这里删除，文章删除后它们成为孤儿附件，由 CleanupOrphanAttachments 清理文件。
+单独删除的评论还有未彻底删除的回复时保留，等回复也被清理后再删除，避免违反 parent_id 外键
+参数：
+  - db: GORM 数据库连接
+  - olderThan: 删除时间早于这个时长的才会被清理，<= 0 时使用 DefaultTrashRetention
+
+返回值：
+  - TrashPurge: 彻底删除的文章和评论数量
+  - error: 删除失败时返回，事务回滚
+*/
+func PurgeTrash(db *gorm.DB, olderThan time.Duration) (TrashPurge, error) {
+	if olderThan <= 0 {
+		olderThan = DefaultTrashRetention
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	var purged TrashPurge
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		purged = TrashPurge{}
+		var postIDs []uint
+		err := tx.Unscoped().Model(&Post{}).Where("deleted_at < ?", cutoff).Pluck("id", &postIDs).Error
+		if err != nil {
+			return err
+		}
+
+		// 待删除的评论：这些文章的全部评论，以及删除时间足够早的评论
+		candidates := tx.Unscoped().Model(&Comment{}).Where("deleted_at < ?", cutoff)
+		if len(postIDs) > 0 {
+			candidates = candidates.Or("post_id IN ?", postIDs)
+		}
+		var comments []Comment
+		if err := candidates.Select("id", "parent_id").Find(&comments).Error; err != nil {
+			return err
+		}
+		if purged.Comments, err = purgeComments(tx, comments); err != nil {
+			return err
+		}
+
+		if len(postIDs) == 0 {
+			return nil
+		}
+		cleanups := []struct {
+			model interface{}
+			where string
+			args  []interface{}
+		}{
+			{&Like{}, "target_type = ? AND target_id IN ?", []interface{}{LikeTargetPost, postIDs}},
+			{&PostView{}, "post_id IN ?", []interface{}{postIDs}},
+			{&Notification{}, "post_id IN ?", []interface{}{postIDs}},
+		}
+		for _, c := range cleanups {
+			if err := tx.Where(c.where, c.args...).Delete(c.model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("DELETE FROM post_tags WHERE post_id IN ?", postIDs).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Delete(&Post{}, postIDs)
+		purged.Posts = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return TrashPurge{}, err
+	}
+	return purged, nil
+}
+
+// purgeComments 彻底删除 candidates 中的评论及其点赞、通知和提及记录，返回删除的数量
+// 有不在 candidates 中的回复的评论不删除；按层从回复到上级评论依次删除，每条语句都满足 parent_id 外键
+func purgeComments(tx *gorm.DB, candidates []Comment) (int64, error) {
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	ids := make([]uint, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.ID
+	}
+	var replies []Comment
+	if err := tx.Unscoped().Select("id", "parent_id").Where("parent_id IN ?", ids).Find(&replies).Error; err != nil {
+		return 0, err
+	}
+	children := make(map[uint][]uint)
+	for _, reply := range replies {
+		children[*reply.ParentID] = append(children[*reply.ParentID], reply.ID)
+	}
+
+	// 可以删除：在 candidates 中，并且所有回复都可以删除
+	remaining := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		remaining[id] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for id := range remaining {
+			for _, child := range children[id] {
+				if !remaining[child] {
+					delete(remaining, id)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var deleted int64
+	for len(remaining) > 0 {
+		// 本层：回复都已删除的评论
+		var layer []uint
+		for id := range remaining {
+			leaf := true
+			for _, child := range children[id] {
+				if remaining[child] {
+					leaf = false
+					break
+				}
+			}
+			if leaf {
+				layer = append(layer, id)
+			}
+		}
+		if err := tx.Where("target_type = ? AND target_id IN ?", LikeTargetComment, layer).Delete(&Like{}).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Where("comment_id IN ?", layer).Delete(&Notification{}).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Where("comment_id IN ?", layer).Delete(&Mention{}).Error; err != nil {
+			return 0, err
+		}
+		result := tx.Unscoped().Delete(&Comment{}, layer)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		deleted += result.RowsAffected
+		for _, id := range layer {
+			delete(remaining, id)
+		}
+	}
+	return deleted, nil
+}
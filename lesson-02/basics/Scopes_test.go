@@ -1,8 +1,13 @@
 package basics
 
 import (
+	"errors"
 	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/scopes"
 	"gohomeworklesson02/testutil"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,7 +17,7 @@ import (
 type User1 struct {
 	ID          uint `gorm:"primaryKey"`
 	Name        string
-	Email       string `gorm:"uniqueIndex"`
+	Email       string `gorm:"uniqueIndex;size:128"`
 	Age         uint8
 	Status      string
 	LastLoginAt *time.Time
@@ -50,20 +55,27 @@ func ActiveYoungUsers() func(db *gorm.DB) *gorm.DB {
 	}
 }
 
+// user1SortColumns 允许作为排序字段的列，排序字段可能来自请求参数，不能直接拼进 SQL
+var user1SortColumns = []string{"id", "name", "age", "status", "created_at"}
+
 // YoungUsersOrdered 创建一个查询年龄在 18-30 岁之间并排序的 scope
-// 参数 orderBy: 排序字段，如 "age", "created_at"
+// 参数 orderBy: 排序字段，如 "age", "created_at"，不在 user1SortColumns 中时按 age 排序
 // 参数 order: 排序方式，"asc" 或 "desc"
 func YoungUsersOrdered(orderBy, order string) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		if orderBy == "" {
+		if !slices.Contains(user1SortColumns, orderBy) {
 			orderBy = "age"
 		}
-		if order == "" {
-			order = "asc"
-		}
-		tx := db.Where("age >= ? AND age <= ?", MinAge, MaxAge).
-			Order(fmt.Sprintf("%s %s", orderBy, order))
-		return tx
+		tx := db.Where("age >= ? AND age <= ?", MinAge, MaxAge)
+		return scopes.OrderBySafe(orderBy, order, user1SortColumns...)(tx)
+	}
+}
+
+// OrderBy 排序 scope，便于和筛选、分页 scope 一起传给 dbutil.FindWithCount
+// 参数 order: 排序表达式，如 "created_at DESC"
+func OrderBy(order string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Order(order)
 	}
 }
 
@@ -72,119 +84,158 @@ func YoungUsersOrdered(orderBy, order string) func(db *gorm.DB) *gorm.DB {
 // Paginate 通用分页 scope
 // 参数 page: 页码（从1开始）
 // 参数 size: 每页大小
+// 页码小于1按第1页，每页默认20条、最多100条（见 dbutil.NormalizePage）
 func Paginate(page, size int) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		if page < 1 {
-			page = 1
-		}
-		if size <= 0 {
-			size = 20
-		}
-		if size > 100 {
-			size = 100
-		}
+		page, size := dbutil.NormalizePage(page, size)
 		offset := (page - 1) * size
 		return db.Offset(offset).Limit(size)
 	}
 }
 
-// GetYoungUsersWithPagination 使用 scope 查询年轻用户（分页版本）
-func GetYoungUsersWithPagination(db *gorm.DB, page, size int) ([]User1, int64, error) {
-	var users []User1
-	var total int64
+// Cursor 游标分页的位置，记录上一页最后一条记录的 (created_at, id)
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
 
-	// 先获取总数
-	if err := db.Model(&User1{}).Scopes(YoungUsers()).Count(&total).Error; err != nil {
-		return nil, 0, fmt.Errorf("获取年轻用户总数失败: %w", err)
+// CursorPaginate 游标（keyset）分页 scope，按 created_at DESC, id DESC 排序
+// 与 Paginate 不同，它不使用 OFFSET，而是从上一页最后一条记录之后继续查询，
+// 大表翻到后面的页时不需要扫描并丢弃前面的行
+// 参数 cursor: 上一页返回的游标，为 nil 时查询第一页
+// 参数 size: 每页大小，规则同 Paginate
+// 用法: db.Scopes(CursorPaginate(cursor, 20)).Find(&users)
+func CursorPaginate(cursor *Cursor, size int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		_, size := dbutil.NormalizePage(1, size)
+		if cursor != nil {
+			// created_at 相同时用 id 区分，保证排序唯一，翻页不会重复或遗漏
+			db = db.Where("created_at < ? OR (created_at = ? AND id < ?)",
+				cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+		return db.Order("created_at DESC").Order("id DESC").Limit(size)
 	}
+}
 
-	// 再获取分页数据
-	if err := db.Scopes(
-		YoungUsers(),
-		Paginate(page, size),
-	).Order("created_at DESC").Find(&users).Error; err != nil {
-		return nil, 0, fmt.Errorf("分页查询年轻用户失败: %w", err)
+// NextCursor 根据本页结果计算下一页的游标
+// 本页记录数不足 size 时说明已经是最后一页，返回 nil
+// 参数 size: 传给 CursorPaginate 的每页大小，按同样的规则规范化后比较
+// 参数 key: 从记录中取出 (created_at, id)
+func NextCursor[T any](items []T, size int, key func(item T) Cursor) *Cursor {
+	_, size = dbutil.NormalizePage(1, size)
+	if len(items) == 0 || len(items) < size {
+		return nil
 	}
+	next := key(items[len(items)-1])
+	return &next
+}
 
-	return users, total, nil
+// Token 把游标编码为返回给客户端的 next_cursor，客户端无法篡改其中的排序键
+func (c Cursor) Token(codec *dbutil.CursorCodec) (string, error) {
+	return codec.Encode(c)
 }
 
-// GetYoungUsersByPage 多条件查询年轻用户
-func GetYoungUsersByPage(db *gorm.DB, page, size int, status, orderBy, order string) ([]User1, int64, error) {
-	var users []User1
-	var total int64
+// ParseCursor 解析客户端传回的 cursor 参数
+// 参数 token: 为空时表示第一页，返回 nil
+// 返回值: 格式错误或被篡改时返回 dbutil.ErrInvalidCursor，HTTP 接口应返回 400
+func ParseCursor(codec *dbutil.CursorCodec, token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	var cursor Cursor
+	if err := codec.Decode(token, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
 
-	// 构建基础查询
-	query := db.Model(&User1{}).Where("age >= ? AND age <= ?", MinAge, MaxAge)
+// GetYoungUsersWithPagination 使用 scope 查询年轻用户（分页版本）
+func GetYoungUsersWithPagination(db *gorm.DB, page, size int) (dbutil.Page[User1], error) {
+	var users []User1
 
-	// 添加状态筛选
-	if status != "" {
-		query = query.Where("status = ?", status)
+	// 总数和分页数据使用同一组 scope
+	total, err := dbutil.FindWithCount(db.Model(&User1{}), &users,
+		YoungUsers(),
+		Paginate(page, size),
+		OrderBy("created_at DESC"),
+	)
+	if err != nil {
+		return dbutil.Page[User1]{}, fmt.Errorf("分页查询年轻用户失败: %w", err)
 	}
 
-	// 获取总数
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, fmt.Errorf("获取总数失败: %w", err)
-	}
+	return dbutil.NewPage(users, total, page, size), nil
+}
 
-	// 添加排序
-	if orderBy != "" {
-		if order == "" {
-			order = "asc"
-		}
-		query = query.Order(fmt.Sprintf("%s %s", orderBy, order))
-	} else {
-		query = query.Order("created_at DESC")
-	}
+// GetYoungUsersByPage 多条件查询年轻用户
+func GetYoungUsersByPage(db *gorm.DB, page, size int, status, orderBy, order string) (dbutil.Page[User1], error) {
+	var users []User1
 
-	// 添加分页
-	offset := (page - 1) * size
-	if page < 1 {
-		page = 1
-	}
-	if size <= 0 {
-		size = 20
-	}
-	if size > 100 {
-		size = 100
+	// 构建查询条件，指定状态时按状态筛选
+	conds := []func(*gorm.DB) *gorm.DB{YoungUsers()}
+	if status != "" {
+		conds[0] = YoungUsersWithStatus(status)
 	}
 
-	// 执行查询
-	if err := query.Offset(offset).Limit(size).Find(&users).Error; err != nil {
-		return nil, 0, fmt.Errorf("查询年轻用户失败: %w", err)
+	// 添加排序：orderBy 不在白名单中时忽略，默认按创建时间倒序
+	conds = append(conds,
+		scopes.OrderBySafe(orderBy, order, user1SortColumns...),
+		OrderBy("created_at DESC"),
+	)
+
+	// 添加分页，总数和数据共用上面的条件
+	conds = append(conds, Paginate(page, size))
+	total, err := dbutil.FindWithCount(db.Model(&User1{}), &users, conds...)
+	if err != nil {
+		return dbutil.Page[User1]{}, fmt.Errorf("查询年轻用户失败: %w", err)
 	}
 
-	return users, total, nil
+	return dbutil.NewPage(users, total, page, size), nil
 }
 
 // 使用 scopes 的高级查询示例
 
-// FindYoungUsersByEmail 按邮箱模糊查询年轻用户
-func FindYoungUsersByEmail(db *gorm.DB, emailPattern string, page, size int) ([]User1, int64, error) {
-	var users []User1
-	var total int64
-
-	// 使用链式调用和 scopes
-	baseQuery := db.Model(&User1{}).Scopes(YoungUsers())
+// UserSearchOptions SearchUsersAdvanced 的查询条件，零值字段表示不按该条件筛选
+type UserSearchOptions struct {
+	MinAge          int       // 最小年龄（含），0 表示不限
+	MaxAge          int       // 最大年龄（含），0 表示不限
+	Statuses        []string  // 状态为其中之一
+	Pattern         string    // 邮箱或姓名包含的关键词，按空白拆分后每个都要匹配
+	LastLoginAfter  time.Time // 最后登录时间 >= LastLoginAfter
+	LastLoginBefore time.Time // 最后登录时间 < LastLoginBefore
+	OrderBy         string    // 排序字段，不在 user1SortColumns 中时按创建时间倒序
+	Order           string    // "asc" 或 "desc"
+	Page            int       // 页码，规则同 Paginate
+	Size            int       // 每页数量，规则同 Paginate
+}
 
-	// 添加邮箱筛选
-	if emailPattern != "" {
-		baseQuery = baseQuery.Where("email LIKE ?", "%"+emailPattern+"%")
+// SearchUsersAdvanced 按任意组合的条件查询用户，只为设置了的条件生成 SQL
+// 用法: SearchUsersAdvanced(db, UserSearchOptions{MinAge: 18, Statuses: []string{"active"}, Pattern: "example"})
+func SearchUsersAdvanced(db *gorm.DB, opts UserSearchOptions) (dbutil.Page[User1], error) {
+	var conds []func(*gorm.DB) *gorm.DB
+	if opts.MinAge > 0 {
+		conds = append(conds, func(db *gorm.DB) *gorm.DB { return db.Where("age >= ?", opts.MinAge) })
 	}
-
-	// 获取总数
-	if err := baseQuery.Count(&total).Error; err != nil {
-		return nil, 0, fmt.Errorf("获取总数失败: %w", err)
+	if opts.MaxAge > 0 {
+		conds = append(conds, func(db *gorm.DB) *gorm.DB { return db.Where("age <= ?", opts.MaxAge) })
 	}
 
-	// 分页查询
-	if err := baseQuery.Scopes(
-		Paginate(page, size),
-	).Order("created_at DESC").Find(&users).Error; err != nil {
-		return nil, 0, fmt.Errorf("查询失败: %w", err)
-	}
+	// 以下 scope 在参数为空时不添加条件
+	conds = append(conds,
+		scopes.StatusIn(opts.Statuses...),
+		scopes.Search(opts.Pattern, "email", "name"),
+		scopes.TimeBetween("last_login_at", opts.LastLoginAfter, opts.LastLoginBefore),
+		scopes.OrderBySafe(opts.OrderBy, opts.Order, user1SortColumns...),
+		OrderBy("created_at DESC"),
+		OrderBy("id DESC"),
+		Paginate(opts.Page, opts.Size),
+	)
 
-	return users, total, nil
+	var users []User1
+	total, err := dbutil.FindWithCount(db.Model(&User1{}), &users, conds...)
+	if err != nil {
+		return dbutil.Page[User1]{}, fmt.Errorf("查询用户失败: %w", err)
+	}
+	return dbutil.NewPage(users, total, opts.Page, opts.Size), nil
 }
 
 // 测试函数
@@ -220,28 +271,64 @@ func TestScopes(t *testing.T) {
 
 	t.Run("测试分页查询", func(t *testing.T) {
 		// 测试 GetYoungUsersWithPagination
-		users, total, err := GetYoungUsersWithPagination(db, 1, 3)
+		page1, err := GetYoungUsersWithPagination(db, 1, 3)
 		if err != nil {
 			t.Fatalf("分页查询失败: %v", err)
 		}
 
-		if total != 9 {
-			t.Errorf("预期总数 9，实际 %d", total)
+		if page1.Total != 9 {
+			t.Errorf("预期总数 9，实际 %d", page1.Total)
+		}
+
+		if len(page1.Items) != 3 {
+			t.Errorf("预期第1页3条记录，实际 %d 条", len(page1.Items))
 		}
 
-		if len(users) != 3 {
-			t.Errorf("预期第1页3条记录，实际 %d 条", len(users))
+		if page1.TotalPages != 3 || !page1.HasNext {
+			t.Errorf("预期共3页且有下一页，实际 %+v", page1)
 		}
 
-		t.Logf("总数: %d, 第1页: %d 条记录", total, len(users))
+		t.Logf("总数: %d, 第1页: %d 条记录", page1.Total, len(page1.Items))
 
-		// 测试第2页
-		users2, _, err := GetYoungUsersWithPagination(db, 2, 3)
+		// 测试最后一页
+		page3, err := GetYoungUsersWithPagination(db, 3, 3)
 		if err != nil {
 			t.Fatalf("分页查询失败: %v", err)
 		}
+		if page3.HasNext {
+			t.Errorf("最后一页不应有下一页")
+		}
+
+		t.Logf("第3页: %d 条记录", len(page3.Items))
+	})
+
+	t.Run("测试 GetYoungUsersByPage", func(t *testing.T) {
+		// 年轻的 active 用户按年龄升序: Henry Grace Alice | Leo Frank Ivy | Charlie
+		result, err := GetYoungUsersByPage(db, 2, 3, "active", "age", "asc")
+		if err != nil {
+			t.Fatalf("多条件分页查询失败: %v", err)
+		}
+
+		if result.Total != 7 || result.TotalPages != 3 {
+			t.Errorf("预期总数 7、共3页，实际 %+v", result)
+		}
+
+		var names []string
+		for _, u := range result.Items {
+			names = append(names, u.Name)
+		}
+		if strings.Join(names, ",") != "Leo,Frank,Ivy" {
+			t.Errorf("预期第2页为 Leo,Frank,Ivy，实际 %v", names)
+		}
 
-		t.Logf("第2页: %d 条记录", len(users2))
+		// 不在白名单中的排序字段被忽略，不会拼进 SQL
+		result, err = GetYoungUsersByPage(db, 1, 3, "active", "age; DROP TABLE user1", "")
+		if err != nil {
+			t.Fatalf("非法排序字段不应导致查询失败: %v", err)
+		}
+		if result.Total != 7 || !db.Migrator().HasTable(&User1{}) {
+			t.Errorf("预期按默认排序查询，实际 %+v", result)
+		}
 	})
 
 	t.Run("测试排序 scope", func(t *testing.T) {
@@ -314,32 +401,232 @@ func TestScopes(t *testing.T) {
 		t.Logf("组合查询结果: %d 条记录", len(users))
 	})
 
-	t.Run("测试 FindYoungUsersByEmail", func(t *testing.T) {
-		// 测试邮箱模糊查询
-		users, total, err := FindYoungUsersByEmail(db, "example", 1, 5)
+	t.Run("测试 SearchUsersAdvanced", func(t *testing.T) {
+		// Alice、Frank 最近登录过，David 很久以前登录过
+		now := time.Now()
+		recent, old := now.Add(-time.Hour), now.AddDate(0, -6, 0)
+		db.Model(&User1{}).Where("name IN ?", []string{"Alice", "Frank"}).Update("last_login_at", recent)
+		db.Model(&User1{}).Where("name = ?", "David").Update("last_login_at", old)
+
+		names := func(users []User1) string {
+			result := make([]string, len(users))
+			for i, u := range users {
+				result[i] = u.Name
+			}
+			return strings.Join(result, ",")
+		}
+
+		cases := []struct {
+			name string
+			opts UserSearchOptions
+			want string
+		}{
+			{"年龄范围", UserSearchOptions{MinAge: 18, MaxAge: 20, OrderBy: "age"}, "Henry,Kate,Grace"},
+			{"只有最大年龄", UserSearchOptions{MaxAge: 18, OrderBy: "age"}, "Bob,Henry"},
+			{"多个状态", UserSearchOptions{Statuses: []string{"inactive", "suspended"}, OrderBy: "name"}, "Eve,Kate"},
+			{"邮箱关键词", UserSearchOptions{Pattern: "alice"}, "Alice"},
+			{"姓名关键词", UserSearchOptions{Pattern: "CH", OrderBy: "name"}, "Charlie"},
+			{"登录时间窗口", UserSearchOptions{LastLoginAfter: now.AddDate(0, 0, -7), OrderBy: "name"}, "Alice,Frank"},
+			{"登录时间上限", UserSearchOptions{LastLoginBefore: now.AddDate(0, 0, -7)}, "David"},
+			{"组合条件", UserSearchOptions{MinAge: 25, Statuses: []string{"active"}, Pattern: "example.com", OrderBy: "age", Order: "desc"}, "Jack,David,Charlie,Ivy,Frank,Leo,Alice"},
+			{"组合条件分页", UserSearchOptions{MinAge: 25, Statuses: []string{"active"}, OrderBy: "age", Order: "desc", Page: 2, Size: 3}, "Ivy,Frank,Leo"},
+			{"非法排序字段被忽略", UserSearchOptions{Pattern: "ali", OrderBy: "age; DROP TABLE user1"}, "Alice"},
+			{"没有匹配", UserSearchOptions{MinAge: 40}, ""},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				result, err := SearchUsersAdvanced(db, c.opts)
+				if err != nil {
+					t.Fatalf("SearchUsersAdvanced: %v", err)
+				}
+				if got := names(result.Items); got != c.want {
+					t.Errorf("结果 [%s]，预期 [%s]", got, c.want)
+				}
+			})
+		}
+
+		// 不设置任何条件时返回全部用户
+		result, err := SearchUsersAdvanced(db, UserSearchOptions{})
 		if err != nil {
-			t.Fatalf("邮箱模糊查询失败: %v", err)
+			t.Fatalf("SearchUsersAdvanced: %v", err)
+		}
+		if result.Total != int64(len(seedUsers)) {
+			t.Errorf("预期总数 %d，实际 %d", len(seedUsers), result.Total)
+		}
+	})
+}
+
+// TestCursorPaginate 对比游标分页与偏移分页的结果
+func TestCursorPaginate(t *testing.T) {
+	db := testutil.NewTestDB(t, "cursor_paginate.db")
+
+	if err := db.Migrator().DropTable(&User1{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User1{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	// 每3个用户共用一个创建时间，验证 created_at 相同时按 id 翻页
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		u := User1{
+			Name:      fmt.Sprintf("User%02d", i),
+			Email:     fmt.Sprintf("user%02d@example.com", i),
+			Age:       20,
+			Status:    "active",
+			CreatedAt: base.Add(time.Duration(i/3) * time.Minute),
+		}
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+	}
+
+	const size = 7
+	key := func(u User1) Cursor { return Cursor{CreatedAt: u.CreatedAt, ID: u.ID} }
+
+	var cursor *Cursor
+	var pages int
+	var byCursor []User1
+	for {
+		var page []User1
+		if err := db.Scopes(CursorPaginate(cursor, size)).Find(&page).Error; err != nil {
+			t.Fatalf("游标分页查询失败: %v", err)
+		}
+		byCursor = append(byCursor, page...)
+		pages++
+
+		// 与同一页的偏移分页结果逐条对比
+		var offsetPage []User1
+		if err := db.Scopes(Paginate(pages, size)).Order("created_at DESC").Order("id DESC").
+			Find(&offsetPage).Error; err != nil {
+			t.Fatalf("偏移分页查询失败: %v", err)
+		}
+		if len(page) != len(offsetPage) {
+			t.Fatalf("第%d页: 游标分页 %d 条，偏移分页 %d 条", pages, len(page), len(offsetPage))
+		}
+		for i := range page {
+			if page[i].ID != offsetPage[i].ID {
+				t.Errorf("第%d页第%d条: 游标分页 id=%d，偏移分页 id=%d", pages, i+1, page[i].ID, offsetPage[i].ID)
+			}
 		}
 
-		if total < 5 {
-			t.Logf("注意: 查询结果少于5条")
+		cursor = NextCursor(page, size, key)
+		if cursor == nil {
+			break
+		}
+		if pages > 10 {
+			t.Fatalf("翻页次数异常，游标可能没有前进")
 		}
+	}
 
-		t.Logf("邮箱包含 'example' 的年轻用户: 总数 %d, 本页 %d 条", total, len(users))
+	if pages != 4 {
+		t.Errorf("预期4页，实际 %d 页", pages)
+	}
+	if len(byCursor) != 25 {
+		t.Errorf("预期共25条记录，实际 %d 条", len(byCursor))
+	}
+	seen := make(map[uint]bool)
+	for _, u := range byCursor {
+		if seen[u.ID] {
+			t.Errorf("用户 %d 重复出现", u.ID)
+		}
+		seen[u.ID] = true
+	}
 
-		// 测试特定邮箱
-		users, total, err = FindYoungUsersByEmail(db, "alice", 1, 10)
+	t.Run("与条件组合", func(t *testing.T) {
+		var users []User1
+		if err := db.Where("name > ?", "User20").
+			Scopes(CursorPaginate(&Cursor{CreatedAt: base.Add(8 * time.Minute), ID: 26}, size)).
+			Find(&users).Error; err != nil {
+			t.Fatalf("组合查询失败: %v", err)
+		}
+		// User21~24 中 created_at 均 <= 第8分钟，且 id < 26
+		if len(users) != 4 {
+			t.Errorf("预期4条记录，实际 %d 条", len(users))
+		}
+	})
+
+	t.Run("不透明游标", func(t *testing.T) {
+		codec, err := dbutil.NewCursorCodec([]byte("cursor-paginate-test-key"))
 		if err != nil {
-			t.Fatalf("邮箱模糊查询失败: %v", err)
+			t.Fatalf("NewCursorCodec: %v", err)
 		}
 
-		if total != 1 {
-			t.Errorf("预期找到1个包含 'alice' 的用户，实际 %d 个", total)
-		} else if users[0].Name != "Alice" {
-			t.Errorf("预期找到 Alice，实际找到 %s", users[0].Name)
+		// 模拟客户端：只保存服务端返回的 token，下次请求原样传回
+		var token string
+		var ids []uint
+		for i := 0; i < 10; i++ {
+			cursor, err := ParseCursor(codec, token)
+			if err != nil {
+				t.Fatalf("ParseCursor: %v", err)
+			}
+			var page []User1
+			if err := db.Scopes(CursorPaginate(cursor, size)).Find(&page).Error; err != nil {
+				t.Fatalf("游标分页查询失败: %v", err)
+			}
+			for _, u := range page {
+				ids = append(ids, u.ID)
+			}
+			next := NextCursor(page, size, key)
+			if next == nil {
+				break
+			}
+			if token, err = next.Token(codec); err != nil {
+				t.Fatalf("Token: %v", err)
+			}
+		}
+		for i := range byCursor {
+			if i >= len(ids) || ids[i] != byCursor[i].ID {
+				t.Fatalf("token 翻页结果与游标分页不一致: %v", ids)
+			}
 		}
 
-		t.Logf("邮箱包含 'alice' 的用户: %s", users[0].Name)
+		// 客户端伪造的游标被拒绝
+		forged, _ := dbutil.NewCursorCodec([]byte("forged-cursor-signing-key"))
+		bad, _ := Cursor{CreatedAt: base, ID: 1}.Token(forged)
+		if _, err := ParseCursor(codec, bad); !errors.Is(err, dbutil.ErrInvalidCursor) {
+			t.Errorf("预期 ErrInvalidCursor，实际 %v", err)
+		}
 	})
 
+	// 超出范围的 size 被规范化（<=0 为 20，>100 为 100），NextCursor 与 CursorPaginate 使用同样的值
+	t.Run("超出范围的每页大小", func(t *testing.T) {
+		for i := 25; i < 130; i++ {
+			u := User1{
+				Name:      fmt.Sprintf("User%03d", i),
+				Email:     fmt.Sprintf("user%03d@example.com", i),
+				Status:    "active",
+				CreatedAt: base.Add(time.Duration(i/3) * time.Minute),
+			}
+			if err := db.Create(&u).Error; err != nil {
+				t.Fatalf("创建用户失败: %v", err)
+			}
+		}
+
+		for _, c := range []struct {
+			size  int
+			pages []int // 每页的记录数
+		}{
+			{0, []int{20, 20, 20, 20, 20, 20, 10}},
+			{-5, []int{20, 20, 20, 20, 20, 20, 10}},
+			{200, []int{100, 30}},
+		} {
+			var cursor *Cursor
+			var pages []int
+			for len(pages) < 10 {
+				var page []User1
+				if err := db.Scopes(CursorPaginate(cursor, c.size)).Find(&page).Error; err != nil {
+					t.Fatalf("游标分页查询失败: %v", err)
+				}
+				pages = append(pages, len(page))
+				if cursor = NextCursor(page, c.size, key); cursor == nil {
+					break
+				}
+			}
+			if !slices.Equal(pages, c.pages) {
+				t.Errorf("size=%d 每页记录数 %v，预期 %v", c.size, pages, c.pages)
+			}
+		}
+	})
 }
@@ -0,0 +1,134 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// TestProcessUsersInBatches 测试分批处理、进度回调和两种错误处理方式
+func TestProcessUsersInBatches(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "batch.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	users := make([]User, 25)
+	for i := range users {
+		users[i] = User{
+			Name:   fmt.Sprintf("user%02d", i+1),
+			Email:  fmt.Sprintf("user%02d@example.com", i+1),
+			Phone:  dbutil.EncryptedString(fmt.Sprintf("138%08d", i+1)),
+			Age:    uint8(15 + i),
+			Status: "active",
+		}
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+
+	// markMinors 把一批中未成年的用户标记为 pending（待监护人确认）
+	markMinors := func(tx *gorm.DB, batch []User) error {
+		var ids []uint
+		for _, u := range batch {
+			if u.Age < 18 {
+				ids = append(ids, u.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id IN ?", ids).Update("status", UserStatusPending).Error
+	}
+	countStatus := func(t *testing.T, status UserStatus) int64 {
+		t.Helper()
+		var n int64
+		if err := db.Model(&User{}).Where("status = ?", status).Count(&n).Error; err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	errBoom := errors.New("boom")
+
+	t.Run("进度回调", func(t *testing.T) {
+		var reports []BatchProgress
+		progress, err := ProcessUsersInBatches(ctx, db, 10, markMinors, BatchOptions{
+			OnProgress: func(p BatchProgress) { reports = append(reports, p) },
+		})
+		if err != nil {
+			t.Fatalf("ProcessUsersInBatches: %v", err)
+		}
+		if len(reports) != 3 || reports[2].Processed != 25 || reports[2].Total != 25 {
+			t.Errorf("预期3次进度回调、最终处理25个用户，实际 %+v", reports)
+		}
+		if progress != reports[2] {
+			t.Errorf("返回的进度 %+v 应与最后一次回调一致", progress)
+		}
+		if got := countStatus(t, UserStatusPending); got != 3 {
+			t.Errorf("预期3个未成年用户，实际 %d", got)
+		}
+	})
+
+	t.Run("带查询条件", func(t *testing.T) {
+		var seen int
+		_, err := ProcessUsersInBatches(ctx, db.Where("status = ?", UserStatusPending), 2, func(tx *gorm.DB, batch []User) error {
+			seen += len(batch)
+			return nil
+		}, BatchOptions{})
+		if err != nil {
+			t.Fatalf("ProcessUsersInBatches: %v", err)
+		}
+		if seen != 3 {
+			t.Errorf("预期只处理3个 pending 用户，实际 %d", seen)
+		}
+	})
+
+	// failSecond 第2批写入后返回错误，该批的修改应被回滚
+	failSecond := func(tx *gorm.DB, batch []User) error {
+		if err := tx.Model(&User{}).Where("id IN ?", []uint{batch[0].ID}).Update("status", UserStatusVIP).Error; err != nil {
+			return err
+		}
+		if batch[0].ID > 10 && batch[0].ID <= 20 {
+			return errBoom
+		}
+		return nil
+	}
+
+	t.Run("AbortOnError", func(t *testing.T) {
+		progress, err := ProcessUsersInBatches(ctx, db, 10, failSecond, BatchOptions{Policy: AbortOnError})
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || !errors.Is(err, errBoom) {
+			t.Fatalf("预期返回包含 boom 的 BatchError，实际 %v", err)
+		}
+		if batchErr.Batch != 2 || batchErr.FirstID != 11 || batchErr.LastID != 20 {
+			t.Errorf("预期第2批（ID 11-20）失败，实际 %+v", batchErr)
+		}
+		if progress.Batch != 2 || progress.Processed != 10 || progress.Failed != 10 {
+			t.Errorf("预期处理2批后停止，实际 %+v", progress)
+		}
+		if got := countStatus(t, UserStatusVIP); got != 1 {
+			t.Errorf("预期只有第1批的修改生效，实际 %d 个", got)
+		}
+	})
+
+	t.Run("CollectErrors", func(t *testing.T) {
+		progress, err := ProcessUsersInBatches(ctx, db, 10, failSecond, BatchOptions{Policy: CollectErrors})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("预期返回收集到的错误，实际 %v", err)
+		}
+		if progress.Batch != 3 || progress.Processed != 15 || progress.Failed != 10 {
+			t.Errorf("预期处理全部3批，实际 %+v", progress)
+		}
+		if got := countStatus(t, UserStatusVIP); got != 2 {
+			t.Errorf("预期第1、3批的修改生效，实际 %d 个", got)
+		}
+	})
+}
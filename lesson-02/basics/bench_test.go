@@ -0,0 +1,70 @@
+package basics
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// BenchmarkUserCRUD 对比不同连接配置下用户增删改查的吞吐量
+//
+//	go test -run ^$ -bench BenchmarkUserCRUD ./basics
+//
+// 每次迭代用 CreateUser 新增一个用户，再执行 GetUserByID、GetUserByEmail、UpdateUserStatus
+func BenchmarkUserCRUD(b *testing.B) {
+	configs := []struct {
+		name string
+		cfg  dbutil.Config
+	}{
+		{"default", dbutil.Config{}},
+		{"prepare", dbutil.Config{PrepareStmt: true}},
+		{"wal", dbutil.Config{
+			SQLite: dbutil.SQLiteConfig{WAL: true, BusyTimeout: 5 * time.Second},
+		}},
+		{"wal+prepare+pool", dbutil.Config{
+			PrepareStmt: true,
+			Pool:        dbutil.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 4},
+			SQLite:      dbutil.SQLiteConfig{WAL: true, BusyTimeout: 5 * time.Second},
+		}},
+	}
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			cfg := c.cfg
+			cfg.DSN = filepath.Join(b.TempDir(), "bench.db")
+			cfg.EncryptionKey = []byte("bench-encryption-key-0123456789a") // 手机号加密保存
+			db, err := dbutil.Open(cfg, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			if err != nil {
+				b.Fatalf("Open: %v", err)
+			}
+			sqlDB, _ := db.DB()
+			defer sqlDB.Close()
+			if err := db.AutoMigrate(&User{}); err != nil {
+				b.Fatalf("auto migrate: %v", err)
+			}
+
+			db, ctx := withTestTenant(db)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				email := fmt.Sprintf("user%d@bench.com", i)
+				u, err := CreateUser(ctx, db, fmt.Sprintf("user%d", i), email)
+				if err != nil {
+					b.Fatalf("CreateUser: %v", err)
+				}
+				if _, err := GetUserByID(ctx, db, u.ID); err != nil {
+					b.Fatalf("GetUserByID: %v", err)
+				}
+				if _, err := GetUserByEmail(ctx, db, email); err != nil {
+					b.Fatalf("GetUserByEmail: %v", err)
+				}
+				if err := UpdateUserStatus(ctx, db, []uint{u.ID}, "vip"); err != nil {
+					b.Fatalf("UpdateUserStatus: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package basics
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/testutil"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TestContextCancellation 测试已取消或超时的 context 会中止用户相关的查询
+func TestContextCancellation(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "context.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	alice, err := CreateUser(ctx, db, "Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+
+	calls := map[string]func(ctx context.Context) error{
+		"CreateUser": func(ctx context.Context) error {
+			_, err := CreateUser(ctx, db, "Bob", "bob@example.com")
+			return err
+		},
+		"SearchUsers": func(ctx context.Context) error {
+			_, err := SearchUsers(ctx, db, "alice", 1, 10)
+			return err
+		},
+		"GetUserByID": func(ctx context.Context) error {
+			_, err := GetUserByID(ctx, db, alice.ID)
+			return err
+		},
+		"UpdateUserStatus": func(ctx context.Context) error {
+			return UpdateUserStatus(ctx, db, []uint{alice.ID}, "vip")
+		},
+		"DeleteInactiveUsers": func(ctx context.Context) error {
+			return DeleteInactiveUsers(ctx, db)
+		},
+		"UpsertUsers": func(ctx context.Context) error {
+			_, _, err := UpsertUsers(ctx, db, []User{{Name: "Carol", Email: "carol@example.com"}})
+			return err
+		},
+		"ProcessUsersInBatches": func(ctx context.Context) error {
+			_, err := ProcessUsersInBatches(ctx, db, 10, func(tx *gorm.DB, users []User) error { return nil }, BatchOptions{})
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(cancelled); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: 预期 context.Canceled，实际 %v", name, err)
+		}
+		if err := call(expired); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: 预期 context.DeadlineExceeded，实际 %v", name, err)
+		}
+	}
+
+	// 被中止的调用没有写入任何数据
+	var users []User
+	if err := db.Find(&users).Error; err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(users) != 1 || users[0].Status != "active" {
+		t.Errorf("预期只有未修改的 Alice，实际 %+v", users)
+	}
+}
@@ -1,13 +1,18 @@
 package basics
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/scopes"
 	"gohomeworklesson02/testutil"
+	"strings"
 	"testing"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // TestCRUDDemo demonstrates the complete CRUD operations in GORM
@@ -20,8 +25,8 @@ func TestCRUDDemo(t *testing.T) {
 	type User struct {
 		ID          uint       `gorm:"primaryKey"` // Primary key, auto-increment
 		Name        string     // Regular field
-		Email       string     `gorm:"uniqueIndex"`         // Unique index for email
-		Phone       string     `gorm:"uniqueIndex;size:20"` // 新增：电话号码字段，唯一索引，指定长度优化存储
+		Email       string     `gorm:"uniqueIndex;size:128"` // Unique index for email
+		Phone       string     `gorm:"uniqueIndex;size:20"`  // 新增：电话号码字段，唯一索引，指定长度优化存储
 		Age         uint8      // Age field
 		Status      string     // Status field
 		LastLoginAt *time.Time // 新增：最后登录时间字段，使用指针类型，允许NULL
@@ -304,20 +309,28 @@ func TestCRUDDemo(t *testing.T) {
 
 // User 模型定义
 type User struct {
-	ID          uint `gorm:"primaryKey"`
-	Name        string
-	Email       string `gorm:"uniqueIndex"`
-	Phone       string `gorm:"uniqueIndex;size:20"`
-	Age         uint8
-	Status      string
-	LastLoginAt *time.Time
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                  uint `gorm:"primaryKey"`
+	Name                string
+	Email               string                 `gorm:"uniqueIndex;size:128"`         // MySQL 不能给不限长度的 longtext 建索引
+	Phone               dbutil.EncryptedString `gorm:"size:255"`                     // 加密保存，按 PhoneIndex 查询
+	PhoneIndex          *string                `gorm:"uniqueIndex;size:64" json:"-"` // 手机号的盲索引，由 BeforeSave 填充；没有手机号时为 NULL，不占用唯一索引
+	Age                 uint8
+	Status              UserStatus `gorm:"size:20;default:active"` // 未指定时为 active
+	PasswordHash        string     `gorm:"size:60" json:"-"`       // bcrypt 哈希，通过 SetPassword 设置
+	LastLoginAt         *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           gorm.DeletedAt `gorm:"index"` // 软删除
+	dbutil.AuditFields                 // 创建人/修改人，由 AuditPlugin 填充
+	dbutil.TenantFields                // 所属租户，由 TenantPlugin 填充和过滤
 }
 
 /*
 CreateUser 新增用户：创建用户并默认开启激活状态
+先查询邮箱是否存在再插入，并发注册同一邮箱时由唯一索引兜底报错；需要"存在则更新"时使用 UpsertUserByEmail
+已被软删除的用户的邮箱同样视为已注册，需要先 RestoreUser 恢复或 PurgeDeletedUsers 清理
 参数：
+  - ctx: 上下文，取消或超时会中止查询
   - db: GORM 数据库连接
   - name: 用户名
   - email: 邮箱
@@ -326,7 +339,7 @@ CreateUser 新增用户：创建用户并默认开启激活状态
   - *User: 创建的用户对象
   - error: 错误信息
 */
-func CreateUser(db *gorm.DB, name, email string) (*User, error) {
+func CreateUser(ctx context.Context, db *gorm.DB, name, email string) (*User, error) {
 	// 参数验证
 	if name == "" {
 		return nil, errors.New("用户名不能为空")
@@ -335,27 +348,29 @@ func CreateUser(db *gorm.DB, name, email string) (*User, error) {
 		return nil, errors.New("邮箱不能为空")
 	}
 
-	// 检查邮箱是否已存在
-	var existingUser User
-	err := db.Where("email = ?", email).First(&existingUser).Error
-	if err == nil {
-		// 如果找到了现有用户，返回错误
-		return nil, errors.New("邮箱已被注册")
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		// 如果是其他数据库错误，返回该错误
+	users := NewRepository[User](db.WithContext(ctx))
+
+	// 检查邮箱是否已存在：邮箱的唯一索引包含已软删除的用户，需要 Unscoped 一起检查
+	count, err := users.Count(func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Where("email = ?", email)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("检查邮箱失败: %w", err)
 	}
+	if count > 0 {
+		return nil, errors.New("邮箱已被注册")
+	}
 
 	// 创建用户实例，设置默认值
 	user := &User{
 		Name:   name,
 		Email:  email,
-		Status: "active", // 默认开启激活状态
-		Age:    0,        // 默认年龄为0，可根据需求调整
+		Status: UserStatusActive, // 默认开启激活状态
+		Age:    0,                // 默认年龄为0，可根据需求调整
 	}
 
 	// 创建用户
-	if err := db.Create(user).Error; err != nil {
+	if err := users.Create(user); err != nil {
 		return nil, fmt.Errorf("创建用户失败: %w", err)
 	}
 
@@ -363,79 +378,219 @@ func CreateUser(db *gorm.DB, name, email string) (*User, error) {
 }
 
 /*
-SearchUsersByEmail 模糊查询：根据邮箱模糊查询用户列表（支持分页）
+SearchUsers 搜索用户：按空白拆分关键词，在姓名、邮箱中模糊匹配，手机号加密保存只能完全匹配
+每个关键词都必须至少匹配其中一个字段，结果按匹配程度排序：
+完全相等 > 前缀匹配 > 包含，分数相同时按ID升序
+SQLite 上调用过 EnableUserFTS 后改用 FTS5 全文索引（只索引姓名和邮箱），按 bm25 排序
 参数：
+  - ctx: 上下文，取消或超时会中止查询
   - db: GORM 数据库连接
-  - emailPattern: 邮箱匹配模式，如 "%example.com"、"alice%"、"%alice%"
-  - page: 页码（从1开始）
-  - size: 每页大小
+  - q: 搜索关键词，如 "alice"、"alice example.com"、"13800000001"
+  - page: 页码，从1开始
+  - size: 每页数量，规则同 Paginate
 
 返回值：
-  - []User: 用户列表
+  - dbutil.Page[User]: 当前页的用户及总数
   - error: 错误信息
 */
-func SearchUsersByEmail(db *gorm.DB, emailPattern string, page, size int) ([]User, error) {
-	// 参数验证
-	if page < 1 {
-		page = 1
-	}
-	if size < 1 || size > 100 {
-		size = 20 // 默认每页20条
+func SearchUsers(ctx context.Context, db *gorm.DB, q string, page, size int) (dbutil.Page[User], error) {
+	tokens := strings.Fields(strings.ToLower(q))
+	if len(tokens) == 0 {
+		return dbutil.Page[User]{}, errors.New("搜索关键词不能为空")
 	}
+	db = db.WithContext(ctx)
 
-	// 计算偏移量
-	offset := (page - 1) * size
+	var query *gorm.DB
+	if userFTSEnabled(db) {
+		query = searchUsersFTS(db, tokens)
+	} else {
+		query = searchUsersLike(db, tokens)
+	}
 
 	var users []User
+	total, err := dbutil.FindWithCount(query, &users, Paginate(page, size))
+	if err != nil {
+		return dbutil.Page[User]{}, fmt.Errorf("搜索用户失败: %w", err)
+	}
+	return dbutil.NewPage(users, total, page, size), nil
+}
+
+// searchUsersLike 使用 LIKE 条件搜索，适用于所有数据库
+func searchUsersLike(db *gorm.DB, tokens []string) *gorm.DB {
+	query := db.Model(&User{})
+
+	var scores []string
+	var scoreVars []interface{}
+	for _, token := range tokens {
+		escaped := scopes.EscapeLike(token)
+		contains := "%" + escaped + "%"
+		prefix := escaped + "%"
+		// 手机号加密保存，只能按盲索引完全匹配
+		phoneIndex, err := dbutil.BlindIndex(token)
+		if err != nil {
+			_ = query.AddError(err)
+			return query
+		}
 
-	// 构建查询
-	query := db.Model(&User{}).Where("email LIKE ?", emailPattern)
+		// ESCAPE '!' 而不是反斜杠：MySQL 字符串字面量中反斜杠本身需要转义
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!' OR phone_index = ?",
+			contains, contains, phoneIndex)
 
-	// 添加排序（默认按创建时间倒序）
-	query = query.Order("created_at DESC")
+		for _, column := range []string{"LOWER(name)", "LOWER(email)"} {
+			scores = append(scores, fmt.Sprintf(
+				"CASE WHEN %[1]s = ? THEN 3 WHEN %[1]s LIKE ? ESCAPE '!' THEN 2 WHEN %[1]s LIKE ? ESCAPE '!' THEN 1 ELSE 0 END",
+				column))
+			scoreVars = append(scoreVars, token, prefix, contains)
+		}
+		scores = append(scores, "CASE WHEN phone_index = ? THEN 3 ELSE 0 END")
+		scoreVars = append(scoreVars, phoneIndex)
+	}
+
+	return query.
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "(" + strings.Join(scores, " + ") + ") DESC",
+			Vars: scoreVars,
+		}}).
+		Order("id ASC")
+}
 
-	// 执行分页查询
-	if err := query.Offset(offset).Limit(size).Find(&users).Error; err != nil {
-		return nil, fmt.Errorf("查询用户失败: %w", err)
+// userFTSTable SQLite FTS5 索引表名
+const userFTSTable = "users_fts"
+
+/*
+EnableUserFTS 为 users 表创建 SQLite FTS5 全文索引
+索引姓名和邮箱（手机号是密文，不参与全文索引），通过触发器与 users 表保持同步，创建时会根据现有数据重建索引
+仅支持 SQLite，且 go-sqlite3 需要以 sqlite_fts5 构建标签编译，否则返回错误
+注意：删除并重建 users 表后触发器会一并删除，需要重新调用
+*/
+func EnableUserFTS(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return fmt.Errorf("FTS5 仅支持 SQLite，当前数据库为 %s", db.Dialector.Name())
 	}
 
-	return users, nil
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(name, email, content='users', content_rowid='id')`,
+		`CREATE TRIGGER IF NOT EXISTS users_fts_ai AFTER INSERT ON users BEGIN
+			INSERT INTO users_fts(rowid, name, email) VALUES (new.id, new.name, new.email);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS users_fts_ad AFTER DELETE ON users BEGIN
+			INSERT INTO users_fts(users_fts, rowid, name, email) VALUES ('delete', old.id, old.name, old.email);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS users_fts_au AFTER UPDATE ON users BEGIN
+			INSERT INTO users_fts(users_fts, rowid, name, email) VALUES ('delete', old.id, old.name, old.email);
+			INSERT INTO users_fts(rowid, name, email) VALUES (new.id, new.name, new.email);
+		END`,
+		`INSERT INTO users_fts(users_fts) VALUES ('rebuild')`,
+	}
+	return dbutil.WithTx(db, func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("创建全文索引失败: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// userFTSEnabled 是否已为 users 表启用 FTS5 索引
+func userFTSEnabled(db *gorm.DB) bool {
+	return db.Dialector.Name() == "sqlite" && db.Migrator().HasTable(userFTSTable)
+}
+
+// searchUsersFTS 使用 FTS5 搜索，每个关键词按前缀匹配
+func searchUsersFTS(db *gorm.DB, tokens []string) *gorm.DB {
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		// 双引号包裹避免关键词中的 @ . - 等被当作 FTS 语法
+		terms[i] = `"` + strings.ReplaceAll(token, `"`, `""`) + `"*`
+	}
+	return db.Model(&User{}).
+		Joins("JOIN users_fts ON users_fts.rowid = users.id").
+		Where("users_fts MATCH ?", strings.Join(terms, " ")).
+		Order("bm25(users_fts)").
+		Order("users.id ASC")
+}
+
+/*
+GetUserByID 按ID查询用户
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - id: 用户ID
+
+返回值：
+  - *User: 用户对象
+  - error: 用户不存在时返回 gorm.ErrRecordNotFound
+*/
+func GetUserByID(ctx context.Context, db *gorm.DB, id uint) (*User, error) {
+	return NewRepository[User](db.WithContext(ctx)).GetByID(id)
+}
+
+/*
+GetUserByEmail 按邮箱查询用户
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - email: 邮箱
+
+返回值：
+  - *User: 用户对象
+  - error: 用户不存在时返回 gorm.ErrRecordNotFound
+*/
+func GetUserByEmail(ctx context.Context, db *gorm.DB, email string) (*User, error) {
+	var user User
+	if err := db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+/*
+GetUserByPhone 按手机号查询用户
+手机号加密保存，通过盲索引 phone_index 做等值匹配，不支持模糊查询
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - phone: 完整的手机号
+
+返回值：
+  - *User: 用户对象
+  - error: 用户不存在时返回 gorm.ErrRecordNotFound
+*/
+func GetUserByPhone(ctx context.Context, db *gorm.DB, phone string) (*User, error) {
+	index, err := dbutil.BlindIndex(phone)
+	if err != nil {
+		return nil, err
+	}
+	var user User
+	if err := db.WithContext(ctx).Where("phone_index = ?", index).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
 /*
 UpdateUserStatus 批量更新状态：批量更新用户状态
 参数：
+  - ctx: 上下文，取消或超时会中止查询
   - db: GORM 数据库连接
   - ids: 用户ID数组
-  - status: 新的状态值
+  - status: 新的状态值，来自请求参数时先用 ParseUserStatus 转换
 
 返回值：
-  - error: 错误信息
+  - error: 错误信息，状态无效时为 ErrInvalidUserStatus
 */
-func UpdateUserStatus(db *gorm.DB, ids []uint, status string) error {
+func UpdateUserStatus(ctx context.Context, db *gorm.DB, ids []uint, status UserStatus) error {
 	// 参数验证
 	if len(ids) == 0 {
 		return errors.New("用户ID列表不能为空")
 	}
-	if status == "" {
-		return errors.New("状态不能为空")
-	}
-
-	// 验证状态值的有效性
-	validStatuses := []string{"active", "inactive", "pending", "suspended", "vip"}
-	valid := false
-	for _, s := range validStatuses {
-		if s == status {
-			valid = true
-			break
-		}
-	}
-	if !valid {
-		return fmt.Errorf("无效的状态值: %s，有效值: %v", status, validStatuses)
+	if _, err := ParseUserStatus(string(status)); err != nil {
+		return err
 	}
 
 	// 批量更新
-	result := db.Model(&User{}).Where("id IN ?", ids).Update("status", status)
+	result := db.WithContext(ctx).Model(&User{}).Where("id IN ?", ids).Update("status", status)
 	if result.Error != nil {
 		return fmt.Errorf("更新状态失败: %w", result.Error)
 	}
@@ -449,16 +604,38 @@ func UpdateUserStatus(db *gorm.DB, ids []uint, status string) error {
 }
 
 /*
-DeleteInactiveUsers 删除过期用户：删除超过 30 天未登录的用户
-注意：这是硬删除，会从数据库中永久删除数据
-在生产环境中，通常建议使用软删除
+DeleteInactiveUsers 删除过期用户：软删除超过 30 天未登录的用户
+当前时间取自 dbutil.ClockFrom(ctx)
+软删除只设置 deleted_at，可以通过 RestoreUser 恢复，PurgeDeletedUsers 清理
+*/
+func DeleteInactiveUsers(ctx context.Context, db *gorm.DB) error {
+	return deleteInactiveUsers(db.WithContext(ctx), false)
+}
+
+/*
+HardDeleteInactiveUsers 删除过期用户：硬删除超过 30 天未登录的用户
+注意：这是硬删除，会从数据库中永久删除数据（包括已被软删除的用户）
 */
-func DeleteInactiveUsers(db *gorm.DB) error {
-	// 计算30天前的时间
-	thirtyDaysAgo := time.Now().Add(-30 * 24 * time.Hour)
+func HardDeleteInactiveUsers(ctx context.Context, db *gorm.DB) error {
+	return deleteInactiveUsers(db.WithContext(ctx), true)
+}
+
+// deleteInactiveUsers 删除超过 30 天未登录的用户，hard 为 true 时硬删除
+func deleteInactiveUsers(db *gorm.DB, hard bool) error {
+	// 计算30天前的时间，当前时间取自 context 中的 Clock，测试中可以固定
+	// 软删除写入的 deleted_at 也使用该 Clock，PurgeDeletedUsers 按同一时钟计算保留期
+	clock := dbutil.ClockFrom(db.Statement.Context)
+	thirtyDaysAgo := clock.Now().Add(-30 * 24 * time.Hour)
+	db = db.Session(&gorm.Session{NowFunc: clock.Now})
+
+	// 使用事务确保数据一致性，遇到锁冲突时自动重试
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		if hard {
+			// Unscoped: 查询和删除都包含已软删除的记录
+			// Session 使之后的每条语句都从该状态重新开始，避免条件在语句间累积
+			tx = tx.Unscoped().Session(&gorm.Session{})
+		}
 
-	// 使用事务确保数据一致性
-	err := db.Transaction(func(tx *gorm.DB) error {
 		// 先查询要删除的用户信息（用于日志或其他用途）
 		var usersToDelete []User
 		if err := tx.Where("last_login_at IS NULL OR last_login_at < ?", thirtyDaysAgo).Find(&usersToDelete).Error; err != nil {
@@ -476,17 +653,382 @@ func DeleteInactiveUsers(db *gorm.DB) error {
 			userIDs[i] = user.ID
 		}
 
-		// 执行删除
+		// 执行删除：User 带有 DeletedAt 字段，默认为软删除
 		result := tx.Where("id IN ?", userIDs).Delete(&User{})
 		if result.Error != nil {
 			return fmt.Errorf("删除用户失败: %w", result.Error)
 		}
 
-		// 记录删除的数量
-		tx.Commit()
-
 		return nil
 	})
 
 	return err
 }
+
+/*
+RestoreUser 恢复被软删除的用户
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - id: 用户ID
+
+返回值：
+  - error: 用户不存在或未被删除时返回错误
+*/
+func RestoreUser(ctx context.Context, db *gorm.DB, id uint) error {
+	// Unscoped: 跳过 deleted_at IS NULL 条件，才能找到已软删除的记录
+	result := db.WithContext(ctx).Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("恢复用户失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("用户 %d 不存在或未被删除", id)
+	}
+	return nil
+}
+
+/*
+PurgeDeletedUsers 永久删除软删除时间早于 olderThan 之前的用户
+当前时间取自 dbutil.ClockFrom(ctx)
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - olderThan: 软删除后保留的时长，如 7*24*time.Hour 表示清理 7 天前删除的用户
+
+返回值：
+  - int64: 清理的用户数量
+  - error: 错误信息
+*/
+func PurgeDeletedUsers(ctx context.Context, db *gorm.DB, olderThan time.Duration) (int64, error) {
+	cutoff := dbutil.ClockFrom(ctx).Now().Add(-olderThan)
+	result := db.WithContext(ctx).Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Delete(&User{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("清理已删除用户失败: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+/*
+UpsertUserByEmail 按邮箱新增或更新单个用户
+与 CreateUser 先查询再插入不同，插入使用 INSERT ... ON CONFLICT (email) DO NOTHING，
+并发调用时不会因为两边都查到"不存在"而重复插入或报唯一索引冲突；
+插入被跳过时在同一事务中更新已有用户的姓名、手机号、年龄、状态和最后登录时间
+（Status 为空时保留原状态，密码不会被修改，请使用 ChangePassword）
+已被软删除的用户同样按已存在处理，更新后仍保持删除状态，与 UpsertUsers 一致
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - user: 要写入的用户，Email 不能为空；返回时会填充为数据库中的最新数据（包括ID）
+
+返回值：
+  - created: true 表示新增，false 表示更新了已有用户
+  - err: 错误信息
+*/
+func UpsertUserByEmail(ctx context.Context, db *gorm.DB, user *User) (created bool, err error) {
+	if user.Email == "" {
+		return false, errors.New("邮箱不能为空")
+	}
+	// ID 由数据库决定，避免更新时带上调用方传入的 ID 条件
+	user.ID = 0
+
+	err = dbutil.WithTx(db.WithContext(ctx), func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "email"}},
+			DoNothing: true,
+		}).Create(user)
+		if result.Error != nil {
+			return fmt.Errorf("写入用户失败: %w", result.Error)
+		}
+		created = result.RowsAffected > 0
+		if created {
+			return nil
+		}
+
+		columns := []string{"name", "phone", "phone_index", "age", "last_login_at", "updated_at"}
+		if user.Status != "" {
+			columns = append(columns, "status")
+		}
+		// Model(user) 让 BeforeSave 钩子作用在 user 上，重新计算手机号的盲索引
+		if err := tx.Unscoped().Model(user).Where("email = ?", user.Email).
+			Select(columns).Updates(user).Error; err != nil {
+			return fmt.Errorf("更新用户失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// 读回完整的用户数据（ID、创建时间、默认状态等）
+	if err := db.WithContext(ctx).Unscoped().Where("email = ?", user.Email).First(user).Error; err != nil {
+		return false, fmt.Errorf("读取用户失败: %w", err)
+	}
+	return created, nil
+}
+
+// upsertBatchSize UpsertUsers 每条 INSERT 语句写入的最大用户数
+const upsertBatchSize = 100
+
+/*
+UpsertUsers 批量新增或更新用户：按邮箱匹配已有用户，邮箱为空时按手机号匹配
+不存在的用户会被插入；已存在的用户只有字段发生变化时才会更新，未变化的跳过
+输入中同一邮箱（或手机号）出现多次时以最后一条为准
+已被软删除的用户同样按已存在处理，更新后仍保持删除状态
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - users: 要写入的用户
+
+返回值：
+  - inserted: 新增的用户数量
+  - updated: 更新的用户数量
+  - err: 错误信息，出错时所有写入都会回滚
+*/
+func UpsertUsers(ctx context.Context, db *gorm.DB, users []User) (inserted, updated int, err error) {
+	var byEmail, byPhone []User
+	for _, u := range users {
+		switch {
+		case u.Email != "":
+			byEmail = append(byEmail, u)
+		case u.Phone != "":
+			byPhone = append(byPhone, u)
+		default:
+			return 0, 0, fmt.Errorf("用户 %q 的邮箱和手机号不能同时为空", u.Name)
+		}
+	}
+
+	groups := []struct {
+		column string
+		users  []User
+	}{
+		{"email", dedupeUsers(byEmail, "email")},
+		{"phone", dedupeUsers(byPhone, "phone")},
+	}
+
+	err = dbutil.WithTx(db.WithContext(ctx), func(tx *gorm.DB) error {
+		for _, g := range groups {
+			for start := 0; start < len(g.users); start += upsertBatchSize {
+				end := min(start+upsertBatchSize, len(g.users))
+				ins, upd, err := upsertUserBatch(tx, g.column, g.users[start:end])
+				if err != nil {
+					return err
+				}
+				inserted += ins
+				updated += upd
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, nil
+}
+
+// upsertUserBatch 以 column（email 或 phone）为冲突键写入一批用户
+func upsertUserBatch(tx *gorm.DB, column string, batch []User) (inserted, updated int, err error) {
+	keys := make([]string, len(batch))
+	for i, u := range batch {
+		keys[i] = userKey(u, column)
+	}
+	conflictColumn := column
+	if column == "phone" {
+		// 手机号加密保存，每次的密文都不同，按盲索引匹配
+		conflictColumn = "phone_index"
+		for i, key := range keys {
+			if keys[i], err = dbutil.BlindIndex(key); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	// 先查出已存在的用户，用于区分新增/更新并跳过没有变化的记录
+	var existing []User
+	if err := tx.Unscoped().Where(conflictColumn+" IN ?", keys).Find(&existing).Error; err != nil {
+		return 0, 0, fmt.Errorf("查询已有用户失败: %w", err)
+	}
+	existingByKey := make(map[string]User, len(existing))
+	for _, u := range existing {
+		existingByKey[userKey(u, column)] = u
+	}
+
+	// 按邮箱匹配时同时更新手机号；按手机号匹配的用户没有邮箱，不覆盖已有邮箱
+	updateColumns := []string{"name", "age", "status", "last_login_at", "updated_at"}
+	if column == "email" {
+		updateColumns = append(updateColumns, "phone", "phone_index")
+	}
+
+	var rows []User
+	for _, u := range batch {
+		old, ok := existingByKey[userKey(u, column)]
+		if !ok {
+			inserted++
+			rows = append(rows, u)
+			continue
+		}
+		if !userChanged(old, u, column == "email") {
+			continue
+		}
+		updated++
+		rows = append(rows, u)
+	}
+	if len(rows) == 0 {
+		return 0, 0, nil
+	}
+
+	err = tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: conflictColumn}},
+		DoUpdates: clause.AssignmentColumns(updateColumns),
+	}).Create(&rows).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("批量写入用户失败: %w", err)
+	}
+	return inserted, updated, nil
+}
+
+// dedupeUsers 按 column 去重，保留最后出现的记录
+// 同一条 INSERT ... ON CONFLICT 语句不能两次更新同一行
+func dedupeUsers(users []User, column string) []User {
+	index := make(map[string]int, len(users))
+	var result []User
+	for _, u := range users {
+		key := userKey(u, column)
+		if i, ok := index[key]; ok {
+			result[i] = u
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, u)
+	}
+	return result
+}
+
+// userKey 返回用户在 column 上的值
+func userKey(u User, column string) string {
+	if column == "phone" {
+		return string(u.Phone)
+	}
+	return u.Email
+}
+
+// userChanged 判断 UpsertUsers 会更新的字段是否有变化
+func userChanged(old, u User, comparePhone bool) bool {
+	if old.Name != u.Name || old.Age != u.Age || old.Status != u.Status {
+		return true
+	}
+	if comparePhone && old.Phone != u.Phone {
+		return true
+	}
+	if (old.LastLoginAt == nil) != (u.LastLoginAt == nil) {
+		return true
+	}
+	return old.LastLoginAt != nil && !old.LastLoginAt.Equal(*u.LastLoginAt)
+}
+
+// defaultBatchSize ProcessUsersInBatches 未指定批次大小时每批处理的用户数
+const defaultBatchSize = 100
+
+// BatchErrorPolicy 批处理中某一批失败时的处理方式
+type BatchErrorPolicy int
+
+const (
+	AbortOnError  BatchErrorPolicy = iota // 立即停止，不再处理后续批次
+	CollectErrors                         // 记录错误，继续处理后续批次
+)
+
+// BatchProgress 批处理进度
+type BatchProgress struct {
+	Batch     int   // 已处理的批次数
+	Processed int   // 处理成功的用户数
+	Failed    int   // 处理失败的用户数
+	Total     int64 // 待处理的用户总数
+}
+
+// BatchOptions ProcessUsersInBatches 的可选配置
+type BatchOptions struct {
+	Policy     BatchErrorPolicy
+	OnProgress func(BatchProgress) // 每批处理完成后调用
+}
+
+// BatchError 某一批处理失败的错误，记录该批的ID范围便于重跑
+type BatchError struct {
+	Batch   int
+	FirstID uint
+	LastID  uint
+	Err     error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("第%d批（用户ID %d-%d）处理失败: %v", e.Batch, e.FirstID, e.LastID, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+/*
+ProcessUsersInBatches 按主键顺序分批处理用户，适用于大表上的批量任务（如重新计算用户状态）
+每批在独立的事务中调用 fn，某一批失败只回滚该批
+db 上可以带查询条件，如 db.Where("status = ?", "inactive")，只处理符合条件的用户
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - batchSize: 每批的用户数，小于等于0时使用 defaultBatchSize
+  - fn: 处理一批用户，tx 为该批的事务，不带 db 上的查询条件
+  - opts: 错误处理方式和进度回调
+
+返回值：
+  - BatchProgress: 最终的处理进度
+  - error: AbortOnError 时为失败批次的 *BatchError；CollectErrors 时为所有失败批次合并后的错误
+*/
+func ProcessUsersInBatches(ctx context.Context, db *gorm.DB, batchSize int, fn func(tx *gorm.DB, users []User) error, opts BatchOptions) (BatchProgress, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	db = db.WithContext(ctx)
+	query := db.Model(&User{}).Session(&gorm.Session{})
+	var progress BatchProgress
+	if opts.OnProgress != nil {
+		if err := query.Count(&progress.Total).Error; err != nil {
+			return progress, fmt.Errorf("统计待处理用户失败: %w", err)
+		}
+	}
+
+	// 写操作使用不带查询条件的新会话
+	writer := db.Session(&gorm.Session{NewDB: true})
+	var errs []error
+	var users []User
+	err := query.FindInBatches(&users, batchSize, func(_ *gorm.DB, batch int) error {
+		progress.Batch = batch
+		err := dbutil.WithTx(writer, func(tx *gorm.DB) error {
+			return fn(tx, users)
+		})
+		if err != nil {
+			progress.Failed += len(users)
+			errs = append(errs, &BatchError{Batch: batch, FirstID: users[0].ID, LastID: users[len(users)-1].ID, Err: err})
+		} else {
+			progress.Processed += len(users)
+		}
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+		if err != nil && opts.Policy == AbortOnError {
+			return errs[0]
+		}
+		return nil
+	}).Error
+
+	switch {
+	case len(errs) > 0 && opts.Policy == AbortOnError:
+		return progress, errs[0]
+	case err != nil:
+		return progress, fmt.Errorf("分批查询用户失败: %w", err)
+	}
+	return progress, errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package basics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// userExportColumns ExportUsers 导出的列，不包含密码哈希
+var userExportColumns = []dbutil.ExportColumn[User]{
+	{Name: "id", Value: func(u *User) interface{} { return u.ID }},
+	{Name: "name", Value: func(u *User) interface{} { return u.Name }},
+	{Name: "email", Value: func(u *User) interface{} { return u.Email }},
+	{Name: "phone", Value: func(u *User) interface{} { return string(u.Phone) }},
+	{Name: "age", Value: func(u *User) interface{} { return u.Age }},
+	{Name: "status", Value: func(u *User) interface{} { return u.Status }},
+	{Name: "last_login_at", Value: func(u *User) interface{} { return u.LastLoginAt }},
+	{Name: "created_at", Value: func(u *User) interface{} { return u.CreatedAt }},
+}
+
+/*
+ExportUsers 导出用户：把符合条件的用户按批写入 w，不会一次性加载全部用户
+参数：
+  - ctx: 上下文，取消或超时会中止导出
+  - db: GORM 数据库连接
+  - filter: 查询条件 scope，为 nil 时导出全部未删除的用户
+  - format: dbutil.ExportCSV 或 dbutil.ExportJSONLines
+  - w: 输出，如文件或 HTTP 响应
+
+返回值：
+  - int: 导出的用户数量
+  - error: 错误信息，出错时 w 中可能已写入部分数据
+*/
+func ExportUsers(ctx context.Context, db *gorm.DB, filter func(db *gorm.DB) *gorm.DB, format dbutil.ExportFormat, w io.Writer) (int, error) {
+	query := db.WithContext(ctx)
+	if filter != nil {
+		// 立即应用条件，分批查询的每一批都基于同一组条件
+		query = filter(query)
+	}
+	return dbutil.Export(query, format, w, userExportColumns, 0)
+}
+
+// TestExportUsers 测试按条件导出 CSV 和 JSON Lines
+func TestExportUsers(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "export.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	login := created.Add(time.Hour)
+	seed := []User{
+		{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Age: 28, Status: UserStatusActive, LastLoginAt: &login, CreatedAt: created},
+		{Name: "Bob, Jr.", Email: "bob@example.com", Phone: "13800000002", Age: 31, Status: UserStatusVIP, CreatedAt: created},
+		{Name: "Carol", Email: "carol@example.com", Phone: "13800000003", Age: 25, Status: UserStatusInactive, CreatedAt: created},
+	}
+	if err := db.Create(&seed).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+	if err := seed[0].SetPassword("secret password"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if err := db.Save(&seed[0]).Error; err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	notInactive := func(db *gorm.DB) *gorm.DB {
+		return db.Where("status <> ?", UserStatusInactive)
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		var out strings.Builder
+		n, err := ExportUsers(ctx, db, notInactive, dbutil.ExportCSV, &out)
+		if err != nil {
+			t.Fatalf("ExportUsers: %v", err)
+		}
+		want := "id,name,email,phone,age,status,last_login_at,created_at\n" +
+			"1,Alice,alice@example.com,13800000001,28,active,2024-01-02T04:04:05Z,2024-01-02T03:04:05Z\n" +
+			"2,\"Bob, Jr.\",bob@example.com,13800000002,31,vip,,2024-01-02T03:04:05Z\n"
+		if n != 2 || out.String() != want {
+			t.Errorf("导出 %d 条:\n%s\n预期:\n%s", n, out.String(), want)
+		}
+		if strings.Contains(out.String(), "$2") {
+			t.Errorf("导出内容不应包含密码哈希")
+		}
+	})
+
+	t.Run("JSON Lines", func(t *testing.T) {
+		var out strings.Builder
+		n, err := ExportUsers(ctx, db, nil, dbutil.ExportJSONLines, &out)
+		if err != nil {
+			t.Fatalf("ExportUsers: %v", err)
+		}
+		if n != 3 {
+			t.Errorf("预期导出3个用户，实际 %d", n)
+		}
+
+		scanner := bufio.NewScanner(strings.NewReader(out.String()))
+		var rows []map[string]interface{}
+		for scanner.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("解析 %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) != 3 || rows[1]["name"] != "Bob, Jr." || rows[1]["last_login_at"] != nil || rows[2]["status"] != "inactive" {
+			t.Errorf("导出内容不正确: %v", rows)
+		}
+		if !strings.HasPrefix(out.String(), `{"id":1,"name":"Alice",`) {
+			t.Errorf("预期按列的顺序输出，实际 %s", out.String())
+		}
+		// 手机号加密保存，导出的是解密后的明文，与加密密钥无关
+		testutil.AssertGoldenJSON(t, "export_users", rows)
+	})
+
+	t.Run("导出到文件", func(t *testing.T) {
+		// 导出文件写入测试自己的临时目录，测试结束后删除，失败时可用 TEST_KEEP_ARTIFACTS 保留
+		path := testutil.ArtifactPath(t, "exports/users.csv")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		n, err := ExportUsers(ctx, db, notInactive, dbutil.ExportCSV, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatalf("ExportUsers: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if lines := strings.Count(string(data), "\n"); n != 2 || lines != 3 {
+			t.Errorf("预期导出2个用户、共3行，实际 %d 个、%d 行", n, lines)
+		}
+	})
+
+	t.Run("不支持的格式", func(t *testing.T) {
+		if _, err := ExportUsers(ctx, db, nil, "xml", &strings.Builder{}); err == nil {
+			t.Errorf("预期返回错误")
+		}
+	})
+}
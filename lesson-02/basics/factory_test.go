@@ -0,0 +1,27 @@
+package basics
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// userFactory 创建有效的用户，姓名、邮箱和手机号带有递增序号，不会违反唯一索引
+var userFactory = testutil.NewFactory(func(n int) User {
+	return User{
+		Name:   fmt.Sprintf("User%d", n),
+		Email:  testutil.SeqEmail("user", n),
+		Phone:  dbutil.EncryptedString(testutil.SeqPhone(n)),
+		Age:    20,
+		Status: UserStatusActive,
+	}
+})
+
+// newUser 创建用户，overrides 可以修改默认值，如 func(u *User) { u.Age = 16 }
+func newUser(tb testing.TB, db *gorm.DB, overrides ...func(*User)) *User {
+	tb.Helper()
+	return userFactory.Create(tb, db, overrides...)
+}
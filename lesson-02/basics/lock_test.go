@@ -0,0 +1,139 @@
+package basics
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// ErrLockOutsideTx 在事务之外加锁，语句结束后锁立即释放，起不到保护作用
+var ErrLockOutsideTx = errors.New("行锁必须在事务中使用")
+
+/*
+LockUserForUpdate 在事务中查询并锁定用户（SELECT ... FOR UPDATE），直到事务提交或回滚
+用于"读取-修改-写回"的流程，如扣减额度：并发的事务会在加锁处等待，
+拿到锁时读到的是前一个事务提交后的数据，不会相互覆盖
+用法:
+
+	err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+		user, err := LockUserForUpdate(tx, id)
+		if err != nil {
+			return err
+		}
+		// 根据 user 计算新值并写回
+		return tx.Model(user).Update("status", next).Error
+	})
+
+MySQL/PostgreSQL 使用行锁，只阻塞同一用户上的其他加锁事务
+SQLite 不支持 FOR UPDATE（GORM 会忽略该子句），这里改为先执行一条不修改数据的 UPDATE，
+提前拿到数据库的写锁：同一时间只有一个写事务，其他写事务等待 busy_timeout 后才会报 database is locked，
+因此 SQLite 上需要配置 BusyTimeout（见 dbutil.SQLiteConfig），并通过 dbutil.WithTx 在锁冲突时重试
+参数：
+  - tx: 事务中的数据库连接，不在事务中时返回 ErrLockOutsideTx
+  - id: 用户ID
+
+返回值：
+  - *User: 已加锁的用户
+  - error: 用户不存在时返回 gorm.ErrRecordNotFound
+*/
+func LockUserForUpdate(tx *gorm.DB, id uint) (*User, error) {
+	if !dbutil.InTx(tx) {
+		return nil, ErrLockOutsideTx
+	}
+
+	if tx.Dialector.Name() == dbutil.DriverSQLite {
+		// SQLite 的事务默认在第一次写入时才获取写锁，先写一次避免两个事务读到同样的旧值
+		if err := tx.Exec("UPDATE users SET id = id WHERE id = ?", id).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var user User
+	err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// TestLockUserForUpdate 测试并发的读-改-写不会丢失更新
+func TestLockUserForUpdate(t *testing.T) {
+	db, err := dbutil.Open(dbutil.Config{
+		DSN:           filepath.Join(t.TempDir(), "lock.db"),
+		SQLite:        dbutil.SQLiteConfig{WAL: true, BusyTimeout: 5 * time.Second},
+		EncryptionKey: []byte("lock-test-encryption-key-0123456"),
+	}, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	db, _ = withTestTenant(db)
+
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	user := User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	t.Run("事务外加锁", func(t *testing.T) {
+		if _, err := LockUserForUpdate(db, user.ID); !errors.Is(err, ErrLockOutsideTx) {
+			t.Errorf("预期 ErrLockOutsideTx，实际 %v", err)
+		}
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+			_, err := LockUserForUpdate(tx, user.ID+100)
+			return err
+		})
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("预期 ErrRecordNotFound，实际 %v", err)
+		}
+	})
+
+	t.Run("并发读-改-写", func(t *testing.T) {
+		// 每个 goroutine 读出 age 加1后写回，读和写之间故意停顿，不加锁时会丢失更新
+		const workers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- dbutil.WithTx(db, func(tx *gorm.DB) error {
+					u, err := LockUserForUpdate(tx, user.ID)
+					if err != nil {
+						return err
+					}
+					time.Sleep(5 * time.Millisecond)
+					return tx.Model(u).Update("age", u.Age+1).Error
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("WithTx: %v", err)
+			}
+		}
+
+		var got User
+		if err := db.First(&got, user.ID).Error; err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if got.Age != workers {
+			t.Errorf("age = %d，预期 %d", got.Age, workers)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package basics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPasswordMismatch 密码错误，见 dbutil.ErrPasswordMismatch
+	ErrPasswordMismatch = dbutil.ErrPasswordMismatch
+	// ErrPlaintextPassword 试图把未经 SetPassword 处理的密码写入 password_hash
+	ErrPlaintextPassword = dbutil.ErrPlaintextPassword
+)
+
+// SetPassword 校验密码长度并保存 bcrypt 哈希，需要再保存 User 才会写入数据库
+func (u *User) SetPassword(password string) error {
+	hash, err := dbutil.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	return nil
+}
+
+// CheckPassword 校验密码，不匹配或用户没有设置密码时返回 ErrPasswordMismatch
+func (u *User) CheckPassword(password string) error {
+	return dbutil.CheckPassword(u.PasswordHash, password)
+}
+
+// BeforeSave 在 Create/Save/Update 前检查要写入的 password_hash 是 bcrypt 哈希，见 dbutil.GuardPasswordHash；
+// 同时填充手机号的盲索引，见 setPhoneIndex
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	if err := dbutil.GuardPasswordHash(tx, u.PasswordHash); err != nil {
+		return err
+	}
+	return u.setPhoneIndex(tx)
+}
+
+/*
+ChangePassword 修改密码：校验旧密码后保存新密码
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+  - id: 用户ID
+  - oldPassword: 旧密码
+  - newPassword: 新密码，长度 8-72 字节且不能与旧密码相同
+
+返回值：
+  - error: 旧密码错误时返回 ErrPasswordMismatch
+*/
+func ChangePassword(ctx context.Context, db *gorm.DB, id uint, oldPassword, newPassword string) error {
+	user, err := GetUserByID(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if err := user.CheckPassword(oldPassword); err != nil {
+		return err
+	}
+	if newPassword == oldPassword {
+		return errors.New("新密码不能与旧密码相同")
+	}
+	if err := user.SetPassword(newPassword); err != nil {
+		return err
+	}
+	// 只更新 password_hash，避免覆盖并发修改的其他字段
+	if err := db.WithContext(ctx).Model(user).Update("password_hash", user.PasswordHash).Error; err != nil {
+		return fmt.Errorf("保存密码失败: %w", err)
+	}
+	return nil
+}
+
+// TestPassword 测试密码的设置、校验、修改和防止明文写入
+func TestPassword(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "password.db"))
+
+	// 降低计算强度加快测试
+	cost := dbutil.PasswordCost
+	dbutil.PasswordCost = bcrypt.MinCost
+	t.Cleanup(func() { dbutil.PasswordCost = cost })
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	alice := User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001"}
+	if err := alice.SetPassword("correct horse"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if err := db.Create(&alice).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	t.Run("SetPassword 和 CheckPassword", func(t *testing.T) {
+		got, err := GetUserByID(ctx, db, alice.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if !strings.HasPrefix(got.PasswordHash, "$2") || strings.Contains(got.PasswordHash, "correct horse") {
+			t.Errorf("数据库中应保存 bcrypt 哈希，实际 %q", got.PasswordHash)
+		}
+		if err := got.CheckPassword("correct horse"); err != nil {
+			t.Errorf("CheckPassword: %v", err)
+		}
+		if err := got.CheckPassword("wrong horse"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("预期 ErrPasswordMismatch，实际 %v", err)
+		}
+		if err := (&User{}).CheckPassword(""); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("没有设置密码时预期 ErrPasswordMismatch，实际 %v", err)
+		}
+		if err := got.SetPassword("short"); err == nil {
+			t.Errorf("预期过短的密码被拒绝")
+		}
+	})
+
+	t.Run("防止写入明文", func(t *testing.T) {
+		bob := User{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", PasswordHash: "plaintext"}
+		if err := db.Create(&bob).Error; !errors.Is(err, ErrPlaintextPassword) {
+			t.Errorf("Create 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Update("password_hash", "plaintext").Error; !errors.Is(err, ErrPlaintextPassword) {
+			t.Errorf("Update 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Updates(map[string]interface{}{"password_hash": "plaintext"}).Error; !errors.Is(err, ErrPlaintextPassword) {
+			t.Errorf("Updates 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+	})
+
+	t.Run("ChangePassword", func(t *testing.T) {
+		if err := ChangePassword(ctx, db, alice.ID, "wrong horse", "battery staple"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("旧密码错误时预期 ErrPasswordMismatch，实际 %v", err)
+		}
+		if err := ChangePassword(ctx, db, alice.ID, "correct horse", "correct horse"); err == nil {
+			t.Errorf("预期拒绝与旧密码相同的新密码")
+		}
+		if err := ChangePassword(ctx, db, alice.ID, "correct horse", "battery staple"); err != nil {
+			t.Fatalf("ChangePassword: %v", err)
+		}
+
+		got, err := GetUserByID(ctx, db, alice.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if err := got.CheckPassword("battery staple"); err != nil {
+			t.Errorf("新密码应生效: %v", err)
+		}
+		if err := got.CheckPassword("correct horse"); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("旧密码应失效，实际 %v", err)
+		}
+	})
+}
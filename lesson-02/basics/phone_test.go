@@ -0,0 +1,211 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// setPhoneIndex 根据要写入的手机号填充盲索引 phone_index
+// Create/Save 时根据 Phone 字段计算；Update("phone", ...)、Updates(map) 时
+// 把传入的手机号转成 EncryptedString 加密写入，并把盲索引加入要更新的列；
+// Updates(User{...}) 时根据传入结构体的 Phone 更新盲索引，见 dbutil.UpdatedValue
+func (u *User) setPhoneIndex(tx *gorm.DB) error {
+	index, err := phoneIndex(string(u.Phone))
+	if err != nil {
+		return err
+	}
+	u.PhoneIndex = index
+
+	values, ok := tx.Statement.Dest.(map[string]interface{})
+	if !ok {
+		if v, ok := dbutil.UpdatedValue(tx, "Phone"); ok {
+			index, err := phoneIndex(fmt.Sprint(v))
+			if err != nil {
+				return err
+			}
+			tx.Statement.SetColumn("PhoneIndex", index)
+		}
+		return nil
+	}
+	for _, key := range []string{"phone", "Phone"} {
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		phone := dbutil.EncryptedString(fmt.Sprint(v))
+		if v == nil {
+			phone = ""
+		}
+		index, err := phoneIndex(string(phone))
+		if err != nil {
+			return err
+		}
+		values[key] = phone
+		values["phone_index"] = index
+	}
+	return nil
+}
+
+// phoneIndex 返回手机号的盲索引，没有手机号时返回 nil，写入 NULL
+// 唯一索引允许多个 NULL，没有手机号的用户不会互相冲突
+func phoneIndex(phone string) (*string, error) {
+	if phone == "" {
+		return nil, nil
+	}
+	index, err := dbutil.BlindIndex(phone)
+	if err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// TestPhoneEncryption 测试手机号加密保存和按盲索引查询
+func TestPhoneEncryption(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "phone.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	alice := User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001"}
+	bob := User{Name: "Bob", Email: "bob@example.com", Phone: "13800000002"}
+	if err := db.Create([]*User{&alice, &bob}).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+
+	// rawPhone 读取数据库中实际保存的 phone 和 phone_index
+	rawPhone := func(id uint) (phone, index string) {
+		row := db.Raw("SELECT phone, phone_index FROM users WHERE id = ?", id).Row()
+		if err := row.Scan(&phone, &index); err != nil {
+			t.Fatalf("read raw phone: %v", err)
+		}
+		return phone, index
+	}
+
+	t.Run("数据库中保存密文", func(t *testing.T) {
+		phone, index := rawPhone(alice.ID)
+		if phone == "" || strings.Contains(phone, "13800000001") {
+			t.Errorf("phone 列应保存密文，实际 %q", phone)
+		}
+		if want, _ := dbutil.BlindIndex("13800000001"); index != want {
+			t.Errorf("phone_index = %q，预期 %q", index, want)
+		}
+
+		// 同样的明文每次加密的结果不同
+		encrypted, err := dbutil.EncryptedString("13800000001").Value()
+		if err != nil {
+			t.Fatalf("Value: %v", err)
+		}
+		if encrypted == phone {
+			t.Errorf("每次加密应使用不同的 nonce")
+		}
+	})
+
+	t.Run("读取时解密", func(t *testing.T) {
+		got, err := GetUserByID(ctx, db, alice.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Phone != "13800000001" {
+			t.Errorf("Phone = %q，预期 13800000001", got.Phone)
+		}
+	})
+
+	t.Run("按手机号查询", func(t *testing.T) {
+		got, err := GetUserByPhone(ctx, db, "13800000002")
+		if err != nil {
+			t.Fatalf("GetUserByPhone: %v", err)
+		}
+		if got.ID != bob.ID {
+			t.Errorf("预期找到 Bob，实际 %s", got.Name)
+		}
+		if _, err := GetUserByPhone(ctx, db, "1380000000"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("不完整的手机号预期 ErrRecordNotFound，实际 %v", err)
+		}
+	})
+
+	t.Run("手机号唯一", func(t *testing.T) {
+		dup := User{Name: "Dup", Email: "dup@example.com", Phone: "13800000001"}
+		if err := db.Create(&dup).Error; err == nil {
+			t.Errorf("预期重复的手机号被唯一索引拒绝")
+		}
+	})
+
+	t.Run("Update 手机号", func(t *testing.T) {
+		if err := db.Model(&bob).Update("phone", "13900000002").Error; err != nil {
+			t.Fatalf("update phone: %v", err)
+		}
+		phone, _ := rawPhone(bob.ID)
+		if strings.Contains(phone, "13900000002") {
+			t.Errorf("Update 写入的手机号也应加密，实际 %q", phone)
+		}
+		got, err := GetUserByPhone(ctx, db, "13900000002")
+		if err != nil || got.ID != bob.ID {
+			t.Fatalf("按新手机号查询失败: %v", err)
+		}
+		if got.Phone != "13900000002" {
+			t.Errorf("Phone = %q，预期 13900000002", got.Phone)
+		}
+		if _, err := GetUserByPhone(ctx, db, "13800000002"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("旧手机号应查不到，实际 %v", err)
+		}
+	})
+
+	t.Run("Updates 结构体中的手机号", func(t *testing.T) {
+		if err := db.Model(&User{ID: bob.ID}).Updates(User{Phone: "13912345678"}).Error; err != nil {
+			t.Fatalf("updates phone: %v", err)
+		}
+		got, err := GetUserByPhone(ctx, db, "13912345678")
+		if err != nil || got.ID != bob.ID {
+			t.Fatalf("按新手机号查询失败: %v", err)
+		}
+		if _, err := GetUserByPhone(ctx, db, "13900000002"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("旧手机号应查不到，实际 %v", err)
+		}
+		// 没有修改手机号时盲索引不变
+		if err := db.Model(&User{ID: bob.ID}).Updates(User{Name: "Bobby"}).Error; err != nil {
+			t.Fatalf("updates name: %v", err)
+		}
+		if _, err := GetUserByPhone(ctx, db, "13912345678"); err != nil {
+			t.Errorf("修改姓名后按手机号查询失败: %v", err)
+		}
+	})
+
+	t.Run("没有手机号的用户", func(t *testing.T) {
+		for _, email := range []string{"nophone1@example.com", "nophone2@example.com"} {
+			if _, err := CreateUser(ctx, db, "NoPhone", email); err != nil {
+				t.Fatalf("CreateUser(%q): %v", email, err)
+			}
+		}
+		// 清空手机号后盲索引同样为 NULL
+		if err := db.Model(&alice).Update("phone", "").Error; err != nil {
+			t.Fatalf("clear phone: %v", err)
+		}
+		var count int64
+		if err := db.Model(&User{}).Where("phone_index IS NULL").Count(&count).Error; err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if count != 3 {
+			t.Errorf("预期 3 个用户的 phone_index 为 NULL，实际 %d", count)
+		}
+		if _, err := GetUserByPhone(ctx, db, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("空手机号预期 ErrRecordNotFound，实际 %v", err)
+		}
+	})
+
+	t.Run("密钥不匹配时读取失败", func(t *testing.T) {
+		var s dbutil.EncryptedString
+		if err := s.Scan("not-encrypted"); !errors.Is(err, dbutil.ErrDecrypt) {
+			t.Errorf("预期 ErrDecrypt，实际 %v", err)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package basics
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// randomUser1s 生成 n 个随机用户并写入数据库，约五分之一没有登录过
+func randomUser1s(t *testing.T, db *gorm.DB, g *testutil.Gen, n int, from, to time.Time) []User1 {
+	t.Helper()
+	users := make([]User1, n)
+	for i := range users {
+		users[i] = User1{
+			Name:      g.Name(),
+			Email:     g.Email(),
+			Age:       uint8(g.Age(10, 70)),
+			Status:    testutil.Pick(g, "active", "inactive", "banned"),
+			CreatedAt: g.Time(from, to),
+		}
+		if g.Chance(0.8) {
+			login := g.Time(from, to)
+			users[i].LastLoginAt = &login
+		}
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+	return users
+}
+
+// randomUserSearchOptions 随机组合查询条件，关键词取自已有的姓名和邮箱，偶尔带上 LIKE 通配符
+func randomUserSearchOptions(g *testutil.Gen, users []User1, from, to time.Time) UserSearchOptions {
+	var opts UserSearchOptions
+	if g.Chance(0.5) {
+		opts.MinAge = g.Age(10, 70)
+	}
+	if g.Chance(0.5) {
+		opts.MaxAge = g.Age(opts.MinAge, 70)
+	}
+	for _, status := range []string{"active", "inactive", "banned"} {
+		if g.Chance(0.3) {
+			opts.Statuses = append(opts.Statuses, status)
+		}
+	}
+	if g.Chance(0.5) {
+		u := testutil.Pick(g, users...)
+		source := []rune(testutil.Pick(g, u.Name, u.Email))
+		start := g.Intn(len(source))
+		opts.Pattern = string(source[start:g.IntBetween(start+1, len(source))])
+	}
+	if g.Chance(0.2) {
+		opts.Pattern += " " + testutil.Pick(g, "%", "_", "o_b", "a%e")
+	}
+	if g.Chance(0.4) {
+		opts.LastLoginAfter = g.Time(from, to)
+	}
+	if g.Chance(0.4) {
+		opts.LastLoginBefore = g.Time(from, to)
+	}
+	opts.Page = g.IntBetween(0, 3)
+	opts.Size = testutil.Pick(g, 0, 5, 10, 50)
+	return opts
+}
+
+// matchUser1 在内存中按 SearchUsersAdvanced 的规则判断用户是否符合条件
+func matchUser1(u User1, opts UserSearchOptions) bool {
+	if opts.MinAge > 0 && int(u.Age) < opts.MinAge {
+		return false
+	}
+	if opts.MaxAge > 0 && int(u.Age) > opts.MaxAge {
+		return false
+	}
+	if len(opts.Statuses) > 0 && !slices.Contains(opts.Statuses, u.Status) {
+		return false
+	}
+	for _, token := range strings.Fields(strings.ToLower(opts.Pattern)) {
+		if !strings.Contains(strings.ToLower(u.Name), token) && !strings.Contains(strings.ToLower(u.Email), token) {
+			return false
+		}
+	}
+	if !opts.LastLoginAfter.IsZero() && (u.LastLoginAt == nil || u.LastLoginAt.Before(opts.LastLoginAfter)) {
+		return false
+	}
+	if !opts.LastLoginBefore.IsZero() && (u.LastLoginAt == nil || !u.LastLoginAt.Before(opts.LastLoginBefore)) {
+		return false
+	}
+	return true
+}
+
+// TestSearchUsersAdvancedRandom 用随机数据和随机条件对比 SearchUsersAdvanced 与内存中的筛选结果
+// 失败时输出随机种子，设置 TEST_SEED 可以重现
+func TestSearchUsersAdvancedRandom(t *testing.T) {
+	db := testutil.NewTestDB(t, "search_random.db")
+	if err := db.AutoMigrate(&User1{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	g := testutil.NewGen(t)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 6, 0)
+	users := randomUser1s(t, db, g, 200, from, to)
+
+	for i := 0; i < 100; i++ {
+		opts := randomUserSearchOptions(g, users, from, to)
+
+		var want []User1
+		for _, u := range users {
+			if matchUser1(u, opts) {
+				want = append(want, u)
+			}
+		}
+		// 默认排序：创建时间倒序，相同时按 ID 倒序
+		slices.SortFunc(want, func(a, b User1) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return int(b.ID) - int(a.ID)
+		})
+		page, size := dbutil.NormalizePage(opts.Page, opts.Size)
+		start, end := min((page-1)*size, len(want)), min(page*size, len(want))
+
+		got, err := SearchUsersAdvanced(db, opts)
+		if err != nil {
+			t.Fatalf("SearchUsersAdvanced(%+v): %v", opts, err)
+		}
+		if got.Total != int64(len(want)) {
+			t.Fatalf("SearchUsersAdvanced(%+v) 总数 %d，预期 %d", opts, got.Total, len(want))
+		}
+		gotIDs := make([]uint, len(got.Items))
+		for j, u := range got.Items {
+			gotIDs[j] = u.ID
+		}
+		wantIDs := make([]uint, 0, end-start)
+		for _, u := range want[start:end] {
+			wantIDs = append(wantIDs, u.ID)
+		}
+		if !slices.Equal(gotIDs, wantIDs) {
+			t.Fatalf("SearchUsersAdvanced(%+v) 返回 %v，预期 %v", opts, gotIDs, wantIDs)
+		}
+	}
+}
+
+// TestUserRoundTripRandom 随机创建用户后按邮箱、手机号查询，结果应与写入的一致
+func TestUserRoundTripRandom(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "user_random.db"))
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	g := testutil.NewGen(t)
+
+	var created []User
+	for i := 0; i < 50; i++ {
+		name, email := g.Name(), g.Email()
+		user, err := CreateUser(ctx, db, name, email)
+		if err != nil {
+			t.Fatalf("CreateUser(%q, %q): %v", name, email, err)
+		}
+		// 补充年龄，约五分之一的用户没有手机号
+		if g.Chance(0.8) {
+			user.Phone = dbutil.EncryptedString(g.Phone())
+		}
+		user.Age = uint8(g.Age(18, 80))
+		if err := db.Save(user).Error; err != nil {
+			t.Fatalf("save user: %v", err)
+		}
+		created = append(created, *user)
+
+		// 已注册的邮箱不能再次注册
+		if _, err := CreateUser(ctx, db, g.Name(), testutil.Pick(g, created...).Email); err == nil {
+			t.Fatalf("重复的邮箱应被拒绝")
+		}
+	}
+
+	for _, want := range created {
+		got, err := GetUserByEmail(ctx, db, want.Email)
+		if err != nil {
+			t.Fatalf("GetUserByEmail(%q): %v", want.Email, err)
+		}
+		if got.ID != want.ID || got.Name != want.Name || got.Phone != want.Phone || got.Age != want.Age {
+			t.Errorf("GetUserByEmail(%q) = %+v，预期 %+v", want.Email, got, want)
+		}
+		if want.Phone == "" {
+			continue
+		}
+		got, err = GetUserByPhone(ctx, db, string(want.Phone))
+		if err != nil {
+			t.Fatalf("GetUserByPhone(%q): %v", want.Phone, err)
+		}
+		if got.ID != want.ID {
+			t.Errorf("GetUserByPhone(%q) 返回用户 %d，预期 %d", want.Phone, got.ID, want.ID)
+		}
+	}
+	if _, err := GetUserByPhone(ctx, db, g.Phone()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("未使用的手机号预期 ErrRecordNotFound，实际 %v", err)
+	}
+}
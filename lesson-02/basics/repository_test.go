@@ -0,0 +1,218 @@
+package basics
+
+import (
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Repository 通用仓储，为单个模型封装常用的增删改查
+// T 为 GORM 模型类型，主键需为 uint 类型的 ID 字段
+// 用法: users := NewRepository[User](db); users.GetByID(1)
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+// NewRepository 创建模型 T 的仓储
+func NewRepository[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// DB 返回仓储使用的数据库连接，用于仓储未覆盖的查询
+func (r *Repository[T]) DB() *gorm.DB {
+	return r.db
+}
+
+// Create 新增记录，成功后 entity 的主键和时间戳会被回填
+func (r *Repository[T]) Create(entity *T) error {
+	return r.db.Create(entity).Error
+}
+
+// GetByID 按主键查询，记录不存在时返回 gorm.ErrRecordNotFound
+func (r *Repository[T]) GetByID(id uint) (*T, error) {
+	var entity T
+	if err := r.db.First(&entity, id).Error; err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+// Update 保存 entity 的所有字段（包括零值），entity 必须已有主键
+func (r *Repository[T]) Update(entity *T) error {
+	return r.db.Save(entity).Error
+}
+
+// Delete 按主键删除，记录不存在时返回 gorm.ErrRecordNotFound
+// 模型带有 gorm.DeletedAt 字段时为软删除
+func (r *Repository[T]) Delete(id uint) error {
+	var entity T
+	result := r.db.Delete(&entity, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// List 按条件分页查询
+// filter 为查询条件 scope，可以同时指定排序，为 nil 时查询全部；分页规则同 Paginate
+func (r *Repository[T]) List(filter func(db *gorm.DB) *gorm.DB, page, size int) ([]T, error) {
+	var entities []T
+	if err := r.query(filter).Scopes(Paginate(page, size)).Find(&entities).Error; err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// Count 统计符合条件的记录数，与 List 配合得到分页总数
+func (r *Repository[T]) Count(filter func(db *gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	err := r.query(filter).Count(&total).Error
+	return total, err
+}
+
+// query 以模型 T 为主表构建查询
+func (r *Repository[T]) query(filter func(db *gorm.DB) *gorm.DB) *gorm.DB {
+	var entity T
+	query := r.db.Model(&entity)
+	if filter != nil {
+		query = query.Scopes(filter)
+	}
+	return query
+}
+
+// TestRepository 测试通用仓储的增删改查
+func TestRepository(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "repository.db"))
+
+	// 每次运行前重建表，避免上次运行留下的数据影响结果
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	users := NewRepository[User](db)
+
+	t.Run("create and get", func(t *testing.T) {
+		u := &User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Age: 28, Status: "active"}
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if u.ID == 0 {
+			t.Fatalf("expected id to be populated")
+		}
+
+		got, err := users.GetByID(u.ID)
+		if err != nil {
+			t.Fatalf("get by id: %v", err)
+		}
+		if got.Email != u.Email {
+			t.Errorf("expected email %s, got %s", u.Email, got.Email)
+		}
+
+		if _, err := users.GetByID(9999); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("expected record not found, got %v", err)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		u := &User{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Age: 31, Status: "active"}
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		u.Status = "vip"
+		u.Age = 0 // Update 会写入零值
+		if err := users.Update(u); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		got, err := users.GetByID(u.ID)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if got.Status != "vip" || got.Age != 0 {
+			t.Errorf("unexpected updated values: %+v", got)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		u := &User{Name: "Carol", Email: "carol@example.com", Phone: "13800000003", Status: "inactive"}
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := users.Delete(u.ID); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := users.GetByID(u.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("expected record not found after delete, got %v", err)
+		}
+		if err := users.Delete(u.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("expected record not found when deleting twice, got %v", err)
+		}
+	})
+
+	t.Run("list and count", func(t *testing.T) {
+		for i := 1; i <= 5; i++ {
+			u := &User{Name: fmt.Sprintf("User%d", i), Email: fmt.Sprintf("user%d@test.com", i), Phone: dbutil.EncryptedString(fmt.Sprintf("1390000000%d", i)), Status: "active"}
+			if err := users.Create(u); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+
+		active := func(db *gorm.DB) *gorm.DB {
+			return db.Where("status = ?", "active").Order("id ASC")
+		}
+		total, err := users.Count(active)
+		if err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if total != 6 { // Alice + User1~5
+			t.Errorf("expected 6 active users, got %d", total)
+		}
+
+		page2, err := users.List(active, 2, 4)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(page2) != 2 {
+			t.Errorf("expected 2 users on page 2, got %d", len(page2))
+		}
+
+		all, err := users.List(nil, 1, 100)
+		if err != nil {
+			t.Fatalf("list all: %v", err)
+		}
+		if len(all) != 7 {
+			t.Errorf("expected 7 users, got %d", len(all))
+		}
+	})
+
+	t.Run("CreateUser and SearchUsers", func(t *testing.T) {
+		u, err := CreateUser(ctx, db, "Dave", "dave@example.com")
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if u.Status != "active" {
+			t.Errorf("expected default status active, got %s", u.Status)
+		}
+		if _, err := CreateUser(ctx, db, "Dave2", "dave@example.com"); err == nil {
+			t.Errorf("expected duplicate email to be rejected")
+		}
+
+		found, err := SearchUsers(ctx, db, "@test.com", 1, 2)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		if found.Total != 5 || len(found.Items) != 2 || found.TotalPages != 3 {
+			t.Errorf("expected 5 users in 3 pages of 2, got total %d, %d items, %d pages",
+				found.Total, len(found.Items), found.TotalPages)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package basics
+
+import (
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+)
+
+func init() {
+	testutil.RegisterFixtureModels(&User{})
+}
+
+// TestSearchUsers 测试多字段用户搜索
+func TestSearchUsers(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "search.db"))
+
+	if err := db.Migrator().DropTable(&User{}, userFTSTable); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	testutil.LoadFixtures(t, db, "testdata/fixtures/search_users.yaml")
+
+	names := func(users []User) string {
+		result := make([]string, len(users))
+		for i, u := range users {
+			result[i] = u.Name
+		}
+		return strings.Join(result, ",")
+	}
+
+	t.Run("LIKE 搜索", func(t *testing.T) {
+		cases := []struct {
+			q    string
+			want string
+		}{
+			// 姓名前缀匹配的排在邮箱中间包含的前面
+			{"alice", "Alice Wang,Bob"},
+			{"ali", "Alice Wang,Alicia Li,Bob"},
+			{"ALICE example", "Alice Wang,Bob"},
+			{"bob", "Bob"},
+			// 手机号加密保存，只能完全匹配
+			{"13900000004", "Carol"},
+			{"139", ""},
+			// 通配符按普通字符匹配
+			{"100%", "Percent 100%"},
+			{"_", ""},
+			{"nobody", ""},
+		}
+		for _, c := range cases {
+			result, err := SearchUsers(ctx, db, c.q, 1, 20)
+			if err != nil {
+				t.Fatalf("SearchUsers(ctx, %q): %v", c.q, err)
+			}
+			if got := names(result.Items); got != c.want {
+				t.Errorf("SearchUsers(ctx, %q) = [%s]，预期 [%s]", c.q, got, c.want)
+			}
+		}
+
+		if _, err := SearchUsers(ctx, db, "   ", 1, 20); err == nil {
+			t.Errorf("预期空关键词返回错误")
+		}
+	})
+
+	t.Run("完全匹配优先", func(t *testing.T) {
+		result, err := SearchUsers(ctx, db, "carol@example.com", 1, 20)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		if result.Total != 1 || result.Items[0].Name != "Carol" {
+			t.Errorf("预期只找到 Carol，实际 [%s]", names(result.Items))
+		}
+	})
+
+	t.Run("分页", func(t *testing.T) {
+		// "ali" 共匹配3个用户，第2页只剩最后一个
+		result, err := SearchUsers(ctx, db, "ali", 2, 2)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		if result.Total != 3 || result.HasNext || names(result.Items) != "Bob" {
+			t.Errorf("预期第2页只有 Bob 且没有下一页，实际 [%s] %+v", names(result.Items), result)
+		}
+	})
+
+	t.Run("FTS5 搜索", func(t *testing.T) {
+		if err := EnableUserFTS(db); err != nil {
+			t.Skipf("当前环境不支持 FTS5: %v", err)
+		}
+
+		result, err := SearchUsers(ctx, db, "alic", 1, 20)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		if result.Total != 3 {
+			t.Errorf("预期找到3个用户，实际 [%s]", names(result.Items))
+		}
+
+		// 新增的用户通过触发器同步到索引
+		if _, err := CreateUser(ctx, db, "Dave", "dave@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		result, err = SearchUsers(ctx, db, "dave", 1, 20)
+		if err != nil {
+			t.Fatalf("SearchUsers: %v", err)
+		}
+		if result.Total != 1 {
+			t.Errorf("预期找到 Dave，实际 [%s]", names(result.Items))
+		}
+	})
+}
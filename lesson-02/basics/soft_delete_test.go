@@ -0,0 +1,157 @@
+package basics
+
+import (
+	"errors"
+	"gohomeworklesson02/testutil"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TestUserSoftDelete 测试用户软删除、恢复和清理
+func TestUserSoftDelete(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "soft_delete.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	recent := time.Now().Add(-24 * time.Hour)
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	seed := []User{
+		{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Status: "active", LastLoginAt: &recent},
+		{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Status: "active", LastLoginAt: &old},
+		{Name: "Carol", Email: "carol@example.com", Phone: "13800000003", Status: "inactive"}, // 从未登录
+	}
+	if err := db.Create(&seed).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+
+	t.Run("DeleteInactiveUsers 软删除", func(t *testing.T) {
+		if err := DeleteInactiveUsers(ctx, db); err != nil {
+			t.Fatalf("DeleteInactiveUsers: %v", err)
+		}
+
+		testutil.AssertCount(t, db, &User{}, nil, 1)
+		// Unscoped 可以查到软删除的记录
+		testutil.AssertCount(t, db.Unscoped(), &User{}, nil, 3)
+		testutil.AssertSoftDeleted(t, db, &User{}, seed[1].ID)
+		testutil.AssertSoftDeleted(t, db, &User{}, seed[2].ID)
+	})
+
+	t.Run("CreateUser 拒绝已软删除用户的邮箱", func(t *testing.T) {
+		// 邮箱的唯一索引包含已软删除的用户，应返回业务错误而不是唯一索引冲突
+		_, err := CreateUser(ctx, db, "Carol2", "carol@example.com")
+		if err == nil || err.Error() != "邮箱已被注册" {
+			t.Errorf("预期返回 邮箱已被注册，实际 %v", err)
+		}
+	})
+
+	t.Run("RestoreUser", func(t *testing.T) {
+		if err := RestoreUser(ctx, db, seed[1].ID); err != nil {
+			t.Fatalf("RestoreUser: %v", err)
+		}
+		testutil.AssertExists(t, db, &User{}, map[string]interface{}{"id": seed[1].ID})
+
+		// 未被删除的用户和不存在的用户都不能恢复
+		if err := RestoreUser(ctx, db, seed[0].ID); err == nil {
+			t.Errorf("预期恢复未删除的用户时返回错误")
+		}
+		if err := RestoreUser(ctx, db, 9999); err == nil {
+			t.Errorf("预期恢复不存在的用户时返回错误")
+		}
+	})
+
+	t.Run("PurgeDeletedUsers", func(t *testing.T) {
+		// Carol 刚被删除，保留期内不会被清理
+		purged, err := PurgeDeletedUsers(ctx, db, time.Hour)
+		if err != nil {
+			t.Fatalf("PurgeDeletedUsers: %v", err)
+		}
+		if purged != 0 {
+			t.Errorf("预期不清理保留期内的用户，实际清理 %d 个", purged)
+		}
+
+		// 把删除时间改到 10 天前再清理
+		if err := db.Unscoped().Model(&User{}).Where("id = ?", seed[2].ID).
+			Update("deleted_at", time.Now().Add(-10*24*time.Hour)).Error; err != nil {
+			t.Fatalf("update deleted_at: %v", err)
+		}
+		purged, err = PurgeDeletedUsers(ctx, db, 7*24*time.Hour)
+		if err != nil {
+			t.Fatalf("PurgeDeletedUsers: %v", err)
+		}
+		if purged != 1 {
+			t.Errorf("预期清理1个用户，实际 %d", purged)
+		}
+		if err := db.Unscoped().First(&User{}, seed[2].ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("预期用户已被永久删除，实际 %v", err)
+		}
+	})
+
+	t.Run("HardDeleteInactiveUsers", func(t *testing.T) {
+		if err := HardDeleteInactiveUsers(ctx, db); err != nil {
+			t.Fatalf("HardDeleteInactiveUsers: %v", err)
+		}
+		// Bob 超过30天未登录，被永久删除；Alice 保留
+		if err := db.Unscoped().First(&User{}, seed[1].ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("预期 Bob 已被永久删除，实际 %v", err)
+		}
+		testutil.AssertExists(t, db, &User{}, map[string]interface{}{"id": seed[0].ID})
+	})
+}
+
+// TestDeleteInactiveUsersClock 使用固定的时钟测试 30 天未登录的边界
+func TestDeleteInactiveUsersClock(t *testing.T) {
+	db, tenant := withTestTenant(testutil.NewTestDB(t, "soft_delete_clock.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	login := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Status: "active", LastLoginAt: &login}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	clock := testutil.NewFakeClock(login.Add(30*24*time.Hour - time.Minute))
+	ctx := clock.Context(tenant)
+	if err := DeleteInactiveUsers(ctx, db); err != nil {
+		t.Fatalf("DeleteInactiveUsers: %v", err)
+	}
+	if err := db.First(&User{}, user.ID).Error; err != nil {
+		t.Fatalf("未满30天不应删除: %v", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if err := DeleteInactiveUsers(ctx, db); err != nil {
+		t.Fatalf("DeleteInactiveUsers: %v", err)
+	}
+	if err := db.First(&User{}, user.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("超过30天应被删除，实际 %v", err)
+	}
+
+	// deleted_at 取自同一个时钟，保留期按时钟计算
+	purged, err := PurgeDeletedUsers(ctx, db, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("保留期内不应清理，实际清理 %d 个", purged)
+	}
+	clock.Advance(7*24*time.Hour + time.Minute)
+	if purged, err = PurgeDeletedUsers(ctx, db, 7*24*time.Hour); err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("超过保留期应清理1个用户，实际 %d", purged)
+	}
+}
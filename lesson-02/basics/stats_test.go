@@ -0,0 +1,171 @@
+package basics
+
+import (
+	"context"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StatusCount 各状态的用户数
+type StatusCount struct {
+	Status UserStatus
+	Count  int64
+}
+
+// AgeBucketCount 各年龄段的用户数
+type AgeBucketCount struct {
+	Bucket string
+	Count  int64
+}
+
+// DailyCount 每天的注册数，Day 格式为 YYYY-MM-DD
+type DailyCount struct {
+	Day   string
+	Count int64
+}
+
+// UserStatsResult UserStats 的统计结果，不包括已软删除的用户
+type UserStatsResult struct {
+	Total         int64
+	ByStatus      []StatusCount    // 按状态名排序，没有用户的状态不出现
+	ByAge         []AgeBucketCount // 按 ageBuckets 的顺序，包含人数为0的年龄段
+	SignupsPerDay []DailyCount     // 按日期升序，没有注册的日期不出现
+}
+
+// ageBuckets 年龄段划分，Max 为0表示不设上限
+var ageBuckets = []struct {
+	Label    string
+	Min, Max int
+}{
+	{"0-17", 0, 17},
+	{"18-24", 18, 24},
+	{"25-34", 25, 34},
+	{"35-44", 35, 44},
+	{"45+", 45, 0},
+}
+
+// ageBucketExpr 返回把 age 映射为年龄段名称的 CASE 表达式
+func ageBucketExpr() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for _, bucket := range ageBuckets {
+		if bucket.Max == 0 {
+			fmt.Fprintf(&b, " WHEN age >= %d THEN '%s'", bucket.Min, bucket.Label)
+		} else {
+			fmt.Fprintf(&b, " WHEN age BETWEEN %d AND %d THEN '%s'", bucket.Min, bucket.Max, bucket.Label)
+		}
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+/*
+UserStats 用户统计：总数、按状态计数、年龄段分布、每天注册数
+所有统计都在数据库中用 GROUP BY 完成，不加载用户记录
+参数：
+  - ctx: 上下文，取消或超时会中止查询
+  - db: GORM 数据库连接
+
+返回值：
+  - *UserStatsResult: 统计结果
+  - error: 错误信息
+*/
+func UserStats(ctx context.Context, db *gorm.DB) (*UserStatsResult, error) {
+	db = db.WithContext(ctx)
+	users := func() *gorm.DB { return db.Model(&User{}) }
+	var result UserStatsResult
+
+	if err := users().Count(&result.Total).Error; err != nil {
+		return nil, fmt.Errorf("统计用户总数失败: %w", err)
+	}
+
+	err := users().
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Order("status").
+		Scan(&result.ByStatus).Error
+	if err != nil {
+		return nil, fmt.Errorf("按状态统计失败: %w", err)
+	}
+
+	var buckets []AgeBucketCount
+	err = users().
+		Select(ageBucketExpr() + " AS bucket, COUNT(*) AS count").
+		Group("bucket").
+		Scan(&buckets).Error
+	if err != nil {
+		return nil, fmt.Errorf("按年龄段统计失败: %w", err)
+	}
+	counts := make(map[string]int64, len(buckets))
+	for _, b := range buckets {
+		counts[b.Bucket] = b.Count
+	}
+	for _, bucket := range ageBuckets {
+		result.ByAge = append(result.ByAge, AgeBucketCount{Bucket: bucket.Label, Count: counts[bucket.Label]})
+	}
+
+	err = users().
+		Select(dbutil.DateExpr(db, "created_at") + " AS day, COUNT(*) AS count").
+		Group("day").
+		Order("day").
+		Scan(&result.SignupsPerDay).Error
+	if err != nil {
+		return nil, fmt.Errorf("按天统计注册数失败: %w", err)
+	}
+
+	return &result, nil
+}
+
+// TestUserStats 测试用户统计
+func TestUserStats(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "stats.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	day1 := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 5, 3, 18, 0, 0, 0, time.UTC)
+	user := func(age uint8, status UserStatus, createdAt time.Time) func(*User) {
+		return func(u *User) { u.Age, u.Status, u.CreatedAt = age, status, createdAt }
+	}
+	seed := []*User{
+		newUser(t, db, user(16, UserStatusPending, day1)),
+		newUser(t, db, user(18, UserStatusActive, day1)),
+		newUser(t, db, user(24, UserStatusActive, day1.Add(time.Hour))),
+		newUser(t, db, user(30, UserStatusVIP, day2)),
+		newUser(t, db, user(60, UserStatusActive, day2)),
+		newUser(t, db, user(40, UserStatusInactive, day2)),
+	}
+	// 已删除的用户不参与统计
+	if err := db.Delete(seed[5]).Error; err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	stats, err := UserStats(ctx, db)
+	if err != nil {
+		t.Fatalf("UserStats: %v", err)
+	}
+
+	if stats.Total != 5 {
+		t.Errorf("预期总数 5，实际 %d", stats.Total)
+	}
+	if got := fmt.Sprint(stats.ByStatus); got != "[{active 3} {pending 1} {vip 1}]" {
+		t.Errorf("按状态统计 = %s", got)
+	}
+	if got := fmt.Sprint(stats.ByAge); got != "[{0-17 1} {18-24 2} {25-34 1} {35-44 0} {45+ 1}]" {
+		t.Errorf("年龄段分布 = %s", got)
+	}
+	if got := fmt.Sprint(stats.SignupsPerDay); got != "[{2024-05-01 3} {2024-05-03 2}]" {
+		t.Errorf("每天注册数 = %s", got)
+	}
+}
@@ -0,0 +1,81 @@
+package basics
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// testTenant 不专门测试租户隔离的用例都在这个租户中读写
+const testTenant = 1
+
+// withTestTenant 返回带有 testTenant 的连接和 context
+// 测试连接注册了 TenantPlugin，context 中没有租户时读写 User 会返回 dbutil.ErrNoTenant
+func withTestTenant(db *gorm.DB) (*gorm.DB, context.Context) {
+	ctx := dbutil.WithTenant(context.Background(), testTenant)
+	return db.WithContext(ctx), ctx
+}
+
+// TestUserTenantIsolation 测试用户相关函数在不同租户之间互相隔离
+func TestUserTenantIsolation(t *testing.T) {
+	db := testutil.NewTestDB(t, "tenant.db")
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	acme := dbutil.WithTenant(context.Background(), 1)
+	globex := dbutil.WithTenant(context.Background(), 2)
+
+	alice, err := CreateUser(acme, db, "Alice", "alice@acme.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	bob := &User{Name: "Bob", Email: "bob@globex.com", Phone: "13800000002"}
+	if _, err := UpsertUserByEmail(globex, db, bob); err != nil {
+		t.Fatalf("UpsertUserByEmail: %v", err)
+	}
+
+	if _, err := GetUserByID(globex, db, alice.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("租户 2 不应查到租户 1 的用户，实际 %v", err)
+	}
+	if _, err := GetUserByEmail(acme, db, "bob@globex.com"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("租户 1 不应按邮箱查到租户 2 的用户，实际 %v", err)
+	}
+
+	// 批量更新只影响本租户的用户
+	if err := UpdateUserStatus(globex, db, []uint{alice.ID, bob.ID}, UserStatusSuspended); err != nil {
+		t.Fatalf("UpdateUserStatus: %v", err)
+	}
+	got, err := GetUserByID(acme, db, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Status != UserStatusActive || got.TenantID != 1 {
+		t.Errorf("租户 1 的用户不应被修改: %+v", got)
+	}
+
+	result, err := SearchUsers(acme, db, "globex", 1, 20)
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	if result.Total != 0 {
+		t.Errorf("搜索不应返回其他租户的用户，实际 %d 个", result.Total)
+	}
+
+	// 忘记传入租户时不会跨租户读取，需要访问所有租户时显式使用 WithAllTenants
+	if _, err := GetUserByID(context.Background(), db, alice.ID); !errors.Is(err, dbutil.ErrNoTenant) {
+		t.Errorf("没有租户时预期 ErrNoTenant，实际 %v", err)
+	}
+	var count int64
+	if err := db.WithContext(dbutil.WithAllTenants(context.Background())).Model(&User{}).Count(&count).Error; err != nil || count != 2 {
+		t.Errorf("WithAllTenants 预期 2 个用户，实际 %d, %v", count, err)
+	}
+}
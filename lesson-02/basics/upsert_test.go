@@ -0,0 +1,245 @@
+package basics
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestUpsertUsers 测试批量新增或更新用户
+func TestUpsertUsers(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "upsert.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	login := time.Now().Add(-time.Hour).Truncate(time.Second)
+	seed := []User{
+		{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Age: 28, Status: "active", LastLoginAt: &login},
+		{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Age: 31, Status: "active"},
+		{Name: "Carol", Email: "carol@example.com", Phone: "13800000003", Age: 25, Status: "inactive"},
+	}
+	if err := db.Create(&seed).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+
+	t.Run("新增和更新", func(t *testing.T) {
+		input := []User{
+			{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Age: 28, Status: "active", LastLoginAt: &login}, // 未变化
+			{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Age: 32, Status: "vip"},                             // 变化
+			{Name: "Dave", Email: "dave@example.com", Phone: "13800000004", Age: 40, Status: "active"},                        // 新增
+			{Name: "Eve", Email: "eve@example.com", Phone: "13800000005", Age: 22, Status: "pending"},                         // 新增
+			{Name: "Eve", Email: "eve@example.com", Phone: "13800000005", Age: 23, Status: "active"},                          // 重复，以此条为准
+			{Name: "Carol Phone", Phone: "13800000003", Age: 26, Status: "active"},                                            // 按手机号匹配
+		}
+		inserted, updated, err := UpsertUsers(ctx, db, input)
+		if err != nil {
+			t.Fatalf("UpsertUsers: %v", err)
+		}
+		if inserted != 2 || updated != 2 {
+			t.Errorf("预期新增2个、更新2个，实际新增 %d、更新 %d", inserted, updated)
+		}
+
+		var bob User
+		if err := db.Where("email = ?", "bob@example.com").First(&bob).Error; err != nil {
+			t.Fatalf("query bob: %v", err)
+		}
+		if bob.ID != seed[1].ID || bob.Age != 32 || bob.Status != "vip" {
+			t.Errorf("Bob 更新不正确: %+v", bob)
+		}
+
+		var eve User
+		if err := db.Where("email = ?", "eve@example.com").First(&eve).Error; err != nil {
+			t.Fatalf("query eve: %v", err)
+		}
+		if eve.Age != 23 || eve.Status != "active" {
+			t.Errorf("重复记录应以最后一条为准: %+v", eve)
+		}
+
+		var carol User
+		if err := db.First(&carol, seed[2].ID).Error; err != nil {
+			t.Fatalf("query carol: %v", err)
+		}
+		if carol.Name != "Carol Phone" || carol.Email != "carol@example.com" {
+			t.Errorf("按手机号匹配时应更新姓名且保留邮箱: %+v", carol)
+		}
+
+		var total int64
+		if err := db.Model(&User{}).Count(&total).Error; err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if total != 5 {
+			t.Errorf("预期共5个用户，实际 %d", total)
+		}
+
+		// 手机号加密后每次都不同，自增 ID 在不同数据库中可能不连续，都不放入快照
+		testutil.SnapshotTable(t, db, "users", testutil.SnapshotOptions{
+			Columns: []string{"name", "email", "age", "status", "last_login_at", "deleted_at"},
+			OrderBy: "email",
+		})
+	})
+
+	t.Run("重复执行不产生更新", func(t *testing.T) {
+		input := []User{
+			{Name: "Dave", Email: "dave@example.com", Phone: "13800000004", Age: 40, Status: "active"},
+		}
+		inserted, updated, err := UpsertUsers(ctx, db, input)
+		if err != nil {
+			t.Fatalf("UpsertUsers: %v", err)
+		}
+		if inserted != 0 || updated != 0 {
+			t.Errorf("预期没有写入，实际新增 %d、更新 %d", inserted, updated)
+		}
+	})
+
+	t.Run("分批写入", func(t *testing.T) {
+		// 在事务中写入，子测试结束后回滚，不影响后面的子测试
+		db := testutil.NewTestTx(t, db)
+		input := make([]User, 0, upsertBatchSize+50)
+		for i := 0; i < upsertBatchSize+50; i++ {
+			input = append(input, User{
+				Name:   fmt.Sprintf("Batch%03d", i),
+				Email:  fmt.Sprintf("batch%03d@example.com", i),
+				Phone:  dbutil.EncryptedString(fmt.Sprintf("1390000%04d", i)),
+				Status: "active",
+			})
+		}
+		inserted, updated, err := UpsertUsers(ctx, db, input)
+		if err != nil {
+			t.Fatalf("UpsertUsers: %v", err)
+		}
+		if inserted != upsertBatchSize+50 || updated != 0 {
+			t.Errorf("预期新增 %d 个，实际新增 %d、更新 %d", upsertBatchSize+50, inserted, updated)
+		}
+	})
+
+	t.Run("邮箱和手机号都为空", func(t *testing.T) {
+		if _, _, err := UpsertUsers(ctx, db, []User{{Name: "Nobody"}}); err == nil {
+			t.Errorf("预期返回错误")
+		}
+	})
+
+	t.Run("事务回滚", func(t *testing.T) {
+		var total int64
+		if err := db.Model(&User{}).Count(&total).Error; err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if total != 5 {
+			t.Errorf("分批写入的用户应已回滚，预期共5个用户，实际 %d", total)
+		}
+
+		// 嵌套调用使用保存点，回滚到保存点后外层事务的写入仍然保留
+		tx := testutil.NewTestTx(t, db)
+		var outer, inner int64
+		t.Run("外层", func(t *testing.T) {
+			if err := tx.Create(&User{Name: "Frank", Email: "frank@example.com", Phone: "13800000006"}).Error; err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			t.Run("内层", func(t *testing.T) {
+				tx := testutil.NewTestTx(t, tx)
+				if err := tx.Create(&User{Name: "Grace", Email: "grace@example.com", Phone: "13800000007"}).Error; err != nil {
+					t.Fatalf("create: %v", err)
+				}
+				tx.Model(&User{}).Count(&inner)
+			})
+			tx.Model(&User{}).Count(&outer)
+		})
+		if inner != 7 || outer != 6 {
+			t.Errorf("预期内层 7 个、回滚保存点后 6 个用户，实际 %d、%d", inner, outer)
+		}
+	})
+}
+
+// TestUpsertUserByEmail 测试按邮箱新增或更新单个用户
+func TestUpsertUserByEmail(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "upsert_email.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	var aliceID uint
+	t.Run("新增", func(t *testing.T) {
+		u := &User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Age: 28}
+		created, err := UpsertUserByEmail(ctx, db, u)
+		if err != nil {
+			t.Fatalf("UpsertUserByEmail: %v", err)
+		}
+		if !created || u.ID == 0 || u.Status != UserStatusActive {
+			t.Errorf("预期新增且使用默认状态，实际 created=%v %+v", created, u)
+		}
+		aliceID = u.ID
+	})
+
+	t.Run("更新", func(t *testing.T) {
+		u := &User{Name: "Alice Wang", Email: "alice@example.com", Phone: "13900000001", Age: 29}
+		created, err := UpsertUserByEmail(ctx, db, u)
+		if err != nil {
+			t.Fatalf("UpsertUserByEmail: %v", err)
+		}
+		if created || u.ID != aliceID {
+			t.Fatalf("预期更新 ID %d，实际 created=%v ID=%d", aliceID, created, u.ID)
+		}
+		if u.Name != "Alice Wang" || u.Age != 29 || u.Status != UserStatusActive {
+			t.Errorf("更新后的数据不正确: %+v", u)
+		}
+		// 手机号的盲索引随之更新
+		if got, err := GetUserByPhone(ctx, db, "13900000001"); err != nil || got.ID != aliceID {
+			t.Errorf("按新手机号查询失败: %v", err)
+		}
+	})
+
+	t.Run("并发写入同一邮箱", func(t *testing.T) {
+		const workers = 5
+		var wg sync.WaitGroup
+		results := make(chan bool, workers)
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				u := &User{Name: fmt.Sprintf("Bob%d", i), Email: "bob@example.com", Phone: "13800000002"}
+				created, err := UpsertUserByEmail(ctx, db, u)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results <- created
+			}(i)
+		}
+		wg.Wait()
+		close(results)
+		close(errs)
+		for err := range errs {
+			t.Fatalf("UpsertUserByEmail: %v", err)
+		}
+
+		createdCount := 0
+		for created := range results {
+			if created {
+				createdCount++
+			}
+		}
+		var count int64
+		db.Model(&User{}).Where("email = ?", "bob@example.com").Count(&count)
+		if createdCount != 1 || count != 1 {
+			t.Errorf("预期只新增1次且只有1条记录，实际新增 %d 次、%d 条记录", createdCount, count)
+		}
+	})
+
+	t.Run("邮箱为空", func(t *testing.T) {
+		if _, err := UpsertUserByEmail(ctx, db, &User{Name: "Nobody"}); err == nil {
+			t.Errorf("预期返回错误")
+		}
+	})
+}
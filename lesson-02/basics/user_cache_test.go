@@ -0,0 +1,436 @@
+package basics
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CacheStats 缓存命中统计
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64 // 因容量不足被淘汰的次数，不包括过期和主动失效
+}
+
+// HitRate 命中率，没有请求时为 0
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// cachedUser 缓存条目
+type cachedUser struct {
+	tenantID  uint
+	user      User
+	expiresAt time.Time
+}
+
+// cacheKey 按租户和用户ID索引缓存，不同租户的查询不会命中彼此的条目
+type cacheKey struct {
+	tenantID uint
+	id       uint
+}
+
+// cacheEmailKey 按租户和邮箱索引缓存
+type cacheEmailKey struct {
+	tenantID uint
+	email    string
+}
+
+// UserCache 用户查询的读穿透缓存（cache-aside），内存 LRU + TTL
+// 读：先查缓存，未命中再查数据库并写入缓存
+// 写：先写数据库，再让缓存失效，下次读取时重新加载
+// 缓存以用户为单位，按 ID 和邮箱都可以命中同一条目；条目按 ctx 中的租户区分，
+// ctx 中没有租户时（包括 WithAllTenants）不使用缓存，直接查询数据库
+type UserCache struct {
+	db       *gorm.DB
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time // 便于测试时替换时钟
+
+	mu      sync.Mutex
+	lru     *list.List // 元素为 *cachedUser，越靠前越近被使用
+	byID    map[cacheKey]*list.Element
+	byEmail map[cacheEmailKey]uint // 邮箱 -> 用户ID
+	stats   CacheStats
+	// generation 每次失效时加一，查询期间缓存失效过的结果不写入缓存，避免写回旧数据
+	generation uint64
+}
+
+// NewUserCache 创建用户缓存
+// 参数 capacity: 最多缓存的用户数，<= 0 时默认 1000
+// 参数 ttl: 缓存有效期，<= 0 时默认 5 分钟
+func NewUserCache(db *gorm.DB, capacity int, ttl time.Duration) *UserCache {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &UserCache{
+		db:       db,
+		capacity: capacity,
+		ttl:      ttl,
+		now:      time.Now,
+		lru:      list.New(),
+		byID:     make(map[cacheKey]*list.Element),
+		byEmail:  make(map[cacheEmailKey]uint),
+	}
+}
+
+// GetUserByID 按ID查询用户，优先读缓存
+func (c *UserCache) GetUserByID(ctx context.Context, id uint) (*User, error) {
+	query := func() (*User, error) { return GetUserByID(ctx, c.db, id) }
+	tenantID, ok := dbutil.TenantFrom(ctx)
+	if !ok {
+		return query()
+	}
+	c.mu.Lock()
+	user, ok := c.get(cacheKey{tenantID, id})
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+	return c.load(tenantID, generation, query)
+}
+
+// GetUserByEmail 按邮箱查询用户，优先读缓存
+func (c *UserCache) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := func() (*User, error) { return GetUserByEmail(ctx, c.db, email) }
+	tenantID, ok := dbutil.TenantFrom(ctx)
+	if !ok {
+		return query()
+	}
+	c.mu.Lock()
+	var user *User
+	id, ok := c.byEmail[cacheEmailKey{tenantID, email}]
+	if ok {
+		user, ok = c.get(cacheKey{tenantID, id})
+	} else {
+		c.stats.Misses++
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+	return c.load(tenantID, generation, query)
+}
+
+// UpdateUser 保存用户并使缓存失效
+func (c *UserCache) UpdateUser(ctx context.Context, user *User) error {
+	if err := c.db.WithContext(ctx).Save(user).Error; err != nil {
+		return fmt.Errorf("更新用户失败: %w", err)
+	}
+	c.Invalidate(ctx, user.ID)
+	return nil
+}
+
+// DeleteUser 删除用户并使缓存失效
+func (c *UserCache) DeleteUser(ctx context.Context, id uint) error {
+	if err := NewRepository[User](c.db.WithContext(ctx)).Delete(id); err != nil {
+		return fmt.Errorf("删除用户失败: %w", err)
+	}
+	c.Invalidate(ctx, id)
+	return nil
+}
+
+// Invalidate 移除 ctx 中租户的用户缓存，绕过 UserCache 修改数据后需要手动调用
+// 正在进行的查询结果也不会再写入缓存，见 load
+func (c *UserCache) Invalidate(ctx context.Context, id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	tenantID, ok := dbutil.TenantFrom(ctx)
+	if !ok {
+		return
+	}
+	if elem, ok := c.byID[cacheKey{tenantID, id}]; ok {
+		c.remove(elem)
+	}
+}
+
+// Stats 返回命中统计
+func (c *UserCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Len 返回当前缓存的用户数
+func (c *UserCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// get 读缓存并记录命中，调用方需持有锁
+// 返回副本，避免调用方修改缓存中的数据
+func (c *UserCache) get(key cacheKey) (*User, bool) {
+	elem, ok := c.byID[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cachedUser)
+	if c.now().After(entry.expiresAt) {
+		c.remove(elem)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	user := entry.user
+	return &user, true
+}
+
+// load 从数据库加载用户并写入租户的缓存，不存在的用户不缓存
+// generation 是查询前读到的值，查询期间有过失效时（如并发的 UpdateUser）查到的可能是旧数据，
+// 只返回不写入缓存，否则旧数据会一直留在缓存中直到过期
+func (c *UserCache) load(tenantID uint, generation uint64, query func() (*User, error)) (*User, error) {
+	user, err := query()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return user, nil
+	}
+	key := cacheKey{tenantID, user.ID}
+	if elem, ok := c.byID[key]; ok {
+		c.remove(elem)
+	}
+	entry := &cachedUser{tenantID: tenantID, user: *user, expiresAt: c.now().Add(c.ttl)}
+	c.byID[key] = c.lru.PushFront(entry)
+	c.byEmail[cacheEmailKey{tenantID, user.Email}] = user.ID
+
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return user, nil
+}
+
+// remove 删除缓存条目及其邮箱索引，调用方需持有锁
+func (c *UserCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedUser)
+	delete(c.byID, cacheKey{entry.tenantID, entry.user.ID})
+	email := cacheEmailKey{entry.tenantID, entry.user.Email}
+	if c.byEmail[email] == entry.user.ID {
+		delete(c.byEmail, email)
+	}
+}
+
+// TestUserCache 测试用户查询缓存
+func TestUserCache(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "user_cache.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	seed := []User{
+		{Name: "Alice", Email: "alice@example.com", Phone: "13800000001", Status: "active"},
+		{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Status: "active"},
+		{Name: "Carol", Email: "carol@example.com", Phone: "13800000003", Status: "active"},
+	}
+	if err := db.Create(&seed).Error; err != nil {
+		t.Fatalf("seed users: %v", err)
+	}
+
+	t.Run("命中与未命中", func(t *testing.T) {
+		cache := NewUserCache(db, 10, time.Minute)
+
+		if _, err := cache.GetUserByID(ctx, seed[0].ID); err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		// 按 ID 加载后，按邮箱也能命中
+		u, err := cache.GetUserByEmail(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("GetUserByEmail: %v", err)
+		}
+		if u.Name != "Alice" {
+			t.Errorf("预期 Alice，实际 %s", u.Name)
+		}
+
+		// 修改返回值不影响缓存
+		u.Name = "Changed"
+		again, _ := cache.GetUserByID(ctx, seed[0].ID)
+		if again.Name != "Alice" {
+			t.Errorf("缓存被调用方修改: %s", again.Name)
+		}
+
+		stats := cache.Stats()
+		if stats.Hits != 2 || stats.Misses != 1 {
+			t.Errorf("预期命中2次、未命中1次，实际 %+v", stats)
+		}
+
+		if _, err := cache.GetUserByID(ctx, 9999); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("预期 record not found，实际 %v", err)
+		}
+		if cache.Len() != 1 {
+			t.Errorf("不存在的用户不应被缓存，当前缓存 %d 个", cache.Len())
+		}
+	})
+
+	t.Run("更新和删除时失效", func(t *testing.T) {
+		cache := NewUserCache(db, 10, time.Minute)
+
+		bob, err := cache.GetUserByEmail(ctx, "bob@example.com")
+		if err != nil {
+			t.Fatalf("GetUserByEmail: %v", err)
+		}
+		bob.Email = "bobby@example.com"
+		bob.Status = "vip"
+		if err := cache.UpdateUser(ctx, bob); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+
+		got, err := cache.GetUserByID(ctx, bob.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Status != "vip" {
+			t.Errorf("更新后读到旧数据: %+v", got)
+		}
+		// 旧邮箱不应再命中缓存
+		if _, err := cache.GetUserByEmail(ctx, "bob@example.com"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("旧邮箱预期 record not found，实际 %v", err)
+		}
+
+		if err := cache.DeleteUser(ctx, bob.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := cache.GetUserByID(ctx, bob.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("删除后预期 record not found，实际 %v", err)
+		}
+	})
+
+	t.Run("TTL 过期", func(t *testing.T) {
+		cache := NewUserCache(db, 10, time.Minute)
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		if _, err := cache.GetUserByID(ctx, seed[2].ID); err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		// 绕过缓存直接修改数据库，TTL 内仍读到旧值
+		if err := db.Model(&User{}).Where("id = ?", seed[2].ID).Update("status", "suspended").Error; err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		u, _ := cache.GetUserByID(ctx, seed[2].ID)
+		if u.Status != "active" {
+			t.Errorf("TTL 内预期读到缓存值 active，实际 %s", u.Status)
+		}
+
+		now = now.Add(2 * time.Minute)
+		u, _ = cache.GetUserByID(ctx, seed[2].ID)
+		if u.Status != "suspended" {
+			t.Errorf("过期后预期重新加载 suspended，实际 %s", u.Status)
+		}
+	})
+
+	t.Run("租户隔离", func(t *testing.T) {
+		cache := NewUserCache(db, 10, time.Minute)
+		other := dbutil.WithTenant(context.Background(), testTenant+1)
+
+		if _, err := cache.GetUserByID(ctx, seed[0].ID); err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		// 已缓存的用户不能被其他租户按 ID 或邮箱读到
+		if _, err := cache.GetUserByID(other, seed[0].ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("其他租户按 ID 预期 record not found，实际 %v", err)
+		}
+		if _, err := cache.GetUserByEmail(other, "alice@example.com"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("其他租户按邮箱预期 record not found，实际 %v", err)
+		}
+		// 没有租户时不读缓存，由 TenantPlugin 拒绝
+		if _, err := cache.GetUserByID(context.Background(), seed[0].ID); !errors.Is(err, dbutil.ErrNoTenant) {
+			t.Errorf("没有租户时预期 ErrNoTenant，实际 %v", err)
+		}
+	})
+
+	t.Run("查询期间失效不写回旧数据", func(t *testing.T) {
+		cache := NewUserCache(db, 10, time.Minute)
+
+		// 模拟读取未命中后查询数据库，结果返回前另一个请求修改了用户并使缓存失效
+		cache.mu.Lock()
+		generation := cache.generation
+		cache.mu.Unlock()
+		stale, err := cache.load(testTenant, generation, func() (*User, error) {
+			user, err := GetUserByID(ctx, db, seed[0].ID)
+			if err != nil {
+				return nil, err
+			}
+			updated := *user
+			updated.Status = "vip"
+			if err := cache.UpdateUser(ctx, &updated); err != nil {
+				return nil, err
+			}
+			return user, nil
+		})
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		if stale.Status != "active" {
+			t.Fatalf("预期查询返回修改前的 active，实际 %s", stale.Status)
+		}
+		if cache.Len() != 0 {
+			t.Errorf("查询期间缓存失效过，结果不应写入缓存，当前缓存 %d 个", cache.Len())
+		}
+		got, err := cache.GetUserByID(ctx, seed[0].ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Status != "vip" {
+			t.Errorf("预期读到修改后的 vip，实际 %s", got.Status)
+		}
+		if err := db.Model(&User{}).Where("id = ?", seed[0].ID).Update("status", "active").Error; err != nil {
+			t.Fatalf("restore status: %v", err)
+		}
+	})
+
+	t.Run("LRU 淘汰", func(t *testing.T) {
+		cache := NewUserCache(db, 2, time.Minute)
+
+		cache.GetUserByID(ctx, seed[0].ID)
+		cache.GetUserByID(ctx, seed[2].ID)
+		cache.GetUserByID(ctx, seed[0].ID) // Alice 变为最近使用
+		if _, err := CreateUser(ctx, db, "Dave", "dave@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		cache.GetUserByEmail(ctx, "dave@example.com") // 淘汰 Carol
+
+		if cache.Len() != 2 {
+			t.Errorf("预期缓存2个用户，实际 %d", cache.Len())
+		}
+		before := cache.Stats()
+		cache.GetUserByID(ctx, seed[0].ID)
+		cache.GetUserByID(ctx, seed[2].ID)
+		after := cache.Stats()
+		if after.Hits-before.Hits != 1 || after.Misses-before.Misses != 1 {
+			t.Errorf("预期 Alice 命中、Carol 未命中，统计变化 %+v -> %+v", before, after)
+		}
+		if after.Evictions < 1 {
+			t.Errorf("预期发生淘汰，实际 %+v", after)
+		}
+	})
+}
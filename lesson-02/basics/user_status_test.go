@@ -0,0 +1,137 @@
+package basics
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gohomeworklesson02/testutil"
+	"testing"
+)
+
+// UserStatus 用户状态
+// 写入和读取数据库时都会校验，未知的状态值不会被保存，也不会被静默读出
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusInactive  UserStatus = "inactive"
+	UserStatusPending   UserStatus = "pending"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusVIP       UserStatus = "vip"
+)
+
+// ErrInvalidUserStatus 未知的用户状态
+var ErrInvalidUserStatus = errors.New("无效的用户状态")
+
+// UserStatuses 返回所有有效的用户状态
+func UserStatuses() []UserStatus {
+	return []UserStatus{UserStatusActive, UserStatusInactive, UserStatusPending, UserStatusSuspended, UserStatusVIP}
+}
+
+// ParseUserStatus 把字符串（如请求参数）转换为 UserStatus
+func ParseUserStatus(s string) (UserStatus, error) {
+	status := UserStatus(s)
+	if !status.Valid() {
+		return "", fmt.Errorf("%w: %q，有效值: %v", ErrInvalidUserStatus, s, UserStatuses())
+	}
+	return status, nil
+}
+
+// Valid 是否为有效的用户状态
+func (s UserStatus) Valid() bool {
+	for _, status := range UserStatuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 实现 driver.Valuer，拒绝写入未知的状态
+func (s UserStatus) Value() (driver.Value, error) {
+	if _, err := ParseUserStatus(string(s)); err != nil {
+		return nil, err
+	}
+	return string(s), nil
+}
+
+// Scan 实现 sql.Scanner，数据库中的未知状态返回错误；NULL 读为空字符串
+func (s *UserStatus) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("无法将 %T 转换为 UserStatus", value)
+	}
+	status, err := ParseUserStatus(raw)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// TestUserStatus 测试用户状态的解析和读写校验
+func TestUserStatus(t *testing.T) {
+	db, ctx := withTestTenant(testutil.NewTestDB(t, "user_status.db"))
+
+	if err := db.Migrator().DropTable(&User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	t.Run("ParseUserStatus", func(t *testing.T) {
+		if s, err := ParseUserStatus("vip"); err != nil || s != UserStatusVIP {
+			t.Errorf("ParseUserStatus(vip) = %q, %v", s, err)
+		}
+		for _, raw := range []string{"", "VIP", "deleted"} {
+			if _, err := ParseUserStatus(raw); !errors.Is(err, ErrInvalidUserStatus) {
+				t.Errorf("ParseUserStatus(%q) 预期 ErrInvalidUserStatus，实际 %v", raw, err)
+			}
+		}
+	})
+
+	t.Run("写入校验", func(t *testing.T) {
+		// 未指定状态时使用默认值 active
+		u := User{Name: "Alice", Email: "alice@example.com", Phone: "13800000001"}
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		got, err := GetUserByID(ctx, db, u.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Status != UserStatusActive {
+			t.Errorf("预期默认状态 active，实际 %q", got.Status)
+		}
+
+		bad := User{Name: "Bob", Email: "bob@example.com", Phone: "13800000002", Status: "deleted"}
+		if err := db.Create(&bad).Error; !errors.Is(err, ErrInvalidUserStatus) {
+			t.Errorf("预期拒绝写入未知状态，实际 %v", err)
+		}
+		if err := UpdateUserStatus(ctx, db, []uint{u.ID}, "deleted"); !errors.Is(err, ErrInvalidUserStatus) {
+			t.Errorf("预期 UpdateUserStatus 拒绝未知状态，实际 %v", err)
+		}
+		if err := UpdateUserStatus(ctx, db, []uint{u.ID}, UserStatusSuspended); err != nil {
+			t.Errorf("UpdateUserStatus: %v", err)
+		}
+	})
+
+	t.Run("读取校验", func(t *testing.T) {
+		// 绕过类型直接写入未知状态，读取时报错而不是得到一个非法的值
+		if err := db.Exec("UPDATE users SET status = ? WHERE email = ?", "deleted", "alice@example.com").Error; err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+		if _, err := GetUserByEmail(ctx, db, "alice@example.com"); !errors.Is(err, ErrInvalidUserStatus) {
+			t.Errorf("预期读取未知状态返回 ErrInvalidUserStatus，实际 %v", err)
+		}
+	})
+}
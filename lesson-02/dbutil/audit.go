@@ -0,0 +1,72 @@
+package dbutil
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// actorKey context 中保存操作人ID的键
+type actorKey struct{}
+
+// WithActor 返回带有操作人ID的 context
+// 用法: db.WithContext(dbutil.WithActor(ctx, userID)).Create(&post)
+func WithActor(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// ActorFrom 取出 context 中的操作人ID
+func ActorFrom(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	userID, ok := ctx.Value(actorKey{}).(uint)
+	return userID, ok
+}
+
+// AuditFields 审计字段，嵌入模型后由 AuditPlugin 自动填充
+type AuditFields struct {
+	CreatedBy uint `json:"created_by"` // 创建人ID
+	UpdatedBy uint `json:"updated_by"` // 最后修改人ID
+}
+
+// AuditPlugin 根据 context 中的操作人填充 CreatedBy / UpdatedBy 的 GORM 插件
+// 用法: db.Use(dbutil.AuditPlugin{})
+//
+// 只处理带有 CreatedBy / UpdatedBy 字段的模型；context 中没有操作人时不做修改。
+// 与 UpdatedAt 一样，UpdateColumn / UpdateColumns 会跳过，不会记录修改人。
+type AuditPlugin struct{}
+
+// Name 插件名称
+func (AuditPlugin) Name() string {
+	return "audit"
+}
+
+// Initialize 注册创建和更新回调
+func (AuditPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:create").Register("audit:create", setAuditColumns(true)); err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("audit:update", setAuditColumns(false))
+}
+
+// setAuditColumns 返回填充审计字段的回调，create 为 true 时同时填充 CreatedBy
+func setAuditColumns(create bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || stmt.Schema == nil || stmt.SkipHooks {
+			return
+		}
+		actor, ok := ActorFrom(stmt.Context)
+		if !ok {
+			return
+		}
+
+		if create && stmt.Schema.LookUpField("CreatedBy") != nil {
+			stmt.SetColumn("CreatedBy", actor, true)
+		}
+		if stmt.Schema.LookUpField("UpdatedBy") != nil {
+			stmt.SetColumn("UpdatedBy", actor, true)
+		}
+	}
+}
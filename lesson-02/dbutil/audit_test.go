@@ -0,0 +1,134 @@
+package dbutil_test
+
+import (
+	"context"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+)
+
+type auditedNote struct {
+	ID     uint `gorm:"primaryKey"`
+	Title  string
+	Status string
+	dbutil.AuditFields
+}
+
+// TestAuditPlugin 测试根据 context 中的操作人填充审计字段
+func TestAuditPlugin(t *testing.T) {
+	db := testutil.NewTestDB(t, "audit.db") // NewTestDB 已注册 AuditPlugin
+
+	if err := db.Migrator().DropTable(&auditedNote{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&auditedNote{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	alice := db.WithContext(dbutil.WithActor(context.Background(), 1))
+	bob := db.WithContext(dbutil.WithActor(context.Background(), 2))
+
+	reload := func(t *testing.T, id uint) auditedNote {
+		t.Helper()
+		var n auditedNote
+		if err := db.First(&n, id).Error; err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		return n
+	}
+
+	t.Run("创建", func(t *testing.T) {
+		note := auditedNote{Title: "single"}
+		if err := alice.Create(&note).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if got := reload(t, note.ID); got.CreatedBy != 1 || got.UpdatedBy != 1 {
+			t.Errorf("预期创建人和修改人为1，实际 %+v", got.AuditFields)
+		}
+
+		batch := []auditedNote{{Title: "a"}, {Title: "b"}}
+		if err := bob.Create(&batch).Error; err != nil {
+			t.Fatalf("batch create: %v", err)
+		}
+		for _, n := range batch {
+			if got := reload(t, n.ID); got.CreatedBy != 2 {
+				t.Errorf("批量创建预期创建人为2，实际 %+v", got.AuditFields)
+			}
+		}
+	})
+
+	t.Run("更新", func(t *testing.T) {
+		note := auditedNote{Title: "to update"}
+		if err := alice.Create(&note).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		// Update 单列
+		if err := bob.Model(&auditedNote{}).Where("id = ?", note.ID).Update("status", "done").Error; err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if got := reload(t, note.ID); got.CreatedBy != 1 || got.UpdatedBy != 2 {
+			t.Errorf("预期创建人1、修改人2，实际 %+v", got.AuditFields)
+		}
+
+		// Updates 结构体
+		if err := alice.Model(&note).Updates(auditedNote{Title: "renamed"}).Error; err != nil {
+			t.Fatalf("updates: %v", err)
+		}
+		if got := reload(t, note.ID); got.UpdatedBy != 1 {
+			t.Errorf("预期修改人1，实际 %+v", got.AuditFields)
+		}
+
+		// Save
+		note = reload(t, note.ID)
+		note.Status = "archived"
+		if err := bob.Save(&note).Error; err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if got := reload(t, note.ID); got.UpdatedBy != 2 || got.CreatedBy != 1 {
+			t.Errorf("预期创建人1、修改人2，实际 %+v", got.AuditFields)
+		}
+	})
+
+	t.Run("跳过", func(t *testing.T) {
+		note := auditedNote{Title: "skip"}
+		if err := alice.Create(&note).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		// 没有操作人时不修改
+		if err := db.Model(&note).Update("status", "x").Error; err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		// UpdateColumn 不触发钩子
+		if err := bob.Model(&note).UpdateColumn("status", "y").Error; err != nil {
+			t.Fatalf("update column: %v", err)
+		}
+		if got := reload(t, note.ID); got.UpdatedBy != 1 || got.Status != "y" {
+			t.Errorf("预期修改人仍为1，实际 %+v", got)
+		}
+	})
+
+	t.Run("没有审计字段的模型", func(t *testing.T) {
+		type plain struct {
+			ID   uint `gorm:"primaryKey"`
+			Name string
+		}
+		if err := db.Migrator().DropTable(&plain{}); err != nil {
+			t.Fatalf("drop table: %v", err)
+		}
+		if err := db.AutoMigrate(&plain{}); err != nil {
+			t.Fatalf("auto migrate: %v", err)
+		}
+		if err := alice.Create(&plain{Name: "x"}).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := alice.Model(&plain{}).Where("name = ?", "x").Update("name", "y").Error; err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	})
+
+	if _, ok := dbutil.ActorFrom(context.Background()); ok {
+		t.Errorf("空 context 不应有操作人")
+	}
+}
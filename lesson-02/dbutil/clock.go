@@ -0,0 +1,56 @@
+package dbutil
+
+import (
+	"context"
+	"time"
+)
+
+// Clock 当前时间和定时器的来源，依赖时间的代码通过它取时间，测试中可以替换为 testutil.FakeClock
+type Clock interface {
+	Now() time.Time
+	// After 等待 d 之后在返回的 channel 上发送当时的时间，与 time.After 相同
+	After(d time.Duration) <-chan time.Time
+	// NewTicker 创建每隔 d 触发一次的 Ticker，与 time.NewTicker 相同，d 必须大于 0
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker Clock 创建的周期定时器
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock 使用系统时间的 Clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+// systemTicker 包装 time.Ticker 实现 Ticker
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+
+func (t systemTicker) Stop() { t.t.Stop() }
+
+// clockKey context 中保存 Clock 的键
+type clockKey struct{}
+
+// WithClock 返回带有 Clock 的 context，之后通过 ClockFrom 取时间的代码都使用它
+// 用法: DeleteInactiveUsers(dbutil.WithClock(ctx, fakeClock), db)
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// ClockFrom 取出 context 中的 Clock，没有时返回 SystemClock
+func ClockFrom(ctx context.Context) Clock {
+	if ctx != nil {
+		if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+			return clock
+		}
+	}
+	return SystemClock{}
+}
@@ -0,0 +1,76 @@
+package dbutil_test
+
+import (
+	"context"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+	"time"
+)
+
+// TestClockFrom 测试从 context 中取出 Clock
+func TestClockFrom(t *testing.T) {
+	if _, ok := dbutil.ClockFrom(context.Background()).(dbutil.SystemClock); !ok {
+		t.Errorf("context 中没有 Clock 时应使用 SystemClock")
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := testutil.NewFakeClock(start)
+	if got := dbutil.ClockFrom(clock.Context(context.Background())).Now(); !got.Equal(start) {
+		t.Errorf("ClockFrom().Now() = %v，预期 %v", got, start)
+	}
+}
+
+// TestFakeClock 测试 FakeClock 的定时器和 Ticker 只在时钟前进时触发
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := testutil.NewFakeClock(start)
+
+	fired := func(ch <-chan time.Time) (time.Time, bool) {
+		select {
+		case at := <-ch:
+			return at, true
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	after := clock.After(time.Hour)
+	ticker := clock.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	clock.Advance(9 * time.Minute)
+	if _, ok := fired(ticker.C()); ok {
+		t.Errorf("未到间隔时 Ticker 不应触发")
+	}
+	clock.Advance(time.Minute)
+	if at, ok := fired(ticker.C()); !ok || !at.Equal(start.Add(10*time.Minute)) {
+		t.Errorf("Ticker 应在 10 分钟时触发，实际 %v %v", at, ok)
+	}
+
+	// 一次前进多个间隔只保留一次触发，与 time.Ticker 一样丢弃来不及接收的
+	clock.Advance(35 * time.Minute)
+	if _, ok := fired(ticker.C()); !ok {
+		t.Errorf("Ticker 应再次触发")
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Errorf("Ticker 不应积压多次触发")
+	}
+	if _, ok := fired(after); ok {
+		t.Errorf("未到 1 小时 After 不应触发")
+	}
+
+	clock.Set(start.Add(time.Hour))
+	if at, ok := fired(after); !ok || !at.Equal(start.Add(time.Hour)) {
+		t.Errorf("After 应在 1 小时时触发，实际 %v %v", at, ok)
+	}
+	if at, ok := fired(ticker.C()); !ok || !at.Equal(start.Add(time.Hour)) {
+		t.Errorf("Ticker 应在 60 分钟时触发，实际 %v %v", at, ok)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	if _, ok := fired(ticker.C()); ok {
+		t.Errorf("Stop 之后 Ticker 不应触发")
+	}
+}
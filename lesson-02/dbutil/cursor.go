@@ -0,0 +1,80 @@
+package dbutil
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCursor 分页游标格式错误或被篡改
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+// minCursorKeyLen 游标签名密钥的最小长度
+const minCursorKeyLen = 16
+
+/*
+CursorCodec 把游标分页的位置（上一页最后一条记录的排序键）编码为不透明的 token，
+用于在 HTTP 接口中返回 next_cursor，客户端原样传回即可翻页:
+
+	codec, _ := dbutil.NewCursorCodec(key)
+	token, _ := codec.Encode(Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	...
+	var cursor Cursor
+	if err := codec.Decode(token, &cursor); errors.Is(err, dbutil.ErrInvalidCursor) {
+		// 返回 400
+	}
+
+token 格式为 base64url(JSON).base64url(HMAC-SHA256)，排序键只是编码而不是加密，
+客户端能看到内容但无法修改；排序字段变化时旧 token 解码后的值可能不再适用，应同时修改结构体
+*/
+type CursorCodec struct {
+	key []byte
+}
+
+// NewCursorCodec 创建游标编解码器，key 至少 16 字节，所有实例必须使用同一个 key
+func NewCursorCodec(key []byte) (*CursorCodec, error) {
+	if len(key) < minCursorKeyLen {
+		return nil, fmt.Errorf("游标签名密钥至少 %d 字节，实际 %d 字节", minCursorKeyLen, len(key))
+	}
+	return &CursorCodec{key: append([]byte(nil), key...)}, nil
+}
+
+// Encode 把游标 v 编码为 token，v 需要能被 encoding/json 序列化
+func (c *CursorCodec) Encode(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("编码分页游标失败: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(payload)), nil
+}
+
+// Decode 校验 token 的签名并解码到 dest，格式错误或签名不匹配时返回 ErrInvalidCursor
+func (c *CursorCodec) Decode(token string, dest interface{}) error {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(payload, dest); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return nil
+}
+
+func (c *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
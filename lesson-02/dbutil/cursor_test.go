@@ -0,0 +1,54 @@
+package dbutil_test
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCursorCodec 测试游标的编码、解码和防篡改
+func TestCursorCodec(t *testing.T) {
+	type cursor struct {
+		CreatedAt time.Time
+		ID        uint
+	}
+
+	if _, err := dbutil.NewCursorCodec([]byte("short")); err == nil {
+		t.Errorf("预期过短的密钥被拒绝")
+	}
+	codec, err := dbutil.NewCursorCodec([]byte("cursor-signing-key-for-tests"))
+	if err != nil {
+		t.Fatalf("NewCursorCodec: %v", err)
+	}
+
+	want := cursor{CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), ID: 42}
+	token, err := codec.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token 应可以直接放进 URL，实际 %s", token)
+	}
+
+	var got cursor
+	if err := codec.Decode(token, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("Decode = %+v，预期 %+v", got, want)
+	}
+
+	// 篡改内容、换用其他密钥或格式错误都会被拒绝
+	other, _ := dbutil.NewCursorCodec([]byte("another-signing-key-for-tests"))
+	forged, _ := other.Encode(cursor{ID: 1})
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered, _ := codec.Encode(cursor{ID: 1})
+	tamperedPayload, _, _ := strings.Cut(tampered, ".")
+	for _, bad := range []string{"", "abc", token + "x", forged, tamperedPayload + "." + sig, payload + ".!!"} {
+		if err := codec.Decode(bad, &got); !errors.Is(err, dbutil.ErrInvalidCursor) {
+			t.Errorf("Decode(%q) 预期 ErrInvalidCursor，实际 %v", bad, err)
+		}
+	}
+}
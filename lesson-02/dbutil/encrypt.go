@@ -0,0 +1,174 @@
+package dbutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// EncryptionKeyEnv 保存字段加密密钥的环境变量，值为 base64 编码的 32 字节密钥
+// 可以用 `openssl rand -base64 32` 生成
+const EncryptionKeyEnv = "DB_ENCRYPTION_KEY"
+
+var (
+	// ErrNoEncryptionKey 读写 EncryptedString 前没有设置密钥
+	ErrNoEncryptionKey = errors.New("未设置字段加密密钥")
+	// ErrDecrypt 密文损坏或密钥不匹配
+	ErrDecrypt = errors.New("字段解密失败")
+)
+
+// fieldKeys 由主密钥派生的加密密钥和盲索引密钥
+// 两者分开派生，盲索引泄露不会影响密文的安全性
+type fieldKeys struct {
+	aead  cipher.AEAD
+	index []byte
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *fieldKeys
+)
+
+// SetEncryptionKey 设置 EncryptedString 和 BlindIndex 使用的主密钥，必须是 32 字节
+// 密钥对整个进程生效；更换密钥后旧密钥写入的数据无法解密，盲索引也会失效
+func SetEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("字段加密密钥必须是 32 字节，实际 %d 字节", len(key))
+	}
+	block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	keysMu.Lock()
+	keys = &fieldKeys{aead: aead, index: deriveKey(key, "blind-index")}
+	keysMu.Unlock()
+	return nil
+}
+
+// LoadEncryptionKey 从环境变量 EncryptionKeyEnv 读取并设置主密钥
+func LoadEncryptionKey() error {
+	value := os.Getenv(EncryptionKeyEnv)
+	if value == "" {
+		return fmt.Errorf("%w: 环境变量 %s 为空", ErrNoEncryptionKey, EncryptionKeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", EncryptionKeyEnv, err)
+	}
+	return SetEncryptionKey(key)
+}
+
+// deriveKey 用 HMAC-SHA256 从主密钥派生出用途为 purpose 的子密钥
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func currentKeys() (*fieldKeys, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if keys == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	return keys, nil
+}
+
+/*
+EncryptedString 写入数据库时自动加密、读取时自动解密的字符串，用于手机号等敏感字段
+使用 AES-256-GCM，每次加密使用随机 nonce，同样的明文每次写入的密文都不同，
+因此不能直接按密文查询或建唯一索引，需要配合 BlindIndex 生成的盲索引列:
+
+	type User struct {
+		Phone      dbutil.EncryptedString `gorm:"size:255"`
+		PhoneIndex *string                `gorm:"uniqueIndex;size:64"` // BlindIndex(Phone)，没有手机号时为 NULL
+	}
+	index, err := dbutil.BlindIndex("13800000001")
+	db.Where("phone_index = ?", index).First(&user)
+
+空字符串按原样保存，不加密
+*/
+type EncryptedString string
+
+// Value 实现 driver.Valuer，写入时加密
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	k, err := currentKeys()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(s), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Scan 实现 sql.Scanner，读取时解密
+func (s *EncryptedString) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("无法把 %T 扫描为 EncryptedString", value)
+	}
+	if text == "" {
+		*s = ""
+		return nil
+	}
+
+	k, err := currentKeys()
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plain, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	*s = EncryptedString(plain)
+	return nil
+}
+
+// BlindIndex 计算明文的盲索引（HMAC-SHA256 的十六进制），用于按加密字段做等值查询和唯一约束
+// 同样的明文总是得到同样的索引，但无法由索引反推明文；空字符串返回空字符串
+// 盲索引只支持完全相等的匹配，不支持 LIKE 等模糊查询
+func BlindIndex(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	k, err := currentKeys()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(plain))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
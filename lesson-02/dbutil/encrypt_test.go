@@ -0,0 +1,59 @@
+package dbutil_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"strings"
+	"testing"
+)
+
+// TestEncryptedString 测试字段加解密和盲索引
+func TestEncryptedString(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	t.Setenv(dbutil.EncryptionKeyEnv, base64.StdEncoding.EncodeToString(key))
+	if err := dbutil.LoadEncryptionKey(); err != nil {
+		t.Fatalf("LoadEncryptionKey: %v", err)
+	}
+	if err := dbutil.SetEncryptionKey([]byte("short")); err == nil {
+		t.Errorf("预期长度不是 32 字节的密钥被拒绝")
+	}
+
+	v1, err := dbutil.EncryptedString("13800000001").Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+	v2, _ := dbutil.EncryptedString("13800000001").Value()
+	if v1 == v2 || strings.Contains(v1.(string), "13800000001") {
+		t.Errorf("同样的明文应加密为不同的密文: %v %v", v1, v2)
+	}
+
+	var got dbutil.EncryptedString
+	if err := got.Scan([]byte(v1.(string))); err != nil || got != "13800000001" {
+		t.Errorf("Scan = %q, %v，预期 13800000001", got, err)
+	}
+	if err := got.Scan(nil); err != nil || got != "" {
+		t.Errorf("NULL 应扫描为空字符串，实际 %q, %v", got, err)
+	}
+	if v, _ := dbutil.EncryptedString("").Value(); v != "" {
+		t.Errorf("空字符串不加密，实际 %v", v)
+	}
+
+	i1, _ := dbutil.BlindIndex("13800000001")
+	i2, _ := dbutil.BlindIndex("13800000001")
+	i3, _ := dbutil.BlindIndex("13800000002")
+	if i1 != i2 || i1 == i3 || len(i1) != 64 {
+		t.Errorf("盲索引应确定且区分不同明文: %s %s %s", i1, i2, i3)
+	}
+
+	// 换了密钥后旧密文无法解密
+	if err := dbutil.SetEncryptionKey([]byte(strings.Repeat("x", 32))); err != nil {
+		t.Fatalf("SetEncryptionKey: %v", err)
+	}
+	if err := got.Scan(v1); !errors.Is(err, dbutil.ErrDecrypt) {
+		t.Errorf("预期 ErrDecrypt，实际 %v", err)
+	}
+	if i4, _ := dbutil.BlindIndex("13800000001"); i4 == i1 {
+		t.Errorf("不同密钥的盲索引应不同")
+	}
+}
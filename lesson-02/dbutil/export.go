@@ -0,0 +1,144 @@
+package dbutil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportFormat 导出格式
+type ExportFormat string
+
+const (
+	ExportCSV       ExportFormat = "csv"   // 第一行为表头
+	ExportJSONLines ExportFormat = "jsonl" // 每行一个 JSON 对象，键为列名
+)
+
+// DefaultExportBatchSize Export 每批从数据库读取的记录数
+const DefaultExportBatchSize = 500
+
+// ExportColumn 导出的一列：Name 为 CSV 表头和 JSON 的键，Value 从记录中取值
+type ExportColumn[T any] struct {
+	Name  string
+	Value func(item *T) interface{}
+}
+
+/*
+Export 把 db 查询到的模型 T 的记录按批写入 w，内存中最多只保留一批
+db 上可以带查询条件，记录按主键顺序分批读取（FindInBatches），每批写完后立即刷新到 w
+CSV 中时间格式为 RFC3339，nil 指针为空字符串；JSON Lines 中 nil 指针为 null
+batchSize 小于等于0时使用 DefaultExportBatchSize，返回导出的记录数
+*/
+func Export[T any](db *gorm.DB, format ExportFormat, w io.Writer, columns []ExportColumn[T], batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultExportBatchSize
+	}
+
+	var writeBatch func(items []T) error
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.Name
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		writeBatch = func(items []T) error {
+			record := make([]string, len(columns))
+			for i := range items {
+				for j, c := range columns {
+					record[j] = csvValue(c.Value(&items[i]))
+				}
+				if err := cw.Write(record); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	case ExportJSONLines:
+		writeBatch = func(items []T) error {
+			var buf bytes.Buffer
+			for i := range items {
+				if err := writeJSONLine(&buf, columns, &items[i]); err != nil {
+					return err
+				}
+			}
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+	default:
+		return 0, fmt.Errorf("不支持的导出格式: %q", format)
+	}
+
+	total := 0
+	var items []T
+	var writeErr error
+	err := db.Model(new(T)).FindInBatches(&items, batchSize, func(_ *gorm.DB, _ int) error {
+		if writeErr = writeBatch(items); writeErr != nil {
+			return writeErr
+		}
+		total += len(items)
+		return nil
+	}).Error
+	switch {
+	case writeErr != nil:
+		return total, fmt.Errorf("写入导出数据失败: %w", writeErr)
+	case err != nil:
+		return total, fmt.Errorf("查询导出数据失败: %w", err)
+	}
+
+	// 没有记录时 CSV 只有表头，也需要刷新
+	if format == ExportCSV && total == 0 {
+		return 0, writeBatch(nil)
+	}
+	return total, nil
+}
+
+// writeJSONLine 按列的顺序写出一个 JSON 对象
+func writeJSONLine[T any](buf *bytes.Buffer, columns []ExportColumn[T], item *T) error {
+	buf.WriteByte('{')
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(c.Name)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(c.Value(item))
+		if err != nil {
+			return fmt.Errorf("列 %s: %w", c.Name, err)
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+// csvValue 把单元格的值格式化为字符串
+func csvValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return ""
+		}
+		v = rv.Elem().Interface()
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,69 @@
+package dbutil_test
+
+import (
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+)
+
+type exportItem struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+// countingWriter 记录 Write 的调用次数
+type countingWriter struct {
+	strings.Builder
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Builder.Write(p)
+}
+
+// TestExport 测试分批导出和空结果
+func TestExport(t *testing.T) {
+	db := testutil.NewTestDB(t, "export.db")
+
+	if err := db.Migrator().DropTable(&exportItem{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&exportItem{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	columns := []dbutil.ExportColumn[exportItem]{
+		{Name: "id", Value: func(i *exportItem) interface{} { return i.ID }},
+		{Name: "name", Value: func(i *exportItem) interface{} { return i.Name }},
+	}
+
+	t.Run("空结果", func(t *testing.T) {
+		var out strings.Builder
+		n, err := dbutil.Export(db, dbutil.ExportCSV, &out, columns, 2)
+		if err != nil || n != 0 || out.String() != "id,name\n" {
+			t.Errorf("预期只输出表头，实际 %d 条 %q: %v", n, out.String(), err)
+		}
+	})
+
+	items := []exportItem{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	t.Run("分批写入", func(t *testing.T) {
+		var out countingWriter
+		n, err := dbutil.Export(db.Where("name <> ?", "c"), dbutil.ExportJSONLines, &out, columns, 2)
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		want := `{"id":1,"name":"a"}` + "\n" + `{"id":2,"name":"b"}` + "\n" + `{"id":4,"name":"d"}` + "\n" + `{"id":5,"name":"e"}` + "\n"
+		if n != 4 || out.String() != want {
+			t.Errorf("导出 %d 条 %q，预期 %q", n, out.String(), want)
+		}
+		// 4 条记录每批 2 条，每批写一次
+		if out.writes != 2 {
+			t.Errorf("预期分2次写入，实际 %d 次", out.writes)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+//go:build integration
+
+// 集成测试需要真实的 MySQL / PostgreSQL，默认不参与 go test ./...
+// 运行方式:
+//
+//	TEST_MYSQL_DSN='root:password@tcp(localhost:3306)/testdb?charset=utf8mb4&parseTime=True&loc=Local' \
+//	TEST_POSTGRES_DSN='host=localhost user=postgres password=password dbname=testdb port=5432 sslmode=disable' \
+//	go test -tags integration ./dbutil
+package dbutil_test
+
+import (
+	"gohomeworklesson02/dbutil"
+	"os"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type portableUser struct {
+	ID    uint   `gorm:"primaryKey"`
+	Name  string `gorm:"size:64"`
+	Email string `gorm:"uniqueIndex;size:128"`
+	Phone string `gorm:"uniqueIndex;size:20"`
+}
+
+// TestDialects 在各数据库上验证连接、建表、upsert、事务和日期表达式
+func TestDialects(t *testing.T) {
+	dialects := []struct {
+		driver string
+		env    string
+	}{
+		{dbutil.DriverMySQL, "TEST_MYSQL_DSN"},
+		{dbutil.DriverPostgres, "TEST_POSTGRES_DSN"},
+	}
+	for _, d := range dialects {
+		t.Run(d.driver, func(t *testing.T) {
+			dsn := os.Getenv(d.env)
+			if dsn == "" {
+				t.Skipf("未设置 %s", d.env)
+			}
+			db, err := dbutil.Open(dbutil.Config{Driver: d.driver, DSN: dsn})
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			sqlDB, _ := db.DB()
+			defer sqlDB.Close()
+
+			if err := db.Migrator().DropTable(&portableUser{}); err != nil {
+				t.Fatalf("drop table: %v", err)
+			}
+			if err := db.AutoMigrate(&portableUser{}); err != nil {
+				t.Fatalf("auto migrate: %v", err)
+			}
+
+			err = dbutil.WithTx(db, func(tx *gorm.DB) error {
+				users := []portableUser{
+					{Name: "Alice", Email: "alice@example.com", Phone: "13800000001"},
+					{Name: "Bob", Email: "bob@example.com", Phone: "13800000002"},
+				}
+				if err := tx.Create(&users).Error; err != nil {
+					return err
+				}
+				// 按邮箱冲突时更新姓名
+				return tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "email"}},
+					DoUpdates: clause.AssignmentColumns([]string{"name"}),
+				}).Create(&portableUser{Name: "Alice Wang", Email: "alice@example.com", Phone: "13800000001"}).Error
+			})
+			if err != nil {
+				t.Fatalf("WithTx: %v", err)
+			}
+
+			var alice portableUser
+			if err := db.Where("email = ?", "alice@example.com").First(&alice).Error; err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if alice.Name != "Alice Wang" {
+				t.Errorf("upsert 未生效: %+v", alice)
+			}
+
+			checkDateExpr(t, db)
+		})
+	}
+}
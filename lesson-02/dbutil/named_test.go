@@ -0,0 +1,94 @@
+package dbutil_test
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+)
+
+type namedOrder struct {
+	ID       uint `gorm:"primaryKey"`
+	Customer string
+	Note     string
+	Amount   int
+}
+
+// TestQueryNamed 测试命名参数查询和参数校验
+func TestQueryNamed(t *testing.T) {
+	db := testutil.NewTestDB(t, "named.db")
+
+	if err := db.Migrator().DropTable(&namedOrder{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&namedOrder{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	orders := []namedOrder{
+		{Customer: "alice", Note: "alice@example.com", Amount: 30},
+		{Customer: "alice", Amount: 70},
+		{Customer: "bob", Amount: 20},
+		{Customer: "carol", Amount: 5},
+	}
+	if err := db.Create(&orders).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	report := `
+		SELECT customer, SUM(amount) AS total, COUNT(*) AS orders
+		FROM named_orders
+		WHERE amount >= @min
+		GROUP BY customer
+		HAVING SUM(amount) >= @min
+		ORDER BY total DESC`
+
+	t.Run("扫描到结构体", func(t *testing.T) {
+		var rows []struct {
+			Customer string
+			Total    int
+			Orders   int
+		}
+		if err := dbutil.QueryNamed(db, report, map[string]interface{}{"min": 10}, &rows); err != nil {
+			t.Fatalf("QueryNamed: %v", err)
+		}
+		if len(rows) != 2 || rows[0].Customer != "alice" || rows[0].Total != 100 || rows[0].Orders != 2 || rows[1].Customer != "bob" {
+			t.Errorf("报表结果不正确: %+v", rows)
+		}
+	})
+
+	t.Run("扫描到 map", func(t *testing.T) {
+		var row map[string]interface{}
+		err := dbutil.QueryNamed(db, "SELECT COUNT(*) AS n FROM named_orders WHERE customer = @customer",
+			map[string]interface{}{"customer": "alice"}, &row)
+		if err != nil {
+			t.Fatalf("QueryNamed: %v", err)
+		}
+		if n, ok := row["n"].(int64); !ok || n != 2 {
+			t.Errorf("预期 n = 2，实际 %v", row)
+		}
+	})
+
+	t.Run("引号中的 @ 不是参数", func(t *testing.T) {
+		var ids []uint
+		err := dbutil.QueryNamed(db, "SELECT id FROM named_orders WHERE note = 'alice@example.com' AND amount > @min",
+			map[string]interface{}{"min": 0}, &ids)
+		if err != nil {
+			t.Fatalf("QueryNamed: %v", err)
+		}
+		if len(ids) != 1 || ids[0] != orders[0].ID {
+			t.Errorf("预期找到第1个订单，实际 %v", ids)
+		}
+	})
+
+	t.Run("参数校验", func(t *testing.T) {
+		var rows []map[string]interface{}
+		err := dbutil.QueryNamed(db, report, map[string]interface{}{}, &rows)
+		if !errors.Is(err, dbutil.ErrMissingParam) {
+			t.Errorf("预期 ErrMissingParam，实际 %v", err)
+		}
+		err = dbutil.QueryNamed(db, report, map[string]interface{}{"min": 10, "mni": 5}, &rows)
+		if !errors.Is(err, dbutil.ErrUnusedParam) {
+			t.Errorf("预期 ErrUnusedParam，实际 %v", err)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package dbutil_test
+
+import (
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestParseDSN 测试根据 DSN 推断驱动
+func TestParseDSN(t *testing.T) {
+	cases := []struct {
+		dsn    string
+		driver string
+		want   string
+	}{
+		{"sqlite://data/app.db", dbutil.DriverSQLite, "data/app.db"},
+		{"file:app.db?cache=shared", dbutil.DriverSQLite, "file:app.db?cache=shared"},
+		{":memory:", dbutil.DriverSQLite, ":memory:"},
+		{"app.sqlite3", dbutil.DriverSQLite, "app.sqlite3"},
+		{"mysql://root:pw@tcp(localhost:3306)/app?parseTime=True", dbutil.DriverMySQL, "root:pw@tcp(localhost:3306)/app?parseTime=True"},
+		{"root:pw@tcp(localhost:3306)/app", dbutil.DriverMySQL, "root:pw@tcp(localhost:3306)/app"},
+		{"postgres://postgres:pw@localhost:5432/app", dbutil.DriverPostgres, "postgres://postgres:pw@localhost:5432/app"},
+		{"postgresql://localhost/app", dbutil.DriverPostgres, "postgresql://localhost/app"},
+		{"host=localhost user=postgres dbname=app", dbutil.DriverPostgres, "host=localhost user=postgres dbname=app"},
+	}
+	for _, c := range cases {
+		cfg, err := dbutil.ParseDSN(c.dsn)
+		if err != nil {
+			t.Errorf("ParseDSN(%q): %v", c.dsn, err)
+			continue
+		}
+		if cfg.Driver != c.driver || cfg.DSN != c.want {
+			t.Errorf("ParseDSN(%q) = %+v，预期 {%s %s}", c.dsn, cfg, c.driver, c.want)
+		}
+	}
+
+	if _, err := dbutil.ParseDSN("redis://localhost"); err == nil {
+		t.Errorf("预期无法识别的 DSN 返回错误")
+	}
+	if _, err := dbutil.Open(dbutil.Config{Driver: "oracle", DSN: "x"}); err == nil {
+		t.Errorf("预期不支持的驱动返回错误")
+	}
+}
+
+// TestOpenDSN 测试打开 SQLite 连接并使用 dbutil.DateExpr 按天分组
+func TestOpenDSN(t *testing.T) {
+	db, err := dbutil.OpenDSN("sqlite://" + filepath.Join(t.TempDir(), "open.db"))
+	if err != nil {
+		t.Fatalf("OpenDSN: %v", err)
+	}
+	if db.Dialector.Name() != dbutil.DriverSQLite {
+		t.Fatalf("预期 sqlite，实际 %s", db.Dialector.Name())
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	checkDateExpr(t, db)
+}
+
+// TestOpenOptions 测试连接池、PrepareStmt 和 SQLite 参数
+func TestOpenOptions(t *testing.T) {
+	silent := logger.Default.LogMode(logger.Silent)
+	db, err := dbutil.Open(dbutil.Config{
+		DSN:         filepath.Join(t.TempDir(), "options.db"),
+		Pool:        dbutil.PoolConfig{MaxOpenConns: 4, MaxIdleConns: 2, ConnMaxLifetime: time.Minute},
+		PrepareStmt: true,
+		SQLite:      dbutil.SQLiteConfig{WAL: true, BusyTimeout: 3 * time.Second, ForeignKeys: true},
+	}, &gorm.Config{Logger: silent})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	if !db.Config.PrepareStmt || db.Config.Logger != silent {
+		t.Errorf("预期开启 PrepareStmt 且保留调用方的 Logger")
+	}
+	if got := sqlDB.Stats().MaxOpenConnections; got != 4 {
+		t.Errorf("MaxOpenConnections = %d，预期 4", got)
+	}
+
+	pragmas := map[string]string{"journal_mode": "wal", "busy_timeout": "3000", "foreign_keys": "1"}
+	for name, want := range pragmas {
+		var got string
+		if err := db.Raw("PRAGMA " + name).Scan(&got).Error; err != nil {
+			t.Fatalf("PRAGMA %s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("PRAGMA %s = %s，预期 %s", name, got, want)
+		}
+	}
+}
+
+// TestDateExprWithTestDB 在 TEST_DB_TYPE 指定的数据库上测试 dbutil.DateExpr 和 dbutil.MonthExpr
+func TestDateExprWithTestDB(t *testing.T) {
+	checkDateExpr(t, testutil.NewTestDB(t, "open.db"))
+}
+
+type dateEvent struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// checkDateExpr 按天和按月统计事件数量
+func checkDateExpr(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	if err := db.Migrator().DropTable(&dateEvent{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&dateEvent{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	day1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	day3 := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	events := []dateEvent{{CreatedAt: day1}, {CreatedAt: day1.Add(time.Hour)}, {CreatedAt: day2}, {CreatedAt: day3}}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	type dayCount struct {
+		Day   string
+		Total int64
+	}
+	var rows []dayCount
+	err := db.Model(&dateEvent{}).
+		Select(dbutil.DateExpr(db, "created_at") + " AS day, COUNT(*) AS total").
+		Group("day").
+		Order("day").
+		Scan(&rows).Error
+	if err != nil {
+		t.Fatalf("group by day: %v", err)
+	}
+	if len(rows) != 3 || rows[0].Day != "2024-03-01" || rows[0].Total != 2 || rows[1].Day != "2024-03-02" {
+		t.Errorf("按天统计结果不正确: %+v", rows)
+	}
+
+	var months []dayCount
+	err = db.Model(&dateEvent{}).
+		Select(dbutil.MonthExpr(db, "created_at") + " AS day, COUNT(*) AS total").
+		Group("day").
+		Order("day").
+		Scan(&months).Error
+	if err != nil {
+		t.Fatalf("group by month: %v", err)
+	}
+	if len(months) != 2 || months[0].Day != "2024-03" || months[0].Total != 3 || months[1].Day != "2024-04" {
+		t.Errorf("按月统计结果不正确: %+v", months)
+	}
+}
@@ -0,0 +1,90 @@
+package dbutil
+
+import "gorm.io/gorm"
+
+// 分页参数的默认值和上限
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Page 分页查询结果
+type Page[T any] struct {
+	Items      []T   `json:"items"`
+	Total      int64 `json:"total"`       // 符合条件的记录总数
+	Page       int   `json:"page"`        // 当前页码，从1开始
+	Size       int   `json:"size"`        // 每页大小
+	TotalPages int   `json:"total_pages"` // 总页数，没有记录时为0
+	HasNext    bool  `json:"has_next"`    // 是否还有下一页
+}
+
+// NormalizePage 校验分页参数：页码小于1按第1页，每页默认 DefaultPageSize 条、最多 MaxPageSize 条
+func NormalizePage(page, size int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	return page, size
+}
+
+// Paginate 按 NormalizePage 校验后的页码和每页大小设置 OFFSET/LIMIT 的 scope
+func Paginate(page, size int) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		page, size := NormalizePage(page, size)
+		return db.Offset((page - 1) * size).Limit(size)
+	}
+}
+
+// NewPage 根据本页数据和总数构造分页结果，page 和 size 按 NormalizePage 校验
+func NewPage[T any](items []T, total int64, page, size int) Page[T] {
+	page, size = NormalizePage(page, size)
+	if items == nil {
+		items = []T{} // 序列化为 [] 而不是 null
+	}
+	totalPages := int((total + int64(size) - 1) / int64(size))
+	return Page[T]{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: totalPages,
+		HasNext:    page < totalPages,
+	}
+}
+
+/*
+FindWithCount 用同一组查询条件统计总数并查询数据，避免 Count 和 Find 分别拼条件后不一致
+scopes 在调用时立即应用到 db 上，统计总数时去掉其中的 LIMIT/OFFSET、排序和预加载，
+因此分页、排序可以和筛选条件一起放在 scopes 中，如:
+
+	total, err := dbutil.FindWithCount(db.Model(&User{}), &users, YoungUsers(), Paginate(page, size))
+
+db 没有指定 Model 时按 dest 的类型统计
+*/
+func FindWithCount(db *gorm.DB, dest interface{}, scopes ...func(*gorm.DB) *gorm.DB) (int64, error) {
+	query := db
+	for _, scope := range scopes {
+		query = scope(query)
+	}
+	if query.Statement.Model == nil {
+		query = query.Model(dest)
+	}
+	// 之后的 Count 和 Find 都基于这份条件各自复制，互不影响
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	count := query.Limit(-1).Offset(-1)
+	count.Statement.Preloads = nil
+	if err := count.Count(&total).Error; err != nil {
+		return 0, err
+	}
+	if err := query.Find(dest).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
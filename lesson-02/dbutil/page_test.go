@@ -0,0 +1,106 @@
+package dbutil_test
+
+import (
+	"encoding/json"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// TestNewPage 测试分页结果的页数计算
+func TestNewPage(t *testing.T) {
+	cases := []struct {
+		total      int64
+		page, size int
+		wantPage   int
+		wantSize   int
+		wantPages  int
+		wantNext   bool
+	}{
+		{total: 0, page: 1, size: 10, wantPage: 1, wantSize: 10, wantPages: 0, wantNext: false},
+		{total: 25, page: 1, size: 10, wantPage: 1, wantSize: 10, wantPages: 3, wantNext: true},
+		{total: 25, page: 3, size: 10, wantPage: 3, wantSize: 10, wantPages: 3, wantNext: false},
+		{total: 20, page: 2, size: 10, wantPage: 2, wantSize: 10, wantPages: 2, wantNext: false},
+		{total: 5, page: 0, size: 0, wantPage: 1, wantSize: dbutil.DefaultPageSize, wantPages: 1, wantNext: false},
+		{total: 500, page: 1, size: 1000, wantPage: 1, wantSize: dbutil.MaxPageSize, wantPages: 5, wantNext: true},
+	}
+	for _, c := range cases {
+		p := dbutil.NewPage([]int{}, c.total, c.page, c.size)
+		if p.Page != c.wantPage || p.Size != c.wantSize || p.TotalPages != c.wantPages || p.HasNext != c.wantNext {
+			t.Errorf("NewPage(total=%d, page=%d, size=%d) = %+v", c.total, c.page, c.size, p)
+		}
+	}
+
+	// 没有数据时 items 序列化为空数组
+	data, err := json.Marshal(dbutil.NewPage[string](nil, 0, 1, 10))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"items":[],"total":0,"page":1,"size":10,"total_pages":0,"has_next":false}`
+	if string(data) != want {
+		t.Errorf("json = %s，预期 %s", data, want)
+	}
+}
+
+type pageItem struct {
+	ID   uint `gorm:"primaryKey"`
+	Kind string
+}
+
+// TestFindWithCount 测试 Count 和 Find 共用 scopes 中的条件
+func TestFindWithCount(t *testing.T) {
+	db := testutil.NewTestDB(t, "page.db")
+
+	if err := db.Migrator().DropTable(&pageItem{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&pageItem{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	items := make([]pageItem, 12)
+	for i := range items {
+		items[i].Kind = "a"
+		if i%3 == 0 {
+			items[i].Kind = "b"
+		}
+	}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	ofKind := func(kind string) func(*gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("kind = ?", kind)
+		}
+	}
+	page := func(page, size int) func(*gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Order("id DESC").Offset((page - 1) * size).Limit(size)
+		}
+	}
+
+	var got []pageItem
+	total, err := dbutil.FindWithCount(db, &got, ofKind("a"), page(2, 5))
+	if err != nil {
+		t.Fatalf("FindWithCount: %v", err)
+	}
+	if total != 8 {
+		t.Errorf("预期总数 8，实际 %d", total)
+	}
+	// kind 为 a 的 ID 倒序为 12 11 9 8 6 | 5 3 2
+	if len(got) != 3 || got[0].ID != 5 || got[2].ID != 2 {
+		t.Errorf("预期第2页为 ID 5、3、2，实际 %+v", got)
+	}
+
+	// 同一个 db 可以继续使用，不会残留上次的条件
+	var all []pageItem
+	total, err = dbutil.FindWithCount(db.Model(&pageItem{}), &all)
+	if err != nil {
+		t.Fatalf("FindWithCount: %v", err)
+	}
+	if total != 12 || len(all) != 12 {
+		t.Errorf("预期 12 条记录，实际总数 %d、返回 %d 条", total, len(all))
+	}
+}
@@ -0,0 +1,75 @@
+package dbutil
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// PasswordCost bcrypt 的计算强度，越大越慢越安全，测试中可以调低到 bcrypt.MinCost
+var PasswordCost = bcrypt.DefaultCost
+
+// 密码长度限制：bcrypt 只使用前 72 个字节，更长的部分会被忽略
+const (
+	MinPasswordLen = 8
+	MaxPasswordLen = 72
+)
+
+var (
+	// ErrPasswordLength 密码长度不在 MinPasswordLen 到 MaxPasswordLen 个字节之间
+	ErrPasswordLength = fmt.Errorf("密码长度必须在 %d 到 %d 个字节之间", MinPasswordLen, MaxPasswordLen)
+	// ErrPasswordMismatch 密码错误
+	ErrPasswordMismatch = errors.New("密码错误")
+	// ErrPlaintextPassword 试图把未经 HashPassword 处理的密码写入 password_hash
+	ErrPlaintextPassword = errors.New("password_hash 只能保存 bcrypt 哈希，请使用 SetPassword")
+)
+
+// HashPassword 校验密码长度并返回 bcrypt 哈希，长度不符时返回 ErrPasswordLength
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLen || len(password) > MaxPasswordLen {
+		return "", ErrPasswordLength
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码哈希失败: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 校验密码与哈希是否匹配，不匹配或哈希为空（没有设置密码）时返回 ErrPasswordMismatch
+func CheckPassword(hash, password string) error {
+	if hash == "" {
+		return ErrPasswordMismatch
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// CheckPasswordHash 空值表示没有设置密码，其余必须是 bcrypt 哈希，否则返回 ErrPlaintextPassword
+func CheckPasswordHash(hash string) error {
+	if hash == "" {
+		return nil
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return ErrPlaintextPassword
+	}
+	return nil
+}
+
+// GuardPasswordHash 在模型的 BeforeSave 钩子中调用，检查要写入的 password_hash 是 bcrypt 哈希
+// 同时检查结构体字段 hash 和 Update("password_hash", ...)、Updates(map)、Updates(User{...}) 传入的值，
+// 避免明文密码被误写入数据库，见 UpdatedValue
+func GuardPasswordHash(tx *gorm.DB, hash string) error {
+	if err := CheckPasswordHash(hash); err != nil {
+		return err
+	}
+	if v, ok := UpdatedValue(tx, "PasswordHash"); ok {
+		return CheckPasswordHash(fmt.Sprint(v))
+	}
+	return nil
+}
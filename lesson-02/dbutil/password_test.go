@@ -0,0 +1,105 @@
+package dbutil_test
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type credential struct {
+	ID           uint `gorm:"primaryKey"`
+	Name         string
+	PasswordHash string `gorm:"size:60"`
+}
+
+func (c *credential) BeforeSave(tx *gorm.DB) error {
+	return dbutil.GuardPasswordHash(tx, c.PasswordHash)
+}
+
+// TestPassword 测试密码哈希、校验和防止明文写入
+func TestPassword(t *testing.T) {
+	cost := dbutil.PasswordCost
+	dbutil.PasswordCost = bcrypt.MinCost
+	t.Cleanup(func() { dbutil.PasswordCost = cost })
+
+	t.Run("哈希与校验", func(t *testing.T) {
+		hash, err := dbutil.HashPassword("correct horse")
+		if err != nil {
+			t.Fatalf("HashPassword: %v", err)
+		}
+		if err := dbutil.CheckPassword(hash, "correct horse"); err != nil {
+			t.Errorf("正确的密码校验失败: %v", err)
+		}
+		if err := dbutil.CheckPassword(hash, "wrong horse"); !errors.Is(err, dbutil.ErrPasswordMismatch) {
+			t.Errorf("预期 ErrPasswordMismatch，实际 %v", err)
+		}
+		if err := dbutil.CheckPassword("", ""); !errors.Is(err, dbutil.ErrPasswordMismatch) {
+			t.Errorf("哈希为空时预期 ErrPasswordMismatch，实际 %v", err)
+		}
+	})
+
+	t.Run("长度限制", func(t *testing.T) {
+		for _, password := range []string{"short", strings.Repeat("x", dbutil.MaxPasswordLen+1)} {
+			if _, err := dbutil.HashPassword(password); !errors.Is(err, dbutil.ErrPasswordLength) {
+				t.Errorf("长度 %d 的密码预期 ErrPasswordLength，实际 %v", len(password), err)
+			}
+		}
+	})
+
+	t.Run("拒绝写入明文", func(t *testing.T) {
+		db := testutil.NewTestDB(t, "password.db")
+		if err := db.Migrator().DropTable(&credential{}); err != nil {
+			t.Fatalf("drop table: %v", err)
+		}
+		if err := db.AutoMigrate(&credential{}); err != nil {
+			t.Fatalf("auto migrate: %v", err)
+		}
+		hash, err := dbutil.HashPassword("correct horse")
+		if err != nil {
+			t.Fatalf("HashPassword: %v", err)
+		}
+		alice := credential{Name: "alice", PasswordHash: hash}
+		if err := db.Create(&alice).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := db.Create(&credential{Name: "bob", PasswordHash: "plaintext"}).Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Create 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Update("password_hash", "plaintext").Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Update 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Updates(map[string]interface{}{"password_hash": "plaintext"}).Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Updates 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Updates(map[string]interface{}{"PasswordHash": "plaintext"}).Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Updates 按字段名预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		// 要写入的值在 Updates 传入的结构体上，而不是 Model 上
+		if err := db.Model(&credential{ID: alice.ID}).Updates(credential{PasswordHash: "plaintext"}).Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Updates(struct) 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+		if err := db.Model(&alice).Updates(&credential{Name: "alice", PasswordHash: "plaintext"}).Error; !errors.Is(err, dbutil.ErrPlaintextPassword) {
+			t.Errorf("Updates(&struct) 预期 ErrPlaintextPassword，实际 %v", err)
+		}
+
+		var got credential
+		if err := db.First(&got, alice.ID).Error; err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if got.PasswordHash != hash {
+			t.Errorf("password_hash 被修改为 %q", got.PasswordHash)
+		}
+		// 传入哈希或不修改密码时正常更新
+		if err := db.Model(&credential{ID: alice.ID}).Updates(credential{Name: "Alice"}).Error; err != nil {
+			t.Errorf("Updates 不含密码: %v", err)
+		}
+		if err := db.Model(&credential{ID: alice.ID}).Updates(credential{PasswordHash: hash}).Error; err != nil {
+			t.Errorf("Updates 传入哈希: %v", err)
+		}
+	})
+}
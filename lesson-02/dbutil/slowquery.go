@@ -0,0 +1,157 @@
+package dbutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SlowQueryPluginName SlowQueryPlugin 注册到 gorm 时的插件名
+const SlowQueryPluginName = "slowquery"
+
+// DefaultSlowQueryKeep SlowQueryPlugin 默认保留的慢查询条数
+const DefaultSlowQueryKeep = 20
+
+// slowQueryStartKey 在 Statement 上记录查询开始时间的键
+const slowQueryStartKey = "slowquery:start"
+
+// SlowQuery 一条慢查询记录
+type SlowQuery struct {
+	SQL      string        // 代入参数后的 SQL
+	Rows     int64         // 返回或影响的行数
+	Duration time.Duration // 执行耗时
+	At       time.Time     // 执行完成的时间
+}
+
+/*
+SlowQueryPlugin 记录执行时间超过阈值的查询的 GORM 插件
+每条查询都会计时，超过 Threshold 的查询通过 Logger 输出警告（包括 SQL 和行数），
+并保留耗时最长的 Keep 条，可以通过 Worst 或 SlowQueries 查看:
+
+	plugin := dbutil.NewSlowQueryPlugin(100 * time.Millisecond)
+	db.Use(plugin)
+	...
+	for _, q := range dbutil.SlowQueries(db) {
+		fmt.Println(q.Duration, q.SQL)
+	}
+
+耗时从 GORM 执行该操作的第一个回调开始计算，包含 BeforeCreate 等钩子的时间
+*/
+type SlowQueryPlugin struct {
+	Threshold time.Duration    // 超过该时长（含）的查询视为慢查询
+	Keep      int              // 保留最慢的条数，<= 0 时使用 DefaultSlowQueryKeep
+	Logger    logger.Interface // 输出慢查询的日志，为 nil 时使用 db.Logger，设为 logger.Discard 不输出
+
+	mu    sync.Mutex
+	worst []SlowQuery // 按耗时从长到短排列
+}
+
+// NewSlowQueryPlugin 创建阈值为 threshold 的慢查询插件
+func NewSlowQueryPlugin(threshold time.Duration) *SlowQueryPlugin {
+	return &SlowQueryPlugin{Threshold: threshold, Keep: DefaultSlowQueryKeep}
+}
+
+// Name 插件名称
+func (p *SlowQueryPlugin) Name() string {
+	return SlowQueryPluginName
+}
+
+// registrar gorm 回调注册器，Before/After 返回的类型没有导出
+type registrar interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// Initialize 在每种操作的回调链首尾注册计时回调
+func (p *SlowQueryPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	pairs := [][2]registrar{
+		{callbacks.Create().Before("*"), callbacks.Create().After("*")},
+		{callbacks.Query().Before("*"), callbacks.Query().After("*")},
+		{callbacks.Update().Before("*"), callbacks.Update().After("*")},
+		{callbacks.Delete().Before("*"), callbacks.Delete().After("*")},
+		{callbacks.Row().Before("*"), callbacks.Row().After("*")},
+		{callbacks.Raw().Before("*"), callbacks.Raw().After("*")},
+	}
+	for _, pair := range pairs {
+		if err := pair[0].Register("slowquery:start", startSlowQueryTimer); err != nil {
+			return err
+		}
+		if err := pair[1].Register("slowquery:end", p.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startSlowQueryTimer(db *gorm.DB) {
+	db.InstanceSet(slowQueryStartKey, time.Now())
+}
+
+// record 计算耗时，超过阈值时输出日志并记录
+func (p *SlowQueryPlugin) record(db *gorm.DB) {
+	value, ok := db.InstanceGet(slowQueryStartKey)
+	if !ok || db.DryRun {
+		return
+	}
+	elapsed := time.Since(value.(time.Time))
+	if elapsed < p.Threshold {
+		return
+	}
+
+	stmt := db.Statement
+	query := SlowQuery{
+		SQL:      db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...),
+		Rows:     db.RowsAffected,
+		Duration: elapsed,
+		At:       time.Now(),
+	}
+
+	log := p.Logger
+	if log == nil {
+		log = db.Logger
+	}
+	log.Warn(stmt.Context, "慢查询 %s >= %s [rows:%d] %s", elapsed, p.Threshold, query.Rows, query.SQL)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keep := p.Keep
+	if keep <= 0 {
+		keep = DefaultSlowQueryKeep
+	}
+	// 按耗时插入到有序位置，超出 keep 条时丢弃最快的一条
+	i := sort.Search(len(p.worst), func(i int) bool { return p.worst[i].Duration < elapsed })
+	if i >= keep {
+		return
+	}
+	p.worst = append(p.worst, SlowQuery{})
+	copy(p.worst[i+1:], p.worst[i:])
+	p.worst[i] = query
+	if len(p.worst) > keep {
+		p.worst = p.worst[:keep]
+	}
+}
+
+// Worst 返回记录到的慢查询，按耗时从长到短排列
+func (p *SlowQueryPlugin) Worst() []SlowQuery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]SlowQuery(nil), p.worst...)
+}
+
+// Reset 清空记录的慢查询
+func (p *SlowQueryPlugin) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.worst = nil
+}
+
+// SlowQueries 返回 db 上注册的 SlowQueryPlugin 记录的慢查询，没有注册时返回 nil
+func SlowQueries(db *gorm.DB) []SlowQuery {
+	if p, ok := db.Config.Plugins[SlowQueryPluginName].(*SlowQueryPlugin); ok {
+		return p.Worst()
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package dbutil_test
+
+import (
+	"context"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// warnRecorder 记录 Warn 日志的 logger
+type warnRecorder struct {
+	logger.Interface
+	warnings []string
+}
+
+func (r *warnRecorder) Warn(_ context.Context, msg string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(msg, args...))
+}
+
+type slowItem struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+// TestSlowQueryPlugin 测试慢查询的记录、日志和保留条数
+func TestSlowQueryPlugin(t *testing.T) {
+	db, err := dbutil.Open(dbutil.Config{DSN: filepath.Join(t.TempDir(), "slow.db")},
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	if dbutil.SlowQueries(db) != nil {
+		t.Errorf("没有注册插件时应返回 nil")
+	}
+
+	recorder := &warnRecorder{Interface: logger.Discard}
+	plugin := &dbutil.SlowQueryPlugin{Threshold: time.Hour, Keep: 3, Logger: recorder}
+	if err := db.Use(plugin); err != nil {
+		t.Fatalf("Use: %v", err)
+	}
+	if err := db.AutoMigrate(&slowItem{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	t.Run("低于阈值不记录", func(t *testing.T) {
+		if err := db.Create(&slowItem{Name: "a"}).Error; err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if got := dbutil.SlowQueries(db); len(got) != 0 || len(recorder.warnings) != 0 {
+			t.Errorf("预期没有慢查询，实际 %v %v", got, recorder.warnings)
+		}
+	})
+
+	t.Run("记录最慢的几条", func(t *testing.T) {
+		// 阈值设为0，所有查询都算慢查询
+		plugin.Threshold = 0
+		defer func() { plugin.Threshold = time.Hour }()
+
+		for i := 0; i < 5; i++ {
+			if err := db.Create(&slowItem{Name: fmt.Sprintf("item%d", i)}).Error; err != nil {
+				t.Fatalf("create: %v", err)
+			}
+		}
+		var items []slowItem
+		if err := db.Where("name LIKE ?", "item%").Find(&items).Error; err != nil {
+			t.Fatalf("find: %v", err)
+		}
+
+		got := dbutil.SlowQueries(db)
+		if len(got) != 3 {
+			t.Fatalf("预期保留3条，实际 %d 条", len(got))
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i].Duration > got[i-1].Duration {
+				t.Errorf("应按耗时从长到短排列: %v", got)
+			}
+		}
+		if len(recorder.warnings) != 6 {
+			t.Errorf("预期每条慢查询输出一次日志，实际 %d 条", len(recorder.warnings))
+		}
+
+		// 日志和记录中的 SQL 已代入参数
+		last := recorder.warnings[len(recorder.warnings)-1]
+		if !strings.Contains(last, `name LIKE "item%"`) || !strings.Contains(last, "[rows:5]") {
+			t.Errorf("日志中应包含 SQL 和行数，实际 %s", last)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		plugin.Reset()
+		if got := plugin.Worst(); len(got) != 0 {
+			t.Errorf("Reset 后应为空，实际 %v", got)
+		}
+	})
+}
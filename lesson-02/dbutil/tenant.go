@@ -0,0 +1,194 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoTenant 操作多租户模型时 context 中没有租户，见 TenantPlugin
+var ErrNoTenant = errors.New("context 中没有租户")
+
+// tenantKey context 中保存租户ID的键
+type tenantKey struct{}
+
+// allTenantsKey context 中标记跨租户访问的键
+type allTenantsKey struct{}
+
+// WithTenant 返回带有租户ID的 context
+// 用法: db.WithContext(dbutil.WithTenant(ctx, tenantID)).Find(&users)
+func WithTenant(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFrom 取出 context 中的租户ID
+func TenantFrom(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	tenantID, ok := ctx.Value(tenantKey{}).(uint)
+	return tenantID, ok
+}
+
+// WithAllTenants 返回跨租户访问的 context，TenantPlugin 不会为其添加租户条件
+// 只用于后台统计、数据迁移等明确需要访问所有租户的场景
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey{}, true)
+}
+
+// allTenants 是否通过 WithAllTenants 声明了跨租户访问
+func allTenants(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	all, _ := ctx.Value(allTenantsKey{}).(bool)
+	return all
+}
+
+// TenantFields 租户字段，嵌入模型后由 TenantPlugin 自动填充和过滤
+type TenantFields struct {
+	TenantID uint `gorm:"index"` // 所属租户ID
+}
+
+// tenantColumn 当前表的 tenant_id 列，联表查询时带上表名避免歧义
+var tenantColumn = clause.Column{Table: clause.CurrentTable, Name: "tenant_id"}
+
+// ForTenant 只查询 ctx 中租户的数据的 scope，ctx 中没有租户时不添加条件
+// 注册了 TenantPlugin 时会自动添加同样的条件，不需要手动使用；
+// 用于没有注册插件的连接，或在 Table/Raw 等插件无法识别模型的查询中显式加上租户条件
+func ForTenant(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tenantID, ok := TenantFrom(ctx)
+		if !ok {
+			return db
+		}
+		return db.Where(clause.Eq{Column: tenantColumn, Value: tenantID})
+	}
+}
+
+/*
+TenantPlugin 多租户隔离的 GORM 插件，对嵌入了 TenantFields 的模型:
+  - Create 时把 TenantID 设置为 context 中的租户，忽略调用方传入的值
+  - Query/Update/Delete 时自动添加 tenant_id = 当前租户 的条件（包括 Preload 的关联查询）
+
+用法: db.Use(dbutil.TenantPlugin{})，之后通过 db.WithContext(dbutil.WithTenant(ctx, id)) 访问
+
+context 中没有租户时返回 ErrNoTenant，避免忘记传入租户导致跨租户读写，
+确实需要访问所有租户时使用 WithAllTenants；
+Lenient 为 true 时不做任何限制，只用于兼容没有租户概念的旧代码
+注意：Raw/Exec 执行的原生 SQL 和 Table("...") 查询无法识别模型，不会添加条件，需要手动使用 ForTenant；
+唯一索引仍然是全局的，同一邮箱不能在两个租户中重复注册
+*/
+type TenantPlugin struct {
+	Lenient bool // context 中没有租户时不添加条件，而不是返回 ErrNoTenant
+}
+
+// Name 插件名称
+func (TenantPlugin) Name() string {
+	return "tenant"
+}
+
+// Initialize 注册创建、查询、更新和删除回调
+func (p TenantPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:create", p.setTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", p.filterTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", p.updateTenant); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenant:delete", p.filterTenantWrite); err != nil {
+		return err
+	}
+	return callbacks.Row().Before("gorm:row").Register("tenant:row", p.filterTenant)
+}
+
+// tenant 返回当前操作的租户；模型没有 TenantID 字段或跨租户访问时 ok 为 false
+func (p TenantPlugin) tenant(db *gorm.DB) (tenantID uint, ok bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Schema.LookUpField("TenantID") == nil {
+		return 0, false
+	}
+	if allTenants(stmt.Context) {
+		return 0, false
+	}
+	tenantID, ok = TenantFrom(stmt.Context)
+	if !ok && !p.Lenient {
+		_ = db.AddError(ErrNoTenant)
+	}
+	return tenantID, ok
+}
+
+// setTenant 创建时填充 TenantID
+// INSERT ... ON CONFLICT DO UPDATE（包括 Save 找不到记录时的回退）冲突的可能是其他租户的记录，
+// 给 DO UPDATE 加上租户条件；MySQL 的 ON DUPLICATE KEY UPDATE 不支持条件，无法限制
+func (p TenantPlugin) setTenant(db *gorm.DB) {
+	tenantID, ok := p.tenant(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	stmt.SetColumn("TenantID", tenantID, true)
+
+	if c, ok := stmt.Clauses["ON CONFLICT"]; ok {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+			onConflict.Where.Exprs = append(onConflict.Where.Exprs, clause.Eq{Column: tenantColumn, Value: tenantID})
+			stmt.AddClause(onConflict)
+		}
+	}
+}
+
+// filterTenant 添加 tenant_id 条件
+func (p TenantPlugin) filterTenant(db *gorm.DB) {
+	if tenantID, ok := p.tenant(db); ok {
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: tenantColumn, Value: tenantID},
+		}})
+	}
+}
+
+// updateTenant 更新时添加 tenant_id 条件，并把 TenantID 固定为当前租户，
+// 避免 Save/Updates 传入其他 TenantID 把记录转移到其他租户
+func (p TenantPlugin) updateTenant(db *gorm.DB) {
+	if !hasConditions(db) {
+		return
+	}
+	if tenantID, ok := p.tenant(db); ok {
+		db.Statement.SetColumn("TenantID", tenantID, true)
+		p.filterTenant(db)
+	}
+}
+
+// filterTenantWrite 删除时添加 tenant_id 条件
+func (p TenantPlugin) filterTenantWrite(db *gorm.DB) {
+	if hasConditions(db) {
+		p.filterTenant(db)
+	}
+}
+
+// hasConditions 更新/删除语句是否有 WHERE 条件或主键条件
+// 没有条件时不添加租户条件，保留 gorm 对全表更新/删除的 ErrMissingWhereClause 检查
+func hasConditions(db *gorm.DB) bool {
+	stmt := db.Statement
+	if _, ok := stmt.Clauses["WHERE"]; ok || db.AllowGlobalUpdate {
+		return true
+	}
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return false
+	}
+	// gorm 在执行时才会根据模型的主键添加条件
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		_, zero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, stmt.ReflectValue)
+		return !zero
+	case reflect.Slice, reflect.Array:
+		return stmt.ReflectValue.Len() > 0
+	}
+	return false
+}
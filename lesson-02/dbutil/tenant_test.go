@@ -0,0 +1,221 @@
+package dbutil_test
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type tenantProject struct {
+	ID    uint `gorm:"primaryKey"`
+	Name  string
+	Tasks []tenantTask `gorm:"foreignKey:ProjectID"`
+	dbutil.TenantFields
+}
+
+type tenantTask struct {
+	ID        uint `gorm:"primaryKey"`
+	ProjectID uint
+	Title     string
+	dbutil.TenantFields
+}
+
+// TestTenantPlugin 测试不同租户之间的数据隔离
+func TestTenantPlugin(t *testing.T) {
+	db := testutil.NewTestDB(t, "tenant.db")
+
+	if err := db.Migrator().DropTable(&tenantTask{}, &tenantProject{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&tenantProject{}, &tenantTask{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	acme := db.WithContext(dbutil.WithTenant(context.Background(), 1))
+	globex := db.WithContext(dbutil.WithTenant(context.Background(), 2))
+
+	// 调用方传入的 TenantID 会被 context 中的租户覆盖
+	acmeProjects := []tenantProject{
+		{Name: "acme-a", Tasks: []tenantTask{{Title: "a1"}, {Title: "a2"}}},
+		{Name: "acme-b", TenantFields: dbutil.TenantFields{TenantID: 2}},
+	}
+	if err := acme.Create(&acmeProjects).Error; err != nil {
+		t.Fatalf("create acme: %v", err)
+	}
+	globexProject := tenantProject{Name: "globex-a", Tasks: []tenantTask{{Title: "g1"}}}
+	if err := globex.Create(&globexProject).Error; err != nil {
+		t.Fatalf("create globex: %v", err)
+	}
+
+	names := func(projects []tenantProject) string {
+		result := make([]string, len(projects))
+		for i, p := range projects {
+			result[i] = p.Name
+		}
+		return strings.Join(result, ",")
+	}
+
+	t.Run("创建时填充租户", func(t *testing.T) {
+		for _, p := range acmeProjects {
+			if p.TenantID != 1 {
+				t.Errorf("%s 的 TenantID = %d，预期 1", p.Name, p.TenantID)
+			}
+		}
+		if globexProject.Tasks[0].TenantID != 2 {
+			t.Errorf("关联创建的记录也应属于租户 2，实际 %d", globexProject.Tasks[0].TenantID)
+		}
+	})
+
+	t.Run("查询只返回本租户数据", func(t *testing.T) {
+		var projects []tenantProject
+		if err := acme.Order("id").Preload("Tasks").Find(&projects).Error; err != nil {
+			t.Fatalf("find: %v", err)
+		}
+		if names(projects) != "acme-a,acme-b" || len(projects[0].Tasks) != 2 {
+			t.Errorf("租户 1 查询结果不正确: %+v", projects)
+		}
+
+		var count int64
+		if err := globex.Model(&tenantProject{}).Count(&count).Error; err != nil || count != 1 {
+			t.Errorf("租户 2 预期 1 个项目，实际 %d, %v", count, err)
+		}
+
+		// 按主键查询其他租户的数据也查不到
+		var p tenantProject
+		if err := globex.First(&p, acmeProjects[0].ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("预期 ErrRecordNotFound，实际 %v", err)
+		}
+
+		// 联表时条件带上表名，不会有歧义
+		var tasks []tenantTask
+		err := acme.Joins("JOIN tenant_projects ON tenant_projects.id = tenant_tasks.project_id").
+			Where("tenant_projects.name = ?", "acme-a").Find(&tasks).Error
+		if err != nil || len(tasks) != 2 {
+			t.Errorf("联表查询预期 2 个任务，实际 %d, %v", len(tasks), err)
+		}
+	})
+
+	t.Run("不能修改或删除其他租户的数据", func(t *testing.T) {
+		result := globex.Model(&tenantProject{}).Where("name = ?", "acme-a").Update("name", "hacked")
+		if result.Error != nil || result.RowsAffected != 0 {
+			t.Errorf("预期没有更新任何记录，实际 %d, %v", result.RowsAffected, result.Error)
+		}
+		result = globex.Delete(&tenantProject{}, acmeProjects[1].ID)
+		if result.Error != nil || result.RowsAffected != 0 {
+			t.Errorf("预期没有删除任何记录，实际 %d, %v", result.RowsAffected, result.Error)
+		}
+
+		// Save 找不到记录时会回退为 INSERT ... ON CONFLICT DO UPDATE，同样不能覆盖其他租户的数据
+		stolen := acmeProjects[0]
+		stolen.Name = "stolen"
+		if err := globex.Save(&stolen).Error; err != nil {
+			t.Fatalf("save: %v", err)
+		}
+
+		var p tenantProject
+		if err := acme.First(&p, acmeProjects[0].ID).Error; err != nil {
+			t.Fatalf("first: %v", err)
+		}
+		if p.Name != "acme-a" || p.TenantID != 1 {
+			t.Errorf("租户 1 的数据被修改: %+v", p)
+		}
+		if err := acme.First(&tenantProject{}, acmeProjects[1].ID).Error; err != nil {
+			t.Errorf("租户 1 的数据被删除: %v", err)
+		}
+
+		// 本租户的 Save 也不能把记录转移到其他租户
+		p.TenantID = 2
+		if err := acme.Save(&p).Error; err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		if err := acme.First(&tenantProject{}, p.ID).Error; err != nil {
+			t.Errorf("记录被转移到了其他租户: %v", err)
+		}
+	})
+
+	t.Run("全表更新仍然需要条件", func(t *testing.T) {
+		err := acme.Model(&tenantProject{}).Update("name", "all").Error
+		if !errors.Is(err, gorm.ErrMissingWhereClause) {
+			t.Errorf("预期 ErrMissingWhereClause，实际 %v", err)
+		}
+	})
+
+	t.Run("跨租户访问", func(t *testing.T) {
+		var projects []tenantProject
+		if err := db.WithContext(dbutil.WithAllTenants(context.Background())).Order("id").Find(&projects).Error; err != nil {
+			t.Fatalf("find: %v", err)
+		}
+		if names(projects) != "acme-a,acme-b,globex-a" {
+			t.Errorf("预期所有租户的项目，实际 [%s]", names(projects))
+		}
+
+		// Table 查询无法识别模型，需要显式使用 ForTenant
+		var count int64
+		ctx := dbutil.WithTenant(context.Background(), 2)
+		if err := db.Table("tenant_projects").Scopes(dbutil.ForTenant(ctx)).Count(&count).Error; err != nil || count != 1 {
+			t.Errorf("ForTenant 预期 1 个项目，实际 %d, %v", count, err)
+		}
+	})
+}
+
+// TestTenantPluginStrict 测试默认必须指定租户，Lenient 时不做限制
+func TestTenantPluginStrict(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "strict.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	if err := db.Use(dbutil.TenantPlugin{}); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if err := db.AutoMigrate(&tenantProject{}, &tenantTask{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	if err := db.Create(&tenantProject{Name: "no tenant"}).Error; !errors.Is(err, dbutil.ErrNoTenant) {
+		t.Errorf("Create 预期 ErrNoTenant，实际 %v", err)
+	}
+	var projects []tenantProject
+	if err := db.Find(&projects).Error; !errors.Is(err, dbutil.ErrNoTenant) {
+		t.Errorf("Find 预期 ErrNoTenant，实际 %v", err)
+	}
+
+	ctx := dbutil.WithTenant(context.Background(), 7)
+	if err := db.WithContext(ctx).Create(&tenantProject{Name: "p"}).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := db.WithContext(dbutil.WithAllTenants(context.Background())).Find(&projects).Error; err != nil || len(projects) != 1 {
+		t.Errorf("WithAllTenants 预期 1 个项目，实际 %d, %v", len(projects), err)
+	}
+
+	lenient, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "lenient.db")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	lenientDB, _ := lenient.DB()
+	defer lenientDB.Close()
+	if err := lenient.Use(dbutil.TenantPlugin{Lenient: true}); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if err := lenient.AutoMigrate(&tenantProject{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+	if err := lenient.Create(&tenantProject{Name: "no tenant"}).Error; err != nil {
+		t.Errorf("Lenient 时没有租户也可以创建: %v", err)
+	}
+	if err := lenient.Find(&projects).Error; err != nil || len(projects) != 1 {
+		t.Errorf("Lenient 时预期 1 个项目，实际 %d, %v", len(projects), err)
+	}
+}
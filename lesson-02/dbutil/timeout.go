@@ -0,0 +1,42 @@
+package dbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrQueryTimeout 查询在 WithTimeout 指定的时间内没有完成
+var ErrQueryTimeout = errors.New("查询超时")
+
+/*
+WithTimeout 给 fn 中的查询加上超时，用于限制慢报表等单次查询的执行时间，如:
+
+	err := dbutil.WithTimeout(db, 2*time.Second, func(tx *gorm.DB) error {
+		return tx.Raw(reportSQL).Scan(&rows).Error
+	})
+	if errors.Is(err, dbutil.ErrQueryTimeout) {
+		// 报表太慢，提示用户缩小时间范围
+	}
+
+超时 context 只作用于 tx，不影响 db 上的其他查询；db 自身的 context 被取消时 tx 也会被取消
+超时返回的错误同时满足 errors.Is(err, ErrQueryTimeout) 和 errors.Is(err, context.DeadlineExceeded)
+d <= 0 表示不限制
+*/
+func WithTimeout(db *gorm.DB, d time.Duration, fn func(tx *gorm.DB) error) error {
+	if d <= 0 {
+		return fn(db)
+	}
+
+	ctx, cancel := context.WithTimeout(db.Statement.Context, d)
+	defer cancel()
+
+	err := fn(db.WithContext(ctx))
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w（%s）: %w", ErrQueryTimeout, d, err)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package dbutil_test
+
+import (
+	"context"
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// slowQuery 在 SQLite 上用递归 CTE 模拟一个耗时很长的报表
+const slowQuery = `
+	WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000)
+	SELECT COUNT(*) FROM c`
+
+// TestWithTimeout 测试单次查询超时
+func TestWithTimeout(t *testing.T) {
+	db, err := dbutil.Open(dbutil.Config{DSN: filepath.Join(t.TempDir(), "timeout.db")})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	t.Run("超时返回 ErrQueryTimeout", func(t *testing.T) {
+		start := time.Now()
+		err := dbutil.WithTimeout(db, 50*time.Millisecond, func(tx *gorm.DB) error {
+			var n int64
+			return tx.Raw(slowQuery).Scan(&n).Error
+		})
+		if !errors.Is(err, dbutil.ErrQueryTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("预期 ErrQueryTimeout，实际 %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("查询没有被及时中止，耗时 %s", elapsed)
+		}
+	})
+
+	t.Run("未超时正常返回", func(t *testing.T) {
+		var n int64
+		err := dbutil.WithTimeout(db, time.Second, func(tx *gorm.DB) error {
+			return tx.Raw("SELECT 42").Scan(&n).Error
+		})
+		if err != nil || n != 42 {
+			t.Errorf("预期 42，实际 %d, %v", n, err)
+		}
+	})
+
+	t.Run("不影响其他查询", func(t *testing.T) {
+		_ = dbutil.WithTimeout(db, time.Nanosecond, func(tx *gorm.DB) error {
+			return tx.Exec("SELECT 1").Error
+		})
+		var n int64
+		if err := db.Raw("SELECT 1").Scan(&n).Error; err != nil || n != 1 {
+			t.Errorf("超时后 db 应该仍然可用: %d, %v", n, err)
+		}
+	})
+
+	t.Run("其他错误原样返回", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		err := dbutil.WithTimeout(db, time.Second, func(tx *gorm.DB) error { return errBoom })
+		if err != errBoom {
+			t.Errorf("预期原样返回错误，实际 %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+// Package dbutil 提供 lesson-02 各示例共用的 GORM 辅助函数
+package dbutil
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetryPolicy 事务冲突时的重试策略
+type RetryPolicy struct {
+	MaxAttempts int           // 最多执行次数（包括第一次），<= 1 表示不重试
+	BaseDelay   time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay    time.Duration // 单次等待的上限
+}
+
+// DefaultRetryPolicy WithTx 使用的默认重试策略
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    200 * time.Millisecond,
+}
+
+// retryableMessages 可以通过重试整个事务解决的错误
+var retryableMessages = []string{
+	"database is locked",         // SQLite SQLITE_BUSY
+	"database table is locked",   // SQLite SQLITE_LOCKED
+	"could not serialize access", // PostgreSQL 40001
+	"deadlock detected",          // PostgreSQL 40P01
+	"Deadlock found",             // MySQL 1213
+	"Lock wait timeout exceeded", // MySQL 1205
+}
+
+// IsRetryable 判断事务错误是否是锁冲突或序列化失败，可以重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTx 在事务中执行 fn，使用 DefaultRetryPolicy 重试
+// fn 返回错误或 panic 时回滚，否则提交
+//
+// 嵌套：db 已经处于事务中（例如在另一个 WithTx 的 fn 里传入 tx）时，
+// 使用 SAVEPOINT 开启子事务，fn 失败只回滚到保存点，是否放弃整个事务由外层决定；
+// 子事务不重试，锁冲突交给最外层事务整体重试
+//
+// 注意：重试会重新执行 fn，fn 中不要有事务之外的副作用
+func WithTx(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return WithTxRetry(db, DefaultRetryPolicy, fn)
+}
+
+// WithTxRetry 同 WithTx，使用指定的重试策略
+func WithTxRetry(db *gorm.DB, policy RetryPolicy, fn func(tx *gorm.DB) error) error {
+	// gorm 的 Transaction 在已有事务上调用时会自动使用 SAVEPOINT
+	if InTx(db) {
+		return db.Transaction(fn)
+	}
+
+	delay := policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := db.Transaction(fn)
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		// 等待期间 context 被取消时不再重试
+		select {
+		case <-time.After(delay):
+		case <-db.Statement.Context.Done():
+			return err
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// InTx 判断 db 是否处于事务中
+func InTx(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
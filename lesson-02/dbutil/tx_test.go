@@ -0,0 +1,159 @@
+package dbutil_test
+
+import (
+	"errors"
+	"gohomeworklesson02/dbutil"
+	"gohomeworklesson02/testutil"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type account struct {
+	ID      uint `gorm:"primaryKey"`
+	Name    string
+	Balance int
+}
+
+// TestWithTx 测试事务提交、回滚、嵌套和重试
+func TestWithTx(t *testing.T) {
+	db := testutil.NewTestDB(t, "tx.db")
+
+	if err := db.Migrator().DropTable(&account{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&account{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	count := func(t *testing.T) int64 {
+		t.Helper()
+		var n int64
+		if err := db.Model(&account{}).Count(&n).Error; err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	errBoom := errors.New("boom")
+
+	t.Run("提交与回滚", func(t *testing.T) {
+		before := count(t)
+		err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+			if dbutil.InTx(db) || !dbutil.InTx(tx) {
+				t.Errorf("InTx 判断错误")
+			}
+			return tx.Create(&account{Name: "commit"}).Error
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+
+		err = dbutil.WithTx(db, func(tx *gorm.DB) error {
+			if err := tx.Create(&account{Name: "rollback"}).Error; err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Errorf("预期返回 fn 的错误，实际 %v", err)
+		}
+		if got := count(t); got != before+1 {
+			t.Errorf("预期只提交1条记录，实际新增 %d 条", got-before)
+		}
+	})
+
+	t.Run("嵌套事务", func(t *testing.T) {
+		before := count(t)
+		err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+			if err := tx.Create(&account{Name: "outer"}).Error; err != nil {
+				return err
+			}
+			// 子事务失败只回滚到保存点，外层忽略错误继续提交
+			innerErr := dbutil.WithTx(tx, func(tx2 *gorm.DB) error {
+				if err := tx2.Create(&account{Name: "inner"}).Error; err != nil {
+					return err
+				}
+				return errBoom
+			})
+			if !errors.Is(innerErr, errBoom) {
+				t.Errorf("预期子事务返回错误，实际 %v", innerErr)
+			}
+			return dbutil.WithTx(tx, func(tx2 *gorm.DB) error {
+				return tx2.Create(&account{Name: "inner ok"}).Error
+			})
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+
+		var names []string
+		if err := db.Model(&account{}).Order("id").Offset(int(before)).Pluck("name", &names).Error; err != nil {
+			t.Fatalf("pluck: %v", err)
+		}
+		if len(names) != 2 || names[0] != "outer" || names[1] != "inner ok" {
+			t.Errorf("预期提交 outer 和 inner ok，实际 %v", names)
+		}
+	})
+
+	t.Run("锁冲突重试", func(t *testing.T) {
+		policy := dbutil.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+		before := count(t)
+
+		attempts := 0
+		err := dbutil.WithTxRetry(db, policy, func(tx *gorm.DB) error {
+			attempts++
+			if err := tx.Create(&account{Name: "retry"}).Error; err != nil {
+				return err
+			}
+			if attempts < 3 {
+				return errors.New("database is locked")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WithTxRetry: %v", err)
+		}
+		if attempts != 3 {
+			t.Errorf("预期执行3次，实际 %d 次", attempts)
+		}
+		// 失败的尝试已回滚，只留下最后一次
+		if got := count(t); got != before+1 {
+			t.Errorf("预期新增1条记录，实际 %d 条", got-before)
+		}
+
+		attempts = 0
+		err = dbutil.WithTxRetry(db, policy, func(tx *gorm.DB) error {
+			attempts++
+			return errors.New("database is locked")
+		})
+		if !dbutil.IsRetryable(err) || attempts != 3 {
+			t.Errorf("预期重试3次后返回锁冲突错误，实际 %d 次: %v", attempts, err)
+		}
+	})
+
+	t.Run("不可重试的错误", func(t *testing.T) {
+		attempts := 0
+		err := dbutil.WithTx(db, func(tx *gorm.DB) error {
+			attempts++
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) || attempts != 1 {
+			t.Errorf("预期只执行1次，实际 %d 次: %v", attempts, err)
+		}
+
+		// 子事务不重试，由外层事务整体重试
+		outer, inner := 0, 0
+		policy := dbutil.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}
+		_ = dbutil.WithTxRetry(db, policy, func(tx *gorm.DB) error {
+			outer++
+			return dbutil.WithTxRetry(tx, policy, func(tx2 *gorm.DB) error {
+				inner++
+				return errors.New("database is locked")
+			})
+		})
+		if outer != 2 || inner != 2 {
+			t.Errorf("预期外层执行2次、子事务每次执行1次，实际外层 %d 次、子事务 %d 次", outer, inner)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package dbutil
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+/*
+UpdatedValue 返回 Update/Updates 要写入字段 name 的值，供模型的 BeforeSave/BeforeUpdate 钩子检查
+钩子的接收者是 Model 传入的模型，要写入的值可能不在模型上：
+  - Update("column", v)、Updates(map)：按字段名或列名从 map 中取值
+  - Model(&u).Updates(User{...})：Dest 是模型以外的结构体，取其中的非零值（零值不会被更新）
+
+Dest 就是模型本身（Create、Save）或没有写入该字段时 ok 为 false，此时钩子直接读取模型的字段即可
+*/
+func UpdatedValue(tx *gorm.DB, name string) (value interface{}, ok bool) {
+	stmt := tx.Statement
+	if stmt.Schema == nil {
+		return nil, false
+	}
+	field := stmt.Schema.LookUpField(name)
+	if field == nil {
+		return nil, false
+	}
+	if values, isMap := stmt.Dest.(map[string]interface{}); isMap {
+		for _, key := range []string{field.DBName, field.Name} {
+			if v, ok := values[key]; ok {
+				return v, true
+			}
+		}
+		return nil, false
+	}
+
+	dest := reflect.ValueOf(stmt.Dest)
+	for dest.Kind() == reflect.Ptr {
+		dest = dest.Elem()
+	}
+	if dest.Kind() != reflect.Struct || dest.Type() != stmt.Schema.ModelType || dest == stmt.ReflectValue {
+		return nil, false
+	}
+	v, zero := field.ValueOf(stmt.Context, dest)
+	if zero {
+		return nil, false
+	}
+	return v, true
+}
@@ -3,7 +3,11 @@ module gohomeworklesson02
 go 1.22
 
 require (
+	github.com/go-sql-driver/mysql v1.7.0
 	github.com/joho/godotenv v1.5.1
+	gohomeworklesson01 v0.0.0-00010101000000-000000000000
+	golang.org/x/crypto v0.17.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/mysql v1.5.7
 	gorm.io/driver/postgres v1.5.9
 	gorm.io/driver/sqlite v1.6.0
@@ -11,7 +15,6 @@ require (
 )
 
 require (
-	github.com/go-sql-driver/mysql v1.7.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/pgx/v5 v5.5.5 // indirect
@@ -19,9 +22,10 @@ require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
 	golang.org/x/sync v0.9.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 )
 
 replace encoding/pem => ./std/encoding/pem
+
+replace gohomeworklesson01 => ../lesson-01
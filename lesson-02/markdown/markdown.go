@@ -0,0 +1,293 @@
+// Package markdown 把 Markdown 渲染为可以直接嵌入页面的安全 HTML
+//
+// 只支持常用的子集：标题、段落、引用、有序/无序列表、分隔线、围栏代码块，
+// 以及行内的代码、加粗、斜体、链接和图片。
+// 渲染时先转义所有文本，只输出固定的几种标签，原始 HTML 会被当作文本显示；
+// 链接和图片只允许 http、https、mailto 和相对地址，其他协议（如 javascript:）只保留文字。
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Renderer Markdown 渲染器，零值可以直接使用
+type Renderer struct {
+	// Highlight 代码高亮的钩子，参数为围栏代码块的语言（可能为空）和代码原文，
+	// 返回的 HTML 放在 <pre><code> 中；ok 为 false 时按普通代码块转义输出
+	// 返回值不会再被转义，钩子必须自己转义代码中的 HTML
+	Highlight func(lang, code string) (html string, ok bool)
+}
+
+// Render 使用默认的渲染器（不高亮代码）渲染 src
+func Render(src string) string {
+	return (&Renderer{}).Render(src)
+}
+
+var (
+	headingPattern  = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?[ \t]*#*[ \t]*$`)
+	hrPattern       = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	bulletPattern   = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	orderedPattern  = regexp.MustCompile(`^ {0,3}\d{1,9}[.)][ \t]+(.*)$`)
+	fencePattern    = regexp.MustCompile("^ {0,3}(```+|~~~+)[ \t]*([^`\\s]*)")
+	langPattern     = regexp.MustCompile(`[^A-Za-z0-9_+#-]`)
+	continuationPad = regexp.MustCompile(`^(?: {2,}|\t)`)
+)
+
+// Render 把 src 渲染为 HTML
+func (r *Renderer) Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	var b strings.Builder
+	r.renderBlocks(&b, strings.Split(src, "\n"))
+	return b.String()
+}
+
+// renderBlocks 逐行识别块级元素
+func (r *Renderer) renderBlocks(b *strings.Builder, lines []string) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>")
+			b.WriteString(renderInline(strings.Join(paragraph, "\n")))
+			b.WriteString("</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+
+		case fencePattern.MatchString(line):
+			flush()
+			m := fencePattern.FindStringSubmatch(line)
+			fence := m[1]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) &&
+					strings.Trim(strings.TrimSpace(lines[i]), fence[:1]) == "" {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			r.renderCode(b, m[2], strings.Join(code, "\n"))
+
+		case headingPattern.MatchString(line):
+			flush()
+			m := headingPattern.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			b.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+
+		case hrPattern.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+
+		case strings.HasPrefix(strings.TrimLeft(line, " "), ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimLeft(lines[i], " "), ">"); i++ {
+				content := strings.TrimPrefix(strings.TrimLeft(lines[i], " "), ">")
+				quote = append(quote, strings.TrimPrefix(content, " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			r.renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+
+		case bulletPattern.MatchString(line), orderedPattern.MatchString(line):
+			flush()
+			pattern, tag := bulletPattern, "ul"
+			if !bulletPattern.MatchString(line) {
+				pattern, tag = orderedPattern, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for i < len(lines) && pattern.MatchString(lines[i]) {
+				item := []string{pattern.FindStringSubmatch(lines[i])[1]}
+				// 缩进的行属于上一个列表项
+				for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "" && continuationPad.MatchString(lines[i]) &&
+					!pattern.MatchString(lines[i]); i++ {
+					item = append(item, strings.TrimSpace(lines[i]))
+				}
+				b.WriteString("<li>" + renderInline(strings.Join(item, "\n")) + "</li>\n")
+			}
+			i--
+			b.WriteString("</" + tag + ">\n")
+
+		default:
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	flush()
+}
+
+// renderCode 输出围栏代码块，语言只保留安全的字符后作为 class
+func (r *Renderer) renderCode(b *strings.Builder, lang, code string) {
+	lang = langPattern.ReplaceAllString(lang, "")
+	b.WriteString("<pre><code")
+	if lang != "" {
+		b.WriteString(` class="language-` + lang + `"`)
+	}
+	b.WriteString(">")
+	if highlighted, ok := r.highlight(lang, code); ok {
+		b.WriteString(highlighted)
+	} else {
+		b.WriteString(html.EscapeString(code))
+	}
+	b.WriteString("</code></pre>\n")
+}
+
+func (r *Renderer) highlight(lang, code string) (string, bool) {
+	if r.Highlight == nil {
+		return "", false
+	}
+	return r.Highlight(lang, code)
+}
+
+// renderInline 渲染行内元素，除生成的标签外所有字符都会被转义
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_[]()!#>-+.", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			run := countRun(s[i:], '`')
+			delim := s[i : i+run]
+			if end := strings.Index(s[i+run:], delim); end >= 0 {
+				code := strings.TrimSpace(s[i+run : i+run+end])
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += run + end + run
+				continue
+			}
+			b.WriteString(delim)
+			i += run
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			if text, target, n, ok := parseLink(s[i+1:]); ok {
+				if u, ok := safeURL(target); ok {
+					b.WriteString(`<img src="` + html.EscapeString(u) + `" alt="` + html.EscapeString(text) + `">`)
+				} else {
+					b.WriteString(html.EscapeString(text))
+				}
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, target, n, ok := parseLink(s[i:]); ok {
+				if u, ok := safeURL(target); ok {
+					b.WriteString(`<a href="` + html.EscapeString(u) + `" rel="nofollow noopener">` + renderInline(text) + "</a>")
+				} else {
+					b.WriteString(renderInline(text))
+				}
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			// 单词中间的下划线（如 snake_case）不作为强调
+			if c == '_' && i > 0 && isWordByte(s[i-1]) {
+				break
+			}
+			run := 1
+			if i+1 < len(s) && s[i+1] == c {
+				run = 2
+			}
+			delim := s[i : i+run]
+			if end := findClosing(s[i+run:], delim); end > 0 {
+				tag := "em"
+				if run == 2 {
+					tag = "strong"
+				}
+				b.WriteString("<" + tag + ">" + renderInline(s[i+run:i+run+end]) + "</" + tag + ">")
+				i += run + end + run
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// parseLink 解析 [text](target)，返回消耗的字节数
+func parseLink(s string) (text, target string, n int, ok bool) {
+	closeText := strings.Index(s, "](")
+	if !strings.HasPrefix(s, "[") || closeText < 0 || strings.Contains(s[1:closeText], "[") {
+		return "", "", 0, false
+	}
+	closeTarget := strings.IndexByte(s[closeText+2:], ')')
+	if closeTarget < 0 {
+		return "", "", 0, false
+	}
+	text = s[1:closeText]
+	target = strings.TrimSpace(s[closeText+2 : closeText+2+closeTarget])
+	return text, target, closeText + 2 + closeTarget + 1, true
+}
+
+// findClosing 查找强调的结束标记，标记前不能是空白；返回相对 s 的位置，找不到时返回 -1
+func findClosing(s, delim string) int {
+	if s == "" || s[0] == ' ' {
+		return -1
+	}
+	for i := 1; i+len(delim) <= len(s); i++ {
+		if s[i:i+len(delim)] != delim || s[i-1] == ' ' {
+			continue
+		}
+		// 单个 * 不能匹配 ** 的一部分
+		if len(delim) == 1 && i+1 < len(s) && s[i+1] == delim[0] {
+			i++
+			continue
+		}
+		if delim[0] == '_' && i+len(delim) < len(s) && isWordByte(s[i+len(delim)]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// safeURL 检查链接地址，只允许 http、https、mailto 和没有协议的相对地址
+func safeURL(raw string) (string, bool) {
+	if raw == "" {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return raw, true
+	case "":
+		// 没有协议时，第一个 / 之前不能有 :，避免浏览器把它当作协议
+		if first := strings.IndexAny(raw, "/?#"); strings.Contains(raw[:max(first, 0)], ":") ||
+			first < 0 && strings.Contains(raw, ":") {
+			return "", false
+		}
+		return raw, true
+	}
+	return "", false
+}
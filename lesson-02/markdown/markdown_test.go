@@ -0,0 +1,84 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+// TestRender 测试支持的语法
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name, src, want string
+	}{
+		{"段落", "hello\nworld\n\nsecond", "<p>hello\nworld</p>\n<p>second</p>\n"},
+		{"标题", "# Title\n### Sub ###", "<h1>Title</h1>\n<h3>Sub</h3>\n"},
+		{"不是标题", "#hashtag", "<p>#hashtag</p>\n"},
+		{"强调", "**bold** and *em* and _em_", "<p><strong>bold</strong> and <em>em</em> and <em>em</em></p>\n"},
+		{"单词中的下划线", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"未闭合的强调", "2 * 3 = 6", "<p>2 * 3 = 6</p>\n"},
+		{"行内代码", "use `a < b` here", "<p>use <code>a &lt; b</code> here</p>\n"},
+		{"转义", `\*not em\*`, "<p>*not em*</p>\n"},
+		{"链接", "[Go](https://go.dev)", `<p><a href="https://go.dev" rel="nofollow noopener">Go</a></p>` + "\n"},
+		{"相对链接", "[文章](/posts/1)", `<p><a href="/posts/1" rel="nofollow noopener">文章</a></p>` + "\n"},
+		{"图片", "![logo](/a.png)", `<p><img src="/a.png" alt="logo"></p>` + "\n"},
+		{"无序列表", "- a\n- b\n  continued", "<ul>\n<li>a</li>\n<li>b\ncontinued</li>\n</ul>\n"},
+		{"有序列表", "1. a\n2. b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"引用", "> quote\n> **b**", "<blockquote>\n<p>quote\n<strong>b</strong></p>\n</blockquote>\n"},
+		{"分隔线", "a\n\n---\n\nb", "<p>a</p>\n<hr>\n<p>b</p>\n"},
+		{"代码块", "```go\nif a < b {\n}\n```", "<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>\n"},
+		{"未闭合的代码块", "```\ncode", "<pre><code>code</code></pre>\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Render(c.src); got != c.want {
+				t.Errorf("Render(%q)\n得到 %q\n预期 %q", c.src, got, c.want)
+			}
+		})
+	}
+}
+
+// TestRenderSanitize 测试原始 HTML 和危险链接不会被输出
+func TestRenderSanitize(t *testing.T) {
+	cases := []struct {
+		name, src string
+	}{
+		{"script 标签", "<script>alert(1)</script>"},
+		{"事件属性", `<img src=x onerror="alert(1)">`},
+		{"javascript 链接", "[click](javascript:alert(1))"},
+		{"大小写混合的协议", "[click](JaVaScRiPt:alert(1))"},
+		{"data 图片", "![x](data:text/html;base64,PHNjcmlwdD4=)"},
+		{"没有斜杠的协议", "[x](vbscript:msgbox)"},
+		{"属性注入", `[x](/a"onmouseover="alert(1))`},
+		{"代码块语言", "```go\"><script>\nx\n```"},
+		{"标题中的 HTML", "# <b>x</b>"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Render(c.src)
+			lower := strings.ToLower(got)
+			for _, bad := range []string{"<script", "<b>", "javascript:", "vbscript:", "data:", `"onmouseover`, "onerror=\""} {
+				if strings.Contains(lower, bad) {
+					t.Errorf("Render(%q) = %q，包含 %q", c.src, got, bad)
+				}
+			}
+		})
+	}
+}
+
+// TestRenderHighlight 测试代码高亮钩子
+func TestRenderHighlight(t *testing.T) {
+	r := &Renderer{Highlight: func(lang, code string) (string, bool) {
+		if lang != "go" {
+			return "", false
+		}
+		return `<span class="kw">` + html.EscapeString(code) + "</span>", true
+	}}
+
+	got := r.Render("```go\nfunc\n```\n\n```sql\n<select>\n```")
+	want := "<pre><code class=\"language-go\"><span class=\"kw\">func</span></code></pre>\n" +
+		"<pre><code class=\"language-sql\">&lt;select&gt;</code></pre>\n"
+	if got != want {
+		t.Errorf("得到 %q\n预期 %q", got, want)
+	}
+}
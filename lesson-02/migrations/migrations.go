@@ -0,0 +1,190 @@
+// Package migrations 提供带版本号的数据库迁移
+//
+// AutoMigrate 只会新增表和列，不能删除列、迁移数据，也无法回滚。
+// 这里把每次结构变更写成一个带版本号的迁移步骤（Up/Down），
+// 已执行的版本记录在 schema_migrations 表中，Runner 按版本顺序执行尚未执行的步骤。
+//
+// 注意：SQLite 和 PostgreSQL 的 DDL 可以在事务中回滚；
+// MySQL 执行 DDL 时会隐式提交事务，迁移中途失败可能留下部分变更。
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration 一个迁移步骤
+type Migration struct {
+	Version int64  // 版本号，按从小到大执行，常用日期格式如 2024010101
+	Name    string // 迁移说明，如 "create_users"
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error // 为 nil 时该迁移不可回滚
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName 迁移记录表名
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status 迁移执行状态
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// ErrIrreversible 回滚没有 Down 的迁移时返回
+var ErrIrreversible = errors.New("迁移不可回滚")
+
+// Runner 迁移执行器
+type Runner struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewRunner 创建迁移执行器，migrations 会按版本号排序
+// 版本号必须为正数且不能重复，Up 不能为空
+func NewRunner(db *gorm.DB, migrations ...Migration) (*Runner, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("迁移 %q 的版本号必须为正数", m.Name)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("迁移 %d_%s 缺少 Up", m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("迁移版本号重复: %d", m.Version)
+		}
+	}
+	return &Runner{db: db, migrations: sorted}, nil
+}
+
+// Up 按顺序执行所有未执行的迁移，返回本次执行的迁移
+// 每个迁移在单独的事务中执行，失败时停止，之前已成功的迁移保留
+func (r *Runner) Up() ([]Migration, error) {
+	return r.UpTo(0)
+}
+
+// UpTo 执行版本号不超过 version 的未执行迁移，version 为 0 表示全部
+func (r *Runner) UpTo(version int64) ([]Migration, error) {
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range r.migrations {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := dbutil.WithTx(r.db, func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("执行迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down 按版本号倒序回滚最近执行的 steps 个迁移，返回本次回滚的迁移
+func (r *Runner) Down(steps int) ([]Migration, error) {
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(r.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := r.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return done, fmt.Errorf("回滚迁移 %d_%s 失败: %w", m.Version, m.Name, ErrIrreversible)
+		}
+		err := dbutil.WithTx(r.db, func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("回滚迁移 %d_%s 失败: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Status 返回所有迁移的执行状态，按版本号排序
+func (r *Runner) Status() ([]Status, error) {
+	applied, err := r.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(r.migrations))
+	for i, m := range r.migrations {
+		record, ok := applied[m.Version]
+		statuses[i] = Status{Migration: m, Applied: ok, AppliedAt: record.AppliedAt}
+	}
+	return statuses, nil
+}
+
+// Version 返回已执行的最大版本号，没有执行过任何迁移时为 0
+func (r *Runner) Version() (int64, error) {
+	if err := r.ensureTable(); err != nil {
+		return 0, err
+	}
+	var version int64
+	err := r.db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// applied 查询已执行的迁移
+func (r *Runner) applied() (map[int64]SchemaMigration, error) {
+	if err := r.ensureTable(); err != nil {
+		return nil, err
+	}
+	var records []SchemaMigration
+	if err := r.db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("查询迁移记录失败: %w", err)
+	}
+	applied := make(map[int64]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// ensureTable 创建迁移记录表
+func (r *Runner) ensureTable() error {
+	if err := r.db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("创建迁移记录表失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package migrations_test
+
+import (
+	"errors"
+	"gohomeworklesson02/migrations"
+	"gohomeworklesson02/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 迁移中使用的模型快照：迁移应描述当时的表结构，而不是引用会继续变化的业务模型
+type memberV1 struct {
+	ID    uint `gorm:"primaryKey"`
+	Name  string
+	Email string `gorm:"uniqueIndex;size:128"`
+}
+
+func (memberV1) TableName() string { return "members" }
+
+type memberV2 struct {
+	memberV1
+	Phone string `gorm:"size:20"`
+}
+
+func (memberV2) TableName() string { return "members" }
+
+func testMigrations() []migrations.Migration {
+	return []migrations.Migration{
+		// 故意乱序，NewRunner 会按版本号排序
+		{
+			Version: 2024010102,
+			Name:    "add_members_phone",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().AddColumn(&memberV2{}, "Phone")
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropColumn(&memberV2{}, "Phone"); err != nil {
+					return err
+				}
+				// SQLite 删除列时会重建表，表上的索引随之丢失，需要重新创建
+				if !tx.Migrator().HasIndex(&memberV1{}, "Email") {
+					return tx.Migrator().CreateIndex(&memberV1{}, "Email")
+				}
+				return nil
+			},
+		},
+		{
+			Version: 2024010101,
+			Name:    "create_members",
+			Up: func(tx *gorm.DB) error {
+				return tx.Migrator().CreateTable(&memberV1{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&memberV1{})
+			},
+		},
+		{
+			Version: 2024010103,
+			Name:    "seed_admin",
+			Up: func(tx *gorm.DB) error {
+				return tx.Create(&memberV2{memberV1: memberV1{Name: "admin", Email: "admin@example.com"}, Phone: "10000"}).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Where("email = ?", "admin@example.com").Delete(&memberV2{}).Error
+			},
+		},
+	}
+}
+
+// TestRunner 测试迁移的执行、回滚和状态
+func TestRunner(t *testing.T) {
+	db := testutil.NewTestDB(t, "migrations.db")
+
+	if err := db.Migrator().DropTable(&migrations.SchemaMigration{}, "members"); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+
+	runner, err := migrations.NewRunner(db, testMigrations()...)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	t.Run("逐步执行", func(t *testing.T) {
+		done, err := runner.UpTo(2024010101)
+		if err != nil {
+			t.Fatalf("UpTo: %v", err)
+		}
+		if len(done) != 1 || done[0].Name != "create_members" {
+			t.Fatalf("预期只执行 create_members，实际 %v", done)
+		}
+		if db.Migrator().HasColumn(&memberV2{}, "Phone") {
+			t.Errorf("phone 列不应存在")
+		}
+
+		done, err = runner.Up()
+		if err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if len(done) != 2 {
+			t.Errorf("预期执行剩余2个迁移，实际 %d 个", len(done))
+		}
+		if !db.Migrator().HasColumn(&memberV2{}, "Phone") {
+			t.Errorf("phone 列应已创建")
+		}
+
+		version, err := runner.Version()
+		if err != nil {
+			t.Fatalf("Version: %v", err)
+		}
+		if version != 2024010103 {
+			t.Errorf("预期版本 2024010103，实际 %d", version)
+		}
+
+		// 再次执行不会重复迁移
+		done, err = runner.Up()
+		if err != nil || len(done) != 0 {
+			t.Errorf("预期没有待执行的迁移，实际 %d 个: %v", len(done), err)
+		}
+	})
+
+	t.Run("回滚", func(t *testing.T) {
+		done, err := runner.Down(2)
+		if err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+		if len(done) != 2 || done[0].Name != "seed_admin" || done[1].Name != "add_members_phone" {
+			t.Fatalf("预期倒序回滚 seed_admin、add_members_phone，实际 %v", done)
+		}
+		if db.Migrator().HasColumn(&memberV2{}, "Phone") {
+			t.Errorf("phone 列应已删除")
+		}
+
+		statuses, err := runner.Status()
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		applied := []bool{true, false, false}
+		for i, s := range statuses {
+			if s.Applied != applied[i] {
+				t.Errorf("迁移 %d_%s 执行状态预期 %v，实际 %v", s.Version, s.Name, applied[i], s.Applied)
+			}
+		}
+		if statuses[0].AppliedAt.IsZero() {
+			t.Errorf("已执行的迁移应记录执行时间")
+		}
+	})
+
+	t.Run("失败的迁移整体回滚", func(t *testing.T) {
+		broken := append(testMigrations(), migrations.Migration{
+			Version: 2024010104,
+			Name:    "broken",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.Exec("CREATE TABLE broken_tmp (id INTEGER)").Error; err != nil {
+					return err
+				}
+				return errors.New("boom")
+			},
+		})
+		r, err := migrations.NewRunner(db, broken...)
+		if err != nil {
+			t.Fatalf("NewRunner: %v", err)
+		}
+		done, err := r.Up()
+		if err == nil {
+			t.Fatalf("预期迁移失败")
+		}
+		if len(done) != 2 {
+			t.Errorf("预期失败前成功执行2个迁移，实际 %d 个", len(done))
+		}
+		if db.Migrator().HasTable("broken_tmp") {
+			t.Errorf("失败迁移的变更应被回滚")
+		}
+		if version, _ := r.Version(); version != 2024010103 {
+			t.Errorf("预期版本停在 2024010103，实际 %d", version)
+		}
+
+		// 回滚全部已执行的迁移
+		if _, err := r.Down(10); err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+	})
+
+	t.Run("不可回滚", func(t *testing.T) {
+		r, err := migrations.NewRunner(db, migrations.Migration{
+			Version: 1,
+			Name:    "irreversible",
+			Up:      func(tx *gorm.DB) error { return nil },
+		})
+		if err != nil {
+			t.Fatalf("NewRunner: %v", err)
+		}
+		if _, err := r.Up(); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if _, err := r.Down(1); !errors.Is(err, migrations.ErrIrreversible) {
+			t.Errorf("预期 ErrIrreversible，实际 %v", err)
+		}
+	})
+
+	t.Run("版本号校验", func(t *testing.T) {
+		up := func(tx *gorm.DB) error { return nil }
+		if _, err := migrations.NewRunner(db, migrations.Migration{Version: 1, Up: up}, migrations.Migration{Version: 1, Up: up}); err == nil {
+			t.Errorf("预期重复版本号返回错误")
+		}
+		if _, err := migrations.NewRunner(db, migrations.Migration{Version: 0, Up: up}); err == nil {
+			t.Errorf("预期非正数版本号返回错误")
+		}
+		if _, err := migrations.NewRunner(db, migrations.Migration{Version: 1}); err == nil {
+			t.Errorf("预期缺少 Up 返回错误")
+		}
+	})
+}
+
+// TestMigrationSchema 逐个执行和回滚迁移，检查每一步的表结构
+func TestMigrationSchema(t *testing.T) {
+	db := testutil.NewTestDB(t, "migration_schema.db")
+
+	v1 := testutil.TableSchema{Columns: []string{"id", "name", "email"}, Indexes: []string{"idx_members_email"}}
+	v2 := testutil.TableSchema{Columns: []string{"id", "name", "email", "phone"}, Indexes: []string{"idx_members_email"}}
+
+	empty := testutil.CurrentSchema(t, db)
+	runner := testutil.RunMigrations(t, db, 2024010101, testMigrations()...)
+	testutil.AssertSchema(t, db, testutil.Schema{Tables: map[string]testutil.TableSchema{"members": v1}, Exact: true})
+
+	testutil.RunMigrations(t, db, 2024010102, testMigrations()...)
+	testutil.AssertSchema(t, db, testutil.Schema{Tables: map[string]testutil.TableSchema{"members": v2}, Exact: true})
+
+	// 回滚后恢复为执行前的结构
+	if _, err := runner.Down(1); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	testutil.AssertSchema(t, db, testutil.Schema{Tables: map[string]testutil.TableSchema{"members": v1}, Exact: true})
+	if _, err := runner.Down(1); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	testutil.AssertSchema(t, db, testutil.Schema{Absent: []string{"members"}})
+	testutil.AssertSchema(t, db, empty)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"gohomeworklesson02/dbutil"
 	"gohomeworklesson02/testutil"
 	"math/rand"
 	"testing"
@@ -15,6 +16,7 @@ import (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+	testutil.RegisterFixtureModels(&User{}, &Product{}, &Order{}, &OrderItem{})
 }
 
 // 业务错误定义
@@ -392,7 +394,7 @@ func MarkOrderPaid(ctx context.Context, db *gorm.DB, orderNo string) error {
 // 2. 使用 Joins 关联订单项表（order_items）
 // 3. 使用 Where 过滤已支付订单
 // 4. 使用 Select 指定聚合字段：
-//   - strftime: SQLite 日期格式化函数（MySQL 使用 DATE，PostgreSQL 使用 TO_CHAR）
+//   - dbutil.DateExpr: 按数据库生成日期格式化表达式（SQLite 用 strftime，MySQL 用 DATE_FORMAT，PostgreSQL 用 TO_CHAR）
 //   - COUNT(DISTINCT): 统计不重复的订单数量
 //   - SUM: 统计商品数量和销售总额
 //
@@ -401,20 +403,19 @@ func MarkOrderPaid(ctx context.Context, db *gorm.DB, orderNo string) error {
 // 7. 使用 Scan 将结果映射到 SalesSummary 结构体
 //
 // 注意：
-// - 本示例使用 SQLite 的 strftime 函数，如果使用 MySQL 或 PostgreSQL，需要调整日期格式化函数
 // - 聚合查询必须使用 Scan 而不是 Find（因为结果不直接映射到模型）
 func SalesReport(db *gorm.DB) ([]SalesSummary, error) {
 	var rows []SalesSummary
 	err := db.Table("orders").
 		// Select 指定要查询的字段和聚合函数
-		// strftime('%Y-%m-%d', ...) 是 SQLite 的日期格式化函数
+		// dbutil.DateExpr 把下单时间格式化为 YYYY-MM-DD
 		// COUNT(DISTINCT ...) 统计不重复的订单数量
 		// SUM(...) 统计商品数量和销售总额
-		Select(`
-			strftime('%Y-%m-%d', orders.created_at) AS day,
+		Select(fmt.Sprintf(`
+			%s AS day,
 			COUNT(DISTINCT orders.id) AS order_count,
 			SUM(order_items.quantity) AS item_count,
-			SUM(order_items.quantity * order_items.unit_price) AS total_amount`).
+			SUM(order_items.quantity * order_items.unit_price) AS total_amount`, dbutil.DateExpr(db, "orders.created_at"))).
 		// Joins 关联订单项表，用于统计商品数量和计算销售总额
 		Joins("JOIN order_items ON order_items.order_id = orders.id").
 		// Where 只统计已支付的订单
@@ -720,12 +721,12 @@ func SalesReportWithDateRange(db *gorm.DB, startDate, endDate time.Time) ([]Sale
 	var rows []SalesSummary
 
 	query := db.Table("orders").
-		Select(`
-            strftime('%Y-%m-%d', orders.created_at) AS day,
+		Select(fmt.Sprintf(`
+            %s AS day,
             COUNT(DISTINCT orders.id) AS order_count,
             SUM(order_items.quantity) AS item_count,
             SUM(order_items.quantity * order_items.unit_price) AS total_amount
-        `).
+        `, dbutil.DateExpr(db, "orders.created_at"))).
 		Joins("JOIN order_items ON order_items.order_id = orders.id").
 		Where("orders.status = ?", "PAID")
 
@@ -747,27 +748,48 @@ func SalesReportWithDateRange(db *gorm.DB, startDate, endDate time.Time) ([]Sale
 
 // 扩展销售报表-测试函数
 func TestSalesReportByProduct(t *testing.T) {
-	db := testutil.NewTestDB(t, "ecommerce.db")
+	db := testutil.NewTestDB(t, "sales_report.db")
 
+	// 每次运行前重建表，订单数据从 fixtures 加载
+	if err := db.Migrator().DropTable(&OrderItem{}, &Order{}, &Product{}, &User{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
 	if err := migrate(db); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
-	if err := seedData(db); err != nil {
-		t.Fatalf("seed data: %v", err)
-	}
+	fx := testutil.LoadFixtures(t, db, "testdata/fixtures/sales.yaml")
 
-	// 查询商品销售统计
+	// 查询商品销售统计，只统计已支付订单
 	report, err := SalesReportByProduct(db, time.Time{}, time.Time{})
 	if err != nil {
 		t.Fatalf("SalesReportByProduct failed: %v", err)
 	}
-
-	t.Logf("商品销售统计数量: %d", len(report))
 	for _, item := range report {
 		t.Logf("商品: %s 销售数量:%d 销售额:%.2f 均价:%.2f",
 			item.ProductName, item.SalesCount,
 			float64(item.TotalAmount)/100, float64(item.AvgPrice)/100)
 	}
+	if len(report) != 2 {
+		t.Fatalf("预期2个商品，实际 %d", len(report))
+	}
+	book, keyboard := report[0], report[1]
+	if book.ProductID != fx.ID("products", "book") || book.SalesCount != 3 || book.TotalAmount != 41400 || book.AvgPrice != 13800 {
+		t.Errorf("图书统计不正确: %+v", book)
+	}
+	if keyboard.ProductID != fx.ID("products", "keyboard") || keyboard.SalesCount != 1 || keyboard.TotalAmount != 39900 {
+		t.Errorf("键盘统计不正确（待支付订单不计入）: %+v", keyboard)
+	}
+	testutil.AssertGoldenJSON(t, "sales_report", report, testutil.NormalizeIDs())
+
+	// 按时间范围过滤
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	report, err = SalesReportByProduct(db, start, time.Time{})
+	if err != nil {
+		t.Fatalf("SalesReportByProduct failed: %v", err)
+	}
+	if len(report) != 1 || report[0].ProductID != fx.ID("products", "book") || report[0].SalesCount != 1 {
+		t.Errorf("预期只统计1月2日之后的1本图书，实际 %+v", report)
+	}
 }
 
 // 实现订单取消
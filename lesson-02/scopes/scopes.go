@@ -0,0 +1,90 @@
+// Package scopes 提供可组合的通用查询 scope
+//
+// 每个函数返回 func(*gorm.DB) *gorm.DB，可以直接传给 db.Scopes，
+// 也可以和 dbutil.Paginate 一起传给 dbutil.FindWithCount。
+// 参数为空（零值时间、空状态列表、空关键词）时 scope 不添加任何条件，
+// 调用方可以把请求中的可选筛选参数原样传入。
+package scopes
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreatedBetween 按 created_at 筛选 [from, to) 范围内的记录
+// from 或 to 为零值时表示该端不限
+func CreatedBetween(from, to time.Time) func(*gorm.DB) *gorm.DB {
+	return TimeBetween("created_at", from, to)
+}
+
+// TimeBetween 按时间列 column 筛选 [from, to) 范围内的记录，规则同 CreatedBetween
+// 指定了范围时 column 为 NULL 的记录不会被选中；column 由调用方指定，不要使用请求参数
+func TimeBetween(column string, from, to time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !from.IsZero() {
+			db = db.Where("? >= ?", clause.Column{Name: column}, from)
+		}
+		if !to.IsZero() {
+			db = db.Where("? < ?", clause.Column{Name: column}, to)
+		}
+		return db
+	}
+}
+
+// StatusIn 筛选状态为 statuses 之一的记录，statuses 为空时不筛选
+func StatusIn(statuses ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(statuses) == 0 {
+			return db
+		}
+		return db.Where("status IN ?", statuses)
+	}
+}
+
+// OrderBySafe 按白名单中的列排序，用于排序字段来自请求参数的场景
+// column 不在 allowed 中（包括为空）时不添加排序，调用方可以在之后追加默认排序；
+// direction 为 "desc"（不区分大小写）时降序，其余情况升序
+// 列名经过 Quote 处理，不会被拼接进 SQL
+func OrderBySafe(column, direction string, allowed ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if !slices.Contains(allowed, column) {
+			return db
+		}
+		return db.Order(clause.OrderByColumn{
+			Column: clause.Column{Name: column},
+			Desc:   strings.EqualFold(direction, "desc"),
+		})
+	}
+}
+
+// Search 按空白拆分关键词，每个关键词都必须出现在 columns 中的某一列（不区分大小写）
+// 关键词中的 % 和 _ 按普通字符匹配；columns 由调用方指定，不要使用请求参数
+func Search(q string, columns ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tokens := strings.Fields(strings.ToLower(q))
+		if len(tokens) == 0 || len(columns) == 0 {
+			return db
+		}
+		for _, token := range tokens {
+			pattern := "%" + EscapeLike(token) + "%"
+			conds := make([]string, len(columns))
+			vars := make([]interface{}, 0, 2*len(columns))
+			for i, column := range columns {
+				// ESCAPE '!' 而不是反斜杠：MySQL 字符串字面量中反斜杠本身需要转义
+				conds[i] = "LOWER(?) LIKE ? ESCAPE '!'"
+				vars = append(vars, clause.Column{Name: column}, pattern)
+			}
+			db = db.Where(strings.Join(conds, " OR "), vars...)
+		}
+		return db
+	}
+}
+
+// EscapeLike 转义 LIKE 通配符，转义字符为 '!'，配合 ESCAPE '!' 使用
+func EscapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,104 @@
+package scopes
+
+import (
+	"gohomeworklesson02/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type article struct {
+	ID        uint `gorm:"primaryKey"`
+	Title     string
+	Author    string
+	Status    string
+	Views     int
+	CreatedAt time.Time
+}
+
+// TestScopes 测试各个 scope 单独使用和组合使用
+func TestScopes(t *testing.T) {
+	db := testutil.NewTestDB(t, "scopes.db")
+
+	if err := db.Migrator().DropTable(&article{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&article{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	seed := []article{
+		{Title: "GORM 入门", Author: "Alice", Status: "published", Views: 30, CreatedAt: day(1)},
+		{Title: "GORM Scopes", Author: "Bob", Status: "draft", Views: 10, CreatedAt: day(2)},
+		{Title: "Go 并发", Author: "Alice", Status: "archived", Views: 50, CreatedAt: day(3)},
+		{Title: "100% 覆盖率", Author: "Carol", Status: "published", Views: 20, CreatedAt: day(4)},
+		{Title: "SQL_优化", Author: "Bob", Status: "published", Views: 40, CreatedAt: day(5)},
+	}
+	if err := db.Create(&seed).Error; err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	// titles 返回查询到的标题，scopes 中没有排序时按 id 顺序
+	// db.Scopes 在执行时才应用，这里先应用 scopes 再追加 id 排序
+	titles := func(t *testing.T, scopes ...func(*gorm.DB) *gorm.DB) string {
+		t.Helper()
+		query := db
+		for _, scope := range scopes {
+			query = scope(query)
+		}
+		var got []article
+		if err := query.Order("id").Find(&got).Error; err != nil {
+			t.Fatalf("find: %v", err)
+		}
+		names := make([]string, len(got))
+		for i, a := range got {
+			names[i] = a.Title
+		}
+		return strings.Join(names, ",")
+	}
+
+	cases := []struct {
+		name   string
+		scopes []func(*gorm.DB) *gorm.DB
+		want   string
+	}{
+		{"CreatedBetween", []func(*gorm.DB) *gorm.DB{CreatedBetween(day(2), day(4))}, "GORM Scopes,Go 并发"},
+		{"CreatedBetween 只限起点", []func(*gorm.DB) *gorm.DB{CreatedBetween(day(4), time.Time{})}, "100% 覆盖率,SQL_优化"},
+		{"CreatedBetween 不限", []func(*gorm.DB) *gorm.DB{CreatedBetween(time.Time{}, time.Time{})}, "GORM 入门,GORM Scopes,Go 并发,100% 覆盖率,SQL_优化"},
+		{"TimeBetween 只限终点", []func(*gorm.DB) *gorm.DB{TimeBetween("created_at", time.Time{}, day(3))}, "GORM 入门,GORM Scopes"},
+		{"StatusIn", []func(*gorm.DB) *gorm.DB{StatusIn("draft", "archived")}, "GORM Scopes,Go 并发"},
+		{"StatusIn 为空", []func(*gorm.DB) *gorm.DB{StatusIn()}, "GORM 入门,GORM Scopes,Go 并发,100% 覆盖率,SQL_优化"},
+		{"OrderBySafe", []func(*gorm.DB) *gorm.DB{OrderBySafe("views", "DESC", "views", "title")}, "Go 并发,SQL_优化,GORM 入门,100% 覆盖率,GORM Scopes"},
+		// 不在白名单中的列被忽略，按默认的 id 排序
+		{"OrderBySafe 注入", []func(*gorm.DB) *gorm.DB{OrderBySafe("views; DROP TABLE articles", "desc", "views")}, "GORM 入门,GORM Scopes,Go 并发,100% 覆盖率,SQL_优化"},
+		{"Search", []func(*gorm.DB) *gorm.DB{Search("gorm", "title", "author")}, "GORM 入门,GORM Scopes"},
+		{"Search 多个关键词", []func(*gorm.DB) *gorm.DB{Search("gorm bob", "title", "author")}, "GORM Scopes"},
+		{"Search 通配符", []func(*gorm.DB) *gorm.DB{Search("100%", "title")}, "100% 覆盖率"},
+		{"Search 下划线", []func(*gorm.DB) *gorm.DB{Search("_", "title")}, "SQL_优化"},
+		{"组合", []func(*gorm.DB) *gorm.DB{
+			StatusIn("published"),
+			CreatedBetween(day(1), day(5)),
+			Search("alice carol", "author"),
+		}, ""},
+		{"组合排序", []func(*gorm.DB) *gorm.DB{
+			StatusIn("published"),
+			Search("o", "title", "author"),
+			OrderBySafe("views", "asc", "views"),
+		}, "100% 覆盖率,GORM 入门,SQL_优化"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := titles(t, c.scopes...); got != c.want {
+				t.Errorf("结果 [%s]，预期 [%s]", got, c.want)
+			}
+		})
+	}
+
+	// 不在白名单中的排序参数没有被执行
+	if !db.Migrator().HasTable(&article{}) {
+		t.Errorf("articles 表不应被删除")
+	}
+}
@@ -0,0 +1,188 @@
+// Package seed 提供数据库种子数据的加载
+//
+// 种子数据可以用 Go 代码构造（Records），也可以从 YAML 文件读取（YAML）。
+// 每条记录都必须显式指定主键，重复执行时按主键更新已有记录，
+// 因此测试和演示程序多次运行得到的数据完全一致。
+package seed
+
+import (
+	"fmt"
+	"gohomeworklesson02/dbutil"
+	"io/fs"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Seed 一组种子数据
+type Seed struct {
+	Name string
+	Envs []string // 适用的环境，为空表示所有环境
+	Run  func(tx *gorm.DB) error
+}
+
+// In 返回只在指定环境执行的副本，如 seed.Records(...).In("development", "test")
+func (s Seed) In(envs ...string) Seed {
+	s.Envs = envs
+	return s
+}
+
+// appliesTo 判断种子数据是否适用于 env
+func (s Seed) appliesTo(env string) bool {
+	if len(s.Envs) == 0 {
+		return true
+	}
+	for _, e := range s.Envs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+// Seeder 按添加顺序执行种子数据
+type Seeder struct {
+	db    *gorm.DB
+	seeds []Seed
+}
+
+// New 创建 Seeder
+func New(db *gorm.DB) *Seeder {
+	return &Seeder{db: db}
+}
+
+// Add 追加种子数据，有依赖关系的数据（如文章依赖用户）需要排在后面
+func (s *Seeder) Add(seeds ...Seed) *Seeder {
+	s.seeds = append(s.seeds, seeds...)
+	return s
+}
+
+// Run 在一个事务中执行适用于 env 的种子数据，返回执行的种子名称
+// 任意一组失败时全部回滚
+func (s *Seeder) Run(env string) ([]string, error) {
+	var names []string
+	err := dbutil.WithTx(s.db, func(tx *gorm.DB) error {
+		names = names[:0]
+		for _, seed := range s.seeds {
+			if !seed.appliesTo(env) {
+				continue
+			}
+			if err := seed.Run(tx); err != nil {
+				return fmt.Errorf("执行种子数据 %s 失败: %w", seed.Name, err)
+			}
+			names = append(names, seed.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Records 用 Go 代码构造种子数据，build 返回的每条记录都必须指定主键
+func Records[T any](name string, build func() []T) Seed {
+	return Seed{
+		Name: name,
+		Run: func(tx *gorm.DB) error {
+			return save(tx, build())
+		},
+	}
+}
+
+// YAML 从 fsys 中的 YAML 文件读取模型 T 的种子数据
+// 文件内容为记录列表，键使用数据库列名，如:
+//
+//   - id: 1
+//     name: Alice
+//     created_at: 2024-01-01T00:00:00Z
+func YAML[T any](name string, fsys fs.FS, path string) Seed {
+	return Seed{
+		Name: name,
+		Run: func(tx *gorm.DB) error {
+			records, err := loadYAML[T](tx, fsys, path)
+			if err != nil {
+				return err
+			}
+			return save(tx, records)
+		},
+	}
+}
+
+// loadYAML 读取 YAML 文件并按列名赋值给模型字段
+func loadYAML[T any](tx *gorm.DB, fsys fs.FS, path string) ([]T, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %w", path, err)
+	}
+	var rows []map[string]interface{}
+	if err := yaml.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+
+	sch, err := parseSchema[T](tx)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]T, len(rows))
+	for i, row := range rows {
+		value := reflect.ValueOf(&records[i]).Elem()
+		for column, v := range row {
+			field := sch.LookUpField(column)
+			if field == nil {
+				return nil, fmt.Errorf("%s 第%d条记录: %s 没有字段 %s", path, i+1, sch.Name, column)
+			}
+			if err := field.Set(tx.Statement.Context, value, v); err != nil {
+				return nil, fmt.Errorf("%s 第%d条记录: 字段 %s: %w", path, i+1, column, err)
+			}
+		}
+	}
+	return records, nil
+}
+
+// save 按主键插入或更新记录
+func save[T any](tx *gorm.DB, records []T) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	sch, err := parseSchema[T](tx)
+	if err != nil {
+		return err
+	}
+	pk := sch.PrioritizedPrimaryField
+	if pk == nil {
+		return fmt.Errorf("%s 没有主键", sch.Name)
+	}
+	for i := range records {
+		if _, zero := pk.ValueOf(tx.Statement.Context, reflect.ValueOf(&records[i]).Elem()); zero {
+			return fmt.Errorf("%s 第%d条记录没有指定 %s，种子数据需要固定主键", sch.Name, i+1, pk.Name)
+		}
+	}
+
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&records).Error; err != nil {
+		return err
+	}
+
+	// PostgreSQL 显式写入主键不会推进序列，之后的普通插入会与种子数据冲突
+	if tx.Dialector.Name() == "postgres" && pk.AutoIncrement {
+		sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), (SELECT MAX(%s) FROM %s))",
+			sch.Table, pk.DBName, pk.DBName, sch.Table)
+		if err := tx.Exec(sql).Error; err != nil {
+			return fmt.Errorf("更新 %s 主键序列失败: %w", sch.Table, err)
+		}
+	}
+	return nil
+}
+
+// parseSchema 解析模型 T 的表结构
+func parseSchema[T any](tx *gorm.DB) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, fmt.Errorf("解析模型失败: %w", err)
+	}
+	return stmt.Schema, nil
+}
@@ -0,0 +1,148 @@
+package seed
+
+import (
+	"errors"
+	"gohomeworklesson02/testutil"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type seedUser struct {
+	ID        uint `gorm:"primaryKey"`
+	Name      string
+	Email     string `gorm:"uniqueIndex;size:128"`
+	CreatedAt time.Time
+}
+
+type seedTag struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+type seedPost struct {
+	ID     uint `gorm:"primaryKey"`
+	Title  string
+	UserID uint
+	Tags   []seedTag `gorm:"many2many:seed_post_tags"`
+}
+
+func testSeeder(db *gorm.DB, posts func() []seedPost) *Seeder {
+	return New(db).Add(
+		Records("users", func() []seedUser {
+			return []seedUser{
+				{ID: 1, Name: "Alice", Email: "alice@example.com"},
+				{ID: 2, Name: "Bob", Email: "bob@example.com"},
+			}
+		}),
+		YAML[seedTag]("tags", os.DirFS("testdata"), "tags.yaml"),
+		Records("posts", posts),
+		Records("demo users", func() []seedUser {
+			return []seedUser{{ID: 100, Name: "Demo", Email: "demo@example.com"}}
+		}).In("development"),
+	)
+}
+
+// TestSeeder 测试种子数据的加载、幂等和环境筛选
+func TestSeeder(t *testing.T) {
+	db := testutil.NewTestDB(t, "seed.db")
+
+	if err := db.Migrator().DropTable("seed_post_tags", &seedPost{}, &seedTag{}, &seedUser{}); err != nil {
+		t.Fatalf("drop table: %v", err)
+	}
+	if err := db.AutoMigrate(&seedUser{}, &seedTag{}, &seedPost{}); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	posts := func() []seedPost {
+		return []seedPost{
+			{ID: 1, Title: "Hello GORM", UserID: 1, Tags: []seedTag{{ID: 1}, {ID: 2}}},
+			{ID: 2, Title: "SQLite tips", UserID: 2, Tags: []seedTag{{ID: 3}}},
+		}
+	}
+
+	t.Run("按环境执行", func(t *testing.T) {
+		names, err := testSeeder(db, posts).Run("test")
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if len(names) != 3 {
+			t.Errorf("test 环境预期执行3组，实际 %v", names)
+		}
+
+		var demo int64
+		db.Model(&seedUser{}).Where("id = ?", 100).Count(&demo)
+		if demo != 0 {
+			t.Errorf("development 种子数据不应在 test 环境执行")
+		}
+
+		var post seedPost
+		if err := db.Preload("Tags").First(&post, 1).Error; err != nil {
+			t.Fatalf("query post: %v", err)
+		}
+		if len(post.Tags) != 2 || post.Tags[1].Name != "gorm" {
+			t.Errorf("文章标签不正确: %+v", post.Tags)
+		}
+	})
+
+	t.Run("重复执行保持一致", func(t *testing.T) {
+		// 修改数据后重新执行，恢复为种子数据
+		db.Model(&seedUser{}).Where("id = ?", 1).Update("name", "Changed")
+
+		if _, err := testSeeder(db, posts).Run("development"); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		var users []seedUser
+		if err := db.Order("id").Find(&users).Error; err != nil {
+			t.Fatalf("query users: %v", err)
+		}
+		if len(users) != 3 || users[0].Name != "Alice" || users[2].ID != 100 {
+			t.Errorf("用户数据不正确: %+v", users)
+		}
+		var tags int64
+		db.Model(&seedTag{}).Count(&tags)
+		if tags != 3 {
+			t.Errorf("预期3个标签，实际 %d", tags)
+		}
+	})
+
+	t.Run("缺少主键时回滚", func(t *testing.T) {
+		before := func() int64 {
+			var n int64
+			db.Model(&seedUser{}).Count(&n)
+			return n
+		}()
+
+		seeder := New(db).Add(
+			Records("ok", func() []seedUser {
+				return []seedUser{{ID: 3, Name: "Carol", Email: "carol@example.com"}}
+			}),
+			Records("no id", func() []seedUser {
+				return []seedUser{{Name: "NoID", Email: "noid@example.com"}}
+			}),
+		)
+		if _, err := seeder.Run("test"); err == nil {
+			t.Fatalf("预期缺少主键时返回错误")
+		}
+
+		var after int64
+		db.Model(&seedUser{}).Count(&after)
+		if after != before {
+			t.Errorf("失败时应全部回滚，用户数 %d -> %d", before, after)
+		}
+	})
+
+	t.Run("YAML 错误", func(t *testing.T) {
+		_, err := New(db).Add(YAML[seedTag]("bad", os.DirFS("testdata"), "bad_column.yaml")).Run("test")
+		if err == nil {
+			t.Errorf("预期未知字段返回错误")
+		}
+		_, err = New(db).Add(YAML[seedTag]("missing", os.DirFS("testdata"), "missing.yaml")).Run("test")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("预期文件不存在错误，实际 %v", err)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// maxLoggedBody limits how much of a body Exchange.String prints
+const maxLoggedBody = 1024
+
+// Exchange is a request/response pair recorded by APIServer
+type Exchange struct {
+	Method         string
+	Path           string // path and query, e.g. /posts?page=2
+	RequestHeader  http.Header
+	RequestBody    []byte
+	Status         int
+	ResponseHeader http.Header
+	ResponseBody   []byte
+	Duration       time.Duration
+}
+
+// String formats the exchange for test logs, with long bodies truncated
+func (e Exchange) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s -> %d (%s)", e.Method, e.Path, e.Status, e.Duration.Round(time.Microsecond))
+	if len(e.RequestBody) > 0 {
+		fmt.Fprintf(&b, "\n  request:  %s", truncateBody(e.RequestBody))
+	}
+	if len(e.ResponseBody) > 0 {
+		fmt.Fprintf(&b, "\n  response: %s", truncateBody(e.ResponseBody))
+	}
+	return b.String()
+}
+
+// DecodeResponse decodes the JSON response body into dest
+func (e Exchange) DecodeResponse(dest interface{}) error {
+	return json.Unmarshal(e.ResponseBody, dest)
+}
+
+// truncateBody returns body as a trimmed string of at most maxLoggedBody bytes
+func truncateBody(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxLoggedBody {
+		return s[:maxLoggedBody] + "...(truncated)"
+	}
+	return s
+}
+
+// APIServer serves an HTTP handler on a local port for end-to-end API tests and
+// records every request/response pair it handles, whichever client sent it.
+// When the test fails, the recorded exchanges are logged
+type APIServer struct {
+	URL string // base URL, e.g. http://127.0.0.1:53211
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewAPIServer starts handler on a local port; the server is closed when the
+// test finishes, before the cleanups registered earlier, such as closing the
+// database the handler uses
+func NewAPIServer(t testing.TB, handler http.Handler) *APIServer {
+	t.Helper()
+	s := &APIServer{}
+	s.server = httptest.NewServer(s.record(handler))
+	s.URL = s.server.URL
+	t.Cleanup(s.server.Close)
+	AddCleanup(t, CleanupReport, func() {
+		if !t.Failed() {
+			return
+		}
+		for i, e := range s.Exchanges() {
+			t.Logf("exchange %d: %s", i+1, e)
+		}
+	})
+	return s
+}
+
+// record wraps handler to store each request and response
+func (s *APIServer) record(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var reqBody []byte
+		if r.Body != nil {
+			reqBody, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+		}
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.exchanges = append(s.exchanges, Exchange{
+			Method:         r.Method,
+			Path:           r.URL.RequestURI(),
+			RequestHeader:  r.Header.Clone(),
+			RequestBody:    reqBody,
+			Status:         rec.status,
+			ResponseHeader: w.Header().Clone(),
+			ResponseBody:   rec.body.Bytes(),
+			Duration:       time.Since(start),
+		})
+	})
+}
+
+// recordingWriter copies the status and body written to a ResponseWriter
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Exchanges returns the recorded exchanges in the order the handler finished them
+// An exchange is recorded before the client receives the end of the response, so
+// it is available as soon as the request returns
+func (s *APIServer) Exchanges() []Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Exchange(nil), s.exchanges...)
+}
+
+// LastExchange returns the most recent exchange; ok is false when there is none
+func (s *APIServer) LastExchange() (e Exchange, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.exchanges) == 0 {
+		return Exchange{}, false
+	}
+	return s.exchanges[len(s.exchanges)-1], true
+}
+
+// ResetExchanges forgets the recorded exchanges, e.g. after setting up users
+func (s *APIServer) ResetExchanges() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exchanges = nil
+}
+
+// Client returns a JSON client for the server without credentials
+func (s *APIServer) Client() *APIClient {
+	return &APIClient{server: s, Header: http.Header{}}
+}
+
+// APIClient sends JSON requests to an APIServer
+// Project-specific clients embed it and add typed methods, e.g. CreatePost
+type APIClient struct {
+	server *APIServer
+	Token  string      // sent as a Bearer token when not empty
+	Header http.Header // extra headers sent with every request
+}
+
+// WithToken returns a copy of the client that authenticates with token
+func (c *APIClient) WithToken(token string) *APIClient {
+	clone := *c
+	clone.Header = c.Header.Clone()
+	clone.Token = token
+	return &clone
+}
+
+// Do sends a request and returns the status code. body is sent as is when it is
+// a string or []byte and encoded as JSON otherwise (nil sends no body); a
+// non-empty response is decoded into dest unless dest is nil
+func (c *APIClient) Do(t testing.TB, method, path string, body, dest interface{}) int {
+	t.Helper()
+	status, data := c.send(t, method, path, body)
+	decodeResponse(t, method, path, data, dest)
+	return status
+}
+
+// Expect sends a request like Do and fails the test immediately when the status
+// code is not status, printing the response body
+func (c *APIClient) Expect(t testing.TB, status int, method, path string, body, dest interface{}) {
+	t.Helper()
+	code, data := c.send(t, method, path, body)
+	if code != status {
+		t.Fatalf("%s %s returned %d, expected %d: %s", method, path, code, status, truncateBody(data))
+	}
+	decodeResponse(t, method, path, data, dest)
+}
+
+// send performs the request and returns the status code and response body
+func (c *APIClient) send(t testing.TB, method, path string, body interface{}) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("%s %s: marshal request: %v", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.server.server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: read response: %v", method, path, err)
+	}
+	return resp.StatusCode, data
+}
+
+// decodeResponse decodes a non-empty JSON response into dest unless dest is nil
+func decodeResponse(t testing.TB, method, path string, data []byte, dest interface{}) {
+	t.Helper()
+	if dest == nil || len(data) == 0 {
+		return
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		t.Fatalf("%s %s: decode response: %v\n%s", method, path, err, truncateBody(data))
+	}
+}
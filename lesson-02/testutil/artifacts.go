@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	artifactDirsMu sync.Mutex
+	artifactDirs   = map[testing.TB]string{}
+)
+
+// ArtifactDir returns a directory for the files a test produces: SQLite
+// databases, log files, exports, attachments. Every test (and subtest) gets its
+// own directory under os.TempDir, created on first use; repeated calls return the
+// same one. It is removed when the test finishes, unless the test failed and
+// TEST_KEEP_ARTIFACTS is set, in which case its path is logged for debugging:
+//
+//	TEST_KEEP_ARTIFACTS=true go test ./basics -run TestExportUsers
+func ArtifactDir(t testing.TB) string {
+	t.Helper()
+	artifactDirsMu.Lock()
+	defer artifactDirsMu.Unlock()
+	if dir, ok := artifactDirs[t]; ok {
+		return dir
+	}
+
+	dir, err := os.MkdirTemp("", isolatedName(t)+"_")
+	if err != nil {
+		t.Fatalf("create artifact dir: %v", err)
+	}
+	artifactDirs[t] = dir
+	AddCleanup(t, CleanupRemove, func() {
+		artifactDirsMu.Lock()
+		delete(artifactDirs, t)
+		artifactDirsMu.Unlock()
+		if t.Failed() && keepArtifacts() {
+			t.Logf("test artifacts kept in %s", dir)
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("remove artifact dir: %v", err)
+		}
+	})
+	return dir
+}
+
+// ArtifactPath returns the path of name inside ArtifactDir(t), creating parent
+// directories when name contains slashes, e.g. ArtifactPath(t, "exports/users.csv")
+func ArtifactPath(t testing.TB, name string) string {
+	t.Helper()
+	path := filepath.Join(ArtifactDir(t), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("create artifact dir: %v", err)
+	}
+	return path
+}
+
+// keepArtifacts reports whether TEST_KEEP_ARTIFACTS is set to true
+func keepArtifacts() bool {
+	loadEnv()
+	switch strings.ToLower(os.Getenv("TEST_KEEP_ARTIFACTS")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
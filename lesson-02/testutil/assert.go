@@ -0,0 +1,147 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// AssertCount checks that model has n rows matching where (soft-deleted rows excluded)
+// model may be nil when db is scoped with Table, e.g. db.Table("post_tags")
+// where is anything db.Where accepts without arguments: a SQL string, a map or a
+// struct; nil matches all rows. For conditions with arguments pass a scoped db,
+// e.g. AssertCount(t, db.Where("age > ?", 18), &User{}, nil, 2)
+func AssertCount(t testing.TB, db *gorm.DB, model interface{}, where interface{}, n int64) {
+	t.Helper()
+	if got := countRows(t, db, model, where); got != n {
+		t.Errorf("expected %d %s rows matching %v, got %d", n, modelName(db, model), describeWhere(where), got)
+	}
+}
+
+// AssertExists checks that model has at least one row matching where, see AssertCount
+func AssertExists(t testing.TB, db *gorm.DB, model interface{}, where interface{}) {
+	t.Helper()
+	if countRows(t, db, model, where) == 0 {
+		t.Errorf("expected a %s row matching %v, found none", modelName(db, model), describeWhere(where))
+	}
+}
+
+// AssertSoftDeleted checks that the row of model with primary key id still exists
+// but has deleted_at set, so normal queries no longer see it
+func AssertSoftDeleted(t testing.TB, db *gorm.DB, model interface{}, id interface{}) {
+	t.Helper()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		t.Fatalf("parse model %T: %v", model, err)
+	}
+	deletedAt := stmt.Schema.LookUpField("DeletedAt")
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if deletedAt == nil || pk == nil {
+		t.Fatalf("%s has no DeletedAt field or primary key", stmt.Schema.Name)
+	}
+
+	var deleted int64
+	err := db.Unscoped().Model(model).
+		Where(pk.DBName+" = ? AND "+deletedAt.DBName+" IS NOT NULL", id).
+		Count(&deleted).Error
+	if err != nil {
+		t.Fatalf("count %s: %v", stmt.Schema.Name, err)
+	}
+	if deleted == 0 {
+		var total int64
+		db.Unscoped().Model(model).Where(pk.DBName+" = ?", id).Count(&total)
+		if total == 0 {
+			t.Errorf("expected %s %v to be soft deleted, but it does not exist", stmt.Schema.Name, id)
+		} else {
+			t.Errorf("expected %s %v to be soft deleted, but it is not deleted", stmt.Schema.Name, id)
+		}
+	}
+}
+
+// AssertNoOrphans checks that every foreign key in table points to an existing row
+// (soft-deleted parents count as existing). refs are "column=parent_table" pairs,
+// e.g. AssertNoOrphans(t, db, "post_tags", "post_id=posts", "tag_id=tags");
+// without refs, each <name>_id column is checked against the table GORM would
+// name for <name> if it exists, which covers join tables such as post_tags
+func AssertNoOrphans(t testing.TB, db *gorm.DB, table string, refs ...string) {
+	t.Helper()
+	if len(refs) == 0 {
+		refs = inferRefs(t, db, table)
+		if len(refs) == 0 {
+			t.Fatalf("no foreign key columns found in %s, pass refs explicitly", table)
+		}
+	}
+
+	for _, ref := range refs {
+		column, parent, ok := strings.Cut(ref, "=")
+		if !ok {
+			t.Fatalf("invalid ref %q, expected column=parent_table", ref)
+		}
+		var orphans []interface{}
+		err := db.Table(table+" AS child").
+			Where("child."+column+" IS NOT NULL").
+			Where("NOT EXISTS (SELECT 1 FROM "+parent+" AS parent WHERE parent.id = child."+column+")").
+			Distinct().Pluck("child."+column, &orphans).Error
+		if err != nil {
+			t.Fatalf("check %s.%s: %v", table, column, err)
+		}
+		if len(orphans) > 0 {
+			t.Errorf("%s.%s references missing %s rows: %v", table, column, parent, orphans)
+		}
+	}
+}
+
+// inferRefs maps the <name>_id columns of table to existing tables named after <name>
+func inferRefs(t testing.TB, db *gorm.DB, table string) []string {
+	t.Helper()
+	columns, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		t.Fatalf("read columns of %s: %v", table, err)
+	}
+	var refs []string
+	for _, column := range columns {
+		name, ok := strings.CutSuffix(column.Name(), "_id")
+		if !ok || name == "" {
+			continue
+		}
+		parent := db.NamingStrategy.TableName(name)
+		if db.Migrator().HasTable(parent) {
+			refs = append(refs, column.Name()+"="+parent)
+		}
+	}
+	return refs
+}
+
+// countRows counts the rows of model matching where
+func countRows(t testing.TB, db *gorm.DB, model interface{}, where interface{}) int64 {
+	t.Helper()
+	query := db.Model(model)
+	if where != nil {
+		query = query.Where(where)
+	}
+	var n int64
+	if err := query.Count(&n).Error; err != nil {
+		t.Fatalf("count %s: %v", modelName(db, model), err)
+	}
+	return n
+}
+
+// modelName returns the type name of model for messages, e.g. User for &User{},
+// or the table name when db is scoped with Table and model is nil
+func modelName(db *gorm.DB, model interface{}) string {
+	if model == nil {
+		return db.Statement.Table
+	}
+	name := fmt.Sprintf("%T", model)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// describeWhere formats where for messages
+func describeWhere(where interface{}) string {
+	if where == nil {
+		return "(all)"
+	}
+	return fmt.Sprintf("%+v", where)
+}
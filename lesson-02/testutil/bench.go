@@ -0,0 +1,205 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// BenchSeed creates the schema and writes the dataset of a benchmark database
+// rows is the size passed to NewBenchDB; the same rows must always produce the
+// same data, so benchmark results are comparable across runs
+type BenchSeed func(db *gorm.DB, rows int) error
+
+var (
+	benchMu        sync.Mutex
+	benchSeed      BenchSeed
+	benchTemplates = map[int]string{}
+)
+
+// RegisterBenchSeed sets the seed NewBenchDB uses in this test binary, usually
+// from an init function in a _test.go file, since the models live in the tested package:
+//
+//	func init() { testutil.RegisterBenchSeed(seedBenchData) }
+func RegisterBenchSeed(seed BenchSeed) {
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	benchSeed = seed
+	benchTemplates = map[int]string{}
+}
+
+// NewBenchDB returns a SQLite database seeded by the registered BenchSeed with rows
+// records, whatever TEST_DB_TYPE is. Seeding runs once per rows: the result is
+// kept as a template file and every call gets its own copy in ArtifactDir, so
+// benchmarks may write to it. Templates are cached across runs in
+// os.TempDir()/gotest_benchdb, keyed by a hash of the test binary, so they are
+// rebuilt whenever the code changes; set TEST_BENCH_CACHE=false to seed every
+// database from scratch. When tb is a *testing.B its timer is reset, so setup is
+// not measured
+func NewBenchDB(tb testing.TB, rows int) *gorm.DB {
+	tb.Helper()
+	path := ArtifactPath(tb, fmt.Sprintf("bench_%d.sqlite.db", rows))
+	template, err := benchTemplate(tb, rows)
+	if err == nil {
+		if template != "" {
+			err = copyFile(template, path)
+		} else {
+			err = seedBenchFile(tb, path, rows)
+		}
+	}
+	if err != nil {
+		tb.Fatalf("build bench database with %d rows: %v", rows, err)
+	}
+
+	db, err := openBenchDB(path)
+	if err != nil {
+		tb.Fatalf("open bench database: %v", err)
+	}
+	useTestPlugins(tb, db)
+	AddCleanup(tb, CleanupClose, func() { closeDB(db) })
+
+	if b, ok := tb.(*testing.B); ok {
+		b.ResetTimer()
+	}
+	return db
+}
+
+// benchTemplate returns the path of the seeded template for rows, building it if
+// needed, or "" when templates are not cached
+func benchTemplate(tb testing.TB, rows int) (string, error) {
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	if benchSeed == nil {
+		return "", fmt.Errorf("no bench seed registered, call testutil.RegisterBenchSeed")
+	}
+	if path, ok := benchTemplates[rows]; ok {
+		return path, nil
+	}
+	dir, prefix, ok := benchCacheDir()
+	if !ok {
+		return "", nil
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%d.sqlite.db", prefix, rows))
+	if _, err := os.Stat(path); err != nil {
+		// Seed into a temporary file and rename it, so an interrupted run never
+		// leaves a half-seeded template behind for the next one
+		tmp, err := os.CreateTemp(dir, prefix+"*.tmp")
+		if err != nil {
+			return "", err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := seedBenchFile(tb, tmp.Name(), rows); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return "", err
+		}
+	}
+	benchTemplates[rows] = path
+	return path, nil
+}
+
+// seedBenchFile runs the seed against the SQLite file at path and closes it
+func seedBenchFile(tb testing.TB, path string, rows int) error {
+	db, err := openBenchDB(path)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+	// Seeds may write models with encrypted fields
+	setupEncryptionKey(tb)
+	return benchSeed(db, rows)
+}
+
+// openBenchDB opens a bench database without query logging, which would
+// dominate the measured time
+func openBenchDB(path string) (*gorm.DB, error) {
+	config := sqliteConfig()
+	config.Logger = logger.Default.LogMode(logger.Silent)
+	return gorm.Open(sqlite.Open(path), config)
+}
+
+// benchCacheDir returns the directory templates are cached in and the file name
+// prefix of this test binary, removing stale templates of earlier builds;
+// ok is false when TEST_BENCH_CACHE is false or the binary cannot be hashed
+func benchCacheDir() (dir, prefix string, ok bool) {
+	if !benchCacheEnabled() {
+		return "", "", false
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", "", false
+	}
+	hash, err := fileHash(exe)
+	if err != nil {
+		return "", "", false
+	}
+	dir = filepath.Join(os.TempDir(), "gotest_benchdb")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", false
+	}
+
+	binary := strings.TrimSuffix(filepath.Base(exe), ".exe") + "_"
+	prefix = binary + hash + "_"
+	stale, _ := filepath.Glob(filepath.Join(dir, binary+"*"))
+	for _, path := range stale {
+		if !strings.HasPrefix(filepath.Base(path), prefix) {
+			_ = os.Remove(path)
+		}
+	}
+	return dir, prefix, true
+}
+
+// benchCacheEnabled reports whether TEST_BENCH_CACHE is not set to false
+func benchCacheEnabled() bool {
+	loadEnv()
+	switch strings.ToLower(os.Getenv("TEST_BENCH_CACHE")) {
+	case "false", "0", "no":
+		return false
+	}
+	return true
+}
+
+// fileHash returns a short SHA-256 of the file at path
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// copyFile copies the file at src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,94 @@
+package testutil
+
+import (
+	"sync"
+	"testing"
+)
+
+// CleanupPhase orders the cleanups registered with AddCleanup
+// t.Cleanup alone runs functions in reverse registration order, which breaks as
+// soon as a helper registers a cleanup that has to run after one registered later,
+// e.g. removing a SQLite file only after the connection using it is closed
+type CleanupPhase int
+
+const (
+	// CleanupReport runs first, while the database is still open: logging
+	// reports, dumping tables for a failed test
+	CleanupReport CleanupPhase = iota
+	// CleanupClose closes the connections used by the test
+	CleanupClose
+	// CleanupDrop drops databases and schemas created for the test, after
+	// the connections using them are closed
+	CleanupDrop
+	// CleanupRemove removes files and directories, e.g. ArtifactDir, last
+	CleanupRemove
+
+	cleanupPhases
+)
+
+// cleanups holds the functions registered for one test, by phase
+type cleanups struct {
+	mu     sync.Mutex
+	phases [cleanupPhases][]func()
+}
+
+var (
+	cleanupsMu sync.Mutex
+	cleanupsOf = map[testing.TB]*cleanups{}
+)
+
+// AddCleanup registers fn to run when t finishes, in the given phase
+// Phases run in order; within a phase functions run in reverse registration order,
+// like t.Cleanup. All phases run after cleanups t.Cleanup registered later than
+// the first AddCleanup of the test (and before those registered earlier)
+func AddCleanup(t testing.TB, phase CleanupPhase, fn func()) {
+	t.Helper()
+	if phase < 0 || phase >= cleanupPhases {
+		t.Fatalf("invalid cleanup phase %d", phase)
+	}
+
+	cleanupsMu.Lock()
+	c, ok := cleanupsOf[t]
+	if !ok {
+		c = &cleanups{}
+		cleanupsOf[t] = c
+	}
+	cleanupsMu.Unlock()
+	if !ok {
+		t.Cleanup(func() {
+			cleanupsMu.Lock()
+			delete(cleanupsOf, t)
+			cleanupsMu.Unlock()
+			c.run()
+		})
+	}
+
+	c.mu.Lock()
+	c.phases[phase] = append(c.phases[phase], fn)
+	c.mu.Unlock()
+}
+
+// run calls the registered functions phase by phase; a panicking function does
+// not stop the remaining ones, the panic is raised again once all have run
+func (c *cleanups) run() {
+	var recovered interface{}
+	for phase := range c.phases {
+		c.mu.Lock()
+		fns := c.phases[phase]
+		c.phases[phase] = nil
+		c.mu.Unlock()
+		for i := len(fns) - 1; i >= 0; i-- {
+			func() {
+				defer func() {
+					if r := recover(); r != nil && recovered == nil {
+						recovered = r
+					}
+				}()
+				fns[i]()
+			}()
+		}
+	}
+	if recovered != nil {
+		panic(recovered)
+	}
+}
@@ -0,0 +1,156 @@
+package testutil
+
+import (
+	"context"
+	"gohomeworklesson02/dbutil"
+	"sync"
+	"time"
+)
+
+// FakeClock is a dbutil.Clock whose time only moves when the test calls Advance or Set
+// Timers created by After and tickers created by NewTicker fire while the clock is
+// advanced past their deadline, so "30 days ago" or "every minute" logic can be
+// tested without sleeping
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{} // closed and replaced whenever waiters changes, see BlockUntil
+}
+
+// fakeWaiter is a pending After timer (period 0) or a ticker
+type fakeWaiter struct {
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start, changed: make(chan struct{})}
+}
+
+// Context returns ctx carrying the clock, see dbutil.WithClock
+func (c *FakeClock) Context(ctx context.Context) context.Context {
+	return dbutil.WithClock(ctx, c)
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock reaches now+d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{deadline: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch
+	}
+	c.addWaiter(w)
+	return w.ch
+}
+
+// NewTicker returns a ticker that fires every d of fake time
+// Like time.Ticker it drops ticks when the receiver falls behind
+func (c *FakeClock) NewTicker(d time.Duration) dbutil.Ticker {
+	if d <= 0 {
+		panic("testutil: non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{deadline: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.addWaiter(w)
+	return &fakeTicker{clock: c, w: w}
+}
+
+// Advance moves the clock forward by d and fires the timers and tickers that are due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(c.now.Add(d))
+}
+
+// Set moves the clock to t, which must not be earlier than the current fake time
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.now) {
+		panic("testutil: FakeClock cannot go backwards")
+	}
+	c.setLocked(t)
+}
+
+// BlockUntil waits until n timers and tickers are pending, so the test can be sure
+// the code under test is waiting on the clock before calling Advance
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (c *FakeClock) setLocked(t time.Time) {
+	c.now = t
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(t) {
+			remaining = append(remaining, w)
+			continue
+		}
+		select {
+		case w.ch <- t:
+		default:
+		}
+		if w.period > 0 {
+			// Skip the ticks that were missed while advancing, as time.Ticker does
+			for !w.deadline.After(t) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+			remaining = append(remaining, w)
+		}
+	}
+	c.waiters = remaining
+	c.notifyLocked()
+}
+
+func (c *FakeClock) addWaiter(w *fakeWaiter) {
+	c.waiters = append(c.waiters, w)
+	c.notifyLocked()
+}
+
+func (c *FakeClock) removeWaiter(w *fakeWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.notifyLocked()
+			return
+		}
+	}
+}
+
+func (c *FakeClock) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// fakeTicker is the dbutil.Ticker returned by FakeClock.NewTicker
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t *fakeTicker) Stop() { t.clock.removeWaiter(t.w) }
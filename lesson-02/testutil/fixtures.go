@@ -0,0 +1,233 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	fixtureModelsMu sync.Mutex
+	fixtureModels   []interface{}
+)
+
+// fixtureRef matches a reference to another fixture: $<table>.<label>
+var fixtureRef = regexp.MustCompile(`^\$([A-Za-z0-9_]+)\.([A-Za-z0-9_-]+)$`)
+
+// RegisterFixtureModels makes models available to LoadFixtures, which looks them up
+// by table name. Call it from an init function in the test package, e.g.
+//
+//	func init() { testutil.RegisterFixtureModels(&User{}, &Order{}) }
+func RegisterFixtureModels(models ...interface{}) {
+	fixtureModelsMu.Lock()
+	defer fixtureModelsMu.Unlock()
+	fixtureModels = append(fixtureModels, models...)
+}
+
+// Fixtures holds the records inserted by LoadFixtures, by table and label
+type Fixtures struct {
+	t       *testing.T
+	records map[string]map[string]reflect.Value // pointers to the inserted models
+	schemas map[string]*schema.Schema
+}
+
+// LoadFixtures inserts the records declared in the given YAML or JSON files and
+// returns them by label. Each file maps table names to labelled records whose keys
+// are column names:
+//
+//	users:
+//	  alice:
+//	    name: Alice
+//	    email: alice@example.com
+//	orders:
+//	  first:
+//	    order_no: ORD-0001
+//	    user_id: $users.alice
+//
+// A string value $<table>.<label> is replaced by the primary key of that fixture,
+// which must appear earlier (in the same file or in an earlier path); use $$ to
+// write a literal leading $. Tables and records are inserted in file order through
+// the registered models (see RegisterFixtureModels), so hooks and serializers run
+// as usual. Leave primary keys out so the database assigns them.
+// Paths are relative to the test's package directory, e.g. testdata/fixtures/users.yaml
+func LoadFixtures(t *testing.T, db *gorm.DB, paths ...string) *Fixtures {
+	t.Helper()
+
+	models, err := fixtureSchemas(db)
+	if err != nil {
+		t.Fatalf("load fixtures: %v", err)
+	}
+	f := &Fixtures{t: t, records: make(map[string]map[string]reflect.Value), schemas: models}
+	for _, path := range paths {
+		if err := f.load(db, path); err != nil {
+			t.Fatalf("load fixtures %s: %v", path, err)
+		}
+	}
+	return f
+}
+
+// fixtureSchemas parses the registered models and indexes them by table name
+func fixtureSchemas(db *gorm.DB) (map[string]*schema.Schema, error) {
+	fixtureModelsMu.Lock()
+	defer fixtureModelsMu.Unlock()
+
+	schemas := make(map[string]*schema.Schema, len(fixtureModels))
+	for _, model := range fixtureModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("parse model %T: %w", model, err)
+		}
+		if other, ok := schemas[stmt.Schema.Table]; ok && other.ModelType != stmt.Schema.ModelType {
+			return nil, fmt.Errorf("models %s and %s both use table %s", other.Name, stmt.Schema.Name, stmt.Schema.Table)
+		}
+		schemas[stmt.Schema.Table] = stmt.Schema
+	}
+	return schemas, nil
+}
+
+// load parses one fixture file and inserts its records in order
+func (f *Fixtures) load(db *gorm.DB, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return fmt.Errorf("unsupported file type, expected .yaml, .yml or .json")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is valid YAML, so one parser handles both; decoding into nodes keeps
+	// the order of tables and records
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	tables := doc.Content[0]
+	if tables.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of table names", tables.Line)
+	}
+
+	for i := 0; i < len(tables.Content); i += 2 {
+		table, records := tables.Content[i].Value, tables.Content[i+1]
+		sch, ok := f.schemas[table]
+		if !ok {
+			return fmt.Errorf("line %d: no model registered for table %s", tables.Content[i].Line, table)
+		}
+		if records.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: expected a mapping of labels to records", records.Line)
+		}
+		for j := 0; j < len(records.Content); j += 2 {
+			label, node := records.Content[j].Value, records.Content[j+1]
+			if err := f.insert(db, sch, label, node); err != nil {
+				return fmt.Errorf("%s.%s: %w", table, label, err)
+			}
+		}
+	}
+	return nil
+}
+
+// insert builds a model from the record node and creates it
+func (f *Fixtures) insert(db *gorm.DB, sch *schema.Schema, label string, node *yaml.Node) error {
+	if _, ok := f.records[sch.Table][label]; ok {
+		return fmt.Errorf("duplicate label")
+	}
+	var row map[string]interface{}
+	if err := node.Decode(&row); err != nil {
+		return err
+	}
+
+	record := reflect.New(sch.ModelType)
+	for column, value := range row {
+		field := sch.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("%s has no field %s", sch.Name, column)
+		}
+		value, err := f.resolve(value)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", column, err)
+		}
+		if err := field.Set(db.Statement.Context, record.Elem(), value); err != nil {
+			return fmt.Errorf("field %s: %w", column, err)
+		}
+	}
+	if err := db.Create(record.Interface()).Error; err != nil {
+		return err
+	}
+
+	if f.records[sch.Table] == nil {
+		f.records[sch.Table] = make(map[string]reflect.Value)
+	}
+	f.records[sch.Table][label] = record
+	return nil
+}
+
+// resolve replaces a $<table>.<label> reference by the primary key of that fixture
+func (f *Fixtures) resolve(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, "$") {
+		return value, nil
+	}
+	if strings.HasPrefix(s, "$$") {
+		return s[1:], nil
+	}
+	m := fixtureRef.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid reference %q, expected $<table>.<label>", s)
+	}
+	record, ok := f.records[m[1]][m[2]]
+	if !ok {
+		return nil, fmt.Errorf("unknown fixture %s.%s, it must be declared before it is referenced", m[1], m[2])
+	}
+	pk := f.schemas[m[1]].PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("%s has no primary key", f.schemas[m[1]].Name)
+	}
+	id, _ := pk.ValueOf(context.Background(), record.Elem())
+	return id, nil
+}
+
+// Record returns a pointer to the inserted model, e.g. fx.Record("users", "alice").(*User)
+func (f *Fixtures) Record(table, label string) interface{} {
+	f.t.Helper()
+	record, ok := f.records[table][label]
+	if !ok {
+		f.t.Fatalf("unknown fixture %s.%s", table, label)
+	}
+	return record.Interface()
+}
+
+// ID returns the primary key of the inserted record as a uint
+func (f *Fixtures) ID(table, label string) uint {
+	f.t.Helper()
+	record, ok := f.records[table][label]
+	if !ok {
+		f.t.Fatalf("unknown fixture %s.%s", table, label)
+	}
+	pk := f.schemas[table].PrioritizedPrimaryField
+	if pk == nil {
+		f.t.Fatalf("%s has no primary key", f.schemas[table].Name)
+	}
+	value := reflect.Indirect(pk.ReflectValueOf(context.Background(), record.Elem()))
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return uint(value.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint(value.Int())
+	}
+	f.t.Fatalf("primary key of %s is %s, not an integer", f.schemas[table].Name, value.Type())
+	return 0
+}
@@ -0,0 +1,186 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goldenDir is where AssertGoldenJSON files are stored, relative to the test's package directory
+const goldenDir = "testdata/golden"
+
+// GoldenNormalizer rewrites a value before it is written to a golden file
+// path is the location of the value, e.g. "items.0.user.id" (array elements
+// are numbered); returning v unchanged keeps it
+type GoldenNormalizer func(path string, v interface{}) interface{}
+
+// GoldenOption configures AssertGoldenJSON
+type GoldenOption func(*goldenConfig)
+
+type goldenConfig struct {
+	normalizers []GoldenNormalizer
+	ids         map[string]string // numeric ID -> placeholder, for NormalizeIDs
+}
+
+// IgnoreFields replaces the values of object keys with the given names, at any
+// depth, by "<ignored>", e.g. IgnoreFields("password_hash") for values that
+// differ on every run
+func IgnoreFields(names ...string) GoldenOption {
+	ignored := make(map[string]bool, len(names))
+	for _, name := range names {
+		ignored[name] = true
+	}
+	return NormalizeWith(func(path string, v interface{}) interface{} {
+		if ignored[lastKey(path)] {
+			return "<ignored>"
+		}
+		return v
+	})
+}
+
+// NormalizeTimestamps replaces RFC 3339 strings, as encoding/json writes time.Time,
+// by "<time>"; zero times are kept, so unset times stay visible
+func NormalizeTimestamps() GoldenOption {
+	return NormalizeWith(func(path string, v interface{}) interface{} {
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, s); err == nil && !ts.IsZero() {
+			return "<time>"
+		}
+		return v
+	})
+}
+
+// NormalizeIDs replaces the numbers under "id" and "<name>_id" keys by placeholders
+// numbered in order of first appearance, "<id1>", "<id2>"..., so golden files do
+// not depend on auto-increment values while equal IDs still match each other
+func NormalizeIDs() GoldenOption {
+	return func(c *goldenConfig) {
+		c.normalizers = append(c.normalizers, func(path string, v interface{}) interface{} {
+			key := lastKey(path)
+			if key != "id" && !strings.HasSuffix(key, "_id") {
+				return v
+			}
+			n, ok := v.(json.Number)
+			if !ok {
+				return v
+			}
+			placeholder, ok := c.ids[n.String()]
+			if !ok {
+				placeholder = "<id" + strconv.Itoa(len(c.ids)+1) + ">"
+				c.ids[n.String()] = placeholder
+			}
+			return placeholder
+		})
+	}
+}
+
+// NormalizeWith adds a custom normalizer, applied to every value after the
+// ones added before it
+func NormalizeWith(fn GoldenNormalizer) GoldenOption {
+	return func(c *goldenConfig) {
+		c.normalizers = append(c.normalizers, fn)
+	}
+}
+
+// AssertGoldenJSON encodes value as indented JSON and compares it with the golden
+// file testdata/golden/<name>.json, like SnapshotTable: a missing file is written
+// and fails the test, -update (or UPDATE_SNAPSHOTS=1) accepts changed output.
+// value may be anything encoding/json accepts; []byte and json.RawMessage are
+// taken as JSON text, e.g. a response body. Object keys are written sorted.
+// Options normalize values that change between runs:
+//
+//	testutil.AssertGoldenJSON(t, "feed", page, testutil.NormalizeIDs(), testutil.NormalizeTimestamps())
+func AssertGoldenJSON(t *testing.T, name string, value interface{}, opts ...GoldenOption) {
+	t.Helper()
+	got, err := goldenJSON(value, opts)
+	if err != nil {
+		t.Fatalf("golden %s: %v", name, err)
+	}
+	compareGolden(t, filepath.Join(goldenDir, unsafeFileChars.Replace(name)+".json"), got)
+}
+
+// goldenJSON encodes value as normalized, indented JSON ending with a newline
+func goldenJSON(value interface{}, opts []GoldenOption) ([]byte, error) {
+	var data []byte
+	switch v := value.(type) {
+	case json.RawMessage:
+		data = v
+	case []byte:
+		data = v
+	default:
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	// Decode into generic values, keeping numbers as written
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("decode JSON: %w", err)
+	}
+
+	c := &goldenConfig{ids: map[string]string{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	normalized := c.normalize("", decoded)
+
+	// Keep placeholders such as <time> readable instead of escaping them as \u003c
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(normalized); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// normalize applies the normalizers depth-first; object keys are visited in
+// sorted order, so NormalizeIDs numbers placeholders the same way on every run
+func (c *goldenConfig) normalize(path string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			v[key] = c.normalize(joinPath(path, key), v[key])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = c.normalize(joinPath(path, strconv.Itoa(i)), v[i])
+		}
+	}
+	for _, fn := range c.normalizers {
+		v = fn(path, v)
+	}
+	return v
+}
+
+// joinPath appends key to a dotted path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// lastKey returns the last element of a dotted path
+func lastKey(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
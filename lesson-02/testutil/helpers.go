@@ -1,6 +1,7 @@
 package testutil
 
 import (
+	"gohomeworklesson02/dbutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -27,9 +28,10 @@ var (
 type DBType string
 
 const (
-	DBTypeSQLite   DBType = "sqlite"
-	DBTypeMySQL    DBType = "mysql"
-	DBTypePostgres DBType = "postgres"
+	DBTypeSQLite       DBType = "sqlite"
+	DBTypeSQLiteMemory DBType = "sqlite_memory"
+	DBTypeMySQL        DBType = "mysql"
+	DBTypePostgres     DBType = "postgres"
 )
 
 // loadEnv loads environment variables from .env file in the examples directory
@@ -66,6 +68,8 @@ func getDBType() DBType {
 	loadEnv()
 	dbType := os.Getenv("TEST_DB_TYPE")
 	switch dbType {
+	case "sqlite_memory", "memory":
+		return DBTypeSQLiteMemory
 	case "mysql":
 		return DBTypeMySQL
 	case "postgres", "postgresql":
@@ -75,34 +79,21 @@ func getDBType() DBType {
 	}
 }
 
-// getDBDir returns the db directory path where SQLite files should be stored
-// This function locates the examples/db directory relative to the examples directory
-func getDBDir() (string, error) {
-	// Get the directory where this file (helpers.go) is located
-	// This file is in examples/testutil directory
-	_, currentFile, _, ok := runtime.Caller(0)
-	if !ok {
-		return "", os.ErrInvalid
-	}
-
-	// Get the examples directory (parent of testutil)
-	testutilDir := filepath.Dir(currentFile)
-	examplesDir := filepath.Dir(testutilDir)
-
-	// The db directory is examples/db
-	dbDir := filepath.Join(examplesDir, "db")
-
-	// Ensure db directory exists
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return "", err
-	}
-
-	return dbDir, nil
-}
-
 // NewTestDB creates a test database connection
-// For SQLite: files are stored in the db directory (examples/db) with "sqlite" in the filename
-// For MySQL/PostgreSQL: uses connection strings from environment variables
+// For SQLite: the file is stored in the test's ArtifactDir with "sqlite" in the filename,
+// so every test starts from an empty database that is removed afterwards (kept for
+// inspection when the test fails and TEST_KEEP_ARTIFACTS is set)
+// For in-memory SQLite (TEST_DB_TYPE=sqlite_memory): each test gets its own named
+// in-memory database, so tests can call t.Parallel(); filename is ignored and the
+// database is gone once the connection is closed at the end of the test
+// For MySQL/PostgreSQL: uses connection strings from environment variables; each test
+// gets its own database (MySQL) or schema (PostgreSQL) that is dropped when the test
+// finishes, unless TEST_DB_ISOLATE=false. Run the suite against a real dialect with e.g.
+//
+//	TEST_DB_TYPE=postgres TEST_POSTGRES_DSN="host=localhost user=postgres ..." go test ./...
+//
+// The server has to be started separately (e.g. docker run postgres); testcontainers
+// is not a dependency of this module
 func NewTestDB(t *testing.T, filename string) *gorm.DB {
 	t.Helper()
 
@@ -113,6 +104,8 @@ func NewTestDB(t *testing.T, filename string) *gorm.DB {
 	switch dbType {
 	case DBTypeSQLite:
 		db, err = newSQLiteDB(t, filename)
+	case DBTypeSQLiteMemory:
+		db, err = newSQLiteMemoryDB(t)
 	case DBTypeMySQL:
 		db, err = newMySQLDB(t)
 	case DBTypePostgres:
@@ -125,6 +118,20 @@ func NewTestDB(t *testing.T, filename string) *gorm.DB {
 		t.Fatalf("open database: %v", err)
 	}
 
+	useTestPlugins(t, db)
+
+	// Record queries slower than TEST_SLOW_QUERY_THRESHOLD and report the
+	// worst ones when the test finishes
+	slowQueries := dbutil.NewSlowQueryPlugin(slowQueryThreshold(t))
+	if err := db.Use(slowQueries); err != nil {
+		t.Fatalf("register slow query plugin: %v", err)
+	}
+	AddCleanup(t, CleanupReport, func() {
+		for _, q := range slowQueries.Worst() {
+			t.Logf("slow query %s [rows:%d] %s", q.Duration, q.Rows, q.SQL)
+		}
+	})
+
 	// Get the underlying *sql.DB to configure connection pool settings
 	// Connection pool settings are configured on the underlying database connection,
 	// not in gorm.Config, because they are database-specific settings
@@ -143,23 +150,82 @@ func NewTestDB(t *testing.T, filename string) *gorm.DB {
 	sqlDB.SetMaxIdleConns(2)                   // Keep 2 idle connections ready
 	sqlDB.SetMaxOpenConns(5)                   // Allow up to 5 concurrent connections
 	sqlDB.SetConnMaxLifetime(30 * time.Minute) // Reuse connections for up to 30 minutes
+	if dbType == DBTypeSQLiteMemory {
+		// An in-memory database is dropped when its last connection closes,
+		// so idle connections must never be recycled during the test
+		sqlDB.SetConnMaxLifetime(0)
+	}
 
-	t.Cleanup(func() {
+	AddCleanup(t, CleanupClose, func() {
 		_ = sqlDB.Close()
 	})
 
 	return db
 }
 
-// newSQLiteDB creates a SQLite database connection
-// The database file is stored in the db directory (examples/db) with "sqlite" in the filename
-func newSQLiteDB(t *testing.T, filename string) (*gorm.DB, error) {
-	// Get the db directory where SQLite files should be stored
-	dbDir, err := getDBDir()
+// useTestPlugins registers the plugins the models of this module rely on
+func useTestPlugins(t testing.TB, db *gorm.DB) {
+	t.Helper()
+
+	// Register the audit plugin so models embedding dbutil.AuditFields get
+	// CreatedBy/UpdatedBy filled from the actor stored in the context
+	if err := db.Use(dbutil.AuditPlugin{}); err != nil {
+		t.Fatalf("register audit plugin: %v", err)
+	}
+
+	// Register the tenant plugin so models embedding dbutil.TenantFields are
+	// filtered by the tenant stored in the context; without a tenant they fail
+	// with dbutil.ErrNoTenant unless the context comes from dbutil.WithAllTenants
+	if err := db.Use(dbutil.TenantPlugin{}); err != nil {
+		t.Fatalf("register tenant plugin: %v", err)
+	}
+
+	// Models with dbutil.EncryptedString fields need an encryption key
+	setupEncryptionKey(t)
+}
+
+// testEncryptionKey is used when DB_ENCRYPTION_KEY is not set
+// It is only meant for tests: data written with it is not protected
+var testEncryptionKey = []byte("gohomework-lesson02-test-key-32b")
+
+// setupEncryptionKey loads the field encryption key from DB_ENCRYPTION_KEY
+// (environment or .env file), falling back to a fixed test key
+func setupEncryptionKey(t testing.TB) {
+	t.Helper()
+	loadEnv()
+	if os.Getenv(dbutil.EncryptionKeyEnv) != "" {
+		if err := dbutil.LoadEncryptionKey(); err != nil {
+			t.Fatalf("load encryption key: %v", err)
+		}
+		return
+	}
+	if err := dbutil.SetEncryptionKey(testEncryptionKey); err != nil {
+		t.Fatalf("set encryption key: %v", err)
+	}
+}
+
+// defaultSlowQueryThreshold is used when TEST_SLOW_QUERY_THRESHOLD is not set
+const defaultSlowQueryThreshold = 100 * time.Millisecond
+
+// slowQueryThreshold reads the slow query threshold from TEST_SLOW_QUERY_THRESHOLD
+// The value is a Go duration such as "50ms" or "1s"
+func slowQueryThreshold(t *testing.T) time.Duration {
+	t.Helper()
+	loadEnv()
+	value := os.Getenv("TEST_SLOW_QUERY_THRESHOLD")
+	if value == "" {
+		return defaultSlowQueryThreshold
+	}
+	threshold, err := time.ParseDuration(value)
 	if err != nil {
-		return nil, err
+		t.Fatalf("parse TEST_SLOW_QUERY_THRESHOLD: %v", err)
 	}
+	return threshold
+}
 
+// newSQLiteDB creates a SQLite database connection
+// The database file is stored in the test's ArtifactDir with "sqlite" in the filename
+func newSQLiteDB(t *testing.T, filename string) (*gorm.DB, error) {
 	// Ensure filename contains "sqlite"
 	if filename == "" {
 		filename = "test.sqlite.db"
@@ -182,9 +248,21 @@ func newSQLiteDB(t *testing.T, filename string) (*gorm.DB, error) {
 		}
 	}
 
-	// Database file will be stored in db directory (examples/db)
-	dbPath := filepath.Join(dbDir, filename)
+	dbPath := filepath.Join(ArtifactDir(t), filename)
 
+	return gorm.Open(sqlite.Open(dbPath), sqliteConfig())
+}
+
+// newSQLiteMemoryDB creates a shared-cache in-memory SQLite database named after the test
+// Every pooled connection opening the same name sees the same database, while the
+// random suffix of the name keeps parallel tests apart
+func newSQLiteMemoryDB(t *testing.T) (*gorm.DB, error) {
+	dsn := "file:" + isolatedName(t) + "?mode=memory&cache=shared"
+	return gorm.Open(sqlite.Open(dsn), sqliteConfig())
+}
+
+// sqliteConfig returns the GORM configuration used for SQLite test databases
+func sqliteConfig() *gorm.Config {
 	// Configure GORM with:
 	// 1. Logger: Control SQL logging level
 	//    - Silent: No logs
@@ -196,7 +274,7 @@ func newSQLiteDB(t *testing.T, filename string) (*gorm.DB, error) {
 	//    - ColumnName: How field names map to column names
 	//    - JoinTableName: How join table names are generated
 	//    - SchemaName: Schema name for databases that support it
-	return gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	return &gorm.Config{
 		// Logger configuration
 		Logger: logger.Default.LogMode(logger.Info), // Silent for tests, use logger.Info for development
 
@@ -207,12 +285,13 @@ func newSQLiteDB(t *testing.T, filename string) (*gorm.DB, error) {
 			NoLowerCase:   false, // Disable automatic lowercasing
 			NameReplacer:  nil,   // Custom name replacer function
 		},
-	})
+	}
 }
 
 // newMySQLDB creates a MySQL database connection
 // Connection string is read from TEST_MYSQL_DSN environment variable or .env file
 // Format: user:password@tcp(localhost:3306)/testdb?charset=utf8mb4&parseTime=True&loc=Local
+// The test runs in its own database, see openIsolatedMySQL
 func newMySQLDB(t *testing.T) (*gorm.DB, error) {
 	loadEnv()
 	dsn := os.Getenv("TEST_MYSQL_DSN")
@@ -221,7 +300,7 @@ func newMySQLDB(t *testing.T) (*gorm.DB, error) {
 		t.Logf("using default MySQL DSN, set TEST_MYSQL_DSN in .env file or environment variable to override")
 	}
 
-	return gorm.Open(mysql.Open(dsn), &gorm.Config{
+	config := &gorm.Config{
 		// Logger: Set to logger.Info to see all SQL queries in development
 		Logger: logger.Default.LogMode(logger.Silent),
 
@@ -231,12 +310,17 @@ func newMySQLDB(t *testing.T) (*gorm.DB, error) {
 			SingularTable: false,
 			NoLowerCase:   false,
 		},
-	})
+	}
+	if isolationDisabled() {
+		return gorm.Open(mysql.Open(dsn), config)
+	}
+	return openIsolatedMySQL(t, dsn, config)
 }
 
 // newPostgresDB creates a PostgreSQL database connection
 // Connection string is read from TEST_POSTGRES_DSN environment variable or .env file
 // Format: host=localhost user=postgres password=password dbname=testdb port=5432 sslmode=disable TimeZone=Asia/Shanghai
+// The test runs in its own schema, see openIsolatedPostgres
 func newPostgresDB(t *testing.T) (*gorm.DB, error) {
 	loadEnv()
 	dsn := os.Getenv("TEST_POSTGRES_DSN")
@@ -245,7 +329,7 @@ func newPostgresDB(t *testing.T) (*gorm.DB, error) {
 		t.Logf("using default PostgreSQL DSN, set TEST_POSTGRES_DSN in .env file or environment variable to override")
 	}
 
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{
+	config := &gorm.Config{
 		// Logger: Set to logger.Info to see all SQL queries in development
 		Logger: logger.Default.LogMode(logger.Silent),
 
@@ -255,5 +339,9 @@ func newPostgresDB(t *testing.T) (*gorm.DB, error) {
 			SingularTable: false,
 			NoLowerCase:   false,
 		},
-	})
+	}
+	if isolationDisabled() {
+		return gorm.Open(postgres.Open(dsn), config)
+	}
+	return openIsolatedPostgres(t, dsn, config)
 }
@@ -0,0 +1,136 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// maxIsolatedNameLen keeps generated names within the identifier limits of
+// both MySQL (64) and PostgreSQL (63)
+const maxIsolatedNameLen = 63
+
+// unsafeNameChars matches characters that are not allowed in generated names
+var unsafeNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// isolatedName returns a unique database/schema name for the test
+// The name is derived from the test name so leftovers of a crashed run are easy
+// to identify, and ends with a random suffix so parallel tests never collide
+func isolatedName(t testing.TB) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("generate database name: %v", err)
+	}
+	base := unsafeNameChars.ReplaceAllString(strings.ToLower(t.Name()), "_")
+	base = strings.Trim(base, "_")
+	maxBase := maxIsolatedNameLen - len("gotest__") - hex.EncodedLen(len(suffix))
+	if len(base) > maxBase {
+		base = base[:maxBase]
+	}
+	return "gotest_" + base + "_" + hex.EncodeToString(suffix)
+}
+
+// isolationDisabled reports whether TEST_DB_ISOLATE is set to false
+// Isolation needs CREATE DATABASE (MySQL) or CREATE SCHEMA (PostgreSQL)
+// privileges; without them tests can share the database named in the DSN instead
+func isolationDisabled() bool {
+	loadEnv()
+	switch strings.ToLower(os.Getenv("TEST_DB_ISOLATE")) {
+	case "false", "0", "no":
+		return true
+	}
+	return false
+}
+
+// openIsolatedMySQL creates a database for the test on the server in dsn and
+// returns a connection to it; the database is dropped in the CleanupDrop phase
+// The database named in dsn (if any) is only used for the admin connection
+func openIsolatedMySQL(t *testing.T, dsn string, config *gorm.Config) (*gorm.DB, error) {
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse TEST_MYSQL_DSN: %w", err)
+	}
+	admin, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+
+	name := isolatedName(t)
+	if err := admin.Exec("CREATE DATABASE `" + name + "` CHARACTER SET utf8mb4").Error; err != nil {
+		closeDB(admin)
+		return nil, fmt.Errorf("create database %s: %w", name, err)
+	}
+	AddCleanup(t, CleanupDrop, func() {
+		defer closeDB(admin)
+		if err := admin.Exec("DROP DATABASE IF EXISTS `" + name + "`").Error; err != nil {
+			t.Errorf("drop database %s: %v", name, err)
+		}
+	})
+
+	cfg.DBName = name
+	return gorm.Open(mysql.Open(cfg.FormatDSN()), config)
+}
+
+// openIsolatedPostgres creates a schema for the test in the database in dsn and
+// returns a connection whose search_path is that schema, so unqualified table
+// names resolve to it on every pooled connection; the schema is dropped in the
+// CleanupDrop phase
+func openIsolatedPostgres(t *testing.T, dsn string, config *gorm.Config) (*gorm.DB, error) {
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+
+	name := isolatedName(t)
+	if err := admin.Exec(`CREATE SCHEMA "` + name + `"`).Error; err != nil {
+		closeDB(admin)
+		return nil, fmt.Errorf("create schema %s: %w", name, err)
+	}
+	AddCleanup(t, CleanupDrop, func() {
+		defer closeDB(admin)
+		if err := admin.Exec(`DROP SCHEMA IF EXISTS "` + name + `" CASCADE`).Error; err != nil {
+			t.Errorf("drop schema %s: %v", name, err)
+		}
+	})
+
+	schemaDSN, err := withSearchPath(dsn, name)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(postgres.Open(schemaDSN), config)
+}
+
+// withSearchPath adds search_path to a PostgreSQL DSN in either URL form
+// (postgres://user@host/db?sslmode=disable) or keyword/value form (host=... dbname=...)
+// pgx sends unknown parameters as run-time parameters when connecting
+func withSearchPath(dsn, schema string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("parse TEST_POSTGRES_DSN: %w", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+	return strings.TrimSpace(dsn) + " search_path=" + schema, nil
+}
+
+// closeDB closes the connection pool behind db, ignoring errors
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ExecutedQuery is a statement recorded by QueryRecorder
+type ExecutedQuery struct {
+	SQL      string        // SQL with the arguments substituted, as GORM logs it
+	Rows     int64         // rows returned or affected, -1 when unknown
+	Duration time.Duration // execution time
+	Err      error         // error returned by the statement, including gorm.ErrRecordNotFound
+}
+
+// queryLog is shared by a QueryRecorder and the copies returned by LogMode
+type queryLog struct {
+	mu      sync.Mutex
+	queries []ExecutedQuery
+}
+
+// QueryRecorder is a GORM logger that records every executed statement and passes
+// it on to the wrapped logger, so tests can assert how many queries code runs:
+//
+//	db, queries := testutil.RecordQueries(db)
+//	GetPostsWithCommentCount(db, 1, 20)
+//	testutil.AssertQueryCount(t, queries, 3)
+type QueryRecorder struct {
+	logger.Interface
+	log *queryLog
+}
+
+// RecordQueries returns a session of db that records its statements, and the recorder
+// Only statements run through the returned session (and sessions derived from it,
+// including transactions) are recorded
+func RecordQueries(db *gorm.DB) (*gorm.DB, *QueryRecorder) {
+	recorder := &QueryRecorder{Interface: db.Logger, log: &queryLog{}}
+	return db.Session(&gorm.Session{Logger: recorder}), recorder
+}
+
+// LogMode changes the level of the wrapped logger; the copy records into the same log
+func (r *QueryRecorder) LogMode(level logger.LogLevel) logger.Interface {
+	return &QueryRecorder{Interface: r.Interface.LogMode(level), log: r.log}
+}
+
+// Trace records the statement and forwards it to the wrapped logger
+func (r *QueryRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, rows := fc()
+	r.log.mu.Lock()
+	r.log.queries = append(r.log.queries, ExecutedQuery{SQL: sql, Rows: rows, Duration: time.Since(begin), Err: err})
+	r.log.mu.Unlock()
+	r.Interface.Trace(ctx, begin, func() (string, int64) { return sql, rows }, err)
+}
+
+// ExecutedQueries returns the statements recorded so far, in the order they finished;
+// Preload queries finish, and are listed, before the query that triggered them
+func (r *QueryRecorder) ExecutedQueries() []ExecutedQuery {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	return append([]ExecutedQuery(nil), r.log.queries...)
+}
+
+// Reset forgets the recorded statements, e.g. after preparing test data
+func (r *QueryRecorder) Reset() {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	r.log.queries = nil
+}
+
+// AssertQueryCount checks that exactly n statements were recorded and lists them otherwise
+func AssertQueryCount(t testing.TB, r *QueryRecorder, n int) {
+	t.Helper()
+	queries := r.ExecutedQueries()
+	if len(queries) == n {
+		return
+	}
+	var b strings.Builder
+	for i, q := range queries {
+		fmt.Fprintf(&b, "\n  %d. %s", i+1, q.SQL)
+	}
+	t.Errorf("expected %d queries, got %d:%s", n, len(queries), b.String())
+}
@@ -0,0 +1,189 @@
+package testutil
+
+import (
+	"gohomeworklesson02/migrations"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Schema is the expected database structure checked by AssertSchema
+type Schema struct {
+	// Tables that must exist, by name
+	Tables map[string]TableSchema
+	// Absent lists tables that must not exist, e.g. after rolling back the
+	// migration that created them
+	Absent []string
+	// Exact fails on tables that exist but are not in Tables; schema_migrations
+	// and SQLite's internal tables are never reported
+	Exact bool
+}
+
+// TableSchema is the expected structure of one table
+type TableSchema struct {
+	// Columns is the exact set of columns, in any order; nil skips the check
+	Columns []string
+	// Indexes is the exact set of index names, in any order; nil skips the check.
+	// Primary keys are not listed, and neither are the indexes SQLite creates
+	// for UNIQUE constraints (uniqueIndex tags create named indexes, which are)
+	Indexes []string
+}
+
+// RunMigrations applies migrations up to and including version upTo (0 applies
+// all of them) and returns the runner, so the test can roll back with Down or
+// continue with UpTo. Migrations that were already applied are skipped:
+//
+//	runner := testutil.RunMigrations(t, db, 2024010102, blogMigrations...)
+//	testutil.AssertSchema(t, db, testutil.Schema{...})
+func RunMigrations(t testing.TB, db *gorm.DB, upTo int64, ms ...migrations.Migration) *migrations.Runner {
+	t.Helper()
+	runner, err := migrations.NewRunner(db, ms...)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := runner.UpTo(upTo); err != nil {
+		t.Fatalf("migrate up to %d: %v", upTo, err)
+	}
+	return runner
+}
+
+// CurrentSchema reads the tables, columns and indexes of the database as an exact
+// Schema, to compare with later, e.g. that rolling back a migration restores the
+// structure from before it was applied:
+//
+//	before := testutil.CurrentSchema(t, db)
+//	runner := testutil.RunMigrations(t, db, m.Version, ms...)
+//	runner.Down(1)
+//	testutil.AssertSchema(t, db, before)
+func CurrentSchema(t testing.TB, db *gorm.DB) Schema {
+	t.Helper()
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	schema := Schema{Tables: map[string]TableSchema{}, Exact: true}
+	for _, table := range tables {
+		if ignoredTable(table) {
+			continue
+		}
+		schema.Tables[table] = TableSchema{
+			Columns: tableColumns(t, db, table),
+			Indexes: tableIndexes(t, db, table),
+		}
+	}
+	return schema
+}
+
+// AssertSchema checks that the database has the expected tables, columns and
+// indexes, reporting every difference
+func AssertSchema(t testing.TB, db *gorm.DB, expected Schema) {
+	t.Helper()
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	existing := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		existing[table] = true
+	}
+
+	names := make([]string, 0, len(expected.Tables))
+	for name := range expected.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !existing[name] {
+			t.Errorf("table %s does not exist", name)
+			continue
+		}
+		want := expected.Tables[name]
+		if want.Columns != nil {
+			compareSets(t, name+" columns", want.Columns, tableColumns(t, db, name))
+		}
+		if want.Indexes != nil {
+			compareSets(t, name+" indexes", want.Indexes, tableIndexes(t, db, name))
+		}
+	}
+
+	for _, name := range expected.Absent {
+		if existing[name] {
+			t.Errorf("table %s should not exist", name)
+		}
+	}
+	if expected.Exact {
+		for _, table := range tables {
+			if _, ok := expected.Tables[table]; !ok && !ignoredTable(table) {
+				t.Errorf("unexpected table %s", table)
+			}
+		}
+	}
+}
+
+// ignoredTable reports whether table is bookkeeping rather than part of the schema
+func ignoredTable(table string) bool {
+	return table == migrations.SchemaMigration{}.TableName() || strings.HasPrefix(table, "sqlite_")
+}
+
+// tableColumns returns the sorted column names of table
+func tableColumns(t testing.TB, db *gorm.DB, table string) []string {
+	t.Helper()
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		t.Fatalf("read columns of %s: %v", table, err)
+	}
+	columns := make([]string, len(columnTypes))
+	for i, column := range columnTypes {
+		columns[i] = column.Name()
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// tableIndexes returns the sorted names of the indexes of table, without the primary key
+func tableIndexes(t testing.TB, db *gorm.DB, table string) []string {
+	t.Helper()
+	indexes, err := db.Migrator().GetIndexes(table)
+	if err != nil {
+		t.Fatalf("read indexes of %s: %v", table, err)
+	}
+	names := []string{}
+	for _, index := range indexes {
+		if primary, ok := index.PrimaryKey(); ok && primary {
+			continue
+		}
+		names = append(names, index.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compareSets reports the names missing from got and the unexpected ones in it
+func compareSets(t testing.TB, what string, want, got []string) {
+	t.Helper()
+	gotSet := make(map[string]bool, len(got))
+	for _, name := range got {
+		gotSet[name] = true
+	}
+	wantSet := make(map[string]bool, len(want))
+	var missing, unexpected []string
+	for _, name := range want {
+		wantSet[name] = true
+		if !gotSet[name] {
+			missing = append(missing, name)
+		}
+	}
+	for _, name := range got {
+		if !wantSet[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(missing) > 0 {
+		t.Errorf("%s: missing %s", what, strings.Join(missing, ", "))
+	}
+	if len(unexpected) > 0 {
+		t.Errorf("%s: unexpected %s", what, strings.Join(unexpected, ", "))
+	}
+}
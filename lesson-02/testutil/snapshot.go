@@ -0,0 +1,185 @@
+package testutil
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// updateSnapshots rewrites golden files (SnapshotTable, AssertGoldenJSON) instead of
+// comparing against them:
+//
+//	go test ./basics -run TestUpsertUsers -update
+//
+// The flag only exists in test binaries that import testutil, so when running
+// several packages at once use UPDATE_SNAPSHOTS=1 instead
+var updateSnapshots = flag.Bool("update", false, "rewrite golden files in testdata/snapshots and testdata/golden")
+
+// snapshotDir is where golden snapshots are stored, relative to the test's package directory
+const snapshotDir = "testdata/snapshots"
+
+// unsafeFileChars matches characters that are replaced when a test name becomes a file name
+var unsafeFileChars = strings.NewReplacer("/", "_", `\`, "_", ":", "_", "*", "_", "?", "_",
+	`"`, "_", "<", "_", ">", "_", "|", "_", " ", "_")
+
+// SnapshotOptions selects what SnapshotTable dumps
+type SnapshotOptions struct {
+	// Columns to dump, in order; all columns when empty. Leave out columns whose
+	// values differ between runs, such as encrypted fields
+	Columns []string
+	// Where filters the rows, e.g. "deleted_at IS NULL"; all rows when empty
+	Where string
+	// OrderBy sorts the rows; "id" when empty
+	OrderBy string
+	// KeepTimestamps writes time values as RFC 3339 in UTC; by default non-NULL times
+	// are written as <time> so snapshots don't depend on when the test ran
+	KeepTimestamps bool
+	// Name of the snapshot file without extension; <test name>_<table> when empty
+	Name string
+}
+
+// SnapshotTable dumps the table and compares it with the golden snapshot
+// testdata/snapshots/<name>.snap, failing the test with the first differing line.
+// A missing snapshot is written and the test fails, so new snapshots get reviewed;
+// run with -update (or UPDATE_SNAPSHOTS=1) to accept changed output
+func SnapshotTable(t *testing.T, db *gorm.DB, table string, opts SnapshotOptions) {
+	t.Helper()
+
+	got, err := dumpTable(db, table, opts)
+	if err != nil {
+		t.Fatalf("snapshot %s: %v", table, err)
+	}
+	name := opts.Name
+	if name == "" {
+		name = unsafeFileChars.Replace(t.Name()) + "_" + table
+	}
+	compareGolden(t, filepath.Join(snapshotDir, name+".snap"), got)
+}
+
+// compareGolden compares got with the golden file at path, failing the test with
+// the first differing line; a missing file is written and fails the test, with
+// -update (or UPDATE_SNAPSHOTS=1) the file is rewritten
+func compareGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	want, err := os.ReadFile(path)
+	if err == nil && !shouldUpdateSnapshots() {
+		if !bytes.Equal(want, got) {
+			t.Errorf("golden file %s does not match, rerun with -update to accept\n%s", path, firstDiff(string(want), string(got)))
+		}
+		return
+	}
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read golden file: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("create golden dir: %v", err)
+	}
+	if err := os.WriteFile(path, got, 0644); err != nil {
+		t.Fatalf("write golden file: %v", err)
+	}
+	if !shouldUpdateSnapshots() {
+		t.Errorf("golden file %s did not exist and has been written, review and commit it", path)
+	}
+}
+
+// shouldUpdateSnapshots reports whether -update or UPDATE_SNAPSHOTS is set
+func shouldUpdateSnapshots() bool {
+	if *updateSnapshots {
+		return true
+	}
+	switch strings.ToLower(os.Getenv("UPDATE_SNAPSHOTS")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// dumpTable formats the selected rows as a header line and one line per row,
+// with values separated by " | "
+func dumpTable(db *gorm.DB, table string, opts SnapshotOptions) ([]byte, error) {
+	query := db.Table(table)
+	if len(opts.Columns) > 0 {
+		query = query.Select(opts.Columns)
+	}
+	if opts.Where != "" {
+		query = query.Where(opts.Where)
+	}
+	orderBy := opts.OrderBy
+	if orderBy == "" {
+		orderBy = "id"
+	}
+	rows, err := query.Order(orderBy).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(columns, " | ") + "\n")
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = formatSnapshotValue(v, opts.KeepTimestamps)
+		}
+		buf.WriteString(strings.Join(cells, " | ") + "\n")
+	}
+	return buf.Bytes(), rows.Err()
+}
+
+// formatSnapshotValue formats one cell; NULL, times and bytes are normalized so the
+// output is the same across drivers
+func formatSnapshotValue(v interface{}, keepTimestamps bool) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		if !keepTimestamps {
+			return "<time>"
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return strings.ReplaceAll(string(v), "\n", `\n`)
+	case string:
+		return strings.ReplaceAll(v, "\n", `\n`)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// firstDiff describes the first line where want and got differ
+func firstDiff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want: %s\n  got:  %s", i+1, w, g)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// savepointSeq numbers the savepoints created by NewTestTx
+var savepointSeq atomic.Uint64
+
+// NewTestTx returns a *gorm.DB bound to a transaction on db that is rolled back
+// in t.Cleanup, so subtests sharing a seeded database can write freely without
+// affecting each other and without recreating the database
+// If db is already a transaction (e.g. one returned by NewTestTx), a savepoint is
+// created instead and rolled back to on cleanup, so calls can be nested
+// The returned handle must not be shared by parallel tests; code under test may
+// still call Transaction on it, GORM uses savepoints for nested transactions
+func NewTestTx(t *testing.T, db *gorm.DB) *gorm.DB {
+	t.Helper()
+
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		name := fmt.Sprintf("testutil_sp%d", savepointSeq.Add(1))
+		if err := db.SavePoint(name).Error; err != nil {
+			t.Fatalf("create savepoint: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := db.RollbackTo(name).Error; err != nil {
+				t.Errorf("rollback to savepoint %s: %v", name, err)
+			}
+		})
+		return db
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("begin transaction: %v", tx.Error)
+	}
+	// Registered after NewTestDB's cleanup, so it runs before the connection is closed
+	t.Cleanup(func() {
+		if err := tx.Rollback().Error; err != nil {
+			t.Errorf("rollback transaction: %v", err)
+		}
+	})
+	return tx
+}